@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/cachemir/cachemir/pkg/client"
+	"github.com/cachemir/cachemir/pkg/config"
+)
+
+func main() {
+	var from, to string
+	flag.StringVar(&from, "from", "", "Comma-separated nodes of the current ring (host:port or host:port=weight)")
+	flag.StringVar(&to, "to", "", "Comma-separated nodes of the new ring (host:port or host:port=weight)")
+	flag.Parse()
+
+	if from == "" || to == "" {
+		log.Fatal("Both -from and -to are required")
+	}
+
+	cfg := config.LoadClientConfig()
+	cfg.Nodes = nodeList(from)
+	cfg.Sentinels = nil
+	c := client.NewWithConfig(cfg)
+
+	log.Printf("Resharding from %v to %v", cfg.Nodes, nodeList(to))
+	moved, err := c.Reshard(nodeList(to))
+	c.Close() //nolint:errcheck,gosec // Close never fails
+	log.Printf("Moved %d keys", moved)
+	if err != nil {
+		log.Fatalf("Resharding failed: %v", err)
+	}
+}
+
+// nodeList splits a comma-separated list of nodes.
+func nodeList(s string) []string {
+	var nodes []string
+	for _, node := range strings.Split(s, ",") {
+		if node = strings.TrimSpace(node); node != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
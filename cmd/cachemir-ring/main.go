@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cachemir/cachemir/pkg/client"
+	"github.com/cachemir/cachemir/pkg/config"
+	"github.com/cachemir/cachemir/pkg/hash"
+)
+
+const (
+	defaultSamples   = 100000 // Synthetic keys used to estimate shares and moves
+	defaultScanLimit = 10000  // Real keys sampled per node with -scan
+	scanBatchSize    = 1000   // Keys requested by each SCAN
+)
+
+// errUsage is returned by run for invalid flags, which the flag set has
+// already reported.
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	err := run(os.Args[1:], os.Stdout, os.Stderr)
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2) //nolint:mnd // The exit code of flag.ExitOnError
+	default:
+		log.Fatal(err)
+	}
+}
+
+// run analyzes the ring described by the command-line arguments args,
+// writing the report to stdout and usage errors to stderr.
+func run(args []string, stdout, stderr io.Writer) error {
+	cfg := config.LoadClientConfig()
+
+	fs := flag.NewFlagSet("cachemir-ring", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var nodes, add, remove, match string
+	var samples, scanLimit int
+	var scan bool
+	fs.StringVar(&nodes, "nodes", strings.Join(cfg.Nodes, ","),
+		"Comma-separated nodes of the ring (host:port or host:port=weight)")
+	fs.StringVar(&add, "add", "", "Comma-separated nodes to simulate adding (host:port or host:port=weight)")
+	fs.StringVar(&remove, "remove", "", "Comma-separated nodes to simulate removing")
+	fs.StringVar(&cfg.Placement, "placement", cfg.Placement,
+		"Placement algorithm: consistent, jump, rendezvous, maglev or bounded")
+	fs.StringVar(&cfg.HashFunction, "hash", cfg.HashFunction, "Hash function: sha256, xxhash, fnv or murmur3")
+	fs.IntVar(&cfg.VirtualNodes, "virtual-nodes", cfg.VirtualNodes, "Virtual nodes per node")
+	fs.IntVar(&samples, "samples", defaultSamples, "Synthetic keys used to estimate shares and moves")
+	fs.BoolVar(&scan, "scan", false, "Sample real keys from the nodes with SCAN")
+	fs.StringVar(&match, "match", "", "Glob pattern of the keys sampled with -scan")
+	fs.IntVar(&scanLimit, "scan-limit", defaultScanLimit, "Maximum keys sampled per node with -scan")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	cfg.Nodes = nodeList(nodes)
+	cfg.Sentinels = nil
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if samples <= 0 || scanLimit <= 0 {
+		return errors.New("-samples and -scan-limit must be positive")
+	}
+
+	current, err := buildRing(cfg, cfg.Nodes)
+	if err != nil {
+		return err
+	}
+	keys := syntheticKeys(samples)
+	fmt.Fprintf(stdout, "Ring: %d nodes, placement %s, hash %s, %d sampled keys\n\n",
+		len(current.GetNodes()), orDefault(cfg.Placement, hash.PlacementConsistent),
+		orDefault(cfg.HashFunction, hash.HashSHA256), samples)
+	printShares(stdout, current, keys)
+
+	var next hash.Ring
+	if add != "" || remove != "" {
+		if next, err = buildRing(cfg, changedNodes(cfg.Nodes, nodeList(add), nodeList(remove))); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "\nAfter %s:\n\n", describeChange(nodeList(add), nodeList(remove)))
+		printShares(stdout, next, keys)
+		fmt.Fprintln(stdout)
+		printMoves(stdout, current, next, keys, "sampled")
+	}
+
+	if scan {
+		c := client.NewWithConfig(cfg)
+		defer c.Close() //nolint:errcheck // Close never fails
+
+		realKeys, err := scanNodes(c, current, match, scanLimit)
+		if err != nil {
+			return fmt.Errorf("scan failed: %w", err)
+		}
+		fmt.Fprintf(stdout, "\nReal keys, sampled with SCAN:\n\n")
+		printLoad(stdout, current, realKeys)
+		if next != nil {
+			var all []string
+			for _, nodeKeys := range realKeys {
+				all = append(all, nodeKeys...)
+			}
+			fmt.Fprintln(stdout)
+			printMoves(stdout, current, next, all, "real")
+		}
+	}
+	return nil
+}
+
+// buildRing creates the ring of cfg and adds nodes, in order, with their
+// weights.
+func buildRing(cfg *config.ClientConfig, nodes []string) (hash.Ring, error) {
+	ring, err := hash.NewRing(cfg.Ring())
+	if err != nil {
+		return nil, fmt.Errorf("invalid ring configuration: %w", err)
+	}
+	for _, spec := range nodes {
+		addr, weight, err := config.ParseNode(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node: %w", err)
+		}
+		ring.AddNodeWithWeight(addr, weight)
+	}
+	return ring, nil
+}
+
+// changedNodes returns nodes without the removed addresses, followed by the
+// added ones.
+func changedNodes(nodes, added, removed []string) []string {
+	var changed []string
+	for _, spec := range nodes {
+		addr, _, _ := strings.Cut(spec, "=")
+		if !slices.Contains(removed, addr) {
+			changed = append(changed, spec)
+		}
+	}
+	return append(changed, added...)
+}
+
+// describeChange describes the added and removed nodes.
+func describeChange(added, removed []string) string {
+	var parts []string
+	if len(added) > 0 {
+		parts = append(parts, "adding "+strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		parts = append(parts, "removing "+strings.Join(removed, ", "))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// syntheticKeys returns n distinct keys, standing in for the keyspace.
+func syntheticKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+	}
+	return keys
+}
+
+// printShares prints the weight, target share, ring ownership (for rings
+// that report it) and sampled share of every node of ring.
+func printShares(out io.Writer, ring hash.Ring, keys []string) {
+	counts := make(map[string]int)
+	for _, key := range keys {
+		counts[ring.GetNode(key)]++
+	}
+	ownership, _ := ring.Stats()["ownership"].(map[string]float64)
+	totalWeight := totalWeight(ring)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tWEIGHT\tTARGET\tOWNED\tSAMPLED")
+	skew := 0.0
+	for _, node := range sortedNodes(ring) {
+		target := float64(ring.GetWeight(node)) / float64(totalWeight)
+		sampled := float64(counts[node]) / float64(len(keys))
+		owned := "-"
+		if pct, ok := ownership[node]; ok {
+			owned = fmt.Sprintf("%.2f%%", pct)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.2f%%\t%s\t%.2f%%\n", node, ring.GetWeight(node), target*100, owned, sampled*100)
+		skew = max(skew, sampled/target)
+	}
+	w.Flush() //nolint:errcheck,gosec // Nothing to do on failure
+	fmt.Fprintf(out, "Skew (largest sampled share / target): %.3f\n", skew)
+}
+
+// printMoves prints the share of keys whose node differs between the two
+// rings, per source and destination, and the minimum share any placement
+// would have to move for the change of weights.
+func printMoves(out io.Writer, from, to hash.Ring, keys []string, kind string) {
+	if len(keys) == 0 {
+		fmt.Fprintf(out, "No %s keys to move\n", kind)
+		return
+	}
+
+	type move struct{ from, to string }
+	moves := make(map[move]int)
+	moved := 0
+	for _, key := range keys {
+		src, dst := from.GetNode(key), to.GetNode(key)
+		if src != dst {
+			moves[move{src, dst}]++
+			moved++
+		}
+	}
+
+	fmt.Fprintf(out, "Moved %s keys: %d of %d (%.2f%%, minimum %.2f%%)\n",
+		kind, moved, len(keys), float64(moved)/float64(len(keys))*100, minimumMove(from, to)*100)
+	sorted := make([]move, 0, len(moves))
+	for m := range moves {
+		sorted = append(sorted, m)
+	}
+	slices.SortFunc(sorted, func(a, b move) int {
+		if c := strings.Compare(a.from, b.from); c != 0 {
+			return c
+		}
+		return strings.Compare(a.to, b.to)
+	})
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FROM\tTO\tKEYS\tSHARE")
+	for _, m := range sorted {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.2f%%\n", m.from, m.to, moves[m], float64(moves[m])/float64(len(keys))*100)
+	}
+	w.Flush() //nolint:errcheck,gosec // Nothing to do on failure
+}
+
+// minimumMove returns the share of the keyspace that must move when the
+// target shares change from one ring to the other: half the sum of the
+// differences in share of every node.
+func minimumMove(from, to hash.Ring) float64 {
+	fromWeight, toWeight := totalWeight(from), totalWeight(to)
+	nodes := append(from.GetNodes(), to.GetNodes()...)
+	slices.Sort(nodes)
+
+	diff := 0.0
+	for _, node := range slices.Compact(nodes) {
+		before := float64(from.GetWeight(node)) / float64(max(fromWeight, 1))
+		after := float64(to.GetWeight(node)) / float64(max(toWeight, 1))
+		diff += max(before-after, after-before)
+	}
+	return diff / 2
+}
+
+// scanNodes samples up to limit keys matching pattern from every node of
+// ring with SCAN.
+func scanNodes(c *client.Client, ring hash.Ring, pattern string, limit int) (map[string][]string, error) {
+	keys := make(map[string][]string)
+	for _, node := range sortedNodes(ring) {
+		for cursor := uint64(0); len(keys[node]) < limit; {
+			batch, next, err := c.ScanNode(node, cursor, pattern, min(scanBatchSize, limit-len(keys[node])))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", node, err)
+			}
+			keys[node] = append(keys[node], batch...)
+			if cursor = next; cursor == 0 {
+				break
+			}
+		}
+	}
+	return keys, nil
+}
+
+// printLoad prints the number of keys sampled on every node, their share
+// and target, and how many of them the ring places on another node.
+func printLoad(out io.Writer, ring hash.Ring, keys map[string][]string) {
+	total := 0
+	for _, nodeKeys := range keys {
+		total += len(nodeKeys)
+	}
+	totalWeight := totalWeight(ring)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tKEYS\tSHARE\tTARGET\tMISPLACED")
+	skew := 0.0
+	for _, node := range sortedNodes(ring) {
+		misplaced := 0
+		for _, key := range keys[node] {
+			if ring.GetNode(key) != node {
+				misplaced++
+			}
+		}
+		target := float64(ring.GetWeight(node)) / float64(totalWeight)
+		share := float64(len(keys[node])) / float64(max(total, 1))
+		fmt.Fprintf(w, "%s\t%d\t%.2f%%\t%.2f%%\t%d\n", node, len(keys[node]), share*100, target*100, misplaced)
+		skew = max(skew, share/target)
+	}
+	w.Flush() //nolint:errcheck,gosec // Nothing to do on failure
+	fmt.Fprintf(out, "Skew (largest share / target): %.3f\n", skew)
+}
+
+// totalWeight returns the sum of the weights of the nodes of ring.
+func totalWeight(ring hash.Ring) int {
+	total := 0
+	for _, node := range ring.GetNodes() {
+		total += ring.GetWeight(node)
+	}
+	return total
+}
+
+// sortedNodes returns the nodes of ring, sorted.
+func sortedNodes(ring hash.Ring) []string {
+	nodes := ring.GetNodes()
+	slices.Sort(nodes)
+	return nodes
+}
+
+// orDefault returns s, or def if s is empty.
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// nodeList splits a comma-separated list of nodes.
+func nodeList(s string) []string {
+	var nodes []string
+	for _, node := range strings.Split(s, ",") {
+		if node = strings.TrimSpace(node); node != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
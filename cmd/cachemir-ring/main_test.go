@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string // Substrings of the output, in order
+		notWant string   // Substring that must not appear
+		err     string   // Substring of the error, if run must fail
+	}{
+		{
+			name: "shares",
+			args: []string{"-nodes", "a:1, b:1", "-samples", "1000"},
+			want: []string{
+				"Ring: 2 nodes, placement consistent, hash sha256, 1000 sampled keys",
+				"NODE", "a:1", "b:1", "Skew",
+			},
+			notWant: "After",
+		},
+		{
+			name: "weights",
+			args: []string{"-nodes", "a:1=3,b:1", "-samples", "1000", "-placement", "rendezvous", "-hash", "fnv"},
+			want: []string{"placement rendezvous, hash fnv", "a:1", "75.00%", "b:1", "25.00%"},
+		},
+		{
+			name: "add",
+			args: []string{"-nodes", "a:1,b:1", "-samples", "1000", "-add", "c:1"},
+			want: []string{"After adding c:1:", "c:1", "Moved sampled keys:", "minimum 33.33%)", "FROM"},
+		},
+		{
+			name: "remove",
+			args: []string{"-nodes", "a:1,b:1", "-samples", "1000", "-remove", "b:1"},
+			want: []string{"After removing b:1:", "Moved sampled keys:", "minimum 50.00%)", "b:1", "a:1"},
+		},
+		{
+			name: "add and remove",
+			args: []string{"-nodes", "a:1,b:1", "-samples", "1000", "-add", "c:1=2", "-remove", "a:1"},
+			want: []string{"After adding c:1=2 and removing a:1:", "minimum 66.67%)"},
+		},
+		{name: "no nodes", args: []string{"-nodes", ""}, err: "invalid configuration"},
+		{name: "invalid weight", args: []string{"-nodes", "a:1=x"}, err: "invalid configuration"},
+		{name: "invalid placement", args: []string{"-nodes", "a:1", "-placement", "x"}, err: "invalid configuration"},
+		{name: "no samples", args: []string{"-nodes", "a:1", "-samples", "0"}, err: "must be positive"},
+		{name: "invalid added node", args: []string{"-nodes", "a:1", "-add", "c:1=0"}, err: "invalid node"},
+		{name: "unknown flag", args: []string{"-bogus"}, err: "invalid usage"},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		err := run(tt.args, &stdout, &stderr)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: run failed: %v", tt.name, err)
+			continue
+		}
+
+		out := stdout.String()
+		rest := out
+		for _, want := range tt.want {
+			i := strings.Index(rest, want)
+			if i < 0 {
+				t.Errorf("%s: expected %q in order in the output:\n%s", tt.name, want, out)
+				break
+			}
+			rest = rest[i+len(want):]
+		}
+		if tt.notWant != "" && strings.Contains(out, tt.notWant) {
+			t.Errorf("%s: expected no %q in the output:\n%s", tt.name, tt.notWant, out)
+		}
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"-h"}, &stdout, &stderr); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("Expected flag.ErrHelp, got %v", err)
+	}
+	if !strings.Contains(stderr.String(), "-nodes") || stdout.Len() != 0 {
+		t.Errorf("Expected the usage on stderr only, got %q and %q", stdout.String(), stderr.String())
+	}
+
+	stderr.Reset()
+	if err := run([]string{"-samples", "many"}, &stdout, &stderr); !errors.Is(err, errUsage) {
+		t.Errorf("Expected errUsage, got %v", err)
+	}
+	if !strings.Contains(stderr.String(), "invalid value") {
+		t.Errorf("Expected the flag error on stderr, got %q", stderr.String())
+	}
+}
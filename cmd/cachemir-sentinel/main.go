@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/cachemir/cachemir/internal/sentinel"
+	"github.com/cachemir/cachemir/pkg/config"
+)
+
+func main() {
+	cfg := config.LoadSentinelConfig()
+
+	s, err := sentinel.New(cfg)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	log.Printf("Starting CacheMir sentinel with config: %+v", cfg)
+
+	go func() {
+		if err := s.Start(); err != nil {
+			log.Fatalf("Sentinel failed to start: %v", err)
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	<-sigChan
+	log.Println("Shutting down sentinel...")
+
+	if err := s.Stop(); err != nil {
+		log.Printf("Error stopping sentinel: %v", err)
+	}
+
+	log.Println("Sentinel stopped")
+}
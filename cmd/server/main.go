@@ -5,9 +5,11 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/cachemir/cachemir/internal/server"
 	"github.com/cachemir/cachemir/pkg/config"
+	"github.com/cachemir/cachemir/pkg/hash"
 )
 
 func main() {
@@ -20,6 +22,20 @@ func main() {
 	log.Printf("Starting CacheMir server with config: %+v", cfg)
 
 	srv := server.New(cfg.Port)
+	if err := srv.SetNotifyKeyspaceEvents(cfg.NotifyKeyspaceEvents); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	srv.SetScriptTimeout(time.Duration(cfg.ScriptTimeout) * time.Millisecond)
+	if cfg.ReplicaOf != "" {
+		srv.ReplicaOf(cfg.ReplicaOf)
+	}
+	if cfg.ClusterAnnounce != "" {
+		ring, err := hash.NewRing(cfg.ClusterRing())
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		srv.EnableCluster(cfg.ClusterAnnounce, cfg.ClusterPeers, ring)
+	}
 
 	go func() {
 		if err := srv.Start(); err != nil {
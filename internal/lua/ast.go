@@ -0,0 +1,148 @@
+package lua
+
+// The parser resolves every variable while parsing: locals become slots in
+// their function's frame, variables of enclosing functions become upvalues
+// and everything else is a global. The interpreter walks the resulting tree.
+
+type expr interface{}
+
+type stmt interface{}
+
+type (
+	nilExpr    struct{}
+	trueExpr   struct{}
+	falseExpr  struct{}
+	varargExpr struct{}
+	numberExpr struct{ value float64 }
+	stringExpr struct{ value string }
+
+	// Variables keep their names for error messages.
+	localExpr struct {
+		slot int
+		name string
+	}
+	upvalExpr struct {
+		index int
+		name  string
+	}
+	globalExpr struct{ name string }
+
+	indexExpr struct {
+		obj, key expr
+		line     int
+	}
+
+	callExpr struct {
+		fn   expr
+		args []expr
+		line int
+	}
+
+	// methodCallExpr is obj:name(args), which passes obj as first argument.
+	methodCallExpr struct {
+		obj  expr
+		name string
+		args []expr
+		line int
+	}
+
+	functionExpr struct{ proto *funcProto }
+
+	binaryExpr struct {
+		op          string
+		left, right expr
+		line        int
+	}
+
+	unaryExpr struct {
+		op   string
+		x    expr
+		line int
+	}
+
+	andExpr struct{ left, right expr }
+	orExpr  struct{ left, right expr }
+
+	// parenExpr truncates a multi-value expression to a single value.
+	parenExpr struct{ x expr }
+
+	tableExpr struct{ items []tableItem }
+)
+
+// tableItem is one field of a table constructor. Positional items have a
+// nil key.
+type tableItem struct {
+	key, value expr
+}
+
+type (
+	localStmt struct {
+		slots []int
+		exprs []expr
+	}
+
+	assignStmt struct {
+		targets []expr
+		exprs   []expr
+	}
+
+	callStmt struct{ call expr }
+
+	doStmt struct{ body []stmt }
+
+	whileStmt struct {
+		cond expr
+		body []stmt
+	}
+
+	repeatStmt struct {
+		body []stmt
+		cond expr
+	}
+
+	ifStmt struct {
+		conds    []expr
+		blocks   [][]stmt
+		elseBody []stmt
+	}
+
+	numForStmt struct {
+		slot               int
+		start, limit, step expr
+		body               []stmt
+		line               int
+	}
+
+	genForStmt struct {
+		slots []int
+		exprs []expr
+		body  []stmt
+		line  int
+	}
+
+	localFunctionStmt struct {
+		slot  int
+		proto *funcProto
+	}
+
+	returnStmt struct{ exprs []expr }
+
+	breakStmt struct{}
+)
+
+// upvalDesc tells a closure where to find an upvalue when it is created:
+// in a local slot of the enclosing function or in one of its upvalues.
+type upvalDesc struct {
+	fromLocal bool
+	index     int
+}
+
+// funcProto is a parsed function.
+type funcProto struct {
+	name     string
+	params   []int // Slots of the parameters
+	isVararg bool
+	nslots   int
+	upvals   []upvalDesc
+	body     []stmt
+}
@@ -0,0 +1,728 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// flow tells a block's caller how it ended.
+type flow uint8
+
+const (
+	flowNormal flow = iota
+	flowBreak
+	flowReturn
+)
+
+// frame is one activation of a compiled function.
+type frame struct {
+	fn      *Function
+	slots   []*cell
+	varargs []Value
+}
+
+// runtimeErrorf builds an error positioned at line of the running chunk.
+func (s *State) runtimeErrorf(line int, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	return &RuntimeError{Value: fmt.Sprintf("%s:%d: %s", s.chunkName, line, msg)}
+}
+
+// positioned turns errors returned by Go functions into runtime errors at
+// the line of the current call. Runtime errors and the errors that end the
+// script pass through unchanged.
+func (s *State) positioned(err error) error {
+	var rt *RuntimeError
+	if errors.As(err, &rt) || errors.Is(err, ErrTimeout) || errors.Is(err, ErrStackOverflow) {
+		return err
+	}
+	return s.runtimeErrorf(s.line, "%s", err.Error())
+}
+
+func (s *State) callProto(fn *Function, args []Value) ([]Value, error) {
+	if err := s.step(); err != nil {
+		return nil, err
+	}
+
+	proto := fn.proto
+	fr := &frame{fn: fn, slots: make([]*cell, proto.nslots)}
+	for i, slot := range proto.params {
+		var v Value
+		if i < len(args) {
+			v = args[i]
+		}
+		fr.slots[slot] = &cell{v: v}
+	}
+	if proto.isVararg && len(args) > len(proto.params) {
+		fr.varargs = args[len(proto.params):]
+	}
+
+	_, results, err := s.execBlock(fr, proto.body)
+	return results, err
+}
+
+func (s *State) execBlock(fr *frame, body []stmt) (flow, []Value, error) {
+	for _, st := range body {
+		f, results, err := s.exec(fr, st)
+		if err != nil || f != flowNormal {
+			return f, results, err
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+func (s *State) exec(fr *frame, st stmt) (flow, []Value, error) {
+	switch st := st.(type) {
+	case *localStmt:
+		values, err := s.evalList(fr, st.exprs)
+		if err != nil {
+			return 0, nil, err
+		}
+		for i, slot := range st.slots {
+			var v Value
+			if i < len(values) {
+				v = values[i]
+			}
+			fr.slots[slot] = &cell{v: v}
+		}
+
+	case *assignStmt:
+		return flowNormal, nil, s.assign(fr, st)
+
+	case *callStmt:
+		if _, err := s.evalMulti(fr, st.call); err != nil {
+			return 0, nil, err
+		}
+
+	case *doStmt:
+		return s.execBlock(fr, st.body)
+
+	case *whileStmt:
+		for {
+			if err := s.step(); err != nil {
+				return 0, nil, err
+			}
+			cond, err := s.eval(fr, st.cond)
+			if err != nil {
+				return 0, nil, err
+			}
+			if !Truthy(cond) {
+				break
+			}
+			f, results, err := s.execBlock(fr, st.body)
+			if err != nil || f == flowReturn {
+				return f, results, err
+			}
+			if f == flowBreak {
+				break
+			}
+		}
+
+	case *repeatStmt:
+		for {
+			if err := s.step(); err != nil {
+				return 0, nil, err
+			}
+			f, results, err := s.execBlock(fr, st.body)
+			if err != nil || f == flowReturn {
+				return f, results, err
+			}
+			if f == flowBreak {
+				break
+			}
+			cond, err := s.eval(fr, st.cond)
+			if err != nil {
+				return 0, nil, err
+			}
+			if Truthy(cond) {
+				break
+			}
+		}
+
+	case *ifStmt:
+		for i, condExpr := range st.conds {
+			cond, err := s.eval(fr, condExpr)
+			if err != nil {
+				return 0, nil, err
+			}
+			if Truthy(cond) {
+				return s.execBlock(fr, st.blocks[i])
+			}
+		}
+		return s.execBlock(fr, st.elseBody)
+
+	case *numForStmt:
+		return s.execNumFor(fr, st)
+
+	case *genForStmt:
+		return s.execGenFor(fr, st)
+
+	case *localFunctionStmt:
+		c := &cell{}
+		fr.slots[st.slot] = c
+		c.v = s.closure(fr, st.proto)
+
+	case *returnStmt:
+		results, err := s.evalList(fr, st.exprs)
+		return flowReturn, results, err
+
+	case *breakStmt:
+		return flowBreak, nil, nil
+
+	default:
+		return 0, nil, fmt.Errorf("unknown statement %T", st)
+	}
+	return flowNormal, nil, nil
+}
+
+// assign evaluates the targets' tables and keys, then the values, then
+// assigns from left to right.
+func (s *State) assign(fr *frame, st *assignStmt) error {
+	type place struct {
+		table Value
+		key   Value
+	}
+	places := make([]place, len(st.targets))
+	for i, target := range st.targets {
+		if ix, ok := target.(*indexExpr); ok {
+			obj, err := s.eval(fr, ix.obj)
+			if err != nil {
+				return err
+			}
+			key, err := s.eval(fr, ix.key)
+			if err != nil {
+				return err
+			}
+			places[i] = place{table: obj, key: key}
+		}
+	}
+
+	values, err := s.evalList(fr, st.exprs)
+	if err != nil {
+		return err
+	}
+
+	for i, target := range st.targets {
+		var v Value
+		if i < len(values) {
+			v = values[i]
+		}
+		switch t := target.(type) {
+		case *localExpr:
+			fr.slots[t.slot].v = v
+		case *upvalExpr:
+			fr.fn.upvals[t.index].v = v
+		case *globalExpr:
+			_ = s.globals.Set(t.name, v)
+		case *indexExpr:
+			if err := s.setIndex(places[i].table, places[i].key, v, t.obj, t.line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *State) execNumFor(fr *frame, st *numForStmt) (flow, []Value, error) {
+	bound := func(e expr, what string) (float64, error) {
+		v, err := s.eval(fr, e)
+		if err != nil {
+			return 0, err
+		}
+		n, ok := ToNumber(v)
+		if !ok {
+			return 0, s.runtimeErrorf(st.line, "'for' %s must be a number", what)
+		}
+		return n, nil
+	}
+
+	start, err := bound(st.start, "initial value")
+	if err != nil {
+		return 0, nil, err
+	}
+	limit, err := bound(st.limit, "limit")
+	if err != nil {
+		return 0, nil, err
+	}
+	step := 1.0
+	if st.step != nil {
+		if step, err = bound(st.step, "step"); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	for i := start; (step > 0 && i <= limit) || (step <= 0 && i >= limit); i += step {
+		if err := s.step(); err != nil {
+			return 0, nil, err
+		}
+		fr.slots[st.slot] = &cell{v: i}
+		f, results, err := s.execBlock(fr, st.body)
+		if err != nil || f == flowReturn {
+			return f, results, err
+		}
+		if f == flowBreak {
+			break
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+func (s *State) execGenFor(fr *frame, st *genForStmt) (flow, []Value, error) {
+	values, err := s.evalList(fr, st.exprs)
+	if err != nil {
+		return 0, nil, err
+	}
+	values = append(values, nil, nil, nil)
+	iter, ok := values[0].(*Function)
+	if !ok {
+		return 0, nil, s.runtimeErrorf(st.line, "attempt to call a %s value", TypeName(values[0]))
+	}
+	state, control := values[1], values[2]
+
+	for {
+		if err := s.step(); err != nil {
+			return 0, nil, err
+		}
+		s.line = st.line
+		results, err := s.Call(iter, []Value{state, control})
+		if err != nil {
+			return 0, nil, s.positioned(err)
+		}
+		if len(results) == 0 || results[0] == nil {
+			break
+		}
+		control = results[0]
+
+		for i, slot := range st.slots {
+			var v Value
+			if i < len(results) {
+				v = results[i]
+			}
+			fr.slots[slot] = &cell{v: v}
+		}
+		f, results, err := s.execBlock(fr, st.body)
+		if err != nil || f == flowReturn {
+			return f, results, err
+		}
+		if f == flowBreak {
+			break
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+// closure creates a function value for proto, capturing its upvalues from
+// the enclosing frame.
+func (s *State) closure(fr *frame, proto *funcProto) *Function {
+	fn := &Function{name: proto.name, proto: proto, upvals: make([]*cell, len(proto.upvals))}
+	for i, desc := range proto.upvals {
+		if desc.fromLocal {
+			fn.upvals[i] = fr.slots[desc.index]
+		} else {
+			fn.upvals[i] = fr.fn.upvals[desc.index]
+		}
+	}
+	return fn
+}
+
+// isMulti reports whether e can produce several values.
+func isMulti(e expr) bool {
+	switch e.(type) {
+	case *callExpr, *methodCallExpr, *varargExpr:
+		return true
+	}
+	return false
+}
+
+// evalList evaluates expressions, expanding the last one if it produces
+// several values.
+func (s *State) evalList(fr *frame, exprs []expr) ([]Value, error) {
+	values := make([]Value, 0, len(exprs))
+	for i, e := range exprs {
+		if i == len(exprs)-1 && isMulti(e) {
+			rest, err := s.evalMulti(fr, e)
+			if err != nil {
+				return nil, err
+			}
+			return append(values, rest...), nil
+		}
+		v, err := s.eval(fr, e)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// evalMulti evaluates an expression that may produce several values.
+func (s *State) evalMulti(fr *frame, e expr) ([]Value, error) {
+	switch e := e.(type) {
+	case *varargExpr:
+		return fr.varargs, nil
+	case *callExpr:
+		fnValue, err := s.eval(fr, e.fn)
+		if err != nil {
+			return nil, err
+		}
+		args, err := s.evalList(fr, e.args)
+		if err != nil {
+			return nil, err
+		}
+		return s.callValue(fnValue, args, e.fn, e.line)
+	case *methodCallExpr:
+		obj, err := s.eval(fr, e.obj)
+		if err != nil {
+			return nil, err
+		}
+		method, err := s.index(obj, e.name, e.obj, e.line)
+		if err != nil {
+			return nil, err
+		}
+		args, err := s.evalList(fr, e.args)
+		if err != nil {
+			return nil, err
+		}
+		return s.callValue(method, append([]Value{obj}, args...), &methodName{name: e.name}, e.line)
+	}
+
+	v, err := s.eval(fr, e)
+	if err != nil {
+		return nil, err
+	}
+	return []Value{v}, nil
+}
+
+// methodName describes a method in error messages.
+type methodName struct{ name string }
+
+// callValue calls fnValue, or the __call metamethod of a table with the
+// table as first argument.
+func (s *State) callValue(fnValue Value, args []Value, callee expr, line int) ([]Value, error) {
+	fn, ok := fnValue.(*Function)
+	if !ok {
+		if fn, ok = metamethod(fnValue, "__call").(*Function); !ok {
+			return nil, s.operandError(line, "call", callee, fnValue)
+		}
+		args = append([]Value{fnValue}, args...)
+	}
+	s.line = line
+	results, err := s.Call(fn, args)
+	if err != nil {
+		if fn.fn != nil {
+			s.line = line
+			return nil, s.positioned(err)
+		}
+		return nil, err
+	}
+	return results, nil
+}
+
+// describe names the variable an expression reads, for error messages.
+func describe(e expr) string {
+	switch e := e.(type) {
+	case *globalExpr:
+		return fmt.Sprintf("global '%s'", e.name)
+	case *localExpr:
+		return fmt.Sprintf("local '%s'", e.name)
+	case *upvalExpr:
+		return fmt.Sprintf("upvalue '%s'", e.name)
+	case *methodName:
+		return fmt.Sprintf("method '%s'", e.name)
+	case *indexExpr:
+		if key, ok := e.key.(*stringExpr); ok {
+			return fmt.Sprintf("field '%s'", key.value)
+		}
+	}
+	return ""
+}
+
+// operandError reports an operation applied to a value of the wrong type,
+// naming the variable that held it when possible.
+func (s *State) operandError(line int, action string, e expr, v Value) error {
+	if name := describe(e); name != "" {
+		return s.runtimeErrorf(line, "attempt to %s %s (a %s value)", action, name, TypeName(v))
+	}
+	return s.runtimeErrorf(line, "attempt to %s a %s value", action, TypeName(v))
+}
+
+// index reads obj[key], following __index when the key is absent. Strings
+// index the string library, so that s:upper() works.
+func (s *State) index(obj, key Value, objExpr expr, line int) (Value, error) {
+	for i := 0; i < maxMetaChain; i++ {
+		switch o := obj.(type) {
+		case *Table:
+			v := o.Get(key)
+			handler := metamethod(o, "__index")
+			if v != nil || handler == nil {
+				return v, nil
+			}
+			if fn, ok := handler.(*Function); ok {
+				return s.callMeta(fn, line, o, key)
+			}
+			obj, objExpr = handler, nil
+			continue
+		case string:
+			return s.strings.Get(key), nil
+		}
+		return nil, s.operandError(line, "index", objExpr, obj)
+	}
+	return nil, s.runtimeErrorf(line, "loop in gettable")
+}
+
+func (s *State) eval(fr *frame, e expr) (Value, error) {
+	switch e := e.(type) {
+	case *nilExpr:
+		return nil, nil
+	case *trueExpr:
+		return true, nil
+	case *falseExpr:
+		return false, nil
+	case *numberExpr:
+		return e.value, nil
+	case *stringExpr:
+		return e.value, nil
+	case *varargExpr:
+		if len(fr.varargs) == 0 {
+			return nil, nil
+		}
+		return fr.varargs[0], nil
+	case *localExpr:
+		return fr.slots[e.slot].v, nil
+	case *upvalExpr:
+		return fr.fn.upvals[e.index].v, nil
+	case *globalExpr:
+		return s.globals.Get(e.name), nil
+	case *indexExpr:
+		obj, err := s.eval(fr, e.obj)
+		if err != nil {
+			return nil, err
+		}
+		key, err := s.eval(fr, e.key)
+		if err != nil {
+			return nil, err
+		}
+		return s.index(obj, key, e.obj, e.line)
+	case *callExpr, *methodCallExpr:
+		values, err := s.evalMulti(fr, e)
+		if err != nil || len(values) == 0 {
+			return nil, err
+		}
+		return values[0], nil
+	case *parenExpr:
+		return s.eval(fr, e.x)
+	case *functionExpr:
+		return s.closure(fr, e.proto), nil
+	case *andExpr:
+		left, err := s.eval(fr, e.left)
+		if err != nil || !Truthy(left) {
+			return left, err
+		}
+		return s.eval(fr, e.right)
+	case *orExpr:
+		left, err := s.eval(fr, e.left)
+		if err != nil || Truthy(left) {
+			return left, err
+		}
+		return s.eval(fr, e.right)
+	case *unaryExpr:
+		x, err := s.eval(fr, e.x)
+		if err != nil {
+			return nil, err
+		}
+		return s.unary(e, x)
+	case *binaryExpr:
+		left, err := s.eval(fr, e.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := s.eval(fr, e.right)
+		if err != nil {
+			return nil, err
+		}
+		return s.binary(e, left, right)
+	case *tableExpr:
+		return s.tableConstructor(fr, e)
+	}
+	return nil, fmt.Errorf("unknown expression %T", e)
+}
+
+func (s *State) unary(e *unaryExpr, x Value) (Value, error) {
+	switch e.op {
+	case "not":
+		return !Truthy(x), nil
+	case "-":
+		n, ok := ToNumber(x)
+		if ok {
+			return -n, nil
+		}
+		if v, ok, err := s.binaryMeta("__unm", x, x, e.line); ok || err != nil {
+			return v, err
+		}
+		return nil, s.operandError(e.line, "perform arithmetic on", e.x, x)
+	case "#":
+		switch v := x.(type) {
+		case string:
+			return float64(len(v)), nil
+		case *Table:
+			return float64(v.Len()), nil
+		}
+		return nil, s.operandError(e.line, "get length of", e.x, x)
+	}
+	return nil, fmt.Errorf("unknown operator %s", e.op)
+}
+
+func (s *State) binary(e *binaryExpr, left, right Value) (Value, error) {
+	switch e.op {
+	case "==":
+		return s.equal(left, right, e.line)
+	case "~=":
+		eq, err := s.equal(left, right, e.line)
+		return !eq, err
+	case "<", "<=", ">", ">=":
+		return s.compare(e, left, right)
+	case "..":
+		l, lok := concatOperand(left)
+		r, rok := concatOperand(right)
+		if !lok || !rok {
+			if v, ok, err := s.binaryMeta("__concat", left, right, e.line); ok || err != nil {
+				return v, err
+			}
+			bad, badExpr := left, e.left
+			if lok {
+				bad, badExpr = right, e.right
+			}
+			return nil, s.operandError(e.line, "concatenate", badExpr, bad)
+		}
+		return l + r, nil
+	}
+
+	l, lok := ToNumber(left)
+	r, rok := ToNumber(right)
+	if !lok || !rok {
+		if v, ok, err := s.binaryMeta(arithEvents[e.op], left, right, e.line); ok || err != nil {
+			return v, err
+		}
+		bad, badExpr := left, e.left
+		if lok {
+			bad, badExpr = right, e.right
+		}
+		return nil, s.operandError(e.line, "perform arithmetic on", badExpr, bad)
+	}
+	switch e.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		return l / r, nil
+	case "%":
+		return l - math.Floor(l/r)*r, nil
+	case "^":
+		return math.Pow(l, r), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", e.op)
+}
+
+func concatOperand(v Value) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case float64:
+		return FormatNumber(x), true
+	}
+	return "", false
+}
+
+func (s *State) compare(e *binaryExpr, left, right Value) (Value, error) {
+	var less, equal bool
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			break
+		}
+		less, equal = l < r, l == r
+		return compareResult(e.op, less, equal), nil
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			break
+		}
+		c := strings.Compare(l, r)
+		less, equal = c < 0, c == 0
+		return compareResult(e.op, less, equal), nil
+	}
+
+	// a > b is b < a, and a >= b is b <= a.
+	if e.op == ">" || e.op == ">=" {
+		left, right = right, left
+	}
+	if v, ok, err := s.lessMeta(e.op == "<=" || e.op == ">=", left, right, e.line); ok || err != nil {
+		return v, err
+	}
+	if e.op == ">" || e.op == ">=" {
+		left, right = right, left
+	}
+
+	lt, rt := TypeName(left), TypeName(right)
+	if lt == rt {
+		return nil, s.runtimeErrorf(e.line, "attempt to compare two %s values", lt)
+	}
+	return nil, s.runtimeErrorf(e.line, "attempt to compare %s with %s", lt, rt)
+}
+
+func compareResult(op string, less, equal bool) bool {
+	switch op {
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	}
+	return !less
+}
+
+func (s *State) tableConstructor(fr *frame, e *tableExpr) (Value, error) {
+	t := NewTable()
+	n := 1
+	for i, item := range e.items {
+		if item.key == nil {
+			if i == len(e.items)-1 && isMulti(item.value) {
+				values, err := s.evalMulti(fr, item.value)
+				if err != nil {
+					return nil, err
+				}
+				for _, v := range values {
+					_ = t.Set(float64(n), v)
+					n++
+				}
+				continue
+			}
+			v, err := s.eval(fr, item.value)
+			if err != nil {
+				return nil, err
+			}
+			_ = t.Set(float64(n), v)
+			n++
+			continue
+		}
+
+		key, err := s.eval(fr, item.key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := s.eval(fr, item.value)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.Set(key, v); err != nil {
+			return nil, s.runtimeErrorf(s.line, "%s", err.Error())
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,319 @@
+package lua
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tokenKind identifies a lexical token.
+type tokenKind uint8
+
+const (
+	tokEOF tokenKind = iota
+	tokName
+	tokNumber
+	tokString
+	tokSymbol  // Operators and punctuation
+	tokKeyword // Reserved words
+)
+
+// keywords are the reserved words of the language.
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "if": true,
+	"in": true, "local": true, "nil": true, "not": true, "or": true,
+	"repeat": true, "return": true, "then": true, "true": true, "until": true,
+	"while": true,
+}
+
+// symbols are the operators and punctuation, longest first.
+var symbols = []string{
+	"...", "..", "==", "~=", "<=", ">=",
+	"+", "-", "*", "/", "%", "^", "#", "<", ">", "=",
+	"(", ")", "{", "}", "[", "]", ";", ":", ",", ".",
+}
+
+type token struct {
+	text string  // Name, keyword, symbol or decoded string
+	num  float64 // Value of number tokens
+	line int
+	kind tokenKind
+}
+
+// lexer splits source code into tokens.
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+// tokenize returns all tokens of src, ending with an EOF token.
+func tokenize(src string) ([]token, error) {
+	lx := &lexer{src: src, line: 1}
+	if strings.HasPrefix(src, "#") {
+		// Skip a shebang line, as the reference implementation does.
+		for lx.pos < len(src) && src[lx.pos] != '\n' {
+			lx.pos++
+		}
+	}
+
+	var tokens []token
+	for {
+		tok, err := lx.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		if tok.kind == tokEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (lx *lexer) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: lx.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// next scans one token.
+func (lx *lexer) next() (token, error) {
+	if err := lx.skipSpaceAndComments(); err != nil {
+		return token{}, err
+	}
+	if lx.pos >= len(lx.src) {
+		return token{kind: tokEOF, line: lx.line}, nil
+	}
+
+	c := lx.src[lx.pos]
+	switch {
+	case isLetter(c):
+		start := lx.pos
+		for lx.pos < len(lx.src) && (isLetter(lx.src[lx.pos]) || isDigit(lx.src[lx.pos])) {
+			lx.pos++
+		}
+		word := lx.src[start:lx.pos]
+		if keywords[word] {
+			return token{kind: tokKeyword, text: word, line: lx.line}, nil
+		}
+		return token{kind: tokName, text: word, line: lx.line}, nil
+	case isDigit(c) || (c == '.' && lx.pos+1 < len(lx.src) && isDigit(lx.src[lx.pos+1])):
+		return lx.number()
+	case c == '"' || c == '\'':
+		return lx.quotedString(c)
+	case c == '[' && lx.longBracketLevel() >= 0:
+		text, err := lx.longString()
+		if err != nil {
+			return token{}, err
+		}
+		return token{kind: tokString, text: text, line: lx.line}, nil
+	}
+
+	for _, sym := range symbols {
+		if strings.HasPrefix(lx.src[lx.pos:], sym) {
+			lx.pos += len(sym)
+			return token{kind: tokSymbol, text: sym, line: lx.line}, nil
+		}
+	}
+	return token{}, lx.errorf("unexpected symbol near '%c'", c)
+}
+
+func (lx *lexer) skipSpaceAndComments() error {
+	for lx.pos < len(lx.src) {
+		c := lx.src[lx.pos]
+		switch {
+		case c == '\n':
+			lx.line++
+			lx.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			lx.pos++
+		case strings.HasPrefix(lx.src[lx.pos:], "--"):
+			lx.pos += 2
+			if lx.pos < len(lx.src) && lx.src[lx.pos] == '[' && lx.longBracketLevel() >= 0 {
+				if _, err := lx.longString(); err != nil {
+					return err
+				}
+				continue
+			}
+			for lx.pos < len(lx.src) && lx.src[lx.pos] != '\n' {
+				lx.pos++
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// longBracketLevel returns the level of a long bracket ("[[", "[=[", ...)
+// starting at the current position, or -1 if there is none.
+func (lx *lexer) longBracketLevel() int {
+	i := lx.pos + 1
+	level := 0
+	for i < len(lx.src) && lx.src[i] == '=' {
+		level++
+		i++
+	}
+	if i < len(lx.src) && lx.src[i] == '[' {
+		return level
+	}
+	return -1
+}
+
+// longString scans a long bracket string such as [[...]] or [==[...]==].
+// A newline directly after the opening bracket is skipped.
+func (lx *lexer) longString() (string, error) {
+	level := lx.longBracketLevel()
+	lx.pos += level + 2
+	closing := "]" + strings.Repeat("=", level) + "]"
+
+	if strings.HasPrefix(lx.src[lx.pos:], "\r\n") {
+		lx.pos += 2
+		lx.line++
+	} else if lx.pos < len(lx.src) && lx.src[lx.pos] == '\n' {
+		lx.pos++
+		lx.line++
+	}
+
+	end := strings.Index(lx.src[lx.pos:], closing)
+	if end < 0 {
+		return "", lx.errorf("unfinished long string")
+	}
+	text := lx.src[lx.pos : lx.pos+end]
+	lx.line += strings.Count(text, "\n")
+	lx.pos += end + len(closing)
+	return text, nil
+}
+
+func (lx *lexer) number() (token, error) {
+	start := lx.pos
+	if strings.HasPrefix(lx.src[lx.pos:], "0x") || strings.HasPrefix(lx.src[lx.pos:], "0X") {
+		lx.pos += 2
+		for lx.pos < len(lx.src) && isHexDigit(lx.src[lx.pos]) {
+			lx.pos++
+		}
+	} else {
+		for lx.pos < len(lx.src) && (isDigit(lx.src[lx.pos]) || lx.src[lx.pos] == '.') {
+			lx.pos++
+		}
+		if lx.pos < len(lx.src) && (lx.src[lx.pos] == 'e' || lx.src[lx.pos] == 'E') {
+			lx.pos++
+			if lx.pos < len(lx.src) && (lx.src[lx.pos] == '+' || lx.src[lx.pos] == '-') {
+				lx.pos++
+			}
+			for lx.pos < len(lx.src) && isDigit(lx.src[lx.pos]) {
+				lx.pos++
+			}
+		}
+	}
+
+	text := lx.src[start:lx.pos]
+	num, ok := parseNumber(text)
+	if !ok || (lx.pos < len(lx.src) && isLetter(lx.src[lx.pos])) {
+		return token{}, lx.errorf("malformed number near '%s'", text)
+	}
+	return token{kind: tokNumber, num: num, text: text, line: lx.line}, nil
+}
+
+// escapes maps single-character escape sequences to their bytes.
+var escapes = map[byte]byte{
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+	'\\': '\\', '"': '"', '\'': '\'', '\n': '\n',
+}
+
+func (lx *lexer) quotedString(quote byte) (token, error) {
+	lx.pos++
+	var sb strings.Builder
+	for {
+		if lx.pos >= len(lx.src) || lx.src[lx.pos] == '\n' {
+			return token{}, lx.errorf("unfinished string")
+		}
+		c := lx.src[lx.pos]
+		lx.pos++
+		if c == quote {
+			return token{kind: tokString, text: sb.String(), line: lx.line}, nil
+		}
+		if c != '\\' {
+			sb.WriteByte(c)
+			continue
+		}
+
+		if lx.pos >= len(lx.src) {
+			return token{}, lx.errorf("unfinished string")
+		}
+		e := lx.src[lx.pos]
+		if b, ok := escapes[e]; ok {
+			if e == '\n' {
+				lx.line++
+			}
+			sb.WriteByte(b)
+			lx.pos++
+			continue
+		}
+		if !isDigit(e) {
+			return token{}, lx.errorf("invalid escape sequence '\\%c'", e)
+		}
+
+		// Decimal escape of up to three digits.
+		n := 0
+		for i := 0; i < 3 && lx.pos < len(lx.src) && isDigit(lx.src[lx.pos]); i++ {
+			n = n*10 + int(lx.src[lx.pos]-'0')
+			lx.pos++
+		}
+		if n > 255 {
+			return token{}, lx.errorf("escape sequence too large")
+		}
+		sb.WriteByte(byte(n))
+	}
+}
+
+// parseNumber converts a numeric literal or string to a number, accepting
+// decimal and hexadecimal integer forms with surrounding whitespace.
+func parseNumber(text string) (float64, bool) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, false
+	}
+
+	neg := false
+	body := text
+	if body[0] == '-' || body[0] == '+' {
+		neg = body[0] == '-'
+		body = body[1:]
+	}
+	if strings.HasPrefix(body, "0x") || strings.HasPrefix(body, "0X") {
+		n, err := strconv.ParseUint(body[2:], 16, 64)
+		if err != nil {
+			return 0, false
+		}
+		if neg {
+			return -float64(n), true
+		}
+		return float64(n), true
+	}
+
+	// ParseFloat accepts forms that are not valid numerals, such as "inf"
+	// and "0x1p4"; only digits, dots, signs and exponents are allowed here.
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if !isDigit(c) && c != '.' && c != 'e' && c != 'E' && c != '+' && c != '-' {
+			return 0, false
+		}
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
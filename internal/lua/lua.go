@@ -0,0 +1,262 @@
+// Package lua implements an interpreter for the subset of Lua 5.1 used by
+// cache scripts.
+//
+// The language is supported except for coroutines and goto; metatables
+// work on tables as in Lua 5.1 (see meta.go). The standard library is
+// limited to the deterministic functions that scripts need: the base
+// functions (type, tostring, tonumber, pairs, ipairs, next, select, unpack,
+// error, pcall, assert, rawget, rawset, rawequal, getmetatable,
+// setmetatable) and the string, table and math libraries without I/O, OS
+// access or randomness.
+//
+// Values are represented by Go types: nil, bool, float64, string, *Table
+// and *Function.
+//
+// Example:
+//
+//	chunk, err := lua.Compile("example", "return KEYS[1] .. '!'")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	state := lua.NewState()
+//	state.SetGlobal("KEYS", lua.NewArray("hello"))
+//	results, err := state.Run(chunk) // ["hello!"]
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Value is a Lua value: nil, bool, float64, string, *Table or *Function.
+type Value interface{}
+
+// GoFunction implements a function in Go. It receives the call arguments
+// and returns the results.
+type GoFunction func(state *State, args []Value) ([]Value, error)
+
+// Function is a Lua function: a closure over a compiled function or a Go
+// function.
+type Function struct {
+	name   string
+	proto  *funcProto
+	upvals []*cell
+	fn     GoFunction
+}
+
+// NewFunction wraps a Go function so that scripts can call it.
+func NewFunction(name string, fn GoFunction) *Function {
+	return &Function{name: name, fn: fn}
+}
+
+// cell holds a local variable that closures may share.
+type cell struct {
+	v Value
+}
+
+// Chunk is a compiled script that can be run any number of times.
+type Chunk struct {
+	proto *funcProto
+}
+
+// Compile parses source code. The name is used in error messages.
+//
+// Returns:
+//   - The compiled chunk
+//   - A *SyntaxError if the source is invalid
+func Compile(name, src string) (*Chunk, error) {
+	proto, err := parse(name, src)
+	if err != nil {
+		var syntaxErr *SyntaxError
+		if errors.As(err, &syntaxErr) {
+			syntaxErr.Chunk = name
+		}
+		return nil, err
+	}
+	return &Chunk{proto: proto}, nil
+}
+
+// SyntaxError reports invalid source code.
+type SyntaxError struct {
+	Chunk string
+	Line  int
+	Msg   string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Chunk, e.Line, e.Msg)
+}
+
+// RuntimeError is an error raised while running a script, either by the
+// interpreter or by the error function. Value is the error object, which
+// is usually a message prefixed with the script position but may be any
+// value.
+type RuntimeError struct {
+	Value Value
+}
+
+func (e *RuntimeError) Error() string {
+	return ToString(e.Value)
+}
+
+// ErrTimeout is returned when a script runs past its deadline. Unlike
+// other errors, pcall does not catch it.
+var ErrTimeout = errors.New("script timed out")
+
+// ErrStackOverflow is returned when calls nest too deeply.
+var ErrStackOverflow = errors.New("stack overflow")
+
+// maxCallDepth bounds the nesting of function calls.
+const maxCallDepth = 200
+
+// deadlineCheckInterval is how many steps run between deadline checks.
+const deadlineCheckInterval = 1000
+
+// State holds the globals of a script environment. A State is not safe for
+// concurrent use.
+type State struct {
+	globals   *Table
+	strings   *Table // String library, for method calls on strings
+	deadline  time.Time
+	steps     int
+	depth     int
+	chunkName string
+	line      int // Line of the current call, for error positions
+}
+
+// NewState returns a state with the standard library loaded.
+func NewState() *State {
+	s := &State{globals: NewTable()}
+	openLibs(s)
+	return s
+}
+
+// SetGlobal sets a global variable.
+func (s *State) SetGlobal(name string, v Value) {
+	_ = s.globals.Set(name, v)
+}
+
+// Global returns a global variable.
+func (s *State) Global(name string) Value {
+	return s.globals.Get(name)
+}
+
+// SetDeadline makes scripts fail with ErrTimeout once the deadline passes.
+// The zero time disables the limit.
+func (s *State) SetDeadline(deadline time.Time) {
+	s.deadline = deadline
+}
+
+// Run runs a compiled chunk and returns the values it returns.
+func (s *State) Run(chunk *Chunk) ([]Value, error) {
+	s.steps = 0
+	s.depth = 0
+	s.chunkName = chunk.proto.name
+	return s.Call(&Function{name: chunk.proto.name, proto: chunk.proto}, nil)
+}
+
+// Call calls a function with arguments and returns its results.
+func (s *State) Call(fn *Function, args []Value) ([]Value, error) {
+	if s.depth >= maxCallDepth {
+		return nil, ErrStackOverflow
+	}
+	s.depth++
+	defer func() { s.depth-- }()
+
+	if fn.fn != nil {
+		return fn.fn(s, args)
+	}
+	return s.callProto(fn, args)
+}
+
+// step counts work done by the script and enforces the deadline.
+func (s *State) step() error {
+	s.steps++
+	if s.steps%deadlineCheckInterval == 0 && !s.deadline.IsZero() && time.Now().After(s.deadline) {
+		return ErrTimeout
+	}
+	return nil
+}
+
+// TypeName returns the Lua type name of v.
+func TypeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *Function:
+		return "function"
+	}
+	return "userdata"
+}
+
+// Truthy reports whether v counts as true: anything but nil and false.
+func Truthy(v Value) bool {
+	switch b := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return b
+	}
+	return true
+}
+
+// ToString converts v to a string as tostring does.
+func ToString(v Value) string {
+	switch x := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(x)
+	case float64:
+		return FormatNumber(x)
+	case string:
+		return x
+	case *Table:
+		return fmt.Sprintf("table: %p", x)
+	case *Function:
+		if x.fn != nil {
+			return fmt.Sprintf("function: builtin: %p", x)
+		}
+		return fmt.Sprintf("function: %p", x)
+	}
+	return fmt.Sprint(v)
+}
+
+// FormatNumber formats a number like Lua's "%.14g", so integral values have
+// no fractional part.
+func FormatNumber(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	case f == math.Trunc(f) && math.Abs(f) < 1e15:
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return strconv.FormatFloat(f, 'g', 14, 64)
+}
+
+// ToNumber converts v to a number: numbers as is and strings holding a
+// numeral.
+func ToNumber(v Value) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case string:
+		return parseNumber(x)
+	}
+	return 0, false
+}
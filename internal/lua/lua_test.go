@@ -0,0 +1,464 @@
+package lua
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func run(t *testing.T, src string) []Value {
+	t.Helper()
+	chunk, err := Compile("test", src)
+	if err != nil {
+		t.Fatalf("Compile(%q) failed: %v", src, err)
+	}
+	results, err := NewState().Run(chunk)
+	if err != nil {
+		t.Fatalf("Run(%q) failed: %v", src, err)
+	}
+	return results
+}
+
+func TestEvaluation(t *testing.T) {
+	tests := []struct {
+		src  string
+		want Value
+	}{
+		{"return 1 + 2 * 3", 7.0},
+		{"return -2 ^ 2", -4.0},
+		{"return 7 % 3, 2 ^ 10", 1.0},
+		{"return 'a' .. 1 .. 'b'", "a1b"},
+		{"return '10' + 5", 15.0},
+		{"return 1 < 2 and 'yes' or 'no'", "yes"},
+		{"return nil or false", false},
+		{"return not nil", true},
+		{"return #'hello'", 5.0},
+		{"local t = {1, 2, 3, x = 4} return #t + t.x", 7.0},
+		{"local t = {} t[1] = 'a' t[2] = 'b' t[2] = nil return #t", 1.0},
+		{"local s = 0 for i = 1, 10 do s = s + i end return s", 55.0},
+		{"local s = 0 for i = 10, 1, -2 do s = s + i end return s", 30.0},
+		{"local s = 0 while true do s = s + 1 if s == 5 then break end end return s", 5.0},
+		{"local i = 0 repeat local j = i i = i + 1 until j >= 3 return i", 4.0},
+		{"local function fib(n) if n < 2 then return n end return fib(n-1) + fib(n-2) end return fib(15)", 610.0},
+		{"local x = 1 local function f() return x end local x = 2 return f()", 1.0},
+		{"local fs = {} for i = 1, 3 do fs[i] = function() return i end end return fs[1]() + fs[3]()", 4.0},
+		{"local function counter() local n = 0 return function() n = n + 1 return n end end local c = counter() c() return c()", 2.0},
+		{"local t = {n = 1} function t:inc(by) self.n = self.n + by return self end return t:inc(2):inc(3).n", 6.0},
+		{"local function f(...) return select('#', ...) end return f(1, nil, 3)", 3.0},
+		{"local function f(...) local a, b = ... return b end return f(1, 2)", 2.0},
+		{"local a, b = (function() return 1, 2 end)() return b", 2.0},
+		{"local t = {(function() return 1, 2 end)()} return #t", 2.0},
+		{"local t = {(function() return 1, 2 end)(), 3} return #t", 2.0},
+		{"a, b = 1 return b", nil},
+		{"local a, b = 1, 2 a, b = b, a return a", 2.0},
+		{"return [[long\nstring]]", "long\nstring"},
+		{"return '\\65\\t\\\\'", "A\t\\"},
+		{"return 0x10 + 1e2", 116.0},
+		{"return tostring(10 / 2) .. tostring(1 / 2)", "50.5"},
+		{"return tonumber('0x1f') + tonumber('z', 36)", 66.0},
+		{"return tonumber('abc')", nil},
+		{"return type({}) .. type(print)", "tablenil"},
+		{"local n = 0 for k, v in pairs({a = 1, b = 2, 3}) do n = n + v end return n", 6.0},
+		{"local s = '' for i, v in ipairs({'a', 'b', nil, 'c'}) do s = s .. v end return s", "ab"},
+		{"local t = {x = 1, y = 2} for k in pairs(t) do t[k] = nil end return next(t)", nil},
+		{"return select(-1, 'a', 'b')", "b"},
+		{"return unpack({1, 2, 3}, 2)", 2.0},
+		{"local ok, err = pcall(error, {code = 7}) return err.code", 7.0},
+		{"local ok, err = pcall(function() error('boom') end) return err", "test:1: boom"},
+		{"local ok, err = pcall(function() error('boom', 0) end) return err", "boom"},
+		{"local ok, err = pcall(function() local x = nil return x.y end) return ok", false},
+	}
+
+	for _, tt := range tests {
+		results := run(t, tt.src)
+		var got Value
+		if len(results) > 0 {
+			got = results[0]
+		}
+		if got != tt.want {
+			t.Errorf("%q = %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestStandardLibrary(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"return string.format('%d-%5.2f-%s-%x', 42.9, 3.14159, 'hi', 255)", "42- 3.14-hi-ff"},
+		{"return string.format('%q', 'a\"b')", `"a\"b"`},
+		{"return ('hello'):upper() .. string.lower('ABC')", "HELLOabc"},
+		{"return string.sub('hello', 2, -2) .. string.sub('hello', -3)", "ellllo"},
+		{"return string.rep('ab', 3) .. string.reverse('xyz')", "abababzyx"},
+		{"return string.char(string.byte('A') + 1)", "B"},
+		{"return tostring(string.find('hello world', 'o w'))", "5"},
+		{"return tostring(select(2, string.find('a.b', '.', 1, true)))", "2"},
+		{"return string.match('key:123', '(%a+):(%d+)')", "key"},
+		{"return select(2, string.match('key:123', '(%a+):(%d+)'))", "123"},
+		{"return string.match('  trim  ', '^%s*(.-)%s*$')", "trim"},
+		{"return (string.gsub('hello world', 'o', '0'))", "hell0 w0rld"},
+		{"return (string.gsub('abc', '%w', '%0%0'))", "aabbcc"},
+		{"return (string.gsub('$name is $age', '%$(%w+)', {name = 'bob', age = 3}))", "bob is 3"},
+		{"return (string.gsub('abc', '.', function(c) return c:upper() end))", "ABC"},
+		{"return (string.gsub('f(a(b)c)d', '%b()', 'X'))", "fXd"},
+		{"local t = {} for w in string.gmatch('one two three', '%a+') do t[#t+1] = w end return table.concat(t, ',')", "one,two,three"},
+		{"local t = {3, 1, 2} table.sort(t) return table.concat(t, ' ')", "1 2 3"},
+		{"local t = {3, 1, 2} table.sort(t, function(a, b) return a > b end) return table.concat(t)", "321"},
+		{"local t = {1, 2} table.insert(t, 3) table.insert(t, 1, 0) return table.concat(t)", "0123"},
+		{"local t = {1, 2, 3} local r = table.remove(t, 1) return r .. table.concat(t)", "123"},
+		{"return tostring(math.floor(3.7)) .. tostring(math.max(1, 5, 3)) .. tostring(math.huge)", "35inf"},
+	}
+
+	for _, tt := range tests {
+		results := run(t, tt.src)
+		if len(results) == 0 || results[0] != tt.want {
+			t.Errorf("%q = %v, want %q", tt.src, results, tt.want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	syntax := []string{
+		"return return",
+		"local = 1",
+		"x = ",
+		"if true then",
+		"break",
+		"return 'unfinished",
+		"f() = 1",
+	}
+	for _, src := range syntax {
+		var syntaxErr *SyntaxError
+		if _, err := Compile("test", src); !errors.As(err, &syntaxErr) {
+			t.Errorf("Compile(%q) error = %v, want syntax error", src, err)
+		}
+	}
+
+	runtime := map[string]string{
+		"return nil + 1":             "test:1: attempt to perform arithmetic on a nil value",
+		"local t = nil return t.x":   "test:1: attempt to index local 't' (a nil value)",
+		"undefined()":                "test:1: attempt to call global 'undefined' (a nil value)",
+		"return 1 < 'x'":             "test:1: attempt to compare number with string",
+		"return string.rep()":        "test:1: bad argument #1 to 'rep' (string expected, got no value)",
+		"\n\nerror('custom')":        "test:3: custom",
+		"return {} .. 'x'":           "test:1: attempt to concatenate a table value",
+		"assert(false, 'no luck')":   "no luck",
+		"local t = {} t[nil] = 1":    "test:1: table index is nil",
+		"return ('x'):nonexistent()": "test:1: attempt to call method 'nonexistent' (a nil value)",
+	}
+	for src, want := range runtime {
+		chunk, err := Compile("test", src)
+		if err != nil {
+			t.Fatalf("Compile(%q) failed: %v", src, err)
+		}
+		_, err = NewState().Run(chunk)
+		var rt *RuntimeError
+		if !errors.As(err, &rt) || err.Error() != want {
+			t.Errorf("Run(%q) error = %v, want %q", src, err, want)
+		}
+	}
+
+	chunk, _ := Compile("test", "local function f() return f() + 1 end return f()")
+	if _, err := NewState().Run(chunk); !errors.Is(err, ErrStackOverflow) {
+		t.Errorf("Expected stack overflow, got %v", err)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	chunk, err := Compile("test", "local ok = pcall(function() while true do end end) return ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := NewState()
+	state.SetDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	if _, err := state.Run(chunk); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Script ran for %v after its deadline", elapsed)
+	}
+}
+
+func TestGoFunctions(t *testing.T) {
+	state := NewState()
+	var calls []string
+	state.SetGlobal("record", NewFunction("record", func(s *State, args []Value) ([]Value, error) {
+		for _, arg := range args {
+			calls = append(calls, ToString(arg))
+		}
+		return []Value{float64(len(calls))}, nil
+	}))
+	state.SetGlobal("ARGV", NewArray("a", "b"))
+
+	chunk, err := Compile("test", "return record(ARGV[1], ARGV[2], 3)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := state.Run(chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0] != 3.0 || strings.Join(calls, ",") != "a,b,3" {
+		t.Errorf("Unexpected results %v, calls %v", results, calls)
+	}
+}
+
+// expect runs each script and compares its first result.
+func expect(t *testing.T, tests map[string]Value) {
+	t.Helper()
+	for src, want := range tests {
+		results := run(t, src)
+		var got Value
+		if len(results) > 0 {
+			got = results[0]
+		}
+		if got != want {
+			t.Errorf("%q = %#v, want %#v", src, got, want)
+		}
+	}
+}
+
+// expectErrors runs each script and compares the error it fails with.
+func expectErrors(t *testing.T, tests map[string]string) {
+	t.Helper()
+	for src, want := range tests {
+		chunk, err := Compile("test", src)
+		if err != nil {
+			t.Fatalf("Compile(%q) failed: %v", src, err)
+		}
+		if _, err := NewState().Run(chunk); err == nil || err.Error() != want {
+			t.Errorf("Run(%q) error = %v, want %q", src, err, want)
+		}
+	}
+}
+
+func TestClosures(t *testing.T) {
+	expect(t, map[string]Value{
+		// Closures created together share their upvalues.
+		`local function pair() local n = 0
+			return function() n = n + 1 return n end, function() return n end end
+		 local inc, get = pair() inc() inc() return get()`: 2.0,
+		// Each counter has its own state.
+		`local function counter() local n = 0 return function() n = n + 1 return n end end
+		 local a, b = counter(), counter() a() a() return a() * 10 + b()`: 31.0,
+		// Loop variables are fresh in each iteration, including while loops.
+		`local fs = {} local i = 1
+		 while i <= 3 do local j = i fs[i] = function() return j end i = i + 1 end
+		 return fs[1]() + fs[2]() * 10`: 21.0,
+		`local fs = {} for _, v in ipairs({'a', 'b'}) do fs[#fs + 1] = function() return v end end
+		 return fs[1]() .. fs[2]()`: "ab",
+		// Nested closures reach upvalues of enclosing functions.
+		`local x = 1 local function outer() return function() x = x + 1 return x end end
+		 outer()() return x`: 2.0,
+		// Recursive local functions see themselves.
+		`local function fact(n) if n <= 1 then return 1 end return n * fact(n - 1) end return fact(10)`:     3628800.0,
+		`local t = {} function t.f(n) if n == 0 then return 'done' end return t.f(n - 1) end return t.f(5)`: "done",
+	})
+}
+
+func TestVarargs(t *testing.T) {
+	expect(t, map[string]Value{
+		"local function f(...) return ... end return select('#', f())":                   0.0,
+		"local function f(...) return ... end return select('#', f(nil, nil))":           2.0,
+		"local function f(a, ...) return a, select('#', ...) end return select(2, f(1))": 0.0,
+		"local function f(...) local t = {...} return #t end return f(1, 2, 3)":          3.0,
+		"local function f(...) return (...) end return select('#', f(1, 2))":             1.0,
+		"local function f(...) return ..., 'x' end return select('#', f(1, 2))":          2.0,
+		"local function sum(...) local s = 0 for i = 1, select('#', ...) do s = s + select(i, ...) end return s end" +
+			" return sum(1, 2, 3, 4)": 10.0,
+		"local function f(...) return select(2, ...) end return f('a', 'b', 'c')":     "b",
+		"local function f(a, b) return b end return f(1)":                             nil,
+		"local function f(a) return a end return f(1, 2, 3)":                          1.0,
+		"return select('#', unpack({1, nil, 3}, 1, 3))":                               3.0,
+		"local function f(...) return ... end local t = {f(1, 2), f(3, 4)} return #t": 3.0,
+		"local function f(...) local a, b, c = ..., 'x' return c end return f(1, 2)":  nil,
+	})
+}
+
+func TestNumericFor(t *testing.T) {
+	expect(t, map[string]Value{
+		"local n = 0 for i = 1, 0 do n = n + 1 end return n":                                 0.0,
+		"local n = 0 for i = 1, 1 do n = n + 1 end return n":                                 1.0,
+		"local n = 0 for i = 0, -3, -1 do n = n + 1 end return n":                            4.0,
+		"local last for i = 1, 2, 0.5 do last = i end return last":                           2.0,
+		"local n = 0 for i = 0, 1, 0.1 do n = n + 1 end return n":                            11.0, // Steps accumulate
+		"local n = 0 for i = '1', '3' do n = n + i end return n":                             6.0,
+		"local i = 'outer' for i = 1, 3 do end return i":                                     "outer",
+		"local s = '' for i = 1, 3 do i = i * 10 s = s .. i end return s":                    "102030",
+		"local n = 0 for i = 1, 10 do if i > 3 then break end n = i end return n":            3.0,
+		"local function f() for i = 1, 10 do if i == 4 then return i end end end return f()": 4.0,
+		"local n = 0 local limit = 3 for i = 1, limit do limit = 10 n = n + 1 end return n":  3.0,
+		"local n = 0 for i = 1, math.huge do n = i if i == 5 then break end end return n":    5.0,
+	})
+
+	expectErrors(t, map[string]string{
+		"for i = 'a', 2 do end":    "test:1: 'for' initial value must be a number",
+		"for i = 1, {} do end":     "test:1: 'for' limit must be a number",
+		"for i = 1, 2, nil do end": "test:1: 'for' step must be a number",
+	})
+}
+
+func TestMetatables(t *testing.T) {
+	expect(t, map[string]Value{
+		// __index tables provide defaults and inheritance chains.
+		"local t = setmetatable({}, {__index = {x = 1}}) return t.x": 1.0,
+		"local base = {greet = function(self) return 'hi ' .. self.name end}" +
+			" local obj = setmetatable({name = 'bob'}, {__index = base}) return obj:greet()": "hi bob",
+		"local a = {x = 'a'} local b = setmetatable({}, {__index = a})" +
+			" local c = setmetatable({}, {__index = b}) return c.x": "a",
+		"local t = setmetatable({x = 1}, {__index = function() return 2 end}) return t.x + t.y":   3.0,
+		"local t = setmetatable({}, {__index = function(t, k) return k .. '!' end}) return t.key": "key!",
+		"local t = setmetatable({}, {__index = {x = 1}}) return rawget(t, 'x')":                   nil,
+		// __newindex only runs for absent keys.
+		"local log = {} local t = setmetatable({}, {__newindex = function(t, k, v) rawset(t, k, v * 2) end})" +
+			" t.x = 1 t.x = 5 return t.x": 5.0,
+		"local store = {} local t = setmetatable({}, {__newindex = store})" +
+			" t.x = 1 return rawget(t, 'x') == nil and store.x": 1.0,
+		"local t = setmetatable({}, {__newindex = function() end}) rawset(t, 'x', 1) return t.x": 1.0,
+		// __call makes tables callable, receiving the table first.
+		"local t = setmetatable({n = 10}, {__call = function(self, a) return self.n + a end}) return t(5)": 15.0,
+		// Arithmetic and concatenation use the metatable of either operand.
+		"local mt = {__add = function(a, b) return a.v + b.v end}" +
+			" local a, b = setmetatable({v = 1}, mt), setmetatable({v = 2}, mt) return a + b": 3.0,
+		"local t = setmetatable({}, {__sub = function(a, b) return 'sub' end}) return 1 - t":       "sub",
+		"local t = setmetatable({}, {__mul = function() return 6 end}) return t * 2":               6.0,
+		"local t = setmetatable({}, {__div = function() return 'div' end}) return t / t":           "div",
+		"local t = setmetatable({}, {__mod = function() return 'mod' end}) return t % 2":           "mod",
+		"local t = setmetatable({}, {__pow = function() return 'pow' end}) return t ^ 2":           "pow",
+		"local t = setmetatable({v = 3}, {__unm = function(a) return -a.v end}) return -t":         -3.0,
+		"local t = setmetatable({}, {__concat = function(a, b) return 'cat' end}) return 'x' .. t": "cat",
+		// Comparisons need the same handler on both tables.
+		"local mt = {__eq = function() return true end}" +
+			" return setmetatable({}, mt) == setmetatable({}, mt)": true,
+		"return setmetatable({}, {__eq = function() return true end}) == setmetatable({}, {})": false,
+		"local mt = {__eq = function() return true end}" +
+			" local a = setmetatable({}, mt) return a ~= setmetatable({}, mt)": false,
+		"local mt = {__lt = function(a, b) return a.v < b.v end}" +
+			" local a, b = setmetatable({v = 1}, mt), setmetatable({v = 2}, mt) return a < b and b > a": true,
+		// Without __le, a <= b is not (b < a).
+		"local mt = {__lt = function(a, b) return a.v < b.v end}" +
+			" local a, b = setmetatable({v = 1}, mt), setmetatable({v = 1}, mt) return a <= b and a >= b": true,
+		"local mt = {__le = function(a, b) return false end}" +
+			" local a, b = setmetatable({}, mt), setmetatable({}, mt) return a <= b": false,
+		// __tostring and the metatable functions.
+		"return tostring(setmetatable({}, {__tostring = function() return 'obj' end}))": "obj",
+		"local mt = {} return getmetatable(setmetatable({}, mt)) == mt":                 true,
+		"return getmetatable({})": nil,
+		"return getmetatable(setmetatable({}, {__metatable = 'locked'}))":            "locked",
+		"local t = setmetatable({}, {}) setmetatable(t, nil) return getmetatable(t)": nil,
+		// Length and iteration are raw.
+		"local t = setmetatable({}, {__index = {1, 2, 3}}) return #t":                                 0.0,
+		"local n = 0 for _ in pairs(setmetatable({}, {__index = {a = 1}})) do n = n + 1 end return n": 0.0,
+	})
+
+	expectErrors(t, map[string]string{
+		"setmetatable(setmetatable({}, {__metatable = 1}), {})":            "test:1: cannot change a protected metatable",
+		"local a = {} setmetatable(a, {__index = a}) return a.x":           "test:1: loop in gettable",
+		"local a = {} setmetatable(a, {__newindex = a}) a.x = 1":           "test:1: loop in settable",
+		"return setmetatable({}, {__lt = function() end}) < {}":            "test:1: attempt to compare two table values",
+		"return tostring(setmetatable({}, {__tostring = next}))":           "test:1: '__tostring' must return a string",
+		"return setmetatable({}, {__index = function() error('x') end}).x": "test:1: x",
+
+		"local t = setmetatable({}, {}) t()": "test:1: attempt to call local 't' (a table value)",
+		"return setmetatable({}, {}) + 1":    "test:1: attempt to perform arithmetic on a table value",
+		"setmetatable({}, 1)":                "test:1: bad argument #2 to 'setmetatable' (nil or table expected, got number)",
+	})
+}
+
+func TestStringLibraryEdgeCases(t *testing.T) {
+	expect(t, map[string]Value{
+		"return string.sub('hello', 0)":                               "hello",
+		"return string.sub('hello', 10)":                              "",
+		"return string.sub('hello', -100, 2)":                         "he",
+		"return string.sub('hello', 3, 2)":                            "",
+		"return string.byte('abc', -1)":                               99.0,
+		"return select('#', string.byte('abc', 1, -1))":               3.0,
+		"return string.byte('')":                                      nil,
+		"return string.len('a\\0b')":                                  3.0,
+		"return string.rep('x', 0)":                                   "",
+		"return string.rep('x', -1)":                                  "",
+		"return string.find('abc', '')":                               1.0,
+		"return string.find('abc', 'b', -1)":                          nil,
+		"return string.find('a+b', '+', 1, true)":                     2.0,
+		"return string.find('abc', '(b)(c)')":                         2.0,
+		"return select(3, string.find('abc', '(b)(c)'))":              "b",
+		"return string.match('2024-01-02', '(%d+)-(%d+)-(%d+)')":      "2024",
+		"return string.match('abc', '()b()')":                         2.0,
+		"return string.match('hello', '^h.-o$')":                      "hello",
+		"return string.match('[test]', '%[(.*)%]')":                   "test",
+		"return string.match('aaa', 'a-')":                            "",
+		"return string.match('key=val', '[^=]+$')":                    "val",
+		"return string.match('x', 'y')":                               nil,
+		"return string.match('  x', '%S')":                            "x",
+		"return string.match('f(a,b)', '%((.-)%)')":                   "a,b",
+		"return string.match('THE (quick) fox', '%f[%a]%a+')":         "THE",
+		"return (string.gsub('hello', '', '-'))":                      "-h-e-l-l-o-",
+		"return select(2, string.gsub('aaa', 'a', 'b', 2))":           2.0,
+		"return (string.gsub('abc', 'b', '%%'))":                      "a%c",
+		"return (string.gsub('hello world', '(%w+) (%w+)', '%2 %1'))": "world hello",
+		"return (string.gsub('abc', '%w', {a = 1, b = false}))":       "1bc",
+		"return (string.gsub('abc', '%w', function(c)" +
+			" if c == 'b' then return false end return 'x' end))": "xbx",
+		"return string.format('%5s|%-5s|', 'a', 'b')":      "    a|b    |",
+		"return string.format('%03d %+d %.3f', 7, 5, 1/3)": "007 +5 0.333",
+		"return string.format('%g %g', 1e20, 0.1)":         "1e+20 0.1",
+		"return string.format('%c%c', 72, 105)":            "Hi",
+		"return string.format('%%')":                       "%",
+		"return string.format('%s %s', 1, true)":           "1 true",
+		"return string.format('%q', 'a\\nb')":              "\"a\\\nb\"",
+		"return ('%d'):format(3)":                          "3",
+		"return ('x'):rep(3, nil)":                         "xxx",
+		"return #string.upper('ção')":                      5.0,
+		"return tostring(1e15) .. ' ' .. tostring(-0.5)":   "1e+15 -0.5",
+		"return tostring(2^53)":                            "9.007199254741e+15",
+		"return tonumber('  10  ') + tonumber('1e1')":      20.0,
+		"return tonumber('10', 2)":                         2.0,
+		"return tonumber('')":                              nil,
+		"return tonumber('0x')":                            nil,
+		"return 10 == '10'":                                false,
+		"return 'a' < 'b' and 'Z' < 'a' and '' < 'a'":      true,
+	})
+}
+
+func TestErrorValues(t *testing.T) {
+	expect(t, map[string]Value{
+		// pcall returns false and the error value, positioned for strings.
+		"return select('#', pcall(error))":                            2.0,
+		"local ok, err = pcall(error) return err":                     nil,
+		"local ok, err = pcall(error, 'msg', 2) return err":           "test:1: msg",
+		"local ok, err = pcall(error, 42) return err":                 42.0,
+		"local ok, a, b = pcall(function() return 1, 2 end) return b": 2.0,
+		"local ok, err = pcall(function() local t = {} t.x.y = 1 end)" +
+			" return err": "test:1: attempt to index field 'x' (a nil value)",
+		"local ok, err = pcall(string.rep) return err": "test:1: bad argument #1 to 'rep' (string expected, got no value)",
+		"local ok, err = pcall(42) return err":         "attempt to call a number value",
+		// Errors propagate through nested pcalls until caught.
+		`local function inner() error({code = 1}) end
+		 local function outer() local ok, err = pcall(inner) error({code = err.code + 1}) end
+		 local ok, err = pcall(outer) return err.code`: 2.0,
+		// Execution continues normally after a caught error.
+		`local n = 0 for i = 1, 3 do if pcall(function() if i == 2 then error('x') end end) then n = n + 1 end end
+		 return n`: 2.0,
+		"local ok, err = pcall(assert, false) return err":          "assertion failed!",
+		"local ok, err = pcall(assert, nil, {1}) return type(err)": "table",
+		"return select('#', assert(1, 2, 3))":                      3.0,
+	})
+
+	expectErrors(t, map[string]string{
+		"local s = 'x' return s.y.z":                  "test:1: attempt to index field 'y' (a nil value)",
+		"local x = 5 return x()":                      "test:1: attempt to call local 'x' (a number value)",
+		"local function f() return nothere.x end f()": "test:1: attempt to index global 'nothere' (a nil value)",
+		"return -{}":                 "test:1: attempt to perform arithmetic on a table value",
+		"return #5":                  "test:1: attempt to get length of a number value",
+		"return {} < {}":             "test:1: attempt to compare two table values",
+		"return 'a' + 1":             "test:1: attempt to perform arithmetic on a string value",
+		"return select(0, 'a')":      "test:1: bad argument #1 to 'select' (index out of range)",
+		"local t = {} t[0/0] = 1":    "test:1: table index is NaN",
+		"for k in pairs(nil) do end": "test:1: bad argument #1 to 'pairs' (table expected, got nil)",
+		"error()":                    "nil",
+	})
+}
@@ -0,0 +1,169 @@
+package lua
+
+import "errors"
+
+// Metatables change how tables behave in operations. As in Lua 5.1, only
+// tables have metatables, and the supported events are __index,
+// __newindex, __call, the arithmetic events (__add, __sub, __mul, __div,
+// __mod, __pow, __unm), __concat, __eq, __lt, __le and __tostring.
+// Length, pairs and ipairs are raw.
+
+// maxMetaChain bounds chains of __index and __newindex tables, so that a
+// loop of tables fails instead of hanging.
+const maxMetaChain = 100
+
+// arithEvents maps binary operators to their metamethods.
+var arithEvents = map[string]string{
+	"+": "__add",
+	"-": "__sub",
+	"*": "__mul",
+	"/": "__div",
+	"%": "__mod",
+	"^": "__pow",
+}
+
+// metamethod returns the handler of event in the metatable of v, or nil.
+func metamethod(v Value, event string) Value {
+	t, ok := v.(*Table)
+	if !ok || t.meta == nil {
+		return nil
+	}
+	return t.meta.Get(event)
+}
+
+// callMeta calls a metamethod and returns its first result.
+func (s *State) callMeta(handler Value, line int, args ...Value) (Value, error) {
+	results, err := s.callValue(handler, args, nil, line)
+	if err != nil || len(results) == 0 {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// binaryMeta calls the handler of event in the metatable of left or, if it
+// has none, of right. It reports whether either had one.
+func (s *State) binaryMeta(event string, left, right Value, line int) (Value, bool, error) {
+	handler := metamethod(left, event)
+	if handler == nil {
+		handler = metamethod(right, event)
+	}
+	if handler == nil {
+		return nil, false, nil
+	}
+	v, err := s.callMeta(handler, line, left, right)
+	return v, true, err
+}
+
+// comparisonMeta calls the handler of event for two tables, which Lua 5.1
+// only does when both tables have the same handler.
+func (s *State) comparisonMeta(event string, left, right Value, line int) (Value, bool, error) {
+	handler := metamethod(left, event)
+	if handler == nil || handler != metamethod(right, event) {
+		return nil, false, nil
+	}
+	v, err := s.callMeta(handler, line, left, right)
+	return Truthy(v), true, err
+}
+
+// equal compares values with ==, calling __eq for distinct tables.
+func (s *State) equal(left, right Value, line int) (bool, error) {
+	if left == right {
+		return true, nil
+	}
+	if _, ok := left.(*Table); !ok {
+		return false, nil
+	}
+	if _, ok := right.(*Table); !ok {
+		return false, nil
+	}
+	v, _, err := s.comparisonMeta("__eq", left, right, line)
+	return Truthy(v), err
+}
+
+// lessMeta implements < and <= for tables with __lt and __le. Without
+// __le, a <= b is computed as not (b < a).
+func (s *State) lessMeta(orEqual bool, left, right Value, line int) (Value, bool, error) {
+	if !orEqual {
+		return s.comparisonMeta("__lt", left, right, line)
+	}
+	if v, ok, err := s.comparisonMeta("__le", left, right, line); ok || err != nil {
+		return v, ok, err
+	}
+	v, ok, err := s.comparisonMeta("__lt", right, left, line)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	return !Truthy(v), true, nil
+}
+
+// setIndex assigns obj[key] = v, following __newindex when the key is
+// absent.
+func (s *State) setIndex(obj, key, v Value, objExpr expr, line int) error {
+	for i := 0; i < maxMetaChain; i++ {
+		t, ok := obj.(*Table)
+		if !ok {
+			return s.operandError(line, "index", objExpr, obj)
+		}
+		handler := metamethod(t, "__newindex")
+		if handler == nil || t.Get(key) != nil {
+			if err := t.Set(key, v); err != nil {
+				return s.runtimeErrorf(line, "%s", err.Error())
+			}
+			return nil
+		}
+		if fn, ok := handler.(*Function); ok {
+			_, err := s.callValue(fn, []Value{t, key, v}, nil, line)
+			return err
+		}
+		obj, objExpr = handler, nil
+	}
+	return s.runtimeErrorf(line, "loop in settable")
+}
+
+// toString converts v to a string as tostring does, calling __tostring.
+func (s *State) toString(v Value) (string, error) {
+	handler := metamethod(v, "__tostring")
+	if handler == nil {
+		return ToString(v), nil
+	}
+	str, err := s.callMeta(handler, s.line, v)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := str.(string); !ok {
+		return "", errors.New("'__tostring' must return a string")
+	}
+	return ToString(str), nil
+}
+
+// Metatable functions.
+
+func baseGetMetatable(s *State, args []Value) ([]Value, error) {
+	if err := checkAny(args, 1, "getmetatable"); err != nil {
+		return nil, err
+	}
+	t, ok := args[0].(*Table)
+	if !ok || t.meta == nil {
+		return one(nil)
+	}
+	if protected := t.meta.Get("__metatable"); protected != nil {
+		return one(protected)
+	}
+	return one(t.meta)
+}
+
+func baseSetMetatable(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "setmetatable")
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := arg(args, 2).(*Table)
+	if !ok && arg(args, 2) != nil {
+		return nil, typeError(args, 2, "setmetatable", "nil or table")
+	}
+	if t.meta != nil && t.meta.Get("__metatable") != nil {
+		return nil, errors.New("cannot change a protected metatable")
+	}
+	t.meta = meta
+	return one(t)
+}
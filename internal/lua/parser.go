@@ -0,0 +1,870 @@
+package lua
+
+import "fmt"
+
+// maxLocals bounds the number of locals one function may declare.
+const maxLocals = 200
+
+// localVar is a local variable in scope during parsing.
+type localVar struct {
+	name string
+	slot int
+}
+
+// funcState tracks the function being parsed.
+type funcState struct {
+	parent     *funcState
+	proto      *funcProto
+	actives    []localVar // Locals in scope, innermost last
+	upvalNames []string   // Names of proto.upvals, by index
+	loops      int        // Enclosing loops, for validating break
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	fs     *funcState
+}
+
+// parse compiles source code into the prototype of its main function.
+func parse(name, src string) (*funcProto, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	proto := &funcProto{name: name, isVararg: true}
+	p.fs = &funcState{proto: proto}
+
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf("'<eof>' expected near '%s'", tok.text)
+	}
+	proto.body = body
+	return proto, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(n int) token {
+	if p.pos+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+n]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// check reports whether the next token is the given symbol or keyword.
+func (p *parser) check(text string) bool {
+	tok := p.peek()
+	return (tok.kind == tokSymbol || tok.kind == tokKeyword) && tok.text == text
+}
+
+// accept consumes the next token if it is the given symbol or keyword.
+func (p *parser) accept(text string) bool {
+	if p.check(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("'%s' expected near '%s'", text, p.near())
+	}
+	return nil
+}
+
+func (p *parser) expectName() (string, error) {
+	tok := p.peek()
+	if tok.kind != tokName {
+		return "", p.errorf("<name> expected near '%s'", p.near())
+	}
+	p.pos++
+	return tok.text, nil
+}
+
+// near describes the next token for error messages.
+func (p *parser) near() string {
+	tok := p.peek()
+	if tok.kind == tokEOF {
+		return "<eof>"
+	}
+	return tok.text
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: p.peek().line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// declare brings a new local into scope and returns its slot.
+func (p *parser) declare(name string) (int, error) {
+	fs := p.fs
+	if fs.proto.nslots >= maxLocals {
+		return 0, p.errorf("too many local variables")
+	}
+	slot := fs.proto.nslots
+	fs.proto.nslots++
+	fs.actives = append(fs.actives, localVar{name: name, slot: slot})
+	return slot, nil
+}
+
+// resolve finds the variable a name refers to in the current function.
+func (p *parser) resolve(name string) expr {
+	return resolveIn(p.fs, name)
+}
+
+func resolveIn(fs *funcState, name string) expr {
+	for i := len(fs.actives) - 1; i >= 0; i-- {
+		if fs.actives[i].name == name {
+			return &localExpr{slot: fs.actives[i].slot, name: name}
+		}
+	}
+	for i, upval := range fs.upvalNames {
+		if upval == name {
+			return &upvalExpr{index: i, name: name}
+		}
+	}
+	if fs.parent == nil {
+		return &globalExpr{name: name}
+	}
+
+	var desc upvalDesc
+	switch outer := resolveIn(fs.parent, name).(type) {
+	case *localExpr:
+		desc = upvalDesc{fromLocal: true, index: outer.slot}
+	case *upvalExpr:
+		desc = upvalDesc{index: outer.index}
+	default:
+		return outer
+	}
+	fs.proto.upvals = append(fs.proto.upvals, desc)
+	fs.upvalNames = append(fs.upvalNames, name)
+	return &upvalExpr{index: len(fs.upvalNames) - 1, name: name}
+}
+
+// blockEnd reports whether the next token ends a block.
+func (p *parser) blockEnd() bool {
+	tok := p.peek()
+	if tok.kind == tokEOF {
+		return true
+	}
+	if tok.kind != tokKeyword {
+		return false
+	}
+	switch tok.text {
+	case "end", "else", "elseif", "until":
+		return true
+	}
+	return false
+}
+
+// block parses statements in a new scope.
+func (p *parser) block() ([]stmt, error) {
+	scope := len(p.fs.actives)
+	defer func() { p.fs.actives = p.fs.actives[:scope] }()
+	return p.statements()
+}
+
+// statements parses statements up to the end of the current block.
+func (p *parser) statements() ([]stmt, error) {
+	var body []stmt
+	for !p.blockEnd() {
+		if p.check("return") {
+			ret, err := p.returnStatement()
+			if err != nil {
+				return nil, err
+			}
+			return append(body, ret), nil
+		}
+
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			body = append(body, s)
+		}
+	}
+	return body, nil
+}
+
+func (p *parser) returnStatement() (stmt, error) {
+	p.advance()
+	ret := &returnStmt{}
+	if !p.blockEnd() && !p.check(";") {
+		exprs, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		ret.exprs = exprs
+	}
+	p.accept(";")
+	if !p.blockEnd() {
+		return nil, p.errorf("'end' expected near '%s'", p.near())
+	}
+	return ret, nil
+}
+
+func (p *parser) statement() (stmt, error) {
+	tok := p.peek()
+	if tok.kind == tokSymbol && tok.text == ";" {
+		p.advance()
+		return nil, nil
+	}
+	if tok.kind != tokKeyword {
+		return p.exprStatement()
+	}
+
+	switch tok.text {
+	case "if":
+		return p.ifStatement()
+	case "while":
+		p.advance()
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("do"); err != nil {
+			return nil, err
+		}
+		body, err := p.loopBody("end")
+		if err != nil {
+			return nil, err
+		}
+		return &whileStmt{cond: cond, body: body}, nil
+	case "do":
+		p.advance()
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("end"); err != nil {
+			return nil, err
+		}
+		return &doStmt{body: body}, nil
+	case "for":
+		return p.forStatement()
+	case "repeat":
+		return p.repeatStatement()
+	case "function":
+		return p.functionStatement()
+	case "local":
+		p.advance()
+		if p.accept("function") {
+			return p.localFunction()
+		}
+		return p.localStatement()
+	case "break":
+		p.advance()
+		if p.fs.loops == 0 {
+			return nil, p.errorf("no loop to break")
+		}
+		return &breakStmt{}, nil
+	}
+	return nil, p.errorf("unexpected symbol near '%s'", tok.text)
+}
+
+// loopBody parses the block of a loop up to the closing keyword.
+func (p *parser) loopBody(closing string) ([]stmt, error) {
+	p.fs.loops++
+	body, err := p.block()
+	p.fs.loops--
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(closing); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (p *parser) ifStatement() (stmt, error) {
+	s := &ifStmt{}
+	p.advance()
+	for {
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		s.conds = append(s.conds, cond)
+		s.blocks = append(s.blocks, body)
+
+		if !p.accept("elseif") {
+			break
+		}
+	}
+
+	if p.accept("else") {
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		s.elseBody = body
+	}
+	if err := p.expect("end"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (p *parser) forStatement() (stmt, error) {
+	line := p.advance().line
+	first, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	scope := len(p.fs.actives)
+	defer func() { p.fs.actives = p.fs.actives[:scope] }()
+
+	if p.accept("=") {
+		s := &numForStmt{line: line}
+		if s.start, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		if s.limit, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if p.accept(",") {
+			if s.step, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect("do"); err != nil {
+			return nil, err
+		}
+		if s.slot, err = p.declare(first); err != nil {
+			return nil, err
+		}
+		if s.body, err = p.loopBody("end"); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	names := []string{first}
+	for p.accept(",") {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+
+	s := &genForStmt{line: line}
+	if s.exprs, err = p.exprList(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		slot, err := p.declare(name)
+		if err != nil {
+			return nil, err
+		}
+		s.slots = append(s.slots, slot)
+	}
+	if s.body, err = p.loopBody("end"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// repeatStatement parses repeat ... until cond. The condition can see the
+// locals of the body.
+func (p *parser) repeatStatement() (stmt, error) {
+	p.advance()
+	scope := len(p.fs.actives)
+	defer func() { p.fs.actives = p.fs.actives[:scope] }()
+
+	p.fs.loops++
+	body, err := p.statements()
+	p.fs.loops--
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("until"); err != nil {
+		return nil, err
+	}
+	cond, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return &repeatStmt{body: body, cond: cond}, nil
+}
+
+// functionStatement parses function a.b.c:m(...) ... end.
+func (p *parser) functionStatement() (stmt, error) {
+	line := p.advance().line
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	fullName := name
+	target := p.resolve(name)
+	method := false
+	for p.check(".") || p.check(":") {
+		method = p.advance().text == ":"
+		field, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		fullName += "." + field
+		target = &indexExpr{obj: target, key: &stringExpr{value: field}, line: line}
+		if method {
+			break
+		}
+	}
+
+	proto, err := p.functionBody(fullName, method)
+	if err != nil {
+		return nil, err
+	}
+	return &assignStmt{targets: []expr{target}, exprs: []expr{&functionExpr{proto: proto}}}, nil
+}
+
+// localFunction parses local function name(...) ... end. The name is in
+// scope inside the body so the function can call itself.
+func (p *parser) localFunction() (stmt, error) {
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	slot, err := p.declare(name)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := p.functionBody(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &localFunctionStmt{slot: slot, proto: proto}, nil
+}
+
+// localStatement parses local a, b = exprs. The new locals come into scope
+// after the expressions.
+func (p *parser) localStatement() (stmt, error) {
+	var names []string
+	for {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	s := &localStmt{}
+	if p.accept("=") {
+		exprs, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		s.exprs = exprs
+	}
+	for _, name := range names {
+		slot, err := p.declare(name)
+		if err != nil {
+			return nil, err
+		}
+		s.slots = append(s.slots, slot)
+	}
+	return s, nil
+}
+
+// exprStatement parses a function call or an assignment.
+func (p *parser) exprStatement() (stmt, error) {
+	first, err := p.suffixedExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.check("=") && !p.check(",") {
+		switch first.(type) {
+		case *callExpr, *methodCallExpr:
+			return &callStmt{call: first}, nil
+		}
+		return nil, p.errorf("syntax error near '%s'", p.near())
+	}
+
+	targets := []expr{first}
+	for p.accept(",") {
+		target, err := p.suffixedExpr()
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	for _, target := range targets {
+		switch target.(type) {
+		case *localExpr, *upvalExpr, *globalExpr, *indexExpr:
+		default:
+			return nil, p.errorf("syntax error near '%s'", p.near())
+		}
+	}
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	exprs, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	return &assignStmt{targets: targets, exprs: exprs}, nil
+}
+
+// functionBody parses a parameter list and body into a new prototype.
+// Methods get an implicit self parameter.
+func (p *parser) functionBody(name string, method bool) (*funcProto, error) {
+	proto := &funcProto{name: name}
+	fs := &funcState{parent: p.fs, proto: proto}
+	p.fs = fs
+	defer func() { p.fs = fs.parent }()
+
+	if method {
+		slot, err := p.declare("self")
+		if err != nil {
+			return nil, err
+		}
+		proto.params = append(proto.params, slot)
+	}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if !p.check(")") {
+		for {
+			if p.accept("...") {
+				proto.isVararg = true
+				break
+			}
+			param, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			slot, err := p.declare(param)
+			if err != nil {
+				return nil, err
+			}
+			proto.params = append(proto.params, slot)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("end"); err != nil {
+		return nil, err
+	}
+	proto.body = body
+	return proto, nil
+}
+
+func (p *parser) exprList() ([]expr, error) {
+	var exprs []expr
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+		if !p.accept(",") {
+			return exprs, nil
+		}
+	}
+}
+
+// binaryPriority gives the left and right binding power of binary operators.
+var binaryPriority = map[string][2]int{
+	"or": {1, 1}, "and": {2, 2},
+	"<": {3, 3}, ">": {3, 3}, "<=": {3, 3}, ">=": {3, 3}, "~=": {3, 3}, "==": {3, 3},
+	"..": {5, 4},
+	"+":  {6, 6}, "-": {6, 6},
+	"*": {7, 7}, "/": {7, 7}, "%": {7, 7},
+	"^": {10, 9},
+}
+
+// unaryPriority binds tighter than everything but exponentiation, so -x^2
+// is -(x^2).
+const unaryPriority = 8
+
+func (p *parser) expr() (expr, error) {
+	return p.subExpr(0)
+}
+
+// subExpr parses an expression whose binary operators bind tighter than
+// limit.
+func (p *parser) subExpr(limit int) (expr, error) {
+	var left expr
+	tok := p.peek()
+	if (tok.kind == tokKeyword && tok.text == "not") ||
+		(tok.kind == tokSymbol && (tok.text == "-" || tok.text == "#")) {
+		p.advance()
+		x, err := p.subExpr(unaryPriority)
+		if err != nil {
+			return nil, err
+		}
+		left = foldUnary(tok.text, x, tok.line)
+	} else {
+		var err error
+		if left, err = p.simpleExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		tok := p.peek()
+		if tok.kind != tokSymbol && tok.kind != tokKeyword {
+			return left, nil
+		}
+		prio, ok := binaryPriority[tok.text]
+		if !ok || prio[0] <= limit {
+			return left, nil
+		}
+		p.advance()
+
+		right, err := p.subExpr(prio[1])
+		if err != nil {
+			return nil, err
+		}
+		switch tok.text {
+		case "and":
+			left = &andExpr{left: left, right: right}
+		case "or":
+			left = &orExpr{left: left, right: right}
+		default:
+			left = &binaryExpr{op: tok.text, left: left, right: right, line: tok.line}
+		}
+	}
+}
+
+// foldUnary builds a unary expression, folding negated number literals so
+// that -1 is a constant.
+func foldUnary(op string, x expr, line int) expr {
+	if n, ok := x.(*numberExpr); ok && op == "-" {
+		return &numberExpr{value: -n.value}
+	}
+	return &unaryExpr{op: op, x: x, line: line}
+}
+
+func (p *parser) simpleExpr() (expr, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokNumber:
+		p.advance()
+		return &numberExpr{value: tok.num}, nil
+	case tokString:
+		p.advance()
+		return &stringExpr{value: tok.text}, nil
+	case tokKeyword:
+		switch tok.text {
+		case "nil":
+			p.advance()
+			return &nilExpr{}, nil
+		case "true":
+			p.advance()
+			return &trueExpr{}, nil
+		case "false":
+			p.advance()
+			return &falseExpr{}, nil
+		case "function":
+			p.advance()
+			proto, err := p.functionBody("anonymous", false)
+			if err != nil {
+				return nil, err
+			}
+			return &functionExpr{proto: proto}, nil
+		}
+	case tokSymbol:
+		switch tok.text {
+		case "...":
+			if !p.fs.proto.isVararg {
+				return nil, p.errorf("cannot use '...' outside a vararg function")
+			}
+			p.advance()
+			return &varargExpr{}, nil
+		case "{":
+			return p.tableConstructor()
+		}
+	}
+	return p.suffixedExpr()
+}
+
+func (p *parser) primaryExpr() (expr, error) {
+	tok := p.peek()
+	if tok.kind == tokName {
+		p.advance()
+		return p.resolve(tok.text), nil
+	}
+	if p.accept("(") {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &parenExpr{x: x}, nil
+	}
+	return nil, p.errorf("unexpected symbol near '%s'", p.near())
+}
+
+// suffixedExpr parses a primary expression followed by field accesses,
+// indexing and calls.
+func (p *parser) suffixedExpr() (expr, error) {
+	x, err := p.primaryExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		switch {
+		case p.check("."):
+			p.advance()
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			x = &indexExpr{obj: x, key: &stringExpr{value: name}, line: tok.line}
+		case p.check("["):
+			p.advance()
+			key, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &indexExpr{obj: x, key: key, line: tok.line}
+		case p.check(":"):
+			p.advance()
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			x = &methodCallExpr{obj: x, name: name, args: args, line: tok.line}
+		case p.check("(") || p.check("{") || tok.kind == tokString:
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			x = &callExpr{fn: x, args: args, line: tok.line}
+		default:
+			return x, nil
+		}
+	}
+}
+
+// callArgs parses (args), a table constructor or a string literal.
+func (p *parser) callArgs() ([]expr, error) {
+	tok := p.peek()
+	if tok.kind == tokString {
+		p.advance()
+		return []expr{&stringExpr{value: tok.text}}, nil
+	}
+	if p.check("{") {
+		table, err := p.tableConstructor()
+		if err != nil {
+			return nil, err
+		}
+		return []expr{table}, nil
+	}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if p.accept(")") {
+		return nil, nil
+	}
+	args, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+func (p *parser) tableConstructor() (expr, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	table := &tableExpr{}
+	for !p.check("}") {
+		var item tableItem
+		var err error
+		switch {
+		case p.check("["):
+			p.advance()
+			if item.key, err = p.expr(); err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+		case p.peek().kind == tokName && p.peekAt(1).kind == tokSymbol && p.peekAt(1).text == "=":
+			item.key = &stringExpr{value: p.advance().text}
+			p.advance()
+		}
+		if item.value, err = p.expr(); err != nil {
+			return nil, err
+		}
+		table.items = append(table.items, item)
+
+		if !p.accept(",") && !p.accept(";") {
+			break
+		}
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
@@ -0,0 +1,412 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Lua patterns, as used by string.find, match, gmatch and gsub. This
+// follows the reference implementation's backtracking matcher.
+
+const (
+	maxCaptures     = 32
+	maxMatchDepth   = 200
+	capUnfinished   = -1
+	capPosition     = -2
+	patternEscape   = '%'
+	patternSpecials = "^$*+?.([%-"
+)
+
+type capture struct {
+	init, len int
+}
+
+type matchState struct {
+	src, pat string
+	level    int
+	captures [maxCaptures]capture
+	depth    int
+	err      error
+}
+
+func (ms *matchState) fail(format string, args ...interface{}) int {
+	if ms.err == nil {
+		ms.err = fmt.Errorf(format, args...)
+	}
+	return -1
+}
+
+// reset prepares for a new match attempt.
+func (ms *matchState) reset() {
+	ms.level = 0
+	ms.depth = 0
+}
+
+// classEnd returns the index just past the single-character class at p.
+func (ms *matchState) classEnd(p int) int {
+	pat := ms.pat
+	c := pat[p]
+	p++
+	if c == patternEscape {
+		if p >= len(pat) {
+			return ms.fail("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	}
+	if c != '[' {
+		return p
+	}
+
+	if p < len(pat) && pat[p] == '^' {
+		p++
+	}
+	for {
+		if p >= len(pat) {
+			return ms.fail("malformed pattern (missing ']')")
+		}
+		c := pat[p]
+		p++
+		if c == patternEscape && p < len(pat) {
+			p++
+		}
+		if p >= len(pat) {
+			return ms.fail("malformed pattern (missing ']')")
+		}
+		if pat[p] == ']' {
+			return p + 1
+		}
+	}
+}
+
+func isAlpha(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+func isLower(c byte) bool { return c >= 'a' && c <= 'z' }
+func isUpper(c byte) bool { return c >= 'A' && c <= 'Z' }
+func isSpace(c byte) bool { return c == ' ' || (c >= '\t' && c <= '\r') }
+func isCntrl(c byte) bool { return c < 32 || c == 127 }
+func isPunct(c byte) bool { return c > 32 && c < 127 && !isAlpha(c) && !isDigit(c) }
+func toLower(c byte) byte {
+	if isUpper(c) {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// matchClass reports whether c belongs to the class %cl. Upper-case class
+// letters negate the class; other characters match themselves.
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch toLower(cl) {
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = isCntrl(c)
+	case 'd':
+		res = isDigit(c)
+	case 'l':
+		res = isLower(c)
+	case 'p':
+		res = isPunct(c)
+	case 's':
+		res = isSpace(c)
+	case 'u':
+		res = isUpper(c)
+	case 'w':
+		res = isAlpha(c) || isDigit(c)
+	case 'x':
+		res = isHexDigit(c)
+	case 'z':
+		res = c == 0
+	default:
+		return cl == c
+	}
+	if isUpper(cl) {
+		return !res
+	}
+	return res
+}
+
+// matchBracketClass matches c against the set [...] from p to the closing
+// bracket at ec.
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	pat := ms.pat
+	sig := true
+	p++
+	if pat[p] == '^' {
+		sig = false
+		p++
+	}
+	for ; p < ec; p++ {
+		switch {
+		case pat[p] == patternEscape:
+			p++
+			if matchClass(c, pat[p]) {
+				return sig
+			}
+		case pat[p+1] == '-' && p+2 < ec:
+			if pat[p] <= c && c <= pat[p+2] {
+				return sig
+			}
+			p += 2
+		case pat[p] == c:
+			return sig
+		}
+	}
+	return !sig
+}
+
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true
+	case patternEscape:
+		return matchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	}
+	return ms.pat[p] == c
+}
+
+// match matches the pattern from p against the subject from s and returns
+// the end of the match, or -1.
+func (ms *matchState) match(s, p int) int {
+	ms.depth++
+	defer func() { ms.depth-- }()
+	if ms.depth > maxMatchDepth {
+		return ms.fail("pattern too complex")
+	}
+
+	pat := ms.pat
+	for ms.err == nil {
+		if p == len(pat) {
+			return s
+		}
+
+		switch pat[p] {
+		case '(':
+			if p+1 < len(pat) && pat[p+1] == ')' {
+				return ms.startCapture(s, p+2, capPosition)
+			}
+			return ms.startCapture(s, p+1, capUnfinished)
+		case ')':
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(pat) {
+				if s == len(ms.src) {
+					return s
+				}
+				return -1
+			}
+		case patternEscape:
+			if p+1 >= len(pat) {
+				return ms.fail("malformed pattern (ends with '%%')")
+			}
+			switch next := pat[p+1]; {
+			case next == 'b':
+				s = ms.matchBalance(s, p+2)
+				if s == -1 {
+					return -1
+				}
+				p += 4
+				continue
+			case next == 'f':
+				p += 2
+				if p >= len(pat) || pat[p] != '[' {
+					return ms.fail("missing '[' after '%%f' in pattern")
+				}
+				ep := ms.classEnd(p)
+				if ep == -1 {
+					return -1
+				}
+				var prev, cur byte
+				if s > 0 {
+					prev = ms.src[s-1]
+				}
+				if s < len(ms.src) {
+					cur = ms.src[s]
+				}
+				if ms.matchBracketClass(prev, p, ep-1) || !ms.matchBracketClass(cur, p, ep-1) {
+					return -1
+				}
+				p = ep
+				continue
+			case isDigit(next):
+				s = ms.matchCapture(s, next)
+				if s == -1 {
+					return -1
+				}
+				p += 2
+				continue
+			}
+		}
+
+		ep := ms.classEnd(p)
+		if ep == -1 {
+			return -1
+		}
+		m := ms.singleMatch(s, p, ep)
+		if ep < len(pat) {
+			switch pat[ep] {
+			case '?':
+				if m {
+					if r := ms.match(s+1, ep+1); r != -1 {
+						return r
+					}
+				}
+				p = ep + 1
+				continue
+			case '*':
+				return ms.maxExpand(s, p, ep)
+			case '+':
+				if !m {
+					return -1
+				}
+				return ms.maxExpand(s+1, p, ep)
+			case '-':
+				return ms.minExpand(s, p, ep)
+			}
+		}
+		if !m {
+			return -1
+		}
+		s++
+		p = ep
+	}
+	return -1
+}
+
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- {
+		if r := ms.match(s+i, ep+1); r != -1 {
+			return r
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if r := ms.match(s, ep+1); r != -1 {
+			return r
+		}
+		if !ms.singleMatch(s, p, ep) {
+			return -1
+		}
+		s++
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) int {
+	if ms.level >= maxCaptures {
+		return ms.fail("too many captures")
+	}
+	ms.captures[ms.level] = capture{init: s, len: what}
+	ms.level++
+	r := ms.match(s, p)
+	if r == -1 {
+		ms.level--
+	}
+	return r
+}
+
+func (ms *matchState) endCapture(s, p int) int {
+	l := -1
+	for i := ms.level - 1; i >= 0; i-- {
+		if ms.captures[i].len == capUnfinished {
+			l = i
+			break
+		}
+	}
+	if l == -1 {
+		return ms.fail("invalid pattern capture")
+	}
+	ms.captures[l].len = s - ms.captures[l].init
+	r := ms.match(s, p)
+	if r == -1 {
+		ms.captures[l].len = capUnfinished
+	}
+	return r
+}
+
+func (ms *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pat) {
+		return ms.fail("missing arguments to '%%b'")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1
+	}
+	open, close := ms.pat[p], ms.pat[p+1]
+	depth := 1
+	for i := s + 1; i < len(ms.src); i++ {
+		switch ms.src[i] {
+		case close:
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		case open:
+			depth++
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) matchCapture(s int, l byte) int {
+	i := int(l - '1')
+	if i < 0 || i >= ms.level || ms.captures[i].len == capUnfinished {
+		return ms.fail("invalid capture index")
+	}
+	c := ms.captures[i]
+	if c.len < 0 {
+		return -1
+	}
+	text := ms.src[c.init : c.init+c.len]
+	if len(ms.src)-s >= len(text) && ms.src[s:s+len(text)] == text {
+		return s + len(text)
+	}
+	return -1
+}
+
+// getCapture returns capture i of a match spanning s to e. Without
+// captures, capture 0 is the whole match.
+func (ms *matchState) getCapture(i, s, e int) (Value, error) {
+	if i >= ms.level {
+		if i == 0 {
+			return ms.src[s:e], nil
+		}
+		return nil, errors.New("invalid capture index")
+	}
+	c := ms.captures[i]
+	switch c.len {
+	case capUnfinished:
+		return nil, errors.New("unfinished capture")
+	case capPosition:
+		return float64(c.init + 1), nil
+	}
+	return ms.src[c.init : c.init+c.len], nil
+}
+
+// captureValues returns the captures of a match, or the whole match if the
+// pattern has none and whole is set.
+func (ms *matchState) captureValues(s, e int, whole bool) ([]Value, error) {
+	n := ms.level
+	if n == 0 && whole {
+		n = 1
+	}
+	values := make([]Value, n)
+	for i := range values {
+		v, err := ms.getCapture(i, s, e)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
@@ -0,0 +1,1073 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// openLibs registers the standard library in s.
+func openLibs(s *State) {
+	base := map[string]GoFunction{
+		"assert":       baseAssert,
+		"error":        baseError,
+		"getmetatable": baseGetMetatable,
+		"ipairs":       baseIPairs,
+		"next":         baseNext,
+		"pairs":        basePairs,
+		"pcall":        basePCall,
+		"rawequal":     baseRawEqual,
+		"rawget":       baseRawGet,
+		"rawset":       baseRawSet,
+		"select":       baseSelect,
+		"setmetatable": baseSetMetatable,
+		"tonumber":     baseToNumber,
+		"tostring":     baseToString,
+		"type":         baseType,
+		"unpack":       tableUnpack,
+	}
+	for name, fn := range base {
+		s.SetGlobal(name, NewFunction(name, fn))
+	}
+
+	s.strings = library(map[string]GoFunction{
+		"byte":    strByte,
+		"char":    strChar,
+		"find":    strFind,
+		"format":  strFormat,
+		"gmatch":  strGMatch,
+		"gsub":    strGSub,
+		"len":     strLen,
+		"lower":   strLower,
+		"match":   strMatch,
+		"rep":     strRep,
+		"reverse": strReverse,
+		"sub":     strSub,
+		"upper":   strUpper,
+	})
+	s.SetGlobal("string", s.strings)
+
+	s.SetGlobal("table", library(map[string]GoFunction{
+		"concat": tableConcat,
+		"getn":   tableGetN,
+		"insert": tableInsert,
+		"remove": tableRemove,
+		"sort":   tableSort,
+		"unpack": tableUnpack,
+	}))
+
+	mathLib := library(map[string]GoFunction{
+		"abs":   mathFunc("abs", math.Abs),
+		"ceil":  mathFunc("ceil", math.Ceil),
+		"exp":   mathFunc("exp", math.Exp),
+		"floor": mathFunc("floor", math.Floor),
+		"fmod":  mathFmod,
+		"log":   mathFunc("log", math.Log),
+		"log10": mathFunc("log10", math.Log10),
+		"max":   mathMax,
+		"min":   mathMin,
+		"modf":  mathModf,
+		"pow":   mathPow,
+		"sqrt":  mathFunc("sqrt", math.Sqrt),
+	})
+	_ = mathLib.Set("huge", math.Inf(1))
+	_ = mathLib.Set("pi", math.Pi)
+	s.SetGlobal("math", mathLib)
+}
+
+// library builds a table of Go functions.
+func library(funcs map[string]GoFunction) *Table {
+	t := NewTable()
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_ = t.Set(name, NewFunction(name, funcs[name]))
+	}
+	return t
+}
+
+// Argument helpers. They report errors in the reference implementation's
+// format; the interpreter adds the script position.
+
+func argError(n int, fname, msg string) error {
+	return fmt.Errorf("bad argument #%d to '%s' (%s)", n, fname, msg)
+}
+
+func typeError(args []Value, n int, fname, expected string) error {
+	got := "no value"
+	if n <= len(args) {
+		got = TypeName(args[n-1])
+	}
+	return argError(n, fname, expected+" expected, got "+got)
+}
+
+func arg(args []Value, n int) Value {
+	if n <= len(args) {
+		return args[n-1]
+	}
+	return nil
+}
+
+func checkAny(args []Value, n int, fname string) error {
+	if n > len(args) {
+		return argError(n, fname, "value expected")
+	}
+	return nil
+}
+
+func checkNumber(args []Value, n int, fname string) (float64, error) {
+	f, ok := ToNumber(arg(args, n))
+	if !ok {
+		return 0, typeError(args, n, fname, "number")
+	}
+	return f, nil
+}
+
+func checkInt(args []Value, n int, fname string) (int, error) {
+	f, err := checkNumber(args, n, fname)
+	if err != nil {
+		return 0, err
+	}
+	if f > math.MaxInt32 {
+		return math.MaxInt32, nil
+	}
+	if f < math.MinInt32 {
+		return math.MinInt32, nil
+	}
+	return int(f), nil
+}
+
+func optInt(args []Value, n int, fname string, def int) (int, error) {
+	if arg(args, n) == nil {
+		return def, nil
+	}
+	return checkInt(args, n, fname)
+}
+
+func checkString(args []Value, n int, fname string) (string, error) {
+	switch v := arg(args, n).(type) {
+	case string:
+		return v, nil
+	case float64:
+		return FormatNumber(v), nil
+	}
+	return "", typeError(args, n, fname, "string")
+}
+
+func checkTable(args []Value, n int, fname string) (*Table, error) {
+	t, ok := arg(args, n).(*Table)
+	if !ok {
+		return nil, typeError(args, n, fname, "table")
+	}
+	return t, nil
+}
+
+func one(v Value) ([]Value, error) {
+	return []Value{v}, nil
+}
+
+// Base functions.
+
+func baseAssert(s *State, args []Value) ([]Value, error) {
+	if err := checkAny(args, 1, "assert"); err != nil {
+		return nil, err
+	}
+	if Truthy(args[0]) {
+		return args, nil
+	}
+	if msg := arg(args, 2); msg != nil {
+		return nil, &RuntimeError{Value: msg}
+	}
+	return nil, &RuntimeError{Value: "assertion failed!"}
+}
+
+// baseError raises an error. String messages get the script position
+// unless level is 0.
+func baseError(s *State, args []Value) ([]Value, error) {
+	msg := arg(args, 1)
+	level, err := optInt(args, 2, "error", 1)
+	if err != nil {
+		return nil, err
+	}
+	if str, ok := msg.(string); ok && level > 0 {
+		msg = fmt.Sprintf("%s:%d: %s", s.chunkName, s.line, str)
+	}
+	return nil, &RuntimeError{Value: msg}
+}
+
+func baseIPairs(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "ipairs")
+	if err != nil {
+		return nil, err
+	}
+	iter := NewFunction("ipairs_iterator", func(s *State, args []Value) ([]Value, error) {
+		i, _ := ToNumber(arg(args, 2))
+		v := t.Get(i + 1)
+		if v == nil {
+			return one(nil)
+		}
+		return []Value{i + 1, v}, nil
+	})
+	return []Value{iter, t, 0.0}, nil
+}
+
+func baseNext(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "next")
+	if err != nil {
+		return nil, err
+	}
+	k, v, err := t.Next(arg(args, 2))
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return one(nil)
+	}
+	return []Value{k, v}, nil
+}
+
+func basePairs(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "pairs")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{s.Global("next"), t, nil}, nil
+}
+
+// basePCall calls a function in protected mode. Timeouts and stack
+// overflows end the script and are not caught.
+func basePCall(s *State, args []Value) ([]Value, error) {
+	if err := checkAny(args, 1, "pcall"); err != nil {
+		return nil, err
+	}
+	fn, ok := args[0].(*Function)
+	if !ok {
+		return []Value{false, "attempt to call a " + TypeName(args[0]) + " value"}, nil
+	}
+
+	results, err := s.Call(fn, args[1:])
+	switch {
+	case err == nil:
+		return append([]Value{true}, results...), nil
+	case err == ErrTimeout || err == ErrStackOverflow:
+		return nil, err
+	}
+	if rt, ok := s.positioned(err).(*RuntimeError); ok {
+		return []Value{false, rt.Value}, nil
+	}
+	return []Value{false, err.Error()}, nil
+}
+
+func baseRawEqual(s *State, args []Value) ([]Value, error) {
+	return one(arg(args, 1) == arg(args, 2))
+}
+
+func baseRawGet(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "rawget")
+	if err != nil {
+		return nil, err
+	}
+	return one(t.Get(arg(args, 2)))
+}
+
+func baseRawSet(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "rawset")
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Set(arg(args, 2), arg(args, 3)); err != nil {
+		return nil, err
+	}
+	return one(t)
+}
+
+func baseSelect(s *State, args []Value) ([]Value, error) {
+	if arg(args, 1) == "#" {
+		return one(float64(len(args) - 1))
+	}
+	n, err := checkInt(args, 1, "select")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case n < 0:
+		n += len(args)
+	case n == 0:
+		return nil, argError(1, "select", "index out of range")
+	}
+	if n < 1 {
+		return nil, argError(1, "select", "index out of range")
+	}
+	if n >= len(args) {
+		return nil, nil
+	}
+	return args[n:], nil
+}
+
+func baseToNumber(s *State, args []Value) ([]Value, error) {
+	if err := checkAny(args, 1, "tonumber"); err != nil {
+		return nil, err
+	}
+	base, err := optInt(args, 2, "tonumber", 10)
+	if err != nil {
+		return nil, err
+	}
+	if base == 10 {
+		if f, ok := ToNumber(args[0]); ok {
+			return one(f)
+		}
+		return one(nil)
+	}
+
+	if base < 2 || base > 36 {
+		return nil, argError(2, "tonumber", "base out of range")
+	}
+	str, err := checkString(args, 1, "tonumber")
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseInt(strings.ToLower(strings.TrimSpace(str)), base, 64)
+	if err != nil {
+		return one(nil)
+	}
+	return one(float64(n))
+}
+
+func baseToString(s *State, args []Value) ([]Value, error) {
+	if err := checkAny(args, 1, "tostring"); err != nil {
+		return nil, err
+	}
+	str, err := s.toString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return one(str)
+}
+
+func baseType(s *State, args []Value) ([]Value, error) {
+	if err := checkAny(args, 1, "type"); err != nil {
+		return nil, err
+	}
+	return one(TypeName(args[0]))
+}
+
+// String library. Positions are 1-based and negative positions count from
+// the end, as in the reference implementation.
+
+// strRange converts Lua positions i..j to a Go slice range of a string of
+// length n.
+func strRange(i, j, n int) (int, int) {
+	if i < 0 {
+		i += n + 1
+	}
+	if j < 0 {
+		j += n + 1
+	}
+	if i < 1 {
+		i = 1
+	}
+	if j > n {
+		j = n
+	}
+	if i > j {
+		return 0, 0
+	}
+	return i - 1, j
+}
+
+func strByte(s *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 1, "byte")
+	if err != nil {
+		return nil, err
+	}
+	i, err := optInt(args, 2, "byte", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := optInt(args, 3, "byte", i)
+	if err != nil {
+		return nil, err
+	}
+	start, end := strRange(i, j, len(str))
+	var results []Value
+	for k := start; k < end; k++ {
+		results = append(results, float64(str[k]))
+	}
+	return results, nil
+}
+
+func strChar(s *State, args []Value) ([]Value, error) {
+	buf := make([]byte, len(args))
+	for i := range args {
+		c, err := checkInt(args, i+1, "char")
+		if err != nil {
+			return nil, err
+		}
+		if c < 0 || c > 255 {
+			return nil, argError(i+1, "char", "invalid value")
+		}
+		buf[i] = byte(c)
+	}
+	return one(string(buf))
+}
+
+func strLen(s *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 1, "len")
+	if err != nil {
+		return nil, err
+	}
+	return one(float64(len(str)))
+}
+
+func strLower(s *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 1, "lower")
+	if err != nil {
+		return nil, err
+	}
+	return one(strings.ToLower(str))
+}
+
+func strUpper(s *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 1, "upper")
+	if err != nil {
+		return nil, err
+	}
+	return one(strings.ToUpper(str))
+}
+
+// maxStringSize bounds strings built by string.rep.
+const maxStringSize = 512 * 1024 * 1024
+
+func strRep(s *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 1, "rep")
+	if err != nil {
+		return nil, err
+	}
+	n, err := checkInt(args, 2, "rep")
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return one("")
+	}
+	if len(str)*n > maxStringSize {
+		return nil, fmt.Errorf("resulting string too large")
+	}
+	return one(strings.Repeat(str, n))
+}
+
+func strReverse(s *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 1, "reverse")
+	if err != nil {
+		return nil, err
+	}
+	buf := []byte(str)
+	for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+		buf[i], buf[j] = buf[j], buf[i]
+	}
+	return one(string(buf))
+}
+
+func strSub(s *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 1, "sub")
+	if err != nil {
+		return nil, err
+	}
+	i, err := optInt(args, 2, "sub", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := optInt(args, 3, "sub", -1)
+	if err != nil {
+		return nil, err
+	}
+	start, end := strRange(i, j, len(str))
+	return one(str[start:end])
+}
+
+func strFind(s *State, args []Value) ([]Value, error) {
+	return strFindAux(args, true)
+}
+
+func strMatch(s *State, args []Value) ([]Value, error) {
+	return strFindAux(args, false)
+}
+
+// strFindAux implements string.find, which returns the match positions
+// followed by captures, and string.match, which returns the captures.
+func strFindAux(args []Value, find bool) ([]Value, error) {
+	fname := "match"
+	if find {
+		fname = "find"
+	}
+	str, err := checkString(args, 1, fname)
+	if err != nil {
+		return nil, err
+	}
+	pat, err := checkString(args, 2, fname)
+	if err != nil {
+		return nil, err
+	}
+	init, err := optInt(args, 3, fname, 1)
+	if err != nil {
+		return nil, err
+	}
+	if init < 0 {
+		init += len(str) + 1
+	}
+	if init < 1 {
+		init = 1
+	}
+	if init > len(str)+1 {
+		return one(nil)
+	}
+	init--
+
+	if find && (Truthy(arg(args, 4)) || !strings.ContainsAny(pat, patternSpecials)) {
+		i := strings.Index(str[init:], pat)
+		if i < 0 {
+			return one(nil)
+		}
+		return []Value{float64(init + i + 1), float64(init + i + len(pat))}, nil
+	}
+
+	anchor := strings.HasPrefix(pat, "^")
+	p := 0
+	if anchor {
+		p = 1
+	}
+	ms := &matchState{src: str, pat: pat}
+	for start := init; start <= len(str); start++ {
+		ms.reset()
+		end := ms.match(start, p)
+		if ms.err != nil {
+			return nil, ms.err
+		}
+		if end != -1 {
+			if !find {
+				return ms.captureValues(start, end, true)
+			}
+			captures, err := ms.captureValues(start, end, false)
+			if err != nil {
+				return nil, err
+			}
+			return append([]Value{float64(start + 1), float64(end)}, captures...), nil
+		}
+		if anchor {
+			break
+		}
+	}
+	return one(nil)
+}
+
+func strGMatch(s *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 1, "gmatch")
+	if err != nil {
+		return nil, err
+	}
+	pat, err := checkString(args, 2, "gmatch")
+	if err != nil {
+		return nil, err
+	}
+
+	pos := 0
+	ms := &matchState{src: str, pat: pat}
+	iter := NewFunction("gmatch_iterator", func(s *State, args []Value) ([]Value, error) {
+		for start := pos; start <= len(str); start++ {
+			ms.reset()
+			end := ms.match(start, 0)
+			if ms.err != nil {
+				return nil, ms.err
+			}
+			if end != -1 {
+				pos = end
+				if end == start {
+					pos++
+				}
+				return ms.captureValues(start, end, true)
+			}
+		}
+		pos = len(str) + 1
+		return one(nil)
+	})
+	return one(iter)
+}
+
+func strGSub(s *State, args []Value) ([]Value, error) {
+	str, err := checkString(args, 1, "gsub")
+	if err != nil {
+		return nil, err
+	}
+	pat, err := checkString(args, 2, "gsub")
+	if err != nil {
+		return nil, err
+	}
+	repl := arg(args, 3)
+	switch repl.(type) {
+	case string, float64, *Table, *Function:
+	default:
+		return nil, typeError(args, 3, "gsub", "string/function/table")
+	}
+	maxN, err := optInt(args, 4, "gsub", len(str)+1)
+	if err != nil {
+		return nil, err
+	}
+
+	anchor := strings.HasPrefix(pat, "^")
+	p := 0
+	if anchor {
+		p = 1
+	}
+	ms := &matchState{src: str, pat: pat}
+	var sb strings.Builder
+	src, n := 0, 0
+	for n < maxN {
+		ms.reset()
+		end := ms.match(src, p)
+		if ms.err != nil {
+			return nil, ms.err
+		}
+		if end != -1 {
+			n++
+			if err := gsubValue(s, ms, &sb, src, end, repl); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case end != -1 && end > src:
+			src = end
+		case src < len(str):
+			sb.WriteByte(str[src])
+			src++
+		default:
+			goto done
+		}
+		if anchor {
+			break
+		}
+	}
+done:
+	sb.WriteString(str[src:])
+	return []Value{sb.String(), float64(n)}, nil
+}
+
+// gsubValue appends the replacement for the match from start to end.
+func gsubValue(s *State, ms *matchState, sb *strings.Builder, start, end int, repl Value) error {
+	var value Value
+	switch r := repl.(type) {
+	case float64:
+		sb.WriteString(FormatNumber(r))
+		return nil
+	case string:
+		for i := 0; i < len(r); i++ {
+			c := r[i]
+			if c != patternEscape || i+1 == len(r) {
+				sb.WriteByte(c)
+				continue
+			}
+			i++
+			if !isDigit(r[i]) {
+				sb.WriteByte(r[i])
+				continue
+			}
+			if r[i] == '0' {
+				sb.WriteString(ms.src[start:end])
+				continue
+			}
+			v, err := ms.getCapture(int(r[i]-'1'), start, end)
+			if err != nil {
+				return err
+			}
+			sb.WriteString(ToString(v))
+		}
+		return nil
+	case *Table:
+		key, err := ms.getCapture(0, start, end)
+		if err != nil {
+			return err
+		}
+		value = r.Get(key)
+	case *Function:
+		captures, err := ms.captureValues(start, end, true)
+		if err != nil {
+			return err
+		}
+		results, err := s.Call(r, captures)
+		if err != nil {
+			return err
+		}
+		if len(results) > 0 {
+			value = results[0]
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		sb.WriteString(ms.src[start:end])
+	case bool:
+		if v {
+			return fmt.Errorf("invalid replacement value (a boolean)")
+		}
+		sb.WriteString(ms.src[start:end])
+	case string:
+		sb.WriteString(v)
+	case float64:
+		sb.WriteString(FormatNumber(v))
+	default:
+		return fmt.Errorf("invalid replacement value (a %s)", TypeName(v))
+	}
+	return nil
+}
+
+// strFormat implements string.format with the C conversions %d, %i, %u,
+// %c, %x, %X, %o, %e, %E, %f, %g, %G, %q and %s.
+func strFormat(s *State, args []Value) ([]Value, error) {
+	format, err := checkString(args, 1, "format")
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	n := 1
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			sb.WriteByte('%')
+			continue
+		}
+
+		// Flags, width and precision are passed through to fmt.
+		start := i
+		for i < len(format) && strings.IndexByte("-+ #0", format[i]) >= 0 {
+			i++
+		}
+		for i < len(format) && (isDigit(format[i]) || format[i] == '.') {
+			i++
+		}
+		if i >= len(format) {
+			return nil, fmt.Errorf("invalid option '%%' to 'format'")
+		}
+		spec := "%" + format[start:i]
+		n++
+
+		switch verb := format[i]; verb {
+		case 'd', 'i', 'u':
+			f, err := checkNumber(args, n, "format")
+			if err != nil {
+				return nil, err
+			}
+			sb.WriteString(fmt.Sprintf(spec+"d", int64(f)))
+		case 'c':
+			f, err := checkNumber(args, n, "format")
+			if err != nil {
+				return nil, err
+			}
+			sb.WriteByte(byte(int64(f)))
+		case 'x', 'X', 'o':
+			f, err := checkNumber(args, n, "format")
+			if err != nil {
+				return nil, err
+			}
+			sb.WriteString(fmt.Sprintf(spec+string(verb), int64(f)))
+		case 'e', 'E', 'f', 'g', 'G':
+			f, err := checkNumber(args, n, "format")
+			if err != nil {
+				return nil, err
+			}
+			sb.WriteString(fmt.Sprintf(spec+string(verb), f))
+		case 'q':
+			str, err := checkString(args, n, "format")
+			if err != nil {
+				return nil, err
+			}
+			writeQuoted(&sb, str)
+		case 's':
+			if err := checkAny(args, n, "format"); err != nil {
+				return nil, err
+			}
+			sb.WriteString(fmt.Sprintf(spec+"s", ToString(args[n-1])))
+		default:
+			return nil, fmt.Errorf("invalid option '%%%c' to 'format'", verb)
+		}
+	}
+	return one(sb.String())
+}
+
+// writeQuoted writes str as a Lua string literal.
+func writeQuoted(sb *strings.Builder, str string) {
+	sb.WriteByte('"')
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; c {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString("\\\n") // An escaped line break, as Lua 5.1 writes it
+		case '\r':
+			sb.WriteString("\\r")
+		case 0:
+			sb.WriteString("\\000")
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+}
+
+// Table library.
+
+func tableConcat(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "concat")
+	if err != nil {
+		return nil, err
+	}
+	sep := ""
+	if arg(args, 2) != nil {
+		if sep, err = checkString(args, 2, "concat"); err != nil {
+			return nil, err
+		}
+	}
+	i, err := optInt(args, 3, "concat", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := optInt(args, 4, "concat", t.Len())
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	for k := i; k <= j; k++ {
+		v, ok := concatOperand(t.Get(float64(k)))
+		if !ok {
+			return nil, fmt.Errorf("invalid value (at index %d) in table for 'concat'", k)
+		}
+		sb.WriteString(v)
+		if k < j {
+			sb.WriteString(sep)
+		}
+	}
+	return one(sb.String())
+}
+
+func tableGetN(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "getn")
+	if err != nil {
+		return nil, err
+	}
+	return one(float64(t.Len()))
+}
+
+func tableInsert(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "insert")
+	if err != nil {
+		return nil, err
+	}
+	n := t.Len()
+	switch len(args) {
+	case 2:
+		return nil, t.Set(float64(n+1), args[1])
+	case 3:
+		pos, err := checkInt(args, 2, "insert")
+		if err != nil {
+			return nil, err
+		}
+		for i := n; i >= pos; i-- {
+			if err := t.Set(float64(i+1), t.Get(float64(i))); err != nil {
+				return nil, err
+			}
+		}
+		return nil, t.Set(float64(pos), args[2])
+	}
+	return nil, fmt.Errorf("wrong number of arguments to 'insert'")
+}
+
+func tableRemove(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "remove")
+	if err != nil {
+		return nil, err
+	}
+	n := t.Len()
+	pos, err := optInt(args, 2, "remove", n)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	removed := t.Get(float64(pos))
+	for i := pos; i < n; i++ {
+		if err := t.Set(float64(i), t.Get(float64(i+1))); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.Set(float64(n), nil); err != nil {
+		return nil, err
+	}
+	return one(removed)
+}
+
+func tableSort(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "sort")
+	if err != nil {
+		return nil, err
+	}
+	var less *Function
+	if arg(args, 2) != nil {
+		fn, ok := args[1].(*Function)
+		if !ok {
+			return nil, typeError(args, 2, "sort", "function")
+		}
+		less = fn
+	}
+
+	values := make([]Value, t.Len())
+	for i := range values {
+		values[i] = t.Get(float64(i + 1))
+	}
+
+	var sortErr error
+	sort.SliceStable(values, func(i, j int) bool {
+		if sortErr != nil {
+			return false
+		}
+		if less != nil {
+			results, err := s.Call(less, []Value{values[i], values[j]})
+			if err != nil {
+				sortErr = err
+				return false
+			}
+			return len(results) > 0 && Truthy(results[0])
+		}
+
+		switch a := values[i].(type) {
+		case float64:
+			if b, ok := values[j].(float64); ok {
+				return a < b
+			}
+		case string:
+			if b, ok := values[j].(string); ok {
+				return a < b
+			}
+		}
+		sortErr = fmt.Errorf("attempt to compare %s with %s", TypeName(values[i]), TypeName(values[j]))
+		return false
+	})
+	if sortErr != nil {
+		return nil, sortErr
+	}
+
+	for i, v := range values {
+		if err := t.Set(float64(i+1), v); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func tableUnpack(s *State, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "unpack")
+	if err != nil {
+		return nil, err
+	}
+	i, err := optInt(args, 2, "unpack", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := optInt(args, 3, "unpack", t.Len())
+	if err != nil {
+		return nil, err
+	}
+	if i > j {
+		return nil, nil
+	}
+	if j-i >= 8000 {
+		return nil, fmt.Errorf("too many results to unpack")
+	}
+	results := make([]Value, 0, j-i+1)
+	for k := i; k <= j; k++ {
+		results = append(results, t.Get(float64(k)))
+	}
+	return results, nil
+}
+
+// Math library.
+
+func mathFunc(fname string, fn func(float64) float64) GoFunction {
+	return func(s *State, args []Value) ([]Value, error) {
+		x, err := checkNumber(args, 1, fname)
+		if err != nil {
+			return nil, err
+		}
+		return one(fn(x))
+	}
+}
+
+func mathFmod(s *State, args []Value) ([]Value, error) {
+	x, err := checkNumber(args, 1, "fmod")
+	if err != nil {
+		return nil, err
+	}
+	y, err := checkNumber(args, 2, "fmod")
+	if err != nil {
+		return nil, err
+	}
+	return one(math.Mod(x, y))
+}
+
+func mathModf(s *State, args []Value) ([]Value, error) {
+	x, err := checkNumber(args, 1, "modf")
+	if err != nil {
+		return nil, err
+	}
+	i, frac := math.Modf(x)
+	return []Value{i, frac}, nil
+}
+
+func mathPow(s *State, args []Value) ([]Value, error) {
+	x, err := checkNumber(args, 1, "pow")
+	if err != nil {
+		return nil, err
+	}
+	y, err := checkNumber(args, 2, "pow")
+	if err != nil {
+		return nil, err
+	}
+	return one(math.Pow(x, y))
+}
+
+func mathMax(s *State, args []Value) ([]Value, error) {
+	return mathExtreme(args, "max", func(a, b float64) bool { return a > b })
+}
+
+func mathMin(s *State, args []Value) ([]Value, error) {
+	return mathExtreme(args, "min", func(a, b float64) bool { return a < b })
+}
+
+func mathExtreme(args []Value, fname string, better func(a, b float64) bool) ([]Value, error) {
+	best, err := checkNumber(args, 1, fname)
+	if err != nil {
+		return nil, err
+	}
+	for i := 2; i <= len(args); i++ {
+		x, err := checkNumber(args, i, fname)
+		if err != nil {
+			return nil, err
+		}
+		if better(x, best) {
+			best = x
+		}
+	}
+	return one(best)
+}
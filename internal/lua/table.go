@@ -0,0 +1,215 @@
+package lua
+
+import (
+	"errors"
+	"math"
+)
+
+// Table is a Lua table. Consecutive integer keys from 1 are kept in an array
+// part; other keys are kept in insertion order so that iteration with next
+// and pairs is deterministic, as scripts must be.
+type Table struct {
+	array []Value
+	keys  []Value       // Hash keys in insertion order
+	index map[Value]int // Hash key to position in keys, including removed keys
+	vals  map[Value]Value
+	dead  int // Removed keys still in keys
+	meta  *Table
+}
+
+// NewTable returns an empty table.
+func NewTable() *Table {
+	return &Table{}
+}
+
+// NewArray returns a table holding values at keys 1..n.
+func NewArray(values ...Value) *Table {
+	t := &Table{}
+	for _, v := range values {
+		t.Append(v)
+	}
+	return t
+}
+
+// arrayIndex returns the array position of key, if it is an integer.
+func arrayIndex(key Value) (int, bool) {
+	f, ok := key.(float64)
+	if !ok || f != math.Trunc(f) || f < 1 || f > math.MaxInt32 {
+		return 0, false
+	}
+	return int(f), true
+}
+
+// Metatable returns the metatable of t, or nil.
+func (t *Table) Metatable() *Table {
+	return t.meta
+}
+
+// SetMetatable sets the metatable of t; nil removes it.
+func (t *Table) SetMetatable(meta *Table) {
+	t.meta = meta
+}
+
+// Get returns the value stored at key, or nil. Metamethods are not
+// consulted, as with rawget.
+func (t *Table) Get(key Value) Value {
+	if i, ok := arrayIndex(key); ok && i <= len(t.array) {
+		return t.array[i-1]
+	}
+	if t.vals == nil {
+		return nil
+	}
+	return t.vals[key]
+}
+
+// GetString returns the value stored at a string key.
+func (t *Table) GetString(key string) Value {
+	return t.Get(key)
+}
+
+// Len returns the length of the array part, Lua's # operator.
+func (t *Table) Len() int {
+	return len(t.array)
+}
+
+// Append stores v at key Len()+1.
+func (t *Table) Append(v Value) {
+	if v == nil {
+		return
+	}
+	_ = t.Set(float64(len(t.array)+1), v)
+}
+
+// Set stores v at key, removing the key if v is nil. Metamethods are not
+// consulted, as with rawset.
+func (t *Table) Set(key, v Value) error {
+	switch k := key.(type) {
+	case nil:
+		return errors.New("table index is nil")
+	case float64:
+		if math.IsNaN(k) {
+			return errors.New("table index is NaN")
+		}
+	}
+
+	if i, ok := arrayIndex(key); ok {
+		switch {
+		case i <= len(t.array):
+			t.array[i-1] = v
+			if i == len(t.array) {
+				t.trimArray()
+			}
+			return nil
+		case i == len(t.array)+1 && v != nil:
+			t.deleteHash(key)
+			t.array = append(t.array, v)
+			t.migrate()
+			return nil
+		}
+	}
+
+	if v == nil {
+		t.deleteHash(key)
+		return nil
+	}
+	if t.vals == nil {
+		t.vals = make(map[Value]Value)
+		t.index = make(map[Value]int)
+	}
+	if _, exists := t.vals[key]; !exists {
+		if _, removed := t.index[key]; removed {
+			t.dead--
+		} else {
+			if t.dead > 16 && t.dead > len(t.keys)/2 {
+				t.compact()
+			}
+			t.index[key] = len(t.keys)
+			t.keys = append(t.keys, key)
+		}
+	}
+	t.vals[key] = v
+	return nil
+}
+
+// trimArray drops trailing nils so that Len is a border of the table.
+func (t *Table) trimArray() {
+	n := len(t.array)
+	for n > 0 && t.array[n-1] == nil {
+		n--
+	}
+	t.array = t.array[:n]
+}
+
+// migrate moves keys that continue the array part out of the hash part.
+func (t *Table) migrate() {
+	for t.vals != nil {
+		key := float64(len(t.array) + 1)
+		v, ok := t.vals[key]
+		if !ok {
+			return
+		}
+		t.deleteHash(key)
+		t.array = append(t.array, v)
+	}
+}
+
+// deleteHash removes key from the hash part. The key keeps its position so
+// that a traversal can continue from it, as Lua allows clearing fields
+// while iterating; positions are reclaimed when new keys are added.
+func (t *Table) deleteHash(key Value) {
+	if _, ok := t.vals[key]; !ok {
+		return
+	}
+	delete(t.vals, key)
+	t.dead++
+}
+
+// compact forgets removed keys.
+func (t *Table) compact() {
+	keys := make([]Value, 0, len(t.vals))
+	for _, key := range t.keys {
+		if _, ok := t.vals[key]; ok {
+			keys = append(keys, key)
+		} else {
+			delete(t.index, key)
+		}
+	}
+	for i, key := range keys {
+		t.index[key] = i
+	}
+	t.keys = keys
+	t.dead = 0
+}
+
+// Next returns the key and value following key in iteration order, starting
+// with a nil key. It returns a nil key at the end.
+func (t *Table) Next(key Value) (Value, Value, error) {
+	pos := 0
+	if key != nil {
+		hashPos, inHash := t.index[key]
+		i, isIndex := arrayIndex(key)
+		switch {
+		case isIndex && i <= len(t.array):
+			pos = i
+		case inHash:
+			pos = len(t.array) + hashPos + 1
+		case isIndex:
+			// The array shrank during traversal; continue with the hash part.
+			pos = len(t.array)
+		default:
+			return nil, nil, errors.New("invalid key to 'next'")
+		}
+	}
+
+	for ; pos < len(t.array); pos++ {
+		if t.array[pos] != nil {
+			return float64(pos + 1), t.array[pos], nil
+		}
+	}
+	for i := pos - len(t.array); i < len(t.keys); i++ {
+		if v, ok := t.vals[t.keys[i]]; ok {
+			return t.keys[i], v, nil
+		}
+	}
+	return nil, nil, nil
+}
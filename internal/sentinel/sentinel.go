@@ -0,0 +1,543 @@
+// Package sentinel implements cachemir-sentinel, which monitors CacheMir
+// primaries and fails them over to one of their replicas.
+//
+// Each sentinel PINGs the primaries it monitors. When a primary hasn't
+// answered for DownAfter, the sentinel considers it down and asks the other
+// sentinels whether they agree. Once Quorum sentinels do, the sentinels
+// elect the one that performs the failover: the sentinel starts a new
+// epoch and asks the others for their vote, and each sentinel votes for the
+// first candidate of an epoch. A candidate that wins a majority promotes the
+// replica that has applied the most changes, points the other replicas at
+// it and publishes the new address on protocol.SentinelChannel.
+//
+// Sentinels learn about failovers made by others by polling their SENTINEL
+// PRIMARIES, adopting addresses chosen in a newer epoch. A failed primary
+// that comes back is made a replica of its replacement.
+//
+// Primaries are identified by name. Clients use the name as the node name on
+// their hash ring, so a failover changes the address of a node without
+// moving any keys.
+//
+// Example usage:
+//
+//	s, err := sentinel.New(config.LoadSentinelConfig())
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	if err := s.Start(); err != nil {
+//		log.Fatal(err)
+//	}
+package sentinel
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	mathrand "math/rand/v2"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/config"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// Sentinel timing.
+const (
+	checkInterval  = time.Second
+	callTimeout    = time.Second
+	electionJitter = time.Second // Maximum delay before running for leader
+	idBytes        = 8
+)
+
+// primary is the state of a monitored primary.
+type primary struct {
+	name     string
+	addr     string    // Current address
+	epoch    uint64    // Epoch of the failover that chose addr; 0 for the configured address
+	lastOK   time.Time // Last PING reply
+	replicas []string  // Replicas reported by the primary's last ROLE
+	stale    []string  // Former addresses to turn into replicas when they return
+
+	voteEpoch uint64    // Latest epoch in which this sentinel voted for a failover leader
+	votedFor  string    // Sentinel voted for in voteEpoch
+	retryAt   time.Time // No failover is attempted before this time
+}
+
+// Sentinel monitors primaries and fails them over. All methods are safe for
+// concurrent use.
+type Sentinel struct {
+	config   *config.SentinelConfig
+	id       string // Random ID identifying this sentinel in elections
+	listener net.Listener
+	done     chan struct{}
+
+	mu          sync.Mutex
+	epoch       uint64              // Latest election epoch seen
+	primaries   map[string]*primary // Monitored primaries by name
+	names       []string            // Primary names in configuration order
+	subscribers map[*subscriber]struct{}
+}
+
+// New creates a sentinel for the given configuration. It doesn't monitor
+// anything until Start is called.
+//
+// Returns:
+//   - A new Sentinel
+//   - Error if the configuration is invalid
+func New(cfg *config.SentinelConfig) (*Sentinel, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, idBytes)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	s := &Sentinel{
+		config:      cfg,
+		id:          hex.EncodeToString(id),
+		done:        make(chan struct{}),
+		primaries:   make(map[string]*primary),
+		subscribers: make(map[*subscriber]struct{}),
+	}
+	for _, spec := range cfg.Primaries {
+		name, addr, err := config.ParsePrimary(spec)
+		if err != nil {
+			return nil, err
+		}
+		s.primaries[name] = &primary{name: name, addr: addr}
+		s.names = append(s.names, name)
+	}
+	return s, nil
+}
+
+// Start listens for clients and other sentinels and monitors the
+// primaries. It blocks until the sentinel is stopped or fails to listen.
+//
+// Returns:
+//   - Error if the sentinel can't listen on its address
+func (s *Sentinel) Start() error {
+	addr := s.config.Address()
+	lc := net.ListenConfig{}
+	listener, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	s.listener = listener
+	log.Printf("CacheMir sentinel %s listening on %s, monitoring %d primaries", s.id, addr, len(s.names))
+
+	s.mu.Lock()
+	now := time.Now()
+	for _, name := range s.names {
+		p := s.primaries[name]
+		p.lastOK = now // Give every primary DownAfter to answer
+		go s.monitor(p)
+	}
+	s.mu.Unlock()
+	if len(s.config.Sentinels) > 0 {
+		go s.followPeers()
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			log.Printf("Failed to accept connection: %v", err)
+			continue
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+// Stop stops monitoring and closes the listener, which makes Start return.
+func (s *Sentinel) Stop() error {
+	close(s.done)
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+// downAfter returns the time without a PING reply after which a primary is
+// down.
+func (s *Sentinel) downAfter() time.Duration {
+	return time.Duration(s.config.DownAfter) * time.Millisecond
+}
+
+// quorum returns the number of sentinels that must agree a primary is down.
+func (s *Sentinel) quorum() int {
+	if s.config.Quorum > 0 {
+		return s.config.Quorum
+	}
+	return s.majority()
+}
+
+// majority returns the number of votes needed to lead a failover: a
+// majority of all sentinels, and at least the quorum.
+func (s *Sentinel) majority() int {
+	return max(s.config.Quorum, (len(s.config.Sentinels)+1)/2+1)
+}
+
+// monitor checks p every checkInterval until the sentinel stops.
+func (s *Sentinel) monitor(p *primary) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.check(p)
+		}
+	}
+}
+
+// check PINGs p, refreshes its replicas, demotes former addresses that came
+// back and starts a failover if p is down.
+func (s *Sentinel) check(p *primary) {
+	s.mu.Lock()
+	addr, stale := p.addr, slices.Clone(p.stale)
+	s.mu.Unlock()
+
+	if err := ping(addr); err == nil {
+		replicas, roleErr := primaryReplicas(addr)
+		s.mu.Lock()
+		if p.addr == addr {
+			p.lastOK = time.Now()
+			if roleErr == nil {
+				p.replicas = replicas
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	for _, old := range stale {
+		if ping(old) == nil {
+			s.demote(p, old, addr)
+		}
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	down := p.addr == addr && now.Sub(p.lastOK) > s.downAfter() && now.After(p.retryAt)
+	s.mu.Unlock()
+	if down {
+		s.failover(p, addr)
+	}
+}
+
+// demote makes old, a former address of p that answers again, a replica of
+// p's current address.
+func (s *Sentinel) demote(p *primary, old, addr string) {
+	if err := replicaOf(old, addr); err != nil {
+		log.Printf("Sentinel: failed to make %s a replica of %s: %v", old, addr, err)
+		return
+	}
+	log.Printf("Sentinel: %s is back, now a replica of %s (%s)", old, addr, p.name)
+
+	s.mu.Lock()
+	p.stale = slices.DeleteFunc(p.stale, func(a string) bool { return a == old })
+	s.mu.Unlock()
+}
+
+// failover checks whether enough sentinels agree that p is down at addr,
+// and if so runs for leader and fails p over to its best replica.
+func (s *Sentinel) failover(p *primary, addr string) {
+	agreed := 1
+	for _, reply := range s.askPeers(isDownCommand(p.name, addr, 0, "*")) {
+		if reply.down {
+			agreed++
+		}
+	}
+	if agreed < s.quorum() {
+		return
+	}
+	log.Printf("Sentinel: primary %s at %s is down (%d sentinels agree)", p.name, addr, agreed)
+
+	// Sentinels usually notice at the same time; a random delay keeps them
+	// from all running for leader at once and splitting the vote.
+	select {
+	case <-s.done:
+		return
+	case <-time.After(mathrand.N(electionJitter)): //nolint:gosec // Jitter doesn't need a secure source
+	}
+
+	s.mu.Lock()
+	if time.Now().Before(p.retryAt) || p.addr != addr {
+		s.mu.Unlock()
+		return // Voted for another candidate, or another sentinel already failed over
+	}
+	s.epoch = max(s.epoch, p.voteEpoch) + 1
+	epoch := s.epoch
+	p.voteEpoch, p.votedFor = epoch, s.id
+	p.retryAt = time.Now().Add(time.Duration(s.config.FailoverTimeout) * time.Millisecond)
+	s.mu.Unlock()
+
+	votes := 1
+	for _, reply := range s.askPeers(isDownCommand(p.name, addr, epoch, s.id)) {
+		if reply.leader == s.id && reply.leaderEpoch == epoch {
+			votes++
+		}
+	}
+	if votes < s.majority() {
+		log.Printf("Sentinel: not elected to fail over %s in epoch %d (%d votes)", p.name, epoch, votes)
+		return
+	}
+
+	log.Printf("Sentinel: elected to fail over %s in epoch %d", p.name, epoch)
+	s.promote(p, addr, epoch)
+}
+
+// promote makes the replica of p with the highest applied offset its new
+// primary, points the other replicas at it and publishes the change.
+func (s *Sentinel) promote(p *primary, addr string, epoch uint64) {
+	s.mu.Lock()
+	replicas := slices.Clone(p.replicas)
+	s.mu.Unlock()
+
+	best, bestOffset := "", int64(-1)
+	for _, replica := range replicas {
+		offset, err := replicaOffset(replica)
+		if err == nil && offset > bestOffset {
+			best, bestOffset = replica, offset
+		}
+	}
+	if best == "" {
+		log.Printf("Sentinel: no reachable replica to promote for %s", p.name)
+		return
+	}
+
+	if err := replicaOf(best, ""); err != nil {
+		log.Printf("Sentinel: failed to promote %s: %v", best, err)
+		return
+	}
+	for _, replica := range replicas {
+		if replica == best {
+			continue
+		}
+		if err := replicaOf(replica, best); err != nil {
+			log.Printf("Sentinel: failed to make %s a replica of %s: %v", replica, best, err)
+		}
+	}
+	log.Printf("Sentinel: promoted %s to primary of %s in epoch %d", best, p.name, epoch)
+
+	s.adopt(p.name, best, epoch)
+}
+
+// adopt records addr as the address of primary name chosen in epoch, unless
+// a newer epoch already chose another, and publishes the change.
+func (s *Sentinel) adopt(name, addr string, epoch uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, exists := s.primaries[name]
+	if !exists || epoch <= p.epoch {
+		return
+	}
+	s.epoch = max(s.epoch, epoch)
+	old := p.addr
+	p.addr, p.epoch = addr, epoch
+	p.lastOK = time.Now()
+	p.replicas = slices.DeleteFunc(p.replicas, func(a string) bool { return a == addr })
+	p.stale = slices.DeleteFunc(p.stale, func(a string) bool { return a == addr })
+	if old != addr && !slices.Contains(p.stale, old) {
+		p.stale = append(p.stale, old)
+	}
+
+	log.Printf("Sentinel: primary %s is now %s (epoch %d)", name, addr, epoch)
+	s.publish(protocol.SentinelChannel, fmt.Sprintf("%s %s %d", name, addr, epoch))
+}
+
+// followPeers polls the other sentinels for failovers they made.
+func (s *Sentinel) followPeers() {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	cmd := &protocol.Command{Type: protocol.CmdSentinel, Args: []string{"PRIMARIES"}}
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		for _, peer := range s.config.Sentinels {
+			resp, err := call(peer, cmd)
+			if err != nil {
+				continue
+			}
+			entries, _ := resp.Data.([]interface{})
+			for _, entry := range entries {
+				fields, _ := entry.([]interface{})
+				if len(fields) != 3 {
+					continue
+				}
+				name, _ := fields[0].(string)
+				addr, _ := fields[1].(string)
+				epoch, _ := fields[2].(int64)
+				s.adopt(name, addr, uint64(epoch))
+			}
+		}
+	}
+}
+
+// isDownReply is a decoded SENTINEL IS-DOWN reply.
+type isDownReply struct {
+	down        bool
+	leader      string
+	leaderEpoch uint64
+}
+
+// isDownCommand builds a SENTINEL IS-DOWN request. A candidate of "*" asks
+// for the peer's opinion without requesting its vote.
+func isDownCommand(name, addr string, epoch uint64, candidate string) *protocol.Command {
+	return &protocol.Command{
+		Type: protocol.CmdSentinel,
+		Args: []string{"IS-DOWN", name, addr, strconv.FormatUint(epoch, 10), candidate},
+	}
+}
+
+// askPeers sends cmd to every other sentinel in parallel and returns the
+// decoded IS-DOWN replies of those that answered.
+func (s *Sentinel) askPeers(cmd *protocol.Command) []isDownReply {
+	replies := make([]*isDownReply, len(s.config.Sentinels))
+	var wg sync.WaitGroup
+	for i, peer := range s.config.Sentinels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := call(peer, cmd)
+			if err != nil {
+				return
+			}
+			items, _ := resp.Data.([]interface{})
+			if resp.Type != protocol.RespNested || len(items) != 3 {
+				return
+			}
+			down, _ := items[0].(int64)
+			leader, _ := items[1].(string)
+			epoch, _ := items[2].(int64)
+			replies[i] = &isDownReply{down: down == 1, leader: leader, leaderEpoch: uint64(epoch)}
+		}()
+	}
+	wg.Wait()
+
+	var answered []isDownReply
+	for _, reply := range replies {
+		if reply != nil {
+			answered = append(answered, *reply)
+		}
+	}
+	return answered
+}
+
+// call sends one command to addr on a new connection and returns the
+// response. Error responses are returned as errors.
+func call(addr string, cmd *protocol.Command) (*protocol.Response, error) {
+	dialer := net.Dialer{Timeout: callTimeout}
+	conn, err := dialer.DialContext(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() //nolint:errcheck // Nothing to do on failure
+
+	if err := conn.SetDeadline(time.Now().Add(callTimeout)); err != nil {
+		return nil, err
+	}
+	if err := protocol.WriteCommand(conn, cmd); err != nil {
+		return nil, err
+	}
+	resp, err := protocol.ReadResponse(conn)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type == protocol.RespError {
+		return nil, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// ping checks that the node at addr answers PING.
+func ping(addr string) error {
+	_, err := call(addr, &protocol.Command{Type: protocol.CmdPing})
+	return err
+}
+
+// role returns the ROLE reply of the node at addr.
+func role(addr string) ([]interface{}, error) {
+	resp, err := call(addr, &protocol.Command{Type: protocol.CmdRole})
+	if err != nil {
+		return nil, err
+	}
+	items, _ := resp.Data.([]interface{})
+	if resp.Type != protocol.RespNested || len(items) == 0 {
+		return nil, fmt.Errorf("unexpected ROLE reply")
+	}
+	return items, nil
+}
+
+// primaryReplicas returns the addresses of the replicas connected to the
+// primary at addr.
+func primaryReplicas(addr string) ([]string, error) {
+	items, err := role(addr)
+	if err != nil {
+		return nil, err
+	}
+	if items[0] != "primary" || len(items) != 3 {
+		return nil, fmt.Errorf("%s is not a primary", addr)
+	}
+
+	entries, _ := items[2].([]interface{})
+	replicas := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		fields, _ := entry.([]interface{})
+		if len(fields) > 0 {
+			if replica, ok := fields[0].(string); ok {
+				replicas = append(replicas, replica)
+			}
+		}
+	}
+	return replicas, nil
+}
+
+// replicaOffset returns the replication offset applied by the replica at
+// addr.
+func replicaOffset(addr string) (int64, error) {
+	items, err := role(addr)
+	if err != nil {
+		return 0, err
+	}
+	if items[0] != "replica" || len(items) != 5 {
+		return 0, fmt.Errorf("%s is not a replica", addr)
+	}
+	offset, ok := items[3].(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected ROLE reply")
+	}
+	return offset, nil
+}
+
+// replicaOf makes the node at addr a replica of primary, or a primary if
+// primary is empty.
+func replicaOf(addr, primary string) error {
+	args := []string{"NO", "ONE"}
+	if primary != "" {
+		host, port, err := net.SplitHostPort(primary)
+		if err != nil {
+			return err
+		}
+		args = []string{host, port}
+	}
+	_, err := call(addr, &protocol.Command{Type: protocol.CmdReplicaOf, Args: args})
+	return err
+}
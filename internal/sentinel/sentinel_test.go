@@ -0,0 +1,301 @@
+package sentinel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cachemir/cachemir/internal/server"
+	"github.com/cachemir/cachemir/pkg/client"
+	"github.com/cachemir/cachemir/pkg/config"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// Test timing, in milliseconds. DownAfter must exceed checkInterval, or a
+// primary would be down between two checks.
+const (
+	testDownAfter       = 1500
+	testFailoverTimeout = 3000
+)
+
+// freeAddr returns a local address that is free at the time of the call,
+// and its port.
+func freeAddr(t *testing.T) (string, int) {
+	t.Helper()
+
+	lc := net.ListenConfig{}
+	l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer l.Close()                      //nolint:errcheck // Only reserved the port
+	port := l.Addr().(*net.TCPAddr).Port //nolint:errcheck // TCP listeners have TCP addresses
+	return fmt.Sprintf("127.0.0.1:%d", port), port
+}
+
+// waitFor polls cond until it holds, failing the test after 10 seconds,
+// which leaves time for a primary to be found down and an election.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// startNode starts a server on a free local port. It is stopped when the
+// test ends, unless the test stops it first.
+func startNode(t *testing.T) (*server.Server, string) {
+	t.Helper()
+
+	addr, port := freeAddr(t)
+	s := server.New(port)
+	go func() {
+		if err := s.Start(); err != nil {
+			t.Errorf("Server failed: %v", err)
+		}
+	}()
+	t.Cleanup(func() { s.Stop() }) //nolint:errcheck,gosec // Fails if the test stopped it
+	waitFor(t, "server to listen", func() bool { return ping(addr) == nil })
+	return s, addr
+}
+
+// newSentinel creates a sentinel listening on port, monitoring primaries
+// with the test timing.
+func newSentinel(t *testing.T, port int, peers, primaries []string, quorum int) *Sentinel {
+	t.Helper()
+
+	cfg := &config.SentinelConfig{
+		Host:            "127.0.0.1",
+		Port:            port,
+		Primaries:       primaries,
+		Sentinels:       peers,
+		Quorum:          quorum,
+		DownAfter:       testDownAfter,
+		FailoverTimeout: testFailoverTimeout,
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return s
+}
+
+// startSentinel starts s and waits until it accepts connections. It is
+// stopped when the test ends.
+func startSentinel(t *testing.T, s *Sentinel) {
+	t.Helper()
+
+	go func() {
+		if err := s.Start(); err != nil {
+			t.Errorf("Sentinel failed: %v", err)
+		}
+	}()
+	t.Cleanup(func() { s.Stop() }) //nolint:errcheck,gosec // Nothing to do on failure
+	waitFor(t, "sentinel to listen", func() bool { return ping(s.config.Address()) == nil })
+}
+
+// newClient creates a client with short timeouts, applying configure to its
+// configuration first. The client is closed when the test ends.
+func newClient(t *testing.T, configure func(cfg *config.ClientConfig)) *client.Client {
+	t.Helper()
+
+	cfg := config.LoadClientConfig()
+	cfg.ConnTimeout = 1
+	cfg.ReadTimeout = 1
+	cfg.WriteTimeout = 1
+	cfg.RetryAttempts = 1
+	configure(cfg)
+	c := client.NewWithConfig(cfg)
+	t.Cleanup(func() { c.Close() }) //nolint:errcheck,gosec // Nothing to do on failure
+	return c
+}
+
+// isDownAt asks the sentinel at addr whether primary name is down at
+// primaryAddr, without requesting its vote.
+func isDownAt(t *testing.T, addr, name, primaryAddr string) bool {
+	t.Helper()
+
+	resp, err := call(addr, isDownCommand(name, primaryAddr, 0, "*"))
+	if err != nil {
+		t.Fatalf("SENTINEL IS-DOWN failed: %v", err)
+	}
+	items, _ := resp.Data.([]interface{})
+	return len(items) == 3 && items[0] == int64(1)
+}
+
+// vote returns the sentinel s voted for to fail over primary name, and the
+// epoch of the vote.
+func vote(s *Sentinel, name string) (string, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.primaries[name]
+	return p.votedFor, p.voteEpoch
+}
+
+func TestFailureDetection(t *testing.T) {
+	node, nodeAddr := startNode(t)
+	addr, port := freeAddr(t)
+	s := newSentinel(t, port, nil, []string{"cache=" + nodeAddr}, 0)
+	startSentinel(t, s)
+
+	time.Sleep(testDownAfter*time.Millisecond + checkInterval)
+	if isDownAt(t, addr, "cache", nodeAddr) {
+		t.Fatal("Expected a primary answering PING not to be down")
+	}
+
+	node.Stop() //nolint:errcheck,gosec // Closes the listener, so PINGs fail
+	waitFor(t, "the primary to be down", func() bool { return isDownAt(t, addr, "cache", nodeAddr) })
+
+	// The opinion is about the given address only.
+	if isDownAt(t, addr, "cache", "127.0.0.1:1") {
+		t.Error("Expected a primary not to be down at another address")
+	}
+	if _, err := call(addr, isDownCommand("unknown", nodeAddr, 0, "*")); err == nil {
+		t.Error("Expected an error for an unknown primary")
+	}
+}
+
+func TestFailoverRequiresQuorum(t *testing.T) {
+	down, _ := freeAddr(t) // Nothing listens there
+	primaries := []string{"cache=" + down}
+	addrA, portA := freeAddr(t)
+	addrB, portB := freeAddr(t)
+	addrC, _ := freeAddr(t) // Never started
+
+	// A is driven by the test; B only answers it.
+	a := newSentinel(t, portA, []string{addrB, addrC}, primaries, 3)
+	b := newSentinel(t, portB, []string{addrA, addrC}, primaries, 3)
+	startSentinel(t, b)
+	waitFor(t, "B to consider the primary down", func() bool { return isDownAt(t, addrB, "cache", down) })
+
+	// A and B agree, but the quorum is 3.
+	a.failover(a.primaries["cache"], down)
+	if a.epoch != 0 {
+		t.Errorf("Expected no election without a quorum, got epoch %d", a.epoch)
+	}
+	if leader, _ := vote(b, "cache"); leader != "" {
+		t.Errorf("Expected B not to vote, voted for %q", leader)
+	}
+
+	// With a quorum of 2, A runs for leader and wins B's vote, a majority
+	// of the three sentinels.
+	a.config.Quorum = 2
+	a.failover(a.primaries["cache"], down)
+	if a.epoch != 1 {
+		t.Errorf("Expected A to start epoch 1, got %d", a.epoch)
+	}
+	if leader, epoch := vote(b, "cache"); leader != a.id || epoch != 1 {
+		t.Errorf("Expected B to vote for A in epoch 1, voted for %q in epoch %d", leader, epoch)
+	}
+
+	// B votes once per epoch.
+	resp, err := call(addrB, isDownCommand("cache", down, 1, "other"))
+	if err != nil {
+		t.Fatalf("SENTINEL IS-DOWN failed: %v", err)
+	}
+	if items, _ := resp.Data.([]interface{}); len(items) != 3 || items[1] != a.id {
+		t.Errorf("Expected B to keep its vote for A, got %v", items)
+	}
+}
+
+func TestFailoverPromotesReplica(t *testing.T) {
+	primary, primaryAddr := startNode(t)
+	replicas := make([]*server.Server, 2)
+	replicaAddrs := make([]string, 2)
+	for i := range replicas {
+		replicas[i], replicaAddrs[i] = startNode(t)
+		replicas[i].ReplicaOf(primaryAddr)
+	}
+
+	addrs := make([]string, 3)
+	ports := make([]int, len(addrs))
+	for i := range addrs {
+		addrs[i], ports[i] = freeAddr(t)
+	}
+	sentinels := make([]*Sentinel, len(addrs))
+	for i, addr := range addrs {
+		var peers []string
+		for _, peer := range addrs {
+			if peer != addr {
+				peers = append(peers, peer)
+			}
+		}
+		sentinels[i] = newSentinel(t, ports[i], peers, []string{"cache=" + primaryAddr}, 2)
+		startSentinel(t, sentinels[i])
+	}
+	for _, s := range sentinels {
+		waitFor(t, "sentinels to see both replicas", func() bool {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return len(s.primaries["cache"].replicas) == len(replicas)
+		})
+	}
+
+	// A client following the sentinels writes to the primary by name.
+	c := newClient(t, func(cfg *config.ClientConfig) { cfg.Nodes, cfg.Sentinels = nil, addrs })
+	if nodes := c.Nodes(); len(nodes) != 1 || nodes[0] != "cache" {
+		t.Fatalf("Expected the client to use the primary's name, got %v", nodes)
+	}
+	if err := c.Set("before", "failover", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	primary.Stop() //nolint:errcheck,gosec // Closes the listener, so PINGs fail
+
+	// Every sentinel learns the promoted replica in the same epoch.
+	var promoted string
+	waitFor(t, "a replica to be promoted", func() bool {
+		resp, err := call(addrs[0], &protocol.Command{Type: protocol.CmdSentinel, Args: []string{"PRIMARIES"}})
+		if err != nil {
+			return false
+		}
+		entries, _ := resp.Data.([]interface{})
+		fields, _ := entries[0].([]interface{})
+		promoted, _ = fields[1].(string)
+		return promoted != primaryAddr
+	})
+	for _, s := range sentinels {
+		waitFor(t, "every sentinel to adopt the promotion", func() bool {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			p := s.primaries["cache"]
+			return p.addr == promoted && p.epoch > 0
+		})
+	}
+
+	// The promoted replica is a primary holding the data, and the other
+	// replica follows it.
+	items, err := role(promoted)
+	if err != nil || items[0] != "primary" {
+		t.Fatalf("Expected %s to be a primary, got %v (%v)", promoted, items, err)
+	}
+	other := replicaAddrs[0]
+	if other == promoted {
+		other = replicaAddrs[1]
+	}
+	waitFor(t, "the other replica to follow the new primary", func() bool {
+		items, err := role(other)
+		return err == nil && items[0] == "replica" && items[1] == promoted
+	})
+
+	// The client moves the node to the new address, keeping its keys.
+	direct := newClient(t, func(cfg *config.ClientConfig) { cfg.Nodes = []string{promoted} })
+	waitFor(t, "the client to follow the failover", func() bool {
+		if err := c.Set("after", "failover", 0); err != nil {
+			return false
+		}
+		value, err := direct.Get("after")
+		return err == nil && value == "failover"
+	})
+	if value, err := c.Get("before"); err != nil || value != "failover" {
+		t.Errorf("Expected the replicated key through the new primary, got %q (%v)", value, err)
+	}
+}
@@ -0,0 +1,231 @@
+package sentinel
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// Connection timeouts and limits.
+const (
+	readTimeout         = 30 * time.Second
+	writeTimeout        = 10 * time.Second
+	subscriberQueueSize = 64
+)
+
+// subscriber is a connection that subscribed to channels. Every response
+// for the connection goes through out, drained by a single writer
+// goroutine, so that messages never interleave with replies.
+type subscriber struct {
+	conn     net.Conn
+	out      chan *protocol.Response
+	channels map[string]struct{} // Protected by Sentinel.mu
+}
+
+// handleConnection serves PING, SENTINEL, SUBSCRIBE and UNSUBSCRIBE on a
+// client or sentinel connection.
+func (s *Sentinel) handleConnection(conn net.Conn) {
+	var sub *subscriber
+	defer func() {
+		if sub != nil {
+			s.mu.Lock()
+			delete(s.subscribers, sub)
+			s.mu.Unlock()
+			close(sub.out)
+		}
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("Error closing connection: %v", err)
+		}
+	}()
+
+	for {
+		var deadline time.Time
+		if sub == nil {
+			deadline = time.Now().Add(readTimeout)
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return
+		}
+		cmd, err := protocol.ReadCommand(conn)
+		if err != nil {
+			return
+		}
+
+		var resp *protocol.Response
+		switch cmd.Type {
+		case protocol.CmdPing:
+			resp = &protocol.Response{Type: protocol.RespString, Data: "PONG"}
+		case protocol.CmdSentinel:
+			resp = s.handleSentinel(cmd)
+		case protocol.CmdSubscribe, protocol.CmdUnsubscribe:
+			if sub == nil {
+				sub = s.newSubscriber(conn)
+			}
+			s.handleSubscription(sub, cmd)
+			continue
+		default:
+			resp = &protocol.Response{Type: protocol.RespError, Error: "unknown command for a sentinel"}
+		}
+
+		if sub != nil {
+			sub.out <- resp
+			continue
+		}
+		if err := conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+			return
+		}
+		if err := protocol.WriteResponse(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+// handleSentinel processes SENTINEL subcommands:
+//
+//	SENTINEL PRIMARIES
+//	SENTINEL IS-DOWN name addr epoch candidate
+//
+// PRIMARIES replies [[name, address, epoch]...] for the monitored
+// primaries. IS-DOWN replies [down, leader, leader epoch]: whether this
+// sentinel considers the primary down at addr, and the sentinel it voted
+// for to lead a failover. A candidate other than "*" asks for this
+// sentinel's vote in epoch, which it grants to the first candidate of each
+// epoch while it agrees the primary is down.
+func (s *Sentinel) handleSentinel(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) == 0 {
+		return &protocol.Response{Type: protocol.RespError, Error: "SENTINEL requires a subcommand"}
+	}
+
+	switch strings.ToUpper(cmd.Args[0]) {
+	case "PRIMARIES":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		primaries := make([]interface{}, len(s.names))
+		for i, name := range s.names {
+			p := s.primaries[name]
+			primaries[i] = []interface{}{p.name, p.addr, int64(p.epoch)} //nolint:gosec // Epochs fit
+		}
+		return &protocol.Response{Type: protocol.RespNested, Data: primaries}
+	case "IS-DOWN":
+		if len(cmd.Args) != 5 {
+			return &protocol.Response{Type: protocol.RespError, Error: "SENTINEL IS-DOWN requires name addr epoch candidate"}
+		}
+		epoch, err := strconv.ParseUint(cmd.Args[3], 10, 64)
+		if err != nil {
+			return &protocol.Response{Type: protocol.RespError, Error: "invalid epoch"}
+		}
+		return s.isDown(cmd.Args[1], cmd.Args[2], epoch, cmd.Args[4])
+	default:
+		return &protocol.Response{Type: protocol.RespError, Error: "unknown SENTINEL subcommand: " + cmd.Args[0]}
+	}
+}
+
+// isDown answers SENTINEL IS-DOWN, voting for candidate if appropriate.
+func (s *Sentinel) isDown(name, addr string, epoch uint64, candidate string) *protocol.Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, exists := s.primaries[name]
+	if !exists {
+		return &protocol.Response{Type: protocol.RespError, Error: "unknown primary: " + name}
+	}
+
+	now := time.Now()
+	down := p.addr == addr && now.Sub(p.lastOK) > s.downAfter()
+	if down && candidate != "*" && epoch > p.voteEpoch {
+		p.voteEpoch, p.votedFor = epoch, candidate
+		p.retryAt = now.Add(time.Duration(s.config.FailoverTimeout) * time.Millisecond) // Let the candidate work
+		s.epoch = max(s.epoch, epoch)
+	}
+
+	var downFlag int64
+	if down {
+		downFlag = 1
+	}
+	return &protocol.Response{
+		Type: protocol.RespNested,
+		Data: []interface{}{downFlag, p.votedFor, int64(p.voteEpoch)}, //nolint:gosec // Epochs fit
+	}
+}
+
+// newSubscriber registers conn as a subscriber and starts its writer.
+func (s *Sentinel) newSubscriber(conn net.Conn) *subscriber {
+	sub := &subscriber{
+		conn:     conn,
+		out:      make(chan *protocol.Response, subscriberQueueSize),
+		channels: make(map[string]struct{}),
+	}
+	s.mu.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		for resp := range sub.out {
+			err := conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err == nil {
+				err = protocol.WriteResponse(conn, resp)
+			}
+			if err != nil {
+				conn.Close() //nolint:errcheck,gosec // Unblocks the reader
+				break
+			}
+		}
+		for range sub.out {
+		}
+	}()
+	return sub
+}
+
+// handleSubscription processes SUBSCRIBE and UNSUBSCRIBE, confirming each
+// channel with a push message.
+func (s *Sentinel) handleSubscription(sub *subscriber, cmd *protocol.Command) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cmd.Type == protocol.CmdSubscribe {
+		for _, channel := range cmd.Args {
+			sub.channels[channel] = struct{}{}
+			sub.out <- pushResponse("subscribe", channel, int64(len(sub.channels)))
+		}
+		return
+	}
+
+	channels := cmd.Args
+	if len(channels) == 0 {
+		for channel := range sub.channels {
+			channels = append(channels, channel)
+		}
+	}
+	for _, channel := range channels {
+		delete(sub.channels, channel)
+		sub.out <- pushResponse("unsubscribe", channel, int64(len(sub.channels)))
+	}
+}
+
+// publish sends message to the subscribers of channel. Subscribers that
+// fall behind are disconnected. Callers must hold s.mu.
+func (s *Sentinel) publish(channel, message string) {
+	msg := pushResponse("message", channel, message)
+	for sub := range s.subscribers {
+		if _, subscribed := sub.channels[channel]; !subscribed {
+			continue
+		}
+		select {
+		case sub.out <- msg:
+		default:
+			log.Printf("Disconnecting slow subscriber %s", sub.conn.RemoteAddr())
+			sub.conn.Close() //nolint:errcheck,gosec // Ends its connection handler
+		}
+	}
+}
+
+// pushResponse builds a push message.
+func pushResponse(items ...interface{}) *protocol.Response {
+	return &protocol.Response{Type: protocol.RespPush, Data: items}
+}
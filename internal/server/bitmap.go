@@ -0,0 +1,113 @@
+package server
+
+import (
+	"github.com/cachemir/cachemir/pkg/cache"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// Bitmap argument counts
+const (
+	setBitArgs     = 2
+	bitCountBounds = 2
+	maxBitPosArgs  = 3
+)
+
+// handleSetBit processes SETBIT commands to set or clear a single bit.
+// Expects the offset and bit value as arguments and returns the previous bit.
+func (s *Server) handleSetBit(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) < setBitArgs {
+		return &protocol.Response{Type: protocol.RespError, Error: "SETBIT requires offset and value"}
+	}
+
+	offset, err := parseIntArg(cmd.Args[0])
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: "bit offset is not an integer or out of range"}
+	}
+	bit, err := parseIntArg(cmd.Args[1])
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: "bit is not an integer or out of range"}
+	}
+
+	previous, err := s.cache.SetBit(cmd.Key, offset, int(bit))
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: err.Error()}
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: int64(previous)}
+}
+
+// handleGetBit processes GETBIT commands to read a single bit.
+// Returns 0 for offsets beyond the end of the string or missing keys.
+func (s *Server) handleGetBit(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) == 0 {
+		return &protocol.Response{Type: protocol.RespError, Error: "GETBIT requires an offset"}
+	}
+
+	offset, err := parseIntArg(cmd.Args[0])
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: "bit offset is not an integer or out of range"}
+	}
+
+	bit, err := s.cache.GetBit(cmd.Key, offset)
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: err.Error()}
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: int64(bit)}
+}
+
+// handleBitCount processes BITCOUNT commands to count set bits.
+// Accepts either no arguments or a start and end byte index.
+func (s *Server) handleBitCount(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) != 0 && len(cmd.Args) != bitCountBounds {
+		return &protocol.Response{Type: protocol.RespError, Error: "BITCOUNT requires both start and end"}
+	}
+
+	bounds, err := parseIntArgs(cmd.Args)
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: "value is not an integer or out of range"}
+	}
+
+	count, err := s.cache.BitCount(cmd.Key, bounds...)
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: err.Error()}
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: count}
+}
+
+// handleBitPos processes BITPOS commands to find the first set or clear bit.
+// The first argument is the bit to search for, followed by optional start and end.
+func (s *Server) handleBitPos(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) == 0 || len(cmd.Args) > maxBitPosArgs {
+		return &protocol.Response{Type: protocol.RespError, Error: "BITPOS requires a bit and optional start and end"}
+	}
+
+	args, err := parseIntArgs(cmd.Args)
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: "value is not an integer or out of range"}
+	}
+
+	pos, err := s.cache.BitPos(cmd.Key, int(args[0]), args[1:]...)
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: err.Error()}
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: pos}
+}
+
+// handleBitOp processes BITOP commands. The command key is the destination,
+// the first argument is the operation and the remaining arguments are source keys.
+// Returns the length of the resulting string.
+func (s *Server) handleBitOp(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) < 2 {
+		return &protocol.Response{Type: protocol.RespError, Error: "BITOP requires an operation and source keys"}
+	}
+
+	op, err := cache.ParseBitOperation(cmd.Args[0])
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: err.Error()}
+	}
+
+	length, err := s.cache.BitOp(op, cmd.Key, cmd.Args[1:]...)
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: err.Error()}
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: length}
+}
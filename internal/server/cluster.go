@@ -0,0 +1,408 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/hash"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// In cluster mode, servers learn about each other by gossip. Every round,
+// each node increments its own heartbeat and exchanges its member table
+// with a random peer; the higher heartbeat of a member wins. All nodes
+// build the same consistent hash ring from the members they know, so once
+// gossip has converged they agree on the owner of every key, and answer
+// commands on keys they don't own with a MOVED redirection.
+//
+// A member whose heartbeat stops advancing is reported as failed but stays
+// in the ring, so that keys don't change owner while it restarts. CLUSTER
+// FORGET removes a member, which should be stopped first; the removal is
+// gossiped as well, and the member is banned for clusterForgetTTL so that
+// gossip from servers that haven't heard of the removal yet doesn't bring
+// it back.
+
+// Cluster timing.
+const (
+	clusterGossipInterval = time.Second
+	clusterFailTimeout    = 5 * time.Second
+	clusterDialTimeout    = time.Second
+	clusterForgetTTL      = time.Minute
+)
+
+// forgottenHeartbeat marks a forgotten member in gossip messages.
+const forgottenHeartbeat = "-"
+
+// cluster is the membership and ring of a server in cluster mode.
+type cluster struct {
+	mu        sync.Mutex
+	self      string               // Announced address of this server
+	seeds     []string             // Peers to contact until they are members
+	members   map[string]*member   // Known members, including self
+	forgotten map[string]time.Time // Members removed with CLUSTER FORGET, and when
+	ring      hash.Ring
+	stop      chan struct{}
+}
+
+// member is a cluster member as seen by this server.
+type member struct {
+	heartbeat uint64    // Latest heartbeat heard of
+	updated   time.Time // When the heartbeat last advanced
+}
+
+// EnableCluster switches the server to cluster mode. announce is the
+// "host:port" address other servers and clients reach it at, peers are
+// servers to join through, and ring is an empty ring configured the same
+// way on every server and client, so that they agree on key owners.
+func (s *Server) EnableCluster(announce string, peers []string, ring hash.Ring) {
+	c := &cluster{
+		self:      announce,
+		seeds:     peers,
+		members:   map[string]*member{announce: {heartbeat: startHeartbeat(), updated: time.Now()}},
+		forgotten: make(map[string]time.Time),
+		ring:      ring,
+		stop:      make(chan struct{}),
+	}
+	c.ring.AddNode(announce)
+	s.cluster = c
+	go c.gossip()
+}
+
+// startHeartbeat returns the first heartbeat of this server: the current
+// time in milliseconds, so that a restarted server starts above the
+// heartbeats gossiped before its restart, which advance once per round.
+func startHeartbeat() uint64 {
+	return uint64(time.Now().UnixMilli()) //nolint:gosec // Positive
+}
+
+// stopCluster stops gossiping.
+func (s *Server) stopCluster() {
+	if s.cluster != nil {
+		close(s.cluster.stop)
+	}
+}
+
+// checkOwner returns nil if the server is not in cluster mode or owns
+// all the keys of cmd, a MOVED error if another member owns them all, or
+// a CROSSNODE error if they are spread across members. Keys with the same
+// hash tag, such as "user:{42}:profile" and "user:{42}:cart", are always
+// owned by the same member.
+func (c *cluster) checkOwner(cmd *protocol.Command) *protocol.Response {
+	if c == nil {
+		return nil
+	}
+
+	var owner string
+	for _, key := range commandKeys(cmd) {
+		if key == "" {
+			continue
+		}
+		node := c.ring.GetNode(key)
+		if owner != "" && node != owner {
+			return &protocol.Response{Type: protocol.RespError, Error: "CROSSNODE Keys in request don't map to the same node"}
+		}
+		owner = node
+	}
+	if owner != c.self && owner != "" {
+		return &protocol.Response{Type: protocol.RespError, Error: "MOVED " + owner}
+	}
+	return nil
+}
+
+// commandKeys returns the keys cmd reads or writes: its Key, and for
+// multi-key commands the other keys in its arguments. Arguments that don't
+// parse yield only Key, leaving the error to the handler.
+func commandKeys(cmd *protocol.Command) []string {
+	keys := []string{cmd.Key}
+	switch cmd.Type {
+	case protocol.CmdBitOp:
+		if len(cmd.Args) > 1 {
+			keys = append(keys, cmd.Args[1:]...)
+		}
+	case protocol.CmdPFCount, protocol.CmdPFMerge, protocol.CmdWatch:
+		keys = append(keys, cmd.Args...)
+	case protocol.CmdEval, protocol.CmdEvalSha:
+		if len(cmd.Args) < 2 {
+			break
+		}
+		if numKeys, err := strconv.Atoi(cmd.Args[1]); err == nil && numKeys >= 0 && numKeys <= len(cmd.Args)-2 {
+			keys = append(keys, cmd.Args[2:2+numKeys]...)
+		}
+	case protocol.CmdXRead, protocol.CmdXReadGroup:
+		if opts, err := parseStreamReadArgs(cmd.Args, cmd.Type == protocol.CmdXReadGroup); err == nil {
+			keys = append(keys, opts.keys...)
+		}
+	}
+	return keys
+}
+
+// handleCluster processes CLUSTER subcommands:
+//
+//	CLUSTER NODES
+//	CLUSTER MEET host port
+//	CLUSTER FORGET host port
+//	CLUSTER GOSSIP addr heartbeat... (sent between members)
+//
+// NODES replies [[addr, flags, heartbeat]...] sorted by address, where
+// flags are "myself", "ok" or "fail".
+func (s *Server) handleCluster(cmd *protocol.Command) *protocol.Response {
+	c := s.cluster
+	if c == nil {
+		return &protocol.Response{Type: protocol.RespError, Error: "cluster support disabled"}
+	}
+	if len(cmd.Args) == 0 {
+		return &protocol.Response{Type: protocol.RespError, Error: "CLUSTER requires a subcommand"}
+	}
+
+	switch strings.ToUpper(cmd.Args[0]) {
+	case "NODES":
+		return c.nodes()
+	case "MEET", "FORGET":
+		if len(cmd.Args) != 3 {
+			return &protocol.Response{Type: protocol.RespError, Error: "CLUSTER " + cmd.Args[0] + " requires host and port"}
+		}
+		addr := net.JoinHostPort(cmd.Args[1], cmd.Args[2])
+		if strings.EqualFold(cmd.Args[0], "MEET") {
+			c.meet(addr)
+		} else if err := c.forget(addr); err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Type: protocol.RespOK}
+	case "GOSSIP":
+		if err := c.merge(cmd.Args[1:]); err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Type: protocol.RespArray, Data: c.table()}
+	default:
+		return &protocol.Response{Type: protocol.RespError, Error: "unknown CLUSTER subcommand: " + cmd.Args[0]}
+	}
+}
+
+// nodes builds the CLUSTER NODES reply.
+func (c *cluster) nodes() *protocol.Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	addrs := make([]string, 0, len(c.members))
+	for addr := range c.members {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	nodes := make([]interface{}, len(addrs))
+	for i, addr := range addrs {
+		m := c.members[addr]
+		flags := "ok"
+		switch {
+		case addr == c.self:
+			flags = "myself"
+		case time.Since(m.updated) > clusterFailTimeout:
+			flags = "fail"
+		}
+		nodes[i] = []interface{}{addr, flags, int64(m.heartbeat)} //nolint:gosec // Heartbeats fit
+	}
+	return &protocol.Response{Type: protocol.RespNested, Data: nodes}
+}
+
+// meet adds addr as a member, to be confirmed by gossip.
+func (c *cluster) meet(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.forgotten, addr)
+	if _, known := c.members[addr]; !known {
+		c.members[addr] = &member{updated: time.Now()}
+		c.ring.AddNode(addr)
+		log.Printf("Cluster: met %s", addr)
+	}
+}
+
+// forget removes addr from the cluster.
+func (c *cluster) forget(addr string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if addr == c.self {
+		return errors.New("can't forget myself")
+	}
+	c.forgetLocked(addr)
+	return nil
+}
+
+// forgetLocked removes addr from the members and the ring. Callers must
+// hold c.mu.
+func (c *cluster) forgetLocked(addr string) {
+	if c.banned(addr) {
+		return
+	}
+	c.forgotten[addr] = time.Now()
+	delete(c.members, addr)
+	c.ring.RemoveNode(addr)
+	log.Printf("Cluster: forgot %s", addr)
+}
+
+// banned reports whether addr was forgotten less than clusterForgetTTL
+// ago. Callers must hold c.mu.
+func (c *cluster) banned(addr string) bool {
+	forgotten, exists := c.forgotten[addr]
+	return exists && time.Since(forgotten) < clusterForgetTTL
+}
+
+// table encodes the member table for gossip, as addr, heartbeat pairs.
+// Forgotten members have a "-" heartbeat.
+func (c *cluster) table() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	table := make([]string, 0, 2*(len(c.members)+len(c.forgotten)))
+	for addr, m := range c.members {
+		table = append(table, addr, strconv.FormatUint(m.heartbeat, 10))
+	}
+	for addr := range c.forgotten {
+		if c.banned(addr) {
+			table = append(table, addr, forgottenHeartbeat)
+		}
+	}
+	return table
+}
+
+// merge applies a member table received by gossip.
+func (c *cluster) merge(table []string) error {
+	if len(table)%2 != 0 {
+		return errors.New("invalid gossip message")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for i := 0; i < len(table); i += 2 {
+		addr := table[i]
+		if table[i+1] == forgottenHeartbeat {
+			if addr != c.self {
+				c.forgetLocked(addr)
+			}
+			continue
+		}
+		heartbeat, err := strconv.ParseUint(table[i+1], 10, 64)
+		if err != nil {
+			return errors.New("invalid gossip message")
+		}
+		if c.banned(addr) || addr == c.self {
+			continue
+		}
+
+		m, known := c.members[addr]
+		if !known {
+			c.members[addr] = &member{heartbeat: heartbeat, updated: now}
+			c.ring.AddNode(addr)
+			log.Printf("Cluster: %s joined", addr)
+			continue
+		}
+		if heartbeat > m.heartbeat {
+			m.heartbeat, m.updated = heartbeat, now
+		}
+	}
+	return nil
+}
+
+// gossip exchanges member tables with a random peer every round until the
+// cluster is stopped.
+func (c *cluster) gossip() {
+	ticker := time.NewTicker(clusterGossipInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+
+		peer := c.pickPeer()
+		if peer == "" {
+			continue
+		}
+		table, err := c.exchange(peer)
+		if err != nil {
+			continue // The peer shows as failed once its heartbeat is stale
+		}
+		if err := c.merge(table); err != nil {
+			log.Printf("Cluster: gossip from %s: %v", peer, err)
+		}
+	}
+}
+
+// pickPeer increments this server's heartbeat and returns a random member
+// or seed to gossip with, or "" if there is none.
+func (c *cluster) pickPeer() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	self := c.members[c.self]
+	self.heartbeat++
+	self.updated = time.Now()
+	for addr := range c.forgotten {
+		if !c.banned(addr) {
+			delete(c.forgotten, addr)
+		}
+	}
+
+	var peers []string
+	for addr := range c.members {
+		if addr != c.self {
+			peers = append(peers, addr)
+		}
+	}
+	for _, seed := range c.seeds {
+		if _, known := c.members[seed]; !known && !c.banned(seed) && seed != c.self {
+			peers = append(peers, seed)
+		}
+	}
+	if len(peers) == 0 {
+		return ""
+	}
+	return peers[rand.IntN(len(peers))] //nolint:gosec // Peer selection needs no cryptographic randomness
+}
+
+// exchange sends this server's member table to peer and returns the
+// peer's.
+func (c *cluster) exchange(peer string) ([]string, error) {
+	dialer := &net.Dialer{Timeout: clusterDialTimeout}
+	conn, err := dialer.DialContext(context.Background(), "tcp", peer)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("Error closing connection: %v", err)
+		}
+	}()
+	if err := conn.SetDeadline(time.Now().Add(clusterGossipInterval)); err != nil {
+		return nil, err
+	}
+
+	cmd := &protocol.Command{Type: protocol.CmdCluster, Args: append([]string{"GOSSIP"}, c.table()...)}
+	if err := protocol.WriteCommand(conn, cmd); err != nil {
+		return nil, err
+	}
+	resp, err := protocol.ReadResponse(bufio.NewReader(conn))
+	if err != nil {
+		return nil, err
+	}
+	table, ok := resp.Data.([]string)
+	if resp.Type != protocol.RespArray || !ok {
+		return nil, fmt.Errorf("unexpected gossip reply from %s: %s", peer, resp.Error)
+	}
+	return table, nil
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cachemir/cachemir/pkg/hash"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// startClusterServer starts a server in cluster mode, joining through
+// peers.
+func startClusterServer(t *testing.T, peers ...string) (*Server, string) {
+	t.Helper()
+
+	return startServerWith(t, func(s *Server, addr string) {
+		s.EnableCluster(addr, peers, hash.New(0))
+	})
+}
+
+// members returns the addresses listed by CLUSTER NODES on the server at
+// addr.
+func members(t *testing.T, addr string) []string {
+	t.Helper()
+
+	resp := roundTrip(t, dial(t, addr), command(protocol.CmdCluster, "", "NODES"))
+	entries, ok := resp.Data.([]interface{})
+	if !ok {
+		t.Fatalf("Unexpected CLUSTER NODES reply %+v", resp)
+	}
+	addrs := make([]string, len(entries))
+	for i, entry := range entries {
+		fields, _ := entry.([]interface{})
+		addrs[i], _ = fields[0].(string)
+	}
+	return addrs
+}
+
+// membership sends CLUSTER MEET or FORGET for peer to the server at addr.
+func membership(t *testing.T, addr, subcommand, peer string) *protocol.Response {
+	t.Helper()
+
+	host, port, err := net.SplitHostPort(peer)
+	if err != nil {
+		t.Fatalf("Invalid address %s: %v", peer, err)
+	}
+	return roundTrip(t, dial(t, addr), command(protocol.CmdCluster, "", subcommand, host, port))
+}
+
+// keyOwnedBy returns a key that node owns on the cluster ring of s.
+func keyOwnedBy(t *testing.T, s *Server, node string) string {
+	t.Helper()
+
+	for i := range 10000 {
+		if key := fmt.Sprintf("key:%d", i); s.cluster.ring.GetNode(key) == node {
+			return key
+		}
+	}
+	t.Fatalf("Expected %s to own some key", node)
+	return ""
+}
+
+// waitForMembers waits until every server in addrs lists exactly want.
+func waitForMembers(t *testing.T, addrs []string, want ...string) {
+	t.Helper()
+
+	slices.Sort(want)
+	for _, addr := range addrs {
+		waitFor(t, addr+" to list "+strings.Join(want, ", "), func() bool {
+			return slices.Equal(members(t, addr), want)
+		})
+	}
+}
+
+func TestClusterGossipConverges(t *testing.T) {
+	a, addrA := startClusterServer(t)
+	b, addrB := startClusterServer(t, addrA)
+	c, addrC := startClusterServer(t, addrA)
+
+	// B and C only know A, and learn about each other through it.
+	waitForMembers(t, []string{addrA, addrB, addrC}, addrA, addrB, addrC)
+
+	resp := roundTrip(t, dial(t, addrB), command(protocol.CmdCluster, "", "NODES"))
+	entries, _ := resp.Data.([]interface{})
+	for _, entry := range entries {
+		fields, _ := entry.([]interface{})
+		want := "ok"
+		if fields[0] == addrB {
+			want = "myself"
+		}
+		if fields[1] != want {
+			t.Errorf("Expected %s to be flagged %q, got %v", fields[0], want, fields)
+		}
+	}
+
+	// Every member builds the same ring.
+	for i := range 100 {
+		key := fmt.Sprintf("key:%d", i)
+		owner := a.cluster.ring.GetNode(key)
+		if b.cluster.ring.GetNode(key) != owner || c.cluster.ring.GetNode(key) != owner {
+			t.Fatalf("Expected the members to agree on the owner of %s", key)
+		}
+	}
+}
+
+func TestClusterMeetAndForget(t *testing.T) {
+	_, addrA := startClusterServer(t)
+	_, addrB := startClusterServer(t)
+	if got := members(t, addrA); !slices.Equal(got, []string{addrA}) {
+		t.Fatalf("Expected A alone before MEET, got %v", got)
+	}
+
+	// MEET adds B to A at once; B learns about A by gossip.
+	if resp := membership(t, addrA, "MEET", addrB); resp.Type != protocol.RespOK {
+		t.Fatalf("CLUSTER MEET failed: %+v", resp)
+	}
+	waitForMembers(t, []string{addrA, addrB}, addrA, addrB)
+
+	_, addrC := startClusterServer(t, addrB)
+	waitForMembers(t, []string{addrA, addrB, addrC}, addrA, addrB, addrC)
+
+	// FORGET removes C from A at once, and from B by gossip. C keeps
+	// gossiping, but is banned.
+	if resp := membership(t, addrA, "FORGET", addrC); resp.Type != protocol.RespOK {
+		t.Fatalf("CLUSTER FORGET failed: %+v", resp)
+	}
+	if got := members(t, addrA); slices.Contains(got, addrC) {
+		t.Errorf("Expected A to forget C at once, got %v", got)
+	}
+	waitForMembers(t, []string{addrA, addrB}, addrA, addrB)
+
+	if resp := membership(t, addrA, "FORGET", addrA); resp.Type != protocol.RespError {
+		t.Errorf("Expected a server not to forget itself, got %+v", resp)
+	}
+}
+
+func TestClusterRedirects(t *testing.T) {
+	a, addrA := startClusterServer(t)
+	_, addrB := startClusterServer(t, addrA)
+	waitForMembers(t, []string{addrA, addrB}, addrA, addrB)
+	conn := dial(t, addrA)
+
+	local, remote := keyOwnedBy(t, a, addrA), keyOwnedBy(t, a, addrB)
+	if resp := roundTrip(t, conn, command(protocol.CmdSet, local, "v")); resp.Type != protocol.RespOK {
+		t.Errorf("Expected A to serve its own key, got %+v", resp)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdSet, remote, "v")); resp.Error != "MOVED "+addrB {
+		t.Errorf("Expected MOVED %s, got %+v", addrB, resp)
+	}
+
+	// Multi-key commands must stay on one member.
+	resp := roundTrip(t, conn, command(protocol.CmdPFCount, local, remote))
+	if resp.Type != protocol.RespError || !strings.HasPrefix(resp.Error, "CROSSNODE") {
+		t.Errorf("Expected CROSSNODE for keys on both members, got %+v", resp)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdPFCount, remote, remote)); resp.Error != "MOVED "+addrB {
+		t.Errorf("Expected MOVED %s for keys all on B, got %+v", addrB, resp)
+	}
+
+	// Keys sharing a hash tag have the same owner.
+	var tag string
+	for i := 0; tag == ""; i++ {
+		if candidate := fmt.Sprintf("{user:%d}", i); a.cluster.ring.GetNode(candidate+":a") == addrA {
+			tag = candidate
+		}
+	}
+	resp = roundTrip(t, conn, command(protocol.CmdPFCount, tag+":a", tag+":b", tag+":c"))
+	if resp.Type == protocol.RespError {
+		t.Errorf("Expected keys with a hash tag to be served together, got %+v", resp)
+	}
+}
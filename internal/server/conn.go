@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// readAheadSize is the size of the reads made while watching a connection.
+const readAheadSize = 512
+
+// blockingCommands may wait for other clients indefinitely, so the
+// connection is watched while they run and they give up when their client
+// disconnects.
+var blockingCommands = map[protocol.CommandType]bool{
+	protocol.CmdXRead:      true,
+	protocol.CmdXReadGroup: true,
+}
+
+// connReader reads commands from a connection, first returning the bytes
+// read ahead while the connection was watched.
+type connReader struct {
+	conn    net.Conn
+	pending []byte
+}
+
+func (r *connReader) Read(p []byte) (int, error) {
+	if len(r.pending) > 0 {
+		n := copy(p, r.pending)
+		r.pending = r.pending[n:]
+		return n, nil
+	}
+	return r.conn.Read(p)
+}
+
+// watch reads ahead from the connection until the returned stop function is
+// called, and closes the returned channel if the client disconnects. Bytes
+// read meanwhile, such as pipelined commands, are kept for the next reads.
+func (r *connReader) watch() (disconnected <-chan struct{}, stop func()) {
+	closed := make(chan struct{})
+	finished := make(chan struct{})
+	if err := r.conn.SetReadDeadline(time.Time{}); err != nil {
+		log.Printf("Error clearing read deadline: %v", err)
+	}
+
+	go func() {
+		defer close(finished)
+		buf := make([]byte, readAheadSize)
+		for {
+			n, err := r.conn.Read(buf)
+			r.pending = append(r.pending, buf[:n]...)
+			if err != nil {
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					close(closed)
+				}
+				return
+			}
+		}
+	}()
+
+	return closed, func() {
+		if err := r.conn.SetReadDeadline(time.Now()); err != nil {
+			log.Printf("Error interrupting read: %v", err)
+		}
+		<-finished
+	}
+}
+
+// executeBlocking runs a blocking command for the connection read by r,
+// making it give up when the client disconnects.
+func (s *Server) executeBlocking(r *connReader, cmd *protocol.Command) *protocol.Response {
+	disconnected, stop := r.watch()
+	defer stop()
+
+	view := *s
+	view.disconnected = disconnected
+	return view.executeCommand(cmd)
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cachemir/cachemir/pkg/cache"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// Geo argument counts
+const (
+	geoTripleArgs   = 3
+	minGeoDistArgs  = 2
+	maxGeoDistArgs  = 3
+	geoDistDecimals = 4
+)
+
+// geoUnits maps distance unit names to meters.
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"mi": 1609.34,
+	"ft": 0.3048,
+}
+
+// parseGeoUnit returns the number of meters in a distance unit.
+func parseGeoUnit(unit string) (float64, error) {
+	meters, ok := geoUnits[strings.ToLower(unit)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported unit provided. please use M, KM, FT, MI")
+	}
+	return meters, nil
+}
+
+// formatGeoDistance formats a distance in meters in the given unit.
+func formatGeoDistance(meters, unit float64) string {
+	return strconv.FormatFloat(meters/unit, 'f', geoDistDecimals, 64)
+}
+
+// formatGeoCoord formats a coordinate with full precision.
+func formatGeoCoord(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// parseFloatArgs parses consecutive float arguments.
+func parseFloatArgs(args ...string) ([]float64, error) {
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value is not a valid float")
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// handleGeoAdd processes GEOADD commands and returns the number of members
+// added (or changed, with CH).
+func (s *Server) handleGeoAdd(cmd *protocol.Command) *protocol.Response {
+	opts := &cache.GeoAddOptions{}
+	args := cmd.Args
+options:
+	for len(args) > 0 {
+		switch strings.ToUpper(args[0]) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "CH":
+			opts.CH = true
+		default:
+			break options
+		}
+		args = args[1:]
+	}
+
+	if len(args) == 0 || len(args)%geoTripleArgs != 0 {
+		return &protocol.Response{Type: protocol.RespError, Error: "wrong number of arguments for GEOADD"}
+	}
+
+	locs := make([]cache.GeoLocation, 0, len(args)/geoTripleArgs)
+	for i := 0; i < len(args); i += geoTripleArgs {
+		coords, err := parseFloatArgs(args[i], args[i+1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		locs = append(locs, cache.GeoLocation{Member: args[i+2], Longitude: coords[0], Latitude: coords[1]})
+	}
+
+	added, err := s.cache.GeoAdd(cmd.Key, opts, locs...)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: added}
+}
+
+// handleGeoPos processes GEOPOS commands and returns [lon, lat] per member,
+// or nil for missing members.
+func (s *Server) handleGeoPos(cmd *protocol.Command) *protocol.Response {
+	positions, err := s.cache.GeoPos(cmd.Key, cmd.Args...)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	result := make([]interface{}, len(positions))
+	for i, pos := range positions {
+		if pos != nil {
+			result[i] = []string{formatGeoCoord(pos.Longitude), formatGeoCoord(pos.Latitude)}
+		}
+	}
+	return &protocol.Response{Type: protocol.RespNested, Data: result}
+}
+
+// handleGeoDist processes GEODIST commands and returns the distance between
+// two members in the requested unit (meters by default).
+func (s *Server) handleGeoDist(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) < minGeoDistArgs || len(cmd.Args) > maxGeoDistArgs {
+		return &protocol.Response{Type: protocol.RespError, Error: "wrong number of arguments for GEODIST"}
+	}
+
+	unit := 1.0
+	if len(cmd.Args) == maxGeoDistArgs {
+		var err error
+		if unit, err = parseGeoUnit(cmd.Args[2]); err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	dist, ok, err := s.cache.GeoDist(cmd.Key, cmd.Args[0], cmd.Args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !ok {
+		return &protocol.Response{Type: protocol.RespNil}
+	}
+	return &protocol.Response{Type: protocol.RespString, Data: formatGeoDistance(dist, unit)}
+}
+
+// geoSearchOptions holds the reply options of GEOSEARCH.
+type geoSearchOptions struct {
+	unit      float64
+	withCoord bool
+	withDist  bool
+	withHash  bool
+}
+
+// handleGeoSearch processes GEOSEARCH commands and returns the members inside
+// a radius or box. With WITHDIST, WITHHASH or WITHCOORD each result is
+// [member, dist?, hash?, [lon, lat]?]; otherwise it is the member name.
+func (s *Server) handleGeoSearch(cmd *protocol.Command) *protocol.Response {
+	query, opts, err := parseGeoSearch(cmd.Args)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	results, err := s.cache.GeoSearch(cmd.Key, query)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	reply := make([]interface{}, len(results))
+	for i, r := range results {
+		if !opts.withCoord && !opts.withDist && !opts.withHash {
+			reply[i] = r.Member
+			continue
+		}
+
+		item := []interface{}{r.Member}
+		if opts.withDist {
+			item = append(item, formatGeoDistance(r.Distance, opts.unit))
+		}
+		if opts.withHash {
+			item = append(item, int64(r.Hash))
+		}
+		if opts.withCoord {
+			item = append(item, []string{formatGeoCoord(r.Longitude), formatGeoCoord(r.Latitude)})
+		}
+		reply[i] = item
+	}
+	return &protocol.Response{Type: protocol.RespNested, Data: reply}
+}
+
+// parseGeoSearch parses GEOSEARCH arguments into a cache query. Sizes are
+// converted to meters.
+func parseGeoSearch(args []string) (*cache.GeoSearchQuery, *geoSearchOptions, error) {
+	query := &cache.GeoSearchQuery{}
+	opts := &geoSearchOptions{unit: 1}
+	hasCenter, hasShape := false, false
+
+	missing := func(i, n int) bool { return i+n >= len(args) }
+
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "FROMMEMBER":
+			if hasCenter || missing(i, 1) {
+				return nil, nil, fmt.Errorf("syntax error")
+			}
+			query.FromMember = args[i+1]
+			hasCenter = true
+			i++
+		case "FROMLONLAT":
+			if hasCenter || missing(i, 2) {
+				return nil, nil, fmt.Errorf("syntax error")
+			}
+			coords, err := parseFloatArgs(args[i+1], args[i+2])
+			if err != nil {
+				return nil, nil, err
+			}
+			query.Longitude, query.Latitude = coords[0], coords[1]
+			hasCenter = true
+			i += 2
+		case "BYRADIUS":
+			if hasShape || missing(i, 2) {
+				return nil, nil, fmt.Errorf("syntax error")
+			}
+			values, err := parseFloatArgs(args[i+1])
+			if err != nil {
+				return nil, nil, err
+			}
+			if opts.unit, err = parseGeoUnit(args[i+2]); err != nil {
+				return nil, nil, err
+			}
+			query.Radius = values[0] * opts.unit
+			hasShape = true
+			i += 2
+		case "BYBOX":
+			if hasShape || missing(i, 3) {
+				return nil, nil, fmt.Errorf("syntax error")
+			}
+			values, err := parseFloatArgs(args[i+1], args[i+2])
+			if err != nil {
+				return nil, nil, err
+			}
+			if opts.unit, err = parseGeoUnit(args[i+3]); err != nil {
+				return nil, nil, err
+			}
+			query.Width, query.Height = values[0]*opts.unit, values[1]*opts.unit
+			hasShape = true
+			i += 3
+		case "ASC":
+			query.Sort = cache.GeoSortAsc
+		case "DESC":
+			query.Sort = cache.GeoSortDesc
+		case "COUNT":
+			if missing(i, 1) {
+				return nil, nil, fmt.Errorf("syntax error")
+			}
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil || count <= 0 {
+				return nil, nil, fmt.Errorf("COUNT must be > 0")
+			}
+			query.Count = count
+			i++
+			if i+1 < len(args) && strings.EqualFold(args[i+1], "ANY") {
+				query.Any = true
+				i++
+			}
+		case "WITHCOORD":
+			opts.withCoord = true
+		case "WITHDIST":
+			opts.withDist = true
+		case "WITHHASH":
+			opts.withHash = true
+		default:
+			return nil, nil, fmt.Errorf("syntax error")
+		}
+	}
+
+	if !hasCenter {
+		return nil, nil, fmt.Errorf("exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+	}
+	if !hasShape {
+		return nil, nil, fmt.Errorf("exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+	}
+	return query, opts, nil
+}
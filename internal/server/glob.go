@@ -0,0 +1,100 @@
+package server
+
+// globMatch reports whether str matches a Redis-style glob pattern:
+// '*' matches any sequence, '?' any single byte, "[abc]", "[a-z]" and
+// "[^a]" match byte classes, and '\' escapes the next byte.
+func globMatch(pattern, str string) bool {
+	px, sx := 0, 0
+	starPx, starSx := -1, -1
+
+	for px < len(pattern) || sx < len(str) {
+		if px < len(pattern) {
+			switch c := pattern[px]; c {
+			case '*':
+				// Try matching nothing first; backtrack to consume more on mismatch.
+				starPx, starSx = px, sx
+				px++
+				continue
+			case '?':
+				if sx < len(str) {
+					px++
+					sx++
+					continue
+				}
+			case '[':
+				if sx < len(str) {
+					if matched, width := matchGlobClass(pattern[px:], str[sx]); matched {
+						px += width
+						sx++
+						continue
+					}
+				}
+			case '\\':
+				literal, width := byte('\\'), 1
+				if px+1 < len(pattern) {
+					literal, width = pattern[px+1], 2
+				}
+				if sx < len(str) && str[sx] == literal {
+					px += width
+					sx++
+					continue
+				}
+			default:
+				if sx < len(str) && str[sx] == c {
+					px++
+					sx++
+					continue
+				}
+			}
+		}
+
+		if starPx >= 0 && starSx < len(str) {
+			starSx++
+			px, sx = starPx+1, starSx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// matchGlobClass matches ch against the bracket expression at the start of
+// p. It returns whether ch matched and the width of the expression. An
+// unterminated bracket matches a literal '['.
+func matchGlobClass(p string, ch byte) (matched bool, width int) {
+	i := 1
+	negate := i < len(p) && p[i] == '^'
+	if negate {
+		i++
+	}
+
+	for i < len(p) && p[i] != ']' {
+		c := p[i]
+		if c == '\\' && i+1 < len(p) {
+			i++
+			c = p[i]
+		}
+
+		if i+2 < len(p) && p[i+1] == '-' && p[i+2] != ']' {
+			lo, hi := c, p[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if ch >= lo && ch <= hi {
+				matched = true
+			}
+			i += 3
+			continue
+		}
+
+		if ch == c {
+			matched = true
+		}
+		i++
+	}
+
+	if i >= len(p) {
+		return ch == '[', 1
+	}
+	return matched != negate, i + 1
+}
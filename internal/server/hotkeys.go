@@ -0,0 +1,179 @@
+package server
+
+import (
+	"cmp"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// Hot key tracking parameters
+const (
+	hotKeyCapacity      = 128              // Keys whose access counts are kept
+	hotKeyHalfLife      = 30 * time.Second // Time for an access to count half as much
+	hotKeySampleRate    = 8                // One access in this many is counted
+	defaultHotKeysCount = 10               // Keys returned by HOTKEYS without a count
+)
+
+// hotKeys estimates the most accessed keys with the Space-Saving algorithm
+// (Metwally, Agrawal and El Abbadi, 2005): it counts the accesses of up to
+// hotKeyCapacity keys, and a key that isn't counted replaces the key with
+// the lowest count, inheriting it. Counts of keys accessed more often than
+// 1/hotKeyCapacity of the time are never underestimated, so such keys are
+// always reported. Counts decay with a half-life of hotKeyHalfLife, so
+// that keys that cool down make way for new ones.
+//
+// So that every keyed command doesn't contend on the same lock, only a
+// random sample of one access in sampleRate is counted, weighing
+// sampleRate accesses. Keys hot enough to matter are sampled many times a
+// second, so their estimates stay close.
+type hotKeys struct {
+	mu      sync.Mutex
+	heap    []hotKey       // Min-heap by count
+	index   map[string]int // Key -> position in heap
+	decayed time.Time      // Last time counts were decayed
+
+	sampleRate uint32 // Accesses per counted access
+}
+
+// hotKey is a key counted by hotKeys.
+type hotKey struct {
+	key   string
+	count float64 // Decayed accesses, including those inherited from the key it replaced
+}
+
+// newHotKeys creates an empty hot key tracker.
+func newHotKeys() *hotKeys {
+	return &hotKeys{
+		index:      make(map[string]int, hotKeyCapacity),
+		decayed:    time.Now(),
+		sampleRate: hotKeySampleRate,
+	}
+}
+
+// record counts an access to key, if it is sampled.
+func (h *hotKeys) record(key string) {
+	if h.sampleRate > 1 && rand.Uint32N(h.sampleRate) != 0 { //nolint:gosec // Sampling needs no secure randomness
+		return
+	}
+	weight := float64(h.sampleRate)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.decay(time.Now())
+	if i, exists := h.index[key]; exists {
+		h.heap[i].count += weight
+		h.down(i)
+		return
+	}
+	if len(h.heap) < hotKeyCapacity {
+		h.heap = append(h.heap, hotKey{key: key, count: weight})
+		h.index[key] = len(h.heap) - 1
+		h.up(len(h.heap) - 1)
+		return
+	}
+
+	// Replace the least counted key, the root of the heap.
+	least := h.heap[0]
+	delete(h.index, least.key)
+	h.heap[0] = hotKey{key: key, count: least.count + weight}
+	h.index[key] = 0
+	h.down(0)
+}
+
+// top returns up to n keys with the highest counts, highest first.
+func (h *hotKeys) top(n int) []hotKey {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.decay(time.Now())
+	keys := slices.Clone(h.heap)
+	slices.SortFunc(keys, func(a, b hotKey) int {
+		if a.count != b.count {
+			return cmp.Compare(b.count, a.count)
+		}
+		return cmp.Compare(a.key, b.key)
+	})
+	return keys[:min(n, len(keys))]
+}
+
+// decay scales the counts down for the time elapsed since the last decay.
+// Scaling every count by the same factor keeps the heap order. Callers
+// must hold h.mu.
+func (h *hotKeys) decay(now time.Time) {
+	elapsed := now.Sub(h.decayed)
+	if elapsed < time.Second {
+		return
+	}
+	factor := math.Exp2(-elapsed.Seconds() / hotKeyHalfLife.Seconds())
+	for i := range h.heap {
+		h.heap[i].count *= factor
+	}
+	h.decayed = now
+}
+
+// up moves the entry at i towards the root until the heap is ordered.
+// Callers must hold h.mu.
+func (h *hotKeys) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if h.heap[parent].count <= h.heap[i].count {
+			return
+		}
+		h.swap(i, parent)
+		i = parent
+	}
+}
+
+// down moves the entry at i towards the leaves until the heap is ordered.
+// Callers must hold h.mu.
+func (h *hotKeys) down(i int) {
+	for {
+		smallest := i
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(h.heap) && h.heap[child].count < h.heap[smallest].count {
+				smallest = child
+			}
+		}
+		if smallest == i {
+			return
+		}
+		h.swap(i, smallest)
+		i = smallest
+	}
+}
+
+// swap exchanges two heap entries. Callers must hold h.mu.
+func (h *hotKeys) swap(i, j int) {
+	h.heap[i], h.heap[j] = h.heap[j], h.heap[i]
+	h.index[h.heap[i].key] = i
+	h.index[h.heap[j].key] = j
+}
+
+// handleHotKeys processes HOTKEYS [count], returning the most accessed
+// keys of the server as [[key, hits], ...], most accessed first. Hits are
+// decayed estimates from a sample of the accesses: recent accesses count
+// fully, and accesses count half as much every 30 seconds.
+func (s *Server) handleHotKeys(cmd *protocol.Command) *protocol.Response {
+	count := defaultHotKeysCount
+	if len(cmd.Args) > 0 {
+		n, err := strconv.Atoi(cmd.Args[0])
+		if err != nil || n <= 0 {
+			return &protocol.Response{Type: protocol.RespError, Error: "count must be a positive integer"}
+		}
+		count = n
+	}
+
+	keys := s.hotKeys.top(count)
+	entries := make([]interface{}, len(keys))
+	for i, k := range keys {
+		entries[i] = []interface{}{k.key, int64(math.Round(k.count))}
+	}
+	return &protocol.Response{Type: protocol.RespNested, Data: entries}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// newExactHotKeys returns a tracker that counts every access.
+func newExactHotKeys() *hotKeys {
+	h := newHotKeys()
+	h.sampleRate = 1
+	return h
+}
+
+func TestHotKeysCounts(t *testing.T) {
+	h := newExactHotKeys()
+	for key, accesses := range map[string]int{"a": 5, "b": 3, "c": 1} {
+		for i := 0; i < accesses; i++ {
+			h.record(key)
+		}
+	}
+
+	top := h.top(2)
+	if len(top) != 2 || top[0].key != "a" || top[0].count != 5 || top[1].key != "b" || top[1].count != 3 {
+		t.Errorf("Expected [a:5 b:3], got %+v", top)
+	}
+	if top := h.top(10); len(top) != 3 {
+		t.Errorf("Expected every counted key, got %+v", top)
+	}
+}
+
+func TestHotKeysSpaceSaving(t *testing.T) {
+	h := newExactHotKeys()
+
+	// Two hot keys among many more cold keys than the tracker can hold.
+	for i := 0; i < 1000; i++ {
+		h.record("cold:" + strconv.Itoa(i))
+		if i%10 == 0 {
+			h.record("hot:1")
+			h.record("hot:2")
+		}
+	}
+
+	top := h.top(2)
+	if len(top) != 2 {
+		t.Fatalf("Expected 2 keys, got %+v", top)
+	}
+	for _, k := range top {
+		if k.key != "hot:1" && k.key != "hot:2" {
+			t.Errorf("Expected the hot keys on top, got %+v", top)
+		}
+		if k.count < 100 {
+			t.Errorf("Space-Saving never underestimates, but %s has %v of 100 accesses", k.key, k.count)
+		}
+	}
+	if len(h.heap) != hotKeyCapacity || len(h.index) != hotKeyCapacity {
+		t.Errorf("Expected %d tracked keys, got %d (index %d)", hotKeyCapacity, len(h.heap), len(h.index))
+	}
+	for key, i := range h.index {
+		if h.heap[i].key != key {
+			t.Fatalf("Index of %s points to %s", key, h.heap[i].key)
+		}
+	}
+}
+
+func TestHotKeysReplacementInheritsCount(t *testing.T) {
+	h := newExactHotKeys()
+	for i := 0; i < hotKeyCapacity; i++ {
+		h.record("key:" + strconv.Itoa(i))
+	}
+	h.record("new")
+
+	top := h.top(hotKeyCapacity)
+	if top[0].key != "new" || top[0].count != 2 {
+		t.Errorf("Expected the new key to inherit the least count plus one, got %+v", top[0])
+	}
+	if len(top) != hotKeyCapacity {
+		t.Errorf("Expected %d keys, got %d", hotKeyCapacity, len(top))
+	}
+}
+
+func TestHotKeysDecay(t *testing.T) {
+	h := newExactHotKeys()
+	for i := 0; i < 8; i++ {
+		h.record("key")
+	}
+
+	h.mu.Lock()
+	h.decay(h.decayed.Add(hotKeyHalfLife / 60)) // Under a second: no decay yet
+	if count := h.heap[0].count; count != 8 {
+		t.Errorf("Expected no decay within a second, got %v", count)
+	}
+	h.decay(h.decayed.Add(hotKeyHalfLife))
+	if count := h.heap[0].count; count != 4 {
+		t.Errorf("Expected the count to halve after a half-life, got %v", count)
+	}
+	h.decay(h.decayed.Add(2 * hotKeyHalfLife))
+	if count := h.heap[0].count; count != 1 {
+		t.Errorf("Expected the count to quarter after two half-lives, got %v", count)
+	}
+	h.mu.Unlock()
+}
+
+func TestHotKeysSampling(t *testing.T) {
+	h := newHotKeys()
+	const accesses = 16000
+	for i := 0; i < accesses; i++ {
+		h.record("key")
+	}
+
+	top := h.top(1)
+	if len(top) != 1 {
+		t.Fatal("Expected the sampled key to be counted")
+	}
+	if diff := math.Abs(top[0].count-accesses) / accesses; diff > 0.2 {
+		t.Errorf("Expected an estimate close to %d, got %v", accesses, top[0].count)
+	}
+}
+
+func TestHandleHotKeys(t *testing.T) {
+	s := New(0)
+	s.hotKeys.sampleRate = 1
+
+	for i := 0; i < 3; i++ {
+		s.executeCommand(command(protocol.CmdGet, "hot"))
+	}
+	s.executeCommand(command(protocol.CmdSet, "warm", "value"))
+
+	resp := s.executeCommand(command(protocol.CmdHotKeys, "", "1"))
+	entries, _ := resp.Data.([]interface{})
+	if len(entries) != 1 {
+		t.Fatalf("Expected one hot key, got %+v", resp)
+	}
+	if pair, _ := entries[0].([]interface{}); len(pair) != 2 || pair[0] != "hot" || pair[1] != int64(3) {
+		t.Errorf("Expected [hot 3], got %v", entries[0])
+	}
+
+	resp = s.executeCommand(command(protocol.CmdHotKeys, ""))
+	if entries, _ := resp.Data.([]interface{}); len(entries) != 2 {
+		t.Errorf("Expected both keys by default, got %+v", resp)
+	}
+	if resp := s.executeCommand(command(protocol.CmdHotKeys, "", "0")); resp.Type != protocol.RespError {
+		t.Errorf("Expected an error for a zero count, got %+v", resp)
+	}
+}
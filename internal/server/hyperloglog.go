@@ -0,0 +1,64 @@
+package server
+
+import (
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// handlePFAdd processes PFADD commands to add elements to a HyperLogLog.
+// Returns 1 if the HyperLogLog was created or modified, 0 otherwise.
+func (s *Server) handlePFAdd(cmd *protocol.Command) *protocol.Response {
+	changed, err := s.cache.PFAdd(cmd.Key, cmd.Args...)
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: err.Error()}
+	}
+	var result int64 = 0
+	if changed {
+		result = 1
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: result}
+}
+
+// handlePFCount processes PFCOUNT commands. Additional keys may be passed as
+// arguments, in which case the cardinality of the union is returned.
+func (s *Server) handlePFCount(cmd *protocol.Command) *protocol.Response {
+	keys := append([]string{cmd.Key}, cmd.Args...)
+	count, err := s.cache.PFCount(keys...)
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: err.Error()}
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: count}
+}
+
+// handlePFMerge processes PFMERGE commands. The command key is the destination
+// and the arguments are the source keys.
+func (s *Server) handlePFMerge(cmd *protocol.Command) *protocol.Response {
+	if err := s.cache.PFMerge(cmd.Key, cmd.Args...); err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: err.Error()}
+	}
+	return &protocol.Response{Type: protocol.RespOK}
+}
+
+// handlePFExport processes PFEXPORT commands, returning the serialized
+// HyperLogLog or a nil response if the key doesn't exist.
+func (s *Server) handlePFExport(cmd *protocol.Command) *protocol.Response {
+	data, exists, err := s.cache.PFExport(cmd.Key)
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: err.Error()}
+	}
+	if !exists {
+		return &protocol.Response{Type: protocol.RespNil}
+	}
+	return &protocol.Response{Type: protocol.RespString, Data: string(data)}
+}
+
+// handlePFImport processes PFIMPORT commands, merging a serialized
+// HyperLogLog into the key.
+func (s *Server) handlePFImport(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) == 0 {
+		return &protocol.Response{Type: protocol.RespError, Error: "PFIMPORT requires serialized data"}
+	}
+	if err := s.cache.PFImport(cmd.Key, []byte(cmd.Args[0])); err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: err.Error()}
+	}
+	return &protocol.Response{Type: protocol.RespOK}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/cachemir/cachemir/pkg/cache"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// JSON argument counts
+const (
+	minJSONSetArgs      = 2
+	maxJSONSetArgs      = 3
+	maxJSONDelArgs      = 1
+	jsonNumIncrByArgs   = 2
+	minJSONArrAppendArg = 2
+)
+
+// handleJSONSet processes JSON.SET commands. Returns OK when the value was
+// written, or nil when an NX/XX condition was not met.
+func (s *Server) handleJSONSet(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) < minJSONSetArgs || len(cmd.Args) > maxJSONSetArgs {
+		return &protocol.Response{Type: protocol.RespError, Error: "wrong number of arguments for JSON.SET"}
+	}
+
+	mode := cache.JSONSetAlways
+	if len(cmd.Args) == maxJSONSetArgs {
+		switch strings.ToUpper(cmd.Args[2]) {
+		case "NX":
+			mode = cache.JSONSetIfAbsent
+		case "XX":
+			mode = cache.JSONSetIfExists
+		default:
+			return &protocol.Response{Type: protocol.RespError, Error: "syntax error"}
+		}
+	}
+
+	written, err := s.cache.JSONSet(cmd.Key, cmd.Args[0], cmd.Args[1], mode)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !written {
+		return &protocol.Response{Type: protocol.RespNil}
+	}
+	return &protocol.Response{Type: protocol.RespOK}
+}
+
+// handleJSONGet processes JSON.GET commands and returns JSON text, or nil if
+// the key doesn't exist.
+func (s *Server) handleJSONGet(cmd *protocol.Command) *protocol.Response {
+	text, exists, err := s.cache.JSONGet(cmd.Key, cmd.Args...)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !exists {
+		return &protocol.Response{Type: protocol.RespNil}
+	}
+	return &protocol.Response{Type: protocol.RespString, Data: text}
+}
+
+// handleJSONDel processes JSON.DEL commands and returns the number of values
+// removed. Without a path the whole document is deleted.
+func (s *Server) handleJSONDel(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) > maxJSONDelArgs {
+		return &protocol.Response{Type: protocol.RespError, Error: "wrong number of arguments for JSON.DEL"}
+	}
+
+	path := "$"
+	if len(cmd.Args) == maxJSONDelArgs {
+		path = cmd.Args[0]
+	}
+
+	removed, err := s.cache.JSONDel(cmd.Key, path)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: removed}
+}
+
+// handleJSONNumIncrBy processes JSON.NUMINCRBY commands and returns a JSON
+// array of the new values.
+func (s *Server) handleJSONNumIncrBy(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) != jsonNumIncrByArgs {
+		return &protocol.Response{Type: protocol.RespError, Error: "wrong number of arguments for JSON.NUMINCRBY"}
+	}
+
+	text, err := s.cache.JSONNumIncrBy(cmd.Key, cmd.Args[0], cmd.Args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &protocol.Response{Type: protocol.RespString, Data: text}
+}
+
+// handleJSONArrAppend processes JSON.ARRAPPEND commands and returns the new
+// length of each matched array, or nil for matches that are not arrays.
+func (s *Server) handleJSONArrAppend(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) < minJSONArrAppendArg {
+		return &protocol.Response{Type: protocol.RespError, Error: "wrong number of arguments for JSON.ARRAPPEND"}
+	}
+
+	lengths, err := s.cache.JSONArrAppend(cmd.Key, cmd.Args[0], cmd.Args[1:]...)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	result := make([]interface{}, len(lengths))
+	for i, length := range lengths {
+		if length >= 0 {
+			result[i] = length
+		}
+	}
+	return &protocol.Response{Type: protocol.RespNested, Data: result}
+}
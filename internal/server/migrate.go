@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/cache"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// handleKeys processes KEYS commands, returning the keys that match the
+// glob pattern in Args[0], sorted. It walks the whole keyspace, so it is
+// meant for administration tasks such as resharding.
+func (s *Server) handleKeys(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) != 1 {
+		return &protocol.Response{Type: protocol.RespError, Error: "KEYS requires a pattern"}
+	}
+
+	var keys []string
+	for _, key := range s.cache.Keys() {
+		if globMatch(cmd.Args[0], key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return &protocol.Response{Type: protocol.RespArray, Data: keys}
+}
+
+// defaultScanCount is the number of keys SCAN returns without COUNT.
+const defaultScanCount = 10
+
+// handleScan processes SCAN commands, returning a batch of keys and the
+// cursor to continue from:
+//
+//	SCAN cursor [MATCH pattern] [COUNT count]
+//
+// The reply is [next cursor, [keys...]]; the cursor is "0" once every key
+// has been returned. Unlike KEYS, SCAN returns a bounded number of keys
+// per call, though each call still walks the keyspace (see cache.Scan).
+func (s *Server) handleScan(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) == 0 {
+		return &protocol.Response{Type: protocol.RespError, Error: "SCAN requires a cursor"}
+	}
+	cursor, err := strconv.ParseUint(cmd.Args[0], 10, 64)
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: "invalid cursor"}
+	}
+
+	count := defaultScanCount
+	var match func(key string) bool
+	for i := 1; i < len(cmd.Args); i += 2 {
+		if i+1 >= len(cmd.Args) {
+			return &protocol.Response{Type: protocol.RespError, Error: "syntax error"}
+		}
+		switch value := cmd.Args[i+1]; strings.ToUpper(cmd.Args[i]) {
+		case "MATCH":
+			match = func(key string) bool { return globMatch(value, key) }
+		case "COUNT":
+			if count, err = strconv.Atoi(value); err != nil || count <= 0 {
+				return &protocol.Response{Type: protocol.RespError, Error: "COUNT must be a positive integer"}
+			}
+		default:
+			return &protocol.Response{Type: protocol.RespError, Error: "syntax error"}
+		}
+	}
+
+	keys, next := s.cache.Scan(cursor, count, match)
+	return &protocol.Response{
+		Type: protocol.RespNested,
+		Data: []interface{}{strconv.FormatUint(next, 10), keys},
+	}
+}
+
+// handleMigrate processes MIGRATE commands, moving keys to another node:
+//
+//	MIGRATE host port timeout_ms [COPY] [REPLACE] [KEYS key...]
+//
+// cmd.Key is the key to move, or empty with KEYS. Each key is sent to the
+// target with RESTORE, keeping its TTL, and deleted locally once the
+// target has accepted it, unless COPY is given. Without REPLACE, keys that
+// exist on the target fail with BUSYKEY and are kept.
+//
+// The cache is locked for the whole exchange, so that no key changes
+// between being sent and being deleted. The reply is OK, NOKEY if none of
+// the keys exist, or the first error returned by the target; keys the
+// target accepted are moved even then.
+func (s *Server) handleMigrate(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) < 3 {
+		return &protocol.Response{Type: protocol.RespError, Error: "MIGRATE requires host, port and timeout"}
+	}
+	timeoutMs, err := strconv.ParseInt(cmd.Args[2], 10, 64)
+	if err != nil || timeoutMs <= 0 {
+		return &protocol.Response{Type: protocol.RespError, Error: "invalid timeout, must be > 0"}
+	}
+
+	var copyKeys, replace bool
+	keys := []string{cmd.Key}
+	for i := 3; i < len(cmd.Args); i++ {
+		switch strings.ToUpper(cmd.Args[i]) {
+		case "COPY":
+			copyKeys = true
+		case "REPLACE":
+			replace = true
+		case "KEYS":
+			if cmd.Key != "" {
+				return &protocol.Response{Type: protocol.RespError, Error: "the key must be empty with MIGRATE KEYS"}
+			}
+			keys = cmd.Args[i+1:]
+			i = len(cmd.Args)
+		default:
+			return &protocol.Response{Type: protocol.RespError, Error: "syntax error"}
+		}
+	}
+
+	m := &migration{
+		addr:     net.JoinHostPort(cmd.Args[0], cmd.Args[1]),
+		timeout:  time.Duration(timeoutMs) * time.Millisecond,
+		copyKeys: copyKeys,
+		replace:  replace,
+	}
+	var resp *protocol.Response
+	s.cache.Transaction(nil, func(view *cache.Cache) {
+		resp = m.run(view, keys)
+	})
+	return resp
+}
+
+// migration is a MIGRATE in progress.
+type migration struct {
+	addr     string
+	timeout  time.Duration
+	copyKeys bool
+	replace  bool
+}
+
+// run sends keys to the target and deletes those it accepted from view.
+func (m *migration) run(view *cache.Cache, keys []string) *protocol.Response {
+	var restores []*protocol.Command
+	for _, key := range keys {
+		payload, ttl, exists := view.Dump(key)
+		if !exists {
+			continue
+		}
+		restore := restoreCommand(key, payload, ttl, true)
+		if !m.replace {
+			restore.Args = restore.Args[:2]
+		}
+		restores = append(restores, restore)
+	}
+	if len(restores) == 0 {
+		return &protocol.Response{Type: protocol.RespString, Data: "NOKEY"}
+	}
+
+	replies, err := m.send(restores)
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: "IOERR migrating to " + m.addr + ": " + err.Error()}
+	}
+
+	var firstErr string
+	for i, reply := range replies {
+		if reply.Type == protocol.RespError {
+			if firstErr == "" {
+				firstErr = reply.Error
+			}
+			continue
+		}
+		if !m.copyKeys {
+			view.Del(restores[i].Key)
+		}
+	}
+	if firstErr != "" {
+		return &protocol.Response{Type: protocol.RespError, Error: firstErr}
+	}
+	return &protocol.Response{Type: protocol.RespOK}
+}
+
+// send pipelines cmds to the target and reads a reply for each of them.
+func (m *migration) send(cmds []*protocol.Command) ([]*protocol.Response, error) {
+	dialer := &net.Dialer{Timeout: m.timeout}
+	conn, err := dialer.DialContext(context.Background(), "tcp", m.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("Error closing connection: %v", err)
+		}
+	}()
+	if err := conn.SetDeadline(time.Now().Add(m.timeout)); err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(conn)
+	for _, cmd := range cmds {
+		if err := protocol.WriteCommand(w, cmd); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	replies := make([]*protocol.Response, len(cmds))
+	for i := range replies {
+		if replies[i], err = protocol.ReadResponse(r); err != nil {
+			return nil, err
+		}
+	}
+	return replies, nil
+}
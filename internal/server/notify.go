@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/cachemir/cachemir/pkg/cache"
+)
+
+// Keyspace notification channel prefixes. The server has a single database,
+// numbered 0 as in Redis, so existing tooling can subscribe unchanged.
+const (
+	keyspaceChannelPrefix = "__keyspace@0__:"
+	keyeventChannelPrefix = "__keyevent@0__:"
+)
+
+// keyspaceEventClasses maps notification flag characters to event classes,
+// following Redis's notify-keyspace-events. Geo sets use the sorted set
+// flag and JSON documents the module flag, matching how Redis stores them.
+// There is no 'e' (evicted) flag, since the cache never evicts keys.
+var keyspaceEventClasses = map[rune]cache.EventClass{
+	'g': cache.EventGeneric,
+	'$': cache.EventString,
+	'l': cache.EventList,
+	's': cache.EventSet,
+	'h': cache.EventHash,
+	'z': cache.EventGeo,
+	'x': cache.EventExpired,
+	't': cache.EventStream,
+	'd': cache.EventJSON,
+	'A': cache.EventAll,
+}
+
+// parseKeyspaceEvents parses notification flags: 'K' publishes to
+// __keyspace@0__:<key> with the event name as message, 'E' publishes to
+// __keyevent@0__:<event> with the key as message, and the remaining
+// characters select event classes ('A' for all). Notifications are enabled
+// only if at least one of K and E and one class are given.
+func parseKeyspaceEvents(flags string) (keyspace, keyevent bool, classes cache.EventClass, err error) {
+	for _, flag := range flags {
+		switch flag {
+		case 'K':
+			keyspace = true
+		case 'E':
+			keyevent = true
+		default:
+			class, ok := keyspaceEventClasses[flag]
+			if !ok {
+				return false, false, 0, fmt.Errorf("invalid keyspace event flag %q", flag)
+			}
+			classes |= class
+		}
+	}
+	return keyspace, keyevent, classes, nil
+}
+
+// SetNotifyKeyspaceEvents configures which keyspace events the server
+// publishes, using Redis's notify-keyspace-events flags. An empty string
+// disables notifications, which is the default.
+//
+// Example:
+//
+//	// Publish expirations and generic commands (DEL, EXPIRE...) to both channel families
+//	if err := server.SetNotifyKeyspaceEvents("KEgx"); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// Returns:
+//   - Error if the flags contain an unknown character
+func (s *Server) SetNotifyKeyspaceEvents(flags string) error {
+	keyspace, keyevent, classes, err := parseKeyspaceEvents(flags)
+	if err != nil {
+		return err
+	}
+
+	if (!keyspace && !keyevent) || classes == 0 {
+		s.cache.SetEventHandler(0, nil)
+		return nil
+	}
+
+	s.cache.SetEventHandler(classes, func(e cache.Event) {
+		if keyspace {
+			s.pubsub.publish(keyspaceChannelPrefix+e.Key, e.Name)
+		}
+		if keyevent {
+			s.pubsub.publish(keyeventChannelPrefix+e.Name, e.Key)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// notifications starts a server with the given keyspace event flags and
+// returns a connection to send commands on and one subscribed to every
+// notification channel.
+func notifications(t *testing.T, flags string) (conn, events net.Conn) {
+	t.Helper()
+
+	_, addr := startServerWith(t, func(s *Server, _ string) {
+		if err := s.SetNotifyKeyspaceEvents(flags); err != nil {
+			t.Fatalf("SetNotifyKeyspaceEvents(%q) failed: %v", flags, err)
+		}
+	})
+	return dial(t, addr), subscribe(t, addr, protocol.CmdPSubscribe, "__key*@0__:*")
+}
+
+func TestKeyspaceNotificationFlags(t *testing.T) {
+	tests := []struct {
+		flags string
+		want  [][2]string // Channel and message of each notification
+	}{
+		{"", nil}, // Disabled by default
+		{"KE", nil},
+		{"g$", nil},
+		{"Kg", [][2]string{{"__keyspace@0__:k", "del"}}},
+		{"Eg", [][2]string{{"__keyevent@0__:del", "k"}}},
+		{"K$", [][2]string{{"__keyspace@0__:k", "set"}}},
+		{"E$", [][2]string{{"__keyevent@0__:set", "k"}}},
+		{"KEA", [][2]string{
+			{"__keyspace@0__:k", "set"}, {"__keyevent@0__:set", "k"},
+			{"__keyspace@0__:k", "del"}, {"__keyevent@0__:del", "k"},
+		}},
+	}
+	for _, tt := range tests {
+		conn, events := notifications(t, tt.flags)
+		roundTrip(t, conn, command(protocol.CmdSet, "k", "v"))
+		roundTrip(t, conn, command(protocol.CmdDel, "k"))
+
+		for _, want := range tt.want {
+			push := readPush(t, events)
+			if push[2] != want[0] || push[3] != want[1] {
+				t.Errorf("%q: expected %v, got %v", tt.flags, want, push)
+			}
+		}
+		expectNoPush(t, events)
+	}
+}
+
+func TestKeyspaceNotificationExpired(t *testing.T) {
+	conn, events := notifications(t, "Ex")
+
+	set := command(protocol.CmdSet, "session", "v")
+	set.TTL = time.Second
+	roundTrip(t, conn, set)
+	expectNoPush(t, events) // Only expirations are selected
+
+	waitFor(t, "the key to expire", func() bool {
+		return roundTrip(t, conn, command(protocol.CmdGet, "session")).Type == protocol.RespNil
+	})
+	want := []interface{}{"pmessage", "__key*@0__:*", "__keyevent@0__:expired", "session"}
+	if push := readPush(t, events); !slices.Equal(push, want) {
+		t.Errorf("Expected %v, got %v", want, push)
+	}
+}
+
+func TestKeyspaceNotificationInvalidFlags(t *testing.T) {
+	s, _ := startServer(t)
+	for _, flags := range []string{"Ke", "KEq"} {
+		if err := s.SetNotifyKeyspaceEvents(flags); err == nil {
+			t.Errorf("Expected %q to be rejected", flags)
+		}
+	}
+}
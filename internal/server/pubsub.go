@@ -0,0 +1,307 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// subscriberQueueSize bounds the messages buffered for a subscriber. A
+// subscriber that falls this far behind is disconnected rather than
+// allowed to stall publishers or grow memory without limit.
+const subscriberQueueSize = 1024
+
+// subscriptionKind distinguishes the three subscription namespaces.
+type subscriptionKind uint8
+
+const (
+	subscriptionChannel subscriptionKind = iota // SUBSCRIBE
+	subscriptionPattern                         // PSUBSCRIBE
+	subscriptionShard                           // SSUBSCRIBE
+	subscriptionKinds
+)
+
+// Push message names confirming subscription changes, per kind.
+var (
+	subscribeReplies   = [subscriptionKinds]string{"subscribe", "psubscribe", "ssubscribe"}
+	unsubscribeReplies = [subscriptionKinds]string{"unsubscribe", "punsubscribe", "sunsubscribe"}
+)
+
+// subscriber is a connection in subscriber mode. Every response and push
+// message for the connection goes through out, which is drained by a single
+// writer goroutine so that pushes never interleave with replies.
+type subscriber struct {
+	conn    net.Conn
+	out     chan *protocol.Response
+	subs    [subscriptionKinds]map[string]struct{} // Protected by pubSub.mu
+	closing atomic.Bool                            // Set when the subscriber is disconnected for being slow
+}
+
+func newSubscriber(conn net.Conn) *subscriber {
+	sub := &subscriber{
+		conn: conn,
+		out:  make(chan *protocol.Response, subscriberQueueSize),
+	}
+	for kind := range sub.subs {
+		sub.subs[kind] = make(map[string]struct{})
+	}
+	go sub.writeLoop()
+	return sub
+}
+
+// count returns the subscription count reported in confirmations of the
+// given kind: sharded channels are counted separately, as in Redis.
+// Callers must hold pubSub.mu.
+func (sub *subscriber) count(kind subscriptionKind) int64 {
+	if kind == subscriptionShard {
+		return int64(len(sub.subs[subscriptionShard]))
+	}
+	return int64(len(sub.subs[subscriptionChannel]) + len(sub.subs[subscriptionPattern]))
+}
+
+// send queues a response without blocking. A full queue disconnects the
+// subscriber, which ends its connection handler, and later responses are
+// dropped.
+func (sub *subscriber) send(resp *protocol.Response) bool {
+	if sub.closing.Load() {
+		return false
+	}
+	select {
+	case sub.out <- resp:
+		return true
+	default:
+		if !sub.closing.CompareAndSwap(false, true) {
+			return false // Another sender is disconnecting it
+		}
+		log.Printf("Disconnecting slow subscriber %s", sub.conn.RemoteAddr())
+		if err := sub.conn.Close(); err != nil {
+			log.Printf("Error closing connection: %v", err)
+		}
+		return false
+	}
+}
+
+// writeLoop writes queued responses until the queue is closed.
+func (sub *subscriber) writeLoop() {
+	for resp := range sub.out {
+		err := sub.conn.SetWriteDeadline(time.Now().Add(defaultWriteTimeoutSecs * time.Second))
+		if err == nil {
+			err = protocol.WriteResponse(sub.conn, resp)
+		}
+		if err != nil {
+			log.Printf("Failed to write to subscriber: %v", err)
+			break
+		}
+	}
+
+	// On a write failure, unblock the reader by closing the connection and
+	// keep draining so senders never block on a dead connection.
+	if err := sub.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("Error closing connection: %v", err)
+	}
+	for range sub.out {
+	}
+}
+
+// pushResponse builds a push message.
+func pushResponse(items ...interface{}) *protocol.Response {
+	return &protocol.Response{Type: protocol.RespPush, Data: items}
+}
+
+// pubSub tracks channel, pattern and sharded channel subscriptions for one
+// server.
+type pubSub struct {
+	index [subscriptionKinds]map[string]map[*subscriber]struct{}
+	mu    sync.RWMutex
+}
+
+func newPubSub() *pubSub {
+	ps := &pubSub{}
+	for kind := range ps.index {
+		ps.index[kind] = make(map[string]map[*subscriber]struct{})
+	}
+	return ps
+}
+
+// subscriptions returns the total number of subscriptions held by sub.
+func (ps *pubSub) subscriptions(sub *subscriber) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	total := 0
+	for _, names := range sub.subs {
+		total += len(names)
+	}
+	return total
+}
+
+// subscribe adds sub to each named channel or pattern and confirms each one
+// with a [kind, name, count] push.
+func (ps *pubSub) subscribe(sub *subscriber, kind subscriptionKind, names []string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	index, own := ps.index[kind], sub.subs[kind]
+	for _, name := range names {
+		if _, exists := own[name]; !exists {
+			own[name] = struct{}{}
+			if index[name] == nil {
+				index[name] = make(map[*subscriber]struct{})
+			}
+			index[name][sub] = struct{}{}
+		}
+		sub.send(pushResponse(subscribeReplies[kind], name, sub.count(kind)))
+	}
+}
+
+// unsubscribe removes sub from each named channel or pattern, or from all of
+// them when names is empty, confirming each with a [kind, name, count] push.
+func (ps *pubSub) unsubscribe(sub *subscriber, kind subscriptionKind, names []string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	index, own := ps.index[kind], sub.subs[kind]
+	if len(names) == 0 {
+		for name := range own {
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			sub.send(pushResponse(unsubscribeReplies[kind], nil, sub.count(kind)))
+			return
+		}
+	}
+
+	for _, name := range names {
+		if _, exists := own[name]; exists {
+			delete(own, name)
+			delete(index[name], sub)
+			if len(index[name]) == 0 {
+				delete(index, name)
+			}
+		}
+		sub.send(pushResponse(unsubscribeReplies[kind], name, sub.count(kind)))
+	}
+}
+
+// remove drops every subscription of sub. Once it returns, no publisher
+// will send to sub again.
+func (ps *pubSub) remove(sub *subscriber) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for kind, names := range sub.subs {
+		index := ps.index[kind]
+		for name := range names {
+			delete(index[name], sub)
+			if len(index[name]) == 0 {
+				delete(index, name)
+			}
+		}
+		sub.subs[kind] = make(map[string]struct{})
+	}
+}
+
+// publish delivers a message to the subscribers of channel and of every
+// matching pattern. Returns the number of deliveries.
+func (ps *pubSub) publish(channel, message string) int64 {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	var receivers int64
+	for sub := range ps.index[subscriptionChannel][channel] {
+		if sub.send(pushResponse("message", channel, message)) {
+			receivers++
+		}
+	}
+	for pattern, subs := range ps.index[subscriptionPattern] {
+		if !globMatch(pattern, channel) {
+			continue
+		}
+		for sub := range subs {
+			if sub.send(pushResponse("pmessage", pattern, channel, message)) {
+				receivers++
+			}
+		}
+	}
+	return receivers
+}
+
+// spublish delivers a message to the subscribers of a sharded channel.
+// Patterns never match sharded channels.
+func (ps *pubSub) spublish(channel, message string) int64 {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	var receivers int64
+	for sub := range ps.index[subscriptionShard][channel] {
+		if sub.send(pushResponse("smessage", channel, message)) {
+			receivers++
+		}
+	}
+	return receivers
+}
+
+// subscriptionCommands maps subscription commands to their kind and
+// whether they subscribe (true) or unsubscribe.
+var subscriptionCommands = map[protocol.CommandType]struct {
+	kind      subscriptionKind
+	subscribe bool
+}{
+	protocol.CmdSubscribe:    {subscriptionChannel, true},
+	protocol.CmdUnsubscribe:  {subscriptionChannel, false},
+	protocol.CmdPSubscribe:   {subscriptionPattern, true},
+	protocol.CmdPUnsubscribe: {subscriptionPattern, false},
+	protocol.CmdSSubscribe:   {subscriptionShard, true},
+	protocol.CmdSUnsubscribe: {subscriptionShard, false},
+}
+
+// isSubscriptionCommand reports whether a command changes the connection's
+// subscriptions and is therefore handled with connection context.
+func isSubscriptionCommand(cmdType protocol.CommandType) bool {
+	_, ok := subscriptionCommands[cmdType]
+	return ok
+}
+
+// handleSubscriptionCommand processes the (P|S)SUBSCRIBE and
+// (P|S)UNSUBSCRIBE commands for a subscriber connection. Replies are sent as
+// push messages.
+func (s *Server) handleSubscriptionCommand(sub *subscriber, cmd *protocol.Command) {
+	spec := subscriptionCommands[cmd.Type]
+	if !spec.subscribe {
+		s.pubsub.unsubscribe(sub, spec.kind, cmd.Args)
+		return
+	}
+
+	if len(cmd.Args) == 0 {
+		sub.send(&protocol.Response{Type: protocol.RespError, Error: "wrong number of arguments for " + strings.ToUpper(subscribeReplies[spec.kind])})
+		return
+	}
+	s.pubsub.subscribe(sub, spec.kind, cmd.Args)
+}
+
+// handlePublish processes PUBLISH commands. The command key is the channel.
+// Returns the number of subscribers on this node that received the message.
+// Messages aren't forwarded to other nodes, even in cluster mode: clients
+// send PUBLISH to every node, since a channel's subscribers may be held on
+// any of them.
+func (s *Server) handlePublish(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) != 1 {
+		return &protocol.Response{Type: protocol.RespError, Error: "wrong number of arguments for PUBLISH"}
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: s.pubsub.publish(cmd.Key, cmd.Args[0])}
+}
+
+// handleSPublish processes SPUBLISH commands for sharded channels. The
+// command key is the channel. Returns the number of receivers.
+func (s *Server) handleSPublish(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) != 1 {
+		return &protocol.Response{Type: protocol.RespError, Error: "wrong number of arguments for SPUBLISH"}
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: s.pubsub.spublish(cmd.Key, cmd.Args[0])}
+}
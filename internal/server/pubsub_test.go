@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+func TestPublishDelivers(t *testing.T) {
+	_, addr := startServer(t)
+	sub := subscribe(t, addr, protocol.CmdSubscribe, "news", "weather")
+	pub := dial(t, addr)
+
+	if resp := roundTrip(t, pub, command(protocol.CmdPublish, "news", "hello")); resp.Data != int64(1) {
+		t.Errorf("Expected 1 receiver, got %+v", resp)
+	}
+	if push := readPush(t, sub); len(push) != 3 || push[0] != "message" || push[1] != "news" || push[2] != "hello" {
+		t.Errorf("Expected [message news hello], got %v", push)
+	}
+	if resp := roundTrip(t, pub, command(protocol.CmdPublish, "other", "ignored")); resp.Data != int64(0) {
+		t.Errorf("Expected no receivers, got %+v", resp)
+	}
+	expectNoPush(t, sub)
+}
+
+func TestSubscriberMode(t *testing.T) {
+	_, addr := startServer(t)
+	conn := subscribe(t, addr, protocol.CmdSubscribe, "news")
+
+	// Only subscription commands and PING are accepted.
+	if resp := roundTrip(t, conn, command(protocol.CmdGet, "key")); resp.Type != protocol.RespError ||
+		!strings.Contains(resp.Error, "subscriber mode") {
+		t.Errorf("Expected GET to be rejected in subscriber mode, got %+v", resp)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdPing, "")); resp.Type == protocol.RespError {
+		t.Errorf("Expected PING to be accepted, got %+v", resp)
+	}
+
+	// Confirmations count the remaining subscriptions.
+	if err := protocol.WriteCommand(conn, command(protocol.CmdPSubscribe, "", "n*")); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	if push := readPush(t, conn); push[0] != "psubscribe" || push[1] != "n*" || push[2] != int64(2) {
+		t.Errorf("Expected [psubscribe n* 2], got %v", push)
+	}
+	if err := protocol.WriteCommand(conn, command(protocol.CmdUnsubscribe, "")); err != nil {
+		t.Fatalf("Failed to unsubscribe: %v", err)
+	}
+	if push := readPush(t, conn); push[0] != "unsubscribe" || push[1] != "news" || push[2] != int64(1) {
+		t.Errorf("Expected [unsubscribe news 1], got %v", push)
+	}
+	if err := protocol.WriteCommand(conn, command(protocol.CmdPUnsubscribe, "")); err != nil {
+		t.Fatalf("Failed to unsubscribe: %v", err)
+	}
+	if push := readPush(t, conn); push[0] != "punsubscribe" || push[2] != int64(0) {
+		t.Errorf("Expected [punsubscribe n* 0], got %v", push)
+	}
+
+	// Without subscriptions, the connection accepts every command again.
+	if resp := roundTrip(t, conn, command(protocol.CmdGet, "key")); resp.Type != protocol.RespNil {
+		t.Errorf("Expected GET to work after unsubscribing, got %+v", resp)
+	}
+}
+
+func TestPSubscribeMatchesPatterns(t *testing.T) {
+	_, addr := startServer(t)
+	sub := subscribe(t, addr, protocol.CmdPSubscribe, "news.*", "h?llo", "h[ae]y", "key\\*")
+	pub := dial(t, addr)
+
+	tests := []struct {
+		channel string
+		pattern string // Empty when no pattern matches
+	}{
+		{"news.sport", "news.*"},
+		{"news.", "news.*"},
+		{"news", ""},
+		{"hello", "h?llo"},
+		{"hllo", ""},
+		{"hey", "h[ae]y"},
+		{"hoy", ""},
+		{"key*", "key\\*"},
+		{"keys", ""},
+	}
+	for _, tt := range tests {
+		resp := roundTrip(t, pub, command(protocol.CmdPublish, tt.channel, "payload"))
+		if tt.pattern == "" {
+			if resp.Data != int64(0) {
+				t.Errorf("Expected no pattern to match %q, got %+v", tt.channel, resp)
+			}
+			continue
+		}
+		if resp.Data != int64(1) {
+			t.Errorf("Expected one receiver for %q, got %+v", tt.channel, resp)
+			continue
+		}
+		want := []interface{}{"pmessage", tt.pattern, tt.channel, "payload"}
+		if push := readPush(t, sub); !slices.Equal(push, want) {
+			t.Errorf("Expected %v, got %v", want, push)
+		}
+	}
+}
+
+func TestShardedChannelsAreSeparate(t *testing.T) {
+	_, addr := startServer(t)
+	sharded := subscribe(t, addr, protocol.CmdSSubscribe, "events")
+	pattern := subscribe(t, addr, protocol.CmdPSubscribe, "*")
+	pub := dial(t, addr)
+
+	if resp := roundTrip(t, pub, command(protocol.CmdSPublish, "events", "sharded")); resp.Data != int64(1) {
+		t.Errorf("Expected only the sharded subscriber to receive SPUBLISH, got %+v", resp)
+	}
+	if push := readPush(t, sharded); push[0] != "smessage" || push[1] != "events" || push[2] != "sharded" {
+		t.Errorf("Expected [smessage events sharded], got %v", push)
+	}
+	expectNoPush(t, pattern)
+
+	if resp := roundTrip(t, pub, command(protocol.CmdPublish, "events", "plain")); resp.Data != int64(1) {
+		t.Errorf("Expected only the pattern subscriber to receive PUBLISH, got %+v", resp)
+	}
+	if push := readPush(t, pattern); push[0] != "pmessage" || push[3] != "plain" {
+		t.Errorf("Expected [pmessage * events plain], got %v", push)
+	}
+	expectNoPush(t, sharded)
+}
+
+func TestSlowSubscriberDisconnected(t *testing.T) {
+	s, addr := startServer(t)
+	subscribe(t, addr, protocol.CmdSubscribe, "flood") // Never read again
+
+	// Publish until the subscriber's socket buffers and queue are full.
+	payload := strings.Repeat("x", 64*1024)
+	for i := 0; ; i++ {
+		if s.pubsub.publish("flood", payload) == 0 {
+			break
+		}
+		if i > 100000 {
+			t.Fatal("Expected the slow subscriber to be disconnected")
+		}
+	}
+
+	waitFor(t, "the slow subscriber to be removed", func() bool {
+		s.pubsub.mu.RLock()
+		defer s.pubsub.mu.RUnlock()
+		return len(s.pubsub.index[subscriptionChannel]["flood"]) == 0
+	})
+
+	// Other connections are unaffected.
+	if resp := roundTrip(t, dial(t, addr), command(protocol.CmdPing, "")); resp.Type == protocol.RespError {
+		t.Errorf("Expected the server to keep serving, got %+v", resp)
+	}
+}
+
+// syncBuffer is a buffer safe for concurrent use, to capture logs.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSlowSubscriberLoggedOnce(t *testing.T) {
+	var logs syncBuffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	conn, peer := net.Pipe()           // Writes block, since peer is never read
+	t.Cleanup(func() { peer.Close() }) //nolint:errcheck,gosec // Nothing to do on failure
+	sub := newSubscriber(conn)
+	defer close(sub.out)
+
+	msg := pushResponse("message", "flood", "payload")
+	sent := 0
+	for range 2 * subscriberQueueSize {
+		if sub.send(msg) {
+			sent++
+		}
+	}
+	if sent > subscriberQueueSize+1 { // The queue and the message being written
+		t.Errorf("Expected at most %d messages to be queued, got %d", subscriberQueueSize+1, sent)
+	}
+	if n := strings.Count(logs.String(), "Disconnecting slow subscriber"); n != 1 {
+		t.Errorf("Expected the disconnection to be logged once, got %d times", n)
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/cache"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// handleGCRA processes GCRA commands: Args are the burst, the count per
+// period, the period in milliseconds and an optional quantity (default 1).
+// The reply is described at rateLimitResponse.
+func (s *Server) handleGCRA(cmd *protocol.Command) *protocol.Response {
+	limit, quantity, err := parseRateLimit("GCRA", cmd.Args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	result, err := s.cache.GCRA(cmd.Key, limit, quantity)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return rateLimitResponse(result)
+}
+
+// handleTokenBucket processes TOKENBUCKET commands: Args are the capacity,
+// the tokens added per period, the period in milliseconds and an optional
+// number of tokens to take (default 1). The reply is described at
+// rateLimitResponse.
+func (s *Server) handleTokenBucket(cmd *protocol.Command) *protocol.Response {
+	limit, tokens, err := parseRateLimit("TOKENBUCKET", cmd.Args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	result, err := s.cache.TokenBucket(cmd.Key, limit, tokens)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return rateLimitResponse(result)
+}
+
+// parseRateLimit parses "burst count period_ms [quantity]".
+func parseRateLimit(name string, args []string) (cache.RateLimit, int64, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return cache.RateLimit{}, 0, fmt.Errorf("%s requires a burst, a count, a period and an optional quantity", name)
+	}
+
+	values := []int64{0, 0, 0, 1}
+	for i, arg := range args {
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return cache.RateLimit{}, 0, fmt.Errorf("value is not an integer")
+		}
+		values[i] = n
+	}
+	limit := cache.RateLimit{Burst: values[0], Count: values[1], Period: time.Duration(values[2]) * time.Millisecond}
+	return limit, values[3], nil
+}
+
+// rateLimitResponse encodes a rate limiter result as an array of the
+// allowed flag (1 or 0), the limit, the remaining units, the milliseconds
+// until a retry may succeed (0 if allowed, -1 if never) and the
+// milliseconds until the limiter is full again.
+func rateLimitResponse(result cache.RateLimitResult) *protocol.Response {
+	var allowed int64
+	if result.Allowed {
+		allowed = 1
+	}
+	retryAfter := int64(-1)
+	if result.RetryAfter >= 0 {
+		retryAfter = ceilMillis(result.RetryAfter)
+	}
+	return &protocol.Response{
+		Type: protocol.RespNested,
+		Data: []interface{}{allowed, result.Limit, result.Remaining, retryAfter, ceilMillis(result.ResetAfter)},
+	}
+}
+
+// ceilMillis converts d to milliseconds, rounding up so that clients don't
+// retry too early.
+func ceilMillis(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
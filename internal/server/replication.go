@@ -0,0 +1,793 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/cache"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// Replication streams writes to replicas in the order the primary applied
+// them. A replica first loads a snapshot of the cache, sent as RESTORE
+// commands, then receives each write that changed keys as a unit: a single
+// command is sent as is, and the commands of a transaction or script are
+// wrapped in MULTI ... EXEC so that the replica applies them atomically
+// too. Commands are rewritten where replaying them as received would give
+// another result: SET IFVER becomes SET, XADD gets the ID the primary
+// generated, XREADGROUP doesn't block and XCLAIM claims the entries the
+// primary claimed. Commands whose effect depends on the primary's clock or
+// on other nodes (GCRA, TOKENBUCKET and MIGRATE), and commands that failed
+// after changing keys, are sent as the values of the keys they changed.
+// Keys that expire on the primary are deleted with DEL. TTLs are sent as
+// given, relative, so keys expire on replicas later by the replication
+// delay, unless the primary's DEL comes first.
+//
+// Each unit increments the primary's replication offset. After sending a
+// batch, the primary sends REPLCONF OFFSET with the offset the replica has
+// caught up to, and the replica acknowledges it with REPLCONF ACK. A
+// replica more than replicationBacklog units behind is disconnected and,
+// like any replica that reconnects, loads a new snapshot.
+
+// Replication timing.
+const (
+	replicationHeartbeat   = time.Second
+	replicationTimeout     = 10 * time.Second
+	replicationRetryDelay  = time.Second
+	replicationDialTimeout = 5 * time.Second
+)
+
+// replicationBacklog is the number of units queued for a replica before
+// it is disconnected to resynchronize.
+const replicationBacklog = 65536
+
+// Link states of a replica, as reported by ROLE.
+const (
+	linkConnecting = "connecting"
+	linkSync       = "sync"
+	linkConnected  = "connected"
+)
+
+// errReadOnly is returned for write commands sent to a replica.
+var errReadOnly = errors.New("READONLY You can't write against a read only replica")
+
+// replication holds the replication state of a server, in both directions:
+// the replicas streaming from it and, on a replica, its link to the primary.
+type replication struct {
+	mu       sync.Mutex
+	offset   uint64                    // Units recorded on this node
+	replicas map[*replicaLink]struct{} // Connected replicas
+	primary  *primaryLink              // Link to the primary; nil on a primary
+
+	// Units are built while the write runs, so the following fields are
+	// guarded by the cache lock rather than by mu.
+	unit     *replicationUnit // Unit being recorded; nil outside writes
+	expiring bool             // The next change is an expiry already recorded
+}
+
+// replicationUnit is a write, transaction or script being recorded.
+type replicationUnit struct {
+	cmds     []*protocol.Command // Commands that replay it
+	changed  map[string]struct{} // Keys changed since the last recorded command
+	modified bool                // Some key changed
+	streamed bool                // Replicas were connected when it started
+}
+
+// replicaLink is a primary's view of a connected replica.
+type replicaLink struct {
+	addr    string
+	pending [][]*protocol.Command // Units not sent yet, oldest first
+	resync  bool                  // The replica must load a new snapshot
+	wake    chan struct{}         // Signalled when units are queued
+	acked   uint64                // Offset acknowledged by the replica
+	ackedAt time.Time
+}
+
+// primaryLink is a replica's connection to its primary.
+type primaryLink struct {
+	addr   string
+	stop   chan struct{}
+	conn   net.Conn // Current connection, closed to interrupt reads
+	state  string
+	offset uint64    // Primary offset applied by this replica
+	lastIO time.Time // Last message from the primary
+}
+
+// replicationStream is the state of one connection to the primary.
+type replicationStream struct {
+	conn   net.Conn
+	link   *primaryLink
+	unit   []*protocol.Command // Commands received since MULTI
+	inUnit bool                // Between MULTI and EXEC
+}
+
+func newReplication() *replication {
+	return &replication{replicas: make(map[*replicaLink]struct{})}
+}
+
+// begin starts recording a unit. It is called while the cache lock is held.
+func (r *replication) begin() {
+	r.mu.Lock()
+	streamed := len(r.replicas) > 0
+	r.mu.Unlock()
+
+	r.unit = &replicationUnit{changed: make(map[string]struct{}), streamed: streamed}
+}
+
+// commit ends the unit being recorded and queues it for replicas. Changes
+// no recorded command accounts for are sent as values read from view. It
+// is called while the cache lock is held.
+func (r *replication) commit(view *cache.Cache) {
+	unit := r.unit
+	r.unit = nil
+	if unit.streamed && len(unit.changed) > 0 {
+		unit.cmds = append(unit.cmds, valueCommands(view, unit.changed)...)
+	}
+	if unit.modified {
+		r.publish(unit.cmds)
+	}
+}
+
+// publish assigns the next offset to a unit and queues its commands for
+// every replica. Callers must hold the cache lock.
+func (r *replication) publish(cmds []*protocol.Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.offset++
+	for link := range r.replicas {
+		if len(link.pending) < replicationBacklog {
+			link.pending = append(link.pending, cmds)
+		} else {
+			link.resync = true
+		}
+		link.signal()
+	}
+}
+
+// signal wakes up the stream of the replica.
+func (link *replicaLink) signal() {
+	select {
+	case link.wake <- struct{}{}:
+	default:
+	}
+}
+
+// record adds cmd, which was just run on view with the reply resp, to the
+// unit being recorded if it changed keys.
+func (r *replication) record(view *cache.Cache, cmd *protocol.Command, resp *protocol.Response) {
+	unit := r.unit
+	if unit == nil || len(unit.changed) == 0 {
+		return
+	}
+	if unit.streamed {
+		if replay := replayCommand(cmd, resp); replay != nil {
+			unit.cmds = append(unit.cmds, replay)
+		} else {
+			unit.cmds = append(unit.cmds, valueCommands(view, unit.changed)...)
+		}
+	}
+	clear(unit.changed)
+}
+
+// recordChange is the cache change handler's part for replication: it
+// attributes key to the command being recorded. It runs while the cache
+// lock is held.
+func (r *replication) recordChange(key string) {
+	switch {
+	case r.expiring:
+		r.expiring = false
+	case r.unit != nil:
+		r.unit.changed[key] = struct{}{}
+		r.unit.modified = true
+	default:
+		// Writes run in units, and expiries are recorded separately. A
+		// change made otherwise can't be streamed, so replicas resync.
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for link := range r.replicas {
+			link.resync = true
+			link.signal()
+		}
+	}
+}
+
+// recordExpiry is the cache expiry handler: it deletes key on replicas,
+// within the unit being recorded if an expired key was found by a write,
+// and as a unit of its own otherwise. It runs while the cache lock is held.
+func (r *replication) recordExpiry(key string) {
+	r.expiring = true
+	del := &protocol.Command{Type: protocol.CmdDel, Key: key}
+	if unit := r.unit; unit != nil {
+		unit.modified = true
+		if unit.streamed {
+			unit.cmds = append(unit.cmds, del)
+		}
+		return
+	}
+	r.publish([]*protocol.Command{del})
+}
+
+// valueCommands builds the commands that recreate the keys in changed from
+// their values in view, in key order.
+func valueCommands(view *cache.Cache, changed map[string]struct{}) []*protocol.Command {
+	keys := make([]string, 0, len(changed))
+	for key := range changed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	cmds := make([]*protocol.Command, len(keys))
+	for i, key := range keys {
+		payload, ttl, exists := view.Dump(key)
+		cmds[i] = restoreCommand(key, payload, ttl, exists)
+	}
+	return cmds
+}
+
+// replayCommand returns the command that repeats on a replica the effect
+// cmd had with the reply resp, or nil if its effect must be sent as values.
+func replayCommand(cmd *protocol.Command, resp *protocol.Response) *protocol.Command {
+	if resp.Type == protocol.RespError {
+		return nil
+	}
+
+	replay := *cmd
+	switch cmd.Type {
+	case protocol.CmdGCRA, protocol.CmdTokenBucket, protocol.CmdMigrate:
+		return nil
+	case protocol.CmdSet:
+		if cmd.TTL%time.Second != 0 {
+			return nil // The protocol sends TTLs in whole seconds
+		}
+		replay.Args = cmd.Args[:1] // Versions differ between nodes
+	case protocol.CmdXAdd:
+		id, ok := resp.Data.(string)
+		_, rest, err := parseStreamTrim(cmd.Args, false)
+		if !ok || err != nil || len(rest) == 0 {
+			return nil
+		}
+		replay.Args = slices.Clone(cmd.Args)
+		replay.Args[len(cmd.Args)-len(rest)] = id
+	case protocol.CmdXReadGroup:
+		replay.Args = withoutBlock(cmd.Args)
+	case protocol.CmdXClaim:
+		return replayXClaim(cmd, resp)
+	}
+	return &replay
+}
+
+// withoutBlock removes the BLOCK option from XREADGROUP arguments.
+func withoutBlock(args []string) []string {
+	for i := 0; i < len(args)-1; i++ {
+		switch strings.ToUpper(args[i]) {
+		case "STREAMS":
+			return args
+		case "BLOCK":
+			return append(slices.Clone(args[:i]), args[i+2:]...)
+		}
+	}
+	return args
+}
+
+// replayXClaim rewrites an XCLAIM to claim the entries the primary
+// claimed, regardless of how long they have been idle on the replica.
+func replayXClaim(cmd *protocol.Command, resp *protocol.Response) *protocol.Command {
+	claimed, ok := resp.Data.([]interface{})
+	if !ok || len(claimed) == 0 || len(cmd.Args) < minXClaimArgs {
+		return nil
+	}
+
+	args := []string{cmd.Args[0], cmd.Args[1], "0"}
+	for _, item := range claimed {
+		if entry, isEntry := item.([]interface{}); isEntry && len(entry) > 0 {
+			item = entry[0]
+		}
+		id, isID := item.(string)
+		if !isID {
+			return nil
+		}
+		args = append(args, id)
+	}
+	if last := cmd.Args[len(cmd.Args)-1]; strings.EqualFold(last, "JUSTID") {
+		args = append(args, last)
+	}
+	return &protocol.Command{Type: protocol.CmdXClaim, Key: cmd.Key, Args: args}
+}
+
+// readOnly reports whether the server is a replica.
+func (r *replication) readOnly() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.primary != nil
+}
+
+// writeCommands are the commands that modify the cache. They are rejected
+// on replicas and each runs as a replication unit. Scripts are checked
+// command by command as they call them.
+var writeCommands = map[protocol.CommandType]bool{
+	protocol.CmdSet:           true,
+	protocol.CmdDel:           true,
+	protocol.CmdIncr:          true,
+	protocol.CmdDecr:          true,
+	protocol.CmdIncrBy:        true,
+	protocol.CmdDecrBy:        true,
+	protocol.CmdExpire:        true,
+	protocol.CmdPersist:       true,
+	protocol.CmdHSet:          true,
+	protocol.CmdHDel:          true,
+	protocol.CmdLPush:         true,
+	protocol.CmdRPush:         true,
+	protocol.CmdLPop:          true,
+	protocol.CmdRPop:          true,
+	protocol.CmdSAdd:          true,
+	protocol.CmdSRem:          true,
+	protocol.CmdSetBit:        true,
+	protocol.CmdBitOp:         true,
+	protocol.CmdPFAdd:         true,
+	protocol.CmdPFMerge:       true,
+	protocol.CmdPFImport:      true,
+	protocol.CmdXAdd:          true,
+	protocol.CmdXTrim:         true,
+	protocol.CmdXGroup:        true,
+	protocol.CmdXReadGroup:    true,
+	protocol.CmdXAck:          true,
+	protocol.CmdXClaim:        true,
+	protocol.CmdGeoAdd:        true,
+	protocol.CmdJSONSet:       true,
+	protocol.CmdJSONDel:       true,
+	protocol.CmdJSONNumIncrBy: true,
+	protocol.CmdJSONArrAppend: true,
+	protocol.CmdGCRA:          true,
+	protocol.CmdTokenBucket:   true,
+	protocol.CmdRestore:       true,
+	protocol.CmdMigrate:       true,
+}
+
+// ReplicaOf makes the server a read-only replica of the primary at addr
+// ("host:port"), discarding its data once the first snapshot arrives. An
+// empty addr promotes the server to a primary, keeping its data. The
+// server keeps serving its own replicas either way.
+//
+// Example:
+//
+//	server.ReplicaOf("cache-primary:8080")
+//	// ... after a failover
+//	server.ReplicaOf("")
+func (s *Server) ReplicaOf(addr string) {
+	r := s.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.primary != nil && r.primary.addr == addr {
+		return
+	}
+	if r.primary != nil {
+		r.stopPrimaryLink()
+		if addr == "" {
+			log.Printf("Replication: promoted to primary")
+		}
+	}
+	if addr == "" {
+		return
+	}
+
+	link := &primaryLink{addr: addr, stop: make(chan struct{}), state: linkConnecting}
+	r.primary = link
+	go s.replicate(link)
+}
+
+// stopReplicating stops replicating from the primary, if any.
+func (s *Server) stopReplicating() {
+	s.replication.mu.Lock()
+	defer s.replication.mu.Unlock()
+
+	if s.replication.primary != nil {
+		s.replication.stopPrimaryLink()
+	}
+}
+
+// stopPrimaryLink stops the link to the primary. Callers must hold r.mu.
+func (r *replication) stopPrimaryLink() {
+	close(r.primary.stop)
+	if r.primary.conn != nil {
+		r.primary.conn.Close() //nolint:errcheck,gosec // Only interrupts the stream
+	}
+	r.primary = nil
+}
+
+// handleReplicaOf processes REPLICAOF commands: Args are the host and port
+// of the new primary, or NO ONE to become a primary.
+func (s *Server) handleReplicaOf(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) != 2 {
+		return &protocol.Response{Type: protocol.RespError, Error: "REPLICAOF requires a host and a port, or NO ONE"}
+	}
+	if strings.EqualFold(cmd.Args[0], "NO") && strings.EqualFold(cmd.Args[1], "ONE") {
+		s.ReplicaOf("")
+		return &protocol.Response{Type: protocol.RespOK}
+	}
+	if _, err := strconv.ParseUint(cmd.Args[1], 10, 16); err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: "invalid primary port"}
+	}
+	s.ReplicaOf(net.JoinHostPort(cmd.Args[0], cmd.Args[1]))
+	return &protocol.Response{Type: protocol.RespOK}
+}
+
+// handleRole processes ROLE commands. A primary replies
+// ["primary", offset, [[address, acked offset, ms since ack]...]] and a
+// replica replies ["replica", primary address, link state, applied offset,
+// ms since the last message from the primary or -1].
+func (s *Server) handleRole(_ *protocol.Command) *protocol.Response {
+	r := s.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if link := r.primary; link != nil {
+		sinceIO := int64(-1)
+		if !link.lastIO.IsZero() {
+			sinceIO = time.Since(link.lastIO).Milliseconds()
+		}
+		return &protocol.Response{
+			Type: protocol.RespNested,
+			Data: []interface{}{"replica", link.addr, link.state, int64(link.offset), sinceIO}, //nolint:gosec // Offsets fit
+		}
+	}
+
+	replicas := make([]interface{}, 0, len(r.replicas))
+	for link := range r.replicas {
+		replicas = append(replicas, []interface{}{
+			link.addr, int64(link.acked), time.Since(link.ackedAt).Milliseconds(), //nolint:gosec // Offsets fit
+		})
+	}
+	sort.Slice(replicas, func(i, j int) bool {
+		a, _ := replicas[i].([]interface{})
+		b, _ := replicas[j].([]interface{})
+		return fmt.Sprint(a[0]) < fmt.Sprint(b[0])
+	})
+	return &protocol.Response{
+		Type: protocol.RespNested,
+		Data: []interface{}{"primary", int64(r.offset), replicas}, //nolint:gosec // Offsets fit
+	}
+}
+
+// handleDump processes DUMP commands, returning the serialized value or nil
+// if the key doesn't exist.
+func (s *Server) handleDump(cmd *protocol.Command) *protocol.Response {
+	payload, _, exists := s.cache.Dump(cmd.Key)
+	if !exists {
+		return &protocol.Response{Type: protocol.RespNil}
+	}
+	return &protocol.Response{Type: protocol.RespString, Data: string(payload)}
+}
+
+// handleRestore processes RESTORE commands: Args are the TTL in
+// milliseconds (0 for none), the payload from DUMP and an optional REPLACE.
+func (s *Server) handleRestore(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+		return &protocol.Response{Type: protocol.RespError, Error: "RESTORE requires a TTL and a payload"}
+	}
+	ttl, err := strconv.ParseInt(cmd.Args[0], 10, 64)
+	if err != nil || ttl < 0 {
+		return &protocol.Response{Type: protocol.RespError, Error: "invalid TTL value, must be >= 0"}
+	}
+	replace := len(cmd.Args) == 3
+	if replace && !strings.EqualFold(cmd.Args[2], "REPLACE") {
+		return &protocol.Response{Type: protocol.RespError, Error: "syntax error"}
+	}
+
+	if err := s.cache.Restore(cmd.Key, []byte(cmd.Args[1]), time.Duration(ttl)*time.Millisecond, replace); err != nil {
+		if errors.Is(err, cache.ErrBusyKey) {
+			return &protocol.Response{Type: protocol.RespError, Error: "BUSYKEY " + err.Error()}
+		}
+		return errorResponse(err)
+	}
+	return &protocol.Response{Type: protocol.RespOK}
+}
+
+// restoreCommand builds the RESTORE command that recreates key on a
+// replica or another node, or a DEL if the key doesn't exist.
+func restoreCommand(key string, payload []byte, ttl time.Duration, exists bool) *protocol.Command {
+	if !exists {
+		return &protocol.Command{Type: protocol.CmdDel, Key: key}
+	}
+	return &protocol.Command{
+		Type: protocol.CmdRestore,
+		Key:  key,
+		Args: []string{strconv.FormatInt(ttl.Milliseconds(), 10), string(payload), "REPLACE"},
+	}
+}
+
+// serveReplica takes over a connection that sent SYNC: it sends a snapshot
+// of the cache, then streams units until the connection fails or the
+// replica must resync. Args[0] is the replica's listening port, used to
+// report its address.
+func (s *Server) serveReplica(conn net.Conn, cmd *protocol.Command) {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		host = conn.RemoteAddr().String()
+	}
+	port := "?"
+	if len(cmd.Args) > 0 {
+		port = cmd.Args[0]
+	}
+	link := &replicaLink{
+		addr:    net.JoinHostPort(host, port),
+		wake:    make(chan struct{}, 1),
+		ackedAt: time.Now(),
+	}
+
+	// Register the replica while holding the cache lock, so that every
+	// unit after the snapshot is queued for it.
+	r := s.replication
+	var snapshot []*protocol.Command
+	var offset uint64
+	s.cache.Transaction(nil, func(view *cache.Cache) {
+		view.DumpAll(func(key string, payload []byte, ttl time.Duration) {
+			snapshot = append(snapshot, restoreCommand(key, payload, ttl, true))
+		})
+		r.mu.Lock()
+		r.replicas[link] = struct{}{}
+		offset = r.offset
+		r.mu.Unlock()
+	})
+	defer func() {
+		r.mu.Lock()
+		delete(r.replicas, link)
+		r.mu.Unlock()
+	}()
+	log.Printf("Replication: replica %s connected, sending %d keys", link.addr, len(snapshot))
+
+	header := &protocol.Command{Type: protocol.CmdReplConf, Args: []string{"SNAPSHOT", strconv.Itoa(len(snapshot))}}
+	if err := writeReplication(conn, append([]*protocol.Command{header}, snapshot...), offset); err != nil {
+		log.Printf("Replication: replica %s failed: %v", link.addr, err)
+		return
+	}
+	snapshot = nil
+
+	done := make(chan struct{})
+	go s.readAcks(conn, link, done)
+
+	ticker := time.NewTicker(replicationHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			log.Printf("Replication: replica %s disconnected", link.addr)
+			return
+		case <-link.wake:
+		case <-ticker.C:
+		}
+
+		r.mu.Lock()
+		pending, resync := link.pending, link.resync
+		link.pending = nil
+		offset = r.offset
+		r.mu.Unlock()
+
+		if resync {
+			log.Printf("Replication: replica %s must resync, disconnecting it", link.addr)
+			return
+		}
+		if err := writeReplication(conn, frameUnits(pending), offset); err != nil {
+			log.Printf("Replication: replica %s failed: %v", link.addr, err)
+			return
+		}
+	}
+}
+
+// frameUnits lays units out for the stream: units of a single command are
+// sent as is, and larger units between MULTI and EXEC.
+func frameUnits(units [][]*protocol.Command) []*protocol.Command {
+	var cmds []*protocol.Command
+	for _, unit := range units {
+		if len(unit) == 1 {
+			cmds = append(cmds, unit[0])
+			continue
+		}
+		cmds = append(cmds, &protocol.Command{Type: protocol.CmdMulti})
+		cmds = append(cmds, unit...)
+		cmds = append(cmds, &protocol.Command{Type: protocol.CmdExec})
+	}
+	return cmds
+}
+
+// writeReplication sends cmds followed by REPLCONF OFFSET offset.
+func writeReplication(conn net.Conn, cmds []*protocol.Command, offset uint64) error {
+	cmds = append(cmds, &protocol.Command{
+		Type: protocol.CmdReplConf,
+		Args: []string{"OFFSET", strconv.FormatUint(offset, 10)},
+	})
+	for _, cmd := range cmds {
+		if err := conn.SetWriteDeadline(time.Now().Add(defaultWriteTimeoutSecs * time.Second)); err != nil {
+			return err
+		}
+		if err := protocol.WriteCommand(conn, cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readAcks records the offsets a replica acknowledges until the connection
+// fails, then closes done.
+func (s *Server) readAcks(conn net.Conn, link *replicaLink, done chan struct{}) {
+	defer close(done)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(replicationTimeout)); err != nil {
+			return
+		}
+		cmd, err := protocol.ReadCommand(conn)
+		if err != nil {
+			return
+		}
+		if cmd.Type != protocol.CmdReplConf || len(cmd.Args) != 2 || !strings.EqualFold(cmd.Args[0], "ACK") {
+			continue
+		}
+		acked, err := strconv.ParseUint(cmd.Args[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		s.replication.mu.Lock()
+		link.acked, link.ackedAt = acked, time.Now()
+		s.replication.mu.Unlock()
+	}
+}
+
+// replicate keeps a replica in sync with its primary until the link is
+// stopped, reconnecting after failures. Each connection starts with a full
+// snapshot.
+func (s *Server) replicate(link *primaryLink) {
+	for {
+		err := s.syncFromPrimary(link)
+		select {
+		case <-link.stop:
+			return
+		default:
+		}
+		log.Printf("Replication: link to primary %s failed: %v", link.addr, err)
+
+		s.setLinkState(link, linkConnecting)
+		select {
+		case <-link.stop:
+			return
+		case <-time.After(replicationRetryDelay):
+		}
+	}
+}
+
+// syncFromPrimary runs one replication connection to the primary.
+func (s *Server) syncFromPrimary(link *primaryLink) error {
+	dialer := net.Dialer{Timeout: replicationDialTimeout}
+	conn, err := dialer.DialContext(context.Background(), "tcp", link.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck // Nothing to do on failure
+
+	s.replication.mu.Lock()
+	select {
+	case <-link.stop:
+		s.replication.mu.Unlock()
+		return nil
+	default:
+		link.conn = conn
+	}
+	s.replication.mu.Unlock()
+
+	syncCmd := &protocol.Command{Type: protocol.CmdSync, Args: []string{strconv.Itoa(s.port)}}
+	if err := conn.SetWriteDeadline(time.Now().Add(defaultWriteTimeoutSecs * time.Second)); err != nil {
+		return err
+	}
+	if err := protocol.WriteCommand(conn, syncCmd); err != nil {
+		return err
+	}
+
+	stream := &replicationStream{conn: conn, link: link}
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(replicationTimeout)); err != nil {
+			return err
+		}
+		cmd, err := protocol.ReadCommand(conn)
+		if err != nil {
+			return err
+		}
+		if err := s.applyReplication(stream, cmd); err != nil {
+			return err
+		}
+	}
+}
+
+// applyReplication applies one message of the replication stream. Commands
+// between MULTI and EXEC are applied together once EXEC arrives.
+func (s *Server) applyReplication(stream *replicationStream, cmd *protocol.Command) error {
+	switch cmd.Type {
+	case protocol.CmdReplConf:
+		return s.applyReplConf(stream, cmd)
+	case protocol.CmdMulti:
+		if stream.inUnit {
+			return fmt.Errorf("nested MULTI from primary")
+		}
+		stream.inUnit = true
+		return nil
+	case protocol.CmdExec:
+		if !stream.inUnit {
+			return fmt.Errorf("EXEC without MULTI from primary")
+		}
+		unit := stream.unit
+		stream.unit, stream.inUnit = nil, false
+		return s.applyUnit(unit)
+	}
+
+	if stream.inUnit {
+		stream.unit = append(stream.unit, cmd)
+		return nil
+	}
+	return s.applyUnit([]*protocol.Command{cmd})
+}
+
+// applyUnit runs the commands of a unit atomically, recording them for the
+// server's own replicas. A failing command means the replica diverged from
+// its primary, so it fails the link to resync.
+func (s *Server) applyUnit(cmds []*protocol.Command) error {
+	var err error
+	s.atomically(nil, func(view *Server) {
+		for _, cmd := range cmds {
+			if resp := view.run(cmd); resp.Type == protocol.RespError && err == nil {
+				err = fmt.Errorf("command %d from primary failed: %s", cmd.Type, resp.Error)
+			}
+		}
+	})
+	return err
+}
+
+// applyReplConf applies REPLCONF SNAPSHOT, which starts a snapshot, and
+// REPLCONF OFFSET, which is acknowledged.
+func (s *Server) applyReplConf(stream *replicationStream, cmd *protocol.Command) error {
+	if len(cmd.Args) != 2 {
+		return fmt.Errorf("invalid REPLCONF from primary")
+	}
+	n, err := strconv.ParseUint(cmd.Args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid REPLCONF from primary")
+	}
+
+	link := stream.link
+	switch strings.ToUpper(cmd.Args[0]) {
+	case "SNAPSHOT":
+		log.Printf("Replication: receiving %d keys from primary %s", n, link.addr)
+		s.atomically(nil, func(view *Server) { view.cache.Flush() })
+		s.setLinkState(link, linkSync)
+		return nil
+	case "OFFSET":
+		s.replication.mu.Lock()
+		link.offset, link.lastIO, link.state = n, time.Now(), linkConnected
+		s.replication.mu.Unlock()
+
+		ack := &protocol.Command{Type: protocol.CmdReplConf, Args: []string{"ACK", cmd.Args[1]}}
+		if err := stream.conn.SetWriteDeadline(time.Now().Add(defaultWriteTimeoutSecs * time.Second)); err != nil {
+			return err
+		}
+		return protocol.WriteCommand(stream.conn, ack)
+	default:
+		return fmt.Errorf("invalid REPLCONF from primary")
+	}
+}
+
+// setLinkState updates the state of a link to the primary.
+func (s *Server) setLinkState(link *primaryLink, state string) {
+	s.replication.mu.Lock()
+	defer s.replication.mu.Unlock()
+
+	link.state = state
+}
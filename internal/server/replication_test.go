@@ -0,0 +1,342 @@
+package server
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/cache"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// attachReplica registers a replica link on s without a connection, so
+// that the units queued for it can be inspected.
+func attachReplica(s *Server) *replicaLink {
+	link := &replicaLink{addr: "test", wake: make(chan struct{}, 1)}
+	s.cache.Transaction(nil, func(*cache.Cache) {
+		s.replication.mu.Lock()
+		s.replication.replicas[link] = struct{}{}
+		s.replication.mu.Unlock()
+	})
+	return link
+}
+
+// takeUnits returns and clears the units queued for link.
+func takeUnits(s *Server, link *replicaLink) [][]*protocol.Command {
+	s.replication.mu.Lock()
+	defer s.replication.mu.Unlock()
+
+	units := link.pending
+	link.pending = nil
+	return units
+}
+
+// describeUnits renders units as "TYPE key args..." lines, one slice per
+// unit, with RESTORE payloads elided.
+func describeUnits(units [][]*protocol.Command) [][]string {
+	names := map[protocol.CommandType]string{
+		protocol.CmdSet: "SET", protocol.CmdDel: "DEL", protocol.CmdIncr: "INCR", protocol.CmdXAdd: "XADD",
+		protocol.CmdRestore: "RESTORE", protocol.CmdXReadGroup: "XREADGROUP", protocol.CmdXClaim: "XCLAIM",
+		protocol.CmdRPush: "RPUSH", protocol.CmdHSet: "HSET", protocol.CmdXGroup: "XGROUP",
+	}
+	described := make([][]string, len(units))
+	for i, unit := range units {
+		for _, cmd := range unit {
+			args := cmd.Args
+			if cmd.Type == protocol.CmdRestore {
+				args = []string{"..."}
+			}
+			line := strings.TrimSpace(names[cmd.Type] + " " + cmd.Key + " " + strings.Join(args, " "))
+			described[i] = append(described[i], line)
+		}
+	}
+	return described
+}
+
+func TestReplicationUnits(t *testing.T) {
+	s := New(0)
+	link := attachReplica(s)
+
+	s.executeCommand(command(protocol.CmdSet, "a", "1"))
+	s.executeCommand(command(protocol.CmdIncr, "a"))
+	s.executeCommand(command(protocol.CmdGet, "a"))            // Reads aren't replicated
+	s.executeCommand(command(protocol.CmdHSet, "a", "f", "v")) // Failed writes aren't either
+	versioned, _ := s.executeCommand(command(protocol.CmdGetV, "a")).Data.([]interface{})
+	version, _ := versioned[1].(int64)
+	s.executeCommand(command(protocol.CmdSet, "a", "2", "IFVER", strconv.FormatInt(version, 10)))
+
+	var tx transaction
+	s.handleTransactionCommand(&tx, command(protocol.CmdMulti, ""))
+	s.queueCommand(&tx, command(protocol.CmdSet, "b", "x"))
+	s.queueCommand(&tx, command(protocol.CmdRPush, "l", "1", "2"))
+	s.handleTransactionCommand(&tx, command(protocol.CmdExec, ""))
+
+	script := "redis.call('INCR', KEYS[1]); return redis.call('DEL', KEYS[2])"
+	s.executeCommand(command(protocol.CmdEval, "", script, "2", "a", "b"))
+	s.executeCommand(command(protocol.CmdGCRA, "limit", "10", "10", "1000"))
+
+	want := [][]string{
+		{"SET a 1"},
+		{"INCR a"},
+		{"SET a 2"}, // IFVER dropped: versions differ between nodes
+		{"SET b x", "RPUSH l 1 2"},
+		{"INCR a", "DEL b"},
+		{"RESTORE limit ..."}, // Rate limiters depend on the clock
+	}
+	got := describeUnits(takeUnits(s, link))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected units %q, got %q", want, got)
+	}
+
+	s.replication.mu.Lock()
+	offset := s.replication.offset
+	s.replication.mu.Unlock()
+	if offset != uint64(len(want)) {
+		t.Errorf("Expected offset %d, got %d", len(want), offset)
+	}
+}
+
+func TestReplicationUnitsStreams(t *testing.T) {
+	s := New(0)
+	link := attachReplica(s)
+
+	added := s.executeCommand(command(protocol.CmdXAdd, "stream", "*", "f", "v"))
+	id, ok := added.Data.(string)
+	if !ok {
+		t.Fatalf("XADD failed: %+v", added)
+	}
+	s.executeCommand(command(protocol.CmdXGroup, "stream", "CREATE", "group", "0"))
+	s.executeCommand(command(protocol.CmdXReadGroup, "",
+		"GROUP", "group", "alice", "BLOCK", "10", "STREAMS", "stream", ">"))
+	s.executeCommand(command(protocol.CmdXClaim, "stream", "group", "bob", "0", id, "JUSTID"))
+
+	got := describeUnits(takeUnits(s, link))
+	want := [][]string{
+		{"XADD stream " + id + " f v"}, // The generated ID, not *
+		{"XGROUP stream CREATE group 0"},
+		{"XREADGROUP  GROUP group alice STREAMS stream >"}, // Without BLOCK
+		{"XCLAIM stream group bob 0 " + id + " JUSTID"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected units %q, got %q", want, got)
+	}
+}
+
+func TestReplicationUnitsExpiry(t *testing.T) {
+	s := New(0)
+	s.executeCommand(&protocol.Command{Type: protocol.CmdSet, Key: "temp", Args: []string{"v"}, TTL: time.Second})
+	link := attachReplica(s)
+
+	time.Sleep(1100 * time.Millisecond)
+	s.executeCommand(command(protocol.CmdRPush, "temp", "x"))
+
+	want := [][]string{{"DEL temp", "RPUSH temp x"}}
+	if got := describeUnits(takeUnits(s, link)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected units %q, got %q", want, got)
+	}
+}
+
+func TestReplicationBacklogOverflow(t *testing.T) {
+	s := New(0)
+	link := attachReplica(s)
+
+	for i := 0; i <= replicationBacklog; i++ {
+		s.executeCommand(command(protocol.CmdIncr, "counter"))
+	}
+
+	s.replication.mu.Lock()
+	defer s.replication.mu.Unlock()
+	if !link.resync {
+		t.Error("Expected a replica past the backlog to be marked for resync")
+	}
+	if len(link.pending) != replicationBacklog {
+		t.Errorf("Expected %d queued units, got %d", replicationBacklog, len(link.pending))
+	}
+}
+
+// startReplica starts a server replicating from primaryAddr and waits
+// until it is connected.
+func startReplica(t *testing.T, primaryAddr string) (*Server, string) {
+	t.Helper()
+
+	replica, addr := startServer(t)
+	replica.ReplicaOf(primaryAddr)
+	waitFor(t, "replica to connect", func() bool {
+		return replicaState(replica) == linkConnected
+	})
+	return replica, addr
+}
+
+// replicaState returns the state of the link of replica to its primary.
+func replicaState(replica *Server) string {
+	replica.replication.mu.Lock()
+	defer replica.replication.mu.Unlock()
+
+	if replica.replication.primary == nil {
+		return ""
+	}
+	return replica.replication.primary.state
+}
+
+// role sends ROLE with send and returns the reply items.
+func role(t *testing.T, send func(*protocol.Command) *protocol.Response) []interface{} {
+	t.Helper()
+
+	resp := send(command(protocol.CmdRole, ""))
+	items, ok := resp.Data.([]interface{})
+	if !ok {
+		t.Fatalf("Unexpected ROLE reply %+v", resp)
+	}
+	return items
+}
+
+// caughtUp reports whether the replicas of the primary that send talks to
+// have acknowledged its offset.
+func caughtUp(t *testing.T, send func(*protocol.Command) *protocol.Response) bool {
+	t.Helper()
+
+	items := role(t, send)
+	offset, _ := items[1].(int64)
+	replicas, _ := items[2].([]interface{})
+	if len(replicas) == 0 {
+		return false
+	}
+	for _, r := range replicas {
+		fields, _ := r.([]interface{})
+		if acked, _ := fields[1].(int64); acked != offset {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReplicationSnapshotAndStream(t *testing.T) {
+	primary, primaryAddr := startServer(t)
+	pconn := dial(t, primaryAddr)
+	send := func(cmd *protocol.Command) *protocol.Response { return roundTrip(t, pconn, cmd) }
+
+	send(command(protocol.CmdSet, "before", "snapshot"))
+	send(command(protocol.CmdRPush, "list", "a", "b"))
+
+	_, replicaAddr := startReplica(t, primaryAddr)
+	rconn := dial(t, replicaAddr)
+	if resp := roundTrip(t, rconn, command(protocol.CmdGet, "before")); resp.Data != "snapshot" {
+		t.Errorf("Expected the snapshot on the replica, got %+v", resp)
+	}
+
+	send(command(protocol.CmdIncr, "counter"))
+	send(command(protocol.CmdMulti, ""))
+	send(command(protocol.CmdIncr, "counter"))
+	send(command(protocol.CmdLPop, "list"))
+	send(command(protocol.CmdExec, ""))
+	send(command(protocol.CmdEval, "", "return redis.call('INCRBY', KEYS[1], 10)", "1", "counter"))
+	added := send(command(protocol.CmdXAdd, "stream", "*", "f", "v"))
+	send(command(protocol.CmdDel, "before"))
+
+	waitFor(t, "replica to catch up", func() bool { return caughtUp(t, send) })
+
+	checks := []struct {
+		cmd  *protocol.Command
+		want interface{}
+	}{
+		{command(protocol.CmdGet, "counter"), "12"},
+		{command(protocol.CmdLLen, "list"), int64(1)},
+		{command(protocol.CmdExists, "before"), int64(0)},
+	}
+	for _, c := range checks {
+		if resp := roundTrip(t, rconn, c.cmd); resp.Data != c.want {
+			t.Errorf("Command %d on %q: expected %v on the replica, got %+v", c.cmd.Type, c.cmd.Key, c.want, resp)
+		}
+	}
+
+	entries := roundTrip(t, rconn, command(protocol.CmdXRange, "stream", "-", "+"))
+	list, _ := entries.Data.([]interface{})
+	if len(list) != 1 {
+		t.Fatalf("Expected one stream entry on the replica, got %+v", entries)
+	}
+	if entry, _ := list[0].([]interface{}); len(entry) == 0 || entry[0] != added.Data {
+		t.Errorf("Expected entry ID %v on the replica, got %+v", added.Data, list[0])
+	}
+
+	primary.replication.mu.Lock()
+	defer primary.replication.mu.Unlock()
+	if primary.replication.offset != 7 {
+		t.Errorf("Expected offset 7 after seven writes, got %d", primary.replication.offset)
+	}
+}
+
+func TestReplicaReadOnly(t *testing.T) {
+	_, primaryAddr := startServer(t)
+	_, replicaAddr := startReplica(t, primaryAddr)
+	rconn := dial(t, replicaAddr)
+
+	resp := roundTrip(t, rconn, command(protocol.CmdSet, "key", "value"))
+	if resp.Type != protocol.RespError || !strings.HasPrefix(resp.Error, "READONLY") {
+		t.Errorf("Expected READONLY for SET on a replica, got %+v", resp)
+	}
+	resp = roundTrip(t, rconn, command(protocol.CmdEval, "", "return redis.call('SET', KEYS[1], 'v')", "1", "key"))
+	if resp.Type != protocol.RespError || !strings.Contains(resp.Error, "READONLY") {
+		t.Errorf("Expected READONLY for a writing script on a replica, got %+v", resp)
+	}
+	if resp := roundTrip(t, rconn, command(protocol.CmdGet, "key")); resp.Type != protocol.RespNil {
+		t.Errorf("Expected reads to be served, got %+v", resp)
+	}
+}
+
+func TestReplicationOffsetAndLag(t *testing.T) {
+	_, primaryAddr := startServer(t)
+	_, replicaAddr := startReplica(t, primaryAddr)
+	pconn, rconn := dial(t, primaryAddr), dial(t, replicaAddr)
+	send := func(cmd *protocol.Command) *protocol.Response { return roundTrip(t, pconn, cmd) }
+
+	for i := 0; i < 5; i++ {
+		send(command(protocol.CmdSet, "key"+strconv.Itoa(i), "value"))
+	}
+	waitFor(t, "replica to catch up", func() bool { return caughtUp(t, send) })
+
+	items := role(t, send)
+	if items[0] != "primary" || items[1] != int64(5) {
+		t.Fatalf("Expected a primary at offset 5, got %+v", items)
+	}
+	replicas, _ := items[2].([]interface{})
+	fields, _ := replicas[0].([]interface{})
+	if lag, _ := fields[2].(int64); lag < 0 || lag > int64(replicationTimeout/time.Millisecond) {
+		t.Errorf("Expected a recent acknowledgement, got %d ms", lag)
+	}
+
+	items = role(t, func(cmd *protocol.Command) *protocol.Response { return roundTrip(t, rconn, cmd) })
+	if items[0] != "replica" || items[1] != primaryAddr || items[2] != linkConnected || items[3] != int64(5) {
+		t.Errorf("Expected a connected replica at offset 5, got %+v", items)
+	}
+	if lag, _ := items[4].(int64); lag < 0 {
+		t.Errorf("Expected a message from the primary, got lag %d", lag)
+	}
+}
+
+func TestReplicationReconnectResyncs(t *testing.T) {
+	_, primaryAddr := startServer(t)
+	replica, replicaAddr := startReplica(t, primaryAddr)
+	pconn, rconn := dial(t, primaryAddr), dial(t, replicaAddr)
+
+	roundTrip(t, pconn, command(protocol.CmdSet, "key", "old"))
+	waitFor(t, "first write", func() bool {
+		return roundTrip(t, rconn, command(protocol.CmdGet, "key")).Data == "old"
+	})
+
+	// Break the link, then write while the replica is away.
+	replica.replication.mu.Lock()
+	replica.replication.primary.conn.Close() //nolint:errcheck,gosec // Simulates a network failure
+	replica.replication.mu.Unlock()
+	roundTrip(t, pconn, command(protocol.CmdSet, "key", "new"))
+	roundTrip(t, pconn, command(protocol.CmdSet, "other", "value"))
+
+	waitFor(t, "resync", func() bool {
+		return roundTrip(t, rconn, command(protocol.CmdGet, "key")).Data == "new" &&
+			roundTrip(t, rconn, command(protocol.CmdGet, "other")).Data == "value"
+	})
+	if state := replicaState(replica); state != linkConnected {
+		t.Errorf("Expected the link to be connected again, got %q", state)
+	}
+}
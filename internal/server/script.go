@@ -0,0 +1,593 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cachemir/cachemir/internal/lua"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// defaultScriptTimeout bounds the run time of a script unless changed with
+// SetScriptTimeout, matching Redis's default lua-time-limit.
+const defaultScriptTimeout = 5 * time.Second
+
+// scriptChunkName names scripts in error messages, as in Redis.
+const scriptChunkName = "user_script"
+
+// maxScriptReplyDepth bounds the nesting of tables returned by scripts.
+const maxScriptReplyDepth = 16
+
+// scriptCache holds compiled scripts by the hex SHA1 digest of their source.
+type scriptCache struct {
+	mu      sync.RWMutex
+	scripts map[string]*lua.Chunk
+}
+
+func newScriptCache() *scriptCache {
+	return &scriptCache{scripts: make(map[string]*lua.Chunk)}
+}
+
+// load compiles a script and caches it, returning its digest. Scripts
+// already cached are not compiled again.
+func (sc *scriptCache) load(src string) (*lua.Chunk, string, error) {
+	sum := sha1.Sum([]byte(src)) //nolint:gosec // Scripts are named by SHA1 digests, as in Redis
+	sha := hex.EncodeToString(sum[:])
+	if chunk := sc.get(sha); chunk != nil {
+		return chunk, sha, nil
+	}
+
+	chunk, err := lua.Compile(scriptChunkName, src)
+	if err != nil {
+		return nil, "", fmt.Errorf("error compiling script: %v", err)
+	}
+
+	sc.mu.Lock()
+	sc.scripts[sha] = chunk
+	sc.mu.Unlock()
+	return chunk, sha, nil
+}
+
+func (sc *scriptCache) get(sha string) *lua.Chunk {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.scripts[strings.ToLower(sha)]
+}
+
+func (sc *scriptCache) flush() {
+	sc.mu.Lock()
+	sc.scripts = make(map[string]*lua.Chunk)
+	sc.mu.Unlock()
+}
+
+// SetScriptTimeout sets how long a script may run. A script that runs
+// longer fails with an error; writes it made before the timeout are kept,
+// as scripts are not rolled back. Non-positive values restore the default.
+//
+// Example:
+//
+//	server.SetScriptTimeout(500 * time.Millisecond)
+func (s *Server) SetScriptTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultScriptTimeout
+	}
+	s.scriptTimeout = timeout
+}
+
+// handleEval processes EVAL commands: Args[0] is the script, followed by
+// the number of keys, the keys and the arguments. The script is cached for
+// EVALSHA.
+func (s *Server) handleEval(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) < 2 {
+		return &protocol.Response{Type: protocol.RespError, Error: "EVAL requires a script and the number of keys"}
+	}
+
+	chunk, _, err := s.scripts.load(cmd.Args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	return s.runScript(chunk, cmd.Args[1:])
+}
+
+// handleEvalSha processes EVALSHA commands, which run a cached script by
+// its SHA1 digest.
+func (s *Server) handleEvalSha(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) < 2 {
+		return &protocol.Response{Type: protocol.RespError, Error: "EVALSHA requires a digest and the number of keys"}
+	}
+
+	chunk := s.scripts.get(cmd.Args[0])
+	if chunk == nil {
+		return &protocol.Response{Type: protocol.RespError, Error: "NOSCRIPT No matching script. Please use EVAL."}
+	}
+	return s.runScript(chunk, cmd.Args[1:])
+}
+
+// handleScript processes SCRIPT LOAD, SCRIPT EXISTS and SCRIPT FLUSH.
+func (s *Server) handleScript(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) == 0 {
+		return &protocol.Response{Type: protocol.RespError, Error: "SCRIPT requires a subcommand"}
+	}
+
+	switch strings.ToUpper(cmd.Args[0]) {
+	case "LOAD":
+		if len(cmd.Args) != 2 {
+			return &protocol.Response{Type: protocol.RespError, Error: "SCRIPT LOAD requires a script"}
+		}
+		_, sha, err := s.scripts.load(cmd.Args[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Type: protocol.RespString, Data: sha}
+	case "EXISTS":
+		if len(cmd.Args) < 2 {
+			return &protocol.Response{Type: protocol.RespError, Error: "SCRIPT EXISTS requires at least one digest"}
+		}
+		exists := make([]interface{}, len(cmd.Args)-1)
+		for i, sha := range cmd.Args[1:] {
+			exists[i] = int64(0)
+			if s.scripts.get(sha) != nil {
+				exists[i] = int64(1)
+			}
+		}
+		return &protocol.Response{Type: protocol.RespNested, Data: exists}
+	case "FLUSH":
+		s.scripts.flush()
+		return &protocol.Response{Type: protocol.RespOK}
+	default:
+		return &protocol.Response{Type: protocol.RespError, Error: fmt.Sprintf("unknown SCRIPT subcommand: %s", cmd.Args[0])}
+	}
+}
+
+// runScript runs a script atomically: no other command runs until it
+// returns. args holds the number of keys, the keys and the arguments.
+func (s *Server) runScript(chunk *lua.Chunk, args []string) *protocol.Response {
+	numKeys, err := strconv.Atoi(args[0])
+	switch {
+	case err != nil:
+		return &protocol.Response{Type: protocol.RespError, Error: "number of keys must be an integer"}
+	case numKeys < 0:
+		return &protocol.Response{Type: protocol.RespError, Error: "number of keys can't be negative"}
+	case numKeys > len(args)-1:
+		return &protocol.Response{Type: protocol.RespError, Error: "number of keys can't be greater than number of args"}
+	}
+	keys, argv := args[1:1+numKeys], args[1+numKeys:]
+
+	var resp *protocol.Response
+	s.atomically(nil, func(view *Server) {
+		resp = view.execScript(chunk, keys, argv)
+	})
+	return resp
+}
+
+// execScript runs a script on s, which must be a transaction view.
+func (s *Server) execScript(chunk *lua.Chunk, keys, argv []string) *protocol.Response {
+	state := lua.NewState()
+	state.SetGlobal("KEYS", stringsToTable(keys))
+	state.SetGlobal("ARGV", stringsToTable(argv))
+	state.SetGlobal("redis", s.redisLibrary())
+	state.SetDeadline(time.Now().Add(s.scriptTimeout))
+
+	results, err := state.Run(chunk)
+	if err != nil {
+		return scriptErrorResponse(err, s.scriptTimeout)
+	}
+	if len(results) == 0 {
+		return &protocol.Response{Type: protocol.RespNil}
+	}
+	resp, err := scriptReply(results[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	return resp
+}
+
+// scriptErrorResponse converts a script failure into an error response.
+// Errors raised with error tables, such as failed redis.call commands and
+// redis.error_reply, are returned as is.
+func scriptErrorResponse(err error, timeout time.Duration) *protocol.Response {
+	if errors.Is(err, lua.ErrTimeout) {
+		return &protocol.Response{
+			Type:  protocol.RespError,
+			Error: fmt.Sprintf("script exceeded its time limit of %v; writes made before were kept", timeout),
+		}
+	}
+
+	var rt *lua.RuntimeError
+	if errors.As(err, &rt) {
+		if t, ok := rt.Value.(*lua.Table); ok {
+			if msg, ok := t.GetString("err").(string); ok {
+				return &protocol.Response{Type: protocol.RespError, Error: msg}
+			}
+		}
+	}
+	return &protocol.Response{Type: protocol.RespError, Error: "Error running script: " + err.Error()}
+}
+
+// redisLibrary returns the redis table through which scripts run commands
+// on s.
+func (s *Server) redisLibrary() *lua.Table {
+	lib := lua.NewTable()
+	set := func(name string, fn lua.GoFunction) {
+		_ = lib.Set(name, lua.NewFunction(name, fn))
+	}
+
+	set("call", func(_ *lua.State, args []lua.Value) ([]lua.Value, error) {
+		reply, err := s.scriptCall(args)
+		if err != nil {
+			return nil, &lua.RuntimeError{Value: errorTable(err.Error())}
+		}
+		return []lua.Value{reply}, nil
+	})
+	set("pcall", func(_ *lua.State, args []lua.Value) ([]lua.Value, error) {
+		reply, err := s.scriptCall(args)
+		if err != nil {
+			return []lua.Value{errorTable(err.Error())}, nil
+		}
+		return []lua.Value{reply}, nil
+	})
+	set("error_reply", func(_ *lua.State, args []lua.Value) ([]lua.Value, error) {
+		msg, ok := scriptArg(args, 0)
+		if !ok {
+			return nil, errors.New("wrong number or type of arguments")
+		}
+		return []lua.Value{errorTable(msg)}, nil
+	})
+	set("status_reply", func(_ *lua.State, args []lua.Value) ([]lua.Value, error) {
+		msg, ok := scriptArg(args, 0)
+		if !ok {
+			return nil, errors.New("wrong number or type of arguments")
+		}
+		status := lua.NewTable()
+		_ = status.Set("ok", msg)
+		return []lua.Value{status}, nil
+	})
+	set("sha1hex", func(_ *lua.State, args []lua.Value) ([]lua.Value, error) {
+		str, ok := scriptArg(args, 0)
+		if !ok {
+			return nil, errors.New("wrong number or type of arguments")
+		}
+		sum := sha1.Sum([]byte(str)) //nolint:gosec // Not used for security
+		return []lua.Value{hex.EncodeToString(sum[:])}, nil
+	})
+	return lib
+}
+
+// scriptArg returns argument i as a string, accepting strings and numbers.
+func scriptArg(args []lua.Value, i int) (string, bool) {
+	if i >= len(args) {
+		return "", false
+	}
+	switch v := args[i].(type) {
+	case string:
+		return v, true
+	case float64:
+		return lua.FormatNumber(v), true
+	}
+	return "", false
+}
+
+func errorTable(msg string) *lua.Table {
+	t := lua.NewTable()
+	_ = t.Set("err", msg)
+	return t
+}
+
+func stringsToTable(values []string) *lua.Table {
+	t := lua.NewTable()
+	for _, v := range values {
+		t.Append(v)
+	}
+	return t
+}
+
+// scriptCall runs the command given by redis.call arguments and converts
+// its reply to a Lua value.
+func (s *Server) scriptCall(args []lua.Value) (lua.Value, error) {
+	if len(args) == 0 {
+		return nil, errors.New("please specify at least one argument for redis.call")
+	}
+	strs := make([]string, len(args))
+	for i := range args {
+		str, ok := scriptArg(args, i)
+		if !ok {
+			return nil, errors.New("redis.call arguments must be strings or integers")
+		}
+		strs[i] = str
+	}
+
+	cmd, err := scriptCommand(strs[0], strs[1:])
+	if err != nil {
+		return nil, err
+	}
+	resp := s.executeCommand(cmd)
+	if resp.Type == protocol.RespError {
+		return nil, errors.New(resp.Error)
+	}
+	return replyToLua(resp), nil
+}
+
+// scriptCommandShape describes how redis.call arguments map to a command.
+type scriptCommandShape uint8
+
+const (
+	shapeKeyed      scriptCommandShape = iota // key arg... (Key is the first argument)
+	shapeUnkeyed                              // arg... (no key, as PING and XREAD)
+	shapeSubcommand                           // sub key arg... (BITOP op dest src..., XGROUP CREATE key ...)
+	shapeSet                                  // key value [EX seconds|PX milliseconds]
+	shapeExpire                               // key seconds
+)
+
+type scriptCommandSpec struct {
+	cmdType protocol.CommandType
+	shape   scriptCommandShape
+}
+
+// scriptCommands lists the commands scripts can call. Transactions,
+// subscriptions and scripting commands are not available to scripts.
+var scriptCommands = map[string]scriptCommandSpec{
+	"GET":            {protocol.CmdGet, shapeKeyed},
+	"GETV":           {protocol.CmdGetV, shapeKeyed},
+	"GCRA":           {protocol.CmdGCRA, shapeKeyed},
+	"TOKENBUCKET":    {protocol.CmdTokenBucket, shapeKeyed},
+	"SET":            {protocol.CmdSet, shapeSet},
+	"DEL":            {protocol.CmdDel, shapeKeyed},
+	"EXISTS":         {protocol.CmdExists, shapeKeyed},
+	"INCR":           {protocol.CmdIncr, shapeKeyed},
+	"DECR":           {protocol.CmdDecr, shapeKeyed},
+	"INCRBY":         {protocol.CmdIncrBy, shapeKeyed},
+	"DECRBY":         {protocol.CmdDecrBy, shapeKeyed},
+	"EXPIRE":         {protocol.CmdExpire, shapeExpire},
+	"TTL":            {protocol.CmdTTL, shapeKeyed},
+	"PERSIST":        {protocol.CmdPersist, shapeKeyed},
+	"HGET":           {protocol.CmdHGet, shapeKeyed},
+	"HSET":           {protocol.CmdHSet, shapeKeyed},
+	"HDEL":           {protocol.CmdHDel, shapeKeyed},
+	"HGETALL":        {protocol.CmdHGetAll, shapeKeyed},
+	"HEXISTS":        {protocol.CmdHExists, shapeKeyed},
+	"LPUSH":          {protocol.CmdLPush, shapeKeyed},
+	"RPUSH":          {protocol.CmdRPush, shapeKeyed},
+	"LPOP":           {protocol.CmdLPop, shapeKeyed},
+	"RPOP":           {protocol.CmdRPop, shapeKeyed},
+	"LLEN":           {protocol.CmdLLen, shapeKeyed},
+	"SADD":           {protocol.CmdSAdd, shapeKeyed},
+	"SREM":           {protocol.CmdSRem, shapeKeyed},
+	"SMEMBERS":       {protocol.CmdSMembers, shapeKeyed},
+	"SISMEMBER":      {protocol.CmdSIsMember, shapeKeyed},
+	"PING":           {protocol.CmdPing, shapeUnkeyed},
+	"SETBIT":         {protocol.CmdSetBit, shapeKeyed},
+	"GETBIT":         {protocol.CmdGetBit, shapeKeyed},
+	"BITCOUNT":       {protocol.CmdBitCount, shapeKeyed},
+	"BITPOS":         {protocol.CmdBitPos, shapeKeyed},
+	"BITOP":          {protocol.CmdBitOp, shapeSubcommand},
+	"PFADD":          {protocol.CmdPFAdd, shapeKeyed},
+	"PFCOUNT":        {protocol.CmdPFCount, shapeKeyed},
+	"PFMERGE":        {protocol.CmdPFMerge, shapeKeyed},
+	"XADD":           {protocol.CmdXAdd, shapeKeyed},
+	"XLEN":           {protocol.CmdXLen, shapeKeyed},
+	"XRANGE":         {protocol.CmdXRange, shapeKeyed},
+	"XREVRANGE":      {protocol.CmdXRevRange, shapeKeyed},
+	"XTRIM":          {protocol.CmdXTrim, shapeKeyed},
+	"XREAD":          {protocol.CmdXRead, shapeUnkeyed},
+	"XGROUP":         {protocol.CmdXGroup, shapeSubcommand},
+	"XREADGROUP":     {protocol.CmdXReadGroup, shapeUnkeyed},
+	"XACK":           {protocol.CmdXAck, shapeKeyed},
+	"XPENDING":       {protocol.CmdXPending, shapeKeyed},
+	"XCLAIM":         {protocol.CmdXClaim, shapeKeyed},
+	"GEOADD":         {protocol.CmdGeoAdd, shapeKeyed},
+	"GEOPOS":         {protocol.CmdGeoPos, shapeKeyed},
+	"GEODIST":        {protocol.CmdGeoDist, shapeKeyed},
+	"GEOSEARCH":      {protocol.CmdGeoSearch, shapeKeyed},
+	"JSON.SET":       {protocol.CmdJSONSet, shapeKeyed},
+	"JSON.GET":       {protocol.CmdJSONGet, shapeKeyed},
+	"JSON.DEL":       {protocol.CmdJSONDel, shapeKeyed},
+	"JSON.NUMINCRBY": {protocol.CmdJSONNumIncrBy, shapeKeyed},
+	"JSON.ARRAPPEND": {protocol.CmdJSONArrAppend, shapeKeyed},
+	"PUBLISH":        {protocol.CmdPublish, shapeKeyed},
+	"SPUBLISH":       {protocol.CmdSPublish, shapeKeyed},
+}
+
+// scriptCommand builds the command for redis.call(name, args...).
+func scriptCommand(name string, args []string) (*protocol.Command, error) {
+	spec, ok := scriptCommands[strings.ToUpper(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown command called from script: %s", name)
+	}
+
+	cmd := &protocol.Command{Type: spec.cmdType}
+	switch spec.shape {
+	case shapeUnkeyed:
+		cmd.Args = args
+		return cmd, nil
+	case shapeSubcommand:
+		if len(args) < 2 {
+			return nil, fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(name))
+		}
+		cmd.Key = args[1]
+		cmd.Args = append([]string{args[0]}, args[2:]...)
+		return cmd, nil
+	}
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(name))
+	}
+	cmd.Key, cmd.Args = args[0], args[1:]
+
+	switch spec.shape {
+	case shapeSet:
+		return scriptSetCommand(cmd)
+	case shapeExpire:
+		if len(cmd.Args) != 1 {
+			return nil, errors.New("wrong number of arguments for 'expire' command")
+		}
+		seconds, err := strconv.ParseInt(cmd.Args[0], 10, 64)
+		if err != nil {
+			return nil, errors.New("value is not an integer or out of range")
+		}
+		cmd.TTL, cmd.Args = time.Duration(seconds)*time.Second, nil
+	}
+	return cmd, nil
+}
+
+// scriptSetCommand parses SET key value [EX seconds|PX milliseconds]
+// [IFVER version].
+func scriptSetCommand(cmd *protocol.Command) (*protocol.Command, error) {
+	if len(cmd.Args)%2 != 1 {
+		return nil, errors.New("syntax error")
+	}
+	args := []string{cmd.Args[0]}
+	for i := 1; i < len(cmd.Args); i += 2 {
+		option, arg := strings.ToUpper(cmd.Args[i]), cmd.Args[i+1]
+		if option == "IFVER" {
+			args = append(args, option, arg)
+			continue
+		}
+
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, errors.New("invalid expire time in 'set' command")
+		}
+		switch option {
+		case "EX":
+			cmd.TTL = time.Duration(n) * time.Second
+		case "PX":
+			cmd.TTL = time.Duration(n) * time.Millisecond
+		default:
+			return nil, errors.New("syntax error")
+		}
+	}
+	cmd.Args = args
+	return cmd, nil
+}
+
+// replyToLua converts a command reply as Redis does: integers to numbers,
+// strings to strings, arrays to tables, nil to false and OK to a status
+// table {ok = "OK"}.
+func replyToLua(resp *protocol.Response) lua.Value {
+	switch resp.Type {
+	case protocol.RespOK:
+		status := lua.NewTable()
+		_ = status.Set("ok", "OK")
+		return status
+	case protocol.RespString:
+		str, _ := resp.Data.(string)
+		return str
+	case protocol.RespInt:
+		n, _ := resp.Data.(int64)
+		return float64(n)
+	case protocol.RespArray:
+		values, _ := resp.Data.([]string)
+		return stringsToTable(values)
+	case protocol.RespNested:
+		items, _ := resp.Data.([]interface{})
+		return nestedToLua(items)
+	}
+	return false
+}
+
+func nestedToLua(items []interface{}) *lua.Table {
+	t := lua.NewTable()
+	for i, item := range items {
+		var v lua.Value = false
+		switch x := item.(type) {
+		case string:
+			v = x
+		case int64:
+			v = float64(x)
+		case []string:
+			v = stringsToTable(x)
+		case []interface{}:
+			v = nestedToLua(x)
+		}
+		_ = t.Set(float64(i+1), v)
+	}
+	return t
+}
+
+// scriptReply converts the value returned by a script to a response:
+// numbers become integers (truncated), strings strings, tables arrays up to
+// their first nil, true 1 and false or nil a nil reply. Tables with an err
+// or ok field become error and status replies.
+func scriptReply(v lua.Value) (*protocol.Response, error) {
+	if t, ok := v.(*lua.Table); ok {
+		if msg, ok := t.GetString("err").(string); ok {
+			return &protocol.Response{Type: protocol.RespError, Error: msg}, nil
+		}
+		if status, ok := t.GetString("ok").(string); ok {
+			if status == "OK" {
+				return &protocol.Response{Type: protocol.RespOK}, nil
+			}
+			return &protocol.Response{Type: protocol.RespString, Data: status}, nil
+		}
+		items, err := scriptArray(t, 1)
+		if err != nil {
+			return nil, err
+		}
+		return &protocol.Response{Type: protocol.RespNested, Data: items}, nil
+	}
+
+	switch item := scriptItem(v).(type) {
+	case nil:
+		return &protocol.Response{Type: protocol.RespNil}, nil
+	case int64:
+		return &protocol.Response{Type: protocol.RespInt, Data: item}, nil
+	default:
+		return &protocol.Response{Type: protocol.RespString, Data: item}, nil
+	}
+}
+
+// scriptItem converts a non-table value to a nested reply element.
+func scriptItem(v lua.Value) interface{} {
+	switch x := v.(type) {
+	case float64:
+		return int64(x)
+	case string:
+		return x
+	case bool:
+		if x {
+			return int64(1)
+		}
+	}
+	return nil
+}
+
+func scriptArray(t *lua.Table, depth int) ([]interface{}, error) {
+	if depth > maxScriptReplyDepth {
+		return nil, errors.New("reply nested too deeply")
+	}
+
+	items := make([]interface{}, 0, t.Len())
+	for i := 1; i <= t.Len(); i++ {
+		v := t.Get(float64(i))
+		if v == nil {
+			break
+		}
+		nested, ok := v.(*lua.Table)
+		if !ok {
+			items = append(items, scriptItem(v))
+			continue
+		}
+
+		// Status and error replies inside arrays become plain strings.
+		if msg, ok := nested.GetString("err").(string); ok {
+			items = append(items, msg)
+			continue
+		}
+		if status, ok := nested.GetString("ok").(string); ok {
+			items = append(items, status)
+			continue
+		}
+		arr, err := scriptArray(nested, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, arr)
+	}
+	return items, nil
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// eval builds an EVAL command for src with keys followed by args.
+func eval(src string, keys []string, args ...string) *protocol.Command {
+	cmdArgs := append([]string{src, strconv.Itoa(len(keys))}, keys...)
+	return &protocol.Command{Type: protocol.CmdEval, Args: append(cmdArgs, args...)}
+}
+
+func TestEval(t *testing.T) {
+	s := New(0)
+
+	tests := []struct {
+		src  string
+		keys []string
+		args []string
+		typ  protocol.ResponseType
+		data interface{}
+	}{
+		{"return 42", nil, nil, protocol.RespInt, int64(42)},
+		{"return 3.9", nil, nil, protocol.RespInt, int64(3)},
+		{"return 'str'", nil, nil, protocol.RespString, "str"},
+		{"return KEYS[1] .. ARGV[2]", []string{"k"}, []string{"a", "b"}, protocol.RespString, "kb"},
+		{"return nil", nil, nil, protocol.RespNil, nil},
+		{"return false", nil, nil, protocol.RespNil, nil},
+		{"return true", nil, nil, protocol.RespInt, int64(1)},
+		{"return redis.status_reply('PONG')", nil, nil, protocol.RespString, "PONG"},
+		{"return redis.call('SET', KEYS[1], ARGV[1])", []string{"k"}, []string{"v"}, protocol.RespOK, nil},
+		{"return redis.call('GET', KEYS[1])", []string{"k"}, nil, protocol.RespString, "v"},
+		{"return redis.call('GET', 'missing')", nil, nil, protocol.RespNil, nil},
+		{"return redis.sha1hex('')", nil, nil, protocol.RespString, "da39a3ee5e6b4b0d3255bfef95601890afd80709"},
+	}
+	for _, tt := range tests {
+		resp := s.executeCommand(eval(tt.src, tt.keys, tt.args...))
+		if resp.Type != tt.typ || resp.Data != tt.data {
+			t.Errorf("EVAL %q = %+v, want %v of type %d", tt.src, resp, tt.data, tt.typ)
+		}
+	}
+
+	resp := s.executeCommand(eval("return {1, 'two', {3}, nil, 5}", nil))
+	items, _ := resp.Data.([]interface{})
+	if len(items) != 3 || items[0] != int64(1) || items[1] != "two" {
+		t.Fatalf("Expected the array up to its first nil, got %+v", resp)
+	}
+	if nested, _ := items[2].([]interface{}); len(nested) != 1 || nested[0] != int64(3) {
+		t.Errorf("Expected a nested array, got %v", items[2])
+	}
+
+	for _, args := range [][]string{{"return 1"}, {"return 1", "x"}, {"return 1", "-1"}, {"return 1", "2", "k"}} {
+		resp := s.executeCommand(&protocol.Command{Type: protocol.CmdEval, Args: args})
+		if resp.Type != protocol.RespError {
+			t.Errorf("Expected an error for EVAL %q, got %+v", args, resp)
+		}
+	}
+	if resp := s.executeCommand(eval("return return", nil)); resp.Type != protocol.RespError {
+		t.Errorf("Expected a compile error, got %+v", resp)
+	}
+}
+
+func TestEvalShaAndScriptCommands(t *testing.T) {
+	s := New(0)
+	src := "return ARGV[1] * 2"
+
+	resp := s.executeCommand(command(protocol.CmdScript, "", "LOAD", src))
+	sha, _ := resp.Data.(string)
+	if resp.Type != protocol.RespString || len(sha) != 40 {
+		t.Fatalf("Expected a SHA1 digest, got %+v", resp)
+	}
+	if resp := s.executeCommand(eval("return redis.sha1hex(ARGV[1])", nil, src)); resp.Data != sha {
+		t.Errorf("Expected the digest of the source %s, got %+v", sha, resp)
+	}
+
+	evalSha := &protocol.Command{Type: protocol.CmdEvalSha, Args: []string{sha, "0", "21"}}
+	if resp := s.executeCommand(evalSha); resp.Data != int64(42) {
+		t.Errorf("Expected 42 from EVALSHA, got %+v", resp)
+	}
+	upper := &protocol.Command{Type: protocol.CmdEvalSha, Args: []string{strings.ToUpper(sha), "0", "1"}}
+	if resp := s.executeCommand(upper); resp.Data != int64(2) {
+		t.Errorf("Expected digests to be case-insensitive, got %+v", resp)
+	}
+
+	resp = s.executeCommand(command(protocol.CmdScript, "", "EXISTS", sha, "0000000000000000000000000000000000000000"))
+	if exists, _ := resp.Data.([]interface{}); len(exists) != 2 || exists[0] != int64(1) || exists[1] != int64(0) {
+		t.Errorf("Expected [1 0], got %+v", resp)
+	}
+
+	if resp := s.executeCommand(command(protocol.CmdScript, "", "FLUSH")); resp.Type != protocol.RespOK {
+		t.Fatalf("SCRIPT FLUSH failed: %+v", resp)
+	}
+	resp = s.executeCommand(evalSha)
+	if resp.Type != protocol.RespError || !strings.HasPrefix(resp.Error, "NOSCRIPT") {
+		t.Errorf("Expected NOSCRIPT after SCRIPT FLUSH, got %+v", resp)
+	}
+
+	// EVAL caches the script for EVALSHA.
+	s.executeCommand(eval(src, nil, "1"))
+	if resp := s.executeCommand(evalSha); resp.Data != int64(42) {
+		t.Errorf("Expected EVAL to cache the script, got %+v", resp)
+	}
+
+	for _, args := range [][]string{{}, {"LOAD"}, {"LOAD", "return return"}, {"EXISTS"}, {"UNKNOWN"}} {
+		resp := s.executeCommand(&protocol.Command{Type: protocol.CmdScript, Args: args})
+		if resp.Type != protocol.RespError {
+			t.Errorf("Expected an error for SCRIPT %q, got %+v", args, resp)
+		}
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	s := New(0)
+	s.executeCommand(command(protocol.CmdSet, "str", "value"))
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		// Failed commands end the script with the command's error.
+		{"return redis.call('INCR', 'str')", "value is not an integer"},
+		{"redis.call('INCR', 'str') return 1", "value is not an integer"},
+		{"return redis.call('NOSUCH', 'key')", "unknown command called from script: NOSUCH"},
+		{"return redis.call('MULTI')", "unknown command called from script: MULTI"},
+		{"return redis.call()", "please specify at least one argument for redis.call"},
+		{"return redis.call('GET', {})", "redis.call arguments must be strings or integers"},
+		{"return redis.call('SET', 'k', 'v', 'EX', '0')", "invalid expire time in 'set' command"},
+		// Error tables become error replies.
+		{"return redis.error_reply('custom failure')", "custom failure"},
+		{"return {err = 'raw table'}", "raw table"},
+		{"error({err = 'raised table'})", "raised table"},
+		// Other Lua errors are reported with their position.
+		{"error('boom')", "Error running script: user_script:1: boom"},
+		{"local x = nil return x.y", "Error running script: user_script:1: attempt to index local 'x' (a nil value)"},
+	}
+	for _, tt := range tests {
+		resp := s.executeCommand(eval(tt.src, nil))
+		if resp.Type != protocol.RespError || !strings.HasPrefix(resp.Error, tt.want) {
+			t.Errorf("EVAL %q = %+v, want error %q", tt.src, resp, tt.want)
+		}
+	}
+
+	// redis.pcall returns errors as tables that the script can inspect.
+	resp := s.executeCommand(eval(`
+		local reply = redis.pcall('INCR', 'str')
+		if type(reply) == 'table' and reply.err then return 'caught: ' .. reply.err end
+		return 'not caught'`, nil))
+	if msg, _ := resp.Data.(string); !strings.HasPrefix(msg, "caught: value is not an integer") {
+		t.Errorf("Expected redis.pcall to return the error, got %+v", resp)
+	}
+
+	// Lua pcall catches redis.call errors as error tables.
+	resp = s.executeCommand(eval(`
+		local ok, err = pcall(redis.call, 'INCR', 'str')
+		if not ok then return err.err end
+		return 'not caught'`, nil))
+	if msg, _ := resp.Data.(string); !strings.HasPrefix(msg, "value is not an integer") {
+		t.Errorf("Expected pcall to catch the error table, got %+v", resp)
+	}
+}
+
+func TestScriptWritesBeforeErrorAreKept(t *testing.T) {
+	s := New(0)
+	s.executeCommand(command(protocol.CmdSet, "str", "value"))
+
+	resp := s.executeCommand(eval("redis.call('SET', 'first', '1') redis.call('INCR', 'str')", nil))
+	if resp.Type != protocol.RespError {
+		t.Fatalf("Expected the script to fail, got %+v", resp)
+	}
+	if resp := s.executeCommand(command(protocol.CmdGet, "first")); resp.Data != "1" {
+		t.Errorf("Expected the write before the error to be kept, got %+v", resp)
+	}
+}
+
+func TestScriptAtomicity(t *testing.T) {
+	s, addr := startServer(t)
+
+	// The script increments a counter many times; no reader may see a value
+	// in between.
+	const increments = 2000
+	src := "for i = 1, " + strconv.Itoa(increments) + " do redis.call('INCR', KEYS[1]) end return 1"
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn := dial(t, addr)
+		for i := 0; i < 5; i++ {
+			if resp := roundTrip(t, conn, eval(src, []string{"counter"})); resp.Type == protocol.RespError {
+				t.Errorf("Script failed: %s", resp.Error)
+				return
+			}
+		}
+	}()
+
+	conn := dial(t, addr)
+	for done := false; !done; {
+		resp := roundTrip(t, conn, command(protocol.CmdGet, "counter"))
+		if resp.Type == protocol.RespString {
+			n, err := strconv.Atoi(resp.Data.(string)) //nolint:errcheck // Checked by the type
+			if err != nil || n%increments != 0 {
+				t.Fatalf("Read %v in the middle of a script", resp.Data)
+			}
+			done = n == 5*increments
+		}
+	}
+	wg.Wait()
+
+	if resp := s.executeCommand(command(protocol.CmdGet, "counter")); resp.Data != strconv.Itoa(5*increments) {
+		t.Errorf("Expected %d, got %+v", 5*increments, resp)
+	}
+}
+
+func TestScriptTimeLimit(t *testing.T) {
+	s := New(0)
+	s.SetScriptTimeout(50 * time.Millisecond)
+
+	start := time.Now()
+	resp := s.executeCommand(eval("redis.call('SET', KEYS[1], 'before') while true do end", []string{"k"}))
+	if resp.Type != protocol.RespError || !strings.Contains(resp.Error, "time limit") {
+		t.Errorf("Expected a time limit error, got %+v", resp)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Script ran for %v with a 50ms limit", elapsed)
+	}
+
+	// pcall does not catch the time limit, and writes made before are kept.
+	resp = s.executeCommand(eval("pcall(function() while true do end end) return 'escaped'", nil))
+	if resp.Type != protocol.RespError {
+		t.Errorf("Expected pcall not to catch the time limit, got %+v", resp)
+	}
+	if resp := s.executeCommand(command(protocol.CmdGet, "k")); resp.Data != "before" {
+		t.Errorf("Expected the write before the timeout to be kept, got %+v", resp)
+	}
+
+	// The server keeps serving commands afterwards.
+	if resp := s.executeCommand(eval("return 1", nil)); resp.Data != int64(1) {
+		t.Errorf("Expected scripts to run after a timeout, got %+v", resp)
+	}
+}
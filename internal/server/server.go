@@ -25,15 +25,34 @@
 //   - Hash operations: HGET, HSET, HDEL, HGETALL
 //   - List operations: LPUSH, RPUSH, LPOP, RPOP, LLEN
 //   - Set operations: SADD, SREM, SMEMBERS, SISMEMBER
+//   - Bitmap operations: SETBIT, GETBIT, BITCOUNT, BITPOS, BITOP
+//   - HyperLogLog operations: PFADD, PFCOUNT, PFMERGE
+//   - Stream operations: XADD, XRANGE, XREAD, XREADGROUP, XACK, XPENDING, XCLAIM
+//   - Geo operations: GEOADD, GEOPOS, GEODIST, GEOSEARCH
+//   - JSON operations: JSON.SET, JSON.GET, JSON.DEL, JSON.NUMINCRBY, JSON.ARRAPPEND
+//   - Pub/Sub: SUBSCRIBE, UNSUBSCRIBE, PSUBSCRIBE, PUNSUBSCRIBE, PUBLISH,
+//     SSUBSCRIBE, SUNSUBSCRIBE, SPUBLISH
+//   - Transactions: MULTI, EXEC, DISCARD, WATCH, UNWATCH
+//   - Scripting: EVAL, EVALSHA, SCRIPT LOAD, SCRIPT EXISTS, SCRIPT FLUSH
+//   - Versioned values: GETV, SET IFVER
+//   - Rate limiting: GCRA, TOKENBUCKET
+//   - Serialization: DUMP, RESTORE
+//   - Keyspace: KEYS, SCAN, MIGRATE, HOTKEYS
+//   - Client-side caching: CLIENT TRACKING, invalidations on __cachemir__:invalidate
+//   - Replication: REPLICAOF, ROLE (replicas are read-only)
+//   - Keyspace notifications on __keyspace@0__ and __keyevent@0__ channels
 //   - Utility: PING
 package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cachemir/cachemir/pkg/cache"
@@ -64,9 +83,22 @@ const (
 //	// Later, to stop the server
 //	server.Stop()
 type Server struct {
-	cache    *cache.Cache // The underlying cache engine
-	listener net.Listener // TCP listener for incoming connections
-	port     int          // Port number to listen on
+	cache       *cache.Cache // The underlying cache engine
+	pubsub      *pubSub      // Channel and pattern subscriptions
+	listener    net.Listener // TCP listener for incoming connections
+	port        int          // Port number to listen on
+	transaction bool         // Set on views executing a transaction
+
+	scripts       *scriptCache  // Scripts loaded by EVAL and SCRIPT LOAD
+	scriptTimeout time.Duration // Maximum run time of a script
+
+	replication *replication // Replicas of this server and link to its primary
+	cluster     *cluster     // Cluster membership and ring; nil unless in cluster mode
+
+	hotKeys  *hotKeys  // Most accessed keys, reported by HOTKEYS
+	tracking *tracking // Keys read by connections with CLIENT TRACKING ON
+
+	disconnected <-chan struct{} // Closed when the client of a blocking command leaves; nil otherwise
 }
 
 // New creates a new Server instance that will listen on the specified port.
@@ -83,10 +115,19 @@ type Server struct {
 // Returns:
 //   - A new Server instance ready to be started
 func New(port int) *Server {
-	return &Server{
-		cache: cache.New(),
-		port:  port,
+	s := &Server{
+		cache:         cache.New(),
+		pubsub:        newPubSub(),
+		port:          port,
+		scripts:       newScriptCache(),
+		scriptTimeout: defaultScriptTimeout,
+		replication:   newReplication(),
+		hotKeys:       newHotKeys(),
 	}
+	s.tracking = newTracking(func(key string) { s.pubsub.publish(protocol.InvalidateChannel, key) })
+	s.cache.SetChangeHandler(s.keyChanged)
+	s.cache.SetExpiryHandler(s.replication.recordExpiry)
+	return s
 }
 
 // Start begins listening for TCP connections and processing commands.
@@ -122,6 +163,9 @@ func (s *Server) Start() error {
 
 	for {
 		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
 		if err != nil {
 			log.Printf("Failed to accept connection: %v", err)
 			continue
@@ -134,6 +178,7 @@ func (s *Server) Start() error {
 // Stop gracefully shuts down the server by closing the TCP listener.
 // This will cause Start() to return and stop accepting new connections.
 // Existing connections will continue to be processed until they complete.
+// A replica also stops replicating from its primary.
 //
 // Example:
 //
@@ -145,6 +190,8 @@ func (s *Server) Start() error {
 // Returns:
 //   - Error if there was a problem closing the listener
 func (s *Server) Stop() error {
+	s.stopReplicating()
+	s.stopCluster()
 	if s.listener != nil {
 		return s.listener.Close()
 	}
@@ -160,26 +207,92 @@ func (s *Server) Stop() error {
 //
 // The connection has timeouts for both reading and writing to prevent
 // hanging connections from consuming resources.
+//
+// A SUBSCRIBE, PSUBSCRIBE or SSUBSCRIBE switches the connection to subscriber
+// mode: from then on all replies are queued behind push messages, the read
+// timeout is lifted while subscriptions are active, and only subscription
+// commands and PING are accepted until the last subscription is removed.
+// After MULTI, commands are queued for the connection until EXEC or DISCARD.
+// SYNC hands the connection over to replication for the rest of its life.
+// After CLIENT TRACKING ON, the keys the connection reads are tracked for
+// invalidation (see tracking). While a blocking command runs, the
+// connection is watched so that the command ends if the client leaves.
 func (s *Server) handleConnection(conn net.Conn) {
+	reader := &connReader{conn: conn}
+	var sub *subscriber
+	var tx transaction
+	var tracking bool
 	defer func() {
-		if err := conn.Close(); err != nil {
+		if sub != nil {
+			s.pubsub.remove(sub)
+			close(sub.out)
+		}
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
 			log.Printf("Error closing connection: %v", err)
 		}
 	}()
 
 	for {
-		if err := conn.SetReadDeadline(time.Now().Add(defaultReadTimeoutSecs * time.Second)); err != nil {
+		subscribed := sub != nil && s.pubsub.subscriptions(sub) > 0
+
+		var readDeadline time.Time
+		if !subscribed {
+			readDeadline = time.Now().Add(defaultReadTimeoutSecs * time.Second)
+		}
+		if err := conn.SetReadDeadline(readDeadline); err != nil {
 			log.Printf("Error setting read deadline: %v", err)
 			return
 		}
 
-		cmd, err := protocol.ReadCommand(conn)
+		cmd, err := protocol.ReadCommand(reader)
 		if err != nil {
-			log.Printf("Failed to read command: %v", err)
+			if !errors.Is(err, io.EOF) { // Closed by the client
+				log.Printf("Failed to read command: %v", err)
+			}
 			return
 		}
 
-		resp := s.executeCommand(cmd)
+		if cmd.Type == protocol.CmdSync && sub == nil && !tx.active {
+			s.serveReplica(conn, cmd)
+			return
+		}
+
+		if isSubscriptionCommand(cmd.Type) && !tx.active {
+			if sub == nil {
+				sub = newSubscriber(conn)
+			}
+			s.handleSubscriptionCommand(sub, cmd)
+			continue
+		}
+
+		var resp *protocol.Response
+		switch {
+		case subscribed && cmd.Type != protocol.CmdPing:
+			resp = &protocol.Response{
+				Type:  protocol.RespError,
+				Error: "only (P|S)SUBSCRIBE, (P|S)UNSUBSCRIBE and PING are allowed in subscriber mode",
+			}
+		case isTransactionCommand(cmd.Type):
+			resp = s.handleTransactionCommand(&tx, cmd)
+		case tx.active:
+			resp = s.queueCommand(&tx, cmd)
+		case cmd.Type == protocol.CmdClient:
+			resp = s.handleClient(&tracking, cmd)
+		default:
+			if tracking && trackedReads[cmd.Type] {
+				s.tracking.track(cmd.Key)
+			}
+			if blockingCommands[cmd.Type] {
+				resp = s.executeBlocking(reader, cmd)
+			} else {
+				resp = s.executeCommand(cmd)
+			}
+		}
+
+		if sub != nil {
+			sub.send(resp)
+			continue
+		}
 
 		if err := conn.SetWriteDeadline(time.Now().Add(defaultWriteTimeoutSecs * time.Second)); err != nil {
 			log.Printf("Error setting write deadline: %v", err)
@@ -194,7 +307,9 @@ func (s *Server) handleConnection(conn net.Conn) {
 
 // executeCommand processes a single command and returns the appropriate response.
 // It acts as a dispatcher, routing commands to their specific handler methods
-// based on the command type. Unknown commands return an error response.
+// based on the command type. Unknown commands return an error response,
+// and so do write commands on a replica. Write commands run atomically as
+// replication units.
 //
 // Parameters:
 //   - cmd: The command to execute
@@ -202,44 +317,120 @@ func (s *Server) handleConnection(conn net.Conn) {
 // Returns:
 //   - Response object containing the result or error
 func (s *Server) executeCommand(cmd *protocol.Command) *protocol.Response {
-	if handler := s.getCommandHandler(cmd.Type); handler != nil {
-		return handler(cmd)
+	if writeCommands[cmd.Type] && s.replication.readOnly() {
+		return &protocol.Response{Type: protocol.RespError, Error: errReadOnly.Error()}
+	}
+	if moved := s.cluster.checkOwner(cmd); moved != nil {
+		return moved
+	}
+	if cmd.Key != "" {
+		s.hotKeys.record(cmd.Key)
+	}
+
+	// XREADGROUP may block, so it records each read attempt as a unit.
+	if writeCommands[cmd.Type] && cmd.Type != protocol.CmdXReadGroup && !s.transaction {
+		var resp *protocol.Response
+		s.atomically(nil, func(view *Server) {
+			resp = view.run(cmd)
+		})
+		return resp
+	}
+	return s.run(cmd)
+}
+
+// run runs cmd with its handler. On a transaction view, the command is
+// recorded for replicas if it changed keys.
+func (s *Server) run(cmd *protocol.Command) *protocol.Response {
+	handler := s.getCommandHandler(cmd.Type)
+	if handler == nil {
+		return &protocol.Response{
+			Type:  protocol.RespError,
+			Error: fmt.Sprintf("unknown command: %d", cmd.Type),
+		}
 	}
 
-	return &protocol.Response{
-		Type:  protocol.RespError,
-		Error: fmt.Sprintf("unknown command: %d", cmd.Type),
+	resp := handler(cmd)
+	if s.transaction {
+		s.replication.record(s.cache, cmd, resp)
 	}
+	return resp
 }
 
 func (s *Server) getCommandHandler(cmdType protocol.CommandType) func(*protocol.Command) *protocol.Response {
 	handlers := map[protocol.CommandType]func(*protocol.Command) *protocol.Response{
-		protocol.CmdGet:       s.handleGet,
-		protocol.CmdSet:       s.handleSet,
-		protocol.CmdDel:       s.handleDel,
-		protocol.CmdExists:    s.handleExists,
-		protocol.CmdIncr:      s.handleIncr,
-		protocol.CmdDecr:      s.handleDecr,
-		protocol.CmdIncrBy:    s.handleIncrBy,
-		protocol.CmdDecrBy:    s.handleDecrBy,
-		protocol.CmdExpire:    s.handleExpire,
-		protocol.CmdTTL:       s.handleTTL,
-		protocol.CmdPersist:   s.handlePersist,
-		protocol.CmdHGet:      s.handleHGet,
-		protocol.CmdHSet:      s.handleHSet,
-		protocol.CmdHDel:      s.handleHDel,
-		protocol.CmdHExists:   s.handleHExists,
-		protocol.CmdHGetAll:   s.handleHGetAll,
-		protocol.CmdLPush:     s.handleLPush,
-		protocol.CmdRPush:     s.handleRPush,
-		protocol.CmdLPop:      s.handleLPop,
-		protocol.CmdRPop:      s.handleRPop,
-		protocol.CmdLLen:      s.handleLLen,
-		protocol.CmdSAdd:      s.handleSAdd,
-		protocol.CmdSRem:      s.handleSRem,
-		protocol.CmdSMembers:  s.handleSMembers,
-		protocol.CmdSIsMember: s.handleSIsMember,
-		protocol.CmdPing:      s.handlePing,
+		protocol.CmdGet:           s.handleGet,
+		protocol.CmdSet:           s.handleSet,
+		protocol.CmdDel:           s.handleDel,
+		protocol.CmdExists:        s.handleExists,
+		protocol.CmdIncr:          s.handleIncr,
+		protocol.CmdDecr:          s.handleDecr,
+		protocol.CmdIncrBy:        s.handleIncrBy,
+		protocol.CmdDecrBy:        s.handleDecrBy,
+		protocol.CmdExpire:        s.handleExpire,
+		protocol.CmdTTL:           s.handleTTL,
+		protocol.CmdPersist:       s.handlePersist,
+		protocol.CmdHGet:          s.handleHGet,
+		protocol.CmdHSet:          s.handleHSet,
+		protocol.CmdHDel:          s.handleHDel,
+		protocol.CmdHExists:       s.handleHExists,
+		protocol.CmdHGetAll:       s.handleHGetAll,
+		protocol.CmdLPush:         s.handleLPush,
+		protocol.CmdRPush:         s.handleRPush,
+		protocol.CmdLPop:          s.handleLPop,
+		protocol.CmdRPop:          s.handleRPop,
+		protocol.CmdLLen:          s.handleLLen,
+		protocol.CmdSAdd:          s.handleSAdd,
+		protocol.CmdSRem:          s.handleSRem,
+		protocol.CmdSMembers:      s.handleSMembers,
+		protocol.CmdSIsMember:     s.handleSIsMember,
+		protocol.CmdPing:          s.handlePing,
+		protocol.CmdSetBit:        s.handleSetBit,
+		protocol.CmdGetBit:        s.handleGetBit,
+		protocol.CmdBitCount:      s.handleBitCount,
+		protocol.CmdBitPos:        s.handleBitPos,
+		protocol.CmdBitOp:         s.handleBitOp,
+		protocol.CmdPFAdd:         s.handlePFAdd,
+		protocol.CmdPFCount:       s.handlePFCount,
+		protocol.CmdPFMerge:       s.handlePFMerge,
+		protocol.CmdPFExport:      s.handlePFExport,
+		protocol.CmdPFImport:      s.handlePFImport,
+		protocol.CmdXAdd:          s.handleXAdd,
+		protocol.CmdXLen:          s.handleXLen,
+		protocol.CmdXRange:        s.handleXRange,
+		protocol.CmdXRevRange:     s.handleXRevRange,
+		protocol.CmdXTrim:         s.handleXTrim,
+		protocol.CmdXRead:         s.handleXRead,
+		protocol.CmdXGroup:        s.handleXGroup,
+		protocol.CmdXReadGroup:    s.handleXReadGroup,
+		protocol.CmdXAck:          s.handleXAck,
+		protocol.CmdXPending:      s.handleXPending,
+		protocol.CmdXClaim:        s.handleXClaim,
+		protocol.CmdGeoAdd:        s.handleGeoAdd,
+		protocol.CmdGeoPos:        s.handleGeoPos,
+		protocol.CmdGeoDist:       s.handleGeoDist,
+		protocol.CmdGeoSearch:     s.handleGeoSearch,
+		protocol.CmdJSONSet:       s.handleJSONSet,
+		protocol.CmdJSONGet:       s.handleJSONGet,
+		protocol.CmdJSONDel:       s.handleJSONDel,
+		protocol.CmdJSONNumIncrBy: s.handleJSONNumIncrBy,
+		protocol.CmdJSONArrAppend: s.handleJSONArrAppend,
+		protocol.CmdPublish:       s.handlePublish,
+		protocol.CmdSPublish:      s.handleSPublish,
+		protocol.CmdEval:          s.handleEval,
+		protocol.CmdEvalSha:       s.handleEvalSha,
+		protocol.CmdScript:        s.handleScript,
+		protocol.CmdGetV:          s.handleGetV,
+		protocol.CmdGCRA:          s.handleGCRA,
+		protocol.CmdTokenBucket:   s.handleTokenBucket,
+		protocol.CmdDump:          s.handleDump,
+		protocol.CmdRestore:       s.handleRestore,
+		protocol.CmdReplicaOf:     s.handleReplicaOf,
+		protocol.CmdRole:          s.handleRole,
+		protocol.CmdKeys:          s.handleKeys,
+		protocol.CmdMigrate:       s.handleMigrate,
+		protocol.CmdCluster:       s.handleCluster,
+		protocol.CmdScan:          s.handleScan,
+		protocol.CmdHotKeys:       s.handleHotKeys,
 	}
 
 	return handlers[cmdType]
@@ -251,23 +442,112 @@ func (s *Server) handlePing(_ *protocol.Command) *protocol.Response {
 
 // handleGet processes GET commands to retrieve string values.
 // Returns the value if found, or a nil response if the key doesn't exist.
+// With WITHPTTL in Args, the value is returned in a two-element array with
+// the milliseconds until it expires, or -1 if it doesn't, for clients that
+// keep it.
 func (s *Server) handleGet(cmd *protocol.Command) *protocol.Response {
-	value, exists := s.cache.Get(cmd.Key)
+	if len(cmd.Args) == 0 {
+		value, exists := s.cache.Get(cmd.Key)
+		if !exists {
+			return &protocol.Response{Type: protocol.RespNil}
+		}
+		return &protocol.Response{Type: protocol.RespString, Data: value}
+	}
+	if len(cmd.Args) != 1 || !strings.EqualFold(cmd.Args[0], "WITHPTTL") {
+		return &protocol.Response{Type: protocol.RespError, Error: "syntax error"}
+	}
+
+	value, ttl, exists := s.cache.GetWithTTL(cmd.Key)
 	if !exists {
 		return &protocol.Response{Type: protocol.RespNil}
 	}
-	return &protocol.Response{Type: protocol.RespString, Data: value}
+	pttl := int64(-1)
+	if ttl > 0 {
+		pttl = ttl.Milliseconds()
+	}
+	return &protocol.Response{Type: protocol.RespNested, Data: []interface{}{value, pttl}}
 }
 
 // handleSet processes SET commands to store string values.
 // Uses the TTL from the command if specified.
 // Returns an OK response on success, or an error if arguments are invalid.
+// With IFVER version in Args, the value is only stored if the key still has
+// that version (0 for a missing key); the reply is then the new version, or
+// nil if the version did not match. VERSION newVersion after it gives the
+// value that version instead of a new one, for read repair between replicas.
 func (s *Server) handleSet(cmd *protocol.Command) *protocol.Response {
-	if len(cmd.Args) == 0 {
+	switch {
+	case len(cmd.Args) == 0:
 		return &protocol.Response{Type: protocol.RespError, Error: "SET requires a value"}
+	case len(cmd.Args) == 1:
+		s.cache.Set(cmd.Key, cmd.Args[0], cmd.TTL)
+		return &protocol.Response{Type: protocol.RespOK}
+	case len(cmd.Args) == 5 && strings.EqualFold(cmd.Args[1], "IFVER") && strings.EqualFold(cmd.Args[3], "VERSION"):
+		return s.handleSetVersion(cmd)
+	case len(cmd.Args) != 3 || !strings.EqualFold(cmd.Args[1], "IFVER"):
+		return &protocol.Response{Type: protocol.RespError, Error: "syntax error"}
 	}
-	s.cache.Set(cmd.Key, cmd.Args[0], cmd.TTL)
-	return &protocol.Response{Type: protocol.RespOK}
+
+	version, err := strconv.ParseUint(cmd.Args[2], 10, 64)
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: "version is not a valid integer"}
+	}
+	newVersion, ok := s.cache.CompareAndSet(cmd.Key, cmd.Args[0], version, cmd.TTL)
+	if !ok {
+		return &protocol.Response{Type: protocol.RespNil}
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: int64(newVersion)}
+}
+
+// handleSetVersion processes SET key value IFVER version VERSION newVersion,
+// which replies like SET IFVER.
+func (s *Server) handleSetVersion(cmd *protocol.Command) *protocol.Response {
+	version, err := strconv.ParseUint(cmd.Args[2], 10, 64)
+	newVersion, newErr := strconv.ParseUint(cmd.Args[4], 10, 64)
+	if err != nil || newErr != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: "version is not a valid integer"}
+	}
+	if newVersion <= version {
+		return &protocol.Response{Type: protocol.RespError, Error: "VERSION must be above IFVER"}
+	}
+	if !s.cache.CompareAndSetVersion(cmd.Key, cmd.Args[0], version, newVersion, cmd.TTL) {
+		return &protocol.Response{Type: protocol.RespNil}
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: int64(newVersion)}
+}
+
+// handleGetV processes GETV commands, which return a string value and its
+// version as a two-element array for a later SET IFVER. With WITHPTTL in
+// Args, the key's remaining TTL in milliseconds (-1 for none) is added as a
+// third element.
+func (s *Server) handleGetV(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) == 0 {
+		value, version, exists := s.cache.GetWithVersion(cmd.Key)
+		if !exists {
+			return &protocol.Response{Type: protocol.RespNil}
+		}
+		return &protocol.Response{Type: protocol.RespNested, Data: []interface{}{value, int64(version)}}
+	}
+	if len(cmd.Args) != 1 || !strings.EqualFold(cmd.Args[0], "WITHPTTL") {
+		return &protocol.Response{Type: protocol.RespError, Error: "syntax error"}
+	}
+
+	var value string
+	var version uint64
+	var ttl time.Duration
+	exists := false
+	s.cache.Transaction(nil, func(tx *cache.Cache) {
+		value, version, exists = tx.GetWithVersion(cmd.Key)
+		_, ttl, _ = tx.GetWithTTL(cmd.Key)
+	})
+	if !exists {
+		return &protocol.Response{Type: protocol.RespNil}
+	}
+	pttl := int64(-1)
+	if ttl > 0 {
+		pttl = ttl.Milliseconds()
+	}
+	return &protocol.Response{Type: protocol.RespNested, Data: []interface{}{value, int64(version), pttl}}
 }
 
 // handleDel processes DEL commands to delete keys.
@@ -559,3 +839,31 @@ func (s *Server) handleSIsMember(cmd *protocol.Command) *protocol.Response {
 func parseIntArg(arg string) (int64, error) {
 	return strconv.ParseInt(arg, 10, 64)
 }
+
+// parseIntArgs parses every argument as a 64-bit signed integer.
+// Returns an error if any argument is not a valid integer.
+func parseIntArgs(args []string) ([]int64, error) {
+	values := make([]int64, len(args))
+	for i, arg := range args {
+		v, err := parseIntArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// errorResponse wraps an error in an error response.
+func errorResponse(err error) *protocol.Response {
+	return &protocol.Response{Type: protocol.RespError, Error: err.Error()}
+}
+
+// boolResponse converts a boolean into a 1/0 integer response.
+func boolResponse(b bool) *protocol.Response {
+	var result int64 = 0
+	if b {
+		result = 1
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: result}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// startServer starts a server on a free local port and returns it with its
+// address. The server is stopped when the test ends.
+func startServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	return startServerWith(t, nil)
+}
+
+// startServerWith is startServer, calling setup with the server and its
+// address before it starts if setup isn't nil.
+func startServerWith(t *testing.T, setup func(s *Server, addr string)) (*Server, string) {
+	t.Helper()
+
+	lc := net.ListenConfig{}
+	l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port //nolint:errcheck // TCP listeners have TCP addresses
+	if err := l.Close(); err != nil {
+		t.Fatalf("Failed to free port: %v", err)
+	}
+
+	s := New(port)
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	if setup != nil {
+		setup(s, addr)
+	}
+	started := make(chan struct{})
+	go func() {
+		close(started)
+		if err := s.Start(); err != nil {
+			t.Errorf("Server failed: %v", err)
+		}
+	}()
+	<-started
+	t.Cleanup(func() { s.Stop() }) //nolint:errcheck,gosec // Nothing to do on failure
+
+	waitFor(t, "server to listen", func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close() //nolint:errcheck,gosec // Only probing
+		return true
+	})
+	return s, addr
+}
+
+// dial connects to the server at addr. The connection is closed when the
+// test ends.
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to %s: %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() }) //nolint:errcheck,gosec // Nothing to do on failure
+	return conn
+}
+
+// roundTrip sends cmd over conn and returns the reply.
+func roundTrip(t *testing.T, conn net.Conn, cmd *protocol.Command) *protocol.Response {
+	t.Helper()
+
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("Failed to set deadline: %v", err)
+	}
+	if err := protocol.WriteCommand(conn, cmd); err != nil {
+		t.Fatalf("Failed to send command %d: %v", cmd.Type, err)
+	}
+	resp, err := protocol.ReadResponse(conn)
+	if err != nil {
+		t.Fatalf("Failed to read reply to command %d: %v", cmd.Type, err)
+	}
+	return resp
+}
+
+// waitFor polls cond until it holds, failing the test after 5 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// command builds a command for tests.
+func command(cmdType protocol.CommandType, key string, args ...string) *protocol.Command {
+	return &protocol.Command{Type: cmdType, Key: key, Args: args}
+}
+
+// subscribe opens a connection subscribed with cmdType (SUBSCRIBE,
+// PSUBSCRIBE or SSUBSCRIBE) to names, and reads the confirmations.
+func subscribe(t *testing.T, addr string, cmdType protocol.CommandType, names ...string) net.Conn {
+	t.Helper()
+
+	conn := dial(t, addr)
+	if err := protocol.WriteCommand(conn, &protocol.Command{Type: cmdType, Args: names}); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	for range names {
+		if push := readPush(t, conn); push[0] != subscribeReplies[subscriptionCommands[cmdType].kind] {
+			t.Fatalf("Expected a subscription confirmation, got %v", push)
+		}
+	}
+	return conn
+}
+
+// readPush reads a push message from conn, failing the test if none
+// arrives within a second.
+func readPush(t *testing.T, conn net.Conn) []interface{} {
+	t.Helper()
+
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("Failed to set deadline: %v", err)
+	}
+	resp, err := protocol.ReadResponse(conn)
+	if err != nil {
+		t.Fatalf("Failed to read a push message: %v", err)
+	}
+	items, ok := resp.Data.([]interface{})
+	if resp.Type != protocol.RespPush || !ok {
+		t.Fatalf("Expected a push message, got %+v", resp)
+	}
+	return items
+}
+
+// expectNoPush fails the test if a push message arrives on conn within
+// 100ms.
+func expectNoPush(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	if err := conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatalf("Failed to set deadline: %v", err)
+	}
+	if resp, err := protocol.ReadResponse(conn); err == nil {
+		t.Errorf("Expected no push message, got %+v", resp)
+	}
+}
@@ -0,0 +1,496 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/cache"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// Stream argument counts
+const (
+	minXAddArgs       = 3
+	minXRangeArgs     = 2
+	minXGroupArgs     = 2
+	minXGroupCreate   = 3
+	minXAckArgs       = 2
+	xPendingRangeArgs = 4
+	minXClaimArgs     = 4
+)
+
+// streamReadOptions holds the parsed options of XREAD and XREADGROUP.
+type streamReadOptions struct {
+	group    string
+	consumer string
+	keys     []string
+	ids      []string
+	count    int
+	block    time.Duration
+	blocking bool
+	noAck    bool
+}
+
+// handleXAdd processes XADD commands to append an entry to a stream.
+// Returns the ID of the new entry.
+func (s *Server) handleXAdd(cmd *protocol.Command) *protocol.Response {
+	trim, rest, err := parseStreamTrim(cmd.Args, false)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if len(rest) < minXAddArgs || len(rest)%2 == 0 {
+		return &protocol.Response{Type: protocol.RespError, Error: "wrong number of arguments for XADD"}
+	}
+
+	id, err := s.cache.XAdd(cmd.Key, rest[0], rest[1:], trim)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &protocol.Response{Type: protocol.RespString, Data: id.String()}
+}
+
+// handleXLen processes XLEN commands and returns the number of entries.
+func (s *Server) handleXLen(cmd *protocol.Command) *protocol.Response {
+	length, err := s.cache.XLen(cmd.Key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: length}
+}
+
+// handleXRange processes XRANGE commands and returns entries in ID order.
+func (s *Server) handleXRange(cmd *protocol.Command) *protocol.Response {
+	return s.xrange(cmd, false)
+}
+
+// handleXRevRange processes XREVRANGE commands and returns entries in reverse ID order.
+func (s *Server) handleXRevRange(cmd *protocol.Command) *protocol.Response {
+	return s.xrange(cmd, true)
+}
+
+func (s *Server) xrange(cmd *protocol.Command, reverse bool) *protocol.Response {
+	if len(cmd.Args) < minXRangeArgs {
+		return &protocol.Response{Type: protocol.RespError, Error: "XRANGE requires start and end"}
+	}
+
+	count := 0
+	if len(cmd.Args) > minXRangeArgs {
+		if len(cmd.Args) != minXRangeArgs+2 || !strings.EqualFold(cmd.Args[2], "COUNT") {
+			return &protocol.Response{Type: protocol.RespError, Error: "syntax error"}
+		}
+		n, err := strconv.Atoi(cmd.Args[3])
+		if err != nil {
+			return &protocol.Response{Type: protocol.RespError, Error: "value is not an integer or out of range"}
+		}
+		if n <= 0 {
+			return &protocol.Response{Type: protocol.RespNested, Data: []interface{}{}}
+		}
+		count = n
+	}
+
+	var entries []cache.StreamEntry
+	var err error
+	if reverse {
+		entries, err = s.cache.XRevRange(cmd.Key, cmd.Args[0], cmd.Args[1], count)
+	} else {
+		entries, err = s.cache.XRange(cmd.Key, cmd.Args[0], cmd.Args[1], count)
+	}
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &protocol.Response{Type: protocol.RespNested, Data: encodeStreamEntries(entries)}
+}
+
+// handleXTrim processes XTRIM commands and returns the number of entries removed.
+func (s *Server) handleXTrim(cmd *protocol.Command) *protocol.Response {
+	trim, rest, err := parseStreamTrim(cmd.Args, true)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if len(rest) != 0 {
+		return &protocol.Response{Type: protocol.RespError, Error: "syntax error"}
+	}
+
+	removed, err := s.cache.XTrim(cmd.Key, *trim)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: removed}
+}
+
+// handleXRead processes XREAD commands. The arguments follow Redis syntax;
+// the command key is only used by clients for routing. With BLOCK, the
+// handler waits until one of the streams receives an entry or the timeout
+// expires, returning nil on timeout.
+func (s *Server) handleXRead(cmd *protocol.Command) *protocol.Response {
+	opts, err := parseStreamReadArgs(cmd.Args, false)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	for i, id := range opts.ids {
+		if id != "$" {
+			continue
+		}
+		lastID, lastErr := s.cache.StreamLastID(opts.keys[i])
+		if lastErr != nil {
+			return errorResponse(lastErr)
+		}
+		opts.ids[i] = lastID.String()
+	}
+
+	return s.readStreams(opts, func() ([]cache.StreamReadResult, error) {
+		return s.cache.XRead(opts.keys, opts.ids, opts.count)
+	})
+}
+
+// handleXGroup processes XGROUP CREATE and XGROUP DESTROY commands.
+func (s *Server) handleXGroup(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) < minXGroupArgs {
+		return &protocol.Response{Type: protocol.RespError, Error: "XGROUP requires a subcommand and group"}
+	}
+
+	switch strings.ToUpper(cmd.Args[0]) {
+	case "CREATE":
+		if len(cmd.Args) < minXGroupCreate {
+			return &protocol.Response{Type: protocol.RespError, Error: "XGROUP CREATE requires group and id"}
+		}
+		mkStream := len(cmd.Args) > minXGroupCreate && strings.EqualFold(cmd.Args[3], "MKSTREAM")
+		if err := s.cache.XGroupCreate(cmd.Key, cmd.Args[1], cmd.Args[2], mkStream); err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Type: protocol.RespOK}
+	case "DESTROY":
+		destroyed, err := s.cache.XGroupDestroy(cmd.Key, cmd.Args[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		return boolResponse(destroyed)
+	default:
+		return &protocol.Response{Type: protocol.RespError, Error: fmt.Sprintf("unknown XGROUP subcommand: %s", cmd.Args[0])}
+	}
+}
+
+// handleXReadGroup processes XREADGROUP commands. Like XREAD, the command key
+// is only used for routing. Blocking applies only when every ID is ">".
+// Each read attempt runs atomically, as a replication unit of its own.
+func (s *Server) handleXReadGroup(cmd *protocol.Command) *protocol.Response {
+	opts, err := parseStreamReadArgs(cmd.Args, true)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	for _, id := range opts.ids {
+		if id != ">" {
+			opts.blocking = false
+		}
+	}
+
+	read := func(c *cache.Cache) ([]cache.StreamReadResult, error) {
+		return c.XReadGroup(opts.group, opts.consumer, opts.keys, opts.ids, opts.count, opts.noAck)
+	}
+	return s.readStreams(opts, func() (results []cache.StreamReadResult, err error) {
+		if s.transaction { // Recorded by run
+			return read(s.cache)
+		}
+		s.atomically(nil, func(view *Server) {
+			results, err = read(view.cache)
+			resp := &protocol.Response{Type: protocol.RespNested}
+			if err != nil {
+				resp = errorResponse(err)
+			}
+			view.replication.record(view.cache, cmd, resp)
+		})
+		return results, err
+	})
+}
+
+// readStreams runs read and, if it returns nothing and blocking was requested,
+// waits for new entries before retrying until the block timeout expires or
+// the client disconnects. Inside a transaction it never blocks.
+func (s *Server) readStreams(opts *streamReadOptions, read func() ([]cache.StreamReadResult, error)) *protocol.Response {
+	if s.transaction {
+		opts.blocking = false // The transaction holds the cache lock
+	}
+
+	var deadline <-chan time.Time
+	if opts.blocking && opts.block > 0 {
+		timer := time.NewTimer(opts.block)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		ready, cancel := s.cache.NotifyStreams(opts.keys...)
+		results, err := read()
+		if err != nil || len(results) > 0 || !opts.blocking {
+			cancel()
+			if err != nil {
+				return errorResponse(err)
+			}
+			if len(results) == 0 {
+				return &protocol.Response{Type: protocol.RespNil}
+			}
+			return &protocol.Response{Type: protocol.RespNested, Data: encodeStreamResults(results)}
+		}
+
+		select {
+		case <-ready:
+			cancel()
+		case <-deadline:
+			cancel()
+			return &protocol.Response{Type: protocol.RespNil}
+		case <-s.disconnected:
+			cancel()
+			return &protocol.Response{Type: protocol.RespNil}
+		}
+	}
+}
+
+// handleXAck processes XACK commands and returns the number of acknowledged entries.
+func (s *Server) handleXAck(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) < minXAckArgs {
+		return &protocol.Response{Type: protocol.RespError, Error: "XACK requires a group and at least one ID"}
+	}
+
+	ids, err := parseStreamIDs(cmd.Args[1:])
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	acked, err := s.cache.XAck(cmd.Key, cmd.Args[0], ids...)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &protocol.Response{Type: protocol.RespInt, Data: acked}
+}
+
+// handleXPending processes XPENDING commands. With only a group it returns a
+// summary [count, lowest, highest, [[consumer, count]...]]; with a range it
+// returns [[id, consumer, idle-ms, deliveries]...].
+func (s *Server) handleXPending(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) == 1 {
+		summary, err := s.cache.XPendingSummary(cmd.Key, cmd.Args[0])
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Type: protocol.RespNested, Data: encodePendingSummary(summary)}
+	}
+
+	if len(cmd.Args) < xPendingRangeArgs {
+		return &protocol.Response{Type: protocol.RespError, Error: "XPENDING requires a group and optionally start, end and count"}
+	}
+
+	count, err := strconv.Atoi(cmd.Args[3])
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: "value is not an integer or out of range"}
+	}
+	consumer := ""
+	if len(cmd.Args) > xPendingRangeArgs {
+		consumer = cmd.Args[4]
+	}
+
+	pending, err := s.cache.XPending(cmd.Key, cmd.Args[0], cmd.Args[1], cmd.Args[2], count, consumer)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	result := make([]interface{}, len(pending))
+	for i, pe := range pending {
+		result[i] = []interface{}{pe.ID.String(), pe.Consumer, pe.Idle.Milliseconds(), pe.Deliveries}
+	}
+	return &protocol.Response{Type: protocol.RespNested, Data: result}
+}
+
+// handleXClaim processes XCLAIM commands. Returns the claimed entries, or
+// only their IDs when JUSTID is given.
+func (s *Server) handleXClaim(cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) < minXClaimArgs {
+		return &protocol.Response{Type: protocol.RespError, Error: "XCLAIM requires group, consumer, min-idle-time and IDs"}
+	}
+
+	minIdle, err := strconv.ParseInt(cmd.Args[2], 10, 64)
+	if err != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: "invalid min-idle-time argument for XCLAIM"}
+	}
+
+	idArgs := cmd.Args[3:]
+	justID := false
+	if last := idArgs[len(idArgs)-1]; strings.EqualFold(last, "JUSTID") {
+		justID = true
+		idArgs = idArgs[:len(idArgs)-1]
+	}
+
+	ids, err := parseStreamIDs(idArgs)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	claimed, err := s.cache.XClaim(cmd.Key, cmd.Args[0], cmd.Args[1], time.Duration(minIdle)*time.Millisecond, justID, ids...)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if justID {
+		result := make([]interface{}, len(claimed))
+		for i, entry := range claimed {
+			result[i] = entry.ID.String()
+		}
+		return &protocol.Response{Type: protocol.RespNested, Data: result}
+	}
+	return &protocol.Response{Type: protocol.RespNested, Data: encodeStreamEntries(claimed)}
+}
+
+// parseStreamTrim parses an optional MAXLEN|MINID [=|~] threshold prefix.
+// If required is true, the trim clause must be present.
+func parseStreamTrim(args []string, required bool) (trim *cache.StreamTrim, rest []string, err error) {
+	if len(args) == 0 {
+		if required {
+			return nil, nil, fmt.Errorf("syntax error")
+		}
+		return nil, args, nil
+	}
+
+	strategy := strings.ToUpper(args[0])
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		if required {
+			return nil, nil, fmt.Errorf("syntax error")
+		}
+		return nil, args, nil
+	}
+
+	args = args[1:]
+	if len(args) > 0 && (args[0] == "=" || args[0] == "~") {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("syntax error")
+	}
+
+	trim = &cache.StreamTrim{}
+	if strategy == "MAXLEN" {
+		maxLen, parseErr := strconv.ParseInt(args[0], 10, 64)
+		if parseErr != nil || maxLen < 0 {
+			return nil, nil, fmt.Errorf("the MAXLEN argument must be >= 0")
+		}
+		trim.Strategy = cache.TrimMaxLen
+		trim.MaxLen = maxLen
+	} else {
+		minID, parseErr := cache.ParseStreamID(args[0])
+		if parseErr != nil {
+			return nil, nil, parseErr
+		}
+		trim.Strategy = cache.TrimMinID
+		trim.MinID = minID
+	}
+	return trim, args[1:], nil
+}
+
+// parseStreamReadArgs parses the arguments of XREAD, or of XREADGROUP when
+// withGroup is set.
+func parseStreamReadArgs(args []string, withGroup bool) (*streamReadOptions, error) {
+	opts := &streamReadOptions{}
+	i := 0
+
+	if withGroup {
+		if len(args) < 3 || !strings.EqualFold(args[0], "GROUP") {
+			return nil, fmt.Errorf("XREADGROUP requires GROUP group consumer")
+		}
+		opts.group, opts.consumer = args[1], args[2]
+		i = 3
+	}
+
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("syntax error")
+			}
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("value is not an integer or out of range")
+			}
+			opts.count = count
+			i++
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("syntax error")
+			}
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || ms < 0 {
+				return nil, fmt.Errorf("timeout is not an integer or out of range")
+			}
+			opts.blocking = true
+			opts.block = time.Duration(ms) * time.Millisecond
+			i++
+		case "NOACK":
+			if !withGroup {
+				return nil, fmt.Errorf("syntax error")
+			}
+			opts.noAck = true
+		case "STREAMS":
+			streams := args[i+1:]
+			if len(streams) == 0 || len(streams)%2 != 0 {
+				return nil, fmt.Errorf("unbalanced list of streams: for each stream key an ID must be specified")
+			}
+			half := len(streams) / 2
+			opts.keys = streams[:half]
+			opts.ids = append([]string(nil), streams[half:]...)
+			return opts, nil
+		default:
+			return nil, fmt.Errorf("syntax error")
+		}
+	}
+	return nil, fmt.Errorf("syntax error")
+}
+
+func parseStreamIDs(args []string) ([]cache.StreamID, error) {
+	ids := make([]cache.StreamID, len(args))
+	for i, arg := range args {
+		id, err := cache.ParseStreamID(arg)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// encodeStreamEntries encodes entries as [[id, [field, value, ...]], ...].
+// Entries that no longer exist are encoded with a nil field list.
+func encodeStreamEntries(entries []cache.StreamEntry) []interface{} {
+	result := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		var fields interface{}
+		if entry.Fields != nil {
+			fields = entry.Fields
+		}
+		result[i] = []interface{}{entry.ID.String(), fields}
+	}
+	return result
+}
+
+// encodeStreamResults encodes read results as [[key, entries], ...].
+func encodeStreamResults(results []cache.StreamReadResult) []interface{} {
+	encoded := make([]interface{}, len(results))
+	for i, res := range results {
+		encoded[i] = []interface{}{res.Key, encodeStreamEntries(res.Entries)}
+	}
+	return encoded
+}
+
+// encodePendingSummary encodes an XPENDING summary as
+// [count, lowest, highest, [[consumer, count], ...]].
+func encodePendingSummary(summary cache.StreamPendingSummary) []interface{} {
+	if summary.Count == 0 {
+		return []interface{}{int64(0), nil, nil, []interface{}{}}
+	}
+
+	consumers := make([]interface{}, 0, len(summary.Consumers))
+	for name, count := range summary.Consumers {
+		consumers = append(consumers, []interface{}{name, count})
+	}
+	return []interface{}{summary.Count, summary.Lowest.String(), summary.Highest.String(), consumers}
+}
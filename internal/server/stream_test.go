@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// block sends cmds on conn without reading the replies, the first being a
+// blocking command, and waits until the server watches the connection.
+func block(t *testing.T, conn net.Conn, cmds ...*protocol.Command) {
+	t.Helper()
+
+	roundTrip(t, conn, command(protocol.CmdPing, "")) // The connection is served
+	before := runtime.NumGoroutine()
+	for _, cmd := range cmds {
+		if err := protocol.WriteCommand(conn, cmd); err != nil {
+			t.Fatalf("Failed to send command %d: %v", cmd.Type, err)
+		}
+	}
+	waitFor(t, "the connection to be watched", func() bool {
+		return runtime.NumGoroutine() > before
+	})
+}
+
+func TestBlockingReadEndsWhenClientLeaves(t *testing.T) {
+	_, addr := startServer(t)
+
+	for _, cmd := range []*protocol.Command{
+		command(protocol.CmdXRead, "events", "BLOCK", "0", "STREAMS", "events", "$"),
+		command(protocol.CmdXReadGroup, "events", "GROUP", "g", "c", "BLOCK", "0", "STREAMS", "events", ">"),
+	} {
+		conn := dial(t, addr)
+		if cmd.Type == protocol.CmdXReadGroup {
+			roundTrip(t, conn, command(protocol.CmdXGroup, "events", "CREATE", "g", "$", "MKSTREAM"))
+		}
+		block(t, conn, cmd)
+
+		// Once the client is gone, the connection and its watch end.
+		watching := runtime.NumGoroutine()
+		if err := conn.Close(); err != nil {
+			t.Fatalf("Failed to close: %v", err)
+		}
+		waitFor(t, "the blocked read to end", func() bool {
+			return runtime.NumGoroutine() <= watching-2
+		})
+	}
+}
+
+func TestBlockingReadKeepsPipelinedCommands(t *testing.T) {
+	_, addr := startServer(t)
+	conn := dial(t, addr)
+
+	block(t, conn,
+		command(protocol.CmdXRead, "events", "BLOCK", "0", "STREAMS", "events", "$"),
+		command(protocol.CmdPing, ""))
+	roundTrip(t, dial(t, addr), command(protocol.CmdXAdd, "events", "*", "type", "signup"))
+
+	resp, err := protocol.ReadResponse(conn)
+	if err != nil || resp.Type != protocol.RespNested {
+		t.Fatalf("Expected the blocked read to return the new entry, got %+v (%v)", resp, err)
+	}
+	resp, err = protocol.ReadResponse(conn)
+	if err != nil || resp.Data != "PONG" {
+		t.Errorf("Expected the command sent while blocked to run, got %+v (%v)", resp, err)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdXLen, "events")); resp.Data != int64(1) {
+		t.Errorf("Expected the connection to keep serving, got %+v", resp)
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// maxTrackedKeys bounds the keys tracked for invalidation. Once it is
+// reached, tracking a new key invalidates another one, so that clients
+// drop values the server no longer reports changes for.
+const maxTrackedKeys = 100000
+
+// trackedReads are the commands whose keys are tracked on connections with
+// CLIENT TRACKING ON: the reads whose replies clients keep in near caches.
+var trackedReads = map[protocol.CommandType]bool{
+	protocol.CmdGet: true,
+}
+
+// tracking records the keys read by tracking connections. Whenever one of
+// them changes, its name is published on protocol.InvalidateChannel.
+// Unlike Redis, invalidations go to every subscriber of the channel rather
+// than to the connections that read the key, and keys stay tracked after
+// an invalidation until they are evicted, since a client may read a key
+// again before receiving its invalidation.
+type tracking struct {
+	mu      sync.Mutex
+	keys    map[string]struct{}
+	publish func(key string) // Publishes an invalidation
+}
+
+// newTracking creates an empty tracking table that publishes invalidations
+// with publish.
+func newTracking(publish func(key string)) *tracking {
+	return &tracking{keys: make(map[string]struct{}), publish: publish}
+}
+
+// track records that key was read by a tracking connection. It must be
+// called before the read, so that a change made after it is published.
+func (t *tracking) track(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, exists := t.keys[key]; exists {
+		return
+	}
+	if len(t.keys) >= maxTrackedKeys {
+		for evicted := range t.keys {
+			delete(t.keys, evicted)
+			t.publish(evicted)
+			break
+		}
+	}
+	t.keys[key] = struct{}{}
+}
+
+// invalidate publishes an invalidation for key if it is tracked. It runs as
+// part of the cache change handler, while the cache lock is held.
+func (t *tracking) invalidate(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, exists := t.keys[key]; exists {
+		t.publish(key)
+	}
+}
+
+// keyChanged is the cache change handler: it records the change for
+// replicas and invalidates key for near caches. It runs while the cache
+// lock is held.
+func (s *Server) keyChanged(key string) {
+	s.replication.recordChange(key)
+	s.tracking.invalidate(key)
+}
+
+// handleClient processes CLIENT TRACKING ON|OFF, which switches tracking
+// on or off for the connection whose flag is tracking. Tracking connections
+// have the keys they GET tracked: subscribers of protocol.InvalidateChannel
+// receive their names when they change.
+func (s *Server) handleClient(tracking *bool, cmd *protocol.Command) *protocol.Response {
+	if len(cmd.Args) != 2 || !strings.EqualFold(cmd.Args[0], "TRACKING") {
+		return &protocol.Response{Type: protocol.RespError, Error: "CLIENT supports only TRACKING ON|OFF"}
+	}
+
+	switch strings.ToUpper(cmd.Args[1]) {
+	case "ON":
+		*tracking = true
+	case "OFF":
+		*tracking = false
+	default:
+		return &protocol.Response{Type: protocol.RespError, Error: "CLIENT TRACKING requires ON or OFF"}
+	}
+	return &protocol.Response{Type: protocol.RespOK}
+}
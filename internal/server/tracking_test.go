@@ -0,0 +1,95 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+func TestClientTrackingInvalidates(t *testing.T) {
+	_, addr := startServer(t)
+	invalidations := subscribe(t, addr, protocol.CmdSubscribe, protocol.InvalidateChannel)
+	reader, writer := dial(t, addr), dial(t, addr)
+
+	if resp := roundTrip(t, reader, command(protocol.CmdClient, "", "TRACKING", "ON")); resp.Type != protocol.RespOK {
+		t.Fatalf("CLIENT TRACKING ON failed: %+v", resp)
+	}
+	roundTrip(t, writer, command(protocol.CmdSet, "tracked", "v1"))
+	roundTrip(t, writer, command(protocol.CmdSet, "untracked", "v1"))
+	roundTrip(t, reader, command(protocol.CmdGet, "tracked"))
+
+	roundTrip(t, writer, command(protocol.CmdSet, "untracked", "v2"))
+	roundTrip(t, writer, command(protocol.CmdSet, "tracked", "v2"))
+	if push := readPush(t, invalidations); push[1] != protocol.InvalidateChannel || push[2] != "tracked" {
+		t.Errorf("Expected an invalidation of tracked, got %v", push)
+	}
+
+	// Keys stay tracked after an invalidation, and deletions invalidate too.
+	roundTrip(t, writer, command(protocol.CmdDel, "tracked"))
+	if push := readPush(t, invalidations); push[2] != "tracked" {
+		t.Errorf("Expected an invalidation of tracked, got %v", push)
+	}
+	expectNoPush(t, invalidations)
+}
+
+func TestClientTrackingOff(t *testing.T) {
+	_, addr := startServer(t)
+	invalidations := subscribe(t, addr, protocol.CmdSubscribe, protocol.InvalidateChannel)
+	conn := dial(t, addr)
+
+	roundTrip(t, conn, command(protocol.CmdClient, "", "TRACKING", "ON"))
+	roundTrip(t, conn, command(protocol.CmdClient, "", "TRACKING", "OFF"))
+	roundTrip(t, conn, command(protocol.CmdGet, "key"))
+	roundTrip(t, conn, command(protocol.CmdSet, "key", "value"))
+	expectNoPush(t, invalidations)
+
+	resp := roundTrip(t, conn, command(protocol.CmdClient, "", "TRACKING", "MAYBE"))
+	if resp.Type != protocol.RespError {
+		t.Errorf("Expected an error for an invalid CLIENT TRACKING mode, got %+v", resp)
+	}
+}
+
+func TestClientTrackingInvalidatesExpiry(t *testing.T) {
+	s, addr := startServer(t)
+	invalidations := subscribe(t, addr, protocol.CmdSubscribe, protocol.InvalidateChannel)
+	conn := dial(t, addr)
+
+	roundTrip(t, conn, command(protocol.CmdClient, "", "TRACKING", "ON"))
+	roundTrip(t, conn, &protocol.Command{Type: protocol.CmdSet, Key: "temp", Args: []string{"v"}, TTL: time.Second})
+	roundTrip(t, conn, command(protocol.CmdGet, "temp"))
+
+	time.Sleep(1100 * time.Millisecond)
+	s.cache.Persist("temp") // A write finding the key expired removes it
+	if push := readPush(t, invalidations); push[2] != "temp" {
+		t.Errorf("Expected an invalidation of the expired key, got %v", push)
+	}
+}
+
+func TestGetWithPTTL(t *testing.T) {
+	_, addr := startServer(t)
+	conn := dial(t, addr)
+
+	roundTrip(t, conn, &protocol.Command{Type: protocol.CmdSet, Key: "temp", Args: []string{"v"}, TTL: 10 * time.Second})
+	roundTrip(t, conn, command(protocol.CmdSet, "persistent", "v"))
+
+	resp := roundTrip(t, conn, command(protocol.CmdGet, "temp", "WITHPTTL"))
+	pair, _ := resp.Data.([]interface{})
+	if len(pair) != 2 || pair[0] != "v" {
+		t.Fatalf("Expected [v, ttl], got %+v", resp)
+	}
+	if pttl, _ := pair[1].(int64); pttl <= 9000 || pttl > 10000 {
+		t.Errorf("Expected a TTL just under 10s, got %dms", pttl)
+	}
+
+	resp = roundTrip(t, conn, command(protocol.CmdGet, "persistent", "WITHPTTL"))
+	if pair, _ := resp.Data.([]interface{}); len(pair) != 2 || pair[1] != int64(-1) {
+		t.Errorf("Expected [v, -1] for a key without TTL, got %+v", resp)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdGet, "missing", "WITHPTTL")); resp.Type != protocol.RespNil {
+		t.Errorf("Expected nil for a missing key, got %+v", resp)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdGet, "temp", "WITHTTL")); resp.Type != protocol.RespError {
+		t.Errorf("Expected a syntax error, got %+v", resp)
+	}
+}
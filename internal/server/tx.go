@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/cachemir/cachemir/pkg/cache"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// transaction is the MULTI/EXEC state of one connection.
+type transaction struct {
+	watched map[string]uint64   // Versions of watched keys when first watched
+	queued  []*protocol.Command // Commands queued since MULTI
+	active  bool                // Between MULTI and EXEC or DISCARD
+	failed  bool                // A command was rejected while queuing
+}
+
+// unqueueableCommands can't be queued in a transaction, by name. EXEC
+// holds the cache lock, which MIGRATE mustn't hold while it waits for
+// another server; REPLICAOF, CLUSTER and SCRIPT change the server's role,
+// membership or scripts, which a transaction can't make atomic with its
+// writes.
+var unqueueableCommands = map[protocol.CommandType]string{
+	protocol.CmdMigrate:   "MIGRATE",
+	protocol.CmdReplicaOf: "REPLICAOF",
+	protocol.CmdCluster:   "CLUSTER",
+	protocol.CmdScript:    "SCRIPT",
+}
+
+// reset ends the transaction and forgets watched keys.
+func (tx *transaction) reset() {
+	*tx = transaction{}
+}
+
+// isTransactionCommand reports whether a command controls transactions and
+// is therefore handled with connection context.
+func isTransactionCommand(cmdType protocol.CommandType) bool {
+	switch cmdType {
+	case protocol.CmdMulti, protocol.CmdExec, protocol.CmdDiscard, protocol.CmdWatch, protocol.CmdUnwatch:
+		return true
+	}
+	return false
+}
+
+// handleTransactionCommand processes MULTI, EXEC, DISCARD, WATCH and
+// UNWATCH for a connection.
+func (s *Server) handleTransactionCommand(tx *transaction, cmd *protocol.Command) *protocol.Response {
+	switch cmd.Type {
+	case protocol.CmdMulti:
+		if tx.active {
+			return &protocol.Response{Type: protocol.RespError, Error: "MULTI calls can not be nested"}
+		}
+		tx.active = true
+		return &protocol.Response{Type: protocol.RespOK}
+	case protocol.CmdExec:
+		return s.handleExec(tx)
+	case protocol.CmdDiscard:
+		if !tx.active {
+			return &protocol.Response{Type: protocol.RespError, Error: "DISCARD without MULTI"}
+		}
+		tx.reset()
+		return &protocol.Response{Type: protocol.RespOK}
+	case protocol.CmdWatch:
+		if tx.active {
+			return &protocol.Response{Type: protocol.RespError, Error: "WATCH inside MULTI is not allowed"}
+		}
+		if len(cmd.Args) == 0 {
+			return &protocol.Response{Type: protocol.RespError, Error: "wrong number of arguments for WATCH"}
+		}
+		if tx.watched == nil {
+			tx.watched = make(map[string]uint64, len(cmd.Args))
+		}
+		for _, key := range cmd.Args {
+			if _, exists := tx.watched[key]; !exists {
+				tx.watched[key] = s.cache.Version(key)
+			}
+		}
+		return &protocol.Response{Type: protocol.RespOK}
+	default: // protocol.CmdUnwatch
+		tx.watched = nil
+		return &protocol.Response{Type: protocol.RespOK}
+	}
+}
+
+// queueCommand adds a command to an active transaction. Commands that can't
+// run in a transaction are rejected and make the following EXEC fail.
+func (s *Server) queueCommand(tx *transaction, cmd *protocol.Command) *protocol.Response {
+	var rejection string
+	switch name, unqueueable := unqueueableCommands[cmd.Type]; {
+	case isSubscriptionCommand(cmd.Type):
+		rejection = "subscription commands are not allowed in MULTI"
+	case unqueueable:
+		rejection = name + " is not allowed in MULTI"
+	case s.getCommandHandler(cmd.Type) == nil:
+		rejection = fmt.Sprintf("unknown command: %d", cmd.Type)
+	}
+
+	if rejection != "" {
+		tx.failed = true
+		return &protocol.Response{Type: protocol.RespError, Error: rejection}
+	}
+
+	tx.queued = append(tx.queued, cmd)
+	return &protocol.Response{Type: protocol.RespString, Data: "QUEUED"}
+}
+
+// handleExec runs the queued commands while holding the cache lock, so no
+// other command interleaves with them. Returns one response per command, or
+// nil if a watched key changed. Blocking commands don't block in a
+// transaction. Errors of individual commands don't stop the others.
+func (s *Server) handleExec(tx *transaction) *protocol.Response {
+	if !tx.active {
+		return &protocol.Response{Type: protocol.RespError, Error: "EXEC without MULTI"}
+	}
+
+	queued, watched, failed := tx.queued, tx.watched, tx.failed
+	tx.reset()
+	if failed {
+		return &protocol.Response{Type: protocol.RespError, Error: "EXECABORT Transaction discarded because of previous errors"}
+	}
+
+	results := make([]*protocol.Response, 0, len(queued))
+	executed := s.atomically(watched, func(view *Server) {
+		for _, cmd := range queued {
+			results = append(results, view.executeCommand(cmd))
+		}
+	})
+	if !executed {
+		return &protocol.Response{Type: protocol.RespNil}
+	}
+	return &protocol.Response{Type: protocol.RespMulti, Data: results}
+}
+
+// atomically runs fn on a transaction view while holding the cache lock,
+// unless a watched key changed (see cache.Transaction), and reports whether
+// it ran. The writes fn makes are replicated as one unit. On a view, fn
+// runs as part of the enclosing unit.
+func (s *Server) atomically(watched map[string]uint64, fn func(view *Server)) bool {
+	return s.cache.Transaction(watched, func(c *cache.Cache) {
+		view := s.transactionView(c)
+		if s.transaction {
+			fn(view)
+			return
+		}
+		s.replication.begin()
+		fn(view)
+		s.replication.commit(c)
+	})
+}
+
+// transactionView returns a server whose handlers operate on a transaction
+// view of the cache.
+func (s *Server) transactionView(view *cache.Cache) *Server {
+	return &Server{
+		cache:         view,
+		pubsub:        s.pubsub,
+		port:          s.port,
+		transaction:   true,
+		scripts:       s.scripts,
+		scriptTimeout: s.scriptTimeout,
+		replication:   s.replication,
+		cluster:       s.cluster,
+		hotKeys:       s.hotKeys,
+		tracking:      s.tracking,
+	}
+}
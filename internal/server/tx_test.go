@@ -0,0 +1,202 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// multi sends MULTI and queues cmds on conn, failing the test if one isn't
+// queued.
+func multi(t *testing.T, conn net.Conn, cmds ...*protocol.Command) {
+	t.Helper()
+
+	if resp := roundTrip(t, conn, command(protocol.CmdMulti, "")); resp.Type != protocol.RespOK {
+		t.Fatalf("MULTI failed: %+v", resp)
+	}
+	for _, cmd := range cmds {
+		if resp := roundTrip(t, conn, cmd); resp.Data != "QUEUED" {
+			t.Fatalf("Expected command %d to be queued, got %+v", cmd.Type, resp)
+		}
+	}
+}
+
+// exec sends EXEC on conn and returns the replies of the queued commands,
+// or nil if the transaction didn't run.
+func exec(t *testing.T, conn net.Conn) []*protocol.Response {
+	t.Helper()
+
+	resp := roundTrip(t, conn, command(protocol.CmdExec, ""))
+	if resp.Type == protocol.RespNil {
+		return nil
+	}
+	results, ok := resp.Data.([]*protocol.Response)
+	if resp.Type != protocol.RespMulti || !ok {
+		t.Fatalf("Unexpected EXEC reply %+v", resp)
+	}
+	return results
+}
+
+func TestExecRunsQueuedCommands(t *testing.T) {
+	_, addr := startServer(t)
+	conn, other := dial(t, addr), dial(t, addr)
+
+	multi(t, conn,
+		command(protocol.CmdSet, "counter", "1"),
+		command(protocol.CmdIncr, "counter"),
+		command(protocol.CmdGet, "counter"))
+
+	// Nothing runs before EXEC.
+	if resp := roundTrip(t, other, command(protocol.CmdGet, "counter")); resp.Type != protocol.RespNil {
+		t.Errorf("Expected queued commands not to run before EXEC, got %+v", resp)
+	}
+
+	results := exec(t, conn)
+	if len(results) != 3 || results[0].Type != protocol.RespOK || results[1].Data != int64(2) || results[2].Data != "2" {
+		t.Errorf("Expected [OK 2 \"2\"], got %+v", results)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdExec, "")); resp.Error != "EXEC without MULTI" {
+		t.Errorf("Expected EXEC to end the transaction, got %+v", resp)
+	}
+}
+
+func TestExecAbortsWhenWatchedKeyChanges(t *testing.T) {
+	_, addr := startServer(t)
+	conn, other := dial(t, addr), dial(t, addr)
+
+	roundTrip(t, conn, command(protocol.CmdSet, "balance", "100"))
+	roundTrip(t, conn, command(protocol.CmdWatch, "", "balance"))
+	multi(t, conn, command(protocol.CmdSet, "balance", "50"))
+	roundTrip(t, other, command(protocol.CmdSet, "balance", "200"))
+
+	if results := exec(t, conn); results != nil {
+		t.Errorf("Expected a nil reply after the watched key changed, got %+v", results)
+	}
+	if resp := roundTrip(t, other, command(protocol.CmdGet, "balance")); resp.Data != "200" {
+		t.Errorf("Expected the concurrent write to be kept, got %+v", resp)
+	}
+
+	// EXEC forgets the watched keys, so the next transaction runs.
+	multi(t, conn, command(protocol.CmdSet, "balance", "50"))
+	if results := exec(t, conn); len(results) != 1 {
+		t.Errorf("Expected the transaction to run, got %+v", results)
+	}
+
+	// So does UNWATCH.
+	roundTrip(t, conn, command(protocol.CmdWatch, "", "balance"))
+	roundTrip(t, other, command(protocol.CmdIncr, "balance"))
+	roundTrip(t, conn, command(protocol.CmdUnwatch, ""))
+	multi(t, conn, command(protocol.CmdIncr, "balance"))
+	if results := exec(t, conn); len(results) != 1 || results[0].Data != int64(52) {
+		t.Errorf("Expected the transaction to run after UNWATCH, got %+v", results)
+	}
+
+	// Deleting a watched key counts as a change.
+	roundTrip(t, conn, command(protocol.CmdWatch, "", "balance"))
+	roundTrip(t, other, command(protocol.CmdDel, "balance"))
+	multi(t, conn, command(protocol.CmdSet, "balance", "1"))
+	if results := exec(t, conn); results != nil {
+		t.Errorf("Expected a nil reply after the watched key was deleted, got %+v", results)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	_, addr := startServer(t)
+	conn, other := dial(t, addr), dial(t, addr)
+
+	roundTrip(t, conn, command(protocol.CmdWatch, "", "key"))
+	multi(t, conn, command(protocol.CmdSet, "key", "discarded"))
+	if resp := roundTrip(t, conn, command(protocol.CmdDiscard, "")); resp.Type != protocol.RespOK {
+		t.Fatalf("DISCARD failed: %+v", resp)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdGet, "key")); resp.Type != protocol.RespNil {
+		t.Errorf("Expected discarded commands not to run, got %+v", resp)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdExec, "")); resp.Error != "EXEC without MULTI" {
+		t.Errorf("Expected DISCARD to end the transaction, got %+v", resp)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdDiscard, "")); resp.Error != "DISCARD without MULTI" {
+		t.Errorf("Expected DISCARD without MULTI to fail, got %+v", resp)
+	}
+
+	// DISCARD forgets the watched keys too.
+	roundTrip(t, other, command(protocol.CmdSet, "key", "changed"))
+	multi(t, conn, command(protocol.CmdSet, "key", "kept"))
+	if results := exec(t, conn); len(results) != 1 {
+		t.Errorf("Expected the transaction to run, got %+v", results)
+	}
+}
+
+func TestErrorsInsideMulti(t *testing.T) {
+	_, addr := startServer(t)
+	conn := dial(t, addr)
+
+	// Rejected commands abort the whole transaction.
+	unknown := protocol.CommandType(250) // Not a command
+	multi(t, conn, command(protocol.CmdSet, "a", "1"))
+	if resp := roundTrip(t, conn, command(unknown, "a")); resp.Type != protocol.RespError {
+		t.Errorf("Expected an unknown command to be rejected, got %+v", resp)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdSubscribe, "", "news")); resp.Type != protocol.RespError {
+		t.Errorf("Expected SUBSCRIBE to be rejected in MULTI, got %+v", resp)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdMulti, "")); resp.Error != "MULTI calls can not be nested" {
+		t.Errorf("Expected nested MULTI to fail, got %+v", resp)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdWatch, "", "a")); resp.Type != protocol.RespError {
+		t.Errorf("Expected WATCH inside MULTI to fail, got %+v", resp)
+	}
+	resp := roundTrip(t, conn, command(protocol.CmdExec, ""))
+	if !strings.HasPrefix(resp.Error, "EXECABORT") {
+		t.Fatalf("Expected EXECABORT, got %+v", resp)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdGet, "a")); resp.Type != protocol.RespNil {
+		t.Errorf("Expected no command of an aborted transaction to run, got %+v", resp)
+	}
+
+	// Errors while running don't stop the other commands.
+	multi(t, conn,
+		command(protocol.CmdSet, "a", "text"),
+		command(protocol.CmdIncr, "a"),
+		command(protocol.CmdSet, "b", "2"))
+	results := exec(t, conn)
+	if len(results) != 3 || results[0].Type != protocol.RespOK || results[1].Type != protocol.RespError ||
+		results[2].Type != protocol.RespOK {
+		t.Fatalf("Expected [OK error OK], got %+v", results)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdGet, "b")); resp.Data != "2" {
+		t.Errorf("Expected the command after the error to run, got %+v", resp)
+	}
+}
+
+func TestServerCommandsRejectedInMulti(t *testing.T) {
+	_, addr := startServer(t)
+	conn := dial(t, addr)
+
+	tests := []struct {
+		cmd  *protocol.Command
+		name string
+	}{
+		{command(protocol.CmdMigrate, "a", "127.0.0.1", "1", "0", "1000"), "MIGRATE"},
+		{command(protocol.CmdReplicaOf, "", "127.0.0.1", "1"), "REPLICAOF"},
+		{command(protocol.CmdCluster, "", "MEET", "127.0.0.1", "1"), "CLUSTER"},
+		{command(protocol.CmdScript, "", "FLUSH"), "SCRIPT"},
+	}
+	for _, tt := range tests {
+		multi(t, conn, command(protocol.CmdSet, "a", "1"))
+		if resp := roundTrip(t, conn, tt.cmd); resp.Error != tt.name+" is not allowed in MULTI" {
+			t.Errorf("Expected %s to be rejected in MULTI, got %+v", tt.name, resp)
+		}
+		if resp := roundTrip(t, conn, command(protocol.CmdExec, "")); !strings.HasPrefix(resp.Error, "EXECABORT") {
+			t.Errorf("Expected EXECABORT after %s, got %+v", tt.name, resp)
+		}
+	}
+
+	// REPLICAOF didn't run either.
+	resp := roundTrip(t, conn, command(protocol.CmdRole, ""))
+	if role, ok := resp.Data.([]interface{}); !ok || role[0] != "primary" {
+		t.Errorf("Expected the server to stay a primary, got %+v", resp)
+	}
+}
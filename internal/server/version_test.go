@@ -0,0 +1,75 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+func TestGetVWithPTTL(t *testing.T) {
+	_, addr := startServer(t)
+	conn := dial(t, addr)
+
+	if resp := roundTrip(t, conn, command(protocol.CmdGetV, "k", "WITHPTTL")); resp.Type != protocol.RespNil {
+		t.Errorf("Expected nil for a missing key, got %+v", resp)
+	}
+
+	set := command(protocol.CmdSet, "k", "v")
+	set.TTL = time.Minute
+	roundTrip(t, conn, set)
+	resp := roundTrip(t, conn, command(protocol.CmdGetV, "k", "WITHPTTL"))
+	items, _ := resp.Data.([]interface{})
+	if len(items) != 3 || items[0] != "v" {
+		t.Fatalf("Expected the value, its version and its TTL, got %+v", resp)
+	}
+	version, _ := items[1].(int64)
+	pttl, _ := items[2].(int64)
+	if version <= 0 || pttl <= 0 || pttl > time.Minute.Milliseconds() {
+		t.Errorf("Expected a version and a TTL of up to a minute, got %+v", items)
+	}
+
+	roundTrip(t, conn, command(protocol.CmdPersist, "k"))
+	resp = roundTrip(t, conn, command(protocol.CmdGetV, "k", "WITHPTTL"))
+	if items, _ := resp.Data.([]interface{}); len(items) != 3 || items[2] != int64(-1) {
+		t.Errorf("Expected -1 without a TTL, got %+v", resp)
+	}
+
+	if resp := roundTrip(t, conn, command(protocol.CmdGetV, "k", "WITHTTL")); resp.Type != protocol.RespError {
+		t.Errorf("Expected a syntax error, got %+v", resp)
+	}
+}
+
+func TestSetIfVersionWithVersion(t *testing.T) {
+	_, addr := startServer(t)
+	conn := dial(t, addr)
+
+	roundTrip(t, conn, command(protocol.CmdSet, "k", "old"))
+	items, _ := roundTrip(t, conn, command(protocol.CmdGetV, "k")).Data.([]interface{})
+	version, _ := items[1].(int64)
+	current, newer := strconv.FormatInt(version, 10), strconv.FormatInt(version+10, 10)
+
+	// The value gets the given version.
+	resp := roundTrip(t, conn, command(protocol.CmdSet, "k", "new", "IFVER", current, "VERSION", newer))
+	if resp.Data != version+10 {
+		t.Fatalf("Expected the given version, got %+v", resp)
+	}
+	items, _ = roundTrip(t, conn, command(protocol.CmdGetV, "k")).Data.([]interface{})
+	if items[0] != "new" || items[1] != version+10 {
+		t.Errorf("Expected new with version %d, got %+v", version+10, items)
+	}
+
+	// Only if the key still has the expected version, which the new one is above.
+	stale := command(protocol.CmdSet, "k", "x", "IFVER", current, "VERSION", newer+"0")
+	if resp := roundTrip(t, conn, stale); resp.Type != protocol.RespNil {
+		t.Errorf("Expected nil for a stale version, got %+v", resp)
+	}
+	lower := command(protocol.CmdSet, "k", "x", "IFVER", newer, "VERSION", current)
+	if resp := roundTrip(t, conn, lower); resp.Type != protocol.RespError {
+		t.Errorf("Expected an error for a version below the current one, got %+v", resp)
+	}
+	if resp := roundTrip(t, conn, command(protocol.CmdGet, "k")); resp.Data != "new" {
+		t.Errorf("Expected new to be kept, got %+v", resp)
+	}
+}
@@ -0,0 +1,424 @@
+package cache
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// Bitmap limits
+const (
+	maxBitOffset = 1<<32 - 1 // Largest offset accepted by SetBit (512MB string)
+	bitsPerByte  = 8
+)
+
+// BitOperation identifies the bitwise operation performed by BitOp.
+type BitOperation uint8
+
+const (
+	BitOpAnd BitOperation = iota // Bitwise AND of all source keys
+	BitOpOr                      // Bitwise OR of all source keys
+	BitOpXor                     // Bitwise XOR of all source keys
+	BitOpNot                     // Bitwise NOT of a single source key
+)
+
+// ParseBitOperation converts an operation name (AND, OR, XOR, NOT) into a BitOperation.
+// The name is matched case-insensitively.
+//
+// Example:
+//
+//	op, err := cache.ParseBitOperation("or")
+//	// op == cache.BitOpOr
+func ParseBitOperation(name string) (BitOperation, error) {
+	switch strings.ToUpper(name) {
+	case "AND":
+		return BitOpAnd, nil
+	case "OR":
+		return BitOpOr, nil
+	case "XOR":
+		return BitOpXor, nil
+	case "NOT":
+		return BitOpNot, nil
+	default:
+		return 0, fmt.Errorf("unknown bit operation: %s", name)
+	}
+}
+
+// String returns the command name of the operation.
+func (op BitOperation) String() string {
+	switch op {
+	case BitOpAnd:
+		return "AND"
+	case BitOpOr:
+		return "OR"
+	case BitOpXor:
+		return "XOR"
+	case BitOpNot:
+		return "NOT"
+	default:
+		return fmt.Sprintf("BitOperation(%d)", uint8(op))
+	}
+}
+
+// stringValue returns the string stored at key for use by bitmap operations.
+// A missing or expired key yields an empty string. Callers must hold c.mu.
+func (c *Cache) stringValue(key string) (string, error) {
+	value, exists := c.data[key]
+	if !exists || c.expiredKey(key, value) {
+		return "", nil
+	}
+
+	if value.Type != TypeString {
+		return "", fmt.Errorf("value is not a string")
+	}
+
+	str, ok := value.Data.(string)
+	if !ok {
+		return "", fmt.Errorf("value is not a string")
+	}
+	return str, nil
+}
+
+// SetBit sets or clears the bit at offset in the string value stored at key.
+// The string is grown with zero bytes as needed. Bit 0 is the most significant
+// bit of the first byte, matching Redis semantics. The key's TTL is preserved.
+//
+// Example:
+//
+//	// Mark user 42 as active today
+//	previous, err := cache.SetBit("dau:2024-01-01", 42, 1)
+//
+// Parameters:
+//   - key: The string key holding the bitmap
+//   - offset: Bit offset (0 to 2^32-1)
+//   - bit: The bit value to store (0 or 1)
+//
+// Returns:
+//   - The previous value of the bit
+//   - Error if the arguments are out of range or the key is not a string
+func (c *Cache) SetBit(key string, offset int64, bit int) (int, error) {
+	if offset < 0 || offset > maxBitOffset {
+		return 0, fmt.Errorf("bit offset is not an integer or out of range")
+	}
+	if bit != 0 && bit != 1 {
+		return 0, fmt.Errorf("bit is not an integer or out of range")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(key)
+	str, err := c.stringValue(key)
+	if err != nil {
+		return 0, err
+	}
+
+	byteIdx := int(offset / bitsPerByte)
+	mask := byte(1) << (7 - uint(offset%bitsPerByte))
+
+	buf := []byte(str)
+	if byteIdx >= len(buf) {
+		buf = append(buf, make([]byte, byteIdx-len(buf)+1)...)
+	}
+
+	previous := 0
+	if buf[byteIdx]&mask != 0 {
+		previous = 1
+	}
+
+	if bit == 1 {
+		buf[byteIdx] |= mask
+	} else {
+		buf[byteIdx] &^= mask
+	}
+
+	if value, exists := c.data[key]; exists {
+		value.Data = string(buf)
+	} else {
+		c.data[key] = &Value{Type: TypeString, Data: string(buf)}
+	}
+	c.notify(EventString, "setbit", key)
+	return previous, nil
+}
+
+// GetBit returns the bit value at offset in the string value stored at key.
+// Offsets beyond the end of the string, and missing keys, read as 0.
+//
+// Example:
+//
+//	active, err := cache.GetBit("dau:2024-01-01", 42)
+//
+// Parameters:
+//   - key: The string key holding the bitmap
+//   - offset: Bit offset (0 to 2^32-1)
+//
+// Returns:
+//   - The bit value (0 or 1)
+//   - Error if the offset is out of range or the key is not a string
+func (c *Cache) GetBit(key string, offset int64) (int, error) {
+	if offset < 0 || offset > maxBitOffset {
+		return 0, fmt.Errorf("bit offset is not an integer or out of range")
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	str, err := c.stringValue(key)
+	if err != nil {
+		return 0, err
+	}
+
+	byteIdx := offset / bitsPerByte
+	if byteIdx >= int64(len(str)) {
+		return 0, nil
+	}
+	if str[byteIdx]&(byte(1)<<(7-uint(offset%bitsPerByte))) != 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// BitCount counts the set bits in the string value stored at key.
+// With no bounds the whole string is counted. Otherwise exactly two bounds
+// (start and end) must be given as inclusive byte indexes; negative indexes
+// count from the end of the string, as in Redis.
+//
+// Example:
+//
+//	total, err := cache.BitCount("dau:2024-01-01")
+//	firstKB, err := cache.BitCount("dau:2024-01-01", 0, 1023)
+//
+// Parameters:
+//   - key: The string key holding the bitmap
+//   - bounds: Optional start and end byte indexes
+//
+// Returns:
+//   - Number of bits set to 1 in the range
+//   - Error if the bounds are malformed or the key is not a string
+func (c *Cache) BitCount(key string, bounds ...int64) (int64, error) {
+	if len(bounds) != 0 && len(bounds) != 2 {
+		return 0, fmt.Errorf("BITCOUNT requires both start and end")
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	str, err := c.stringValue(key)
+	if err != nil {
+		return 0, err
+	}
+
+	start, end := int64(0), int64(len(str))-1
+	if len(bounds) == 2 {
+		start, end = bounds[0], bounds[1]
+	}
+	start, end, ok := normalizeByteRange(start, end, int64(len(str)))
+	if !ok {
+		return 0, nil
+	}
+
+	var count int64
+	for i := start; i <= end; i++ {
+		count += int64(bits.OnesCount8(str[i]))
+	}
+	return count, nil
+}
+
+// BitPos returns the position of the first bit set to bit (0 or 1) in the
+// string value stored at key. Up to two optional bounds restrict the search to
+// an inclusive byte range; negative indexes count from the end of the string.
+//
+// When searching for a clear bit without an explicit end and every bit in the
+// range is set, the position just past the end of the string is returned,
+// since the string is conceptually padded with zeros. In all other cases a
+// failed search returns -1.
+//
+// Example:
+//
+//	firstActive, err := cache.BitPos("dau:2024-01-01", 1)
+//
+// Parameters:
+//   - key: The string key holding the bitmap
+//   - bit: The bit value to look for (0 or 1)
+//   - bounds: Optional start and end byte indexes
+//
+// Returns:
+//   - Bit position of the first match, or -1
+//   - Error if the arguments are malformed or the key is not a string
+func (c *Cache) BitPos(key string, bit int, bounds ...int64) (int64, error) {
+	if bit != 0 && bit != 1 {
+		return 0, fmt.Errorf("bit is not an integer or out of range")
+	}
+	if len(bounds) > 2 {
+		return 0, fmt.Errorf("BITPOS accepts at most start and end")
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	str, err := c.stringValue(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if str == "" {
+		if bit == 1 {
+			return -1, nil
+		}
+		return 0, nil
+	}
+
+	start, end := int64(0), int64(len(str))-1
+	if len(bounds) > 0 {
+		start = bounds[0]
+	}
+	if len(bounds) > 1 {
+		end = bounds[1]
+	}
+	start, end, ok := normalizeByteRange(start, end, int64(len(str)))
+	if !ok {
+		return -1, nil
+	}
+
+	var skip byte
+	if bit == 0 {
+		skip = 0xff
+	}
+	for i := start; i <= end; i++ {
+		if str[i] == skip {
+			continue
+		}
+		b := str[i]
+		if bit == 0 {
+			b = ^b
+		}
+		return i*bitsPerByte + int64(bits.LeadingZeros8(b)), nil
+	}
+
+	if bit == 0 && len(bounds) < 2 {
+		return (end + 1) * bitsPerByte, nil
+	}
+	return -1, nil
+}
+
+// BitOp performs a bitwise operation between the source keys and stores the
+// result in destKey. Missing source keys are treated as zero-filled strings and
+// shorter strings are zero-padded to the length of the longest one. BitOpNot
+// takes exactly one source key. If the result is empty, destKey is deleted.
+//
+// Example:
+//
+//	// Users active on both days
+//	length, err := cache.BitOp(cache.BitOpAnd, "dau:both", "dau:2024-01-01", "dau:2024-01-02")
+//
+// Parameters:
+//   - op: The bitwise operation to perform
+//   - destKey: The key that receives the result
+//   - srcKeys: One or more source keys
+//
+// Returns:
+//   - Length in bytes of the resulting string
+//   - Error if the arguments are invalid or a source key is not a string
+func (c *Cache) BitOp(op BitOperation, destKey string, srcKeys ...string) (int64, error) {
+	if len(srcKeys) == 0 {
+		return 0, fmt.Errorf("BITOP requires at least one source key")
+	}
+	if op == BitOpNot && len(srcKeys) != 1 {
+		return 0, fmt.Errorf("BITOP NOT must be called with a single source key")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sources := make([]string, len(srcKeys))
+	maxLen := 0
+	for i, key := range srcKeys {
+		str, err := c.stringValue(key)
+		if err != nil {
+			return 0, err
+		}
+		sources[i] = str
+		if len(str) > maxLen {
+			maxLen = len(str)
+		}
+	}
+
+	c.expireIfDue(destKey)
+	if maxLen == 0 {
+		if _, exists := c.data[destKey]; exists {
+			delete(c.data, destKey)
+			c.notify(EventGeneric, "del", destKey)
+		}
+		return 0, nil
+	}
+
+	result := ApplyBitOp(op, maxLen, sources)
+	c.data[destKey] = &Value{Type: TypeString, Data: string(result)}
+	c.notify(EventString, "set", destKey)
+	return int64(len(result)), nil
+}
+
+// ApplyBitOp computes a bitwise operation over in-memory strings, zero-padding
+// each to length bytes. It is shared by the cache and by clients that combine
+// bitmaps fetched from several nodes.
+func ApplyBitOp(op BitOperation, length int, sources []string) []byte {
+	result := make([]byte, length)
+	if len(sources) == 0 {
+		return result
+	}
+	copy(result, sources[0])
+
+	if op == BitOpNot {
+		for i := range result {
+			result[i] = ^result[i]
+		}
+		return result
+	}
+
+	for _, src := range sources[1:] {
+		for i := range result {
+			var b byte
+			if i < len(src) {
+				b = src[i]
+			}
+			switch op {
+			case BitOpAnd:
+				result[i] &= b
+			case BitOpOr:
+				result[i] |= b
+			case BitOpXor:
+				result[i] ^= b
+			case BitOpNot:
+			}
+		}
+	}
+	return result
+}
+
+// normalizeByteRange converts Redis-style inclusive start/end indexes, which
+// may be negative, into valid indexes for a string of the given length.
+// Returns false if the resulting range is empty.
+func normalizeByteRange(start, end, length int64) (normStart, normEnd int64, ok bool) {
+	if length == 0 {
+		return 0, 0, false
+	}
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= length {
+		end = length - 1
+	}
+	if start > end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
@@ -9,6 +9,11 @@
 //   - Hashes: Field-value mappings (like Redis hashes)
 //   - Lists: Ordered collections with head/tail operations
 //   - Sets: Unordered collections of unique members
+//   - Bitmaps: Bit-level operations on string values
+//   - HyperLogLogs: Approximate distinct counting with fixed memory
+//   - Streams: Append-only logs with consumer groups
+//   - Geo sets: Named coordinates indexed by geohash
+//   - JSON documents: Structured values with JSONPath updates
 //
 // Example usage:
 //
@@ -32,7 +37,8 @@
 //	members := cache.SMembers("tags")
 //
 // All operations are thread-safe and can be called concurrently from multiple goroutines.
-// The cache automatically handles expiration cleanup in the background.
+// The cache automatically handles expiration cleanup in the background. Mutations and
+// expirations can be observed as events through SetEventHandler.
 package cache
 
 import (
@@ -47,10 +53,14 @@ import (
 type ValueType uint8
 
 const (
-	TypeString ValueType = iota // String value ([]byte)
-	TypeHash                    // Hash value (map[string]string)
-	TypeList                    // List value ([]string)
-	TypeSet                     // Set value (map[string]bool)
+	TypeString      ValueType = iota // String value ([]byte)
+	TypeHash                         // Hash value (map[string]string)
+	TypeList                         // List value ([]string)
+	TypeSet                          // Set value (map[string]bool)
+	TypeHyperLogLog                  // HyperLogLog value (*HyperLogLog)
+	TypeStream                       // Stream value (*Stream)
+	TypeGeo                          // Geo set value (*GeoSet)
+	TypeJSON                         // JSON document value (*JSONDocument)
 )
 
 // Value represents a single cache entry with its data, type, and expiration.
@@ -59,9 +69,14 @@ const (
 //   - TypeHash: map[string]string
 //   - TypeList: []string
 //   - TypeSet: map[string]bool
+//   - TypeHyperLogLog: *HyperLogLog
+//   - TypeStream: *Stream
+//   - TypeGeo: *GeoSet
+//   - TypeJSON: *JSONDocument
 type Value struct {
 	Data      interface{} // The actual data (type depends on Type field)
 	ExpiresAt time.Time   // When this value expires (zero means no expiration)
+	Version   uint64      // Version of the last modification, its time in microseconds
 	Type      ValueType   // The type of data stored
 }
 
@@ -79,8 +94,28 @@ type Value struct {
 //		fmt.Printf("Session data: %s\n", value)
 //	}
 type Cache struct {
-	data map[string]*Value // The actual cache storage
-	mu   sync.RWMutex      // Protects the data map
+	*store          // Keys and state, shared with transaction views
+	mu     rwLocker // Protects the store; a no-op in transaction views
+}
+
+// store holds the state of a Cache.
+type store struct {
+	data          map[string]*Value                     // The actual cache storage
+	streamWaiters map[string]map[*streamWaiter]struct{} // Blocked stream readers per key
+	eventHandler  EventHandler                          // Receives keyspace events
+	changeHandler func(key string)                      // Receives every modified key
+	expiryHandler func(key string)                      // Receives every expired key
+	expiredKeys   chan string                           // Expired keys found by readers
+	version       uint64                                // Last version assigned to a modification
+	eventClasses  EventClass                            // Classes passed to eventHandler
+}
+
+// rwLocker is the locking interface of sync.RWMutex.
+type rwLocker interface {
+	Lock()
+	Unlock()
+	RLock()
+	RUnlock()
 }
 
 // New creates a new Cache instance and starts the background expiration cleanup.
@@ -95,7 +130,11 @@ type Cache struct {
 //   - A new Cache instance ready for use
 func New() *Cache {
 	c := &Cache{
-		data: make(map[string]*Value),
+		store: &store{
+			data:        make(map[string]*Value),
+			expiredKeys: make(chan string, expiredQueueSize),
+		},
+		mu: &sync.RWMutex{},
 	}
 	go c.cleanupExpired()
 	return c
@@ -103,20 +142,29 @@ func New() *Cache {
 
 // cleanupExpired runs in a background goroutine to periodically remove expired keys.
 // It runs every minute and removes all keys that have passed their expiration time.
-// This prevents memory leaks from expired but unaccessed keys.
+// This prevents memory leaks from expired but unaccessed keys. In between, it
+// removes the expired keys found by readers. Each removal emits an "expired" event.
 func (c *Cache) cleanupExpired() {
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		c.mu.Lock()
-		now := time.Now()
-		for key, value := range c.data {
-			if !value.ExpiresAt.IsZero() && now.After(value.ExpiresAt) {
-				delete(c.data, key)
+	for {
+		select {
+		case key := <-c.expiredKeys:
+			c.mu.Lock()
+			c.expireIfDue(key)
+			c.mu.Unlock()
+		case <-ticker.C:
+			c.mu.Lock()
+			now := time.Now()
+			for key, value := range c.data {
+				if !value.ExpiresAt.IsZero() && now.After(value.ExpiresAt) {
+					delete(c.data, key)
+					c.notify(EventExpired, "expired", key)
+				}
 			}
+			c.mu.Unlock()
 		}
-		c.mu.Unlock()
 	}
 }
 
@@ -148,7 +196,7 @@ func (c *Cache) Get(key string) (string, bool) {
 	defer c.mu.RUnlock()
 
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) {
+	if !exists || c.expiredKey(key, value) {
 		return "", false
 	}
 
@@ -162,6 +210,28 @@ func (c *Cache) Get(key string) (string, bool) {
 	return "", false
 }
 
+// GetWithTTL retrieves a string value together with the time until it
+// expires, or 0 if it doesn't. Like Get, it reports false for missing keys
+// and values of other types.
+//
+// Example:
+//
+//	value, ttl, exists := cache.GetWithTTL("session:abc")
+func (c *Cache) GetWithTTL(key string) (string, time.Duration, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	value, exists := c.data[key]
+	if !exists || c.expiredKey(key, value) || value.Type != TypeString {
+		return "", 0, false
+	}
+	str, ok := value.Data.(string)
+	if !ok {
+		return "", 0, false
+	}
+	return str, remainingTTL(value), true
+}
+
 // Set stores a string value in the cache with an optional TTL.
 // If TTL is 0, the key will not expire. If TTL is positive, the key
 // will expire after the specified duration.
@@ -182,6 +252,12 @@ func (c *Cache) Set(key, val string, ttl time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.set(key, val, ttl)
+}
+
+// set stores a string value. Callers must hold c.mu for writing.
+func (c *Cache) set(key, val string, ttl time.Duration) {
+	c.expireIfDue(key)
 	value := &Value{
 		Type: TypeString,
 		Data: val,
@@ -192,6 +268,10 @@ func (c *Cache) Set(key, val string, ttl time.Duration) {
 	}
 
 	c.data[key] = value
+	c.notify(EventString, "set", key)
+	if ttl > 0 {
+		c.notify(EventGeneric, "expire", key)
+	}
 }
 
 // Del removes a key from the cache.
@@ -213,9 +293,11 @@ func (c *Cache) Del(key string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.expireIfDue(key)
 	_, exists := c.data[key]
 	if exists {
 		delete(c.data, key)
+		c.notify(EventGeneric, "del", key)
 		return true
 	}
 	return false
@@ -241,7 +323,7 @@ func (c *Cache) Exists(key string) bool {
 	defer c.mu.RUnlock()
 
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) {
+	if !exists || c.expiredKey(key, value) {
 		return false
 	}
 	return true
@@ -316,13 +398,15 @@ func (c *Cache) IncrBy(key string, delta int64) (int64, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.expireIfDue(key)
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) {
+	if !exists {
 		newValue := &Value{
 			Type: TypeString,
 			Data: strconv.FormatInt(delta, 10),
 		}
 		c.data[key] = newValue
+		c.notify(EventString, "incrby", key)
 		return delta, nil
 	}
 
@@ -341,6 +425,7 @@ func (c *Cache) IncrBy(key string, delta int64) (int64, error) {
 
 	newVal := current + delta
 	value.Data = strconv.FormatInt(newVal, 10)
+	c.notify(EventString, "incrby", key)
 	return newVal, nil
 }
 
@@ -364,12 +449,14 @@ func (c *Cache) Expire(key string, ttl time.Duration) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.expireIfDue(key)
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) {
+	if !exists {
 		return false
 	}
 
 	value.ExpiresAt = time.Now().Add(ttl)
+	c.notify(EventGeneric, "expire", key)
 	return true
 }
 
@@ -397,7 +484,7 @@ func (c *Cache) TTL(key string) time.Duration {
 	defer c.mu.RUnlock()
 
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) {
+	if !exists || c.expiredKey(key, value) {
 		return -2 * time.Second
 	}
 
@@ -432,12 +519,14 @@ func (c *Cache) Persist(key string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.expireIfDue(key)
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) {
+	if !exists {
 		return false
 	}
 
 	value.ExpiresAt = time.Time{}
+	c.notify(EventGeneric, "persist", key)
 	return true
 }
 
@@ -464,7 +553,7 @@ func (c *Cache) HGet(key, field string) (string, bool) {
 	defer c.mu.RUnlock()
 
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) || value.Type != TypeHash {
+	if !exists || c.expiredKey(key, value) || value.Type != TypeHash {
 		return "", false
 	}
 
@@ -493,8 +582,9 @@ func (c *Cache) HSet(key, field, val string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.expireIfDue(key)
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) {
+	if !exists {
 		value = &Value{
 			Type: TypeHash,
 			Data: make(map[string]string),
@@ -509,6 +599,7 @@ func (c *Cache) HSet(key, field, val string) {
 		return
 	}
 	hash[field] = val
+	c.notify(EventHash, "hset", key)
 }
 
 // HDel deletes a field from a hash.
@@ -531,8 +622,9 @@ func (c *Cache) HDel(key, field string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.expireIfDue(key)
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) || value.Type != TypeHash {
+	if !exists || value.Type != TypeHash {
 		return false
 	}
 
@@ -543,6 +635,7 @@ func (c *Cache) HDel(key, field string) bool {
 	_, exists = hash[field]
 	if exists {
 		delete(hash, field)
+		c.notify(EventHash, "hdel", key)
 		return true
 	}
 	return false
@@ -561,7 +654,7 @@ func (c *Cache) HExists(key, field string) bool {
 	defer c.mu.RUnlock()
 
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) || value.Type != TypeHash {
+	if !exists || c.expiredKey(key, value) || value.Type != TypeHash {
 		return false
 	}
 
@@ -596,7 +689,7 @@ func (c *Cache) HGetAll(key string) map[string]string {
 	defer c.mu.RUnlock()
 
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) || value.Type != TypeHash {
+	if !exists || c.expiredKey(key, value) || value.Type != TypeHash {
 		return make(map[string]string)
 	}
 
@@ -632,8 +725,9 @@ func (c *Cache) LPush(key string, values ...string) int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.expireIfDue(key)
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) {
+	if !exists {
 		value = &Value{
 			Type: TypeList,
 			Data: make([]string, 0),
@@ -651,6 +745,7 @@ func (c *Cache) LPush(key string, values ...string) int {
 		list = append([]string{values[i]}, list...)
 	}
 	value.Data = list
+	c.notify(EventList, "lpush", key)
 	return len(list)
 }
 
@@ -674,8 +769,9 @@ func (c *Cache) RPush(key string, values ...string) int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.expireIfDue(key)
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) {
+	if !exists {
 		value = &Value{
 			Type: TypeList,
 			Data: make([]string, 0),
@@ -691,6 +787,7 @@ func (c *Cache) RPush(key string, values ...string) int {
 	}
 	list = append(list, values...)
 	value.Data = list
+	c.notify(EventList, "rpush", key)
 	return len(list)
 }
 
@@ -715,8 +812,9 @@ func (c *Cache) LPop(key string) (string, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.expireIfDue(key)
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) || value.Type != TypeList {
+	if !exists || value.Type != TypeList {
 		return "", false
 	}
 
@@ -730,6 +828,7 @@ func (c *Cache) LPop(key string) (string, bool) {
 
 	result := list[0]
 	value.Data = list[1:]
+	c.notify(EventList, "lpop", key)
 	return result, true
 }
 
@@ -754,8 +853,9 @@ func (c *Cache) RPop(key string) (string, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.expireIfDue(key)
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) || value.Type != TypeList {
+	if !exists || value.Type != TypeList {
 		return "", false
 	}
 
@@ -769,6 +869,7 @@ func (c *Cache) RPop(key string) (string, bool) {
 
 	result := list[len(list)-1]
 	value.Data = list[:len(list)-1]
+	c.notify(EventList, "rpop", key)
 	return result, true
 }
 
@@ -791,7 +892,7 @@ func (c *Cache) LLen(key string) int {
 	defer c.mu.RUnlock()
 
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) || value.Type != TypeList {
+	if !exists || c.expiredKey(key, value) || value.Type != TypeList {
 		return 0
 	}
 
@@ -821,8 +922,9 @@ func (c *Cache) SAdd(key string, members ...string) int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.expireIfDue(key)
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) {
+	if !exists {
 		value = &Value{
 			Type: TypeSet,
 			Data: make(map[string]bool),
@@ -843,6 +945,9 @@ func (c *Cache) SAdd(key string, members ...string) int {
 			added++
 		}
 	}
+	if added > 0 {
+		c.notify(EventSet, "sadd", key)
+	}
 	return added
 }
 
@@ -865,8 +970,9 @@ func (c *Cache) SRem(key string, members ...string) int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.expireIfDue(key)
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) || value.Type != TypeSet {
+	if !exists || value.Type != TypeSet {
 		return 0
 	}
 
@@ -881,6 +987,9 @@ func (c *Cache) SRem(key string, members ...string) int {
 			removed++
 		}
 	}
+	if removed > 0 {
+		c.notify(EventSet, "srem", key)
+	}
 	return removed
 }
 
@@ -904,7 +1013,7 @@ func (c *Cache) SMembers(key string) []string {
 	defer c.mu.RUnlock()
 
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) || value.Type != TypeSet {
+	if !exists || c.expiredKey(key, value) || value.Type != TypeSet {
 		return []string{}
 	}
 
@@ -940,7 +1049,7 @@ func (c *Cache) SIsMember(key, member string) bool {
 	defer c.mu.RUnlock()
 
 	value, exists := c.data[key]
-	if !exists || c.isExpired(value) || value.Type != TypeSet {
+	if !exists || c.expiredKey(key, value) || value.Type != TypeSet {
 		return false
 	}
 
@@ -992,6 +1101,14 @@ func (c *Cache) Stats() map[string]interface{} {
 			typeCount["list"]++
 		case TypeSet:
 			typeCount["set"]++
+		case TypeHyperLogLog:
+			typeCount["hyperloglog"]++
+		case TypeStream:
+			typeCount["stream"]++
+		case TypeGeo:
+			typeCount["geo"]++
+		case TypeJSON:
+			typeCount["json"]++
 		}
 
 		if !value.ExpiresAt.IsZero() && now.After(value.ExpiresAt) {
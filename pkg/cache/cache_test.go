@@ -1,6 +1,11 @@
 package cache
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -147,3 +152,789 @@ func TestCacheSetOperations(t *testing.T) {
 		t.Errorf("Expected 2 removed, got %d", removed)
 	}
 }
+
+func TestCacheBitmapOperations(t *testing.T) {
+	c := New()
+
+	if prev, err := c.SetBit("bits", 7, 1); err != nil || prev != 0 {
+		t.Errorf("Expected previous bit 0, got %d (error: %v)", prev, err)
+	}
+	if prev, err := c.SetBit("bits", 7, 0); err != nil || prev != 1 {
+		t.Errorf("Expected previous bit 1, got %d (error: %v)", prev, err)
+	}
+
+	c.Set("bits", "\xff\xf0\x00", 0)
+
+	if bit, err := c.GetBit("bits", 11); err != nil || bit != 1 {
+		t.Errorf("Expected bit 1 at offset 11, got %d (error: %v)", bit, err)
+	}
+	if bit, err := c.GetBit("bits", 1000); err != nil || bit != 0 {
+		t.Errorf("Expected bit 0 beyond end, got %d (error: %v)", bit, err)
+	}
+
+	if count, err := c.BitCount("bits"); err != nil || count != 12 {
+		t.Errorf("Expected 12 set bits, got %d (error: %v)", count, err)
+	}
+	if count, err := c.BitCount("bits", -2, -1); err != nil || count != 4 {
+		t.Errorf("Expected 4 set bits in last two bytes, got %d (error: %v)", count, err)
+	}
+
+	if pos, err := c.BitPos("bits", 0); err != nil || pos != 12 {
+		t.Errorf("Expected first clear bit at 12, got %d (error: %v)", pos, err)
+	}
+	if pos, err := c.BitPos("bits", 1, 2); err != nil || pos != -1 {
+		t.Errorf("Expected no set bit in last byte, got %d (error: %v)", pos, err)
+	}
+
+	c.Set("ones", "\xff", 0)
+	if pos, err := c.BitPos("ones", 0); err != nil || pos != 8 {
+		t.Errorf("Expected clear bit past the end at 8, got %d (error: %v)", pos, err)
+	}
+
+	c.HSet("hash", "field", "value")
+	if _, err := c.SetBit("hash", 0, 1); err == nil {
+		t.Error("SetBit on a hash should fail")
+	}
+}
+
+func TestCacheBitOp(t *testing.T) {
+	c := New()
+
+	c.Set("a", "\x0f\xff", 0)
+	c.Set("b", "\xf0", 0)
+
+	if length, err := c.BitOp(BitOpOr, "or", "a", "b"); err != nil || length != 2 {
+		t.Errorf("Expected length 2, got %d (error: %v)", length, err)
+	}
+	if value, _ := c.Get("or"); value != "\xff\xff" {
+		t.Errorf("Unexpected OR result: %q", value)
+	}
+
+	if _, err := c.BitOp(BitOpAnd, "and", "a", "b", "missing"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if value, _ := c.Get("and"); value != "\x00\x00" {
+		t.Errorf("Unexpected AND result: %q", value)
+	}
+
+	if _, err := c.BitOp(BitOpNot, "not", "b"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if value, _ := c.Get("not"); value != "\x0f" {
+		t.Errorf("Unexpected NOT result: %q", value)
+	}
+
+	if _, err := c.BitOp(BitOpNot, "not", "a", "b"); err == nil {
+		t.Error("NOT with multiple sources should fail")
+	}
+
+	if length, err := c.BitOp(BitOpXor, "xor", "missing"); err != nil || length != 0 || c.Exists("xor") {
+		t.Errorf("Empty result should delete destination (length %d, error %v)", length, err)
+	}
+}
+
+func TestCacheHyperLogLog(t *testing.T) {
+	c := New()
+
+	changed, err := c.PFAdd("hll", "a", "b", "c")
+	if err != nil || !changed {
+		t.Errorf("Expected PFAdd to report a change (error: %v)", err)
+	}
+	if changed, _ = c.PFAdd("hll", "a"); changed {
+		t.Error("Re-adding an element should not change the HyperLogLog")
+	}
+	if count, err := c.PFCount("hll"); err != nil || count != 3 {
+		t.Errorf("Expected count 3, got %d (error: %v)", count, err)
+	}
+
+	for i := 0; i < 20000; i++ {
+		c.PFAdd("big", fmt.Sprintf("element:%d", i))
+	}
+	count, err := c.PFCount("big")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count < 19000 || count > 21000 {
+		t.Errorf("Estimate out of bounds for 20000 elements: %d", count)
+	}
+
+	for i := 10000; i < 30000; i++ {
+		c.PFAdd("other", fmt.Sprintf("element:%d", i))
+	}
+	if err := c.PFMerge("union", "big", "other"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	union, _ := c.PFCount("union")
+	if union < 28500 || union > 31500 {
+		t.Errorf("Union estimate out of bounds for 30000 elements: %d", union)
+	}
+	if multi, _ := c.PFCount("big", "other"); multi != union {
+		t.Errorf("Multi-key count %d should match merged count %d", multi, union)
+	}
+
+	c.Set("str", "value", 0)
+	if _, err := c.PFAdd("str", "a"); err == nil {
+		t.Error("PFAdd on a string should fail")
+	}
+}
+
+func TestHyperLogLogSerialization(t *testing.T) {
+	for _, n := range []int{10, 5000} {
+		hll := NewHyperLogLog()
+		for i := 0; i < n; i++ {
+			hll.Add(fmt.Sprintf("item-%d", i))
+		}
+		if dense := n > hllSparseMaxSize; hll.IsDense() != dense {
+			t.Errorf("Expected dense=%t for %d elements", dense, n)
+		}
+
+		data, err := hll.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+
+		decoded := NewHyperLogLog()
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if decoded.Count() != hll.Count() {
+			t.Errorf("Decoded count %d differs from original %d", decoded.Count(), hll.Count())
+		}
+	}
+
+	if err := NewHyperLogLog().UnmarshalBinary([]byte{0xff}); err == nil {
+		t.Error("Unknown encoding should fail to unmarshal")
+	}
+}
+
+func TestCacheStream(t *testing.T) {
+	c := New()
+
+	id1, err := c.XAdd("s", "1-1", []string{"a", "1"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := c.XAdd("s", "1-1", []string{"a", "2"}, nil); err == nil {
+		t.Error("XAdd with a non-increasing ID should fail")
+	}
+	id2, _ := c.XAdd("s", "*", []string{"b", "2"}, nil)
+	if !id1.Less(id2) {
+		t.Errorf("Auto-generated ID %s should be greater than %s", id2, id1)
+	}
+	c.XAdd("s", "*", []string{"c", "3"}, nil)
+
+	if length, _ := c.XLen("s"); length != 3 {
+		t.Errorf("Expected length 3, got %d", length)
+	}
+
+	entries, err := c.XRange("s", "-", "+", 2)
+	if err != nil || len(entries) != 2 || entries[0].ID != id1 {
+		t.Errorf("Unexpected XRange result %v (error: %v)", entries, err)
+	}
+	entries, _ = c.XRange("s", "("+id1.String(), "+", 0)
+	if len(entries) != 2 || entries[0].ID != id2 {
+		t.Errorf("Exclusive start should skip %s, got %v", id1, entries)
+	}
+	entries, _ = c.XRevRange("s", "+", "-", 1)
+	if len(entries) != 1 || entries[0].Fields[0] != "c" {
+		t.Errorf("Expected newest entry first, got %v", entries)
+	}
+
+	results, _ := c.XRead([]string{"s"}, []string{id2.String()}, 0)
+	if len(results) != 1 || len(results[0].Entries) != 1 {
+		t.Errorf("Expected one entry after %s, got %v", id2, results)
+	}
+
+	removed, _ := c.XTrim("s", StreamTrim{Strategy: TrimMaxLen, MaxLen: 1})
+	if removed != 2 {
+		t.Errorf("Expected 2 entries trimmed, got %d", removed)
+	}
+
+	c.Set("str", "value", 0)
+	if _, err := c.XAdd("str", "*", []string{"a", "1"}, nil); err == nil {
+		t.Error("XAdd on a string should fail")
+	}
+}
+
+func TestCacheStreamConsumerGroups(t *testing.T) {
+	c := New()
+
+	if err := c.XGroupCreate("jobs", "workers", "$", false); err == nil {
+		t.Error("Creating a group on a missing stream without MKSTREAM should fail")
+	}
+	if err := c.XGroupCreate("jobs", "workers", "$", true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	first, _ := c.XAdd("jobs", "*", []string{"job", "1"}, nil)
+	second, _ := c.XAdd("jobs", "*", []string{"job", "2"}, nil)
+
+	results, err := c.XReadGroup("workers", "alice", []string{"jobs"}, []string{">"}, 1, false)
+	if err != nil || len(results) != 1 || results[0].Entries[0].ID != first {
+		t.Fatalf("Unexpected XReadGroup result %v (error: %v)", results, err)
+	}
+	results, _ = c.XReadGroup("workers", "bob", []string{"jobs"}, []string{">"}, 0, false)
+	if len(results) != 1 || results[0].Entries[0].ID != second {
+		t.Fatalf("Expected bob to receive %s, got %v", second, results)
+	}
+
+	summary, _ := c.XPendingSummary("jobs", "workers")
+	if summary.Count != 2 || summary.Consumers["alice"] != 1 || summary.Consumers["bob"] != 1 {
+		t.Errorf("Unexpected pending summary %+v", summary)
+	}
+
+	claimed, err := c.XClaim("jobs", "workers", "bob", 0, false, first)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("Unexpected XClaim result %v (error: %v)", claimed, err)
+	}
+	pending, _ := c.XPending("jobs", "workers", "-", "+", 10, "bob")
+	if len(pending) != 2 || pending[0].Deliveries != 2 {
+		t.Errorf("Expected bob to own both entries, got %+v", pending)
+	}
+
+	if acked, _ := c.XAck("jobs", "workers", first, second); acked != 2 {
+		t.Errorf("Expected 2 acknowledgements, got %d", acked)
+	}
+	if summary, _ = c.XPendingSummary("jobs", "workers"); summary.Count != 0 {
+		t.Errorf("Expected no pending entries, got %d", summary.Count)
+	}
+
+	if existed, _ := c.XGroupDestroy("jobs", "workers"); !existed {
+		t.Error("Expected group to be destroyed")
+	}
+}
+
+func TestCacheStreamNotify(t *testing.T) {
+	c := New()
+
+	ready, cancel := c.NotifyStreams("events")
+	defer cancel()
+
+	select {
+	case <-ready:
+		t.Fatal("Waiter should not fire before an entry is added")
+	default:
+	}
+
+	c.XAdd("events", "*", []string{"type", "click"}, nil)
+
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("Waiter was not notified of the new entry")
+	}
+}
+
+func TestCacheGeo(t *testing.T) {
+	c := New()
+
+	added, err := c.GeoAdd("sicily", nil,
+		GeoLocation{Member: "Palermo", Longitude: 13.361389, Latitude: 38.115556},
+		GeoLocation{Member: "Catania", Longitude: 15.087269, Latitude: 37.502669},
+	)
+	if err != nil || added != 2 {
+		t.Fatalf("Expected 2 members added, got %d (error: %v)", added, err)
+	}
+	if _, err := c.GeoAdd("sicily", nil, GeoLocation{Member: "bad", Longitude: 0, Latitude: 89}); err == nil {
+		t.Error("Latitude outside the indexable range should fail")
+	}
+
+	dist, ok, _ := c.GeoDist("sicily", "Palermo", "Catania")
+	if !ok || dist < 166274 || dist > 166275 {
+		t.Errorf("Expected distance of about 166274m, got %f", dist)
+	}
+	if _, ok, _ = c.GeoDist("sicily", "Palermo", "Rome"); ok {
+		t.Error("Distance to a missing member should not be found")
+	}
+
+	positions, _ := c.GeoPos("sicily", "Palermo", "Rome")
+	if positions[0] == nil || positions[1] != nil {
+		t.Fatalf("Unexpected positions %v", positions)
+	}
+	if d := GeoDistance(13.361389, 38.115556, positions[0].Longitude, positions[0].Latitude); d > 1 {
+		t.Errorf("Decoded position is %fm away from the original", d)
+	}
+
+	results, err := c.GeoSearch("sicily", &GeoSearchQuery{Longitude: 15, Latitude: 37, Radius: 200000, Sort: GeoSortAsc})
+	if err != nil || len(results) != 2 || results[0].Member != "Catania" {
+		t.Errorf("Unexpected radius search result %v (error: %v)", results, err)
+	}
+	results, _ = c.GeoSearch("sicily", &GeoSearchQuery{FromMember: "Palermo", Width: 400000, Height: 400000, Count: 1})
+	if len(results) != 1 || results[0].Member != "Palermo" || results[0].Distance != 0 {
+		t.Errorf("Unexpected box search result %v", results)
+	}
+}
+
+func TestCacheGeoSearchMatchesScan(t *testing.T) {
+	c := New()
+
+	var locations []GeoLocation
+	for i := 0; i < 2000; i++ {
+		locations = append(locations, GeoLocation{
+			Member:    fmt.Sprintf("p%d", i),
+			Longitude: -10 + float64(i%50)*0.4 + float64(i%7)*0.01,
+			Latitude:  40 + float64(i/50)*0.25 + float64(i%11)*0.01,
+		})
+	}
+	c.GeoAdd("points", nil, locations...)
+
+	for _, radius := range []float64{1000, 25000, 300000} {
+		query := &GeoSearchQuery{Longitude: -2, Latitude: 44, Radius: radius}
+		results, err := c.GeoSearch("points", query)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		expected := 0
+		for _, loc := range locations {
+			if GeoDistance(-2, 44, loc.Longitude, loc.Latitude) <= radius-1 {
+				expected++
+			}
+		}
+		if len(results) < expected {
+			t.Errorf("Radius %.0f: expected at least %d results, got %d", radius, expected, len(results))
+		}
+		for _, r := range results {
+			if r.Distance > radius {
+				t.Errorf("Radius %.0f: result %s is %f away", radius, r.Member, r.Distance)
+			}
+		}
+	}
+}
+
+func TestCacheJSON(t *testing.T) {
+	c := New()
+
+	if _, err := c.JSONSet("doc", "$.a", `1`, JSONSetAlways); err == nil {
+		t.Error("Setting a nested path on a missing document should fail")
+	}
+	written, err := c.JSONSet("doc", "$", `{"name":"Ada","visits":1,"tags":["x"],"items":[{"n":1},{"n":2.5}]}`, JSONSetAlways)
+	if err != nil || !written {
+		t.Fatalf("Expected document to be created (error: %v)", err)
+	}
+
+	if written, _ = c.JSONSet("doc", "$.name", `"Bob"`, JSONSetIfAbsent); written {
+		t.Error("NX should not replace an existing value")
+	}
+	if written, _ = c.JSONSet("doc", "$.email", `"ada@example.com"`, JSONSetIfExists); written {
+		t.Error("XX should not create a new member")
+	}
+	c.JSONSet("doc", "$.email", `"ada@example.com"`, JSONSetAlways)
+
+	if got, _, _ := c.JSONGet("doc", "$.email"); got != `["ada@example.com"]` {
+		t.Errorf("Unexpected email %s", got)
+	}
+	if got, _, _ := c.JSONGet("doc", "$.items[*].n"); got != `[1,2.5]` {
+		t.Errorf("Unexpected wildcard result %s", got)
+	}
+	if got, _, _ := c.JSONGet("doc", "$['items'][-1]"); got != `[{"n":2.5}]` {
+		t.Errorf("Unexpected negative index result %s", got)
+	}
+
+	if got, err := c.JSONNumIncrBy("doc", "$.visits", "2"); err != nil || got != `[3]` {
+		t.Errorf("Expected [3], got %s (error: %v)", got, err)
+	}
+	if got, _ := c.JSONNumIncrBy("doc", "$.items[*].n", "1"); got != `[2,3.5]` {
+		t.Errorf("Expected [2,3.5], got %s", got)
+	}
+	if got, _ := c.JSONNumIncrBy("doc", "$.name", "1"); got != `[null]` {
+		t.Errorf("Incrementing a string should yield null, got %s", got)
+	}
+
+	lengths, err := c.JSONArrAppend("doc", "$.tags", `"y"`, `{"z":true}`)
+	if err != nil || len(lengths) != 1 || lengths[0] != 3 {
+		t.Errorf("Expected new length 3, got %v (error: %v)", lengths, err)
+	}
+	if lengths, _ = c.JSONArrAppend("doc", "$.name", `1`); lengths[0] != -1 {
+		t.Errorf("Appending to a non-array should report -1, got %v", lengths)
+	}
+
+	if removed, _ := c.JSONDel("doc", "$.tags[*]"); removed != 3 {
+		t.Errorf("Expected 3 elements removed, got %d", removed)
+	}
+	if got, _, _ := c.JSONGet("doc", "$.tags"); got != `[[]]` {
+		t.Errorf("Expected empty tags, got %s", got)
+	}
+	if removed, _ := c.JSONDel("doc", "$"); removed != 1 || c.Exists("doc") {
+		t.Error("Deleting the root should remove the key")
+	}
+
+	if _, err := c.JSONSet("doc", "$", `{"a":`, JSONSetAlways); err == nil {
+		t.Error("Invalid JSON should be rejected")
+	}
+	if _, _, err := c.JSONGet("doc", "$..a"); err == nil {
+		t.Error("Unsupported recursive descent should be rejected")
+	}
+}
+
+func TestCacheEvents(t *testing.T) {
+	c := New()
+
+	var mu sync.Mutex
+	var events []string
+	c.SetEventHandler(EventAll&^EventHash, func(e Event) {
+		mu.Lock()
+		events = append(events, e.Name+" "+e.Key)
+		mu.Unlock()
+	})
+	received := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), events...)
+	}
+
+	c.Set("k", "v", 0)
+	c.HSet("h", "f", "v") // Hash class is not selected
+	c.LPush("l", "a")
+	c.Del("k")
+	c.Del("missing")
+
+	want := []string{"set k", "lpush l", "del k"}
+	if got := received(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected events %v, got %v", want, got)
+	}
+
+	// A write to an expired key reports the expiry first.
+	c.Set("w", "v", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	c.Set("w", "v2", 0)
+
+	want = append(want, "set w", "expire w", "expired w", "set w")
+	if got := received(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected events %v, got %v", want, got)
+	}
+
+	// A read hands the expired key to the cleanup goroutine.
+	c.Set("r", "v", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, exists := c.Get("r"); exists {
+		t.Error("Key should have expired")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if got := received(); got[len(got)-1] == "expired r" {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("Expected an expired event for r, got %v", received())
+}
+
+func TestCacheTransaction(t *testing.T) {
+	c := New()
+
+	c.Set("stock", "10", 0)
+	watched := map[string]uint64{"stock": c.Version("stock"), "missing": c.Version("missing")}
+	if watched["stock"] == 0 || watched["missing"] != 0 {
+		t.Fatalf("Unexpected versions %v", watched)
+	}
+
+	ok := c.Transaction(watched, func(tx *Cache) {
+		if _, err := tx.IncrBy("stock", -1); err != nil {
+			t.Errorf("IncrBy failed: %v", err)
+		}
+		tx.RPush("audit", "sold")
+	})
+	if !ok {
+		t.Fatal("Transaction should run when watched keys are unchanged")
+	}
+	if value, _ := c.Get("stock"); value != "9" || c.LLen("audit") != 1 {
+		t.Errorf("Expected stock 9 and one audit entry, got %s and %d", value, c.LLen("audit"))
+	}
+
+	// The transaction's own writes changed the version.
+	if c.Transaction(watched, func(*Cache) { t.Error("Transaction should not run") }) {
+		t.Error("Transaction should abort after a watched key changed")
+	}
+
+	watched = map[string]uint64{"audit": c.Version("audit")}
+	c.LPop("audit")
+	if c.Transaction(watched, func(*Cache) {}) {
+		t.Error("Transaction should abort after a watched list changed")
+	}
+}
+
+func TestCacheCompareAndSet(t *testing.T) {
+	c := New()
+
+	if _, ok := c.CompareAndSet("config", "v1", 1, 0); ok {
+		t.Error("CompareAndSet should fail for a missing key with a nonzero version")
+	}
+	version, ok := c.CompareAndSet("config", "v1", 0, 0)
+	if !ok || version == 0 {
+		t.Fatalf("CompareAndSet with version 0 should create the key, got %d, %v", version, ok)
+	}
+
+	value, got, exists := c.GetWithVersion("config")
+	if !exists || value != "v1" || got != version {
+		t.Fatalf("GetWithVersion = %q, %d, %v; want v1, %d, true", value, got, exists, version)
+	}
+
+	newVersion, ok := c.CompareAndSet("config", "v2", version, 0)
+	if !ok || newVersion <= version {
+		t.Fatalf("CompareAndSet with the current version failed: %d, %v", newVersion, ok)
+	}
+	if _, ok := c.CompareAndSet("config", "v3", version, 0); ok {
+		t.Error("CompareAndSet with a stale version should fail")
+	}
+	if value, _ := c.Get("config"); value != "v2" {
+		t.Errorf("Expected v2, got %s", value)
+	}
+
+	c.HSet("hash", "f", "v")
+	if _, _, exists := c.GetWithVersion("hash"); exists {
+		t.Error("GetWithVersion should ignore non-string values")
+	}
+}
+
+func TestCacheGCRA(t *testing.T) {
+	c := New()
+	limit := RateLimit{Burst: 3, Count: 10, Period: time.Second} // One unit every 100ms
+	now := time.Now()
+
+	for i := int64(0); i < 3; i++ {
+		result, err := c.gcra("api", limit, 1, now)
+		if err != nil || !result.Allowed || result.Remaining != 2-i {
+			t.Fatalf("Request %d: got %+v, %v", i, result, err)
+		}
+	}
+
+	result, _ := c.gcra("api", limit, 1, now)
+	if result.Allowed || result.RetryAfter != 100*time.Millisecond || result.Remaining != 0 {
+		t.Errorf("Expected a denial with a 100ms retry, got %+v", result)
+	}
+	if result.ResetAfter != 300*time.Millisecond {
+		t.Errorf("Expected a 300ms reset, got %v", result.ResetAfter)
+	}
+
+	result, _ = c.gcra("api", limit, 1, now.Add(100*time.Millisecond))
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("Expected the request after the retry delay to be allowed, got %+v", result)
+	}
+
+	if result, _ = c.gcra("api", limit, 5, now); result.RetryAfter != -1 {
+		t.Errorf("A request larger than the burst should never be allowed, got %+v", result)
+	}
+
+	c.HSet("hash", "f", "v")
+	if _, err := c.GCRA("hash", limit, 1); err == nil {
+		t.Error("GCRA should fail on a non-string value")
+	}
+	if _, err := c.GCRA("api", RateLimit{}, 1); err == nil {
+		t.Error("GCRA should reject an empty limit")
+	}
+}
+
+func TestCacheTokenBucket(t *testing.T) {
+	c := New()
+	limit := RateLimit{Burst: 10, Count: 5, Period: time.Second} // One token every 200ms
+	now := time.Now()
+
+	result, err := c.tokenBucket("upload", limit, 8, now)
+	if err != nil || !result.Allowed || result.Remaining != 2 || result.ResetAfter != 1600*time.Millisecond {
+		t.Fatalf("Expected 8 tokens to be taken, got %+v, %v", result, err)
+	}
+
+	result, _ = c.tokenBucket("upload", limit, 3, now)
+	if result.Allowed || result.RetryAfter != 200*time.Millisecond {
+		t.Errorf("Expected a denial with a 200ms retry, got %+v", result)
+	}
+
+	result, _ = c.tokenBucket("upload", limit, 3, now.Add(200*time.Millisecond))
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("Expected the refilled bucket to allow 3 tokens, got %+v", result)
+	}
+
+	result, _ = c.tokenBucket("upload", limit, 0, now.Add(time.Hour))
+	if !result.Allowed || result.Remaining != 10 {
+		t.Errorf("Expected a full bucket after an hour, got %+v", result)
+	}
+
+	if result, _ = c.tokenBucket("upload", limit, 11, now); result.RetryAfter != -1 {
+		t.Errorf("A request larger than the capacity should never be allowed, got %+v", result)
+	}
+}
+
+func TestCacheDumpRestore(t *testing.T) {
+	src := New()
+	src.Set("str", "hello", time.Hour)
+	src.HSet("hash", "f", "v")
+	src.RPush("list", "a", "b")
+	src.SAdd("set", "x", "y")
+	src.PFAdd("hll", "alice", "bob")
+	if _, err := src.GeoAdd("geo", nil, GeoLocation{Member: "rome", Longitude: 12.5, Latitude: 41.9}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.JSONSet("doc", "$", `{"n":12345678901234567890,"a":[1,"x"]}`, JSONSetAlways); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.XAdd("stream", "1-1", []string{"k", "v"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.XGroupCreate("stream", "g", "0", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.XReadGroup("g", "c", []string{"stream"}, []string{">"}, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := New()
+	src.DumpAll(func(key string, payload []byte, ttl time.Duration) {
+		if err := dst.Restore(key, payload, ttl, false); err != nil {
+			t.Errorf("Restore(%s) failed: %v", key, err)
+		}
+		restored, _, _ := dst.Dump(key)
+		if string(restored) != string(payload) {
+			t.Errorf("Dump of restored %s differs from the original", key)
+		}
+	})
+
+	if ttl := dst.TTL("str"); ttl < 59*time.Minute {
+		t.Errorf("Expected the TTL to be restored, got %v", ttl)
+	}
+	if count, _ := dst.PFCount("hll"); count != 2 {
+		t.Errorf("Expected 2 HyperLogLog elements, got %d", count)
+	}
+	if doc, _, _ := dst.JSONGet("doc"); doc != `{"a":[1,"x"],"n":12345678901234567890}` {
+		t.Errorf("Unexpected JSON document %s", doc)
+	}
+	if pending, _ := dst.XPending("stream", "g", "-", "+", 10, ""); len(pending) != 1 || pending[0].Consumer != "c" {
+		t.Errorf("Expected the pending entry to be restored, got %v", pending)
+	}
+
+	payload, _, _ := src.Dump("str")
+	if err := dst.Restore("str", payload, 0, false); !errors.Is(err, ErrBusyKey) {
+		t.Errorf("Expected ErrBusyKey, got %v", err)
+	}
+	payload[1] ^= 0xff
+	if err := dst.Restore("other", payload, 0, true); err == nil {
+		t.Error("Restore should reject a corrupt payload")
+	}
+
+	dst.Flush()
+	if dst.Exists("str") || dst.Exists("stream") {
+		t.Error("Flush should remove all keys")
+	}
+}
+
+func TestCacheKeys(t *testing.T) {
+	c := New()
+	c.Set("a", "1", 0)
+	c.HSet("b", "f", "v")
+	c.Set("expired", "x", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	keys := c.Keys()
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("Expected keys [a b], got %v", keys)
+	}
+}
+
+func TestCacheScan(t *testing.T) {
+	c := New()
+	for i := range 100 {
+		c.Set(fmt.Sprintf("key:%d", i), "v", 0)
+	}
+	c.Set("other", "v", 0)
+	c.Set("expired", "x", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	match := func(key string) bool { return strings.HasPrefix(key, "key:") }
+	seen := make(map[string]int)
+	cursor, calls := uint64(0), 0
+	for {
+		var keys []string
+		keys, cursor = c.Scan(cursor, 7, match)
+		if len(keys) > 7 {
+			t.Fatalf("Expected at most 7 keys, got %d", len(keys))
+		}
+		for _, key := range keys {
+			seen[key]++
+		}
+		calls++
+		if cursor == 0 {
+			break
+		}
+		// Keys added during the iteration must not disturb it
+		c.Set(fmt.Sprintf("new:%d", calls), "v", 0)
+	}
+
+	if len(seen) != 100 {
+		t.Errorf("Expected 100 keys, got %d", len(seen))
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("Expected %s once, got %d times", key, n)
+		}
+	}
+	if calls != 15 {
+		t.Errorf("Expected 15 calls, got %d", calls)
+	}
+
+	keys, cursor := c.Scan(0, 1000, nil)
+	if len(keys) != 101+calls-1 || cursor != 0 {
+		t.Errorf("Expected every key and cursor 0, got %d keys and cursor %d", len(keys), cursor)
+	}
+}
+
+func TestCacheVersionsFollowTheClock(t *testing.T) {
+	c, other := New(), New()
+
+	before := uint64(time.Now().UnixMicro())
+	c.Set("a", "1", 0)
+	c.Set("a", "2", 0)
+	first := c.Version("a")
+	if first < before {
+		t.Errorf("Expected a version from the clock, at least %d, got %d", before, first)
+	}
+
+	// Another cache written later gives a larger version.
+	time.Sleep(time.Millisecond)
+	other.Set("a", "3", 0)
+	if second := other.Version("a"); second <= first {
+		t.Errorf("Expected the later write to have a larger version than %d, got %d", first, second)
+	}
+
+	// Versions keep increasing even when writes share a microsecond.
+	last := c.Version("a")
+	for i := 0; i < 100; i++ {
+		c.Set("a", "v", 0)
+		if version := c.Version("a"); version <= last {
+			t.Fatalf("Expected a version above %d, got %d", last, version)
+		}
+		last = c.Version("a")
+	}
+}
+
+func TestCacheCompareAndSetVersion(t *testing.T) {
+	c := New()
+
+	if !c.CompareAndSetVersion("config", "v1", 0, 100, time.Hour) {
+		t.Fatal("CompareAndSetVersion should create a missing key with version 0")
+	}
+	if value, version, _ := c.GetWithVersion("config"); value != "v1" || version != 100 {
+		t.Errorf("Expected v1 with version 100, got %q with %d", value, version)
+	}
+	if ttl := c.TTL("config"); ttl <= 0 {
+		t.Errorf("Expected a TTL, got %v", ttl)
+	}
+
+	if c.CompareAndSetVersion("config", "v2", 0, 200, 0) {
+		t.Error("CompareAndSetVersion with a stale version should fail")
+	}
+	if c.CompareAndSetVersion("config", "v2", 100, 100, 0) {
+		t.Error("CompareAndSetVersion should not keep the version")
+	}
+	if value, _ := c.Get("config"); value != "v1" {
+		t.Errorf("Expected v1 to be kept, got %s", value)
+	}
+
+	// New versions stay above the given one.
+	c.CompareAndSetVersion("other", "v", 0, 1<<52, 0)
+	c.Set("config", "v3", 0)
+	if version := c.Version("config"); version <= 1<<52 {
+		t.Errorf("Expected a version above %d, got %d", uint64(1<<52), version)
+	}
+}
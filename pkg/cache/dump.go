@@ -0,0 +1,524 @@
+package cache
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
+	"sort"
+	"time"
+)
+
+// dumpVersion is the version of the serialization format written by Dump.
+// Restore rejects payloads written by other versions.
+const dumpVersion = 1
+
+// dumpTrailerSize is the size of the version byte and CRC32 that end every
+// payload.
+const dumpTrailerSize = 5
+
+// ErrBusyKey is returned by Restore when the key exists and replace was not
+// requested.
+var ErrBusyKey = errors.New("target key name is busy")
+
+// errBadPayload is returned for payloads that don't decode.
+var errBadPayload = errors.New("payload version or checksum are wrong")
+
+// Dump serializes the value at key in an opaque format that Restore
+// accepts, together with its remaining time to live. The payload ends with
+// a format version and a checksum, so it can be stored or sent between
+// nodes safely.
+//
+// Example:
+//
+//	payload, ttl, exists := source.Dump("user:123")
+//	if exists {
+//		err := target.Restore("user:123", payload, ttl, true)
+//	}
+//
+// Returns:
+//   - The serialized value
+//   - The remaining time to live, or 0 if the key doesn't expire
+//   - False if the key doesn't exist
+func (c *Cache) Dump(key string) ([]byte, time.Duration, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	value, exists := c.data[key]
+	if !exists || c.expiredKey(key, value) {
+		return nil, 0, false
+	}
+	return encodeValue(value), remainingTTL(value), true
+}
+
+// DumpAll calls fn with the serialized value and remaining time to live of
+// every key, as Dump would return them, while holding the cache lock. fn
+// must not call back into the cache.
+func (c *Cache) DumpAll(fn func(key string, payload []byte, ttl time.Duration)) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for key, value := range c.data {
+		if !c.isExpired(value) {
+			fn(key, encodeValue(value), remainingTTL(value))
+		}
+	}
+}
+
+// Keys returns the keys that haven't expired, in no particular order.
+func (c *Cache) Keys() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keys := make([]string, 0, len(c.data))
+	for key, value := range c.data {
+		if !c.isExpired(value) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Scan returns up to count keys that haven't expired and match, starting
+// at cursor, and the cursor to continue from, which is 0 once every key
+// has been returned. Start with cursor 0.
+//
+// Keys are returned in the order of their hash, so a full iteration
+// returns every key that exists for its whole duration exactly once, even
+// while other keys are added or removed. Each call walks the whole
+// keyspace, without holding the lock between calls.
+//
+// Example:
+//
+//	for cursor := uint64(0); ; {
+//		var keys []string
+//		keys, cursor = c.Scan(cursor, 100, nil)
+//		process(keys)
+//		if cursor == 0 {
+//			break
+//		}
+//	}
+func (c *Cache) Scan(cursor uint64, count int, match func(key string) bool) ([]string, uint64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	count = max(count, 1)
+	batch := make(scanHeap, 0, count)
+	more := false
+	for key, value := range c.data {
+		h := scanHash(key)
+		if h < cursor || c.isExpired(value) || match != nil && !match(key) {
+			continue
+		}
+		if len(batch) < count {
+			heap.Push(&batch, scanEntry{key, h})
+			continue
+		}
+		more = true
+		if h < batch[0].hash {
+			batch[0] = scanEntry{key, h}
+			heap.Fix(&batch, 0)
+		}
+	}
+
+	next := uint64(0)
+	if more && batch[0].hash != math.MaxUint64 {
+		next = batch[0].hash + 1
+	}
+	keys := make([]string, len(batch))
+	for i, entry := range batch {
+		keys[i] = entry.key
+	}
+	return keys, next
+}
+
+// scanHash returns the position of key in the order of Scan: its 64-bit
+// FNV-1a hash.
+func scanHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key)) //nolint:errcheck,gosec // Hash writes never fail
+	return h.Sum64()
+}
+
+// scanEntry is a key found by Scan.
+type scanEntry struct {
+	key  string
+	hash uint64
+}
+
+// scanHeap is a max-heap of scanEntry by hash, holding the keys with the
+// lowest hashes found so far.
+type scanHeap []scanEntry
+
+func (h scanHeap) Len() int            { return len(h) }
+func (h scanHeap) Less(i, j int) bool  { return h[i].hash > h[j].hash }
+func (h scanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scanHeap) Push(x interface{}) { *h = append(*h, x.(scanEntry)) }
+
+func (h *scanHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// Restore creates key from a payload produced by Dump, with an optional
+// TTL (0 for none).
+//
+// Parameters:
+//   - key: The key to create
+//   - payload: Serialized value from Dump
+//   - ttl: Time to live (0 for no expiration)
+//   - replace: Overwrite the key if it exists
+//
+// Returns:
+//   - ErrBusyKey if the key exists and replace is false
+//   - Error if the payload is corrupt or from an incompatible version
+func (c *Cache) Restore(key string, payload []byte, ttl time.Duration, replace bool) error {
+	value, err := decodeValue(payload)
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		value.ExpiresAt = time.Now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(key)
+	if _, exists := c.data[key]; exists && !replace {
+		return ErrBusyKey
+	}
+	c.data[key] = value
+	c.notify(EventGeneric, "restore", key)
+	return nil
+}
+
+// Flush removes all keys. Each removed key gets a new version, but no
+// keyspace events are emitted.
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.data {
+		delete(c.data, key)
+		c.touch(key)
+	}
+}
+
+// remainingTTL returns the time until value expires, or 0 if it doesn't.
+func remainingTTL(value *Value) time.Duration {
+	if value.ExpiresAt.IsZero() {
+		return 0
+	}
+	// Keep keys that are about to expire from turning persistent.
+	return max(time.Until(value.ExpiresAt), time.Millisecond)
+}
+
+// dumpWriter appends length-prefixed fields to a payload.
+type dumpWriter struct {
+	buf []byte
+}
+
+func (w *dumpWriter) uint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *dumpWriter) int(v int64) {
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *dumpWriter) string(s string) {
+	w.uint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *dumpWriter) streamID(id StreamID) {
+	w.uint(id.Ms)
+	w.uint(id.Seq)
+}
+
+// dumpReader reads fields written by dumpWriter. The first error sticks
+// and makes all further reads return zero values.
+type dumpReader struct {
+	buf []byte
+	err error
+}
+
+func (r *dumpReader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errBadPayload
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *dumpReader) int() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = errBadPayload
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+// count reads a collection size, rejecting sizes that can't fit in the rest
+// of the payload so that corrupt input can't cause huge allocations.
+func (r *dumpReader) count() int {
+	n := r.uint()
+	if n > uint64(len(r.buf)) {
+		r.err = errBadPayload
+		return 0
+	}
+	return int(n)
+}
+
+func (r *dumpReader) string() string {
+	n := r.count()
+	if r.err != nil {
+		return ""
+	}
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+func (r *dumpReader) streamID() StreamID {
+	return StreamID{Ms: r.uint(), Seq: r.uint()}
+}
+
+// encodeValue serializes a value without its expiration: the type, the
+// type-specific data, the format version and a CRC32 of everything before
+// it.
+func encodeValue(value *Value) []byte {
+	w := &dumpWriter{buf: []byte{byte(value.Type)}}
+
+	switch data := value.Data.(type) {
+	case string:
+		w.string(data)
+	case map[string]string:
+		fields := sortedKeys(data)
+		w.uint(uint64(len(fields)))
+		for _, field := range fields {
+			w.string(field)
+			w.string(data[field])
+		}
+	case []string:
+		w.uint(uint64(len(data)))
+		for _, item := range data {
+			w.string(item)
+		}
+	case map[string]bool:
+		members := sortedKeys(data)
+		w.uint(uint64(len(members)))
+		for _, member := range members {
+			w.string(member)
+		}
+	case *HyperLogLog:
+		encoded, _ := data.MarshalBinary() //nolint:errcheck // MarshalBinary never fails
+		w.string(string(encoded))
+	case *Stream:
+		encodeStream(w, data)
+	case *GeoSet:
+		w.uint(uint64(len(data.entries)))
+		for _, entry := range data.entries {
+			w.string(entry.member)
+			w.uint(entry.hash)
+		}
+	case *JSONDocument:
+		encoded, _ := json.Marshal(data.root) //nolint:errcheck // Documents only hold JSON values
+		w.string(string(encoded))
+	}
+
+	w.buf = append(w.buf, dumpVersion)
+	return binary.BigEndian.AppendUint32(w.buf, crc32.ChecksumIEEE(w.buf))
+}
+
+// encodeStream serializes entries, consumer groups and their pending lists.
+func encodeStream(w *dumpWriter, s *Stream) {
+	w.streamID(s.lastID)
+	w.uint(uint64(len(s.entries)))
+	for _, entry := range s.entries {
+		w.streamID(entry.ID)
+		w.uint(uint64(len(entry.Fields)))
+		for _, field := range entry.Fields {
+			w.string(field)
+		}
+	}
+
+	names := sortedKeys(s.groups)
+	w.uint(uint64(len(names)))
+	for _, name := range names {
+		group := s.groups[name]
+		w.string(name)
+		w.streamID(group.lastDelivered)
+
+		ids := make([]StreamID, 0, len(group.pending))
+		for id := range group.pending {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+		w.uint(uint64(len(ids)))
+		for _, id := range ids {
+			pending := group.pending[id]
+			w.streamID(id)
+			w.string(pending.consumer)
+			w.int(pending.deliveries)
+			w.int(pending.deliveredAt.UnixNano())
+		}
+
+		consumers := sortedKeys(group.consumers)
+		w.uint(uint64(len(consumers)))
+		for _, name := range consumers {
+			consumer := group.consumers[name]
+			w.string(name)
+			w.int(consumer.seenAt.UnixNano())
+			w.int(consumer.pending)
+		}
+	}
+}
+
+// decodeValue parses a payload produced by encodeValue.
+func decodeValue(payload []byte) (*Value, error) {
+	if len(payload) < 1+dumpTrailerSize {
+		return nil, errBadPayload
+	}
+	body := payload[:len(payload)-4]
+	if body[len(body)-1] != dumpVersion ||
+		binary.BigEndian.Uint32(payload[len(body):]) != crc32.ChecksumIEEE(body) {
+		return nil, errBadPayload
+	}
+
+	value := &Value{Type: ValueType(body[0])}
+	r := &dumpReader{buf: body[1 : len(body)-1]}
+
+	switch value.Type {
+	case TypeString:
+		value.Data = r.string()
+	case TypeHash:
+		n := r.count()
+		hash := make(map[string]string, n)
+		for i := 0; i < n; i++ {
+			field := r.string()
+			hash[field] = r.string()
+		}
+		value.Data = hash
+	case TypeList:
+		n := r.count()
+		list := make([]string, n)
+		for i := range list {
+			list[i] = r.string()
+		}
+		value.Data = list
+	case TypeSet:
+		n := r.count()
+		set := make(map[string]bool, n)
+		for i := 0; i < n; i++ {
+			set[r.string()] = true
+		}
+		value.Data = set
+	case TypeHyperLogLog:
+		hll := NewHyperLogLog()
+		if err := hll.UnmarshalBinary([]byte(r.string())); err != nil && r.err == nil {
+			r.err = fmt.Errorf("%w: %v", errBadPayload, err)
+		}
+		value.Data = hll
+	case TypeStream:
+		value.Data = decodeStream(r)
+	case TypeGeo:
+		n := r.count()
+		geo := newGeoSet()
+		for i := 0; i < n; i++ {
+			member := r.string()
+			geo.set(member, r.uint())
+		}
+		value.Data = geo
+	case TypeJSON:
+		root, err := parseJSONValue(r.string())
+		if err != nil && r.err == nil {
+			r.err = fmt.Errorf("%w: %v", errBadPayload, err)
+		}
+		value.Data = &JSONDocument{root: root}
+	default:
+		return nil, errBadPayload
+	}
+
+	if r.err == nil && len(r.buf) > 0 {
+		r.err = errBadPayload
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return value, nil
+}
+
+// decodeStream parses a stream written by encodeStream.
+func decodeStream(r *dumpReader) *Stream {
+	s := newStream()
+	s.lastID = r.streamID()
+
+	n := r.count()
+	s.entries = make([]StreamEntry, n)
+	for i := range s.entries {
+		s.entries[i].ID = r.streamID()
+		fields := make([]string, r.count())
+		for j := range fields {
+			fields[j] = r.string()
+		}
+		s.entries[i].Fields = fields
+	}
+
+	groups := r.count()
+	for i := 0; i < groups && r.err == nil; i++ {
+		name := r.string()
+		group := &consumerGroup{
+			lastDelivered: r.streamID(),
+			pending:       make(map[StreamID]*pendingEntry),
+			consumers:     make(map[string]*streamConsumer),
+		}
+		pending := r.count()
+		for j := 0; j < pending; j++ {
+			id := r.streamID()
+			group.pending[id] = &pendingEntry{
+				consumer:    r.string(),
+				deliveries:  r.int(),
+				deliveredAt: time.Unix(0, r.int()),
+			}
+		}
+		consumers := r.count()
+		for j := 0; j < consumers; j++ {
+			consumerName := r.string()
+			group.consumers[consumerName] = &streamConsumer{
+				seenAt:  time.Unix(0, r.int()),
+				pending: r.int(),
+			}
+		}
+		s.groups[name] = group
+	}
+	return s
+}
+
+// sortedKeys returns the keys of m in order, so that payloads of equal
+// values are identical.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,98 @@
+package cache
+
+// EventClass is a bit mask selecting groups of keyspace events.
+type EventClass uint16
+
+// Event classes. Each mutation belongs to exactly one class.
+const (
+	EventGeneric EventClass = 1 << iota // del, expire, persist
+	EventString                         // set, incrby, setbit, pfadd
+	EventList                           // lpush, rpush, lpop, rpop
+	EventSet                            // sadd, srem
+	EventHash                           // hset, hdel
+	EventGeo                            // geoadd
+	EventExpired                        // expired
+	EventStream                         // xadd, xtrim, xgroup-create, xgroup-destroy
+	EventJSON                           // json.set, json.del, json.numincrby, json.arrappend
+
+	// EventAll selects every class.
+	EventAll = EventGeneric | EventString | EventList | EventSet | EventHash |
+		EventGeo | EventExpired | EventStream | EventJSON
+)
+
+// expiredQueueSize bounds the keys found expired by readers that wait for
+// the cleanup goroutine. Keys that don't fit are removed by the next sweep.
+const expiredQueueSize = 1024
+
+// Event describes a change to a key.
+//
+// Expired events are emitted when an expired key is removed: immediately by
+// any write that touches it, shortly after any read that finds it, and
+// otherwise by the periodic cleanup. The cache never evicts keys, so there
+// is no "evicted" event.
+type Event struct {
+	Name  string     // Event name, such as "set", "hdel" or "expired"
+	Key   string     // Affected key
+	Class EventClass // Class the event belongs to
+}
+
+// EventHandler receives keyspace events.
+type EventHandler func(Event)
+
+// SetEventHandler registers handler to receive events of the given classes,
+// replacing any previous handler. A nil handler or zero classes disables
+// events.
+//
+// The handler runs synchronously while the cache lock is held, so it must
+// not call back into the cache and should return quickly.
+//
+// Example:
+//
+//	cache.SetEventHandler(cache.EventExpired|cache.EventGeneric, func(e cache.Event) {
+//		log.Printf("%s %s", e.Name, e.Key)
+//	})
+func (c *Cache) SetEventHandler(classes EventClass, handler EventHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.eventClasses = classes
+	c.eventHandler = handler
+}
+
+// notify records a modification of key and emits an event if its class is
+// enabled. Callers must hold c.mu for writing.
+func (c *Cache) notify(class EventClass, name, key string) {
+	if class == EventExpired && c.expiryHandler != nil {
+		c.expiryHandler(key)
+	}
+	c.touch(key)
+	if c.eventHandler != nil && c.eventClasses&class != 0 {
+		c.eventHandler(Event{Name: name, Key: key, Class: class})
+	}
+}
+
+// expireIfDue removes key if it has expired, emitting an "expired" event.
+// Writers call it before touching a key so that lazy expiry is reported
+// before the key is replaced. Callers must hold c.mu for writing.
+func (c *Cache) expireIfDue(key string) {
+	if value, exists := c.data[key]; exists && c.isExpired(value) {
+		delete(c.data, key)
+		c.notify(EventExpired, "expired", key)
+	}
+}
+
+// expiredKey reports whether value, stored at key, has expired. Readers
+// can't delete under the read lock, so expired keys are handed to the
+// cleanup goroutine, which removes them and emits their events. Callers
+// must hold c.mu.
+func (c *Cache) expiredKey(key string, value *Value) bool {
+	if !c.isExpired(value) {
+		return false
+	}
+
+	select {
+	case c.expiredKeys <- key:
+	default:
+	}
+	return true
+}
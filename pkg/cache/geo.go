@@ -0,0 +1,497 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Geohash parameters. Coordinates are encoded as 52-bit interleaved geohashes
+// (26 bits per axis), compatible with Redis. Latitudes are limited to the
+// range used by Web Mercator projections.
+const (
+	GeoLatMin = -85.05112878
+	GeoLatMax = 85.05112878
+	GeoLonMin = -180.0
+	GeoLonMax = 180.0
+
+	geoStepMax     = 26
+	geoEarthRadius = 6372797.560856 // Meters, same value Redis uses
+)
+
+// GeoSort selects the ordering of search results.
+type GeoSort uint8
+
+const (
+	GeoSortNone GeoSort = iota // Unspecified order
+	GeoSortAsc                 // Nearest first
+	GeoSortDesc                // Farthest first
+)
+
+// GeoLocation is a named point.
+type GeoLocation struct {
+	Member    string
+	Longitude float64
+	Latitude  float64
+}
+
+// GeoAddOptions controls how GeoAdd treats existing members.
+type GeoAddOptions struct {
+	NX bool // Only add new members
+	XX bool // Only update existing members
+	CH bool // Count updated members as well as added ones
+}
+
+// GeoSearchQuery describes a GeoSearch. The center is FromMember if set,
+// otherwise Longitude/Latitude. Radius selects a circular search; otherwise
+// Width and Height select a box. Distances are in meters.
+type GeoSearchQuery struct {
+	FromMember string
+	Longitude  float64
+	Latitude   float64
+	Radius     float64
+	Width      float64
+	Height     float64
+	Count      int     // Maximum results (0 for no limit)
+	Any        bool    // With Count, stop as soon as Count matches are found
+	Sort       GeoSort // Result order (defaults to nearest first when Count is set)
+}
+
+// GeoSearchResult is a member matched by GeoSearch.
+type GeoSearchResult struct {
+	Member    string
+	Longitude float64
+	Latitude  float64
+	Distance  float64 // Meters from the search center
+	Hash      uint64  // 52-bit geohash
+}
+
+// geoEntry is one member in hash order.
+type geoEntry struct {
+	member string
+	hash   uint64
+}
+
+// GeoSet stores members sorted by their 52-bit geohash so that a geohash cell
+// maps to a contiguous range of entries.
+type GeoSet struct {
+	members map[string]uint64
+	entries []geoEntry // Sorted by hash, then member
+}
+
+func newGeoSet() *GeoSet {
+	return &GeoSet{members: make(map[string]uint64)}
+}
+
+// search returns the index of the first entry not less than (hash, member).
+func (g *GeoSet) search(hash uint64, member string) int {
+	return sort.Search(len(g.entries), func(i int) bool {
+		e := g.entries[i]
+		return e.hash > hash || (e.hash == hash && e.member >= member)
+	})
+}
+
+// set inserts or moves member to the given hash.
+func (g *GeoSet) set(member string, hash uint64) {
+	if old, exists := g.members[member]; exists {
+		if old == hash {
+			return
+		}
+		i := g.search(old, member)
+		g.entries = append(g.entries[:i], g.entries[i+1:]...)
+	}
+
+	g.members[member] = hash
+	i := g.search(hash, member)
+	g.entries = append(g.entries, geoEntry{})
+	copy(g.entries[i+1:], g.entries[i:])
+	g.entries[i] = geoEntry{member: member, hash: hash}
+}
+
+// geoValidate checks that a coordinate pair can be indexed.
+func geoValidate(lon, lat float64) error {
+	if lon < GeoLonMin || lon > GeoLonMax || lat < GeoLatMin || lat > GeoLatMax {
+		return fmt.Errorf("invalid longitude,latitude pair %f,%f", lon, lat)
+	}
+	return nil
+}
+
+// geoEncode returns the interleaved geohash of a point at the given step
+// (bits per axis). Longitude bits occupy the odd positions.
+func geoEncode(lon, lat float64, step uint) uint64 {
+	cells := float64(uint64(1) << step)
+	latOffset := uint64((lat - GeoLatMin) / (GeoLatMax - GeoLatMin) * cells)
+	lonOffset := uint64((lon - GeoLonMin) / (GeoLonMax - GeoLonMin) * cells)
+	maxOffset := uint64(1)<<step - 1
+	if latOffset > maxOffset {
+		latOffset = maxOffset
+	}
+	if lonOffset > maxOffset {
+		lonOffset = maxOffset
+	}
+	return interleave(latOffset) | interleave(lonOffset)<<1
+}
+
+// geoCell returns the bounds of a geohash cell at the given step.
+func geoCell(hash uint64, step uint) (lonMin, lonMax, latMin, latMax float64) {
+	latOffset := deinterleave(hash)
+	lonOffset := deinterleave(hash >> 1)
+	cells := float64(uint64(1) << step)
+
+	latMin = GeoLatMin + float64(latOffset)/cells*(GeoLatMax-GeoLatMin)
+	latMax = GeoLatMin + float64(latOffset+1)/cells*(GeoLatMax-GeoLatMin)
+	lonMin = GeoLonMin + float64(lonOffset)/cells*(GeoLonMax-GeoLonMin)
+	lonMax = GeoLonMin + float64(lonOffset+1)/cells*(GeoLonMax-GeoLonMin)
+	return lonMin, lonMax, latMin, latMax
+}
+
+// geoDecode returns the center of the full-precision cell of a geohash.
+func geoDecode(hash uint64) (lon, lat float64) {
+	lonMin, lonMax, latMin, latMax := geoCell(hash, geoStepMax)
+	lon = math.Max(GeoLonMin, math.Min(GeoLonMax, (lonMin+lonMax)/2))
+	lat = math.Max(GeoLatMin, math.Min(GeoLatMax, (latMin+latMax)/2))
+	return lon, lat
+}
+
+// interleave spreads the low 32 bits of v over the even bit positions.
+func interleave(v uint64) uint64 {
+	v &= 0xFFFFFFFF
+	v = (v | v<<16) & 0x0000FFFF0000FFFF
+	v = (v | v<<8) & 0x00FF00FF00FF00FF
+	v = (v | v<<4) & 0x0F0F0F0F0F0F0F0F
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}
+
+// deinterleave gathers the even bit positions of v.
+func deinterleave(v uint64) uint64 {
+	v &= 0x5555555555555555
+	v = (v | v>>1) & 0x3333333333333333
+	v = (v | v>>2) & 0x0F0F0F0F0F0F0F0F
+	v = (v | v>>4) & 0x00FF00FF00FF00FF
+	v = (v | v>>8) & 0x0000FFFF0000FFFF
+	v = (v | v>>16) & 0x00000000FFFFFFFF
+	return v
+}
+
+// GeoDistance returns the great-circle distance in meters between two points.
+func GeoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r := lat1 * math.Pi / 180
+	lat2r := lat2 * math.Pi / 180
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2 - lon1) * math.Pi / 180 / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * geoEarthRadius * math.Asin(math.Sqrt(a))
+}
+
+// geoValue returns the geo set stored at key, or nil if it doesn't exist.
+// Callers must hold c.mu.
+func (c *Cache) geoValue(key string) (*GeoSet, error) {
+	value, exists := c.data[key]
+	if !exists || c.expiredKey(key, value) {
+		return nil, nil
+	}
+
+	if value.Type != TypeGeo {
+		return nil, fmt.Errorf("value is not a geo set")
+	}
+
+	geo, ok := value.Data.(*GeoSet)
+	if !ok {
+		return nil, fmt.Errorf("value is not a geo set")
+	}
+	return geo, nil
+}
+
+// GeoAdd adds or updates named locations in the geo set at key.
+//
+// Example:
+//
+//	added, err := cache.GeoAdd("drivers", nil,
+//		cache.GeoLocation{Member: "d1", Longitude: 13.361389, Latitude: 38.115556})
+//
+// Parameters:
+//   - key: The geo set key
+//   - opts: Optional NX/XX/CH behavior (nil for defaults)
+//   - locations: Members and their coordinates
+//
+// Returns:
+//   - Number of members added (or added and updated with CH)
+//   - Error if a coordinate is invalid or the key holds a different type
+func (c *Cache) GeoAdd(key string, opts *GeoAddOptions, locations ...GeoLocation) (int64, error) {
+	if opts == nil {
+		opts = &GeoAddOptions{}
+	}
+	if opts.NX && opts.XX {
+		return 0, fmt.Errorf("XX and NX options at the same time are not compatible")
+	}
+	for _, loc := range locations {
+		if err := geoValidate(loc.Longitude, loc.Latitude); err != nil {
+			return 0, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(key)
+	geo, err := c.geoValue(key)
+	if err != nil {
+		return 0, err
+	}
+	if geo == nil {
+		if opts.XX {
+			return 0, nil
+		}
+		geo = newGeoSet()
+		c.data[key] = &Value{Type: TypeGeo, Data: geo}
+	}
+
+	var changed int64
+	written := false
+	for _, loc := range locations {
+		hash := geoEncode(loc.Longitude, loc.Latitude, geoStepMax)
+		old, exists := geo.members[loc.Member]
+		switch {
+		case exists && opts.NX, !exists && opts.XX:
+			continue
+		case !exists:
+			changed++
+		case old != hash && opts.CH:
+			changed++
+		}
+		geo.set(loc.Member, hash)
+		written = true
+	}
+
+	if len(geo.members) == 0 {
+		delete(c.data, key)
+	}
+	if written {
+		c.notify(EventGeo, "geoadd", key)
+	}
+	return changed, nil
+}
+
+// GeoPos returns the coordinates of members. Missing members yield nil.
+// Coordinates are decoded from the stored geohash and so are accurate to
+// well under a meter rather than returned verbatim.
+//
+// Example:
+//
+//	positions, err := cache.GeoPos("drivers", "d1", "d2")
+func (c *Cache) GeoPos(key string, members ...string) ([]*GeoLocation, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	geo, err := c.geoValue(key)
+	if err != nil {
+		return nil, err
+	}
+
+	positions := make([]*GeoLocation, len(members))
+	if geo == nil {
+		return positions, nil
+	}
+	for i, member := range members {
+		if hash, exists := geo.members[member]; exists {
+			lon, lat := geoDecode(hash)
+			positions[i] = &GeoLocation{Member: member, Longitude: lon, Latitude: lat}
+		}
+	}
+	return positions, nil
+}
+
+// GeoDist returns the distance in meters between two members.
+//
+// Example:
+//
+//	meters, ok, err := cache.GeoDist("drivers", "d1", "d2")
+//
+// Returns:
+//   - Distance in meters
+//   - Boolean indicating if both members exist
+//   - Error if the key holds a different type
+func (c *Cache) GeoDist(key, member1, member2 string) (float64, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	geo, err := c.geoValue(key)
+	if err != nil || geo == nil {
+		return 0, false, err
+	}
+
+	hash1, ok1 := geo.members[member1]
+	hash2, ok2 := geo.members[member2]
+	if !ok1 || !ok2 {
+		return 0, false, nil
+	}
+
+	lon1, lat1 := geoDecode(hash1)
+	lon2, lat2 := geoDecode(hash2)
+	return GeoDistance(lon1, lat1, lon2, lat2), true, nil
+}
+
+// GeoSearch returns the members inside a circle or box. Candidates are
+// gathered from the geohash cells covering the search area, which map to
+// contiguous ranges of the sorted set, and then filtered exactly.
+//
+// Example:
+//
+//	nearest, err := cache.GeoSearch("drivers", &cache.GeoSearchQuery{
+//		Longitude: 13.36, Latitude: 38.11, Radius: 5000, Count: 3,
+//	})
+//
+// Returns:
+//   - Matching members with their coordinates and distance from the center
+//   - Error if the query is invalid or the key holds a different type
+func (c *Cache) GeoSearch(key string, query *GeoSearchQuery) ([]GeoSearchResult, error) {
+	if query.Radius <= 0 && (query.Width <= 0 || query.Height <= 0) {
+		return nil, fmt.Errorf("GEOSEARCH requires a positive radius or box size")
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	geo, err := c.geoValue(key)
+	if err != nil {
+		return nil, err
+	}
+
+	lon, lat := query.Longitude, query.Latitude
+	if query.FromMember != "" {
+		var hash uint64
+		var exists bool
+		if geo != nil {
+			hash, exists = geo.members[query.FromMember]
+		}
+		if !exists {
+			return nil, fmt.Errorf("could not decode requested member %q", query.FromMember)
+		}
+		lon, lat = geoDecode(hash)
+	} else if err := geoValidate(lon, lat); err != nil {
+		return nil, err
+	}
+
+	if geo == nil {
+		return []GeoSearchResult{}, nil
+	}
+
+	results := geo.searchArea(lon, lat, query)
+
+	sortOrder := query.Sort
+	if sortOrder == GeoSortNone && query.Count > 0 && !query.Any {
+		sortOrder = GeoSortAsc
+	}
+	switch sortOrder {
+	case GeoSortAsc:
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Distance < results[j].Distance })
+	case GeoSortDesc:
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Distance > results[j].Distance })
+	}
+
+	if query.Count > 0 && len(results) > query.Count {
+		results = results[:query.Count]
+	}
+	return results, nil
+}
+
+// searchArea collects the members matching the query shape around (lon, lat).
+func (g *GeoSet) searchArea(lon, lat float64, query *GeoSearchQuery) []GeoSearchResult {
+	results := []GeoSearchResult{}
+	for _, r := range g.coveringRanges(lon, lat, query) {
+		start := sort.Search(len(g.entries), func(i int) bool { return g.entries[i].hash >= r[0] })
+		for i := start; i < len(g.entries) && g.entries[i].hash < r[1]; i++ {
+			e := g.entries[i]
+			mLon, mLat := geoDecode(e.hash)
+			dist, ok := geoMatch(query, lon, lat, mLon, mLat)
+			if !ok {
+				continue
+			}
+			results = append(results, GeoSearchResult{
+				Member:    e.member,
+				Longitude: mLon,
+				Latitude:  mLat,
+				Distance:  dist,
+				Hash:      e.hash,
+			})
+			if query.Any && query.Count > 0 && len(results) >= query.Count {
+				return results
+			}
+		}
+	}
+	return results
+}
+
+// geoMatch reports whether a point lies inside the query shape centered at
+// (lon, lat), and its distance from the center.
+func geoMatch(query *GeoSearchQuery, lon, lat, pLon, pLat float64) (float64, bool) {
+	if query.Radius > 0 {
+		dist := GeoDistance(lon, lat, pLon, pLat)
+		return dist, dist <= query.Radius
+	}
+
+	latDist := geoEarthRadius * math.Abs(pLat-lat) * math.Pi / 180
+	if latDist > query.Height/2 {
+		return 0, false
+	}
+	if GeoDistance(pLon, pLat, lon, pLat) > query.Width/2 {
+		return 0, false
+	}
+	return GeoDistance(lon, lat, pLon, pLat), true
+}
+
+// coveringRanges returns [min, max) hash ranges of the geohash cells around
+// the center that together cover the search area. It picks the finest step at
+// which the center cell and its eight neighbors contain the area's bounding box.
+func (g *GeoSet) coveringRanges(lon, lat float64, query *GeoSearchQuery) [][2]uint64 {
+	halfHeight, halfWidth := query.Radius, query.Radius
+	if query.Radius <= 0 {
+		halfHeight, halfWidth = query.Height/2, query.Width/2
+	}
+
+	latDelta := halfHeight / geoEarthRadius * 180 / math.Pi
+	lonDelta := 360.0
+	if cosLat := math.Cos(lat * math.Pi / 180); cosLat > 1e-9 {
+		lonDelta = halfWidth / (geoEarthRadius * cosLat) * 180 / math.Pi
+	}
+	boxLatMin := math.Max(GeoLatMin, lat-latDelta)
+	boxLatMax := math.Min(GeoLatMax, lat+latDelta)
+
+	for step := uint(geoStepMax); step >= 1 && lonDelta < 180; step-- {
+		lonMin, lonMax, latMin, latMax := geoCell(geoEncode(lon, lat, step), step)
+		dLon, dLat := lonMax-lonMin, latMax-latMin
+		if boxLatMin < latMin-dLat || boxLatMax > latMax+dLat ||
+			lon-lonDelta < lonMin-dLon || lon+lonDelta > lonMax+dLon {
+			continue
+		}
+
+		seen := make(map[uint64]bool)
+		var ranges [][2]uint64
+		shift := 2 * (geoStepMax - step)
+		for _, di := range []float64{-1, 0, 1} {
+			cellLat := (latMin+latMax)/2 + di*dLat
+			if cellLat < GeoLatMin || cellLat > GeoLatMax {
+				continue
+			}
+			for _, dj := range []float64{-1, 0, 1} {
+				cellLon := (lonMin+lonMax)/2 + dj*dLon
+				if cellLon < GeoLonMin {
+					cellLon += 360
+				} else if cellLon >= GeoLonMax {
+					cellLon -= 360
+				}
+				hash := geoEncode(cellLon, cellLat, step)
+				if !seen[hash] {
+					seen[hash] = true
+					ranges = append(ranges, [2]uint64{hash << shift, (hash + 1) << shift})
+				}
+			}
+		}
+		return ranges
+	}
+
+	// The area spans most of the globe; scan everything.
+	return [][2]uint64{{0, math.MaxUint64}}
+}
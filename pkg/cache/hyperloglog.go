@@ -0,0 +1,478 @@
+package cache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// HyperLogLog parameters. With 2^14 registers the standard error is about 0.81%.
+const (
+	hllPrecision     = 14
+	hllRegisters     = 1 << hllPrecision
+	hllQ             = 64 - hllPrecision
+	hllSparseMaxSize = 3000 // Sparse entries before converting to the dense form
+)
+
+// HyperLogLog serialization encodings
+const (
+	hllEncodingSparse byte = iota
+	hllEncodingDense
+)
+
+// HyperLogLog is a probabilistic cardinality estimator using 16384 registers.
+// Small sets are stored sparsely as a sorted list of non-zero registers and
+// are converted to a dense register array once they grow beyond a few thousand
+// entries, keeping memory low for the common case of few distinct elements.
+//
+// HyperLogLog values are stored in the cache as TypeHyperLogLog. The type is
+// exported so that clients can merge sketches fetched from different nodes.
+//
+// Example:
+//
+//	hll := cache.NewHyperLogLog()
+//	hll.Add("alice")
+//	hll.Add("bob")
+//	fmt.Println(hll.Count()) // ~2
+type HyperLogLog struct {
+	sparse []uint32 // Sorted entries: register index << 8 | register value
+	dense  []uint8  // One byte per register once converted to dense form
+}
+
+// NewHyperLogLog creates an empty HyperLogLog in its sparse representation.
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{}
+}
+
+// IsDense reports whether the HyperLogLog uses the dense register array.
+func (h *HyperLogLog) IsDense() bool {
+	return h.dense != nil
+}
+
+// Add observes an element and reports whether any register changed.
+func (h *HyperLogLog) Add(element string) bool {
+	x := murmurHash64A([]byte(element), 0xadc83b19)
+	index := uint16(x & (hllRegisters - 1))
+	x >>= hllPrecision
+	x |= 1 << hllQ
+	count := uint8(bits.TrailingZeros64(x) + 1)
+	return h.setRegister(index, count)
+}
+
+// Merge folds other into h so that h estimates the union of both sets.
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	other.forEachRegister(func(index uint16, value uint8) {
+		h.setRegister(index, value)
+	})
+}
+
+// Count returns the estimated number of distinct elements observed.
+// It uses the improved estimator by Otmar Ertl, which is accurate across
+// the whole cardinality range without empirical bias correction.
+func (h *HyperLogLog) Count() int64 {
+	var histogram [hllQ + 2]int
+	nonZero := 0
+	h.forEachRegister(func(_ uint16, value uint8) {
+		histogram[value]++
+		nonZero++
+	})
+	histogram[0] = hllRegisters - nonZero
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for k := hllQ; k >= 1; k-- {
+		z += float64(histogram[k])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+
+	alpha := 0.5 / math.Ln2
+	return int64(math.Round(alpha * m * m / z))
+}
+
+// MarshalBinary encodes the HyperLogLog, preserving its representation.
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	if h.dense != nil {
+		buf := make([]byte, 0, 1+hllRegisters)
+		buf = append(buf, hllEncodingDense)
+		return append(buf, h.dense...), nil
+	}
+
+	buf := []byte{hllEncodingSparse}
+	buf = binary.AppendUvarint(buf, uint64(len(h.sparse)))
+	for _, entry := range h.sparse {
+		buf = binary.BigEndian.AppendUint32(buf, entry)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a HyperLogLog produced by MarshalBinary.
+func (h *HyperLogLog) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("invalid HyperLogLog encoding")
+	}
+
+	switch data[0] {
+	case hllEncodingDense:
+		if len(data) != 1+hllRegisters {
+			return fmt.Errorf("invalid dense HyperLogLog length")
+		}
+		h.sparse = nil
+		h.dense = make([]uint8, hllRegisters)
+		copy(h.dense, data[1:])
+		for _, v := range h.dense {
+			if v > hllQ+1 {
+				return fmt.Errorf("invalid HyperLogLog register value")
+			}
+		}
+	case hllEncodingSparse:
+		count, n := binary.Uvarint(data[1:])
+		if n <= 0 || count > hllRegisters || uint64(len(data)-1-n) != count*4 {
+			return fmt.Errorf("invalid sparse HyperLogLog length")
+		}
+		h.dense = nil
+		h.sparse = make([]uint32, 0, count)
+		for offset := 1 + n; offset < len(data); offset += 4 {
+			entry := binary.BigEndian.Uint32(data[offset:])
+			if entry&0xff > hllQ+1 || entry>>8 >= hllRegisters {
+				return fmt.Errorf("invalid HyperLogLog register value")
+			}
+			if len(h.sparse) > 0 && entry>>8 <= h.sparse[len(h.sparse)-1]>>8 {
+				return fmt.Errorf("unsorted sparse HyperLogLog entries")
+			}
+			h.sparse = append(h.sparse, entry)
+		}
+	default:
+		return fmt.Errorf("unknown HyperLogLog encoding: %d", data[0])
+	}
+	return nil
+}
+
+// setRegister raises register index to value if it is currently lower.
+// Reports whether the register changed.
+func (h *HyperLogLog) setRegister(index uint16, value uint8) bool {
+	if h.dense != nil {
+		if h.dense[index] >= value {
+			return false
+		}
+		h.dense[index] = value
+		return true
+	}
+
+	pos := sort.Search(len(h.sparse), func(i int) bool {
+		return uint16(h.sparse[i]>>8) >= index
+	})
+	entry := uint32(index)<<8 | uint32(value)
+	if pos < len(h.sparse) && uint16(h.sparse[pos]>>8) == index {
+		if uint8(h.sparse[pos]) >= value {
+			return false
+		}
+		h.sparse[pos] = entry
+		return true
+	}
+
+	h.sparse = append(h.sparse, 0)
+	copy(h.sparse[pos+1:], h.sparse[pos:])
+	h.sparse[pos] = entry
+
+	if len(h.sparse) > hllSparseMaxSize {
+		h.toDense()
+	}
+	return true
+}
+
+// toDense converts the sparse representation into a dense register array.
+func (h *HyperLogLog) toDense() {
+	dense := make([]uint8, hllRegisters)
+	for _, entry := range h.sparse {
+		dense[entry>>8] = uint8(entry)
+	}
+	h.dense = dense
+	h.sparse = nil
+}
+
+// forEachRegister calls fn for every non-zero register.
+func (h *HyperLogLog) forEachRegister(fn func(index uint16, value uint8)) {
+	if h.dense != nil {
+		for i, v := range h.dense {
+			if v != 0 {
+				fn(uint16(i), v)
+			}
+		}
+		return
+	}
+	for _, entry := range h.sparse {
+		fn(uint16(entry>>8), uint8(entry))
+	}
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
+
+// murmurHash64A is MurmurHash2's 64-bit variant, the same function Redis
+// uses for HyperLogLog register selection.
+func murmurHash64A(data []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ uint64(len(data))*m
+
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+
+	if len(data) > 0 {
+		var tail uint64
+		for i := len(data) - 1; i >= 0; i-- {
+			tail = tail<<8 | uint64(data[i])
+		}
+		h ^= tail
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hyperLogLogValue returns the HyperLogLog stored at key, or nil if the key is
+// missing or expired. Callers must hold c.mu.
+func (c *Cache) hyperLogLogValue(key string) (*HyperLogLog, error) {
+	value, exists := c.data[key]
+	if !exists || c.expiredKey(key, value) {
+		return nil, nil
+	}
+
+	if value.Type != TypeHyperLogLog {
+		return nil, fmt.Errorf("value is not a HyperLogLog")
+	}
+
+	hll, ok := value.Data.(*HyperLogLog)
+	if !ok {
+		return nil, fmt.Errorf("value is not a HyperLogLog")
+	}
+	return hll, nil
+}
+
+// PFAdd adds elements to the HyperLogLog stored at key, creating it if needed.
+// Returns true if the estimated cardinality may have changed (or the key was created).
+//
+// Example:
+//
+//	changed, err := cache.PFAdd("visitors:/home", "alice", "bob")
+//
+// Parameters:
+//   - key: The HyperLogLog key
+//   - elements: Elements to observe
+//
+// Returns:
+//   - Boolean indicating if any register was updated
+//   - Error if the key holds a different type
+func (c *Cache) PFAdd(key string, elements ...string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(key)
+	hll, err := c.hyperLogLogValue(key)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	if hll == nil {
+		hll = NewHyperLogLog()
+		c.data[key] = &Value{Type: TypeHyperLogLog, Data: hll}
+		changed = true
+	}
+
+	for _, element := range elements {
+		if hll.Add(element) {
+			changed = true
+		}
+	}
+	if changed {
+		c.notify(EventString, "pfadd", key)
+	}
+	return changed, nil
+}
+
+// PFCount returns the approximate number of distinct elements observed by the
+// HyperLogLog at key. With several keys, the cardinality of their union is
+// returned. Missing keys count as empty.
+//
+// Example:
+//
+//	visitors, err := cache.PFCount("visitors:/home")
+//
+// Parameters:
+//   - keys: One or more HyperLogLog keys
+//
+// Returns:
+//   - Estimated cardinality
+//   - Error if any key holds a different type
+func (c *Cache) PFCount(keys ...string) (int64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(keys) == 1 {
+		hll, err := c.hyperLogLogValue(keys[0])
+		if err != nil || hll == nil {
+			return 0, err
+		}
+		return hll.Count(), nil
+	}
+
+	union := NewHyperLogLog()
+	for _, key := range keys {
+		hll, err := c.hyperLogLogValue(key)
+		if err != nil {
+			return 0, err
+		}
+		if hll != nil {
+			union.Merge(hll)
+		}
+	}
+	return union.Count(), nil
+}
+
+// PFMerge merges the HyperLogLogs at srcKeys into destKey, which is created if
+// needed. An existing HyperLogLog at destKey is included in the union.
+//
+// Example:
+//
+//	err := cache.PFMerge("visitors:week", "visitors:mon", "visitors:tue")
+//
+// Parameters:
+//   - destKey: The key receiving the merged HyperLogLog
+//   - srcKeys: Source HyperLogLog keys
+//
+// Returns:
+//   - Error if any key holds a different type
+func (c *Cache) PFMerge(destKey string, srcKeys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(destKey)
+	dest, err := c.hyperLogLogValue(destKey)
+	if err != nil {
+		return err
+	}
+
+	sources := make([]*HyperLogLog, 0, len(srcKeys))
+	for _, key := range srcKeys {
+		hll, srcErr := c.hyperLogLogValue(key)
+		if srcErr != nil {
+			return srcErr
+		}
+		if hll != nil {
+			sources = append(sources, hll)
+		}
+	}
+
+	if dest == nil {
+		dest = NewHyperLogLog()
+		c.data[destKey] = &Value{Type: TypeHyperLogLog, Data: dest}
+	}
+	for _, hll := range sources {
+		if hll != dest {
+			dest.Merge(hll)
+		}
+	}
+	c.notify(EventString, "pfadd", destKey)
+	return nil
+}
+
+// PFExport returns the serialized HyperLogLog stored at key.
+// Clients use it to merge sketches that live on different nodes.
+//
+// Returns:
+//   - Serialized HyperLogLog (see HyperLogLog.MarshalBinary)
+//   - Boolean indicating if the key exists
+//   - Error if the key holds a different type
+func (c *Cache) PFExport(key string) ([]byte, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	hll, err := c.hyperLogLogValue(key)
+	if err != nil || hll == nil {
+		return nil, false, err
+	}
+
+	data, err := hll.MarshalBinary()
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// PFImport merges a serialized HyperLogLog into the HyperLogLog at key,
+// creating it if needed. It is the counterpart of PFExport.
+//
+// Parameters:
+//   - key: The HyperLogLog key
+//   - data: Serialized HyperLogLog
+//
+// Returns:
+//   - Error if the data is malformed or the key holds a different type
+func (c *Cache) PFImport(key string, data []byte) error {
+	incoming := NewHyperLogLog()
+	if err := incoming.UnmarshalBinary(data); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(key)
+	hll, err := c.hyperLogLogValue(key)
+	if err != nil {
+		return err
+	}
+	if hll == nil {
+		c.data[key] = &Value{Type: TypeHyperLogLog, Data: incoming}
+	} else {
+		hll.Merge(incoming)
+	}
+	c.notify(EventString, "pfadd", key)
+	return nil
+}
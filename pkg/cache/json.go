@@ -0,0 +1,571 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// JSONSetMode restricts when JSONSet writes a value.
+type JSONSetMode uint8
+
+const (
+	JSONSetAlways   JSONSetMode = iota // Create or replace
+	JSONSetIfAbsent                    // NX: only create new values
+	JSONSetIfExists                    // XX: only replace existing values
+)
+
+// jsonSegmentKind identifies a step of a JSONPath.
+type jsonSegmentKind uint8
+
+const (
+	jsonSegmentKey      jsonSegmentKind = iota // .name or ['name']
+	jsonSegmentIndex                           // [n], negative counts from the end
+	jsonSegmentWildcard                        // .* or [*]
+)
+
+// jsonSegment is one step of a parsed JSONPath.
+type jsonSegment struct {
+	key   string
+	index int
+	kind  jsonSegmentKind
+}
+
+// jsonMatch is a value found by a JSONPath, with accessors to replace or
+// remove it in its parent.
+type jsonMatch struct {
+	value  interface{}
+	set    func(interface{})
+	remove func()
+}
+
+// jsonDeleted marks array elements removed by JSONDel until the document is
+// compacted, so that indexes of other matches stay valid.
+type jsonDeleted struct{}
+
+// JSONDocument holds a parsed JSON value. Numbers are kept as json.Number so
+// integers round-trip exactly.
+type JSONDocument struct {
+	root interface{}
+}
+
+// parseJSONPath parses the supported JSONPath subset: "$" followed by
+// ".name", "['name']", "[index]", ".*" and "[*]" steps. Legacy paths without
+// a leading "$" (".a.b" or "a.b") are accepted as well.
+func parseJSONPath(path string) ([]jsonSegment, error) {
+	switch {
+	case path == "" || path == "." || path == "$":
+		return nil, nil
+	case strings.HasPrefix(path, "$"):
+		path = path[1:]
+	case !strings.HasPrefix(path, ".") && !strings.HasPrefix(path, "["):
+		path = "." + path
+	}
+
+	var segments []jsonSegment
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+			if i < len(path) && path[i] == '.' {
+				return nil, fmt.Errorf("recursive descent is not supported")
+			}
+			if i < len(path) && path[i] == '*' {
+				segments = append(segments, jsonSegment{kind: jsonSegmentWildcard})
+				i++
+				continue
+			}
+			end := i
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("invalid JSONPath %q", path)
+			}
+			segments = append(segments, jsonSegment{kind: jsonSegmentKey, key: path[i:end]})
+			i = end
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSONPath %q: unterminated bracket", path)
+			}
+			seg, err := parseJSONBracket(path[i+1 : i+end])
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, seg)
+			i += end + 1
+		default:
+			return nil, fmt.Errorf("invalid JSONPath %q", path)
+		}
+	}
+	return segments, nil
+}
+
+// parseJSONBracket parses the contents of a bracket step.
+func parseJSONBracket(inner string) (jsonSegment, error) {
+	inner = strings.TrimSpace(inner)
+	if inner == "*" {
+		return jsonSegment{kind: jsonSegmentWildcard}, nil
+	}
+	if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+		return jsonSegment{kind: jsonSegmentKey, key: inner[1 : len(inner)-1]}, nil
+	}
+	index, err := strconv.Atoi(inner)
+	if err != nil {
+		return jsonSegment{}, fmt.Errorf("invalid JSONPath index %q", inner)
+	}
+	return jsonSegment{kind: jsonSegmentIndex, index: index}, nil
+}
+
+// resolve returns the values matched by a parsed path.
+func (d *JSONDocument) resolve(segments []jsonSegment, removeRoot func()) []jsonMatch {
+	matches := []jsonMatch{{
+		value:  d.root,
+		set:    func(v interface{}) { d.root = v },
+		remove: removeRoot,
+	}}
+
+	for _, seg := range segments {
+		var next []jsonMatch
+		for _, m := range matches {
+			next = append(next, jsonChildren(m.value, m.set, seg)...)
+		}
+		matches = next
+	}
+	return matches
+}
+
+// jsonChildren returns the children of node selected by one path step.
+func jsonChildren(node interface{}, setNode func(interface{}), seg jsonSegment) []jsonMatch {
+	var matches []jsonMatch
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		member := func(key string) jsonMatch {
+			return jsonMatch{
+				value:  n[key],
+				set:    func(v interface{}) { n[key] = v },
+				remove: func() { delete(n, key) },
+			}
+		}
+		switch seg.kind {
+		case jsonSegmentKey:
+			if _, ok := n[seg.key]; ok {
+				matches = append(matches, member(seg.key))
+			}
+		case jsonSegmentWildcard:
+			for key := range n {
+				matches = append(matches, member(key))
+			}
+		case jsonSegmentIndex:
+		}
+	case []interface{}:
+		element := func(i int) jsonMatch {
+			return jsonMatch{
+				value:  n[i],
+				set:    func(v interface{}) { n[i] = v },
+				remove: func() { n[i] = jsonDeleted{} },
+			}
+		}
+		switch seg.kind {
+		case jsonSegmentIndex:
+			i := seg.index
+			if i < 0 {
+				i += len(n)
+			}
+			if i >= 0 && i < len(n) {
+				matches = append(matches, element(i))
+			}
+		case jsonSegmentWildcard:
+			for i := range n {
+				matches = append(matches, element(i))
+			}
+		case jsonSegmentKey:
+		}
+	}
+	return matches
+}
+
+// compactJSON drops array elements marked as deleted.
+func compactJSON(node interface{}) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, child := range n {
+			n[key] = compactJSON(child)
+		}
+		return n
+	case []interface{}:
+		kept := n[:0]
+		for _, child := range n {
+			if _, deleted := child.(jsonDeleted); !deleted {
+				kept = append(kept, compactJSON(child))
+			}
+		}
+		return kept
+	default:
+		return node
+	}
+}
+
+// parseJSONValue decodes JSON text, keeping numbers as json.Number.
+func parseJSONValue(text string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("invalid JSON: trailing data")
+	}
+	return v, nil
+}
+
+// marshalJSON encodes a value without escaping HTML characters.
+func marshalJSON(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// jsonValue returns the document stored at key, or nil if it doesn't exist.
+// Callers must hold c.mu.
+func (c *Cache) jsonValue(key string) (*JSONDocument, error) {
+	value, exists := c.data[key]
+	if !exists || c.expiredKey(key, value) {
+		return nil, nil
+	}
+
+	if value.Type != TypeJSON {
+		return nil, fmt.Errorf("value is not a JSON document")
+	}
+
+	doc, ok := value.Data.(*JSONDocument)
+	if !ok {
+		return nil, fmt.Errorf("value is not a JSON document")
+	}
+	return doc, nil
+}
+
+// JSONSet stores a JSON value at path. Setting the root ("$") creates or
+// replaces the whole document; other paths require the document to exist.
+// A path whose last step is a missing object member adds that member.
+//
+// Example:
+//
+//	cache.JSONSet("user:1", "$", `{"name":"Ada","visits":0}`, cache.JSONSetAlways)
+//	cache.JSONSet("user:1", "$.email", `"ada@example.com"`, cache.JSONSetAlways)
+//
+// Parameters:
+//   - key: The document key
+//   - path: JSONPath of the value to set
+//   - value: JSON text of the new value
+//   - mode: Whether to create, replace, or both
+//
+// Returns:
+//   - Boolean indicating if a value was written
+//   - Error if the JSON or path is invalid, or the key holds a different type
+func (c *Cache) JSONSet(key, path, value string, mode JSONSetMode) (bool, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return false, err
+	}
+	newValue, err := parseJSONValue(value)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(key)
+	doc, err := c.jsonValue(key)
+	if err != nil {
+		return false, err
+	}
+
+	if doc == nil {
+		if len(segments) != 0 {
+			return false, fmt.Errorf("new objects must be created at the root")
+		}
+		if mode == JSONSetIfExists {
+			return false, nil
+		}
+		c.data[key] = &Value{Type: TypeJSON, Data: &JSONDocument{root: newValue}}
+		c.notify(EventJSON, "json.set", key)
+		return true, nil
+	}
+
+	if matches := doc.resolve(segments, nil); len(matches) > 0 {
+		if mode == JSONSetIfAbsent {
+			return false, nil
+		}
+		for i, m := range matches {
+			if i > 0 {
+				// Each match gets its own copy so later updates stay independent.
+				newValue, _ = parseJSONValue(value)
+			}
+			m.set(newValue)
+		}
+		c.notify(EventJSON, "json.set", key)
+		return true, nil
+	}
+
+	last := segments[len(segments)-1]
+	if mode == JSONSetIfExists || last.kind != jsonSegmentKey {
+		return false, nil
+	}
+
+	written := false
+	for _, parent := range doc.resolve(segments[:len(segments)-1], nil) {
+		if obj, ok := parent.value.(map[string]interface{}); ok {
+			if written {
+				newValue, _ = parseJSONValue(value)
+			}
+			obj[last.key] = newValue
+			written = true
+		}
+	}
+	if written {
+		c.notify(EventJSON, "json.set", key)
+	}
+	return written, nil
+}
+
+// JSONGet returns JSON text for the values at the given paths. With no path,
+// the whole document is returned. With one path, the result is an array of
+// all matches; with several, an object mapping each path to its matches.
+//
+// Example:
+//
+//	doc, ok, err := cache.JSONGet("user:1")
+//	names, ok, err := cache.JSONGet("users", "$[*].name")
+//
+// Returns:
+//   - JSON text of the result
+//   - Boolean indicating if the key exists
+//   - Error if a path is invalid or the key holds a different type
+func (c *Cache) JSONGet(key string, paths ...string) (string, bool, error) {
+	parsed := make([][]jsonSegment, len(paths))
+	for i, path := range paths {
+		segments, err := parseJSONPath(path)
+		if err != nil {
+			return "", false, err
+		}
+		parsed[i] = segments
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	doc, err := c.jsonValue(key)
+	if err != nil || doc == nil {
+		return "", false, err
+	}
+
+	collect := func(segments []jsonSegment) []interface{} {
+		values := []interface{}{}
+		for _, m := range doc.resolve(segments, nil) {
+			values = append(values, m.value)
+		}
+		return values
+	}
+
+	var result interface{}
+	switch len(paths) {
+	case 0:
+		result = doc.root
+	case 1:
+		result = collect(parsed[0])
+	default:
+		byPath := make(map[string]interface{}, len(paths))
+		for i, path := range paths {
+			byPath[path] = collect(parsed[i])
+		}
+		result = byPath
+	}
+
+	text, err := marshalJSON(result)
+	if err != nil {
+		return "", false, err
+	}
+	return text, true, nil
+}
+
+// JSONDel removes the values at path. Deleting the root removes the key.
+//
+// Example:
+//
+//	removed, err := cache.JSONDel("user:1", "$.email")
+//
+// Returns:
+//   - Number of values removed
+//   - Error if the path is invalid or the key holds a different type
+func (c *Cache) JSONDel(key, path string) (int64, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(key)
+	doc, err := c.jsonValue(key)
+	if err != nil || doc == nil {
+		return 0, err
+	}
+
+	matches := doc.resolve(segments, func() { delete(c.data, key) })
+	for _, m := range matches {
+		m.remove()
+	}
+	if len(segments) > 0 {
+		doc.root = compactJSON(doc.root)
+	}
+	if len(matches) > 0 {
+		c.notify(EventJSON, "json.del", key)
+	}
+	return int64(len(matches)), nil
+}
+
+// JSONNumIncrBy adds delta to the numbers at path. Integers stay integers
+// when the delta is an integer too.
+//
+// Example:
+//
+//	updated, err := cache.JSONNumIncrBy("user:1", "$.visits", "1") // "[1]"
+//
+// Returns:
+//   - JSON array of the new values (null for matches that are not numbers)
+//   - Error if the key doesn't exist, the path or delta is invalid, or the
+//     key holds a different type
+func (c *Cache) JSONNumIncrBy(key, path, delta string) (string, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return "", err
+	}
+	deltaNum := json.Number(delta)
+	if _, err := deltaNum.Float64(); err != nil {
+		return "", fmt.Errorf("value is not a number")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(key)
+	doc, err := c.jsonValue(key)
+	if err != nil {
+		return "", err
+	}
+	if doc == nil {
+		return "", fmt.Errorf("key does not exist")
+	}
+
+	results := []interface{}{}
+	updated := false
+	for _, m := range doc.resolve(segments, nil) {
+		num, ok := m.value.(json.Number)
+		if !ok {
+			results = append(results, nil)
+			continue
+		}
+		sum, err := addJSONNumbers(num, deltaNum)
+		if err != nil {
+			return "", err
+		}
+		m.set(sum)
+		results = append(results, sum)
+		updated = true
+	}
+	if updated {
+		c.notify(EventJSON, "json.numincrby", key)
+	}
+	return marshalJSON(results)
+}
+
+// addJSONNumbers adds two JSON numbers, using integer arithmetic when both
+// are integers and the result doesn't overflow.
+func addJSONNumbers(a, b json.Number) (json.Number, error) {
+	ai, errA := a.Int64()
+	bi, errB := b.Int64()
+	if errA == nil && errB == nil {
+		sum := ai + bi
+		if (sum > ai) == (bi > 0) {
+			return json.Number(strconv.FormatInt(sum, 10)), nil
+		}
+	}
+
+	af, _ := a.Float64()
+	bf, _ := b.Float64()
+	sum := af + bf
+	if math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return "", fmt.Errorf("result is not a finite number")
+	}
+	return json.Number(strconv.FormatFloat(sum, 'f', -1, 64)), nil
+}
+
+// JSONArrAppend appends JSON values to the arrays at path.
+//
+// Example:
+//
+//	lengths, err := cache.JSONArrAppend("user:1", "$.tags", `"admin"`)
+//
+// Returns:
+//   - New length of each matched array (-1 for matches that are not arrays)
+//   - Error if the key doesn't exist, a value or the path is invalid, or the
+//     key holds a different type
+func (c *Cache) JSONArrAppend(key, path string, values ...string) ([]int64, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		if _, err := parseJSONValue(v); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(key)
+	doc, err := c.jsonValue(key)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("key does not exist")
+	}
+
+	lengths := []int64{}
+	for _, m := range doc.resolve(segments, nil) {
+		arr, ok := m.value.([]interface{})
+		if !ok {
+			lengths = append(lengths, -1)
+			continue
+		}
+		for _, v := range values {
+			parsed, _ := parseJSONValue(v)
+			arr = append(arr, parsed)
+		}
+		m.set(arr)
+		lengths = append(lengths, int64(len(arr)))
+	}
+	for _, length := range lengths {
+		if length >= 0 {
+			c.notify(EventJSON, "json.arrappend", key)
+			break
+		}
+	}
+	return lengths, nil
+}
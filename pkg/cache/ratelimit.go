@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit describes a rate limiter: at most Burst units at once,
+// replenished at Count units per Period.
+type RateLimit struct {
+	Burst  int64         // Maximum units allowed at once
+	Count  int64         // Units replenished per period
+	Period time.Duration // Replenishment period
+}
+
+// validate checks that all fields of the limit are positive.
+func (l RateLimit) validate() error {
+	if l.Burst <= 0 || l.Count <= 0 || l.Period <= 0 {
+		return fmt.Errorf("burst, count and period must be positive")
+	}
+	return nil
+}
+
+// RateLimitResult is the outcome of a rate limiter request.
+type RateLimitResult struct {
+	Allowed    bool          // Whether the request was allowed and counted
+	Limit      int64         // Maximum number of requests allowed at once
+	Remaining  int64         // Requests that would be allowed right now
+	RetryAfter time.Duration // When a denied request may succeed; 0 if allowed, -1 if never
+	ResetAfter time.Duration // Until the limiter is back to its full capacity
+}
+
+// GCRA applies the generic cell rate algorithm to key: a request of
+// quantity units is allowed if it fits in the limit's burst, which is
+// replenished evenly over the period. The limiter only stores the
+// theoretical arrival time of the next request, as a string of Unix
+// nanoseconds that expires once the limiter is full again.
+//
+// Example:
+//
+//	// 100 requests per minute, at most 10 at once
+//	limit := cache.RateLimit{Burst: 10, Count: 100, Period: time.Minute}
+//	result, err := cache.GCRA("ratelimit:alice", limit, 1)
+//	if err == nil && !result.Allowed {
+//		time.Sleep(result.RetryAfter)
+//	}
+//
+// Parameters:
+//   - key: The limiter key
+//   - limit: The rate limit (all fields positive)
+//   - quantity: Units this request costs (0 only inspects the limiter)
+//
+// Returns:
+//   - The outcome of the request
+//   - Error if the parameters are invalid or key holds a different value
+func (c *Cache) GCRA(key string, limit RateLimit, quantity int64) (RateLimitResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gcra(key, limit, quantity, time.Now())
+}
+
+// gcra is GCRA at the given time. Callers must hold c.mu for writing.
+func (c *Cache) gcra(key string, limit RateLimit, quantity int64, now time.Time) (RateLimitResult, error) {
+	if err := limit.validate(); err != nil {
+		return RateLimitResult{}, err
+	}
+	if quantity < 0 {
+		return RateLimitResult{}, fmt.Errorf("quantity can't be negative")
+	}
+
+	c.expireIfDue(key)
+	state, err := c.rateLimitState(key)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	interval := limit.Period / time.Duration(limit.Count) // Emission interval of one unit
+	if interval <= 0 {
+		return RateLimitResult{}, fmt.Errorf("count is too high for the period")
+	}
+	tolerance := interval * time.Duration(limit.Burst)
+
+	tat := now
+	if state != "" {
+		ns, parseErr := strconv.ParseInt(state, 10, 64)
+		if parseErr != nil {
+			return RateLimitResult{}, fmt.Errorf("value is not a GCRA state")
+		}
+		if stored := time.Unix(0, ns); stored.After(now) {
+			tat = stored
+		}
+	}
+
+	result := RateLimitResult{Limit: limit.Burst}
+	newTAT := tat.Add(interval * time.Duration(quantity))
+	if allowAt := newTAT.Add(-tolerance); now.Before(allowAt) {
+		result.RetryAfter = allowAt.Sub(now)
+		if quantity > limit.Burst {
+			result.RetryAfter = -1
+		}
+		newTAT = tat
+	} else {
+		result.Allowed = true
+	}
+
+	resetAfter := newTAT.Sub(now)
+	result.ResetAfter = resetAfter
+	result.Remaining = int64((tolerance - resetAfter) / interval)
+
+	if result.Allowed && quantity > 0 {
+		c.data[key] = &Value{
+			Type:      TypeString,
+			Data:      strconv.FormatInt(newTAT.UnixNano(), 10),
+			ExpiresAt: newTAT,
+		}
+		c.notify(EventString, "gcra", key)
+	}
+	return result, nil
+}
+
+// TokenBucket takes tokens from the token bucket at key. The bucket holds
+// up to limit.Burst tokens, starts full and is refilled continuously with
+// limit.Count tokens per period. A request is allowed if the bucket holds
+// enough tokens. The bucket is stored as a string of its token count and the
+// Unix nanoseconds of the last update, and expires once it is full again.
+//
+// Example:
+//
+//	// Bursts of up to 20 requests, 5 more every second
+//	limit := cache.RateLimit{Burst: 20, Count: 5, Period: time.Second}
+//	result, err := cache.TokenBucket("ratelimit:upload", limit, 1)
+//
+// Parameters:
+//   - key: The bucket key
+//   - limit: Capacity and refill rate of the bucket (all fields positive)
+//   - tokens: Tokens this request takes (0 only inspects the bucket)
+//
+// Returns:
+//   - The outcome of the request
+//   - Error if the parameters are invalid or key holds a different value
+func (c *Cache) TokenBucket(key string, limit RateLimit, tokens int64) (RateLimitResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.tokenBucket(key, limit, tokens, time.Now())
+}
+
+// tokenBucket is TokenBucket at the given time. Callers must hold c.mu for
+// writing.
+func (c *Cache) tokenBucket(key string, limit RateLimit, tokens int64, now time.Time) (RateLimitResult, error) {
+	if err := limit.validate(); err != nil {
+		return RateLimitResult{}, err
+	}
+	if tokens < 0 {
+		return RateLimitResult{}, fmt.Errorf("tokens can't be negative")
+	}
+	capacity := limit.Burst
+
+	c.expireIfDue(key)
+	state, err := c.rateLimitState(key)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	perToken := float64(limit.Period) / float64(limit.Count) // Nanoseconds to refill one token
+	available := float64(capacity)
+	if state != "" {
+		stored, updated, parseErr := parseTokenBucket(state)
+		if parseErr != nil {
+			return RateLimitResult{}, parseErr
+		}
+		if elapsed := now.Sub(updated); elapsed > 0 {
+			stored += float64(elapsed) / perToken
+		}
+		available = math.Min(stored, float64(capacity))
+	}
+
+	result := RateLimitResult{Limit: capacity}
+	switch {
+	case available >= float64(tokens):
+		result.Allowed = true
+		available -= float64(tokens)
+	case tokens > capacity:
+		result.RetryAfter = -1
+	default:
+		result.RetryAfter = time.Duration(math.Ceil((float64(tokens) - available) * perToken))
+	}
+	result.Remaining = int64(available)
+	result.ResetAfter = time.Duration(math.Ceil((float64(capacity) - available) * perToken))
+
+	if result.Allowed && tokens > 0 {
+		c.data[key] = &Value{
+			Type:      TypeString,
+			Data:      strconv.FormatFloat(available, 'g', -1, 64) + " " + strconv.FormatInt(now.UnixNano(), 10),
+			ExpiresAt: now.Add(result.ResetAfter),
+		}
+		c.notify(EventString, "tokenbucket", key)
+	}
+	return result, nil
+}
+
+// rateLimitState returns the string stored at key by a rate limiter, or an
+// empty string if the key doesn't exist. Callers must hold c.mu.
+func (c *Cache) rateLimitState(key string) (string, error) {
+	value, exists := c.data[key]
+	if !exists {
+		return "", nil
+	}
+	str, ok := value.Data.(string)
+	if value.Type != TypeString || !ok {
+		return "", fmt.Errorf("value is not a string")
+	}
+	return str, nil
+}
+
+// parseTokenBucket parses the "tokens updated" state of a token bucket.
+func parseTokenBucket(state string) (float64, time.Time, error) {
+	tokensField, updatedField, found := strings.Cut(state, " ")
+	if !found {
+		return 0, time.Time{}, fmt.Errorf("value is not a token bucket state")
+	}
+	tokens, err := strconv.ParseFloat(tokensField, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("value is not a token bucket state")
+	}
+	ns, err := strconv.ParseInt(updatedField, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("value is not a token bucket state")
+	}
+	return tokens, time.Unix(0, ns), nil
+}
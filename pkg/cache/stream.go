@@ -0,0 +1,975 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StreamID identifies an entry in a stream. IDs are ordered first by their
+// millisecond timestamp and then by sequence number.
+type StreamID struct {
+	Ms  uint64 // Unix time in milliseconds
+	Seq uint64 // Sequence number within the millisecond
+}
+
+// String formats the ID in the Redis "ms-seq" form.
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less reports whether id sorts before other.
+func (id StreamID) Less(other StreamID) bool {
+	if id.Ms != other.Ms {
+		return id.Ms < other.Ms
+	}
+	return id.Seq < other.Seq
+}
+
+// IsZero reports whether id is 0-0.
+func (id StreamID) IsZero() bool {
+	return id.Ms == 0 && id.Seq == 0
+}
+
+// next returns the smallest ID greater than id.
+func (id StreamID) next() (StreamID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return StreamID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// ParseStreamID parses an ID in "ms-seq" or "ms" form. A missing
+// sequence number defaults to 0.
+//
+// Example:
+//
+//	id, err := cache.ParseStreamID("1700000000000-3")
+func ParseStreamID(s string) (StreamID, error) {
+	return parseStreamID(s, 0)
+}
+
+func parseStreamID(s string, defaultSeq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("invalid stream ID specified as stream command argument")
+	}
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: defaultSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("invalid stream ID specified as stream command argument")
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// parseRangeStart parses the start of an XRANGE interval: "-", an ID, or an
+// exclusive "(" ID.
+func parseRangeStart(s string) (StreamID, error) {
+	if s == "-" {
+		return StreamID{}, nil
+	}
+	if rest, exclusive := strings.CutPrefix(s, "("); exclusive {
+		id, err := parseStreamID(rest, 0)
+		if err != nil {
+			return id, err
+		}
+		next, ok := id.next()
+		if !ok {
+			return id, fmt.Errorf("invalid start ID for the interval")
+		}
+		return next, nil
+	}
+	return parseStreamID(s, 0)
+}
+
+// parseRangeEnd parses the end of an XRANGE interval: "+", an ID, or an
+// exclusive "(" ID. Returns false if the interval is empty.
+func parseRangeEnd(s string) (StreamID, bool, error) {
+	maxID := StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+	if s == "+" {
+		return maxID, true, nil
+	}
+	if rest, exclusive := strings.CutPrefix(s, "("); exclusive {
+		id, err := parseStreamID(rest, math.MaxUint64)
+		if err != nil {
+			return id, false, err
+		}
+		if id.IsZero() {
+			return id, false, nil
+		}
+		if id.Seq > 0 {
+			return StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true, nil
+		}
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true, nil
+	}
+	id, err := parseStreamID(s, math.MaxUint64)
+	return id, true, err
+}
+
+// StreamEntry is a single stream record: an ID plus field/value pairs.
+type StreamEntry struct {
+	ID     StreamID // Entry ID
+	Fields []string // Alternating field names and values
+}
+
+// StreamReadResult holds the entries read from one stream by XRead or XReadGroup.
+type StreamReadResult struct {
+	Key     string        // Stream key
+	Entries []StreamEntry // Entries read, in ID order
+}
+
+// StreamTrimStrategy selects how XAdd and XTrim bound a stream.
+type StreamTrimStrategy uint8
+
+const (
+	TrimNone   StreamTrimStrategy = iota // Do not trim
+	TrimMaxLen                           // Keep at most MaxLen entries
+	TrimMinID                            // Drop entries with IDs below MinID
+)
+
+// StreamTrim describes a trimming policy for XAdd and XTrim.
+type StreamTrim struct {
+	Strategy StreamTrimStrategy // Trimming strategy
+	MaxLen   int64              // Maximum length for TrimMaxLen
+	MinID    StreamID           // Lowest ID kept for TrimMinID
+}
+
+// StreamPendingEntry describes a message delivered to a consumer but not yet acknowledged.
+type StreamPendingEntry struct {
+	ID         StreamID      // Entry ID
+	Consumer   string        // Consumer that owns the entry
+	Idle       time.Duration // Time since the last delivery
+	Deliveries int64         // Number of times the entry was delivered
+}
+
+// StreamPendingSummary summarizes the pending entries list of a consumer group.
+type StreamPendingSummary struct {
+	Consumers map[string]int64 // Pending entry count per consumer
+	Lowest    StreamID         // Smallest pending ID
+	Highest   StreamID         // Largest pending ID
+	Count     int64            // Total pending entries
+}
+
+// Stream is an append-only log of entries with optional consumer groups.
+// It is stored in the cache as TypeStream.
+type Stream struct {
+	groups  map[string]*consumerGroup
+	entries []StreamEntry
+	lastID  StreamID
+}
+
+type consumerGroup struct {
+	pending       map[StreamID]*pendingEntry
+	consumers     map[string]*streamConsumer
+	lastDelivered StreamID
+}
+
+type pendingEntry struct {
+	deliveredAt time.Time
+	consumer    string
+	deliveries  int64
+}
+
+type streamConsumer struct {
+	seenAt  time.Time
+	pending int64
+}
+
+// streamWaiter is signalled when any stream it is registered for receives an entry.
+type streamWaiter struct {
+	ch   chan struct{}
+	once sync.Once
+}
+
+func newStream() *Stream {
+	return &Stream{groups: make(map[string]*consumerGroup)}
+}
+
+// search returns the index of the first entry with ID >= id.
+func (s *Stream) search(id StreamID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return !s.entries[i].ID.Less(id)
+	})
+}
+
+// lookup returns the entry with the given ID.
+func (s *Stream) lookup(id StreamID) (StreamEntry, bool) {
+	idx := s.search(id)
+	if idx < len(s.entries) && s.entries[idx].ID == id {
+		return s.entries[idx], true
+	}
+	return StreamEntry{}, false
+}
+
+// trim applies a trimming policy and returns the number of entries removed.
+func (s *Stream) trim(trim StreamTrim) int64 {
+	var remove int
+	switch trim.Strategy {
+	case TrimMaxLen:
+		if excess := int64(len(s.entries)) - trim.MaxLen; excess > 0 {
+			remove = int(excess)
+		}
+	case TrimMinID:
+		remove = s.search(trim.MinID)
+	case TrimNone:
+	}
+
+	for i := 0; i < remove; i++ {
+		s.entries[i] = StreamEntry{}
+	}
+	s.entries = s.entries[remove:]
+	return int64(remove)
+}
+
+// streamValue returns the stream stored at key, or nil if it doesn't exist.
+// Callers must hold c.mu.
+func (c *Cache) streamValue(key string) (*Stream, error) {
+	value, exists := c.data[key]
+	if !exists || c.expiredKey(key, value) {
+		return nil, nil
+	}
+
+	if value.Type != TypeStream {
+		return nil, fmt.Errorf("value is not a stream")
+	}
+
+	stream, ok := value.Data.(*Stream)
+	if !ok {
+		return nil, fmt.Errorf("value is not a stream")
+	}
+	return stream, nil
+}
+
+// XAdd appends an entry to the stream at key, creating the stream if needed.
+// The id may be "*" to generate one from the current time, "ms-*" to generate
+// only the sequence number, or an explicit ID greater than the last entry's.
+// The optional trim policy is applied after the entry is added.
+//
+// Example:
+//
+//	id, err := cache.XAdd("orders", "*", []string{"sku", "A-1", "qty", "2"}, nil)
+//
+// Parameters:
+//   - key: The stream key
+//   - id: Entry ID or an auto-generation pattern
+//   - fields: Alternating field names and values
+//   - trim: Optional trimming policy (nil for none)
+//
+// Returns:
+//   - The ID assigned to the new entry
+//   - Error if the ID is invalid or the key holds a different type
+func (c *Cache) XAdd(key, id string, fields []string, trim *StreamTrim) (StreamID, error) {
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return StreamID{}, fmt.Errorf("wrong number of arguments for XADD")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(key)
+	stream, err := c.streamValue(key)
+	if err != nil {
+		return StreamID{}, err
+	}
+
+	lastID := StreamID{}
+	if stream != nil {
+		lastID = stream.lastID
+	}
+
+	newID, err := nextStreamID(id, lastID)
+	if err != nil {
+		return StreamID{}, err
+	}
+
+	if stream == nil {
+		stream = newStream()
+		c.data[key] = &Value{Type: TypeStream, Data: stream}
+	}
+
+	stream.entries = append(stream.entries, StreamEntry{ID: newID, Fields: append([]string(nil), fields...)})
+	stream.lastID = newID
+	c.notify(EventStream, "xadd", key)
+	if trim != nil && stream.trim(*trim) > 0 {
+		c.notify(EventStream, "xtrim", key)
+	}
+
+	c.signalStream(key)
+	return newID, nil
+}
+
+// nextStreamID resolves an XADD ID argument against the stream's last ID.
+func nextStreamID(id string, lastID StreamID) (StreamID, error) {
+	tooSmall := fmt.Errorf("the ID specified in XADD is equal or smaller than the target stream top item")
+
+	if id == "*" {
+		ms := uint64(time.Now().UnixMilli())
+		if ms > lastID.Ms {
+			return StreamID{Ms: ms}, nil
+		}
+		next, ok := lastID.next()
+		if !ok {
+			return StreamID{}, tooSmall
+		}
+		return next, nil
+	}
+
+	if msPart, found := strings.CutSuffix(id, "-*"); found {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return StreamID{}, fmt.Errorf("invalid stream ID specified as stream command argument")
+		}
+		switch {
+		case ms > lastID.Ms:
+			return StreamID{Ms: ms}, nil
+		case ms == lastID.Ms && lastID.Seq < math.MaxUint64:
+			return StreamID{Ms: ms, Seq: lastID.Seq + 1}, nil
+		default:
+			return StreamID{}, tooSmall
+		}
+	}
+
+	newID, err := ParseStreamID(id)
+	if err != nil {
+		return StreamID{}, err
+	}
+	if newID.IsZero() {
+		return StreamID{}, fmt.Errorf("the ID specified in XADD must be greater than 0-0")
+	}
+	if !lastID.Less(newID) {
+		return StreamID{}, tooSmall
+	}
+	return newID, nil
+}
+
+// XLen returns the number of entries in the stream at key.
+//
+// Example:
+//
+//	length, err := cache.XLen("orders")
+func (c *Cache) XLen(key string) (int64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stream, err := c.streamValue(key)
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	return int64(len(stream.entries)), nil
+}
+
+// XRange returns the entries with IDs between start and end, inclusive.
+// Use "-" and "+" for the smallest and largest possible IDs, and prefix an
+// ID with "(" to make that bound exclusive. A count <= 0 returns all entries.
+//
+// Example:
+//
+//	entries, err := cache.XRange("orders", "-", "+", 10)
+//
+// Parameters:
+//   - key: The stream key
+//   - start: Lower bound of the range
+//   - end: Upper bound of the range
+//   - count: Maximum number of entries to return (<= 0 for no limit)
+//
+// Returns:
+//   - Entries in ascending ID order
+//   - Error if the bounds are invalid or the key holds a different type
+func (c *Cache) XRange(key, start, end string, count int) ([]StreamEntry, error) {
+	return c.xrange(key, start, end, count, false)
+}
+
+// XRevRange is like XRange but returns entries in descending ID order.
+// Note that the end bound comes first, as in Redis.
+//
+// Example:
+//
+//	latest, err := cache.XRevRange("orders", "+", "-", 1)
+func (c *Cache) XRevRange(key, end, start string, count int) ([]StreamEntry, error) {
+	return c.xrange(key, start, end, count, true)
+}
+
+func (c *Cache) xrange(key, start, end string, count int, reverse bool) ([]StreamEntry, error) {
+	startID, err := parseRangeStart(start)
+	if err != nil {
+		return nil, err
+	}
+	endID, nonEmpty, err := parseRangeEnd(end)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stream, err := c.streamValue(key)
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil || !nonEmpty || endID.Less(startID) {
+		return []StreamEntry{}, nil
+	}
+
+	lo := stream.search(startID)
+	hi := lo
+	for hi < len(stream.entries) && !endID.Less(stream.entries[hi].ID) {
+		hi++
+	}
+
+	result := make([]StreamEntry, 0, hi-lo)
+	if reverse {
+		for i := hi - 1; i >= lo && (count <= 0 || len(result) < count); i-- {
+			result = append(result, stream.entries[i])
+		}
+	} else {
+		for i := lo; i < hi && (count <= 0 || len(result) < count); i++ {
+			result = append(result, stream.entries[i])
+		}
+	}
+	return result, nil
+}
+
+// XTrim trims the stream at key according to the given policy.
+//
+// Example:
+//
+//	removed, err := cache.XTrim("orders", cache.StreamTrim{Strategy: cache.TrimMaxLen, MaxLen: 1000})
+//
+// Returns:
+//   - Number of entries removed
+//   - Error if the key holds a different type
+func (c *Cache) XTrim(key string, trim StreamTrim) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(key)
+	stream, err := c.streamValue(key)
+	if err != nil || stream == nil {
+		return 0, err
+	}
+
+	removed := stream.trim(trim)
+	if removed > 0 {
+		c.notify(EventStream, "xtrim", key)
+	}
+	return removed, nil
+}
+
+// StreamLastID returns the ID of the last entry added to the stream at key,
+// or 0-0 if the stream doesn't exist. It is used to resolve the "$" ID.
+func (c *Cache) StreamLastID(key string) (StreamID, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stream, err := c.streamValue(key)
+	if err != nil || stream == nil {
+		return StreamID{}, err
+	}
+	return stream.lastID, nil
+}
+
+// XRead returns, for each stream, up to count entries with IDs greater than
+// the corresponding ID in ids. Streams without new entries are omitted.
+// XRead never blocks; use NotifyStreams to wait for new entries.
+//
+// Example:
+//
+//	results, err := cache.XRead([]string{"orders"}, []string{"0-0"}, 100)
+//
+// Parameters:
+//   - keys: Stream keys
+//   - ids: Last ID already seen for each stream
+//   - count: Maximum entries per stream (<= 0 for no limit)
+//
+// Returns:
+//   - Entries read per stream
+//   - Error if an ID is invalid or a key holds a different type
+func (c *Cache) XRead(keys, ids []string, count int) ([]StreamReadResult, error) {
+	if len(keys) != len(ids) {
+		return nil, fmt.Errorf("unbalanced XREAD list of streams: for each stream key an ID must be specified")
+	}
+
+	after := make([]StreamID, len(ids))
+	for i, id := range ids {
+		parsed, err := ParseStreamID(id)
+		if err != nil {
+			return nil, err
+		}
+		after[i] = parsed
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var results []StreamReadResult
+	for i, key := range keys {
+		stream, err := c.streamValue(key)
+		if err != nil {
+			return nil, err
+		}
+		if stream == nil {
+			continue
+		}
+		if entries := stream.entriesAfter(after[i], count); len(entries) > 0 {
+			results = append(results, StreamReadResult{Key: key, Entries: entries})
+		}
+	}
+	return results, nil
+}
+
+// entriesAfter returns up to count entries with IDs strictly greater than id.
+func (s *Stream) entriesAfter(id StreamID, count int) []StreamEntry {
+	start, ok := id.next()
+	if !ok {
+		return nil
+	}
+	idx := s.search(start)
+	end := len(s.entries)
+	if count > 0 && idx+count < end {
+		end = idx + count
+	}
+	if idx >= end {
+		return nil
+	}
+	return append([]StreamEntry(nil), s.entries[idx:end]...)
+}
+
+// NotifyStreams returns a channel that is closed the next time an entry is
+// added to any of the given streams, and a function that unregisters the
+// notification. Register before calling XRead or XReadGroup to avoid
+// missing entries added in between.
+//
+// Example:
+//
+//	ready, cancel := cache.NotifyStreams("orders")
+//	defer cancel()
+//	results, _ := cache.XRead([]string{"orders"}, []string{lastID}, 10)
+//	if len(results) == 0 {
+//		<-ready
+//	}
+func (c *Cache) NotifyStreams(keys ...string) (ready <-chan struct{}, cancel func()) {
+	waiter := &streamWaiter{ch: make(chan struct{})}
+
+	c.mu.Lock()
+	if c.streamWaiters == nil {
+		c.streamWaiters = make(map[string]map[*streamWaiter]struct{})
+	}
+	for _, key := range keys {
+		if c.streamWaiters[key] == nil {
+			c.streamWaiters[key] = make(map[*streamWaiter]struct{})
+		}
+		c.streamWaiters[key][waiter] = struct{}{}
+	}
+	c.mu.Unlock()
+
+	cancel = func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, key := range keys {
+			delete(c.streamWaiters[key], waiter)
+			if len(c.streamWaiters[key]) == 0 {
+				delete(c.streamWaiters, key)
+			}
+		}
+	}
+	return waiter.ch, cancel
+}
+
+// signalStream wakes every waiter registered for key. Callers must hold c.mu.
+func (c *Cache) signalStream(key string) {
+	for waiter := range c.streamWaiters[key] {
+		waiter.once.Do(func() { close(waiter.ch) })
+	}
+	delete(c.streamWaiters, key)
+}
+
+// XGroupCreate creates a consumer group on the stream at key. The id is the
+// last delivered ID for the group; use "$" to deliver only new entries.
+// If mkStream is true, an empty stream is created when the key doesn't exist.
+//
+// Example:
+//
+//	err := cache.XGroupCreate("orders", "billing", "$", true)
+func (c *Cache) XGroupCreate(key, group, id string, mkStream bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(key)
+	stream, err := c.streamValue(key)
+	if err != nil {
+		return err
+	}
+	if stream == nil {
+		if !mkStream {
+			return fmt.Errorf("the XGROUP subcommand requires the key to exist")
+		}
+		stream = newStream()
+		c.data[key] = &Value{Type: TypeStream, Data: stream}
+	}
+
+	if _, exists := stream.groups[group]; exists {
+		return fmt.Errorf("BUSYGROUP consumer group name already exists")
+	}
+
+	lastDelivered := stream.lastID
+	if id != "$" {
+		if lastDelivered, err = ParseStreamID(id); err != nil {
+			return err
+		}
+	}
+
+	stream.groups[group] = &consumerGroup{
+		pending:       make(map[StreamID]*pendingEntry),
+		consumers:     make(map[string]*streamConsumer),
+		lastDelivered: lastDelivered,
+	}
+	c.notify(EventStream, "xgroup-create", key)
+	return nil
+}
+
+// XGroupDestroy removes a consumer group and its pending entries.
+// Returns true if the group existed.
+func (c *Cache) XGroupDestroy(key, group string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stream, err := c.streamValue(key)
+	if err != nil || stream == nil {
+		return false, err
+	}
+	if _, exists := stream.groups[group]; !exists {
+		return false, nil
+	}
+	delete(stream.groups, group)
+	c.notify(EventStream, "xgroup-destroy", key)
+	return true, nil
+}
+
+// consumerGroup returns the named group of the stream at key. Callers must hold c.mu.
+func (c *Cache) consumerGroup(key, group string) (*Stream, *consumerGroup, error) {
+	stream, err := c.streamValue(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stream == nil {
+		return nil, nil, fmt.Errorf("NOGROUP no such key '%s' or consumer group '%s'", key, group)
+	}
+	cg, exists := stream.groups[group]
+	if !exists {
+		return nil, nil, fmt.Errorf("NOGROUP no such key '%s' or consumer group '%s'", key, group)
+	}
+	return stream, cg, nil
+}
+
+// XReadGroup reads entries on behalf of a consumer in a consumer group.
+// An ID of ">" delivers entries never delivered to the group and records them
+// as pending for the consumer (unless noAck is set). Any other ID replays the
+// consumer's own pending entries with greater IDs; entries that were trimmed
+// from the stream are returned with nil fields.
+//
+// Example:
+//
+//	results, err := cache.XReadGroup("billing", "worker-1", []string{"orders"}, []string{">"}, 10, false)
+//
+// Parameters:
+//   - group: Consumer group name
+//   - consumer: Consumer name (created on first use)
+//   - keys: Stream keys
+//   - ids: ">" or a pending-history ID for each stream
+//   - count: Maximum entries per stream (<= 0 for no limit)
+//   - noAck: Deliver without adding entries to the pending list
+//
+// Returns:
+//   - Entries read per stream
+//   - Error if the group doesn't exist or arguments are invalid
+func (c *Cache) XReadGroup(group, consumer string, keys, ids []string, count int, noAck bool) ([]StreamReadResult, error) {
+	if len(keys) != len(ids) {
+		return nil, fmt.Errorf("unbalanced XREADGROUP list of streams: for each stream key an ID must be specified")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	var results []StreamReadResult
+	for i, key := range keys {
+		stream, cg, err := c.consumerGroup(key, group)
+		if err != nil {
+			return nil, err
+		}
+
+		cons := cg.consumer(consumer, now)
+
+		var entries []StreamEntry
+		if ids[i] == ">" {
+			entries = stream.entriesAfter(cg.lastDelivered, count)
+			for _, entry := range entries {
+				cg.lastDelivered = entry.ID
+				if noAck {
+					continue
+				}
+				if prev, pending := cg.pending[entry.ID]; pending {
+					cg.consumers[prev.consumer].pending--
+				}
+				cg.pending[entry.ID] = &pendingEntry{consumer: consumer, deliveredAt: now, deliveries: 1}
+				cons.pending++
+			}
+			if len(entries) > 0 {
+				c.touch(key)
+			}
+		} else {
+			after, err := ParseStreamID(ids[i])
+			if err != nil {
+				return nil, err
+			}
+			entries = cg.history(stream, consumer, after, count)
+		}
+
+		if len(entries) > 0 || ids[i] != ">" {
+			results = append(results, StreamReadResult{Key: key, Entries: entries})
+		}
+	}
+	return results, nil
+}
+
+// consumer returns the named consumer, creating it if needed, and marks it as seen.
+func (cg *consumerGroup) consumer(name string, now time.Time) *streamConsumer {
+	cons, exists := cg.consumers[name]
+	if !exists {
+		cons = &streamConsumer{}
+		cg.consumers[name] = cons
+	}
+	cons.seenAt = now
+	return cons
+}
+
+// sortedPending returns the pending IDs in ascending order.
+func (cg *consumerGroup) sortedPending() []StreamID {
+	ids := make([]StreamID, 0, len(cg.pending))
+	for id := range cg.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+	return ids
+}
+
+// history returns the consumer's pending entries with IDs greater than after.
+func (cg *consumerGroup) history(stream *Stream, consumer string, after StreamID, count int) []StreamEntry {
+	entries := []StreamEntry{}
+	for _, id := range cg.sortedPending() {
+		if count > 0 && len(entries) >= count {
+			break
+		}
+		pe := cg.pending[id]
+		if pe.consumer != consumer || !after.Less(id) {
+			continue
+		}
+		entry, found := stream.lookup(id)
+		if !found {
+			entry = StreamEntry{ID: id}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// XAck acknowledges entries for a consumer group, removing them from the
+// pending entries list.
+//
+// Example:
+//
+//	acked, err := cache.XAck("orders", "billing", id)
+//
+// Returns:
+//   - Number of entries acknowledged
+//   - Error if the key holds a different type
+func (c *Cache) XAck(key, group string, ids ...StreamID) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stream, err := c.streamValue(key)
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	cg, exists := stream.groups[group]
+	if !exists {
+		return 0, nil
+	}
+
+	var acked int64
+	for _, id := range ids {
+		if pe, pending := cg.pending[id]; pending {
+			if cons := cg.consumers[pe.consumer]; cons != nil {
+				cons.pending--
+			}
+			delete(cg.pending, id)
+			acked++
+		}
+	}
+	if acked > 0 {
+		c.touch(key)
+	}
+	return acked, nil
+}
+
+// XPendingSummary returns an overview of the pending entries of a consumer group.
+//
+// Example:
+//
+//	summary, err := cache.XPendingSummary("orders", "billing")
+//	fmt.Printf("%d messages awaiting acknowledgement\n", summary.Count)
+func (c *Cache) XPendingSummary(key, group string) (StreamPendingSummary, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, cg, err := c.consumerGroup(key, group)
+	if err != nil {
+		return StreamPendingSummary{}, err
+	}
+
+	summary := StreamPendingSummary{Consumers: make(map[string]int64)}
+	ids := cg.sortedPending()
+	if len(ids) == 0 {
+		return summary, nil
+	}
+
+	summary.Count = int64(len(ids))
+	summary.Lowest = ids[0]
+	summary.Highest = ids[len(ids)-1]
+	for _, pe := range cg.pending {
+		summary.Consumers[pe.consumer]++
+	}
+	return summary, nil
+}
+
+// XPending lists pending entries of a consumer group with IDs between start
+// and end (inclusive, "-" and "+" allowed), optionally filtered by consumer.
+//
+// Example:
+//
+//	pending, err := cache.XPending("orders", "billing", "-", "+", 10, "")
+//
+// Parameters:
+//   - key: The stream key
+//   - group: Consumer group name
+//   - start, end: ID range
+//   - count: Maximum entries to return
+//   - consumer: Only return entries owned by this consumer ("" for all)
+//
+// Returns:
+//   - Pending entries in ascending ID order
+//   - Error if the group doesn't exist
+func (c *Cache) XPending(key, group, start, end string, count int, consumer string) ([]StreamPendingEntry, error) {
+	startID, err := parseRangeStart(start)
+	if err != nil {
+		return nil, err
+	}
+	endID, nonEmpty, err := parseRangeEnd(end)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, cg, err := c.consumerGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []StreamPendingEntry{}
+	if !nonEmpty {
+		return result, nil
+	}
+
+	now := time.Now()
+	for _, id := range cg.sortedPending() {
+		if count > 0 && len(result) >= count {
+			break
+		}
+		if id.Less(startID) || endID.Less(id) {
+			continue
+		}
+		pe := cg.pending[id]
+		if consumer != "" && pe.consumer != consumer {
+			continue
+		}
+		result = append(result, StreamPendingEntry{
+			ID:         id,
+			Consumer:   pe.consumer,
+			Idle:       now.Sub(pe.deliveredAt),
+			Deliveries: pe.deliveries,
+		})
+	}
+	return result, nil
+}
+
+// XClaim transfers ownership of pending entries that have been idle for at
+// least minIdle to consumer. Claimed entries have their idle time reset and,
+// unless justID is set, their delivery count incremented. Pending entries
+// that no longer exist in the stream are removed from the pending list.
+//
+// Example:
+//
+//	claimed, err := cache.XClaim("orders", "billing", "worker-2", time.Minute, false, stuckID)
+//
+// Returns:
+//   - The claimed entries (only IDs are meaningful when justID is set)
+//   - Error if the group doesn't exist
+func (c *Cache) XClaim(key, group, consumer string, minIdle time.Duration, justID bool, ids ...StreamID) ([]StreamEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stream, cg, err := c.consumerGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	cons := cg.consumer(consumer, now)
+	claimed := []StreamEntry{}
+
+	for _, id := range ids {
+		pe, pending := cg.pending[id]
+		if !pending || now.Sub(pe.deliveredAt) < minIdle {
+			continue
+		}
+
+		previous := cg.consumers[pe.consumer]
+		entry, found := stream.lookup(id)
+		if !found {
+			if previous != nil {
+				previous.pending--
+			}
+			delete(cg.pending, id)
+			continue
+		}
+
+		if previous != nil {
+			previous.pending--
+		}
+		cons.pending++
+		pe.consumer = consumer
+		pe.deliveredAt = now
+		if !justID {
+			pe.deliveries++
+		}
+
+		if justID {
+			entry = StreamEntry{ID: id}
+		}
+		claimed = append(claimed, entry)
+	}
+	c.touch(key)
+	return claimed, nil
+}
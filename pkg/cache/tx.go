@@ -0,0 +1,193 @@
+package cache
+
+import "time"
+
+// nopLocker is the lock of a transaction view, whose operations run while
+// the transaction holds the cache lock.
+type nopLocker struct{}
+
+func (nopLocker) Lock()    {}
+func (nopLocker) Unlock()  {}
+func (nopLocker) RLock()   {}
+func (nopLocker) RUnlock() {}
+
+// touch assigns a new version to key after a modification and reports it
+// to the change handler. Callers must hold c.mu for writing.
+//
+// Versions are the time of the modification in microseconds since the Unix
+// epoch, or the last version plus one if the clock hasn't moved on, so that
+// the versions a key has on different nodes order the writes made to them.
+// They stay below 2^53, so that scripts see them exactly.
+func (c *Cache) touch(key string) {
+	c.version = max(c.version+1, uint64(time.Now().UnixMicro()))
+	if value, exists := c.data[key]; exists {
+		value.Version = c.version
+	}
+	if c.changeHandler != nil {
+		c.changeHandler(key)
+	}
+}
+
+// SetChangeHandler registers fn to be called with every key that is
+// created, modified, deleted or expires, replacing any previous handler;
+// nil removes it. Unlike event handlers, it sees every change regardless of
+// the enabled event classes. fn runs while the cache lock is held and must
+// not call back into the cache.
+//
+// Example:
+//
+//	cache.SetChangeHandler(func(key string) {
+//		dirty.Add(key) // Replicate the key later
+//	})
+func (c *Cache) SetChangeHandler(fn func(key string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.changeHandler = fn
+}
+
+// SetExpiryHandler registers fn to be called with every key that expires,
+// replacing any previous handler; nil removes it. fn runs right before the
+// change handler is called for the expiry, while the cache lock is held,
+// and must not call back into the cache.
+//
+// Example:
+//
+//	cache.SetExpiryHandler(func(key string) {
+//		replicas.Send("DEL", key) // Expire the key on replicas too
+//	})
+func (c *Cache) SetExpiryHandler(fn func(key string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expiryHandler = fn
+}
+
+// versionOf returns the version of the live value at key, or 0 if there is
+// none. Callers must hold c.mu.
+func (c *Cache) versionOf(key string) uint64 {
+	value, exists := c.data[key]
+	if !exists || c.expiredKey(key, value) {
+		return 0
+	}
+	return value.Version
+}
+
+// Version returns the version of the value at key, or 0 if the key doesn't
+// exist. Every modification of a key, including its expiry, gives it a new,
+// larger version.
+//
+// Example:
+//
+//	version := cache.Version("inventory:42")
+func (c *Cache) Version(key string) uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.versionOf(key)
+}
+
+// GetWithVersion retrieves a string value together with its version, for
+// a later CompareAndSet. Like Get, it reports false for missing keys and
+// values of other types.
+//
+// Example:
+//
+//	value, version, exists := cache.GetWithVersion("config")
+func (c *Cache) GetWithVersion(key string) (string, uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	value, exists := c.data[key]
+	if !exists || c.expiredKey(key, value) || value.Type != TypeString {
+		return "", 0, false
+	}
+	str, ok := value.Data.(string)
+	if !ok {
+		return "", 0, false
+	}
+	return str, value.Version, true
+}
+
+// CompareAndSet stores a string value like Set, but only if the version of
+// key is still version. Version 0 means that the key must not exist.
+//
+// Example:
+//
+//	value, version, _ := cache.GetWithVersion("config")
+//	if newVersion, ok := cache.CompareAndSet("config", update(value), version, 0); !ok {
+//		// Someone else changed the key; read it again and retry
+//	}
+//
+// Returns:
+//   - The new version of the key if the value was stored
+//   - False if the version did not match and nothing was written
+func (c *Cache) CompareAndSet(key, val string, version uint64, ttl time.Duration) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(key)
+	if c.versionOf(key) != version {
+		return 0, false
+	}
+	c.set(key, val, ttl)
+	return c.versionOf(key), true
+}
+
+// CompareAndSetVersion stores a string value like CompareAndSet, but gives
+// it newVersion instead of a new version. newVersion must be above version.
+// Read repair uses it to copy a value from another replica together with
+// its version there, so the copy doesn't look newer than later writes.
+//
+// Example:
+//
+//	// value has version 1700000000000000 on the replica it was read from
+//	ok := cache.CompareAndSetVersion("config", value, 0, 1700000000000000, 0)
+//
+// Returns:
+//   - False if the version did not match or newVersion is not above it, and
+//     nothing was written
+func (c *Cache) CompareAndSetVersion(key, val string, version, newVersion uint64, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireIfDue(key)
+	if newVersion <= version || c.versionOf(key) != version {
+		return false
+	}
+	c.set(key, val, ttl)
+	c.data[key].Version = newVersion
+	c.version = max(c.version, newVersion)
+	return true
+}
+
+// Transaction runs fn atomically: no other operation on the cache runs
+// until fn returns. fn receives a view of the cache that must be used for
+// all operations inside the transaction and must not be retained.
+//
+// watched maps keys to versions previously obtained with Version. If any of
+// them has changed, fn is not run and Transaction returns false. A missing
+// key has version 0, so creating and then deleting a watched key that did
+// not exist goes unnoticed.
+//
+// Example:
+//
+//	watched := map[string]uint64{"inventory:42": cache.Version("inventory:42")}
+//	// ... read the key and decide ...
+//	ok := cache.Transaction(watched, func(tx *cache.Cache) {
+//		tx.IncrBy("inventory:42", -1)
+//		tx.RPush("audit", "sold 42")
+//	})
+func (c *Cache) Transaction(watched map[string]uint64, fn func(tx *Cache)) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, version := range watched {
+		if c.versionOf(key) != version {
+			return false
+		}
+	}
+
+	fn(&Cache{store: c.store, mu: nopLocker{}})
+	return true
+}
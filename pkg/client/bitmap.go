@@ -0,0 +1,197 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/cachemir/cachemir/pkg/cache"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// SetBit sets or clears the bit at offset in the string stored at key.
+// The string grows as needed; bit 0 is the most significant bit of the first byte.
+//
+// Example:
+//
+//	// Mark user 42 as active today
+//	previous, err := client.SetBit("dau:2024-01-01", 42, 1)
+//
+// Parameters:
+//   - key: The bitmap key
+//   - offset: Bit offset
+//   - value: Bit value to store (0 or 1)
+//
+// Returns:
+//   - The previous value of the bit
+//   - Error if the operation fails
+func (c *Client) SetBit(key string, offset int64, value int) (int64, error) {
+	args := []string{strconv.FormatInt(offset, 10), strconv.Itoa(value)}
+	return c.executeInt64CommandWithArgs(protocol.CmdSetBit, key, args)
+}
+
+// GetBit returns the bit at offset in the string stored at key.
+// Offsets beyond the end of the string and missing keys read as 0.
+//
+// Example:
+//
+//	active, err := client.GetBit("dau:2024-01-01", 42)
+//
+// Parameters:
+//   - key: The bitmap key
+//   - offset: Bit offset
+//
+// Returns:
+//   - The bit value (0 or 1)
+//   - Error if the operation fails
+func (c *Client) GetBit(key string, offset int64) (int64, error) {
+	return c.executeInt64CommandWithArgs(protocol.CmdGetBit, key, []string{strconv.FormatInt(offset, 10)})
+}
+
+// BitCount counts the set bits in the string stored at key.
+// Optionally pass an inclusive start and end byte index; negative
+// indexes count from the end of the string.
+//
+// Example:
+//
+//	activeUsers, err := client.BitCount("dau:2024-01-01")
+//
+// Parameters:
+//   - key: The bitmap key
+//   - bounds: Optional start and end byte indexes
+//
+// Returns:
+//   - Number of set bits
+//   - Error if the operation fails
+func (c *Client) BitCount(key string, bounds ...int64) (int64, error) {
+	return c.executeInt64CommandWithArgs(protocol.CmdBitCount, key, formatInt64s(bounds))
+}
+
+// BitPos returns the position of the first bit equal to bit in the string
+// stored at key, optionally restricted to a start and end byte index.
+// Returns -1 if no such bit exists.
+//
+// Example:
+//
+//	firstActive, err := client.BitPos("dau:2024-01-01", 1)
+//
+// Parameters:
+//   - key: The bitmap key
+//   - bit: The bit value to search for (0 or 1)
+//   - bounds: Optional start and end byte indexes
+//
+// Returns:
+//   - Bit position of the first match, or -1
+//   - Error if the operation fails
+func (c *Client) BitPos(key string, bit int, bounds ...int64) (int64, error) {
+	args := append([]string{strconv.Itoa(bit)}, formatInt64s(bounds)...)
+	return c.executeInt64CommandWithArgs(protocol.CmdBitPos, key, args)
+}
+
+// BitOp performs a bitwise operation (AND, OR, XOR or NOT) between source keys
+// and stores the result in destKey.
+//
+// When all keys live on the same node the operation runs atomically on that
+// server. Otherwise the client fetches every source bitmap, combines them
+// locally and writes the result to the destination node; this fallback is
+// not atomic with respect to concurrent writers.
+//
+// Example:
+//
+//	// Users active on both days
+//	length, err := client.BitOp("AND", "dau:both", "dau:2024-01-01", "dau:2024-01-02")
+//
+// Parameters:
+//   - op: Operation name: AND, OR, XOR or NOT
+//   - destKey: Key that receives the result
+//   - srcKeys: Source bitmap keys
+//
+// Returns:
+//   - Length in bytes of the resulting string
+//   - Error if the operation fails
+func (c *Client) BitOp(op, destKey string, srcKeys ...string) (int64, error) {
+	bitOp, err := cache.ParseBitOperation(op)
+	if err != nil {
+		return 0, err
+	}
+	if len(srcKeys) == 0 {
+		return 0, fmt.Errorf("BITOP requires at least one source key")
+	}
+
+	if c.sameNode(append([]string{destKey}, srcKeys...)...) {
+		args := append([]string{bitOp.String()}, srcKeys...)
+		return c.executeInt64CommandWithArgs(protocol.CmdBitOp, destKey, args)
+	}
+
+	if bitOp == cache.BitOpNot && len(srcKeys) != 1 {
+		return 0, fmt.Errorf("BITOP NOT must be called with a single source key")
+	}
+
+	sources := make([]string, len(srcKeys))
+	maxLen := 0
+	for i, key := range srcKeys {
+		str, getErr := c.getOptional(key)
+		if getErr != nil {
+			return 0, getErr
+		}
+		sources[i] = str
+		if len(str) > maxLen {
+			maxLen = len(str)
+		}
+	}
+
+	if maxLen == 0 {
+		_, err = c.Del(destKey)
+		return 0, err
+	}
+
+	result := cache.ApplyBitOp(bitOp, maxLen, sources)
+	if err := c.Set(destKey, string(result), 0); err != nil {
+		return 0, err
+	}
+	return int64(len(result)), nil
+}
+
+// getOptional fetches a string value, returning an empty string for missing keys.
+func (c *Client) getOptional(key string) (string, error) {
+	resp, err := c.executeCommand(&protocol.Command{Type: protocol.CmdGet, Key: key})
+	if err != nil {
+		return "", err
+	}
+
+	switch resp.Type {
+	case protocol.RespNil:
+		return "", nil
+	case protocol.RespError:
+		return "", fmt.Errorf("server error: %s", resp.Error)
+	case protocol.RespString:
+		if str, ok := resp.Data.(string); ok {
+			return str, nil
+		}
+		return "", fmt.Errorf("response data is not a string")
+	default:
+		return "", fmt.Errorf("unexpected response type")
+	}
+}
+
+// sameNode reports whether all keys are routed to the same node.
+func (c *Client) sameNode(keys ...string) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	node := c.route(keys[0])
+	for _, key := range keys[1:] {
+		if c.route(key) != node {
+			return false
+		}
+	}
+	return true
+}
+
+// formatInt64s converts integers into command arguments.
+func formatInt64s(values []int64) []string {
+	args := make([]string, len(values))
+	for i, v := range values {
+		args[i] = strconv.FormatInt(v, 10)
+	}
+	return args
+}
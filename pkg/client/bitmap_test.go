@@ -0,0 +1,57 @@
+package client
+
+import "testing"
+
+func TestBitOpAcrossNodes(t *testing.T) {
+	_, addrA := startServer(t)
+	_, addrB := startServer(t)
+	c := newTestClient(t, []string{addrA, addrB}, nil)
+	a, b := newTestClient(t, []string{addrA}, nil), newTestClient(t, []string{addrB}, nil)
+
+	tagA, tagB := "{"+keyOwnedBy(t, c, addrA)+"}", "{"+keyOwnedBy(t, c, addrB)+"}"
+	long, short, dest := tagA+":long", tagB+":short", tagB+":dest"
+	if err := a.Set(long, "\xff\x0f", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := b.Set(short, "\xf0", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	// The shorter source is padded with zero bytes.
+	tests := []struct {
+		op      string
+		sources []string
+		want    string
+	}{
+		{"AND", []string{long, short}, "\xf0\x00"},
+		{"OR", []string{long, short}, "\xff\x0f"},
+		{"XOR", []string{long, short}, "\x0f\x0f"},
+		{"XOR", []string{short, long, tagA + ":missing"}, "\x0f\x0f"},
+		{"NOT", []string{long}, "\x00\xf0"},
+	}
+	for _, tt := range tests {
+		n, err := c.BitOp(tt.op, dest, tt.sources...)
+		if err != nil || n != int64(len(tt.want)) {
+			t.Errorf("%s %v: expected length %d, got %d (%v)", tt.op, tt.sources, len(tt.want), n, err)
+			continue
+		}
+		if value, err := b.Get(dest); err != nil || value != tt.want {
+			t.Errorf("%s %v: expected %q on the destination's owner, got %q (%v)", tt.op, tt.sources, tt.want, value, err)
+		}
+	}
+	if exists, err := a.Exists(dest); err != nil || exists {
+		t.Errorf("Expected the result only on the destination's owner, got %v (%v)", exists, err)
+	}
+
+	if _, err := c.BitOp("NOT", dest, long, short); err == nil {
+		t.Error("Expected NOT with two sources to fail")
+	}
+
+	// Missing sources give an empty result, which deletes the destination.
+	if n, err := c.BitOp("OR", dest, tagA+":missing", tagB+":missing"); err != nil || n != 0 {
+		t.Errorf("Expected an empty result, got %d (%v)", n, err)
+	}
+	if exists, err := b.Exists(dest); err != nil || exists {
+		t.Errorf("Expected the destination to be deleted, got %v (%v)", exists, err)
+	}
+}
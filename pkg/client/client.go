@@ -63,6 +63,7 @@ import (
 	"time"
 
 	"github.com/cachemir/cachemir/pkg/config"
+	"github.com/cachemir/cachemir/pkg/discovery"
 	"github.com/cachemir/cachemir/pkg/hash"
 	"github.com/cachemir/cachemir/pkg/protocol"
 )
@@ -85,9 +86,26 @@ import (
 //	client.Set("session:abc", "data", 0) // May go to server2
 type Client struct {
 	config *config.ClientConfig       // Client configuration
-	ring   *hash.ConsistentHash       // Consistent hash ring for node selection
+	ring   hash.Ring                  // Hash ring for node selection
 	pools  map[string]*ConnectionPool // Connection pools per node
-	mu     sync.RWMutex               // Protects the pools map
+	mu     sync.RWMutex               // Protects the pools, pubsubs and epochs maps and previous
+
+	previous hash.Ring // Ring before the resharding in progress; nil otherwise
+
+	pubsubs map[*PubSub]struct{} // Open subscriptions, moved on ring changes
+
+	near *nearCache // In-process cache of Get values; nil unless NearCacheSize is set
+
+	sentinels *Client           // Client for the sentinels, if configured
+	epochs    map[string]uint64 // Failover epoch of each node's address
+	done      chan struct{}     // Closed by Close to stop following sentinels and probing nodes
+
+	healthMu    sync.Mutex                           // Protects health and healthHooks
+	health      map[string]*nodeHealth               // Nodes with recent failures
+	healthHooks []func(node string, state NodeState) // Called when a node goes down or up
+
+	clusterMu        sync.Mutex // Serializes topology refreshes after MOVED redirections
+	clusterRefreshed time.Time  // Time of the last refresh
 }
 
 // ConnectionPool manages a pool of connections to a single server node.
@@ -101,9 +119,19 @@ type ConnectionPool struct {
 	connections chan net.Conn // Pool of available connections
 	address     string        // Server address (host:port)
 	connTimeout time.Duration // Timeout for creating new connections
-	mu          sync.Mutex    // Protects the created counter
+	mu          sync.Mutex    // Protects the created counter and closed flag
 	maxConns    int           // Maximum number of connections
 	created     int           // Number of connections created
+	closed      bool          // Set by Close
+
+	onConnect func(conn net.Conn) error // Prepares new connections, if set
+}
+
+// pooledConn is a connection created by a ConnectionPool, so that it is
+// only ever returned to that pool.
+type pooledConn struct {
+	net.Conn
+	pool *ConnectionPool
 }
 
 // New creates a new Client connected to the specified server nodes.
@@ -165,26 +193,68 @@ func NewWithConfig(cfg *config.ClientConfig) *Client {
 
 	client := &Client{
 		config: cfg,
-		ring:   hash.New(cfg.VirtualNodes),
+		ring:   newRing(cfg),
 		pools:  make(map[string]*ConnectionPool),
+
+		pubsubs: make(map[*PubSub]struct{}),
+		epochs:  make(map[string]uint64),
+		done:    make(chan struct{}),
+
+		health: make(map[string]*nodeHealth),
 	}
 
 	for _, node := range cfg.Nodes {
-		client.ring.AddNode(node)
-		client.pools[node] = &ConnectionPool{
-			address:     node,
-			connections: make(chan net.Conn, cfg.MaxConnsPerNode),
-			maxConns:    cfg.MaxConnsPerNode,
-			connTimeout: time.Duration(cfg.ConnTimeout) * time.Second,
+		addr, weight, _ := config.ParseNode(node) // Checked by Validate
+		client.ring.AddNodeWithWeight(addr, weight)
+		client.pools[addr] = client.newPool(addr)
+	}
+
+	if cfg.NearCacheSize > 0 {
+		client.near = newNearCache(client)
+	}
+
+	if len(cfg.Sentinels) > 0 {
+		client.followSentinels()
+	}
+
+	if cfg.Discovery != "" {
+		d, _ := discovery.Parse(cfg.Discovery) // Checked by Validate
+		if err := client.Discover(d, time.Duration(cfg.DiscoveryInterval)*time.Second); err != nil {
+			log.Printf("Discovery: failed to load nodes: %v", err)
 		}
 	}
 
 	return client
 }
 
+// newRing creates an empty ring with the configured placement.
+func newRing(cfg *config.ClientConfig) hash.Ring {
+	ring, err := hash.NewRing(cfg.Ring())
+	if err != nil {
+		panic(fmt.Sprintf("invalid client config: %v", err)) // Checked by Validate
+	}
+	return ring
+}
+
+// newPool creates an empty connection pool for address. With a near
+// cache, its connections enable key tracking.
+func (c *Client) newPool(address string) *ConnectionPool {
+	pool := &ConnectionPool{
+		address:     address,
+		connections: make(chan net.Conn, c.config.MaxConnsPerNode),
+		maxConns:    c.config.MaxConnsPerNode,
+		connTimeout: time.Duration(c.config.ConnTimeout) * time.Second,
+	}
+	if c.config.NearCacheSize > 0 {
+		pool.onConnect = c.enableTracking
+	}
+	return pool
+}
+
 // AddNode dynamically adds a new server node to the cluster.
 // The node is added to the consistent hash ring and a connection pool is created.
 // Existing keys may be redistributed to the new node according to consistent hashing.
+// Their values stay on their previous nodes; use Reshard to move them along.
 //
 // This operation is thread-safe and can be called while the client is in use.
 // It's useful for scaling up the cluster or replacing failed nodes.
@@ -201,23 +271,42 @@ func NewWithConfig(cfg *config.ClientConfig) *Client {
 // Parameters:
 //   - address: Server address in "host:port" format
 func (c *Client) AddNode(address string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.addNode(address, c.ring.AddNode)
+}
+
+// AddNodeWithWeight adds a server node that owns a share of the keys
+// proportional to weight, relative to the nodes of weight 1 added by
+// AddNode, or changes the weight of a node already in the ring. Keys that
+// change node keep their values on their previous nodes; use Reshard to
+// move them along.
+//
+// Example:
+//
+//	// A 64GB server next to 8GB ones
+//	client.AddNodeWithWeight("large:8080", 8)
+func (c *Client) AddNodeWithWeight(address string, weight int) {
+	c.addNode(address, func(node string) { c.ring.AddNodeWithWeight(node, weight) })
+}
 
-	c.ring.AddNode(address)
+// addNode adds address to the ring with add, and creates its pool.
+func (c *Client) addNode(address string, add func(node string)) {
+	c.mu.Lock()
+	add(address)
 	if _, exists := c.pools[address]; !exists {
-		c.pools[address] = &ConnectionPool{
-			address:     address,
-			connections: make(chan net.Conn, c.config.MaxConnsPerNode),
-			maxConns:    c.config.MaxConnsPerNode,
-			connTimeout: time.Duration(c.config.ConnTimeout) * time.Second,
-		}
+		c.pools[address] = c.newPool(address)
+	}
+	pubsubs := c.openPubSubs()
+	c.mu.Unlock()
+
+	for _, ps := range pubsubs {
+		ps.reshard()
 	}
 }
 
 // RemoveNode dynamically removes a server node from the cluster.
 // The node is removed from the consistent hash ring and its connection pool is closed.
 // Keys previously assigned to this node will be redistributed to remaining nodes.
+// Their values are dropped with the node; use Reshard to move them along.
 //
 // This operation is thread-safe and can be called while the client is in use.
 // It's useful for handling node failures or scaling down the cluster.
@@ -235,22 +324,51 @@ func (c *Client) AddNode(address string) {
 //   - address: Server address to remove
 func (c *Client) RemoveNode(address string) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	c.ring.RemoveNode(address)
 	if pool, exists := c.pools[address]; exists {
 		pool.Close()
 		delete(c.pools, address)
 	}
+	pubsubs := c.openPubSubs()
+	c.mu.Unlock()
+
+	for _, ps := range pubsubs {
+		ps.reshard()
+	}
 }
 
-// getConnection obtains a connection to the server responsible for the given key.
-// It uses consistent hashing to determine the target node, then gets a connection
-// from that node's connection pool.
+// Nodes returns the nodes currently in the ring. Nodes are named by their
+// address, except for primaries learned from sentinels, which are named as
+// the sentinels name them.
+func (c *Client) Nodes() []string {
+	return c.ring.GetNodes()
+}
+
+// nodeAddress returns the current address of node.
+func (c *Client) nodeAddress(node string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if pool, exists := c.pools[node]; exists {
+		return pool.address
+	}
+	return node
+}
+
+// openPubSubs returns the open subscriptions. Callers must hold c.mu.
+func (c *Client) openPubSubs() []*PubSub {
+	pubsubs := make([]*PubSub, 0, len(c.pubsubs))
+	for ps := range c.pubsubs {
+		pubsubs = append(pubsubs, ps)
+	}
+	return pubsubs
+}
+
+// getConnection obtains a connection to the given node from its connection pool.
+// Callers typically pick the node with consistent hashing on the command's key.
 //
 // Returns an error if no nodes are available or if connection establishment fails.
-func (c *Client) getConnection(key string) (net.Conn, error) {
-	node := c.ring.GetNode(key)
+func (c *Client) getConnection(node string) (net.Conn, error) {
 	if node == "" {
 		return nil, fmt.Errorf("no available nodes")
 	}
@@ -266,23 +384,15 @@ func (c *Client) getConnection(key string) (net.Conn, error) {
 	return pool.Get()
 }
 
-// returnConnection returns a connection to the appropriate connection pool.
-// The connection is determined by the key's assigned node in the hash ring.
-// If the node is no longer available, the connection is closed.
-func (c *Client) returnConnection(key string, conn net.Conn) {
-	node := c.ring.GetNode(key)
-	if node == "" {
-		if err := conn.Close(); err != nil {
-			log.Printf("Error closing connection: %v", err)
-		}
-		return
-	}
-
+// returnConnection returns a connection to the pool of the node it was
+// obtained from. If the node has been removed or has moved to another
+// address since, the connection is closed.
+func (c *Client) returnConnection(node string, conn net.Conn) {
 	c.mu.RLock()
 	pool, exists := c.pools[node]
 	c.mu.RUnlock()
 
-	if exists {
+	if pc, ok := conn.(*pooledConn); ok && exists && pc.pool == pool {
 		pool.Put(conn)
 	} else {
 		if err := conn.Close(); err != nil {
@@ -291,61 +401,148 @@ func (c *Client) returnConnection(key string, conn net.Conn) {
 	}
 }
 
+// closeConnection closes a broken connection instead of returning it to
+// its pool, freeing its slot there.
+func (c *Client) closeConnection(conn net.Conn) {
+	if pc, ok := conn.(*pooledConn); ok {
+		pc.pool.discard(conn)
+		return
+	}
+	if err := conn.Close(); err != nil {
+		log.Printf("Error closing connection: %v", err)
+	}
+}
+
 // executeCommand executes a command against the appropriate server node with retry logic.
 // It automatically selects the correct node based on the command's key, handles
 // network errors with retries, and manages connection lifecycle.
 //
 // The method implements the following retry strategy:
-//  1. Determine target node using consistent hashing
+//  1. Determine target node using consistent hashing, skipping nodes that are down
 //  2. Get connection from node's connection pool
 //  3. Send command and read response
 //  4. Return connection to pool on success
-//  5. Close connection and retry on failure
+//  5. Close connection, count a failure for the node and retry
 //  6. Return error after exhausting retry attempts
+//
+// A node is marked down after FailureThreshold consecutive failures, so
+// that later attempts go to the next node on the ring (see route). A MOVED
+// redirection from a server in cluster mode updates the ring from the
+// cluster's members, and the next attempt goes to the node it names, up to
+// maxRedirects times.
+//
+// With a ReplicationFactor above 1, string commands are sent to all
+// replicas of their key instead (see executeReplicated). While resharding,
+// commands on keys that changed owner also involve the previous owner (see
+// executeResharded).
+//
+// With a near cache, commands other than GET drop their key from it once
+// they have run (see nearCache).
 func (c *Client) executeCommand(cmd *protocol.Command) (*protocol.Response, error) {
+	if c.near != nil && cmd.Type != protocol.CmdGet && cmd.Key != "" {
+		defer c.near.invalidate(cmd.Key)
+	}
+	if previous := c.previousOwner(cmd.Key); previous != "" {
+		return c.executeResharded(cmd, previous)
+	}
+	if c.config.ReplicationFactor > 1 {
+		if kind, replicated := replicatedCommands[cmd.Type]; replicated {
+			return c.executeReplicated(cmd, kind)
+		}
+	}
+	return c.executeCommandWithTimeout(cmd, time.Duration(c.config.ReadTimeout)*time.Second)
+}
+
+// executeCommandWithTimeout is executeCommand with an explicit read timeout,
+// used by blocking commands that may legitimately wait longer than the
+// configured ReadTimeout. A zero timeout waits indefinitely for the response.
+func (c *Client) executeCommandWithTimeout(cmd *protocol.Command, readTimeout time.Duration) (*protocol.Response, error) {
+	return c.executeOnNode("", cmd, readTimeout)
+}
+
+// executeOnNode executes a command on a specific node with the usual retry
+// logic. An empty node means the node owning cmd.Key, looked up on every
+// attempt so that retries follow ring changes.
+func (c *Client) executeOnNode(node string, cmd *protocol.Command, readTimeout time.Duration) (*protocol.Response, error) {
 	var lastErr error
+	var redirect string
+	redirects := 0
 
 	for attempt := 0; attempt <= c.config.RetryAttempts; attempt++ {
-		conn, err := c.getConnection(cmd.Key)
+		target := node
+		switch {
+		case target != "":
+		case redirect != "":
+			target = redirect
+		default:
+			target = c.route(cmd.Key)
+		}
+
+		resp, err := c.roundTrip(target, cmd, readTimeout)
 		if err != nil {
 			lastErr = err
 			continue
 		}
 
-		writeDeadline := time.Now().Add(time.Duration(c.config.WriteTimeout) * time.Second)
-		if writeErr := conn.SetWriteDeadline(writeDeadline); writeErr != nil {
-			c.returnConnection(cmd.Key, conn)
-			lastErr = writeErr
-			continue
-		}
-		if writeErr := protocol.WriteCommand(conn, cmd); writeErr != nil {
-			if closeErr := conn.Close(); closeErr != nil {
-				log.Printf("Error closing connection: %v", closeErr)
-			}
-			lastErr = writeErr
+		c.recordSuccess(target)
+		if moved := movedTarget(resp); moved != "" && node == "" && redirects < maxRedirects {
+			c.followMoved(moved)
+			redirect = moved
+			redirects++
+			attempt-- // Redirections don't count as attempts
 			continue
 		}
+		return resp, nil
+	}
 
-		readDeadline := time.Now().Add(time.Duration(c.config.ReadTimeout) * time.Second)
-		if readErr := conn.SetReadDeadline(readDeadline); readErr != nil {
-			c.returnConnection(cmd.Key, conn)
-			lastErr = readErr
-			continue
-		}
-		resp, err := protocol.ReadResponse(conn)
-		if err != nil {
-			if closeErr := conn.Close(); closeErr != nil {
-				log.Printf("Error closing connection: %v", closeErr)
-			}
-			lastErr = err
-			continue
+	return nil, fmt.Errorf("command failed after %d attempts: %v", c.config.RetryAttempts+1, lastErr)
+}
+
+// roundTrip sends a command to node over a pooled connection and reads
+// the response, counting failures towards marking node down. With a
+// load-aware placement, node counts as loaded until the response is read.
+func (c *Client) roundTrip(node string, cmd *protocol.Command, readTimeout time.Duration) (*protocol.Response, error) {
+	if tracker, ok := c.ring.(hash.LoadTracker); ok {
+		tracker.Acquire(node)
+		defer tracker.Release(node)
+	}
+
+	conn, err := c.getConnection(node)
+	if err != nil {
+		if isDialError(err) {
+			c.recordFailure(node)
 		}
+		return nil, err
+	}
 
-		c.returnConnection(cmd.Key, conn)
-		return resp, nil
+	writeDeadline := time.Now().Add(time.Duration(c.config.WriteTimeout) * time.Second)
+	if err := conn.SetWriteDeadline(writeDeadline); err != nil {
+		c.returnConnection(node, conn)
+		return nil, err
+	}
+	if err := protocol.WriteCommand(conn, cmd); err != nil {
+		c.closeConnection(conn)
+		c.recordFailure(node)
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("command failed after %d attempts: %v", c.config.RetryAttempts+1, lastErr)
+	var readDeadline time.Time
+	if readTimeout > 0 {
+		readDeadline = time.Now().Add(readTimeout)
+	}
+	if err := conn.SetReadDeadline(readDeadline); err != nil {
+		c.returnConnection(node, conn)
+		return nil, err
+	}
+	resp, err := protocol.ReadResponse(conn)
+	if err != nil {
+		c.closeConnection(conn)
+		c.recordFailure(node)
+		return nil, err
+	}
+
+	c.returnConnection(node, conn)
+	return resp, nil
 }
 
 // Get retrieves the string value of a key.
@@ -364,10 +561,18 @@ func (c *Client) executeCommand(cmd *protocol.Command) (*protocol.Response, erro
 // Parameters:
 //   - key: The key to retrieve
 //
+// With a NearCacheSize, values are served from the near cache while it
+// holds them (see nearCache).
+//
 // Returns:
 //   - The string value if found
 //   - Error if key doesn't exist or operation fails
 func (c *Client) Get(key string) (string, error) {
+	if c.near != nil {
+		return c.near.get(key, func() (string, time.Duration, error) {
+			return c.getWithTTL(key)
+		})
+	}
 	return c.executeStringCommand(protocol.CmdGet, key, "key not found")
 }
 
@@ -544,6 +749,26 @@ func (c *Client) executeInt64CommandWithArgs(cmdType protocol.CommandType, key s
 	return 0, fmt.Errorf("response data is not an int64")
 }
 
+// executeOKCommandWithArgs executes a command with arguments that only reports success or failure
+func (c *Client) executeOKCommandWithArgs(cmdType protocol.CommandType, key string, args []string) error {
+	cmd := &protocol.Command{
+		Type: cmdType,
+		Key:  key,
+		Args: args,
+	}
+
+	resp, err := c.executeCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	if resp.Type == protocol.RespError {
+		return fmt.Errorf("server error: %s", resp.Error)
+	}
+
+	return nil
+}
+
 func (c *Client) Del(key string) (bool, error) {
 	return c.executeBoolCommand(protocol.CmdDel, key)
 }
@@ -1058,6 +1283,18 @@ func (c *Client) Ping() error {
 // Returns:
 //   - Error if there was a problem closing connections (usually nil)
 func (c *Client) Close() error {
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	if c.sentinels != nil {
+		c.sentinels.Close() //nolint:errcheck,gosec // Close never fails
+	}
+	if c.near != nil {
+		c.near.close()
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -1073,10 +1310,17 @@ func (c *Client) Close() error {
 // If the pool is full and at capacity, it waits for an available connection.
 func (cp *ConnectionPool) Get() (net.Conn, error) {
 	select {
-	case conn := <-cp.connections:
+	case conn, ok := <-cp.connections:
+		if !ok {
+			return nil, fmt.Errorf("connection pool closed")
+		}
 		return conn, nil
 	default:
 		cp.mu.Lock()
+		if cp.closed {
+			cp.mu.Unlock()
+			return nil, fmt.Errorf("connection pool closed")
+		}
 		if cp.created < cp.maxConns {
 			cp.created++
 			cp.mu.Unlock()
@@ -1089,12 +1333,21 @@ func (cp *ConnectionPool) Get() (net.Conn, error) {
 				cp.mu.Unlock()
 				return nil, err
 			}
-			return conn, nil
+			if cp.onConnect != nil {
+				if err := cp.onConnect(conn); err != nil {
+					cp.discard(conn)
+					return nil, err
+				}
+			}
+			return &pooledConn{Conn: conn, pool: cp}, nil
 		}
 		cp.mu.Unlock()
 
 		select {
-		case conn := <-cp.connections:
+		case conn, ok := <-cp.connections:
+			if !ok {
+				return nil, fmt.Errorf("connection pool closed")
+			}
 			return conn, nil
 		case <-time.After(cp.connTimeout):
 			return nil, fmt.Errorf("connection pool timeout")
@@ -1103,24 +1356,64 @@ func (cp *ConnectionPool) Get() (net.Conn, error) {
 }
 
 // Put returns a connection to the pool for reuse.
-// If the pool is full, the connection is closed instead of being stored.
+// If the pool is full or closed, the connection is closed instead of being stored.
 func (cp *ConnectionPool) Put(conn net.Conn) {
-	select {
-	case cp.connections <- conn:
-	default:
-		if err := conn.Close(); err != nil {
-			log.Printf("Error closing connection: %v", err)
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if !cp.closed {
+		select {
+		case cp.connections <- conn:
+			return
+		default:
+		}
+	}
+	if err := conn.Close(); err != nil {
+		log.Printf("Error closing connection: %v", err)
+	}
+	cp.created--
+}
+
+// discard closes a connection obtained from the pool that is broken,
+// making room for a new one.
+func (cp *ConnectionPool) discard(conn net.Conn) {
+	if err := conn.Close(); err != nil {
+		log.Printf("Error closing connection: %v", err)
+	}
+
+	cp.mu.Lock()
+	cp.created--
+	cp.mu.Unlock()
+}
+
+// drain closes the idle connections of the pool, which are likely broken
+// once their node has failed.
+func (cp *ConnectionPool) drain() {
+	for {
+		select {
+		case conn, ok := <-cp.connections:
+			if !ok {
+				return
+			}
+			cp.discard(conn)
+		default:
+			return
 		}
-		cp.mu.Lock()
-		cp.created--
-		cp.mu.Unlock()
 	}
 }
 
 // Close shuts down the connection pool by closing all pooled connections.
-// This is called when a node is removed or the client is shut down.
+// This is called when a node is removed or moves, or the client is shut down.
 func (cp *ConnectionPool) Close() {
+	cp.mu.Lock()
+	if cp.closed {
+		cp.mu.Unlock()
+		return
+	}
+	cp.closed = true
 	close(cp.connections)
+	cp.mu.Unlock()
+
 	for conn := range cp.connections {
 		if err := conn.Close(); err != nil {
 			log.Printf("Error closing connection: %v", err)
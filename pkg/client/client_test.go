@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cachemir/cachemir/internal/server"
+	"github.com/cachemir/cachemir/pkg/config"
+)
+
+// freePort returns a local TCP port that is free at the time of the call.
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	lc := net.ListenConfig{}
+	l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer l.Close()                     //nolint:errcheck // Only reserved the port
+	return l.Addr().(*net.TCPAddr).Port //nolint:errcheck // TCP listeners have TCP addresses
+}
+
+// startServer starts a server on a free local port and returns it with its
+// address. The server is stopped when the test ends.
+func startServer(t *testing.T) (*server.Server, string) {
+	t.Helper()
+
+	port := freePort(t)
+	s := server.New(port)
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	runServer(t, s, addr)
+	return s, addr
+}
+
+// runServer starts s, listening on addr, and waits until it accepts
+// connections. The server is stopped when the test ends.
+func runServer(t *testing.T, s *server.Server, addr string) {
+	t.Helper()
+
+	go func() {
+		if err := s.Start(); err != nil {
+			t.Errorf("Server failed: %v", err)
+		}
+	}()
+	t.Cleanup(func() { s.Stop() }) //nolint:errcheck,gosec // Nothing to do on failure
+
+	waitFor(t, "server to listen", func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close() //nolint:errcheck,gosec // Only probing
+		return true
+	})
+}
+
+// refuseConnections listens on a free local port and closes every
+// connection as soon as it is accepted, like a node that is up but failing.
+// It returns the address, its port and a function that stops listening.
+func refuseConnections(t *testing.T) (string, int, func()) {
+	t.Helper()
+
+	lc := net.ListenConfig{}
+	l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close() //nolint:errcheck,gosec // Refusing the connection
+		}
+	}()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			l.Close() //nolint:errcheck,gosec // Nothing to do on failure
+			wg.Wait()
+		})
+	}
+	t.Cleanup(stop)
+	return l.Addr().String(), l.Addr().(*net.TCPAddr).Port, stop //nolint:errcheck // TCP listeners have TCP addresses
+}
+
+// newTestClient creates a client for nodes with short timeouts, applying
+// configure to its configuration first if it isn't nil. The client is
+// closed when the test ends.
+func newTestClient(t *testing.T, nodes []string, configure func(cfg *config.ClientConfig)) *Client {
+	t.Helper()
+
+	cfg := config.LoadClientConfig()
+	cfg.Nodes = nodes
+	cfg.ConnTimeout = 1
+	cfg.ReadTimeout = 2
+	cfg.WriteTimeout = 2
+	cfg.RetryAttempts = 1
+	if configure != nil {
+		configure(cfg)
+	}
+	c := NewWithConfig(cfg)
+	t.Cleanup(func() { c.Close() }) //nolint:errcheck,gosec // Nothing to do on failure
+	return c
+}
+
+// waitFor polls cond until it holds, failing the test after 5 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
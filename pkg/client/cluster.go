@@ -0,0 +1,175 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// clusterRefreshInterval is the minimum time between two topology refreshes
+// triggered by MOVED redirections.
+const clusterRefreshInterval = 100 * time.Millisecond
+
+// maxRedirects is the number of MOVED redirections followed by a command
+// before the MOVED error is returned.
+const maxRedirects = 5
+
+// ClusterNode is a member of a server cluster, as reported by CLUSTER NODES.
+type ClusterNode struct {
+	Address   string // Address announced by the server
+	Flags     string // "myself" for the server that answered, "ok" or "fail"
+	Heartbeat uint64 // Latest gossip heartbeat of the server
+}
+
+// ClusterNodes returns the members of the cluster, as seen by the first
+// node that answers.
+//
+// Example:
+//
+//	nodes, err := client.ClusterNodes()
+//	for _, n := range nodes {
+//		fmt.Printf("%s %s\n", n.Address, n.Flags)
+//	}
+func (c *Client) ClusterNodes() ([]ClusterNode, error) {
+	var lastErr error
+	for _, node := range c.ring.GetNodes() {
+		nodes, err := c.clusterNodes(node)
+		if err == nil {
+			return nodes, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no available nodes")
+	}
+	return nil, lastErr
+}
+
+// ClusterMeet makes node join the cluster of peer ("host:port"). The rest
+// of both clusters learns about it by gossip.
+func (c *Client) ClusterMeet(node, peer string) error {
+	return c.clusterMembership(node, "MEET", peer)
+}
+
+// ClusterForget removes peer ("host:port") from the cluster of node, which
+// gossips the removal to the other members. Stop peer first, or it rejoins
+// once the removal expires after a minute.
+func (c *Client) ClusterForget(node, peer string) error {
+	return c.clusterMembership(node, "FORGET", peer)
+}
+
+// clusterMembership sends CLUSTER MEET or FORGET to node.
+func (c *Client) clusterMembership(node, subcommand, peer string) error {
+	host, port, err := net.SplitHostPort(peer)
+	if err != nil {
+		return err
+	}
+
+	cmd := &protocol.Command{Type: protocol.CmdCluster, Args: []string{subcommand, host, port}}
+	resp, err := c.executeOnNode(node, cmd, time.Duration(c.config.ReadTimeout)*time.Second)
+	if err != nil {
+		return err
+	}
+	if resp.Type == protocol.RespError {
+		return fmt.Errorf("server error: %s", resp.Error)
+	}
+	return nil
+}
+
+// clusterNodes sends CLUSTER NODES to node.
+func (c *Client) clusterNodes(node string) ([]ClusterNode, error) {
+	cmd := &protocol.Command{Type: protocol.CmdCluster, Args: []string{"NODES"}}
+	resp, err := c.executeOnNode(node, cmd, time.Duration(c.config.ReadTimeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type == protocol.RespError {
+		return nil, fmt.Errorf("server error: %s", resp.Error)
+	}
+	entries, ok := resp.Data.([]interface{})
+	if resp.Type != protocol.RespNested || !ok {
+		return nil, fmt.Errorf("unexpected response type")
+	}
+
+	nodes := make([]ClusterNode, 0, len(entries))
+	for _, entry := range entries {
+		fields, _ := entry.([]interface{})
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected response data")
+		}
+		address, aok := fields[0].(string)
+		flags, fok := fields[1].(string)
+		heartbeat, hok := fields[2].(int64)
+		if !aok || !fok || !hok {
+			return nil, fmt.Errorf("unexpected response data")
+		}
+		nodes = append(nodes, ClusterNode{Address: address, Flags: flags, Heartbeat: uint64(heartbeat)})
+	}
+	return nodes, nil
+}
+
+// movedTarget returns the node a MOVED error redirects to, or "" if resp
+// isn't one.
+func movedTarget(resp *protocol.Response) string {
+	if resp.Type != protocol.RespError {
+		return ""
+	}
+	target, moved := strings.CutPrefix(resp.Error, "MOVED ")
+	if !moved {
+		return ""
+	}
+	return target
+}
+
+// followMoved handles a MOVED redirection to target: the ring is updated
+// with the members reported by target, so that later commands go straight
+// to the owners of their keys. target is added to the ring first, so that
+// the command can be sent there even if the refresh, which is rate
+// limited, doesn't happen or fails.
+func (c *Client) followMoved(target string) {
+	c.clusterMu.Lock()
+	defer c.clusterMu.Unlock()
+
+	c.mu.RLock()
+	_, exists := c.pools[target]
+	c.mu.RUnlock()
+	if !exists {
+		c.AddNode(target)
+	}
+
+	if time.Since(c.clusterRefreshed) < clusterRefreshInterval {
+		return
+	}
+	c.clusterRefreshed = time.Now()
+	nodes, err := c.clusterNodes(target)
+	if err != nil {
+		log.Printf("Cluster: failed to load nodes from %s: %v", target, err)
+		return
+	}
+	c.setClusterNodes(nodes)
+}
+
+// setClusterNodes makes the ring hold exactly the given cluster members.
+func (c *Client) setClusterNodes(nodes []ClusterNode) {
+	members := make([]string, len(nodes))
+	for i, node := range nodes {
+		members[i] = node.Address
+	}
+
+	current := c.ring.GetNodes()
+	for _, member := range members {
+		if !slices.Contains(current, member) {
+			c.AddNode(member)
+		}
+	}
+	for _, node := range current {
+		if !slices.Contains(members, node) {
+			c.RemoveNode(node)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cachemir/cachemir/internal/server"
+	"github.com/cachemir/cachemir/pkg/config"
+	"github.com/cachemir/cachemir/pkg/hash"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// startClusterServer starts a server in cluster mode, joining through
+// peers, with the ring clients use by default.
+func startClusterServer(t *testing.T, peers ...string) string {
+	t.Helper()
+
+	port := freePort(t)
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	ring, err := hash.NewRing(config.LoadClientConfig().Ring())
+	if err != nil {
+		t.Fatalf("NewRing failed: %v", err)
+	}
+	s := server.New(port)
+	s.EnableCluster(addr, peers, ring)
+	runServer(t, s, addr)
+	return addr
+}
+
+// redirector answers every command with a MOVED error.
+type redirector struct {
+	addr      string
+	commands  atomic.Int64 // Commands other than CLUSTER received
+	refreshes atomic.Int64 // CLUSTER commands received
+}
+
+// startRedirector starts a redirector on a free local port, redirecting to
+// target, or to itself if target is empty. It stops when the test ends.
+func startRedirector(t *testing.T, target string) *redirector {
+	t.Helper()
+
+	lc := net.ListenConfig{}
+	l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() }) //nolint:errcheck,gosec // Nothing to do on failure
+
+	r := &redirector{addr: l.Addr().String()}
+	if target == "" {
+		target = r.addr
+	}
+	moved := &protocol.Response{Type: protocol.RespError, Error: "MOVED " + target}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close() //nolint:errcheck // Nothing to do on failure
+				for {
+					cmd, err := protocol.ReadCommand(conn)
+					if err != nil {
+						return
+					}
+					if cmd.Type == protocol.CmdCluster {
+						r.refreshes.Add(1)
+					} else {
+						r.commands.Add(1)
+					}
+					if err := protocol.WriteResponse(conn, moved); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return r
+}
+
+func TestClientFollowsMoved(t *testing.T) {
+	addrA := startClusterServer(t)
+	addrB := startClusterServer(t, addrA)
+	addrC := startClusterServer(t, addrA)
+	all := []string{addrA, addrB, addrC}
+	slices.Sort(all)
+
+	direct := make(map[string]*Client)
+	for _, addr := range all {
+		direct[addr] = newTestClient(t, []string{addr}, func(cfg *config.ClientConfig) { cfg.RetryAttempts = 0 })
+	}
+	waitFor(t, "gossip to converge", func() bool {
+		for _, addr := range all {
+			nodes, err := direct[addr].ClusterNodes()
+			if err != nil || len(nodes) != len(all) {
+				return false
+			}
+		}
+		return true
+	})
+
+	c := newTestClient(t, []string{addrA}, nil)
+
+	// The first MOVED loads the members, so that every node joins the ring.
+	for i := range 20 {
+		key := fmt.Sprintf("key:%d", i)
+		if err := c.Set(key, "v", 0); err != nil {
+			t.Fatalf("Set %s failed: %v", key, err)
+		}
+	}
+	if nodes := c.Nodes(); !slices.Equal(slices.Sorted(slices.Values(nodes)), all) {
+		t.Fatalf("Expected the ring to hold %v, got %v", all, nodes)
+	}
+
+	// Each key is stored on the owner the client and the servers agree on.
+	for i := range 20 {
+		key := fmt.Sprintf("key:%d", i)
+		if value, err := direct[c.ring.GetNode(key)].Get(key); err != nil || value != "v" {
+			t.Errorf("Expected %s on %s, got %q (%v)", key, c.ring.GetNode(key), value, err)
+		}
+	}
+
+	nodes, err := c.ClusterNodes()
+	if err != nil || len(nodes) != len(all) {
+		t.Errorf("Expected %d cluster nodes, got %v (%v)", len(all), nodes, err)
+	}
+}
+
+func TestMovedRedirectLimit(t *testing.T) {
+	r := startRedirector(t, "")
+	c := newTestClient(t, []string{r.addr}, nil)
+
+	_, err := c.Get("key")
+	if err == nil || !strings.Contains(err.Error(), "MOVED "+r.addr) {
+		t.Fatalf("Expected the MOVED error after %d redirections, got %v", maxRedirects, err)
+	}
+	if n := r.commands.Load(); n != maxRedirects+1 {
+		t.Errorf("Expected %d attempts, got %d", maxRedirects+1, n)
+	}
+
+	// Redirections in a burst refresh the topology once.
+	if n := r.refreshes.Load(); n != 1 {
+		t.Errorf("Expected a single refresh, got %d", n)
+	}
+	time.Sleep(clusterRefreshInterval)
+	c.Get("key") //nolint:errcheck,gosec // Fails the same way
+	if n := r.refreshes.Load(); n != 2 {
+		t.Errorf("Expected another refresh after %v, got %d refreshes", clusterRefreshInterval, n)
+	}
+}
+
+func TestMovedAddsTargetWhenRefreshLimited(t *testing.T) {
+	_, target := startServer(t)
+	r := startRedirector(t, target)
+	c := newTestClient(t, []string{r.addr}, nil)
+	c.clusterRefreshed = time.Now() // A refresh just happened
+
+	if err := c.Set("key", "v", 0); err != nil {
+		t.Fatalf("Expected Set to follow the redirection, got %v", err)
+	}
+	if n := r.refreshes.Load(); n != 0 {
+		t.Errorf("Expected no refresh within %v of the last, got %d", clusterRefreshInterval, n)
+	}
+	if nodes := c.Nodes(); !slices.Contains(nodes, target) {
+		t.Errorf("Expected the target to be added to the ring, got %v", nodes)
+	}
+	if value, err := newTestClient(t, []string{target}, nil).Get("key"); err != nil || value != "v" {
+		t.Errorf("Expected the key on the target, got %q (%v)", value, err)
+	}
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/config"
+	"github.com/cachemir/cachemir/pkg/discovery"
+)
+
+// Discover keeps the nodes of the client in sync with a discovery source:
+// it adds the nodes d returns, and updates their weights or removes them
+// as d reports, now and then every interval until the client is closed.
+// Nodes the client got otherwise, from its configuration, AddNode or
+// sentinels, are left alone.
+//
+// When d fails or returns no nodes, the client keeps its nodes. Discover
+// returns the error of the first lookup, but keeps polling anyway. Added
+// nodes change the owners of some keys, as with AddNode; their values stay
+// on their previous nodes.
+//
+// Example:
+//
+//	err := client.Discover(discovery.NewSRV("_cachemir._tcp.cache.example.com"), 10*time.Second)
+func (c *Client) Discover(d discovery.Discovery, interval time.Duration) error {
+	if interval <= 0 {
+		interval = config.DefaultDiscoveryIntervalSecs * time.Second
+	}
+
+	discovered := make(map[string]bool)
+	err := c.syncDiscovered(d, discovered)
+	go c.watchDiscovery(d, interval, discovered)
+	return err
+}
+
+// watchDiscovery polls d every interval until the client is closed.
+func (c *Client) watchDiscovery(d discovery.Discovery, interval time.Duration, discovered map[string]bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.syncDiscovered(d, discovered); err != nil {
+				log.Printf("Discovery: failed to refresh nodes: %v", err)
+			}
+		}
+	}
+}
+
+// syncDiscovered applies the nodes returned by d. discovered holds the
+// nodes added by earlier calls, the only ones it changes or removes.
+func (c *Client) syncDiscovered(d discovery.Discovery, discovered map[string]bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.config.ReadTimeout)*time.Second)
+	defer cancel()
+
+	specs, err := d.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	weights := make(map[string]int, len(specs))
+	for _, spec := range specs {
+		addr, weight, err := config.ParseNode(spec)
+		if err != nil {
+			return fmt.Errorf("invalid discovered node: %w", err)
+		}
+		weights[addr] = weight
+	}
+	if len(weights) == 0 {
+		return fmt.Errorf("no nodes discovered")
+	}
+
+	// Sorted, so that every client adds new nodes in the same order.
+	addrs := make([]string, 0, len(weights))
+	for addr := range weights {
+		addrs = append(addrs, addr)
+	}
+	slices.Sort(addrs)
+
+	for _, addr := range addrs {
+		current := c.ring.GetWeight(addr)
+		if current == weights[addr] || current > 0 && !discovered[addr] {
+			continue
+		}
+		c.AddNodeWithWeight(addr, weights[addr])
+		discovered[addr] = true
+		log.Printf("Discovery: node %s with weight %d", addr, weights[addr])
+	}
+
+	for addr := range discovered {
+		if _, ok := weights[addr]; !ok {
+			c.RemoveNode(addr)
+			delete(discovered, addr)
+			log.Printf("Discovery: removed node %s", addr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// GeoLocation is a named point, as added with GeoAdd or returned by GeoSearch.
+type GeoLocation struct {
+	Name      string  // Member name
+	Longitude float64 // Longitude in degrees
+	Latitude  float64 // Latitude in degrees
+	Dist      float64 // Distance from the search center in the query unit (GeoSearch only)
+}
+
+// GeoPos is the position of a member.
+type GeoPos struct {
+	Longitude float64
+	Latitude  float64
+}
+
+// GeoSearchQuery describes a GeoSearch. The center is Member if set,
+// otherwise Longitude/Latitude. A positive Radius searches a circle;
+// otherwise BoxWidth and BoxHeight search a box.
+type GeoSearchQuery struct {
+	Member    string  // Center member
+	Longitude float64 // Center longitude (when Member is empty)
+	Latitude  float64 // Center latitude (when Member is empty)
+	Radius    float64 // Circle radius
+	BoxWidth  float64 // Box width
+	BoxHeight float64 // Box height
+	Unit      string  // "m", "km", "mi" or "ft" (defaults to "m")
+	Sort      string  // "ASC", "DESC" or "" (nearest first when Count is set)
+	Count     int     // Maximum results (0 for no limit)
+	CountAny  bool    // Return as soon as Count matches are found
+}
+
+// GeoAdd adds or updates locations in a geo set.
+//
+// Example:
+//
+//	added, err := client.GeoAdd("drivers",
+//		&client.GeoLocation{Name: "driver:1", Longitude: 13.361389, Latitude: 38.115556},
+//		&client.GeoLocation{Name: "driver:2", Longitude: 15.087269, Latitude: 37.502669},
+//	)
+//
+// Returns:
+//   - Number of new members added
+//   - Error if a coordinate is invalid or the operation fails
+func (c *Client) GeoAdd(key string, locations ...*GeoLocation) (int64, error) {
+	args := make([]string, 0, len(locations)*3)
+	for _, loc := range locations {
+		args = append(args,
+			strconv.FormatFloat(loc.Longitude, 'f', -1, 64),
+			strconv.FormatFloat(loc.Latitude, 'f', -1, 64),
+			loc.Name,
+		)
+	}
+	return c.executeInt64CommandWithArgs(protocol.CmdGeoAdd, key, args)
+}
+
+// GeoPos returns the positions of members. Missing members yield nil.
+//
+// Example:
+//
+//	positions, err := client.GeoPos("drivers", "driver:1", "driver:2")
+func (c *Client) GeoPos(key string, members ...string) ([]*GeoPos, error) {
+	arr, err := c.executeNestedCommand(&protocol.Command{Type: protocol.CmdGeoPos, Key: key, Args: members})
+	if err != nil {
+		return nil, err
+	}
+
+	positions := make([]*GeoPos, len(arr))
+	for i, item := range arr {
+		if item == nil {
+			continue
+		}
+		lon, lat, err := decodeGeoCoord(item)
+		if err != nil {
+			return nil, err
+		}
+		positions[i] = &GeoPos{Longitude: lon, Latitude: lat}
+	}
+	return positions, nil
+}
+
+// GeoDist returns the distance between two members in the given unit
+// ("m", "km", "mi" or "ft"; "" for meters).
+//
+// Example:
+//
+//	km, err := client.GeoDist("drivers", "driver:1", "driver:2", "km")
+//
+// Returns:
+//   - Distance in the requested unit
+//   - Error if either member doesn't exist or the operation fails
+func (c *Client) GeoDist(key, member1, member2, unit string) (float64, error) {
+	args := []string{member1, member2}
+	if unit != "" {
+		args = append(args, unit)
+	}
+
+	resp, err := c.executeCommand(&protocol.Command{Type: protocol.CmdGeoDist, Key: key, Args: args})
+	if err != nil {
+		return 0, err
+	}
+
+	switch resp.Type {
+	case protocol.RespString:
+		str, ok := resp.Data.(string)
+		if !ok {
+			return 0, fmt.Errorf("response data is not a string")
+		}
+		return strconv.ParseFloat(str, 64)
+	case protocol.RespNil:
+		return 0, fmt.Errorf("member not found")
+	case protocol.RespError:
+		return 0, fmt.Errorf("server error: %s", resp.Error)
+	default:
+		return 0, fmt.Errorf("unexpected response type")
+	}
+}
+
+// GeoSearch returns the members inside a circle or box, with their
+// coordinates and distance from the center.
+//
+// Example:
+//
+//	nearest, err := client.GeoSearch("drivers", &client.GeoSearchQuery{
+//		Longitude: 15, Latitude: 37,
+//		Radius:    200,
+//		Unit:      "km",
+//		Count:     5,
+//	})
+//	for _, d := range nearest {
+//		fmt.Printf("%s is %.1f km away\n", d.Name, d.Dist)
+//	}
+func (c *Client) GeoSearch(key string, query *GeoSearchQuery) ([]GeoLocation, error) {
+	unit := query.Unit
+	if unit == "" {
+		unit = "m"
+	}
+
+	var args []string
+	if query.Member != "" {
+		args = append(args, "FROMMEMBER", query.Member)
+	} else {
+		args = append(args, "FROMLONLAT",
+			strconv.FormatFloat(query.Longitude, 'f', -1, 64),
+			strconv.FormatFloat(query.Latitude, 'f', -1, 64))
+	}
+
+	if query.Radius > 0 {
+		args = append(args, "BYRADIUS", strconv.FormatFloat(query.Radius, 'f', -1, 64), unit)
+	} else {
+		args = append(args, "BYBOX",
+			strconv.FormatFloat(query.BoxWidth, 'f', -1, 64),
+			strconv.FormatFloat(query.BoxHeight, 'f', -1, 64), unit)
+	}
+
+	if query.Sort != "" {
+		args = append(args, query.Sort)
+	}
+	if query.Count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(query.Count))
+		if query.CountAny {
+			args = append(args, "ANY")
+		}
+	}
+	args = append(args, "WITHDIST", "WITHCOORD")
+
+	arr, err := c.executeNestedCommand(&protocol.Command{Type: protocol.CmdGeoSearch, Key: key, Args: args})
+	if err != nil {
+		return nil, err
+	}
+
+	const itemFields = 3
+	locations := make([]GeoLocation, 0, len(arr))
+	for _, item := range arr {
+		fields, ok := item.([]interface{})
+		if !ok || len(fields) != itemFields {
+			return nil, fmt.Errorf("unexpected GEOSEARCH result")
+		}
+
+		loc := GeoLocation{}
+		loc.Name, _ = fields[0].(string)
+		dist, _ := fields[1].(string)
+		if loc.Dist, err = strconv.ParseFloat(dist, 64); err != nil {
+			return nil, fmt.Errorf("invalid distance in GEOSEARCH result: %w", err)
+		}
+		if loc.Longitude, loc.Latitude, err = decodeGeoCoord(fields[2]); err != nil {
+			return nil, err
+		}
+		locations = append(locations, loc)
+	}
+	return locations, nil
+}
+
+// decodeGeoCoord decodes a [lon, lat] pair.
+func decodeGeoCoord(item interface{}) (lon, lat float64, err error) {
+	pair, ok := item.([]interface{})
+	if !ok || len(pair) != 2 {
+		return 0, 0, fmt.Errorf("unexpected coordinate pair")
+	}
+	lonStr, _ := pair[0].(string)
+	latStr, _ := pair[1].(string)
+	if lon, err = strconv.ParseFloat(lonStr, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid longitude: %w", err)
+	}
+	if lat, err = strconv.ParseFloat(latStr, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid latitude: %w", err)
+	}
+	return lon, lat, nil
+}
@@ -0,0 +1,213 @@
+package client
+
+import (
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// NodeState is the health of a node, as tracked by the client.
+type NodeState int
+
+const (
+	NodeUp   NodeState = iota // Serving its keys
+	NodeDown                  // Failing; its keys are routed to the next healthy node on the ring
+)
+
+// String returns "up" or "down".
+func (s NodeState) String() string {
+	if s == NodeDown {
+		return "down"
+	}
+	return "up"
+}
+
+// nodeHealth tracks the failures of a node.
+type nodeHealth struct {
+	failures int  // Consecutive failed commands
+	down     bool // Set once failures reach the FailureThreshold
+}
+
+// OnNodeStateChange registers a hook called whenever a node is marked down
+// or back up. Hooks run synchronously on the goroutine that noticed the
+// change, so they should return quickly.
+//
+// Example:
+//
+//	client.OnNodeStateChange(func(node string, state client.NodeState) {
+//		log.Printf("cache node %s is %s", node, state)
+//	})
+func (c *Client) OnNodeStateChange(hook func(node string, state NodeState)) {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+
+	c.healthHooks = append(c.healthHooks, hook)
+}
+
+// NodeStates returns the state of every node in the ring.
+func (c *Client) NodeStates() map[string]NodeState {
+	states := make(map[string]NodeState)
+	for _, node := range c.ring.GetNodes() {
+		states[node] = NodeUp
+		if c.nodeDown(node) {
+			states[node] = NodeDown
+		}
+	}
+	return states
+}
+
+// route returns the node that serves key: its owner on the ring, or while
+// the owner is down, the next healthy node clockwise from the key. If every
+// node is down, the owner is returned anyway.
+func (c *Client) route(key string) string {
+	owner := c.ring.GetNode(key)
+	if !c.nodeDown(owner) {
+		return owner
+	}
+
+	successors := c.ring.GetNodesForKey(key, len(c.ring.GetNodes()))
+	for _, node := range successors[1:] {
+		if !c.nodeDown(node) {
+			return node
+		}
+	}
+	return owner
+}
+
+// nodeDown reports whether node is marked down.
+func (c *Client) nodeDown(node string) bool {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+
+	h, tracked := c.health[node]
+	return tracked && h.down
+}
+
+// recordSuccess resets the failures of node, marking it up if it was down.
+func (c *Client) recordSuccess(node string) {
+	c.healthMu.Lock()
+	h, tracked := c.health[node]
+	if !tracked {
+		c.healthMu.Unlock()
+		return
+	}
+	delete(c.health, node)
+	hooks := c.healthHooks
+	c.healthMu.Unlock()
+
+	if h.down {
+		c.notifyNodeState(hooks, node, NodeUp)
+	}
+}
+
+// recordFailure counts a failed command on node. Once FailureThreshold
+// consecutive commands have failed, the node is marked down: its idle
+// connections are dropped and it is probed until it answers again.
+func (c *Client) recordFailure(node string) {
+	if c.config.FailureThreshold == 0 || node == "" {
+		return
+	}
+
+	c.healthMu.Lock()
+	h, tracked := c.health[node]
+	if !tracked {
+		h = &nodeHealth{}
+		c.health[node] = h
+	}
+	h.failures++
+	if h.down || h.failures < c.config.FailureThreshold {
+		c.healthMu.Unlock()
+		return
+	}
+	h.down = true
+	hooks := c.healthHooks
+	c.healthMu.Unlock()
+
+	c.mu.RLock()
+	pool, exists := c.pools[node]
+	c.mu.RUnlock()
+	if exists {
+		pool.drain()
+	}
+
+	c.notifyNodeState(hooks, node, NodeDown)
+	go c.probe(node)
+}
+
+// notifyNodeState logs a state change and calls the hooks.
+func (c *Client) notifyNodeState(hooks []func(string, NodeState), node string, state NodeState) {
+	log.Printf("Node %s is %s", node, state)
+	for _, hook := range hooks {
+		hook(node, state)
+	}
+}
+
+// probe sends PING to a down node every HealthCheckInterval until it
+// answers, the node is removed, or the client is closed.
+func (c *Client) probe(node string) {
+	ticker := time.NewTicker(time.Duration(c.config.HealthCheckInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.RLock()
+		_, exists := c.pools[node]
+		c.mu.RUnlock()
+		if !exists {
+			c.healthMu.Lock()
+			delete(c.health, node)
+			c.healthMu.Unlock()
+			return
+		}
+		if !c.nodeDown(node) {
+			return // Marked up by a command that went through
+		}
+
+		if err := c.ping(node); err == nil {
+			c.recordSuccess(node)
+			return
+		}
+	}
+}
+
+// ping sends a single PING to node, without retries.
+func (c *Client) ping(node string) error {
+	conn, err := c.getConnection(node)
+	if err != nil {
+		return err
+	}
+
+	err = conn.SetDeadline(time.Now().Add(time.Duration(c.config.ConnTimeout) * time.Second))
+	if err == nil {
+		err = protocol.WriteCommand(conn, &protocol.Command{Type: protocol.CmdPing})
+	}
+	var resp *protocol.Response
+	if err == nil {
+		resp, err = protocol.ReadResponse(conn)
+	}
+	if err != nil {
+		c.closeConnection(conn)
+		return err
+	}
+
+	c.returnConnection(node, conn)
+	if resp.Type == protocol.RespError {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+// isDialError reports whether err comes from connecting to a node, rather
+// than from a pool that is closed or has no free connection.
+func isDialError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cachemir/cachemir/internal/server"
+)
+
+// keyOwnedBy returns a key that node owns on the ring of c.
+func keyOwnedBy(t *testing.T, c *Client, node string) string {
+	t.Helper()
+
+	for i := range 10000 {
+		if key := fmt.Sprintf("key:%d", i); c.ring.GetNode(key) == node {
+			return key
+		}
+	}
+	t.Fatalf("Expected %s to own some key", node)
+	return ""
+}
+
+// watchStates returns a channel receiving the state changes of node.
+func watchStates(c *Client, node string) <-chan NodeState {
+	states := make(chan NodeState, 16)
+	c.OnNodeStateChange(func(changed string, state NodeState) {
+		if changed == node {
+			states <- state
+		}
+	})
+	return states
+}
+
+func TestBreakerOpensAfterFailures(t *testing.T) {
+	_, good := startServer(t)
+	bad, _, _ := refuseConnections(t)
+	c := newTestClient(t, []string{good, bad}, nil) // Threshold 3, two attempts per command
+	states := watchStates(c, bad)
+	key := keyOwnedBy(t, c, bad)
+
+	// Two failures: the command fails, but the node isn't down yet.
+	if err := c.Set(key, "v", 0); err == nil {
+		t.Fatal("Expected Set to fail while the owner refuses connections")
+	}
+	if state := c.NodeStates()[bad]; state != NodeUp {
+		t.Errorf("Expected %s to be up below the threshold, got %s", bad, state)
+	}
+
+	// The third failure opens the breaker, and the retry goes to the next
+	// node on the ring.
+	if err := c.Set(key, "v", 0); err != nil {
+		t.Fatalf("Expected Set to be routed around the down node, got %v", err)
+	}
+	if state := <-states; state != NodeDown {
+		t.Errorf("Expected the hook to report %s down, got %s", bad, state)
+	}
+	if states := c.NodeStates(); states[bad] != NodeDown || states[good] != NodeUp {
+		t.Errorf("Expected %s down and %s up, got %v", bad, good, states)
+	}
+	if value, err := newTestClient(t, []string{good}, nil).Get(key); err != nil || value != "v" {
+		t.Errorf("Expected the key on %s, got %q (%v)", good, value, err)
+	}
+
+	// Later commands skip the down node without failing.
+	if value, err := c.Get(key); err != nil || value != "v" {
+		t.Errorf("Expected Get through %s, got %q (%v)", good, value, err)
+	}
+	select {
+	case state := <-states:
+		t.Errorf("Expected no further state change, got %s", state)
+	default:
+	}
+}
+
+func TestProbeRestoresNode(t *testing.T) {
+	_, good := startServer(t)
+	bad, port, stop := refuseConnections(t)
+	c := newTestClient(t, []string{good, bad}, nil)
+	states := watchStates(c, bad)
+	key := keyOwnedBy(t, c, bad)
+
+	for range 2 {
+		c.Set(key, "moved", 0) //nolint:errcheck,gosec // Fails until the node is down
+	}
+	if state := <-states; state != NodeDown {
+		t.Fatalf("Expected %s to be down, got %s", bad, state)
+	}
+
+	// A server starts on the node's address; the probe marks it up.
+	stop()
+	runServer(t, server.New(port), bad)
+	waitFor(t, "the probe to mark the node up", func() bool { return c.NodeStates()[bad] == NodeUp })
+	if state := <-states; state != NodeUp {
+		t.Errorf("Expected the hook to report %s up, got %s", bad, state)
+	}
+
+	// The node serves its keys again.
+	if err := c.Set(key, "back", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if value, err := newTestClient(t, []string{bad}, nil).Get(key); err != nil || value != "back" {
+		t.Errorf("Expected the key back on %s, got %q (%v)", bad, value, err)
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// HotKey is a frequently accessed key reported by HotKeys.
+type HotKey struct {
+	Key  string // The key
+	Node string // Node that reported it
+	Hits int64  // Estimated recent accesses, decayed by half every 30 seconds
+}
+
+// HotKeys returns up to count of the most accessed keys across all nodes,
+// most accessed first. Each node estimates the access counts of its keys
+// from the commands it served, with recent accesses weighing more, so that
+// keys hammering a node stand out. Keys read from the near cache aren't
+// counted, since they don't reach the nodes.
+//
+// Example:
+//
+//	hot, err := client.HotKeys(10)
+//	for _, k := range hot {
+//		fmt.Printf("%s on %s: %d hits\n", k.Key, k.Node, k.Hits)
+//	}
+func (c *Client) HotKeys(count int) ([]HotKey, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("count must be positive")
+	}
+
+	var hot []HotKey
+	for _, node := range c.ring.GetNodes() {
+		nodeKeys, err := c.hotKeysOnNode(node, count)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", node, err)
+		}
+		hot = append(hot, nodeKeys...)
+	}
+
+	slices.SortFunc(hot, func(a, b HotKey) int {
+		if a.Hits != b.Hits {
+			return cmp.Compare(b.Hits, a.Hits)
+		}
+		return cmp.Compare(a.Key, b.Key)
+	})
+	return hot[:min(count, len(hot))], nil
+}
+
+// hotKeysOnNode sends HOTKEYS to node.
+func (c *Client) hotKeysOnNode(node string, count int) ([]HotKey, error) {
+	cmd := &protocol.Command{Type: protocol.CmdHotKeys, Args: []string{strconv.Itoa(count)}}
+	resp, err := c.executeOnNode(node, cmd, time.Duration(c.config.ReadTimeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type == protocol.RespError {
+		return nil, fmt.Errorf("server error: %s", resp.Error)
+	}
+	entries, ok := resp.Data.([]interface{})
+	if resp.Type != protocol.RespNested || (!ok && resp.Data != nil) {
+		return nil, fmt.Errorf("unexpected response type")
+	}
+
+	hot := make([]HotKey, 0, len(entries))
+	for _, entry := range entries {
+		pair, ok := entry.([]interface{})
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("unexpected response data")
+		}
+		key, keyOK := pair[0].(string)
+		hits, hitsOK := pair[1].(int64)
+		if !keyOK || !hitsOK {
+			return nil, fmt.Errorf("unexpected response data")
+		}
+		hot = append(hot, HotKey{Key: key, Node: node, Hits: hits})
+	}
+	return hot, nil
+}
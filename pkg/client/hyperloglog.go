@@ -0,0 +1,133 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/cachemir/cachemir/pkg/cache"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// PFAdd adds elements to the HyperLogLog stored at key, creating it if needed.
+// Returns true if the HyperLogLog was created or its estimate may have changed.
+//
+// Example:
+//
+//	changed, err := client.PFAdd("visitors:/home", "alice", "bob")
+//
+// Parameters:
+//   - key: The HyperLogLog key
+//   - elements: Elements to observe
+//
+// Returns:
+//   - Boolean indicating if the HyperLogLog changed
+//   - Error if the operation fails
+func (c *Client) PFAdd(key string, elements ...string) (bool, error) {
+	val, err := c.executeInt64CommandWithArgs(protocol.CmdPFAdd, key, elements)
+	if err != nil {
+		return false, err
+	}
+	return val == 1, nil
+}
+
+// PFCount returns the approximate number of distinct elements observed by the
+// HyperLogLog at key. With several keys it returns the cardinality of their union.
+//
+// Keys on a single node are counted by that server. When the keys are spread
+// across nodes, the client fetches each HyperLogLog and merges them locally.
+//
+// Example:
+//
+//	visitors, err := client.PFCount("visitors:/home")
+//	weekly, err := client.PFCount("visitors:mon", "visitors:tue", "visitors:wed")
+//
+// Parameters:
+//   - keys: One or more HyperLogLog keys
+//
+// Returns:
+//   - Estimated cardinality
+//   - Error if the operation fails
+func (c *Client) PFCount(keys ...string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, fmt.Errorf("PFCOUNT requires at least one key")
+	}
+
+	if c.sameNode(keys...) {
+		return c.executeInt64CommandWithArgs(protocol.CmdPFCount, keys[0], keys[1:])
+	}
+
+	union, err := c.fetchHyperLogLogs(keys)
+	if err != nil {
+		return 0, err
+	}
+	return union.Count(), nil
+}
+
+// PFMerge merges the HyperLogLogs at srcKeys into destKey, including any
+// HyperLogLog already stored at destKey.
+//
+// Keys on a single node are merged atomically by that server. Otherwise the
+// client fetches the sources, merges them locally and sends the result to the
+// destination node, which folds it into destKey.
+//
+// Example:
+//
+//	err := client.PFMerge("visitors:week", "visitors:mon", "visitors:tue")
+//
+// Parameters:
+//   - destKey: The key receiving the merged HyperLogLog
+//   - srcKeys: Source HyperLogLog keys
+//
+// Returns:
+//   - Error if the operation fails
+func (c *Client) PFMerge(destKey string, srcKeys ...string) error {
+	if c.sameNode(append([]string{destKey}, srcKeys...)...) {
+		return c.executeOKCommandWithArgs(protocol.CmdPFMerge, destKey, srcKeys)
+	}
+
+	union, err := c.fetchHyperLogLogs(srcKeys)
+	if err != nil {
+		return err
+	}
+
+	data, err := union.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return c.executeOKCommandWithArgs(protocol.CmdPFImport, destKey, []string{string(data)})
+}
+
+// fetchHyperLogLogs exports the HyperLogLogs at keys from their nodes and
+// returns their union. Missing keys are treated as empty.
+func (c *Client) fetchHyperLogLogs(keys []string) (*cache.HyperLogLog, error) {
+	union := cache.NewHyperLogLog()
+
+	for _, key := range keys {
+		resp, err := c.executeCommand(&protocol.Command{Type: protocol.CmdPFExport, Key: key})
+		if err != nil {
+			return nil, err
+		}
+
+		switch resp.Type {
+		case protocol.RespNil:
+			continue
+		case protocol.RespError:
+			return nil, fmt.Errorf("server error: %s", resp.Error)
+		case protocol.RespString:
+		default:
+			return nil, fmt.Errorf("unexpected response type")
+		}
+
+		data, ok := resp.Data.(string)
+		if !ok {
+			return nil, fmt.Errorf("response data is not a string")
+		}
+
+		hll := cache.NewHyperLogLog()
+		if err := hll.UnmarshalBinary([]byte(data)); err != nil {
+			return nil, fmt.Errorf("invalid HyperLogLog for key %s: %w", key, err)
+		}
+		union.Merge(hll)
+	}
+
+	return union, nil
+}
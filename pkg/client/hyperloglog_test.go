@@ -0,0 +1,71 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+)
+
+// addRange adds the elements "e:from" to "e:to-1" to the HyperLogLog at key.
+func addRange(t *testing.T, c *Client, key string, from, to int) {
+	t.Helper()
+
+	elements := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		elements = append(elements, fmt.Sprintf("e:%d", i))
+	}
+	if _, err := c.PFAdd(key, elements...); err != nil {
+		t.Fatalf("PFAdd failed: %v", err)
+	}
+}
+
+// expectEstimate fails the test unless got is within 3% of want.
+func expectEstimate(t *testing.T, what string, got int64, err error, want int64) {
+	t.Helper()
+
+	if err != nil || got < want*97/100 || got > want*103/100 {
+		t.Errorf("Expected %s to be about %d, got %d (%v)", what, want, got, err)
+	}
+}
+
+func TestHyperLogLogAcrossNodes(t *testing.T) {
+	_, addrA := startServer(t)
+	_, addrB := startServer(t)
+	c := newTestClient(t, []string{addrA, addrB}, nil)
+	a, b := newTestClient(t, []string{addrA}, nil), newTestClient(t, []string{addrB}, nil)
+
+	tagA, tagB := "{"+keyOwnedBy(t, c, addrA)+"}", "{"+keyOwnedBy(t, c, addrB)+"}"
+	monday, tuesday, week := tagA+":mon", tagB+":tue", tagB+":week"
+	addRange(t, a, monday, 0, 1000)
+	addRange(t, b, tuesday, 500, 1500)
+
+	// PFCOUNT merges the keys of both nodes, counting shared elements once.
+	n, err := c.PFCount(monday, tuesday)
+	expectEstimate(t, "the union", n, err, 1500)
+	n, err = c.PFCount(monday, tuesday, tagA+":missing")
+	expectEstimate(t, "the union with a missing key", n, err, 1500)
+	n, err = c.PFCount(monday)
+	expectEstimate(t, "a single key", n, err, 1000)
+
+	// PFMERGE folds the sources into the destination, on its own owner.
+	addRange(t, b, week, 1500, 1600)
+	if err := c.PFMerge(week, monday, tuesday); err != nil {
+		t.Fatalf("PFMerge failed: %v", err)
+	}
+	n, err = b.PFCount(week)
+	expectEstimate(t, "the merged destination", n, err, 1600)
+	if exists, err := a.Exists(week); err != nil || exists {
+		t.Errorf("Expected the destination only on its owner, got %v (%v)", exists, err)
+	}
+
+	// Sources are left as they were.
+	n, err = a.PFCount(monday)
+	expectEstimate(t, "a source after the merge", n, err, 1000)
+
+	// A new destination is created on its owner.
+	created := tagA + ":created"
+	if err := c.PFMerge(created, tuesday); err != nil {
+		t.Fatalf("PFMerge failed: %v", err)
+	}
+	n, err = a.PFCount(created)
+	expectEstimate(t, "a new destination", n, err, 1000)
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// JSONSet stores JSON text at a JSONPath in a document. Use "$" to create or
+// replace the whole document; other paths update it in place.
+//
+// Example:
+//
+//	err := client.JSONSet("user:1", "$", `{"name":"Ada","visits":0,"tags":[]}`)
+//	err = client.JSONSet("user:1", "$.name", `"Ada Lovelace"`)
+//
+// Parameters:
+//   - key: The document key
+//   - path: JSONPath of the value to set
+//   - value: JSON text of the new value
+//
+// Returns:
+//   - Error if the JSON or path is invalid, or the path matches nothing
+func (c *Client) JSONSet(key, path, value string) error {
+	written, err := c.jsonSet(key, path, value, "")
+	if err != nil {
+		return err
+	}
+	if !written {
+		return fmt.Errorf("path not found")
+	}
+	return nil
+}
+
+// JSONSetMode is like JSONSet with an NX (only create) or XX (only replace)
+// condition.
+//
+// Example:
+//
+//	created, err := client.JSONSetMode("user:1", "$.email", `"ada@example.com"`, "NX")
+//
+// Returns:
+//   - Boolean indicating if the value was written
+//   - Error if the operation fails
+func (c *Client) JSONSetMode(key, path, value, mode string) (bool, error) {
+	return c.jsonSet(key, path, value, mode)
+}
+
+func (c *Client) jsonSet(key, path, value, mode string) (bool, error) {
+	args := []string{path, value}
+	if mode != "" {
+		args = append(args, mode)
+	}
+
+	resp, err := c.executeCommand(&protocol.Command{Type: protocol.CmdJSONSet, Key: key, Args: args})
+	if err != nil {
+		return false, err
+	}
+
+	switch resp.Type {
+	case protocol.RespOK:
+		return true, nil
+	case protocol.RespNil:
+		return false, nil
+	case protocol.RespError:
+		return false, fmt.Errorf("server error: %s", resp.Error)
+	default:
+		return false, fmt.Errorf("unexpected response type")
+	}
+}
+
+// JSONGet returns JSON text from a document. Without paths the whole document
+// is returned; with one path, a JSON array of its matches; with several, a
+// JSON object mapping each path to its matches.
+//
+// Example:
+//
+//	doc, err := client.JSONGet("user:1")
+//	names, err := client.JSONGet("users", "$[*].name")
+//
+// Returns:
+//   - JSON text
+//   - Error if the key doesn't exist or the operation fails
+func (c *Client) JSONGet(key string, paths ...string) (string, error) {
+	resp, err := c.executeCommand(&protocol.Command{Type: protocol.CmdJSONGet, Key: key, Args: paths})
+	if err != nil {
+		return "", err
+	}
+
+	switch resp.Type {
+	case protocol.RespString:
+		str, ok := resp.Data.(string)
+		if !ok {
+			return "", fmt.Errorf("response data is not a string")
+		}
+		return str, nil
+	case protocol.RespNil:
+		return "", fmt.Errorf("key not found")
+	case protocol.RespError:
+		return "", fmt.Errorf("server error: %s", resp.Error)
+	default:
+		return "", fmt.Errorf("unexpected response type")
+	}
+}
+
+// JSONDel removes the values at a JSONPath. Deleting "$" removes the key.
+//
+// Example:
+//
+//	removed, err := client.JSONDel("user:1", "$.tags[0]")
+//
+// Returns:
+//   - Number of values removed
+//   - Error if the operation fails
+func (c *Client) JSONDel(key, path string) (int64, error) {
+	return c.executeInt64CommandWithArgs(protocol.CmdJSONDel, key, []string{path})
+}
+
+// JSONNumIncrBy atomically adds delta to the numbers at a JSONPath.
+//
+// Example:
+//
+//	updated, err := client.JSONNumIncrBy("user:1", "$.visits", 1) // "[1]"
+//
+// Returns:
+//   - JSON array of the new values (null for matches that are not numbers)
+//   - Error if the key doesn't exist or the operation fails
+func (c *Client) JSONNumIncrBy(key, path string, delta float64) (string, error) {
+	args := []string{path, strconv.FormatFloat(delta, 'f', -1, 64)}
+	resp, err := c.executeCommand(&protocol.Command{Type: protocol.CmdJSONNumIncrBy, Key: key, Args: args})
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Type == protocol.RespError {
+		return "", fmt.Errorf("server error: %s", resp.Error)
+	}
+	str, ok := resp.Data.(string)
+	if resp.Type != protocol.RespString || !ok {
+		return "", fmt.Errorf("unexpected response type")
+	}
+	return str, nil
+}
+
+// JSONArrAppend atomically appends JSON values to the arrays at a JSONPath.
+//
+// Example:
+//
+//	lengths, err := client.JSONArrAppend("user:1", "$.tags", `"admin"`, `"beta"`)
+//
+// Returns:
+//   - New length of each matched array (-1 for matches that are not arrays)
+//   - Error if the key doesn't exist or the operation fails
+func (c *Client) JSONArrAppend(key, path string, values ...string) ([]int64, error) {
+	args := append([]string{path}, values...)
+	arr, err := c.executeNestedCommand(&protocol.Command{Type: protocol.CmdJSONArrAppend, Key: key, Args: args})
+	if err != nil {
+		return nil, err
+	}
+
+	lengths := make([]int64, len(arr))
+	for i, item := range arr {
+		length, ok := item.(int64)
+		if !ok {
+			length = -1
+		}
+		lengths[i] = length
+	}
+	return lengths, nil
+}
@@ -0,0 +1,338 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/config"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// migrateBatchSize is the number of keys moved by each MIGRATE of a
+// resharding.
+const migrateBatchSize = 100
+
+// ErrBusyKey is returned by MigrateKeys when a key already exists on the
+// target and replace was not requested.
+var ErrBusyKey = errors.New("target key name is busy")
+
+// dualReadCommands are the reads that, while resharding, are retried on a
+// key's previous owner when its new owner answers nil.
+var dualReadCommands = map[protocol.CommandType]bool{
+	protocol.CmdGet:     true,
+	protocol.CmdGetV:    true,
+	protocol.CmdHGet:    true,
+	protocol.CmdJSONGet: true,
+	protocol.CmdDump:    true,
+}
+
+// Keys returns the keys on all nodes that match a glob pattern ("*" for
+// all keys), sorted. Every node walks its whole keyspace to answer, so
+// Keys is meant for administration rather than regular use.
+//
+// Example:
+//
+//	sessions, err := client.Keys("session:*")
+func (c *Client) Keys(pattern string) ([]string, error) {
+	var keys []string
+	for _, node := range c.ring.GetNodes() {
+		nodeKeys, err := c.keysOnNode(node, pattern)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, nodeKeys...)
+	}
+	sort.Strings(keys)
+	return slices.Compact(keys), nil
+}
+
+// keysOnNode sends KEYS to node.
+func (c *Client) keysOnNode(node, pattern string) ([]string, error) {
+	cmd := &protocol.Command{Type: protocol.CmdKeys, Args: []string{pattern}}
+	resp, err := c.executeOnNode(node, cmd, time.Duration(c.config.ReadTimeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type == protocol.RespError {
+		return nil, fmt.Errorf("server error: %s", resp.Error)
+	}
+	if resp.Type != protocol.RespArray {
+		return nil, fmt.Errorf("unexpected response type")
+	}
+	keys, _ := resp.Data.([]string)
+	return keys, nil
+}
+
+// ScanNode returns a batch of about count keys of node that match a glob
+// pattern ("" for all keys), starting at cursor, and the cursor to pass to
+// the next call. Start with cursor 0; the returned cursor is 0 once every
+// key has been returned. Unlike Keys, each call returns a bounded number
+// of keys, and keys that exist for the whole iteration are returned
+// exactly once.
+//
+// Example:
+//
+//	for cursor := uint64(0); ; {
+//		keys, next, err := client.ScanNode("cache1:8080", cursor, "session:*", 100)
+//		if err != nil {
+//			return err
+//		}
+//		process(keys)
+//		if cursor = next; cursor == 0 {
+//			break
+//		}
+//	}
+func (c *Client) ScanNode(node string, cursor uint64, pattern string, count int) ([]string, uint64, error) {
+	args := []string{strconv.FormatUint(cursor, 10), "COUNT", strconv.Itoa(max(count, 1))}
+	if pattern != "" {
+		args = append(args, "MATCH", pattern)
+	}
+	cmd := &protocol.Command{Type: protocol.CmdScan, Args: args}
+	resp, err := c.executeOnNode(node, cmd, time.Duration(c.config.ReadTimeout)*time.Second)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.Type == protocol.RespError {
+		return nil, 0, fmt.Errorf("server error: %s", resp.Error)
+	}
+	items, ok := resp.Data.([]interface{})
+	if resp.Type != protocol.RespNested || !ok || len(items) != 2 {
+		return nil, 0, fmt.Errorf("unexpected response type")
+	}
+
+	cursorStr, _ := items[0].(string)
+	next, err := strconv.ParseUint(cursorStr, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unexpected response data")
+	}
+	entries, _ := items[1].([]interface{})
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		key, ok := entry.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("unexpected response data")
+		}
+		keys = append(keys, key)
+	}
+	return keys, next, nil
+}
+
+// MigrateKeys moves keys from the source node to the target node, keeping
+// their TTLs. Keys that don't exist on source are skipped. Unless replace
+// is set, keys that already exist on target are left on source and
+// ErrBusyKey is returned; the other keys are moved anyway.
+//
+// Example:
+//
+//	err := client.MigrateKeys("cache1:8080", "cache2:8080", []string{"user:1", "user:2"}, false)
+func (c *Client) MigrateKeys(source, target string, keys []string, replace bool) error {
+	host, port, err := net.SplitHostPort(c.nodeAddress(target))
+	if err != nil {
+		return err
+	}
+	timeout := time.Duration(c.config.ConnTimeout) * time.Second
+	args := []string{host, port, strconv.FormatInt(timeout.Milliseconds(), 10)}
+	if replace {
+		args = append(args, "REPLACE")
+	}
+	args = append(args, "KEYS")
+	args = append(args, keys...)
+
+	cmd := &protocol.Command{Type: protocol.CmdMigrate, Args: args}
+	resp, err := c.executeOnNode(source, cmd, time.Duration(c.config.ReadTimeout)*time.Second)
+	if err != nil {
+		return err
+	}
+	if resp.Type == protocol.RespError {
+		if strings.HasPrefix(resp.Error, "BUSYKEY") {
+			return fmt.Errorf("%w: %s", ErrBusyKey, resp.Error)
+		}
+		return fmt.Errorf("server error: %s", resp.Error)
+	}
+	return nil
+}
+
+// Reshard changes the nodes of the ring to nodes and moves every key whose
+// owner changed from its previous owner to its new one. Nodes are given as
+// "host:port" or "host:port=weight", so Reshard also changes node weights.
+// Nodes that are no longer listed are dropped once their keys have moved.
+//
+// The ring changes before any key moves, so that new writes go to the new
+// owners. Until Reshard returns, commands on a key whose owner changed
+// are handled so that no value is missed or lost: reads that get nil from
+// the new owner are retried on the previous one, and any other command
+// first moves the key to its new owner. Keys written on their new owner
+// before being moved keep the newer value.
+//
+// Reshard returns the number of keys moved by the resharding itself. It
+// isn't supported with a ReplicationFactor above 1, and AddNode and
+// RemoveNode must not be called while it runs.
+//
+// Example:
+//
+//	// Add cache4 and move its share of the keys to it
+//	moved, err := client.Reshard([]string{"cache1:8080", "cache2:8080", "cache3:8080", "cache4:8080"})
+func (c *Client) Reshard(nodes []string) (int, error) {
+	if c.config.ReplicationFactor > 1 {
+		return 0, fmt.Errorf("resharding is not supported with a replication factor above 1")
+	}
+
+	addrs := make([]string, len(nodes))
+	weights := make([]int, len(nodes))
+	for i, node := range nodes {
+		var err error
+		if addrs[i], weights[i], err = config.ParseNode(node); err != nil {
+			return 0, err
+		}
+	}
+
+	c.mu.Lock()
+	if c.previous != nil {
+		c.mu.Unlock()
+		return 0, fmt.Errorf("resharding already in progress")
+	}
+	old := c.ring.GetNodes()
+	c.previous = newRing(c.config)
+	var removed []string
+	for _, node := range old {
+		c.previous.AddNodeWithWeight(node, c.ring.GetWeight(node))
+		if !slices.Contains(addrs, node) {
+			c.ring.RemoveNode(node) // Its pool stays open until its keys have moved
+			removed = append(removed, node)
+		}
+	}
+	for i, node := range addrs {
+		c.ring.AddNodeWithWeight(node, weights[i])
+		if _, exists := c.pools[node]; !exists {
+			c.pools[node] = c.newPool(node)
+		}
+	}
+	pubsubs := c.openPubSubs()
+	c.mu.Unlock()
+
+	for _, ps := range pubsubs {
+		ps.reshard()
+	}
+	defer c.finishReshard(removed)
+
+	moved := 0
+	var errs []error
+	for _, source := range old {
+		n, err := c.moveKeys(source)
+		moved += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("moving keys from %s: %w", source, err))
+		}
+	}
+	return moved, errors.Join(errs...)
+}
+
+// finishReshard ends dual reads and closes the pools of removed nodes.
+func (c *Client) finishReshard(removed []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.previous = nil
+	for _, node := range removed {
+		if pool, exists := c.pools[node]; exists {
+			pool.Close()
+			delete(c.pools, node)
+		}
+	}
+}
+
+// moveKeys moves the keys of source that it no longer owns to their new
+// owners, in batches, and returns how many were moved.
+func (c *Client) moveKeys(source string) (int, error) {
+	keys, err := c.keysOnNode(source, "*")
+	if err != nil {
+		return 0, err
+	}
+
+	byOwner := make(map[string][]string)
+	for _, key := range keys {
+		if owner := c.ring.GetNode(key); owner != source {
+			byOwner[owner] = append(byOwner[owner], key)
+		}
+	}
+
+	moved := 0
+	for owner, keys := range byOwner {
+		for batch := range slices.Chunk(keys, migrateBatchSize) {
+			if err := c.moveBatch(source, owner, batch); err != nil {
+				return moved, err
+			}
+			moved += len(batch)
+		}
+	}
+	return moved, nil
+}
+
+// moveBatch moves keys from source to target without replacing keys that
+// target already has: those were written after the ring changed, so the
+// copies left on source are stale and are deleted.
+func (c *Client) moveBatch(source, target string, keys []string) error {
+	err := c.MigrateKeys(source, target, keys, false)
+	if !errors.Is(err, ErrBusyKey) {
+		return err
+	}
+
+	// The other keys have moved; find the busy ones.
+	for _, key := range keys {
+		err := c.MigrateKeys(source, target, []string{key}, false)
+		if errors.Is(err, ErrBusyKey) {
+			_, err = c.executeOnNode(source, &protocol.Command{Type: protocol.CmdDel, Key: key},
+				time.Duration(c.config.ReadTimeout)*time.Second)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// previousOwner returns the node that owned key before the resharding in
+// progress, or "" if there is none or the owner hasn't changed.
+func (c *Client) previousOwner(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.previous == nil || key == "" {
+		return ""
+	}
+	if owner := c.previous.GetNode(key); owner != c.ring.GetNode(key) {
+		return owner
+	}
+	return ""
+}
+
+// executeResharded executes a command on a key that is moving from the
+// previous owner to its new one. Reads in dualReadCommands that miss on the
+// new owner are retried on previous, then on the new owner again in case
+// the key moved meanwhile. Other commands move the key first, so that they
+// see and change its current value.
+func (c *Client) executeResharded(cmd *protocol.Command, previous string) (*protocol.Response, error) {
+	timeout := time.Duration(c.config.ReadTimeout) * time.Second
+	if dualReadCommands[cmd.Type] {
+		resp, err := c.executeOnNode("", cmd, timeout)
+		if err != nil || resp.Type != protocol.RespNil {
+			return resp, err
+		}
+		if old, err := c.executeOnNode(previous, cmd, timeout); err == nil && old.Type != protocol.RespNil {
+			return old, nil
+		}
+		// The key may have moved between the two reads.
+		return c.executeOnNode("", cmd, timeout)
+	}
+
+	if err := c.moveBatch(previous, c.ring.GetNode(cmd.Key), []string{cmd.Key}); err != nil {
+		return nil, err
+	}
+	return c.executeOnNode("", cmd, timeout)
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// beginReshard adds node to the ring of c the way Reshard does before
+// moving any key, leaving the keys it now owns on their previous owners.
+func beginReshard(c *Client, node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.previous = newRing(c.config)
+	for _, existing := range c.ring.GetNodes() {
+		c.previous.AddNodeWithWeight(existing, c.ring.GetWeight(existing))
+	}
+	c.ring.AddNode(node)
+	c.pools[node] = c.newPool(node)
+}
+
+func TestMigrateKeys(t *testing.T) {
+	_, addrA := startServer(t)
+	_, addrB := startServer(t)
+	c := newTestClient(t, []string{addrA, addrB}, nil)
+	a, b := newTestClient(t, []string{addrA}, nil), newTestClient(t, []string{addrB}, nil)
+
+	if err := a.Set("session", "s1", time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := a.Set("profile", "p1", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	// Missing keys are skipped.
+	if err := c.MigrateKeys(addrA, addrB, []string{"session", "profile", "missing"}, false); err != nil {
+		t.Fatalf("MigrateKeys failed: %v", err)
+	}
+	for _, key := range []string{"session", "profile"} {
+		if exists, err := a.Exists(key); err != nil || exists {
+			t.Errorf("Expected %s to leave the source, got %v (%v)", key, exists, err)
+		}
+	}
+	if value, err := b.Get("profile"); err != nil || value != "p1" {
+		t.Errorf("Expected profile on the target, got %q (%v)", value, err)
+	}
+	if ttl, err := b.TTL("session"); err != nil || ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("Expected session to keep its TTL, got %v (%v)", ttl, err)
+	}
+
+	// Keys the target already has stay on the source unless replaced.
+	if err := a.Set("profile", "p2", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := c.MigrateKeys(addrA, addrB, []string{"profile"}, false); !errors.Is(err, ErrBusyKey) {
+		t.Errorf("Expected ErrBusyKey, got %v", err)
+	}
+	if value, err := a.Get("profile"); err != nil || value != "p2" {
+		t.Errorf("Expected the busy key to stay on the source, got %q (%v)", value, err)
+	}
+	if err := c.MigrateKeys(addrA, addrB, []string{"profile"}, true); err != nil {
+		t.Fatalf("MigrateKeys with replace failed: %v", err)
+	}
+	if value, err := b.Get("profile"); err != nil || value != "p2" {
+		t.Errorf("Expected the target's copy to be replaced, got %q (%v)", value, err)
+	}
+}
+
+func TestReshardMovesKeys(t *testing.T) {
+	_, addrA := startServer(t)
+	_, addrB := startServer(t)
+	c := newTestClient(t, []string{addrA}, nil)
+
+	const count = 50
+	for i := range count {
+		if err := c.Set(fmt.Sprintf("key:%d", i), fmt.Sprint(i), 0); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	moved, err := c.Reshard([]string{addrA, addrB})
+	if err != nil {
+		t.Fatalf("Reshard failed: %v", err)
+	}
+
+	direct := map[string]*Client{
+		addrA: newTestClient(t, []string{addrA}, nil),
+		addrB: newTestClient(t, []string{addrB}, nil),
+	}
+	owned := 0
+	for i := range count {
+		key := fmt.Sprintf("key:%d", i)
+		owner := c.ring.GetNode(key)
+		if owner == addrB {
+			owned++
+		}
+		if value, err := direct[owner].Get(key); err != nil || value != fmt.Sprint(i) {
+			t.Errorf("Expected %s on its owner %s, got %q (%v)", key, owner, value, err)
+		}
+	}
+	if moved != owned || moved == 0 {
+		t.Errorf("Expected the %d keys B owns to move, got %d", owned, moved)
+	}
+	if keys, err := direct[addrA].Keys("*"); err != nil || len(keys) != count-owned {
+		t.Errorf("Expected A to keep only its %d keys, got %d (%v)", count-owned, len(keys), err)
+	}
+
+	// Removing B moves its keys back.
+	if moved, err := c.Reshard([]string{addrA}); err != nil || moved != owned {
+		t.Errorf("Expected %d keys to move back, got %d (%v)", owned, moved, err)
+	}
+	if keys, err := direct[addrA].Keys("*"); err != nil || len(keys) != count {
+		t.Errorf("Expected A to hold every key again, got %d (%v)", len(keys), err)
+	}
+}
+
+func TestDualReadsDuringReshard(t *testing.T) {
+	_, addrA := startServer(t)
+	_, addrB := startServer(t)
+	c := newTestClient(t, []string{addrA}, nil)
+	a, b := newTestClient(t, []string{addrA}, nil), newTestClient(t, []string{addrB}, nil)
+
+	beginReshard(c, addrB)
+	var owned []string // Keys B owns now, and A owned before
+	for i := 0; len(owned) < 3 && i < 10000; i++ {
+		if key := fmt.Sprintf("key:%d", i); c.ring.GetNode(key) == addrB {
+			owned = append(owned, key)
+		}
+	}
+	if len(owned) < 3 {
+		t.Fatalf("Expected %s to own 3 keys, got %v", addrB, owned)
+	}
+	moving, counter, stale := owned[0], owned[1], owned[2]
+	for key, value := range map[string]string{moving: "old", counter: "1", stale: "old"} {
+		if err := a.Set(key, value, 0); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	// Reads that miss on the new owner fall back to the previous one,
+	// without moving the key.
+	if value, err := c.Get(moving); err != nil || value != "old" {
+		t.Errorf("Expected the value from the previous owner, got %q (%v)", value, err)
+	}
+	if exists, err := b.Exists(moving); err != nil || exists {
+		t.Errorf("Expected a read not to move the key, got %v (%v)", exists, err)
+	}
+
+	// Other commands move the key first, so they see its value.
+	if n, err := c.Incr(counter); err != nil || n != 2 {
+		t.Errorf("Expected INCR to see the moved value, got %d (%v)", n, err)
+	}
+	if exists, err := a.Exists(counter); err != nil || exists {
+		t.Errorf("Expected the key to leave the previous owner, got %v (%v)", exists, err)
+	}
+
+	// Keys written on the new owner before being moved keep the new value.
+	if err := b.Set(stale, "new", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if value, err := c.Get(stale); err != nil || value != "new" {
+		t.Errorf("Expected the new owner's value, got %q (%v)", value, err)
+	}
+	if _, err := c.moveKeys(addrA); err != nil {
+		t.Fatalf("moveKeys failed: %v", err)
+	}
+	c.finishReshard(nil)
+	if value, err := c.Get(stale); err != nil || value != "new" {
+		t.Errorf("Expected the stale copy not to replace the new value, got %q (%v)", value, err)
+	}
+	if value, err := c.Get(moving); err != nil || value != "old" {
+		t.Errorf("Expected the key to be read from its new owner, got %q (%v)", value, err)
+	}
+	if keys, err := a.Keys("*"); err != nil || len(keys) != 0 {
+		t.Errorf("Expected the previous owner to be empty, got %v (%v)", keys, err)
+	}
+}
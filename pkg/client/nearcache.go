@@ -0,0 +1,264 @@
+package client
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// nearCache keeps the values returned by Get in process, so that hot keys
+// are served without a round trip. It holds up to NearCacheSize values,
+// evicting the least recently used, each for at most NearCacheTTL and no
+// longer than the key lives on the server: values are fetched with GET
+// WITHPTTL, which also returns the key's remaining TTL.
+//
+// Values are kept consistent with the servers through their key tracking:
+// the client's pooled connections enable CLIENT TRACKING, so that servers
+// publish the keys the client read on protocol.InvalidateChannel when they
+// change, and the near cache subscribes to that channel on every node.
+// Values are only cached while every node has confirmed the subscription,
+// and everything is dropped when a subscription connection is lost or the
+// ring changes, since invalidations may have been missed. A value fetched
+// while its key was invalidated is not cached either. Writes made through
+// the client drop their key right away, without waiting for the server.
+type nearCache struct {
+	client *Client
+	size   int
+	ttl    time.Duration
+	pubsub *PubSub // Subscription to protocol.InvalidateChannel on every node
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // Key -> element of lru holding a *nearEntry
+	lru     *list.List               // Entries, most recently used first
+	fetches map[string]*nearFetch    // Gets in flight per key
+	up      map[string]*pubSubConn   // Nodes whose subscription is confirmed
+}
+
+// nearEntry is a value held by the near cache.
+type nearEntry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+// nearFetch counts the Gets of a key in flight, whose values are only
+// cached if the key wasn't invalidated meanwhile.
+type nearFetch struct {
+	count int
+	stale bool
+}
+
+// newNearCache creates the near cache of c and subscribes to invalidations.
+// Nodes that can't be reached are retried in the background.
+func newNearCache(c *Client) *nearCache {
+	n := &nearCache{
+		client:  c,
+		size:    c.config.NearCacheSize,
+		ttl:     time.Duration(c.config.NearCacheTTL) * time.Millisecond,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		fetches: make(map[string]*nearFetch),
+		up:      make(map[string]*pubSubConn),
+	}
+
+	ps := c.newPubSub()
+	ps.observer = n
+	ps.mu.Lock()
+	ps.subs[subscriptionChannel][protocol.InvalidateChannel] = nil
+	ps.mu.Unlock()
+	ps.reshard() // Subscribes on every node
+	n.pubsub = ps
+
+	go func() {
+		for msg := range ps.Channel() {
+			n.invalidate(msg.Payload)
+		}
+	}()
+	return n
+}
+
+// get returns the value of key, from the near cache if it holds it and
+// from fetch otherwise. fetch also returns the time until the key expires,
+// or 0 if it doesn't.
+func (n *nearCache) get(key string, fetch func() (string, time.Duration, error)) (string, error) {
+	n.mu.Lock()
+	if elem, exists := n.entries[key]; exists {
+		entry := elem.Value.(*nearEntry) //nolint:errcheck // Only *nearEntry values are stored
+		if time.Now().Before(entry.expires) {
+			n.lru.MoveToFront(elem)
+			n.mu.Unlock()
+			return entry.value, nil
+		}
+		n.remove(elem)
+	}
+	f := n.fetches[key]
+	if f == nil {
+		f = &nearFetch{}
+		n.fetches[key] = f
+	}
+	f.count++
+	n.mu.Unlock()
+
+	start := time.Now() // The TTL counts from before the server replied
+	value, ttl, err := fetch()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if f.count--; f.count == 0 && n.fetches[key] == f {
+		delete(n.fetches, key)
+	}
+	if err == nil && !f.stale && n.ready(key) {
+		expires := start.Add(n.ttl)
+		if ttl > 0 && ttl < n.ttl {
+			expires = start.Add(ttl)
+		}
+		n.add(key, value, expires)
+	}
+	return value, err
+}
+
+// ready reports whether values of key can be cached: invalidations are
+// received from every node and key isn't moving between nodes. Callers
+// must hold n.mu.
+func (n *nearCache) ready(key string) bool {
+	if n.client.previousOwner(key) != "" {
+		return false
+	}
+	nodes := n.client.ring.GetNodes()
+	for _, node := range nodes {
+		if n.up[node] == nil {
+			return false
+		}
+	}
+	return len(nodes) > 0
+}
+
+// add caches the value of key until expires, evicting the least recently
+// used value if the near cache is full. Callers must hold n.mu.
+func (n *nearCache) add(key, value string, expires time.Time) {
+	entry := &nearEntry{key: key, value: value, expires: expires}
+	if elem, exists := n.entries[key]; exists {
+		elem.Value = entry
+		n.lru.MoveToFront(elem)
+		return
+	}
+	if n.lru.Len() >= n.size {
+		n.remove(n.lru.Back())
+	}
+	n.entries[key] = n.lru.PushFront(entry)
+}
+
+// remove drops an entry. Callers must hold n.mu.
+func (n *nearCache) remove(elem *list.Element) {
+	entry := n.lru.Remove(elem).(*nearEntry) //nolint:errcheck // Only *nearEntry values are stored
+	delete(n.entries, entry.key)
+}
+
+// invalidate drops the value of key, and keeps the values of Gets in
+// flight from being cached.
+func (n *nearCache) invalidate(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if elem, exists := n.entries[key]; exists {
+		n.remove(elem)
+	}
+	if f := n.fetches[key]; f != nil {
+		f.stale = true
+		delete(n.fetches, key)
+	}
+}
+
+// reset drops every value, and keeps the values of Gets in flight from
+// being cached. Callers must hold n.mu.
+func (n *nearCache) reset() {
+	clear(n.entries)
+	n.lru.Init()
+	for key, f := range n.fetches {
+		f.stale = true
+		delete(n.fetches, key)
+	}
+}
+
+// subscribed records that nc receives invalidations.
+func (n *nearCache) subscribed(nc *pubSubConn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.up[nc.node] = nc
+}
+
+// lost stops caching until nc resubscribes, since invalidations sent
+// meanwhile are lost.
+func (n *nearCache) lost(nc *pubSubConn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.up[nc.node] == nc {
+		delete(n.up, nc.node)
+	}
+	n.reset()
+}
+
+// resharded drops every value, since keys may have moved to nodes that
+// didn't track them.
+func (n *nearCache) resharded() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.reset()
+}
+
+// close ends the subscription to invalidations.
+func (n *nearCache) close() {
+	n.pubsub.Close() //nolint:errcheck,gosec // Close never fails
+}
+
+// getWithTTL sends GET key WITHPTTL, returning the value of key and the
+// time until it expires, or 0 if it doesn't.
+func (c *Client) getWithTTL(key string) (string, time.Duration, error) {
+	resp, err := c.executeCommand(&protocol.Command{Type: protocol.CmdGet, Key: key, Args: []string{"WITHPTTL"}})
+	if err != nil {
+		return "", 0, err
+	}
+	switch resp.Type {
+	case protocol.RespNil:
+		return "", 0, fmt.Errorf("key not found")
+	case protocol.RespError:
+		return "", 0, fmt.Errorf("server error: %s", resp.Error)
+	}
+
+	pair, ok := resp.Data.([]interface{})
+	if resp.Type != protocol.RespNested || !ok || len(pair) != 2 {
+		return "", 0, fmt.Errorf("unexpected response type")
+	}
+	value, valueOK := pair[0].(string)
+	pttl, pttlOK := pair[1].(int64)
+	if !valueOK || !pttlOK {
+		return "", 0, fmt.Errorf("unexpected response data")
+	}
+	return value, max(time.Duration(pttl)*time.Millisecond, 0), nil
+}
+
+// enableTracking turns on CLIENT TRACKING on a new pooled connection, so
+// that the server invalidates the keys read over it.
+func (c *Client) enableTracking(conn net.Conn) error {
+	deadline := time.Now().Add(time.Duration(c.config.ReadTimeout) * time.Second)
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	cmd := &protocol.Command{Type: protocol.CmdClient, Args: []string{"TRACKING", "ON"}}
+	if err := protocol.WriteCommand(conn, cmd); err != nil {
+		return err
+	}
+	resp, err := protocol.ReadResponse(conn)
+	if err != nil {
+		return err
+	}
+	if resp.Type == protocol.RespError {
+		return fmt.Errorf("server error: %s", resp.Error)
+	}
+	return nil
+}
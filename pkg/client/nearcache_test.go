@@ -0,0 +1,153 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/config"
+)
+
+// newNearCacheClient creates a client with a near cache that keeps values
+// for a minute, so that values only change through invalidations, and
+// waits until its invalidation subscriptions are confirmed.
+func newNearCacheClient(t *testing.T, nodes []string) *Client {
+	t.Helper()
+
+	c := newTestClient(t, nodes, func(cfg *config.ClientConfig) {
+		cfg.NearCacheSize = 100
+		cfg.NearCacheTTL = int(time.Minute / time.Millisecond)
+	})
+	waitFor(t, "invalidation subscriptions", func() bool {
+		c.near.mu.Lock()
+		defer c.near.mu.Unlock()
+		return len(c.near.up) == len(nodes)
+	})
+	return c
+}
+
+// nearEntryOf returns the near cache entry of key, or nil.
+func nearEntryOf(c *Client, key string) *nearEntry {
+	c.near.mu.Lock()
+	defer c.near.mu.Unlock()
+
+	if elem, exists := c.near.entries[key]; exists {
+		return elem.Value.(*nearEntry) //nolint:errcheck // Only *nearEntry values are stored
+	}
+	return nil
+}
+
+func TestNearCacheInvalidatedByOtherClient(t *testing.T) {
+	_, addr := startServer(t)
+	reader := newNearCacheClient(t, []string{addr})
+	writer := newTestClient(t, []string{addr}, nil)
+
+	if err := writer.Set("key", "v1", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if value, err := reader.Get("key"); err != nil || value != "v1" {
+		t.Fatalf("Expected v1, got %q (%v)", value, err)
+	}
+	if entry := nearEntryOf(reader, "key"); entry == nil || entry.value != "v1" {
+		t.Fatalf("Expected v1 in the near cache, got %+v", entry)
+	}
+
+	if err := writer.Set("key", "v2", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	waitFor(t, "invalidation", func() bool { return nearEntryOf(reader, "key") == nil })
+	if value, err := reader.Get("key"); err != nil || value != "v2" {
+		t.Errorf("Expected v2 after the invalidation, got %q (%v)", value, err)
+	}
+}
+
+func TestNearCacheInvalidatedByDel(t *testing.T) {
+	_, addr := startServer(t)
+	reader := newNearCacheClient(t, []string{addr})
+	writer := newTestClient(t, []string{addr}, nil)
+
+	if err := writer.Set("key", "value", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := reader.Get("key"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if _, err := writer.Del("key"); err != nil {
+		t.Fatalf("Del failed: %v", err)
+	}
+
+	waitFor(t, "invalidation", func() bool { return nearEntryOf(reader, "key") == nil })
+	if value, err := reader.Get("key"); err == nil {
+		t.Errorf("Expected the deleted key to be missing, got %q", value)
+	}
+}
+
+func TestNearCacheWriteInvalidatesLocally(t *testing.T) {
+	_, addr := startServer(t)
+	c := newNearCacheClient(t, []string{addr})
+
+	if err := c.Set("key", "v1", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := c.Get("key"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := c.Set("key", "v2", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if value, err := c.Get("key"); err != nil || value != "v2" {
+		t.Errorf("Expected the client's own write to be read back, got %q (%v)", value, err)
+	}
+}
+
+func TestNearCacheHonorsServerTTL(t *testing.T) {
+	_, addr := startServer(t)
+	c := newNearCacheClient(t, []string{addr})
+
+	if err := c.Set("session", "value", time.Second); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	before := time.Now()
+	if _, err := c.Get("session"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	entry := nearEntryOf(c, "session")
+	if entry == nil {
+		t.Fatal("Expected the value in the near cache")
+	}
+	if entry.expires.After(before.Add(time.Second)) {
+		t.Errorf("Expected the entry to expire with the key, within 1s, got %v", entry.expires.Sub(before))
+	}
+
+	time.Sleep(time.Until(before.Add(1100 * time.Millisecond)))
+	if value, err := c.Get("session"); err == nil {
+		t.Errorf("Expected the expired key to be missing, got %q", value)
+	}
+}
+
+func TestNearCacheDroppedWhenSubscriptionLost(t *testing.T) {
+	_, addr := startServer(t)
+	c := newNearCacheClient(t, []string{addr})
+
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := c.Get("key"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	ps := c.near.pubsub
+	ps.mu.Lock()
+	conn := ps.conns[addr].conn
+	ps.mu.Unlock()
+	conn.Close() //nolint:errcheck,gosec // Simulates a network failure
+
+	waitFor(t, "near cache reset", func() bool { return nearEntryOf(c, "key") == nil })
+
+	// Values are cached again once the subscription is restored.
+	waitFor(t, "resubscription", func() bool {
+		if _, err := c.Get("key"); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		return nearEntryOf(c, "key") != nil
+	})
+}
@@ -0,0 +1,681 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// Pub/Sub tuning
+const (
+	messageBufferSize    = 100
+	minReconnectInterval = 100 * time.Millisecond
+	maxReconnectInterval = 5 * time.Second
+)
+
+// subscriptionKind distinguishes channels, patterns and sharded channels.
+type subscriptionKind uint8
+
+const (
+	subscriptionChannel subscriptionKind = iota
+	subscriptionPattern
+	subscriptionShard
+	subscriptionKinds
+)
+
+// subscriptionCommands holds the commands and confirmation name of each kind.
+var subscriptionCommands = [subscriptionKinds]struct {
+	subscribe   protocol.CommandType
+	unsubscribe protocol.CommandType
+	confirm     string
+}{
+	subscriptionChannel: {protocol.CmdSubscribe, protocol.CmdUnsubscribe, "subscribe"},
+	subscriptionPattern: {protocol.CmdPSubscribe, protocol.CmdPUnsubscribe, "psubscribe"},
+	subscriptionShard:   {protocol.CmdSSubscribe, protocol.CmdSUnsubscribe, "ssubscribe"},
+}
+
+// Message is a Pub/Sub message received by a subscription.
+type Message struct {
+	Channel string // Channel the message was published to
+	Pattern string // Pattern that matched, for PSubscribe deliveries
+	Payload string // Message body
+	Sharded bool   // True for messages on sharded channels
+}
+
+// PubSub is a set of channel, pattern and sharded channel subscriptions.
+// Messages from all of them are delivered on a single Go channel.
+//
+// Each subscription is held on the node that owns its name on the ring,
+// over dedicated connections outside the connection pools. Publish sends
+// every message to all nodes, so a subscriber receives it whichever node it
+// is connected to; SPublish sends only to the owning node, where SSubscribe
+// subscribes. Keyspace notification channels and patterns (those starting
+// with "__keyspace@" or "__keyevent@"), as well as CacheMir's own channels
+// (those starting with "__cachemir__:"), are held on every node, since each
+// node only reports events for its own keys. When nodes are added or
+// removed, subscriptions follow their new owners. If a connection drops, it
+// is re-established in the background and its subscriptions are restored;
+// messages published while disconnected are lost.
+type PubSub struct {
+	client   *Client
+	conns    map[string]*pubSubConn                 // Connections per node
+	subs     [subscriptionKinds]map[string][]string // Subscribed name -> nodes
+	waiters  map[string][]chan struct{}             // Pending subscription confirmations
+	messages chan *Message
+	done     chan struct{}
+	wg       sync.WaitGroup
+	mu       sync.Mutex // Protects everything above except messages and done
+	closed   bool
+
+	observer subscriptionObserver // Notified of connection changes, if set before subscribing
+}
+
+// subscriptionObserver follows the state of the connections of a PubSub,
+// for users that must know when messages may have been lost.
+type subscriptionObserver interface {
+	subscribed(nc *pubSubConn) // A subscription was confirmed on nc
+	lost(nc *pubSubConn)       // nc dropped; messages may be lost until it resubscribes
+	resharded()                // Subscriptions moved after a ring change
+}
+
+// pubSubConn is the subscription connection to one node.
+type pubSubConn struct {
+	conn    net.Conn // Current connection, nil while reconnecting
+	node    string
+	stopped bool // Set when the node left the ring
+}
+
+// Subscribe subscribes to channels and returns a PubSub delivering their
+// messages.
+//
+// Example:
+//
+//	sub, err := client.Subscribe("orders:created")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer sub.Close()
+//
+//	for msg := range sub.Channel() {
+//		fmt.Printf("%s: %s\n", msg.Channel, msg.Payload)
+//	}
+//
+// Messages are buffered in the channel up to 100 at a time. When the buffer
+// is full, the reader of each node connection blocks until the application
+// receives again, so the connection stalls: confirmations of later
+// Subscribe calls wait behind the undelivered messages, and a node whose
+// queue for the connection fills up disconnects it as a slow subscriber.
+// Messages published until the connection is restored are lost. Receive
+// promptly, handing slow work to other goroutines.
+//
+// Returns:
+//   - A PubSub for receiving messages and managing subscriptions
+//   - Error if a node can't be reached or doesn't confirm the subscription
+func (c *Client) Subscribe(channels ...string) (*PubSub, error) {
+	ps := c.newPubSub()
+	if err := ps.Subscribe(channels...); err != nil {
+		ps.Close()
+		return nil, err
+	}
+	return ps, nil
+}
+
+// PSubscribe subscribes to glob patterns (such as "orders:*") and returns a
+// PubSub delivering matching messages.
+//
+// Example:
+//
+//	sub, err := client.PSubscribe("orders:*")
+func (c *Client) PSubscribe(patterns ...string) (*PubSub, error) {
+	ps := c.newPubSub()
+	if err := ps.PSubscribe(patterns...); err != nil {
+		ps.Close()
+		return nil, err
+	}
+	return ps, nil
+}
+
+// SSubscribe subscribes to sharded channels and returns a PubSub delivering
+// their messages. Sharded channels live only on the node that owns the
+// channel name, so SPublish costs one node instead of the whole cluster.
+//
+// Example:
+//
+//	sub, err := client.SSubscribe("user:42:events")
+func (c *Client) SSubscribe(channels ...string) (*PubSub, error) {
+	ps := c.newPubSub()
+	if err := ps.SSubscribe(channels...); err != nil {
+		ps.Close()
+		return nil, err
+	}
+	return ps, nil
+}
+
+// Publish sends a message to a channel on every node, in parallel, so that
+// subscribers receive it whichever node they are connected to. Servers
+// don't forward messages to each other, so a PUBLISH sent to a single node,
+// by another client or a script, only reaches that node's subscribers.
+//
+// Example:
+//
+//	receivers, err := client.Publish("orders:created", `{"id":42}`)
+//
+// Returns:
+//   - Number of subscribers that received the message, across all nodes
+//   - Error if the message could not be sent to any node
+func (c *Client) Publish(channel, message string) (int64, error) {
+	nodes := c.ring.GetNodes()
+	if len(nodes) == 0 {
+		return 0, fmt.Errorf("no available nodes")
+	}
+
+	cmd := &protocol.Command{Type: protocol.CmdPublish, Key: channel, Args: []string{message}}
+	readTimeout := time.Duration(c.config.ReadTimeout) * time.Second
+
+	replies := make(chan replicaReply, len(nodes))
+	for _, node := range nodes {
+		go func() {
+			resp, err := c.executeOnNode(node, cmd, readTimeout)
+			if err == nil && resp.Type == protocol.RespError {
+				err = fmt.Errorf("server error: %s", resp.Error)
+			}
+			replies <- replicaReply{node: node, resp: resp, err: err}
+		}()
+	}
+
+	var receivers int64
+	var lastErr error
+	delivered := false
+	for range nodes {
+		reply := <-replies
+		if reply.err != nil {
+			lastErr = reply.err
+			continue
+		}
+		if n, ok := reply.resp.Data.(int64); ok {
+			receivers += n
+		}
+		delivered = true
+	}
+
+	if !delivered {
+		return 0, lastErr
+	}
+	return receivers, nil
+}
+
+// SPublish sends a message to a sharded channel on the node that owns it.
+//
+// Example:
+//
+//	receivers, err := client.SPublish("user:42:events", "logged_in")
+//
+// Returns:
+//   - Number of subscribers that received the message
+//   - Error if the operation fails
+func (c *Client) SPublish(channel, message string) (int64, error) {
+	return c.executeInt64CommandWithArgs(protocol.CmdSPublish, channel, []string{message})
+}
+
+func (c *Client) newPubSub() *PubSub {
+	ps := &PubSub{
+		client:   c,
+		conns:    make(map[string]*pubSubConn),
+		waiters:  make(map[string][]chan struct{}),
+		messages: make(chan *Message, messageBufferSize),
+		done:     make(chan struct{}),
+	}
+	for kind := range ps.subs {
+		ps.subs[kind] = make(map[string][]string)
+	}
+
+	c.mu.Lock()
+	c.pubsubs[ps] = struct{}{}
+	c.mu.Unlock()
+	return ps
+}
+
+// Channel returns the Go channel on which messages are delivered. It is
+// closed by Close.
+func (ps *PubSub) Channel() <-chan *Message {
+	return ps.messages
+}
+
+// Subscribe adds channel subscriptions and waits for them to be confirmed.
+func (ps *PubSub) Subscribe(channels ...string) error {
+	return ps.subscribe(subscriptionChannel, channels)
+}
+
+// PSubscribe adds pattern subscriptions and waits for them to be confirmed.
+func (ps *PubSub) PSubscribe(patterns ...string) error {
+	return ps.subscribe(subscriptionPattern, patterns)
+}
+
+// SSubscribe adds sharded channel subscriptions and waits for them to be
+// confirmed.
+func (ps *PubSub) SSubscribe(channels ...string) error {
+	return ps.subscribe(subscriptionShard, channels)
+}
+
+// Unsubscribe removes channel subscriptions, or all of them if none are given.
+func (ps *PubSub) Unsubscribe(channels ...string) error {
+	return ps.unsubscribe(subscriptionChannel, channels)
+}
+
+// PUnsubscribe removes pattern subscriptions, or all of them if none are given.
+func (ps *PubSub) PUnsubscribe(patterns ...string) error {
+	return ps.unsubscribe(subscriptionPattern, patterns)
+}
+
+// SUnsubscribe removes sharded channel subscriptions, or all of them if none
+// are given.
+func (ps *PubSub) SUnsubscribe(channels ...string) error {
+	return ps.unsubscribe(subscriptionShard, channels)
+}
+
+// subscribe sends a subscription command for names to their owning nodes,
+// then waits for every confirmation.
+func (ps *PubSub) subscribe(kind subscriptionKind, names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("at least one channel or pattern is required")
+	}
+
+	placements := make(map[string][]string, len(names))
+	byNode := make(map[string][]string)
+	for _, name := range names {
+		nodes := ps.placement(name)
+		if len(nodes) == 0 {
+			return fmt.Errorf("no available nodes")
+		}
+		placements[name] = nodes
+		for _, node := range nodes {
+			byNode[node] = append(byNode[node], name)
+		}
+	}
+
+	ps.mu.Lock()
+	if ps.closed {
+		ps.mu.Unlock()
+		return fmt.Errorf("pubsub is closed")
+	}
+
+	confirm := subscriptionCommands[kind].confirm
+	var waits []chan struct{}
+	for node, nodeNames := range byNode {
+		nc, err := ps.connect(node)
+		if err != nil {
+			ps.mu.Unlock()
+			return err
+		}
+
+		for _, name := range nodeNames {
+			ps.subs[kind][name] = placements[name]
+			ch := make(chan struct{})
+			ps.waiters[confirm+":"+name] = append(ps.waiters[confirm+":"+name], ch)
+			waits = append(waits, ch)
+		}
+		ps.send(nc, &protocol.Command{Type: subscriptionCommands[kind].subscribe, Args: nodeNames})
+	}
+	ps.mu.Unlock()
+
+	timeout := time.NewTimer(time.Duration(ps.client.config.ReadTimeout) * time.Second)
+	defer timeout.Stop()
+	for _, ch := range waits {
+		select {
+		case <-ch:
+		case <-timeout.C:
+			return fmt.Errorf("timed out waiting for %s confirmation", confirm)
+		case <-ps.done:
+			return fmt.Errorf("pubsub is closed")
+		}
+	}
+	return nil
+}
+
+// unsubscribe removes subscriptions of one kind, or all of them if names is
+// empty.
+func (ps *PubSub) unsubscribe(kind subscriptionKind, names []string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	subs := ps.subs[kind]
+	if len(names) == 0 {
+		for name := range subs {
+			names = append(names, name)
+		}
+	}
+
+	byNode := make(map[string][]string)
+	for _, name := range names {
+		for _, node := range subs[name] {
+			byNode[node] = append(byNode[node], name)
+		}
+		delete(subs, name)
+	}
+	for node, nodeNames := range byNode {
+		if nc, exists := ps.conns[node]; exists {
+			ps.send(nc, &protocol.Command{Type: subscriptionCommands[kind].unsubscribe, Args: nodeNames})
+		}
+	}
+	return nil
+}
+
+// reshard moves subscriptions to the nodes that now own their names and
+// stops connections to nodes that left the ring. The client calls it
+// after AddNode and RemoveNode.
+func (ps *PubSub) reshard() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.closed {
+		return
+	}
+	if ps.observer != nil {
+		defer ps.observer.resharded()
+	}
+
+	members := make(map[string]bool)
+	for _, node := range ps.client.ring.GetNodes() {
+		members[node] = true
+	}
+	for node, nc := range ps.conns {
+		if !members[node] {
+			nc.stopped = true
+			if nc.conn != nil {
+				if err := nc.conn.Close(); err != nil {
+					log.Printf("Error closing connection: %v", err)
+				}
+			}
+			delete(ps.conns, node)
+		}
+	}
+
+	for kind, subs := range ps.subs {
+		added := make(map[string][]string)
+		for name, nodes := range subs {
+			want := ps.placement(name)
+			if len(want) == 0 {
+				continue
+			}
+			for _, node := range nodes {
+				if nc, exists := ps.conns[node]; exists && !slices.Contains(want, node) {
+					ps.send(nc, &protocol.Command{Type: subscriptionCommands[kind].unsubscribe, Args: []string{name}})
+				}
+			}
+			for _, node := range want {
+				if !slices.Contains(nodes, node) {
+					added[node] = append(added[node], name)
+				}
+			}
+			subs[name] = want
+		}
+
+		for node, names := range added {
+			nc, err := ps.connect(node)
+			if err != nil {
+				// Keep retrying in the background; subscriptions are
+				// restored once the connection is up.
+				log.Printf("Pub/Sub connect to %s failed: %v", node, err)
+				nc = ps.start(node, nil)
+			}
+			ps.send(nc, &protocol.Command{Type: subscriptionCommands[kind].subscribe, Args: names})
+		}
+	}
+}
+
+// redial closes the connection to node, if any, so that it reconnects to
+// the node's current address and restores its subscriptions. The client
+// calls it when a sentinel moves a node.
+func (ps *PubSub) redial(node string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if nc, exists := ps.conns[node]; exists && nc.conn != nil {
+		if err := nc.conn.Close(); err != nil {
+			log.Printf("Error closing connection: %v", err)
+		}
+	}
+}
+
+// placement returns the nodes that should hold a subscription to name.
+func (ps *PubSub) placement(name string) []string {
+	if strings.HasPrefix(name, "__keyspace@") || strings.HasPrefix(name, "__keyevent@") ||
+		strings.HasPrefix(name, "__cachemir__:") {
+		return ps.client.ring.GetNodes()
+	}
+	if node := ps.client.ring.GetNode(name); node != "" {
+		return []string{node}
+	}
+	return nil
+}
+
+// Close ends all subscriptions, closes their connections and closes the
+// message channel.
+func (ps *PubSub) Close() error {
+	ps.client.mu.Lock()
+	delete(ps.client.pubsubs, ps)
+	ps.client.mu.Unlock()
+
+	ps.mu.Lock()
+	if ps.closed {
+		ps.mu.Unlock()
+		return nil
+	}
+	ps.closed = true
+	close(ps.done)
+	for _, nc := range ps.conns {
+		if nc.conn != nil {
+			if err := nc.conn.Close(); err != nil {
+				log.Printf("Error closing connection: %v", err)
+			}
+		}
+	}
+	ps.mu.Unlock()
+
+	ps.wg.Wait()
+	close(ps.messages)
+	return nil
+}
+
+// connect returns the connection to node, dialing it and starting its reader
+// if this is the first subscription there. Callers must hold ps.mu.
+func (ps *PubSub) connect(node string) (*pubSubConn, error) {
+	if nc, exists := ps.conns[node]; exists {
+		return nc, nil
+	}
+
+	conn, err := ps.dial(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", node, err)
+	}
+	return ps.start(node, conn), nil
+}
+
+// start registers a connection to node and runs its reader. A nil conn
+// makes the reader dial in the background. Callers must hold ps.mu.
+func (ps *PubSub) start(node string, conn net.Conn) *pubSubConn {
+	nc := &pubSubConn{node: node, conn: conn}
+	ps.conns[node] = nc
+	ps.wg.Add(1)
+	go ps.run(nc, conn)
+	return nc
+}
+
+func (ps *PubSub) dial(node string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: time.Duration(ps.client.config.ConnTimeout) * time.Second}
+	return dialer.DialContext(context.Background(), "tcp", ps.client.nodeAddress(node))
+}
+
+// send writes a command on a subscription connection. A failed write closes
+// the connection so the reader reconnects and restores all subscriptions.
+// Callers must hold ps.mu.
+func (ps *PubSub) send(nc *pubSubConn, cmd *protocol.Command) {
+	if nc.conn == nil {
+		return // Reconnecting; subscriptions are restored from ps state
+	}
+
+	deadline := time.Now().Add(time.Duration(ps.client.config.WriteTimeout) * time.Second)
+	err := nc.conn.SetWriteDeadline(deadline)
+	if err == nil {
+		err = protocol.WriteCommand(nc.conn, cmd)
+	}
+	if err != nil {
+		log.Printf("Pub/Sub write to %s failed: %v", nc.node, err)
+		if closeErr := nc.conn.Close(); closeErr != nil {
+			log.Printf("Error closing connection: %v", closeErr)
+		}
+	}
+}
+
+// run reads push messages from a node, reconnecting and resubscribing with
+// exponential backoff whenever the connection fails.
+func (ps *PubSub) run(nc *pubSubConn, conn net.Conn) {
+	defer ps.wg.Done()
+
+	backoff := minReconnectInterval
+	for {
+		if conn != nil {
+			ps.readLoop(nc, conn)
+			if ps.observer != nil {
+				ps.observer.lost(nc)
+			}
+		}
+
+		ps.mu.Lock()
+		nc.conn = nil
+		stopped := nc.stopped
+		ps.mu.Unlock()
+		if stopped {
+			return
+		}
+
+		select {
+		case <-ps.done:
+			return
+		case <-time.After(backoff):
+		}
+
+		var err error
+		if conn, err = ps.dial(nc.node); err != nil {
+			log.Printf("Pub/Sub reconnect to %s failed: %v", nc.node, err)
+			backoff = min(backoff*2, maxReconnectInterval)
+			continue
+		}
+		backoff = minReconnectInterval
+
+		ps.mu.Lock()
+		if ps.closed || nc.stopped {
+			ps.mu.Unlock()
+			if err := conn.Close(); err != nil {
+				log.Printf("Error closing connection: %v", err)
+			}
+			return
+		}
+		nc.conn = conn
+		ps.resubscribe(nc)
+		ps.mu.Unlock()
+	}
+}
+
+// resubscribe restores the subscriptions held on nc's node after a
+// reconnect. Callers must hold ps.mu.
+func (ps *PubSub) resubscribe(nc *pubSubConn) {
+	for kind, subs := range ps.subs {
+		var names []string
+		for name, nodes := range subs {
+			if slices.Contains(nodes, nc.node) {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			ps.send(nc, &protocol.Command{Type: subscriptionCommands[kind].subscribe, Args: names})
+		}
+	}
+}
+
+// readLoop delivers push messages from conn until it fails or is closed.
+func (ps *PubSub) readLoop(nc *pubSubConn, conn net.Conn) {
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		log.Printf("Error clearing read deadline: %v", err)
+		return
+	}
+
+	for {
+		resp, err := protocol.ReadResponse(conn)
+		if err != nil {
+			ps.mu.Lock()
+			expected := ps.closed || nc.stopped
+			ps.mu.Unlock()
+			if !expected {
+				log.Printf("Pub/Sub connection to %s lost: %v", nc.node, err)
+			}
+			if closeErr := conn.Close(); closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
+				log.Printf("Error closing connection: %v", closeErr)
+			}
+			return
+		}
+
+		if resp.Type == protocol.RespError {
+			log.Printf("Pub/Sub server error: %s", resp.Error)
+			continue
+		}
+		items, ok := resp.Data.([]interface{})
+		if resp.Type != protocol.RespPush || !ok || len(items) == 0 {
+			continue
+		}
+
+		if msg := ps.handlePush(nc, items); msg != nil {
+			select {
+			case ps.messages <- msg:
+			case <-ps.done:
+				return
+			}
+		}
+	}
+}
+
+// handlePush decodes a push message received on nc, resolving subscription
+// confirmations. Returns the message to deliver, if any.
+func (ps *PubSub) handlePush(nc *pubSubConn, items []interface{}) *Message {
+	kind, _ := items[0].(string)
+	str := func(i int) string {
+		if i < len(items) {
+			s, _ := items[i].(string)
+			return s
+		}
+		return ""
+	}
+
+	switch kind {
+	case "message":
+		return &Message{Channel: str(1), Payload: str(2)}
+	case "pmessage":
+		return &Message{Pattern: str(1), Channel: str(2), Payload: str(3)}
+	case "smessage":
+		return &Message{Channel: str(1), Payload: str(2), Sharded: true}
+	case "subscribe", "psubscribe", "ssubscribe":
+		key := kind + ":" + str(1)
+		ps.mu.Lock()
+		if waiters := ps.waiters[key]; len(waiters) > 0 {
+			close(waiters[0])
+			if len(waiters) == 1 {
+				delete(ps.waiters, key)
+			} else {
+				ps.waiters[key] = waiters[1:]
+			}
+		}
+		ps.mu.Unlock()
+		if ps.observer != nil {
+			ps.observer.subscribed(nc)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// receive returns the next message of ps, failing the test if none arrives
+// within a second.
+func receive(t *testing.T, ps *PubSub) *Message {
+	t.Helper()
+
+	select {
+	case msg := <-ps.Channel():
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a message")
+		return nil
+	}
+}
+
+// expectNoMessage fails the test if ps receives a message within 100ms.
+func expectNoMessage(t *testing.T, ps *PubSub) {
+	t.Helper()
+
+	select {
+	case msg := <-ps.Channel():
+		t.Errorf("Expected no message, got %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// drain discards messages until none arrives for 100ms.
+func drain(ps *PubSub) {
+	for {
+		select {
+		case <-ps.Channel():
+		case <-time.After(100 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func TestSubscribeReceivesMessages(t *testing.T) {
+	_, addr := startServer(t)
+	c := newTestClient(t, []string{addr}, nil)
+
+	ps, err := c.Subscribe("news")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer ps.Close() //nolint:errcheck // Nothing to do on failure
+
+	if n, err := c.Publish("news", "hello"); err != nil || n != 1 {
+		t.Fatalf("Expected 1 receiver, got %d (%v)", n, err)
+	}
+	if msg := receive(t, ps); msg.Channel != "news" || msg.Payload != "hello" || msg.Pattern != "" || msg.Sharded {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+
+	if err := ps.Unsubscribe("news"); err != nil {
+		t.Fatalf("Unsubscribe failed: %v", err)
+	}
+	waitFor(t, "unsubscription", func() bool {
+		n, err := c.Publish("news", "probe")
+		return err == nil && n == 0
+	})
+	drain(ps) // Probes delivered before the unsubscription
+	if _, err := c.Publish("news", "dropped"); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	expectNoMessage(t, ps)
+}
+
+func TestPSubscribeReceivesMatchingMessages(t *testing.T) {
+	_, addr := startServer(t)
+	c := newTestClient(t, []string{addr}, nil)
+
+	ps, err := c.PSubscribe("orders:*")
+	if err != nil {
+		t.Fatalf("PSubscribe failed: %v", err)
+	}
+	defer ps.Close() //nolint:errcheck // Nothing to do on failure
+
+	if _, err := c.Publish("orders:created", "42"); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	msg := receive(t, ps)
+	if msg.Pattern != "orders:*" || msg.Channel != "orders:created" || msg.Payload != "42" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+
+	if n, err := c.Publish("invoices:created", "7"); err != nil || n != 0 {
+		t.Errorf("Expected no receivers for a channel outside the pattern, got %d (%v)", n, err)
+	}
+	expectNoMessage(t, ps)
+}
+
+func TestSSubscribeReceivesShardedMessages(t *testing.T) {
+	_, addr := startServer(t)
+	c := newTestClient(t, []string{addr}, nil)
+
+	ps, err := c.SSubscribe("user:42")
+	if err != nil {
+		t.Fatalf("SSubscribe failed: %v", err)
+	}
+	defer ps.Close() //nolint:errcheck // Nothing to do on failure
+
+	if n, err := c.SPublish("user:42", "logged_in"); err != nil || n != 1 {
+		t.Fatalf("Expected 1 receiver, got %d (%v)", n, err)
+	}
+	if msg := receive(t, ps); !msg.Sharded || msg.Channel != "user:42" || msg.Payload != "logged_in" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+}
+
+func TestPubSubResubscribesAfterReconnect(t *testing.T) {
+	_, addr := startServer(t)
+	c := newTestClient(t, []string{addr}, nil)
+
+	ps, err := c.Subscribe("news")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer ps.Close() //nolint:errcheck // Nothing to do on failure
+	if err := ps.PSubscribe("alerts:*"); err != nil {
+		t.Fatalf("PSubscribe failed: %v", err)
+	}
+
+	ps.mu.Lock()
+	conn := ps.conns[addr].conn
+	ps.mu.Unlock()
+	conn.Close() //nolint:errcheck,gosec // Simulates a network failure
+
+	// Both subscriptions are restored on the new connection. Until the node
+	// notices the old connection is gone, it may still count it as a
+	// receiver, so wait for actual deliveries.
+	for _, channel := range []string{"news", "alerts:disk"} {
+		waitFor(t, "resubscription to "+channel, func() bool {
+			if _, err := c.Publish(channel, "back"); err != nil {
+				t.Fatalf("Publish failed: %v", err)
+			}
+			select {
+			case msg := <-ps.Channel():
+				return msg.Channel == channel && msg.Payload == "back"
+			case <-time.After(50 * time.Millisecond):
+				return false
+			}
+		})
+	}
+
+	ps.mu.Lock()
+	reconnected := ps.conns[addr].conn
+	ps.mu.Unlock()
+	if reconnected == nil || reconnected == conn {
+		t.Error("Expected a new connection")
+	}
+}
+
+func TestPubSubClose(t *testing.T) {
+	_, addr := startServer(t)
+	c := newTestClient(t, []string{addr}, nil)
+
+	ps, err := c.Subscribe("news")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, open := <-ps.Channel(); open {
+		t.Error("Expected Close to close the message channel")
+	}
+	if err := ps.Subscribe("more"); err == nil {
+		t.Error("Expected Subscribe to fail after Close")
+	}
+	waitFor(t, "the subscription to be dropped", func() bool {
+		n, err := c.Publish("news", "nobody")
+		return err == nil && n == 0
+	})
+}
+
+func TestPublishReachesEveryNode(t *testing.T) {
+	addrs := make([]string, 3)
+	for i := range addrs {
+		_, addrs[i] = startServer(t)
+	}
+	c := newTestClient(t, addrs, nil)
+
+	// One subscriber per node, each connected to that node only.
+	subs := make([]*PubSub, len(addrs))
+	for i, addr := range addrs {
+		ps, err := newTestClient(t, []string{addr}, nil).Subscribe("news")
+		if err != nil {
+			t.Fatalf("Subscribe on %s failed: %v", addr, err)
+		}
+		defer ps.Close() //nolint:errcheck // Nothing to do on failure
+		subs[i] = ps
+	}
+
+	if n, err := c.Publish("news", "everywhere"); err != nil || n != int64(len(addrs)) {
+		t.Fatalf("Expected %d receivers, got %d (%v)", len(addrs), n, err)
+	}
+	for i, ps := range subs {
+		if msg := receive(t, ps); msg.Channel != "news" || msg.Payload != "everywhere" {
+			t.Errorf("Unexpected message on %s: %+v", addrs[i], msg)
+		}
+	}
+}
+
+func TestPublishAcrossNodes(t *testing.T) {
+	_, addrA := startServer(t)
+	_, addrB := startServer(t)
+	ring := []string{addrA, addrB}
+	subscriber, publisher := newTestClient(t, ring, nil), newTestClient(t, ring, nil)
+
+	// The subscription is held on B, which owns the channel.
+	channel := keyOwnedBy(t, subscriber, addrB)
+	ps, err := subscriber.Subscribe(channel)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer ps.Close() //nolint:errcheck // Nothing to do on failure
+
+	if n, err := publisher.Publish(channel, "hello"); err != nil || n != 1 {
+		t.Fatalf("Expected the subscriber on the other node to receive the message, got %d (%v)", n, err)
+	}
+	if msg := receive(t, ps); msg.Channel != channel || msg.Payload != "hello" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+
+	// Servers don't forward messages, so a PUBLISH sent to A alone doesn't
+	// reach B's subscribers.
+	if n, err := newTestClient(t, []string{addrA}, nil).Publish(channel, "local"); err != nil || n != 0 {
+		t.Errorf("Expected no receiver on A, got %d (%v)", n, err)
+	}
+	expectNoMessage(t, ps)
+}
+
+func TestPublishToleratesDownNodes(t *testing.T) {
+	_, addr := startServer(t)
+	down := fmt.Sprintf("127.0.0.1:%d", freePort(t)) // Nothing listens there
+	c := newTestClient(t, []string{addr, down}, nil)
+
+	ps, err := newTestClient(t, []string{addr}, nil).Subscribe("news")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer ps.Close() //nolint:errcheck // Nothing to do on failure
+
+	if n, err := c.Publish("news", "partial"); err != nil || n != 1 {
+		t.Fatalf("Expected the live node to receive the message, got %d (%v)", n, err)
+	}
+	receive(t, ps)
+
+	if _, err := newTestClient(t, []string{down}, nil).Publish("news", "lost"); err == nil {
+		t.Error("Expected Publish to fail when no node is reachable")
+	}
+}
+
+func TestSPublishReachesOwningNodes(t *testing.T) {
+	addrs := make([]string, 3)
+	for i := range addrs {
+		_, addrs[i] = startServer(t)
+	}
+	c := newTestClient(t, addrs, nil)
+
+	// Find a sharded channel owned by each node.
+	channels := make(map[string]string) // Node -> channel
+	for i := 0; len(channels) < len(addrs); i++ {
+		if i > 10000 {
+			t.Fatal("Expected every node to own some channel")
+		}
+		channel := fmt.Sprintf("user:%d", i)
+		if node := c.ring.GetNode(channel); channels[node] == "" {
+			channels[node] = channel
+		}
+	}
+
+	names := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		names = append(names, channel)
+	}
+	ps, err := c.SSubscribe(names...)
+	if err != nil {
+		t.Fatalf("SSubscribe failed: %v", err)
+	}
+	defer ps.Close() //nolint:errcheck // Nothing to do on failure
+
+	for node, channel := range channels {
+		if n, err := c.SPublish(channel, "sharded"); err != nil || n != 1 {
+			t.Fatalf("Expected 1 receiver for %s on %s, got %d (%v)", channel, node, n, err)
+		}
+		if msg := receive(t, ps); !msg.Sharded || msg.Channel != channel || msg.Payload != "sharded" {
+			t.Errorf("Unexpected message %+v", msg)
+		}
+
+		// Only the owner holds the subscription.
+		other, err := newTestClient(t, []string{node}, nil).SPublish(channel, "direct")
+		if err != nil || other != 1 {
+			t.Errorf("Expected the subscription on %s, got %d (%v)", node, other, err)
+		}
+		receive(t, ps)
+	}
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/cache"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// rateLimitReplyFields is the number of fields in a rate limiter reply.
+const rateLimitReplyFields = 5
+
+// GCRA makes a request of quantity units against the GCRA rate limiter at
+// key, in one atomic round trip. See cache.Cache.GCRA for the algorithm.
+//
+// Example:
+//
+//	limit := cache.RateLimit{Burst: 10, Count: 100, Period: time.Minute}
+//	result, err := client.GCRA("ratelimit:alice", limit, 1)
+//	if err == nil && !result.Allowed {
+//		w.Header().Set("Retry-After", strconv.Itoa(int(result.RetryAfter.Seconds())+1))
+//	}
+//
+// Parameters:
+//   - key: The limiter key
+//   - limit: The rate limit; Period is sent in whole milliseconds
+//   - quantity: Units this request costs (0 only inspects the limiter)
+//
+// Returns:
+//   - The outcome of the request
+//   - Error if the parameters are invalid or the operation fails
+func (c *Client) GCRA(key string, limit cache.RateLimit, quantity int64) (cache.RateLimitResult, error) {
+	return c.rateLimit(&protocol.Command{Type: protocol.CmdGCRA, Key: key}, limit, quantity)
+}
+
+// TokenBucket takes tokens from the token bucket at key, in one atomic
+// round trip. The bucket holds up to limit.Burst tokens and is refilled with
+// limit.Count tokens per period. See cache.Cache.TokenBucket.
+//
+// Example:
+//
+//	limit := cache.RateLimit{Burst: 20, Count: 5, Period: time.Second}
+//	result, err := client.TokenBucket("ratelimit:upload", limit, 1)
+func (c *Client) TokenBucket(key string, limit cache.RateLimit, tokens int64) (cache.RateLimitResult, error) {
+	return c.rateLimit(&protocol.Command{Type: protocol.CmdTokenBucket, Key: key}, limit, tokens)
+}
+
+// rateLimit adds the limit and the quantity n to a rate limiter command,
+// sends it and decodes its reply.
+func (c *Client) rateLimit(cmd *protocol.Command, limit cache.RateLimit, n int64) (cache.RateLimitResult, error) {
+	cmd.Args = []string{
+		strconv.FormatInt(limit.Burst, 10),
+		strconv.FormatInt(limit.Count, 10),
+		strconv.FormatInt(limit.Period.Milliseconds(), 10),
+		strconv.FormatInt(n, 10),
+	}
+
+	resp, err := c.executeCommand(cmd)
+	if err != nil {
+		return cache.RateLimitResult{}, err
+	}
+
+	switch resp.Type {
+	case protocol.RespNested:
+	case protocol.RespError:
+		return cache.RateLimitResult{}, fmt.Errorf("server error: %s", resp.Error)
+	default:
+		return cache.RateLimitResult{}, fmt.Errorf("unexpected response type")
+	}
+
+	items, _ := resp.Data.([]interface{})
+	if len(items) != rateLimitReplyFields {
+		return cache.RateLimitResult{}, fmt.Errorf("unexpected response data")
+	}
+	fields := make([]int64, len(items))
+	for i, item := range items {
+		n, ok := item.(int64)
+		if !ok {
+			return cache.RateLimitResult{}, fmt.Errorf("unexpected response data")
+		}
+		fields[i] = n
+	}
+
+	result := cache.RateLimitResult{
+		Allowed:    fields[0] == 1,
+		Limit:      fields[1],
+		Remaining:  fields[2],
+		RetryAfter: time.Duration(fields[3]) * time.Millisecond,
+		ResetAfter: time.Duration(fields[4]) * time.Millisecond,
+	}
+	if fields[3] < 0 {
+		result.RetryAfter = -1
+	}
+	return result, nil
+}
@@ -0,0 +1,278 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// replicaKind describes how a replicated command is executed.
+type replicaKind uint8
+
+const (
+	replicaWrite      replicaKind = iota // Sent to all replicas, succeeds with W acknowledgements
+	replicaRead                          // Sent to all replicas, answered by the majority of R replies
+	replicaReadRepair                    // A replicaRead that also repairs diverging replicas
+)
+
+// replicatedCommands are the commands sent to every replica of their key
+// when ReplicationFactor is above 1. Other commands only go to the first
+// replica, the key's owner on the ring.
+var replicatedCommands = map[protocol.CommandType]replicaKind{
+	protocol.CmdSet:     replicaWrite,
+	protocol.CmdDel:     replicaWrite,
+	protocol.CmdIncr:    replicaWrite,
+	protocol.CmdDecr:    replicaWrite,
+	protocol.CmdIncrBy:  replicaWrite,
+	protocol.CmdDecrBy:  replicaWrite,
+	protocol.CmdExpire:  replicaWrite,
+	protocol.CmdPersist: replicaWrite,
+	protocol.CmdGet:     replicaReadRepair,
+	protocol.CmdExists:  replicaRead,
+	protocol.CmdTTL:     replicaRead,
+}
+
+// deleteIfVersionScript deletes a key only if it still has the version
+// given as the first argument.
+var deleteIfVersionScript = NewScript(`
+local current = redis.call('GETV', KEYS[1])
+if current and current[2] == tonumber(ARGV[1]) then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+
+// replicaReply is the outcome of a command on one replica.
+type replicaReply struct {
+	node string
+	resp *protocol.Response
+	err  error
+}
+
+// versionedValue is a replica's answer to GETV key WITHPTTL.
+type versionedValue struct {
+	value   string
+	version uint64
+	pttl    int64 // Remaining TTL in milliseconds, -1 for none
+	exists  bool
+}
+
+// executeReplicated sends cmd to the ReplicationFactor nodes that hold its
+// key, in parallel. A write succeeds once WriteQuorum replicas have
+// answered and returns the answer of the most preferred of them. A read
+// waits for ReadQuorum answers and returns the one given by most of them,
+// preferring earlier replicas on ties. GET is sent as GETV instead and
+// returns the newest value, by version; it then compares the answers of
+// all replicas in the background and rewrites those holding older values.
+func (c *Client) executeReplicated(cmd *protocol.Command, kind replicaKind) (*protocol.Response, error) {
+	if cmd.Type == protocol.CmdSet && len(cmd.Args) > 1 {
+		// Versions are per node, so they can't be compared across replicas.
+		return nil, fmt.Errorf("compare-and-set is not supported with a replication factor above 1")
+	}
+
+	nodes := c.ring.GetNodesForKey(cmd.Key, c.config.ReplicationFactor)
+	write, read := c.config.Quorums()
+	quorum := write
+	if kind != replicaWrite {
+		quorum = read
+	}
+	if len(nodes) < quorum {
+		return nil, fmt.Errorf("quorum of %d replicas needs more than the %d available nodes", quorum, len(nodes))
+	}
+
+	sent := cmd
+	if kind == replicaReadRepair {
+		sent = &protocol.Command{Type: protocol.CmdGetV, Key: cmd.Key, Args: []string{"WITHPTTL"}}
+	}
+
+	replies := make(chan replicaReply, len(nodes))
+	timeout := time.Duration(c.config.ReadTimeout) * time.Second
+	for _, node := range nodes {
+		go func() {
+			if c.nodeDown(node) {
+				replies <- replicaReply{node: node, err: fmt.Errorf("node %s is down", node)}
+				return
+			}
+			resp, err := c.executeOnNode(node, sent, timeout)
+			replies <- replicaReply{node: node, resp: resp, err: err}
+		}()
+	}
+
+	var received []replicaReply
+	var lastErr error
+	failed := 0
+	for len(received) < quorum {
+		reply := <-replies
+		if reply.err != nil {
+			failed++
+			lastErr = reply.err
+			if len(nodes)-failed < quorum {
+				return nil, fmt.Errorf("quorum of %d replicas not reached: %v", quorum, lastErr)
+			}
+			continue
+		}
+		received = append(received, reply)
+	}
+
+	// Order replies by replica preference.
+	slices.SortFunc(received, func(a, b replicaReply) int {
+		return slices.Index(nodes, a.node) - slices.Index(nodes, b.node)
+	})
+	switch kind {
+	case replicaWrite:
+		return received[0].resp, nil
+	case replicaRead:
+		return majorityReply(received).resp, nil
+	}
+
+	winner := newestReply(received)
+	pending := len(nodes) - len(received) - failed
+	go c.readRepair(cmd.Key, winner, received, replies, pending)
+	return getReply(cmd, winner.resp), nil
+}
+
+// majorityReply returns the reply given by most replicas, preferring the
+// earliest on ties. replies must be ordered by preference.
+func majorityReply(replies []replicaReply) replicaReply {
+	counts := make(map[string]int, len(replies))
+	for _, reply := range replies {
+		counts[replyKey(reply.resp)]++
+	}
+
+	best := replies[0]
+	for _, reply := range replies[1:] {
+		if counts[replyKey(reply.resp)] > counts[replyKey(best.resp)] {
+			best = reply
+		}
+	}
+	return best
+}
+
+// newestReply returns the reply to GETV WITHPTTL holding the newest value,
+// preferring the earliest on ties. A missing key wins instead if most
+// replies are missing: without a record of deletions, a value that only a
+// minority of replicas hold is taken for a deletion they missed, and a
+// value that most of them hold for a write the others missed. replies must
+// be ordered by preference.
+func newestReply(replies []replicaReply) replicaReply {
+	var newest, missing *replicaReply
+	var version uint64
+	nils := 0
+	for i, reply := range replies {
+		got, ok := parseVersioned(reply.resp)
+		switch {
+		case !ok:
+		case !got.exists:
+			if missing == nil {
+				missing = &replies[i]
+			}
+			nils++
+		case got.version > version:
+			newest, version = &replies[i], got.version
+		}
+	}
+
+	switch {
+	case missing != nil && (newest == nil || 2*nils > len(replies)):
+		return *missing
+	case newest != nil:
+		return *newest
+	}
+	return replies[0]
+}
+
+// replyKey returns a string that is equal for equal responses.
+func replyKey(resp *protocol.Response) string {
+	return fmt.Sprintf("%d|%s|%v", resp.Type, resp.Error, resp.Data)
+}
+
+// parseVersioned parses a reply to GETV key WITHPTTL. It reports false for
+// other replies, such as errors.
+func parseVersioned(resp *protocol.Response) (versionedValue, bool) {
+	if resp.Type == protocol.RespNil {
+		return versionedValue{}, true
+	}
+	items, _ := resp.Data.([]interface{})
+	if resp.Type != protocol.RespNested || len(items) != 3 { //nolint:mnd // Value, version and TTL
+		return versionedValue{}, false
+	}
+	value, ok := items[0].(string)
+	version, vok := items[1].(int64)
+	pttl, pok := items[2].(int64)
+	if !ok || !vok || !pok {
+		return versionedValue{}, false
+	}
+	return versionedValue{value: value, version: uint64(version), pttl: pttl, exists: true}, true
+}
+
+// getReply converts a reply to GETV WITHPTTL into the reply to the GET cmd:
+// the value, or the value and its TTL for GET WITHPTTL.
+func getReply(cmd *protocol.Command, resp *protocol.Response) *protocol.Response {
+	got, ok := parseVersioned(resp)
+	if !ok || !got.exists {
+		return resp
+	}
+	if len(cmd.Args) == 0 {
+		return &protocol.Response{Type: protocol.RespString, Data: got.value}
+	}
+	return &protocol.Response{Type: protocol.RespNested, Data: []interface{}{got.value, got.pttl}}
+}
+
+// readRepair waits for the n replies of a GET still due on rest and
+// rewrites the replicas holding an older value than the winning reply, or
+// none: they are set to the winning value with its version and TTL, so the
+// copy doesn't look newer than writes made since. If the key was missing,
+// it is deleted from the replicas holding it instead. Either repair only
+// applies if the replica still has the version it replied with, so it never
+// overwrites a write made after the read. Replies other than strings and
+// nils are left alone.
+func (c *Client) readRepair(key string, winner replicaReply, received []replicaReply, rest <-chan replicaReply, n int) {
+	for ; n > 0; n-- {
+		if reply := <-rest; reply.err == nil {
+			received = append(received, reply)
+		}
+	}
+
+	want, ok := parseVersioned(winner.resp)
+	if !ok {
+		return
+	}
+
+	timeout := time.Duration(c.config.ReadTimeout) * time.Second
+	for _, reply := range received {
+		got, ok := parseVersioned(reply.resp)
+		var err error
+		switch {
+		case !ok:
+			continue
+		case want.exists && (!got.exists || got.version < want.version):
+			repair := &protocol.Command{
+				Type: protocol.CmdSet,
+				Key:  key,
+				Args: []string{
+					want.value,
+					"IFVER", strconv.FormatUint(got.version, 10),
+					"VERSION", strconv.FormatUint(want.version, 10),
+				},
+			}
+			if want.pttl > 0 {
+				// The protocol sends TTLs in whole seconds; round up so the
+				// copy doesn't lose its TTL.
+				repair.TTL = time.Duration((want.pttl+999)/1000) * time.Second //nolint:mnd // Milliseconds
+			}
+			var resp *protocol.Response
+			if resp, err = c.executeOnNode(reply.node, repair, timeout); err == nil && resp.Type == protocol.RespError {
+				err = errors.New(resp.Error)
+			}
+		case !want.exists && got.exists:
+			_, err = deleteIfVersionScript.RunOnNode(c, reply.node, []string{key}, strconv.FormatUint(got.version, 10))
+		}
+		if err != nil {
+			log.Printf("Read repair of %s on %s failed: %v", key, reply.node, err)
+		}
+	}
+}
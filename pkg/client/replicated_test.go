@@ -0,0 +1,289 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/config"
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// startReplicas starts n servers and returns a client replicating every
+// key to all of them, with the given quorums (0 for a majority), and a
+// client for each server alone.
+func startReplicas(t *testing.T, n, writeQuorum, readQuorum int) (*Client, []*Client) {
+	t.Helper()
+
+	addrs := make([]string, n)
+	direct := make([]*Client, n)
+	for i := range addrs {
+		_, addrs[i] = startServer(t)
+		direct[i] = newTestClient(t, []string{addrs[i]}, nil)
+	}
+	c := newTestClient(t, addrs, func(cfg *config.ClientConfig) {
+		cfg.ReplicationFactor = n
+		cfg.WriteQuorum = writeQuorum
+		cfg.ReadQuorum = readQuorum
+	})
+	return c, direct
+}
+
+func TestQuorumWrites(t *testing.T) {
+	c, direct := startReplicas(t, 3, 0, 0)
+
+	if err := c.Set("key", "v1", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	for i, replica := range direct {
+		waitFor(t, fmt.Sprintf("replica %d to be written", i), func() bool {
+			value, err := replica.Get("key")
+			return err == nil && value == "v1"
+		})
+	}
+	if n, err := c.Incr("counter"); err != nil || n != 1 {
+		t.Errorf("Expected INCR to return the first replica's answer, got %d (%v)", n, err)
+	}
+
+	// A majority is enough.
+	down, _, _ := refuseConnections(t)
+	_, addrA := startServer(t)
+	_, addrB := startServer(t)
+	majority := newTestClient(t, []string{addrA, addrB, down}, func(cfg *config.ClientConfig) {
+		cfg.ReplicationFactor = 3
+	})
+	if err := majority.Set("key", "v2", 0); err != nil {
+		t.Errorf("Expected a write to reach the quorum of 2, got %v", err)
+	}
+
+	// A minority isn't.
+	down2, _, _ := refuseConnections(t)
+	minority := newTestClient(t, []string{addrA, down, down2}, func(cfg *config.ClientConfig) {
+		cfg.ReplicationFactor = 3
+	})
+	err := minority.Set("key", "v3", 0)
+	if err == nil || !strings.Contains(err.Error(), "quorum of 2 replicas not reached") {
+		t.Errorf("Expected the quorum not to be reached, got %v", err)
+	}
+
+	// Quorums can't exceed the replicas.
+	strict := newTestClient(t, []string{addrA, addrB}, func(cfg *config.ClientConfig) {
+		cfg.ReplicationFactor = 2
+		cfg.WriteQuorum = 2
+	})
+	if err := strict.Set("key", "v4", 0); err != nil {
+		t.Errorf("Expected a write to both replicas, got %v", err)
+	}
+	strict.RemoveNode(addrB)
+	if err := strict.Set("key", "v5", 0); err == nil || !strings.Contains(err.Error(), "needs more than") {
+		t.Errorf("Expected a quorum of 2 to need 2 nodes, got %v", err)
+	}
+}
+
+func TestQuorumReads(t *testing.T) {
+	c, direct := startReplicas(t, 3, 0, 3)
+
+	// The newest value wins GET.
+	for i, value := range []string{"stale", "fresh", "fresh"} {
+		if err := direct[i].Set("key", value, 0); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if value, err := c.Get("key"); err != nil || value != "fresh" {
+		t.Errorf("Expected the majority value, got %q (%v)", value, err)
+	}
+
+	// The answer of most replicas wins other reads.
+	if err := direct[0].Set("gone", "zombie", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if exists, err := c.Exists("gone"); err != nil || exists {
+		t.Errorf("Expected the key to be missing on most replicas, got %v (%v)", exists, err)
+	}
+
+	// TTLs are read the same way.
+	for i, ttl := range []time.Duration{0, time.Hour, time.Hour} {
+		if err := direct[i].Set("expiring", "v", ttl); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if ttl, err := c.TTL("expiring"); err != nil || ttl < 59*time.Minute {
+		t.Errorf("Expected the majority TTL, got %v (%v)", ttl, err)
+	}
+}
+
+func TestReadQuorumWithDownReplica(t *testing.T) {
+	down, _, _ := refuseConnections(t)
+	_, addrA := startServer(t)
+	_, addrB := startServer(t)
+	c := newTestClient(t, []string{addrA, addrB, down}, func(cfg *config.ClientConfig) {
+		cfg.ReplicationFactor = 3
+	})
+
+	if err := c.Set("key", "v", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if value, err := c.Get("key"); err != nil || value != "v" {
+		t.Errorf("Expected a read quorum of 2 to be reached, got %q (%v)", value, err)
+	}
+
+	strict := newTestClient(t, []string{addrA, addrB, down}, func(cfg *config.ClientConfig) {
+		cfg.ReplicationFactor = 3
+		cfg.ReadQuorum = 3
+	})
+	if _, err := strict.Get("key"); err == nil || !strings.Contains(err.Error(), "quorum of 3 replicas not reached") {
+		t.Errorf("Expected a read quorum of 3 not to be reached, got %v", err)
+	}
+}
+
+func TestReadRepair(t *testing.T) {
+	c, direct := startReplicas(t, 3, 0, 3) // Every replica answers, so the majority is known
+
+	// A stale value is rewritten with the winning value and its TTL.
+	if err := direct[2].Set("key", "old", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	for _, replica := range direct[:2] {
+		if err := replica.Set("key", "new", time.Hour); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if value, err := c.Get("key"); err != nil || value != "new" {
+		t.Fatalf("Expected the newest value, got %q (%v)", value, err)
+	}
+	waitFor(t, "the stale replica to be repaired", func() bool {
+		value, err := direct[2].Get("key")
+		return err == nil && value == "new"
+	})
+	if ttl, err := direct[2].TTL("key"); err != nil || ttl < 59*time.Minute || ttl > time.Hour {
+		t.Errorf("Expected the repaired value to get the winning TTL, got %v (%v)", ttl, err)
+	}
+
+	// A key missing on most replicas is deleted from the others.
+	if err := direct[0].Set("zombie", "v", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := c.Get("zombie"); err == nil {
+		t.Error("Expected the key to be missing")
+	}
+	waitFor(t, "the extra copy to be deleted", func() bool {
+		exists, err := direct[0].Exists("zombie")
+		return err == nil && !exists
+	})
+
+	// Replicas that agree are left alone.
+	if err := c.Set("agreed", "v", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if value, err := c.Get("agreed"); err != nil || value != "v" {
+		t.Errorf("Expected the agreed value, got %q (%v)", value, err)
+	}
+}
+
+func TestReadRepairKeepsNewestValue(t *testing.T) {
+	c, direct := startReplicas(t, 2, 0, 0)
+
+	// Whichever replica holds it, the newer value wins a 1-1 split and is
+	// copied to the other replica.
+	nodes := c.ring.GetNodesForKey("key", 2)
+	for _, order := range [][2]string{{nodes[0], nodes[1]}, {nodes[1], nodes[0]}} {
+		for i, value := range []string{"old", "new"} {
+			replica := newTestClient(t, []string{order[i]}, nil)
+			if err := replica.Set("key", value, 0); err != nil {
+				t.Fatalf("Set failed: %v", err)
+			}
+		}
+		if value, err := c.Get("key"); err != nil || value != "new" {
+			t.Errorf("Expected the newer value from %s, got %q (%v)", order[1], value, err)
+		}
+		for i, replica := range direct {
+			waitFor(t, fmt.Sprintf("replica %d to hold the newer value", i), func() bool {
+				value, err := replica.Get("key")
+				return err == nil && value == "new"
+			})
+		}
+	}
+}
+
+// readReplicas sends every replica of key the GETV that a replicated GET
+// sends, in ring order.
+func readReplicas(t *testing.T, c *Client, key string) []replicaReply {
+	t.Helper()
+
+	cmd := &protocol.Command{Type: protocol.CmdGetV, Key: key, Args: []string{"WITHPTTL"}}
+	var replies []replicaReply
+	for _, node := range c.ring.GetNodesForKey(key, c.config.ReplicationFactor) {
+		resp, err := c.executeOnNode(node, cmd, time.Second)
+		if err != nil {
+			t.Fatalf("GETV on %s failed: %v", node, err)
+		}
+		replies = append(replies, replicaReply{node: node, resp: resp})
+	}
+	return replies
+}
+
+func TestReadRepairKeepsWritesMadeSince(t *testing.T) {
+	c, _ := startReplicas(t, 2, 0, 0)
+	nodes := c.ring.GetNodesForKey("key", 2)
+	stale, fresh := newTestClient(t, nodes[1:], nil), newTestClient(t, nodes[:1], nil)
+	set := func(replica *Client, value string) {
+		if err := replica.Set("key", value, 0); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	// A replica written between the read and its repair isn't repaired.
+	set(stale, "old")
+	set(fresh, "new")
+	received := readReplicas(t, c, "key")
+	set(stale, "written since")
+	c.readRepair("key", newestReply(received), received, nil, 0)
+	if value, err := stale.Get("key"); err != nil || value != "written since" {
+		t.Errorf("Expected the write made since the read to be kept, got %q (%v)", value, err)
+	}
+
+	// A repaired copy keeps the version it was read with, so a write made
+	// since on the replica it came from still wins.
+	set(stale, "old")
+	set(fresh, "new")
+	received = readReplicas(t, c, "key")
+	set(fresh, "newest")
+	c.readRepair("key", newestReply(received), received, nil, 0)
+	if value, err := stale.Get("key"); err != nil || value != "new" {
+		t.Fatalf("Expected the stale replica to be repaired, got %q (%v)", value, err)
+	}
+	if value, err := c.Get("key"); err != nil || value != "newest" {
+		t.Errorf("Expected the write made since the read to win, got %q (%v)", value, err)
+	}
+
+	// Keys missing from most replicas are only deleted if unchanged too.
+	c, direct := startReplicas(t, 3, 0, 3)
+	if err := direct[0].Set("zombie", "v", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	received = readReplicas(t, c, "zombie")
+	if err := direct[0].Set("zombie", "revived", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	c.readRepair("zombie", newestReply(received), received, nil, 0)
+	if value, err := direct[0].Get("zombie"); err != nil || value != "revived" {
+		t.Errorf("Expected the write made since the read to be kept, got %q (%v)", value, err)
+	}
+}
+
+func TestQuorumsMustOverlap(t *testing.T) {
+	cfg := config.LoadClientConfig()
+	cfg.Nodes = []string{"localhost:8080"}
+	cfg.ReplicationFactor = 3
+	cfg.WriteQuorum = 1
+	cfg.ReadQuorum = 2
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "add up to more than 3") {
+		t.Errorf("Expected quorums of 1 and 2 out of 3 to be rejected, got %v", err)
+	}
+
+	cfg.ReadQuorum = 3
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected quorums of 1 and 3 out of 3 to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// RoleInfo describes the replication role of a server, as reported by ROLE.
+type RoleInfo struct {
+	Role string // "primary" or "replica"
+
+	// Primary fields
+	Offset   uint64        // Writes recorded by the primary
+	Replicas []ReplicaInfo // Connected replicas
+
+	// Replica fields
+	Primary       string        // Address of the primary
+	State         string        // Link state: "connecting", "sync" or "connected"
+	AppliedOffset uint64        // Primary offset the replica has caught up to
+	Lag           time.Duration // Time since the last message from the primary; -1 if none yet
+}
+
+// ReplicaInfo describes a replica connected to a primary.
+type ReplicaInfo struct {
+	Address string        // Replica address, as host:port
+	Offset  uint64        // Offset acknowledged by the replica
+	Lag     time.Duration // Time since the last acknowledgement
+}
+
+// Role returns the replication role of node.
+//
+// Example:
+//
+//	info, err := client.Role("cache-primary:8080")
+//	if err == nil && info.Role == "primary" {
+//		for _, replica := range info.Replicas {
+//			fmt.Printf("%s is %d writes behind\n", replica.Address, info.Offset-replica.Offset)
+//		}
+//	}
+func (c *Client) Role(node string) (*RoleInfo, error) {
+	resp, err := c.executeOnNode(node, &protocol.Command{Type: protocol.CmdRole},
+		time.Duration(c.config.ReadTimeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type == protocol.RespError {
+		return nil, fmt.Errorf("server error: %s", resp.Error)
+	}
+	items, ok := resp.Data.([]interface{})
+	if resp.Type != protocol.RespNested || !ok || len(items) == 0 {
+		return nil, fmt.Errorf("unexpected response type")
+	}
+
+	role, _ := items[0].(string)
+	switch {
+	case role == "primary" && len(items) == 3:
+		return parsePrimaryRole(items)
+	case role == "replica" && len(items) == 5:
+		primary, _ := items[1].(string)
+		state, _ := items[2].(string)
+		offset, ok := items[3].(int64)
+		lag, lok := items[4].(int64)
+		if !ok || !lok {
+			return nil, fmt.Errorf("unexpected response data")
+		}
+		info := &RoleInfo{Role: role, Primary: primary, State: state, AppliedOffset: uint64(offset), Lag: -1}
+		if lag >= 0 {
+			info.Lag = time.Duration(lag) * time.Millisecond
+		}
+		return info, nil
+	default:
+		return nil, fmt.Errorf("unexpected response data")
+	}
+}
+
+// parsePrimaryRole parses the ROLE reply of a primary.
+func parsePrimaryRole(items []interface{}) (*RoleInfo, error) {
+	offset, ok := items[1].(int64)
+	replicas, rok := items[2].([]interface{})
+	if !ok || !rok {
+		return nil, fmt.Errorf("unexpected response data")
+	}
+
+	info := &RoleInfo{Role: "primary", Offset: uint64(offset)}
+	for _, item := range replicas {
+		fields, _ := item.([]interface{})
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected response data")
+		}
+		address, _ := fields[0].(string)
+		acked, aok := fields[1].(int64)
+		lag, lok := fields[2].(int64)
+		if !aok || !lok {
+			return nil, fmt.Errorf("unexpected response data")
+		}
+		info.Replicas = append(info.Replicas, ReplicaInfo{
+			Address: address,
+			Offset:  uint64(acked),
+			Lag:     time.Duration(lag) * time.Millisecond,
+		})
+	}
+	return info, nil
+}
+
+// ReplicaOf makes node a read-only replica of primary ("host:port"). The
+// replica discards its data and loads a snapshot of the primary's. An empty
+// primary promotes node back to a primary, keeping its data.
+//
+// Example:
+//
+//	// Fail over to a replica
+//	err := client.ReplicaOf("cache-replica:8080", "")
+func (c *Client) ReplicaOf(node, primary string) error {
+	args := []string{"NO", "ONE"}
+	if primary != "" {
+		host, port, err := net.SplitHostPort(primary)
+		if err != nil {
+			return err
+		}
+		args = []string{host, port}
+	}
+
+	resp, err := c.executeOnNode(node, &protocol.Command{Type: protocol.CmdReplicaOf, Args: args},
+		time.Duration(c.config.ReadTimeout)*time.Second)
+	if err != nil {
+		return err
+	}
+	if resp.Type == protocol.RespError {
+		return fmt.Errorf("server error: %s", resp.Error)
+	}
+	return nil
+}
+
+// Dump serializes the value at key in an opaque format accepted by Restore.
+//
+// Returns:
+//   - The serialized value, or nil if the key doesn't exist
+//   - Error if the operation fails
+func (c *Client) Dump(key string) ([]byte, error) {
+	resp, err := c.executeCommand(&protocol.Command{Type: protocol.CmdDump, Key: key})
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.Type {
+	case protocol.RespString:
+		payload, _ := resp.Data.(string)
+		return []byte(payload), nil
+	case protocol.RespNil:
+		return nil, nil
+	case protocol.RespError:
+		return nil, fmt.Errorf("server error: %s", resp.Error)
+	default:
+		return nil, fmt.Errorf("unexpected response type")
+	}
+}
+
+// Restore creates key from a payload produced by Dump, with an optional TTL
+// (0 for none). Unless replace is set, it fails if the key exists.
+//
+// Example:
+//
+//	payload, err := client.Dump("user:123")
+//	if err == nil && payload != nil {
+//		err = other.Restore("user:123", payload, 0, true)
+//	}
+func (c *Client) Restore(key string, payload []byte, ttl time.Duration, replace bool) error {
+	args := []string{strconv.FormatInt(ttl.Milliseconds(), 10), string(payload)}
+	if replace {
+		args = append(args, "REPLACE")
+	}
+
+	resp, err := c.executeCommand(&protocol.Command{Type: protocol.CmdRestore, Key: key, Args: args})
+	if err != nil {
+		return err
+	}
+	if resp.Type == protocol.RespError {
+		return fmt.Errorf("server error: %s", resp.Error)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package client
+
+import (
+	"crypto/sha1" //nolint:gosec // Scripts are named by SHA1 digests, as in Redis
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// Script is a Lua script run with EVALSHA, falling back to EVAL when the
+// node does not have it cached yet (after a restart, SCRIPT FLUSH or when
+// the ring changed).
+//
+// Example:
+//
+//	var takeStock = client.NewScript(`
+//		local stock = tonumber(redis.call('GET', KEYS[1]) or '0')
+//		if stock < tonumber(ARGV[1]) then return 0 end
+//		redis.call('DECRBY', KEYS[1], ARGV[1])
+//		return 1
+//	`)
+//
+//	ok, err := takeStock.Run(c, []string{"inventory:42"}, "2")
+type Script struct {
+	src string
+	sha string
+}
+
+// NewScript creates a Script from Lua source.
+func NewScript(src string) *Script {
+	sum := sha1.Sum([]byte(src)) //nolint:gosec // Scripts are named by SHA1 digests, as in Redis
+	return &Script{src: src, sha: hex.EncodeToString(sum[:])}
+}
+
+// Hash returns the SHA1 digest the script is cached under.
+func (s *Script) Hash() string {
+	return s.sha
+}
+
+// Run runs the script with EVALSHA, sending its source with EVAL only if
+// the node replies NOSCRIPT. The result is converted as for Eval.
+func (s *Script) Run(c *Client, keys []string, args ...string) (interface{}, error) {
+	return s.RunOnNode(c, "", keys, args...)
+}
+
+// RunOnNode is Run on a specific node instead of the node owning keys, for
+// algorithms that place copies of a key on several nodes. An empty node
+// means the owner of keys.
+func (s *Script) RunOnNode(c *Client, node string, keys []string, args ...string) (interface{}, error) {
+	result, err := c.eval(node, s.sha, protocol.CmdEvalSha, keys, args)
+	if err != nil && strings.HasPrefix(err.Error(), "server error: NOSCRIPT") {
+		return c.eval(node, s.src, protocol.CmdEval, keys, args)
+	}
+	return result, err
+}
+
+// Eval runs a Lua script atomically on the node owning keys. The script
+// sees keys as KEYS and args as ARGV, and calls commands with redis.call.
+// All keys must be on the same node, which hash tags such as "{user:42}"
+// guarantee (see hash.HashTag); scripts without keys run on the node
+// owning the empty key.
+//
+// Example:
+//
+//	n, err := client.Eval("return redis.call('INCRBY', KEYS[1], ARGV[1])", []string{"hits"}, "5")
+//
+// Parameters:
+//   - script: Lua source
+//   - keys: Keys the script accesses
+//   - args: Additional arguments
+//
+// Returns:
+//   - The script result: a string, int64 or []interface{} of those values,
+//     or nil for nil, false and status replies
+//   - Error if the script failed, timed out or the keys span nodes
+func (c *Client) Eval(script string, keys []string, args ...string) (interface{}, error) {
+	return c.eval("", script, protocol.CmdEval, keys, args)
+}
+
+// EvalSha runs a script previously cached on the node by EVAL or SCRIPT
+// LOAD. It fails with a "NOSCRIPT" server error when the script is unknown;
+// Script.Run handles that case.
+func (c *Client) EvalSha(sha string, keys []string, args ...string) (interface{}, error) {
+	return c.eval("", sha, protocol.CmdEvalSha, keys, args)
+}
+
+// ScriptLoad caches a script on every node without running it, so that
+// EVALSHA works wherever its keys live.
+//
+// Returns:
+//   - The SHA1 digest of the script
+//   - Error if the script does not compile or a node failed
+func (c *Client) ScriptLoad(script string) (string, error) {
+	var sha string
+	err := c.scriptOnAllNodes([]string{"LOAD", script}, func(resp *protocol.Response) {
+		sha, _ = resp.Data.(string)
+	})
+	return sha, err
+}
+
+// ScriptExists reports for each digest whether the script is cached on
+// every node.
+func (c *Client) ScriptExists(shas ...string) ([]bool, error) {
+	if len(shas) == 0 {
+		return nil, fmt.Errorf("at least one digest is required")
+	}
+
+	exists := make([]bool, len(shas))
+	for i := range exists {
+		exists[i] = true
+	}
+	err := c.scriptOnAllNodes(append([]string{"EXISTS"}, shas...), func(resp *protocol.Response) {
+		flags, _ := resp.Data.([]interface{})
+		for i := range exists {
+			if i >= len(flags) || flags[i] != int64(1) {
+				exists[i] = false
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return exists, nil
+}
+
+// ScriptFlush removes all cached scripts from every node.
+func (c *Client) ScriptFlush() error {
+	return c.scriptOnAllNodes([]string{"FLUSH"}, func(*protocol.Response) {})
+}
+
+// eval sends EVAL or EVALSHA to node, or to the node owning keys if
+// node is empty.
+func (c *Client) eval(node, script string, cmdType protocol.CommandType, keys, args []string) (interface{}, error) {
+	if node == "" && !c.sameNode(keys...) {
+		return nil, fmt.Errorf("script keys must be on the same node, use a hash tag such as {user:42}")
+	}
+
+	cmdArgs := make([]string, 0, len(keys)+len(args)+2)
+	cmdArgs = append(cmdArgs, script, strconv.Itoa(len(keys)))
+	cmdArgs = append(cmdArgs, keys...)
+	cmdArgs = append(cmdArgs, args...)
+
+	cmd := &protocol.Command{Type: cmdType, Args: cmdArgs}
+	if len(keys) > 0 {
+		cmd.Key = keys[0]
+	}
+
+	resp, err := c.executeOnNode(node, cmd, time.Duration(c.config.ReadTimeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.Type {
+	case protocol.RespError:
+		return nil, fmt.Errorf("server error: %s", resp.Error)
+	case protocol.RespOK, protocol.RespNil:
+		return nil, nil
+	default:
+		return resp.Data, nil
+	}
+}
+
+// scriptOnAllNodes sends a SCRIPT subcommand to every node and passes each
+// successful response to handle.
+func (c *Client) scriptOnAllNodes(args []string, handle func(*protocol.Response)) error {
+	nodes := c.ring.GetNodes()
+	if len(nodes) == 0 {
+		return fmt.Errorf("no available nodes")
+	}
+
+	cmd := &protocol.Command{Type: protocol.CmdScript, Args: args}
+	readTimeout := time.Duration(c.config.ReadTimeout) * time.Second
+	for _, node := range nodes {
+		resp, err := c.executeOnNode(node, cmd, readTimeout)
+		if err != nil {
+			return err
+		}
+		if resp.Type == protocol.RespError {
+			return fmt.Errorf("server error: %s", resp.Error)
+		}
+		handle(resp)
+	}
+	return nil
+}
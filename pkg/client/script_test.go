@@ -0,0 +1,53 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScriptRunFallsBackToEval(t *testing.T) {
+	_, addr := startServer(t)
+	c := newTestClient(t, []string{addr}, nil)
+
+	script := NewScript("return redis.call('INCRBY', KEYS[1], ARGV[1])")
+	if exists, err := c.ScriptExists(script.Hash()); err != nil || exists[0] {
+		t.Fatalf("Expected the script not to be cached yet, got %v (%v)", exists, err)
+	}
+	if _, err := c.EvalSha(script.Hash(), []string{"n"}, "1"); err == nil ||
+		!strings.HasPrefix(err.Error(), "server error: NOSCRIPT") {
+		t.Fatalf("Expected a NOSCRIPT error, got %v", err)
+	}
+
+	// The first run sends the source, which caches the script.
+	if n, err := script.Run(c, []string{"n"}, "2"); err != nil || n != int64(2) {
+		t.Fatalf("Expected 2, got %v (%v)", n, err)
+	}
+	if exists, err := c.ScriptExists(script.Hash()); err != nil || !exists[0] {
+		t.Errorf("Expected EVAL to cache the script, got %v (%v)", exists, err)
+	}
+	if n, err := c.EvalSha(script.Hash(), []string{"n"}, "3"); err != nil || n != int64(5) {
+		t.Errorf("Expected EVALSHA to run the cached script, got %v (%v)", n, err)
+	}
+
+	// After a flush, Run falls back to EVAL again.
+	if err := c.ScriptFlush(); err != nil {
+		t.Fatalf("ScriptFlush failed: %v", err)
+	}
+	if n, err := script.Run(c, []string{"n"}, "5"); err != nil || n != int64(10) {
+		t.Errorf("Expected 10 after the fallback, got %v (%v)", n, err)
+	}
+}
+
+func TestScriptRunReturnsScriptErrors(t *testing.T) {
+	_, addr := startServer(t)
+	c := newTestClient(t, []string{addr}, nil)
+
+	script := NewScript("return redis.error_reply('NOSCRIPT from the script')")
+
+	// Only a NOSCRIPT reply to EVALSHA triggers the fallback; the script's
+	// own errors are returned as is.
+	_, err := script.Run(c, nil)
+	if err == nil || err.Error() != "server error: NOSCRIPT from the script" {
+		t.Errorf("Expected the script's error, got %v", err)
+	}
+}
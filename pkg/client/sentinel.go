@@ -0,0 +1,201 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// sentinelRefreshInterval is how often the client polls sentinels for
+// primaries, in case it missed a published change.
+const sentinelRefreshInterval = 10 * time.Second
+
+// Primary is a primary monitored by sentinels.
+type Primary struct {
+	Name    string // Node name on the ring
+	Address string // Current address
+	Epoch   uint64 // Failover epoch that chose Address; 0 for the configured address
+}
+
+// followSentinels loads the primaries from the configured sentinels, adds
+// them to the ring by name and keeps their addresses up to date as
+// sentinels fail them over.
+func (c *Client) followSentinels() {
+	cfg := *c.config
+	cfg.Nodes, cfg.Sentinels = c.config.Sentinels, nil
+	c.sentinels = NewWithConfig(&cfg)
+
+	if err := c.refreshPrimaries(); err != nil {
+		log.Printf("Sentinel: failed to load primaries: %v", err)
+	}
+
+	sub, err := c.sentinels.Subscribe(protocol.SentinelChannel)
+	if err != nil {
+		// Changes are still picked up by polling.
+		log.Printf("Sentinel: failed to subscribe to changes: %v", err)
+	}
+	go c.watchSentinels(sub)
+}
+
+// watchSentinels applies published primary changes, and polls the
+// sentinels periodically, until the client is closed.
+func (c *Client) watchSentinels(sub *PubSub) {
+	var messages <-chan *Message
+	if sub != nil {
+		messages = sub.Channel()
+		defer sub.Close() //nolint:errcheck // Close never fails
+	}
+	ticker := time.NewTicker(sentinelRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				messages = nil
+				continue
+			}
+			primary, err := parseSentinelMessage(msg.Payload)
+			if err != nil {
+				log.Printf("Sentinel: %v", err)
+				continue
+			}
+			c.setPrimary(primary)
+		case <-ticker.C:
+			if err := c.refreshPrimaries(); err != nil {
+				log.Printf("Sentinel: failed to refresh primaries: %v", err)
+			}
+		}
+	}
+}
+
+// refreshPrimaries applies the primaries reported by the first sentinel
+// that answers.
+func (c *Client) refreshPrimaries() error {
+	var lastErr error
+	for _, sentinel := range c.sentinels.Nodes() {
+		primaries, err := c.sentinels.sentinelPrimaries(sentinel)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, primary := range primaries {
+			c.setPrimary(primary)
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// Primaries returns the primaries monitored by the configured sentinels, as
+// reported by the first sentinel that answers.
+//
+// Example:
+//
+//	primaries, err := client.Primaries()
+//	for _, p := range primaries {
+//		fmt.Printf("%s is at %s\n", p.Name, p.Address)
+//	}
+func (c *Client) Primaries() ([]Primary, error) {
+	if c.sentinels == nil {
+		return nil, fmt.Errorf("no sentinels configured")
+	}
+
+	var lastErr error
+	for _, sentinel := range c.sentinels.Nodes() {
+		primaries, err := c.sentinels.sentinelPrimaries(sentinel)
+		if err == nil {
+			return primaries, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// sentinelPrimaries sends SENTINEL PRIMARIES to a sentinel.
+func (c *Client) sentinelPrimaries(sentinel string) ([]Primary, error) {
+	cmd := &protocol.Command{Type: protocol.CmdSentinel, Args: []string{"PRIMARIES"}}
+	resp, err := c.executeOnNode(sentinel, cmd, time.Duration(c.config.ReadTimeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type == protocol.RespError {
+		return nil, fmt.Errorf("server error: %s", resp.Error)
+	}
+	entries, ok := resp.Data.([]interface{})
+	if resp.Type != protocol.RespNested || !ok {
+		return nil, fmt.Errorf("unexpected response type")
+	}
+
+	primaries := make([]Primary, 0, len(entries))
+	for _, entry := range entries {
+		fields, _ := entry.([]interface{})
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected response data")
+		}
+		name, nok := fields[0].(string)
+		address, aok := fields[1].(string)
+		epoch, eok := fields[2].(int64)
+		if !nok || !aok || !eok {
+			return nil, fmt.Errorf("unexpected response data")
+		}
+		primaries = append(primaries, Primary{Name: name, Address: address, Epoch: uint64(epoch)})
+	}
+	return primaries, nil
+}
+
+// parseSentinelMessage parses a "name address epoch" message published by a
+// sentinel.
+func parseSentinelMessage(payload string) (Primary, error) {
+	fields := strings.Fields(payload)
+	if len(fields) != 3 {
+		return Primary{}, fmt.Errorf("invalid sentinel message: %q", payload)
+	}
+	epoch, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return Primary{}, fmt.Errorf("invalid sentinel message: %q", payload)
+	}
+	return Primary{Name: fields[0], Address: fields[1], Epoch: epoch}, nil
+}
+
+// setPrimary adds a primary to the ring, or moves it to a new address,
+// unless the client already knows an address from a newer epoch. Moving a
+// node keeps its name, and so its keys, on the ring.
+func (c *Client) setPrimary(primary Primary) {
+	c.mu.Lock()
+	if primary.Epoch < c.epochs[primary.Name] {
+		c.mu.Unlock()
+		return
+	}
+	c.epochs[primary.Name] = primary.Epoch
+
+	pool, exists := c.pools[primary.Name]
+	switch {
+	case !exists:
+		c.ring.AddNode(primary.Name)
+		c.pools[primary.Name] = c.newPool(primary.Address)
+	case pool.address != primary.Address:
+		pool.Close()
+		c.pools[primary.Name] = c.newPool(primary.Address)
+	default:
+		c.mu.Unlock()
+		return
+	}
+	pubsubs := c.openPubSubs()
+	c.mu.Unlock()
+
+	log.Printf("Sentinel: node %s is at %s", primary.Name, primary.Address)
+	for _, ps := range pubsubs {
+		if exists {
+			ps.redial(primary.Name)
+		} else {
+			ps.reshard()
+		}
+	}
+}
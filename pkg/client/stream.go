@@ -0,0 +1,543 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// XMessage is a single stream entry.
+type XMessage struct {
+	Values map[string]string // Field/value pairs (nil if the entry was deleted)
+	ID     string            // Entry ID in "ms-seq" form
+}
+
+// XStream holds the messages read from one stream.
+type XStream struct {
+	Stream   string     // Stream key
+	Messages []XMessage // Messages in ID order
+}
+
+// XAddArgs describes an entry to append with XAdd.
+type XAddArgs struct {
+	Values map[string]string // Field/value pairs of the entry
+	Stream string            // Stream key
+	ID     string            // Entry ID, "ms-*" or "" to auto-generate ("*")
+	MinID  string            // If set, trim entries with IDs below MinID
+	MaxLen int64             // If positive, trim the stream to at most MaxLen entries
+}
+
+// XReadArgs describes an XRead call.
+type XReadArgs struct {
+	Streams []string      // Stream keys
+	IDs     []string      // Last ID seen per stream ("$" for only new entries)
+	Count   int64         // Maximum entries per stream (0 for no limit)
+	Block   time.Duration // 0: don't block; > 0: wait up to Block; < 0: wait indefinitely
+}
+
+// XReadGroupArgs describes an XReadGroup call.
+type XReadGroupArgs struct {
+	Group    string        // Consumer group name
+	Consumer string        // Consumer name
+	Streams  []string      // Stream keys
+	IDs      []string      // ">" for new entries, or an ID to replay pending entries
+	Count    int64         // Maximum entries per stream (0 for no limit)
+	Block    time.Duration // 0: don't block; > 0: wait up to Block; < 0: wait indefinitely
+	NoAck    bool          // Don't add delivered entries to the pending list
+}
+
+// XPendingSummary summarizes the pending entries of a consumer group.
+type XPendingSummary struct {
+	Consumers map[string]int64 // Pending count per consumer
+	Lowest    string           // Smallest pending ID
+	Highest   string           // Largest pending ID
+	Count     int64            // Total number of pending entries
+}
+
+// XPendingExtArgs describes an XPendingExt call.
+type XPendingExtArgs struct {
+	Stream   string // Stream key
+	Group    string // Consumer group name
+	Start    string // Range start ("-" for the smallest ID)
+	End      string // Range end ("+" for the largest ID)
+	Consumer string // Only entries owned by this consumer ("" for all)
+	Count    int64  // Maximum entries to return
+}
+
+// XPendingEntry describes one pending (delivered but unacknowledged) entry.
+type XPendingEntry struct {
+	ID         string        // Entry ID
+	Consumer   string        // Owning consumer
+	Idle       time.Duration // Time since last delivery
+	Deliveries int64         // Number of deliveries
+}
+
+// XClaimArgs describes an XClaim call.
+type XClaimArgs struct {
+	Stream   string        // Stream key
+	Group    string        // Consumer group name
+	Consumer string        // Consumer receiving ownership
+	Messages []string      // IDs of the entries to claim
+	MinIdle  time.Duration // Only claim entries idle for at least this long
+}
+
+// XAdd appends an entry to a stream, creating the stream if needed, and
+// optionally trims it in the same operation.
+//
+// Example:
+//
+//	id, err := client.XAdd(&client.XAddArgs{
+//		Stream: "orders",
+//		MaxLen: 10000,
+//		Values: map[string]string{"sku": "A-1", "qty": "2"},
+//	})
+//
+// Returns:
+//   - The ID of the new entry
+//   - Error if the operation fails
+func (c *Client) XAdd(args *XAddArgs) (string, error) {
+	if len(args.Values) == 0 {
+		return "", fmt.Errorf("XADD requires at least one field")
+	}
+
+	var cmdArgs []string
+	switch {
+	case args.MaxLen > 0:
+		cmdArgs = append(cmdArgs, "MAXLEN", strconv.FormatInt(args.MaxLen, 10))
+	case args.MinID != "":
+		cmdArgs = append(cmdArgs, "MINID", args.MinID)
+	}
+
+	id := args.ID
+	if id == "" {
+		id = "*"
+	}
+	cmdArgs = append(cmdArgs, id)
+
+	fields := make([]string, 0, len(args.Values))
+	for field := range args.Values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		cmdArgs = append(cmdArgs, field, args.Values[field])
+	}
+
+	resp, err := c.executeCommand(&protocol.Command{Type: protocol.CmdXAdd, Key: args.Stream, Args: cmdArgs})
+	if err != nil {
+		return "", err
+	}
+	if resp.Type == protocol.RespError {
+		return "", fmt.Errorf("server error: %s", resp.Error)
+	}
+	str, ok := resp.Data.(string)
+	if resp.Type != protocol.RespString || !ok {
+		return "", fmt.Errorf("unexpected response type")
+	}
+	return str, nil
+}
+
+// XLen returns the number of entries in a stream.
+//
+// Example:
+//
+//	length, err := client.XLen("orders")
+func (c *Client) XLen(key string) (int64, error) {
+	return c.executeInt64Command(protocol.CmdXLen, key)
+}
+
+// XRange returns stream entries with IDs between start and end, inclusive.
+// Use "-" and "+" for the extremes; prefix an ID with "(" to exclude it.
+// A count <= 0 returns all matching entries.
+//
+// Example:
+//
+//	messages, err := client.XRange("orders", "-", "+", 100)
+func (c *Client) XRange(key, start, end string, count int64) ([]XMessage, error) {
+	return c.xrange(protocol.CmdXRange, key, start, end, count)
+}
+
+// XRevRange is like XRange but returns entries newest first.
+// Note that the end bound comes first, as in Redis.
+//
+// Example:
+//
+//	latest, err := client.XRevRange("orders", "+", "-", 1)
+func (c *Client) XRevRange(key, end, start string, count int64) ([]XMessage, error) {
+	return c.xrange(protocol.CmdXRevRange, key, end, start, count)
+}
+
+func (c *Client) xrange(cmdType protocol.CommandType, key, from, to string, count int64) ([]XMessage, error) {
+	args := []string{from, to}
+	if count > 0 {
+		args = append(args, "COUNT", strconv.FormatInt(count, 10))
+	}
+
+	arr, err := c.executeNestedCommand(&protocol.Command{Type: cmdType, Key: key, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	return decodeXMessages(arr)
+}
+
+// XTrimMaxLen trims a stream to at most maxLen entries, dropping the oldest.
+//
+// Returns:
+//   - Number of entries removed
+//   - Error if the operation fails
+func (c *Client) XTrimMaxLen(key string, maxLen int64) (int64, error) {
+	return c.executeInt64CommandWithArgs(protocol.CmdXTrim, key, []string{"MAXLEN", strconv.FormatInt(maxLen, 10)})
+}
+
+// XTrimMinID removes stream entries with IDs lower than minID.
+//
+// Returns:
+//   - Number of entries removed
+//   - Error if the operation fails
+func (c *Client) XTrimMinID(key, minID string) (int64, error) {
+	return c.executeInt64CommandWithArgs(protocol.CmdXTrim, key, []string{"MINID", minID})
+}
+
+// XRead reads entries with IDs greater than the given IDs from one or more
+// streams. With a Block duration, it waits for new entries if none are
+// available. Returns an empty result if nothing was read before the timeout.
+//
+// Streams on different nodes are read node by node; blocking reads require
+// all streams to live on the same node.
+//
+// Example:
+//
+//	streams, err := client.XRead(&client.XReadArgs{
+//		Streams: []string{"orders"},
+//		IDs:     []string{"$"},
+//		Block:   5 * time.Second,
+//	})
+func (c *Client) XRead(args *XReadArgs) ([]XStream, error) {
+	if len(args.Streams) == 0 || len(args.Streams) != len(args.IDs) {
+		return nil, fmt.Errorf("XREAD requires one ID per stream")
+	}
+
+	var prefix []string
+	if args.Count > 0 {
+		prefix = append(prefix, "COUNT", strconv.FormatInt(args.Count, 10))
+	}
+	return c.readStreams(protocol.CmdXRead, prefix, args.Streams, args.IDs, args.Block)
+}
+
+// XGroupCreate creates a consumer group on a stream, starting after the
+// given ID ("$" for only new entries, "0" for the whole stream).
+// With mkStream, a missing stream is created empty.
+//
+// Example:
+//
+//	err := client.XGroupCreate("orders", "billing", "$", true)
+func (c *Client) XGroupCreate(key, group, start string, mkStream bool) error {
+	args := []string{"CREATE", group, start}
+	if mkStream {
+		args = append(args, "MKSTREAM")
+	}
+	return c.executeOKCommandWithArgs(protocol.CmdXGroup, key, args)
+}
+
+// XGroupDestroy removes a consumer group and its pending entries.
+// Returns true if the group existed.
+func (c *Client) XGroupDestroy(key, group string) (bool, error) {
+	val, err := c.executeInt64CommandWithArgs(protocol.CmdXGroup, key, []string{"DESTROY", group})
+	if err != nil {
+		return false, err
+	}
+	return val == 1, nil
+}
+
+// XReadGroup reads entries as a consumer of a consumer group. Use ">" to
+// receive entries never delivered to the group; they stay pending until
+// acknowledged with XAck (unless NoAck is set).
+//
+// Example:
+//
+//	streams, err := client.XReadGroup(&client.XReadGroupArgs{
+//		Group:    "billing",
+//		Consumer: "worker-1",
+//		Streams:  []string{"orders"},
+//		IDs:      []string{">"},
+//		Count:    10,
+//		Block:    time.Second,
+//	})
+func (c *Client) XReadGroup(args *XReadGroupArgs) ([]XStream, error) {
+	if len(args.Streams) == 0 || len(args.Streams) != len(args.IDs) {
+		return nil, fmt.Errorf("XREADGROUP requires one ID per stream")
+	}
+
+	prefix := []string{"GROUP", args.Group, args.Consumer}
+	if args.Count > 0 {
+		prefix = append(prefix, "COUNT", strconv.FormatInt(args.Count, 10))
+	}
+	if args.NoAck {
+		prefix = append(prefix, "NOACK")
+	}
+	return c.readStreams(protocol.CmdXReadGroup, prefix, args.Streams, args.IDs, args.Block)
+}
+
+// readStreams issues XREAD or XREADGROUP, splitting the streams by node.
+func (c *Client) readStreams(cmdType protocol.CommandType, prefix, keys, ids []string, block time.Duration) ([]XStream, error) {
+	if block != 0 && !c.sameNode(keys...) {
+		return nil, fmt.Errorf("blocking reads require all streams to map to the same node")
+	}
+
+	readTimeout := time.Duration(c.config.ReadTimeout) * time.Second
+	if block > 0 {
+		prefix = append(prefix, "BLOCK", strconv.FormatInt(block.Milliseconds(), 10))
+		readTimeout += block
+	} else if block < 0 {
+		prefix = append(prefix, "BLOCK", "0")
+		readTimeout = 0
+	}
+
+	var order []string
+	byNode := make(map[string][]int)
+	for i, key := range keys {
+		node := c.route(key)
+		if _, seen := byNode[node]; !seen {
+			order = append(order, node)
+		}
+		byNode[node] = append(byNode[node], i)
+	}
+
+	var result []XStream
+	for _, node := range order {
+		indexes := byNode[node]
+		nodeKeys := make([]string, len(indexes))
+		nodeIDs := make([]string, len(indexes))
+		for j, idx := range indexes {
+			nodeKeys[j] = keys[idx]
+			nodeIDs[j] = ids[idx]
+		}
+
+		args := append(append(append([]string{}, prefix...), "STREAMS"), nodeKeys...)
+		args = append(args, nodeIDs...)
+
+		cmd := &protocol.Command{Type: cmdType, Key: nodeKeys[0], Args: args}
+		resp, err := c.executeCommandWithTimeout(cmd, readTimeout)
+		if err != nil {
+			return nil, err
+		}
+		arr, err := nestedResponse(resp)
+		if err != nil {
+			return nil, err
+		}
+		streams, err := decodeXStreams(arr)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, streams...)
+	}
+	return result, nil
+}
+
+// XAck acknowledges entries of a consumer group, removing them from the
+// pending entries list.
+//
+// Example:
+//
+//	acked, err := client.XAck("orders", "billing", msg.ID)
+//
+// Returns:
+//   - Number of entries acknowledged
+//   - Error if the operation fails
+func (c *Client) XAck(key, group string, ids ...string) (int64, error) {
+	return c.executeInt64CommandWithArgs(protocol.CmdXAck, key, append([]string{group}, ids...))
+}
+
+// XPending returns a summary of the pending entries of a consumer group.
+//
+// Example:
+//
+//	summary, err := client.XPending("orders", "billing")
+//	fmt.Printf("%d messages awaiting acknowledgement\n", summary.Count)
+func (c *Client) XPending(key, group string) (*XPendingSummary, error) {
+	arr, err := c.executeNestedCommand(&protocol.Command{Type: protocol.CmdXPending, Key: key, Args: []string{group}})
+	if err != nil {
+		return nil, err
+	}
+
+	const summaryFields = 4
+	if len(arr) != summaryFields {
+		return nil, fmt.Errorf("unexpected XPENDING response")
+	}
+
+	summary := &XPendingSummary{Consumers: make(map[string]int64)}
+	summary.Count, _ = arr[0].(int64)
+	summary.Lowest, _ = arr[1].(string)
+	summary.Highest, _ = arr[2].(string)
+
+	consumers, _ := arr[3].([]interface{})
+	for _, item := range consumers {
+		pair, ok := item.([]interface{})
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("unexpected XPENDING consumer entry")
+		}
+		name, _ := pair[0].(string)
+		count, _ := pair[1].(int64)
+		summary.Consumers[name] = count
+	}
+	return summary, nil
+}
+
+// XPendingExt lists pending entries of a consumer group in an ID range.
+//
+// Example:
+//
+//	pending, err := client.XPendingExt(&client.XPendingExtArgs{
+//		Stream: "orders", Group: "billing", Start: "-", End: "+", Count: 10,
+//	})
+func (c *Client) XPendingExt(args *XPendingExtArgs) ([]XPendingEntry, error) {
+	cmdArgs := []string{args.Group, args.Start, args.End, strconv.FormatInt(args.Count, 10)}
+	if args.Consumer != "" {
+		cmdArgs = append(cmdArgs, args.Consumer)
+	}
+
+	arr, err := c.executeNestedCommand(&protocol.Command{Type: protocol.CmdXPending, Key: args.Stream, Args: cmdArgs})
+	if err != nil {
+		return nil, err
+	}
+
+	const entryFields = 4
+	entries := make([]XPendingEntry, 0, len(arr))
+	for _, item := range arr {
+		fields, ok := item.([]interface{})
+		if !ok || len(fields) != entryFields {
+			return nil, fmt.Errorf("unexpected XPENDING entry")
+		}
+		entry := XPendingEntry{}
+		entry.ID, _ = fields[0].(string)
+		entry.Consumer, _ = fields[1].(string)
+		idle, _ := fields[2].(int64)
+		entry.Idle = time.Duration(idle) * time.Millisecond
+		entry.Deliveries, _ = fields[3].(int64)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// XClaim transfers pending entries idle for at least MinIdle to another
+// consumer and returns them. Use it to recover messages from dead consumers.
+//
+// Example:
+//
+//	messages, err := client.XClaim(&client.XClaimArgs{
+//		Stream: "orders", Group: "billing", Consumer: "worker-2",
+//		MinIdle: time.Minute, Messages: []string{stuckID},
+//	})
+func (c *Client) XClaim(args *XClaimArgs) ([]XMessage, error) {
+	arr, err := c.executeNestedCommand(xclaimCommand(args, false))
+	if err != nil {
+		return nil, err
+	}
+	return decodeXMessages(arr)
+}
+
+// XClaimJustID is like XClaim but returns only the claimed IDs and does not
+// increment their delivery counters.
+func (c *Client) XClaimJustID(args *XClaimArgs) ([]string, error) {
+	arr, err := c.executeNestedCommand(xclaimCommand(args, true))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(arr))
+	for _, item := range arr {
+		id, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected XCLAIM response")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func xclaimCommand(args *XClaimArgs, justID bool) *protocol.Command {
+	cmdArgs := []string{args.Group, args.Consumer, strconv.FormatInt(args.MinIdle.Milliseconds(), 10)}
+	cmdArgs = append(cmdArgs, args.Messages...)
+	if justID {
+		cmdArgs = append(cmdArgs, "JUSTID")
+	}
+	return &protocol.Command{Type: protocol.CmdXClaim, Key: args.Stream, Args: cmdArgs}
+}
+
+// executeNestedCommand executes a command that returns a nested array.
+func (c *Client) executeNestedCommand(cmd *protocol.Command) ([]interface{}, error) {
+	resp, err := c.executeCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return nestedResponse(resp)
+}
+
+// nestedResponse extracts the data of a nested array response.
+// A nil response yields an empty array.
+func nestedResponse(resp *protocol.Response) ([]interface{}, error) {
+	switch resp.Type {
+	case protocol.RespError:
+		return nil, fmt.Errorf("server error: %s", resp.Error)
+	case protocol.RespNil:
+		return nil, nil
+	case protocol.RespNested:
+		arr, ok := resp.Data.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("response data is not a nested array")
+		}
+		return arr, nil
+	default:
+		return nil, fmt.Errorf("unexpected response type")
+	}
+}
+
+// decodeXMessages decodes [[id, [field, value, ...]], ...].
+func decodeXMessages(arr []interface{}) ([]XMessage, error) {
+	messages := make([]XMessage, 0, len(arr))
+	for _, item := range arr {
+		entry, ok := item.([]interface{})
+		if !ok || len(entry) != 2 {
+			return nil, fmt.Errorf("unexpected stream entry")
+		}
+
+		msg := XMessage{}
+		if msg.ID, ok = entry[0].(string); !ok {
+			return nil, fmt.Errorf("unexpected stream entry ID")
+		}
+
+		if fields, isArr := entry[1].([]interface{}); isArr {
+			msg.Values = make(map[string]string, len(fields)/2)
+			for i := 0; i+1 < len(fields); i += 2 {
+				field, _ := fields[i].(string)
+				value, _ := fields[i+1].(string)
+				msg.Values[field] = value
+			}
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// decodeXStreams decodes [[key, entries], ...].
+func decodeXStreams(arr []interface{}) ([]XStream, error) {
+	streams := make([]XStream, 0, len(arr))
+	for _, item := range arr {
+		pair, ok := item.([]interface{})
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("unexpected stream read result")
+		}
+
+		key, _ := pair[0].(string)
+		entries, _ := pair[1].([]interface{})
+		messages, err := decodeXMessages(entries)
+		if err != nil {
+			return nil, err
+		}
+		streams = append(streams, XStream{Stream: key, Messages: messages})
+	}
+	return streams, nil
+}
@@ -0,0 +1,356 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// ErrTxAborted is returned by Tx.Exec when a watched key was modified after
+// it was watched. The transaction did not run and may be retried.
+var ErrTxAborted = errors.New("transaction aborted: watched key changed")
+
+// Tx is an atomic transaction (MULTI/EXEC) on one node. It holds a pooled
+// connection from creation until Exec, Discard or Close, so that WATCH and
+// the transaction run on the same server connection. All keys used in a
+// transaction must be owned by the same node, for example by sharing a
+// hash tag (see hash.HashTag).
+//
+// Commands are queued locally and sent together by Exec.
+//
+// Example:
+//
+//	tx, err := client.NewTx("inventory:42", "audit:42")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer tx.Close()
+//
+//	if err := tx.Watch("inventory:42"); err != nil {
+//		log.Fatal(err)
+//	}
+//	stock, _ := client.Get("inventory:42")
+//	if stock != "0" {
+//		tx.IncrBy("inventory:42", -1)
+//		tx.RPush("audit:42", "sold one")
+//		results, err := tx.Exec()
+//		if errors.Is(err, client.ErrTxAborted) {
+//			// Someone else changed the stock; retry
+//		}
+//	}
+type Tx struct {
+	client  *Client
+	conn    net.Conn
+	node    string
+	queued  []*protocol.Command
+	err     error // First error from queuing; reported by Exec
+	watched bool
+}
+
+// TxResult is the reply to one command of a transaction.
+type TxResult struct {
+	// Value is a string, int64, []string or []interface{} depending on the
+	// command, or nil for OK and nil replies.
+	Value interface{}
+	// Err is set when the command failed. Other commands still ran.
+	Err error
+}
+
+// NewTx starts a transaction on the node that owns keys, pinning one of its
+// pooled connections until the transaction ends.
+//
+// Parameters:
+//   - keys: Keys the transaction will use (at least one, all on one node)
+//
+// Returns:
+//   - A new transaction
+//   - Error if the keys live on different nodes or no connection is available
+func (c *Client) NewTx(keys ...string) (*Tx, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one key is required")
+	}
+	if !c.sameNode(keys...) {
+		return nil, fmt.Errorf("transaction keys must be on the same node, use a hash tag such as {user:42}")
+	}
+
+	node := c.route(keys[0])
+	conn, err := c.getConnection(node)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{client: c, conn: conn, node: node}, nil
+}
+
+// Watch makes the next Exec fail with ErrTxAborted if any of the keys is
+// modified, deleted or expires before it runs. Read the watched values after
+// calling Watch.
+func (tx *Tx) Watch(keys ...string) error {
+	if err := tx.checkKeys(keys...); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("at least one key is required")
+	}
+
+	resp, err := tx.roundTrip(&protocol.Command{Type: protocol.CmdWatch, Key: keys[0], Args: keys})
+	if err != nil {
+		return err
+	}
+	if resp.Type == protocol.RespError {
+		return fmt.Errorf("server error: %s", resp.Error)
+	}
+	tx.watched = true
+	return nil
+}
+
+// Unwatch forgets all watched keys.
+func (tx *Tx) Unwatch() error {
+	if tx.conn == nil {
+		return fmt.Errorf("transaction is closed")
+	}
+
+	resp, err := tx.roundTrip(&protocol.Command{Type: protocol.CmdUnwatch})
+	if err != nil {
+		return err
+	}
+	if resp.Type == protocol.RespError {
+		return fmt.Errorf("server error: %s", resp.Error)
+	}
+	tx.watched = false
+	return nil
+}
+
+// Get queues a GET.
+func (tx *Tx) Get(key string) {
+	tx.queue(&protocol.Command{Type: protocol.CmdGet, Key: key})
+}
+
+// Set queues a SET with an optional TTL (0 for none).
+func (tx *Tx) Set(key, value string, ttl time.Duration) {
+	tx.queue(&protocol.Command{Type: protocol.CmdSet, Key: key, Args: []string{value}, TTL: ttl})
+}
+
+// Del queues a DEL.
+func (tx *Tx) Del(key string) {
+	tx.queue(&protocol.Command{Type: protocol.CmdDel, Key: key})
+}
+
+// Incr queues an INCR.
+func (tx *Tx) Incr(key string) {
+	tx.queue(&protocol.Command{Type: protocol.CmdIncr, Key: key})
+}
+
+// IncrBy queues an INCRBY; use a negative delta to decrement.
+func (tx *Tx) IncrBy(key string, delta int64) {
+	tx.queue(&protocol.Command{Type: protocol.CmdIncrBy, Key: key, Args: []string{strconv.FormatInt(delta, 10)}})
+}
+
+// Expire queues an EXPIRE.
+func (tx *Tx) Expire(key string, ttl time.Duration) {
+	tx.queue(&protocol.Command{Type: protocol.CmdExpire, Key: key, TTL: ttl})
+}
+
+// HSet queues an HSET.
+func (tx *Tx) HSet(key, field, value string) {
+	tx.queue(&protocol.Command{Type: protocol.CmdHSet, Key: key, Args: []string{field, value}})
+}
+
+// HGet queues an HGET.
+func (tx *Tx) HGet(key, field string) {
+	tx.queue(&protocol.Command{Type: protocol.CmdHGet, Key: key, Args: []string{field}})
+}
+
+// LPush queues an LPUSH.
+func (tx *Tx) LPush(key string, values ...string) {
+	tx.queue(&protocol.Command{Type: protocol.CmdLPush, Key: key, Args: values})
+}
+
+// RPush queues an RPUSH.
+func (tx *Tx) RPush(key string, values ...string) {
+	tx.queue(&protocol.Command{Type: protocol.CmdRPush, Key: key, Args: values})
+}
+
+// LPop queues an LPOP.
+func (tx *Tx) LPop(key string) {
+	tx.queue(&protocol.Command{Type: protocol.CmdLPop, Key: key})
+}
+
+// SAdd queues an SADD.
+func (tx *Tx) SAdd(key string, members ...string) {
+	tx.queue(&protocol.Command{Type: protocol.CmdSAdd, Key: key, Args: members})
+}
+
+// Exec runs the queued commands atomically and ends the transaction,
+// returning its connection to the pool.
+//
+// Returns:
+//   - One result per queued command, in order
+//   - ErrTxAborted if a watched key changed, or an error if a command was
+//     rejected or the connection failed (nothing ran in either case)
+func (tx *Tx) Exec() ([]TxResult, error) {
+	if tx.conn == nil {
+		return nil, fmt.Errorf("transaction is closed")
+	}
+	if tx.err != nil {
+		err := tx.err
+		tx.Close()
+		return nil, err
+	}
+
+	resp, err := tx.pipeline()
+	if err != nil {
+		tx.closeConn()
+		return nil, err
+	}
+	tx.watched = false // EXEC clears watches on the server
+	tx.Close()
+
+	switch resp.Type {
+	case protocol.RespNil:
+		return nil, ErrTxAborted
+	case protocol.RespError:
+		return nil, fmt.Errorf("server error: %s", resp.Error)
+	case protocol.RespMulti:
+	default:
+		return nil, fmt.Errorf("unexpected response type")
+	}
+
+	replies, _ := resp.Data.([]*protocol.Response)
+	results := make([]TxResult, len(replies))
+	for i, reply := range replies {
+		switch reply.Type {
+		case protocol.RespError:
+			results[i].Err = fmt.Errorf("server error: %s", reply.Error)
+		case protocol.RespOK, protocol.RespNil:
+		default:
+			results[i].Value = reply.Data
+		}
+	}
+	return results, nil
+}
+
+// Discard drops the queued commands and ends the transaction.
+func (tx *Tx) Discard() error {
+	tx.queued = nil
+	return tx.Close()
+}
+
+// Close ends the transaction without running it, forgets watched keys and
+// returns the connection to the pool. It is safe to call more than once.
+func (tx *Tx) Close() error {
+	if tx.conn == nil {
+		return nil
+	}
+
+	if tx.watched {
+		if err := tx.Unwatch(); err != nil {
+			// The connection may still hold watches; don't reuse it.
+			tx.closeConn()
+			return nil
+		}
+	}
+
+	tx.client.returnConnection(tx.node, tx.conn)
+	tx.conn = nil
+	return nil
+}
+
+// queue records a command, or the error that will fail Exec.
+func (tx *Tx) queue(cmd *protocol.Command) {
+	if err := tx.checkKeys(cmd.Key); err != nil && tx.err == nil {
+		tx.err = err
+	}
+	tx.queued = append(tx.queued, cmd)
+}
+
+// checkKeys verifies that the transaction is open and owns keys.
+func (tx *Tx) checkKeys(keys ...string) error {
+	if tx.conn == nil {
+		return fmt.Errorf("transaction is closed")
+	}
+	for _, key := range keys {
+		if tx.client.route(key) != tx.node {
+			return fmt.Errorf("key %q is not on the transaction's node", key)
+		}
+	}
+	return nil
+}
+
+// pipeline sends MULTI, the queued commands and EXEC in one write and
+// returns the EXEC response. A rejected command is reported as an error;
+// the server then discards the transaction.
+func (tx *Tx) pipeline() (*protocol.Response, error) {
+	cmds := make([]*protocol.Command, 0, len(tx.queued)+2)
+	cmds = append(cmds, &protocol.Command{Type: protocol.CmdMulti})
+	cmds = append(cmds, tx.queued...)
+	cmds = append(cmds, &protocol.Command{Type: protocol.CmdExec})
+
+	cfg := tx.client.config
+	if err := tx.conn.SetWriteDeadline(time.Now().Add(time.Duration(cfg.WriteTimeout) * time.Second)); err != nil {
+		return nil, err
+	}
+	for _, cmd := range cmds {
+		if err := protocol.WriteCommand(tx.conn, cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.conn.SetReadDeadline(time.Now().Add(time.Duration(cfg.ReadTimeout) * time.Second)); err != nil {
+		return nil, err
+	}
+	var rejected error
+	for i := 0; i < len(cmds)-1; i++ {
+		resp, err := protocol.ReadResponse(tx.conn)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Type == protocol.RespError && rejected == nil {
+			rejected = fmt.Errorf("server error: %s", resp.Error)
+		}
+	}
+
+	resp, err := protocol.ReadResponse(tx.conn)
+	if err != nil {
+		return nil, err
+	}
+	if rejected != nil {
+		return &protocol.Response{Type: protocol.RespError, Error: rejected.Error()}, nil
+	}
+	return resp, nil
+}
+
+// roundTrip sends one command on the pinned connection and reads its reply.
+// A connection failure ends the transaction.
+func (tx *Tx) roundTrip(cmd *protocol.Command) (*protocol.Response, error) {
+	cfg := tx.client.config
+	err := tx.conn.SetWriteDeadline(time.Now().Add(time.Duration(cfg.WriteTimeout) * time.Second))
+	if err == nil {
+		err = protocol.WriteCommand(tx.conn, cmd)
+	}
+	if err == nil {
+		err = tx.conn.SetReadDeadline(time.Now().Add(time.Duration(cfg.ReadTimeout) * time.Second))
+	}
+
+	var resp *protocol.Response
+	if err == nil {
+		resp, err = protocol.ReadResponse(tx.conn)
+	}
+	if err != nil {
+		tx.closeConn()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// closeConn closes the pinned connection instead of returning it to the pool.
+func (tx *Tx) closeConn() {
+	if tx.conn == nil {
+		return
+	}
+	tx.client.closeConnection(tx.conn)
+	tx.conn = nil
+}
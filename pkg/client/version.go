@@ -0,0 +1,98 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/protocol"
+)
+
+// GetWithVersion retrieves a string value together with its version, for
+// optimistic updates with CompareAndSet. Every modification of a key gives
+// it a new, larger version.
+//
+// Example:
+//
+//	for {
+//		value, version, err := client.GetWithVersion("config")
+//		if err != nil {
+//			return err
+//		}
+//		ok, err := client.CompareAndSet("config", update(value), version, 0)
+//		if err != nil || ok {
+//			return err
+//		}
+//		// Someone else changed the key; retry
+//	}
+//
+// Parameters:
+//   - key: The key to retrieve
+//
+// Returns:
+//   - The string value and its version
+//   - Error if the key doesn't exist, is not a string or the operation fails
+func (c *Client) GetWithVersion(key string) (string, uint64, error) {
+	resp, err := c.executeCommand(&protocol.Command{Type: protocol.CmdGetV, Key: key})
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch resp.Type {
+	case protocol.RespNested:
+		items, _ := resp.Data.([]interface{})
+		if len(items) != 2 {
+			return "", 0, fmt.Errorf("unexpected response data")
+		}
+		value, ok := items[0].(string)
+		version, vok := items[1].(int64)
+		if !ok || !vok {
+			return "", 0, fmt.Errorf("unexpected response data")
+		}
+		return value, uint64(version), nil
+	case protocol.RespNil:
+		return "", 0, fmt.Errorf("key not found")
+	case protocol.RespError:
+		return "", 0, fmt.Errorf("server error: %s", resp.Error)
+	default:
+		return "", 0, fmt.Errorf("unexpected response type")
+	}
+}
+
+// CompareAndSet stores a string value like Set, but only if the key still
+// has the given version. Use version 0 to create a key that must not exist
+// yet.
+//
+// Parameters:
+//   - key: The key to store
+//   - value: The string value to store
+//   - version: Version obtained from GetWithVersion, or 0
+//   - ttl: Time-to-live duration (0 for no expiration)
+//
+// Returns:
+//   - True if the value was stored, false if the version did not match
+//   - Error if the operation fails
+func (c *Client) CompareAndSet(key, value string, version uint64, ttl time.Duration) (bool, error) {
+	cmd := &protocol.Command{
+		Type: protocol.CmdSet,
+		Key:  key,
+		Args: []string{value, "IFVER", strconv.FormatUint(version, 10)},
+		TTL:  ttl,
+	}
+
+	resp, err := c.executeCommand(cmd)
+	if err != nil {
+		return false, err
+	}
+
+	switch resp.Type {
+	case protocol.RespInt:
+		return true, nil
+	case protocol.RespNil:
+		return false, nil
+	case protocol.RespError:
+		return false, fmt.Errorf("server error: %s", resp.Error)
+	default:
+		return false, fmt.Errorf("unexpected response type")
+	}
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompareAndSet(t *testing.T) {
+	_, addr := startServer(t)
+	c := newTestClient(t, []string{addr}, nil)
+
+	// Version 0 creates a key that doesn't exist yet.
+	if _, _, err := c.GetWithVersion("config"); err == nil {
+		t.Error("Expected GetWithVersion of a missing key to fail")
+	}
+	if ok, err := c.CompareAndSet("config", "v1", 0, 0); err != nil || !ok {
+		t.Fatalf("Expected version 0 to create the key, got %v (%v)", ok, err)
+	}
+	if ok, err := c.CompareAndSet("config", "other", 0, 0); err != nil || ok {
+		t.Errorf("Expected version 0 to fail on an existing key, got %v (%v)", ok, err)
+	}
+
+	value, version, err := c.GetWithVersion("config")
+	if err != nil || value != "v1" || version == 0 {
+		t.Fatalf("Expected v1 with a version, got %q %d (%v)", value, version, err)
+	}
+
+	// The current version stores the value and gives the key a newer one.
+	if ok, err := c.CompareAndSet("config", "v2", version, 0); err != nil || !ok {
+		t.Fatalf("Expected the current version to match, got %v (%v)", ok, err)
+	}
+	value, newer, err := c.GetWithVersion("config")
+	if err != nil || value != "v2" || newer <= version {
+		t.Errorf("Expected v2 with a version above %d, got %q %d (%v)", version, value, newer, err)
+	}
+
+	// A stale version leaves the key alone.
+	if ok, err := c.CompareAndSet("config", "v3", version, 0); err != nil || ok {
+		t.Errorf("Expected a stale version to fail, got %v (%v)", ok, err)
+	}
+	if value, err := c.Get("config"); err != nil || value != "v2" {
+		t.Errorf("Expected v2 to be kept, got %q (%v)", value, err)
+	}
+
+	// Any modification changes the version.
+	if err := c.Set("config", "v3", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if ok, err := c.CompareAndSet("config", "v4", newer, 0); err != nil || ok {
+		t.Errorf("Expected the version to change with Set, got %v (%v)", ok, err)
+	}
+}
+
+func TestCompareAndSetTTL(t *testing.T) {
+	_, addr := startServer(t)
+	c := newTestClient(t, []string{addr}, nil)
+
+	if ok, err := c.CompareAndSet("session", "v1", 0, time.Minute); err != nil || !ok {
+		t.Fatalf("CompareAndSet failed: %v (%v)", ok, err)
+	}
+	if ttl, err := c.TTL("session"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Expected a TTL of up to a minute, got %v (%v)", ttl, err)
+	}
+
+	// Without a TTL, the stored value doesn't expire.
+	_, version, err := c.GetWithVersion("session")
+	if err != nil {
+		t.Fatalf("GetWithVersion failed: %v", err)
+	}
+	if ok, err := c.CompareAndSet("session", "v2", version, 0); err != nil || !ok {
+		t.Fatalf("CompareAndSet failed: %v (%v)", ok, err)
+	}
+	if ttl, err := c.TTL("session"); err != nil || ttl != -time.Second {
+		t.Errorf("Expected no TTL, got %v (%v)", ttl, err)
+	}
+}
@@ -38,9 +38,13 @@ package config
 import (
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+
+	"github.com/cachemir/cachemir/pkg/discovery"
+	"github.com/cachemir/cachemir/pkg/hash"
 )
 
 // Default server configuration constants
@@ -53,7 +57,27 @@ const (
 	DefaultConnTimeoutSecs    = 5
 	DefaultRetryAttempts      = 3
 	DefaultVirtualNodes       = 150
+	DefaultScriptTimeoutMs    = 5000
 	DefaultHashCapacityFactor = 2
+	DefaultFailureThreshold   = 3
+	DefaultHealthCheckSecs    = 1
+)
+
+// Default sentinel configuration constants
+const (
+	DefaultSentinelPort      = 26379
+	DefaultDownAfterMs       = 5000
+	DefaultFailoverTimeoutMs = 30000
+)
+
+// Default discovery configuration constants
+const (
+	DefaultDiscoveryIntervalSecs = 10
+)
+
+// Default near cache configuration constants
+const (
+	DefaultNearCacheTTLMs = 1000
 )
 
 // Protocol constants
@@ -87,6 +111,16 @@ type ServerConfig struct {
 	MaxConns     int    // Maximum concurrent connections (default: 1000)
 	ReadTimeout  int    // Read timeout in seconds (default: 30)
 	WriteTimeout int    // Write timeout in seconds (default: 10)
+
+	NotifyKeyspaceEvents string // Keyspace notification flags, e.g. "KEx" (default: "", disabled)
+	ScriptTimeout        int    // Maximum script run time in milliseconds (default: 5000)
+	ReplicaOf            string // Primary to replicate from as "host:port" (default: "", a primary)
+
+	ClusterAnnounce     string   // Address announced to cluster peers; enables cluster mode (default: "", disabled)
+	ClusterPeers        []string // Cluster servers to join through (default: none)
+	ClusterVirtualNodes int      // Virtual nodes of the cluster ring, as set on clients (default: 150)
+	ClusterHashFunction string   // Hash function of the cluster ring, as set on clients (default: "sha256")
+	ClusterPlacement    string   // Placement algorithm of the cluster ring, as set on clients (default: "consistent")
 }
 
 // ClientConfig holds all configuration options for a CacheMir client instance.
@@ -106,13 +140,53 @@ type ServerConfig struct {
 //	}
 //	client := client.NewWithConfig(config)
 type ClientConfig struct {
-	Nodes           []string // List of server addresses (default: ["localhost:8080"])
+	Nodes           []string // Server addresses, "host:port" or "host:port=weight" (default: ["localhost:8080"])
 	MaxConnsPerNode int      // Max connections per server node (default: 10)
 	ConnTimeout     int      // Connection timeout in seconds (default: 5)
 	ReadTimeout     int      // Read timeout in seconds (default: 30)
 	WriteTimeout    int      // Write timeout in seconds (default: 10)
 	RetryAttempts   int      // Number of retry attempts (default: 3)
 	VirtualNodes    int      // Virtual nodes for consistent hashing (default: 150)
+	Sentinels       []string // Sentinel addresses to follow failovers from (default: none)
+
+	ReplicationFactor int // Nodes holding each key for replicated commands (default: 1; 0 or 1 disable replication)
+	WriteQuorum       int // Replicas that must acknowledge a write (default: 0, a majority)
+	ReadQuorum        int // Replicas that must answer a read (default: 0, a majority)
+
+	FailureThreshold    int // Consecutive failures before a node is marked down (default: 3; 0 disables)
+	HealthCheckInterval int // Seconds between PING probes of a down node (default: 1)
+
+	HashFunction string  // Hash function placing keys: sha256, xxhash, fnv or murmur3 (default: "sha256")
+	Placement    string  // Placement: consistent, jump, rendezvous, maglev or bounded (default: "consistent")
+	LoadFactor   float64 // Maximum node load relative to the average, for bounded placement (default: 1.25)
+
+	Discovery         string // Node discovery source: "srv:name", "file:path" or "static:nodes" (default: none)
+	DiscoveryInterval int    // Seconds between discovery lookups (default: 10)
+
+	NearCacheSize int // Values kept in process for Get, invalidated by the servers (default: 0, disabled)
+	NearCacheTTL  int // Milliseconds a near-cached value is served at most (default: 1000)
+}
+
+// SentinelConfig holds the configuration of a cachemir-sentinel process,
+// which monitors primaries and promotes one of their replicas when a
+// quorum of sentinels agrees that a primary is down.
+//
+// Example:
+//
+//	config := &SentinelConfig{
+//		Port:      26379,
+//		Primaries: []string{"shard1=cache1:8080", "shard2=cache2:8080"},
+//		Sentinels: []string{"sentinel2:26379", "sentinel3:26379"},
+//		Quorum:    2,
+//	}
+type SentinelConfig struct {
+	Host            string   // Host address to bind to (default: "0.0.0.0")
+	Port            int      // TCP port to listen on (default: 26379)
+	Primaries       []string // Monitored primaries as "name=host:port", or "host:port" named by address
+	Sentinels       []string // Addresses of the other sentinels
+	Quorum          int      // Sentinels that must agree a primary is down (default: 0, a majority)
+	DownAfter       int      // Milliseconds without a PING reply before a primary is down (default: 5000)
+	FailoverTimeout int      // Milliseconds before a failed failover is retried (default: 30000)
 }
 
 // LoadServerConfig creates a ServerConfig by loading values from command-line flags
@@ -126,12 +200,28 @@ type ClientConfig struct {
 //	-read-timeout: Read timeout in seconds (default: 30)
 //	-write-timeout: Write timeout in seconds (default: 10)
 //	-log-level: Log level (default: "info")
+//	-notify-keyspace-events: Keyspace notification flags (default: "")
+//	-script-timeout: Maximum script run time in milliseconds (default: 5000)
+//	-replicaof: Primary to replicate from (default: "")
+//	-cluster-announce: Address announced to cluster peers, enabling cluster mode (default: "")
+//	-cluster-peers: Comma-separated cluster servers to join through (default: "")
+//	-cluster-virtual-nodes: Virtual nodes of the cluster ring (default: 150)
+//	-cluster-hash: Hash function of the cluster ring (default: "sha256")
+//	-cluster-placement: Placement algorithm of the cluster ring (default: "consistent")
 //
 // Environment variables:
 //
 //	CACHEMIR_PORT: Server port
 //	CACHEMIR_HOST: Server host
 //	CACHEMIR_MAX_CONNS: Maximum connections
+//	CACHEMIR_NOTIFY_KEYSPACE_EVENTS: Keyspace notification flags
+//	CACHEMIR_SCRIPT_TIMEOUT: Maximum script run time in milliseconds
+//	CACHEMIR_REPLICAOF: Primary to replicate from (host:port)
+//	CACHEMIR_CLUSTER_ANNOUNCE: Address announced to cluster peers
+//	CACHEMIR_CLUSTER_PEERS: Comma-separated cluster servers to join through
+//	CACHEMIR_CLUSTER_VIRTUAL_NODES: Virtual nodes of the cluster ring
+//	CACHEMIR_CLUSTER_HASH: Hash function of the cluster ring
+//	CACHEMIR_CLUSTER_PLACEMENT: Placement algorithm of the cluster ring
 //
 // Example:
 //
@@ -150,22 +240,45 @@ type ClientConfig struct {
 //   - ServerConfig with values loaded from various sources
 func LoadServerConfig() *ServerConfig {
 	config := &ServerConfig{
-		Port:         DefaultServerPort,
-		Host:         "0.0.0.0",
-		MaxConns:     DefaultMaxConnections,
-		ReadTimeout:  DefaultReadTimeoutSecs,
-		WriteTimeout: DefaultWriteTimeoutSecs,
-		LogLevel:     "info",
+		Port:          DefaultServerPort,
+		Host:          "0.0.0.0",
+		MaxConns:      DefaultMaxConnections,
+		ReadTimeout:   DefaultReadTimeoutSecs,
+		WriteTimeout:  DefaultWriteTimeoutSecs,
+		LogLevel:      "info",
+		ScriptTimeout: DefaultScriptTimeoutMs,
+
+		ClusterVirtualNodes: DefaultVirtualNodes,
+		ClusterHashFunction: hash.HashSHA256,
+		ClusterPlacement:    hash.PlacementConsistent,
 	}
 
+	var peers string
 	flag.IntVar(&config.Port, "port", config.Port, "Server port")
 	flag.StringVar(&config.Host, "host", config.Host, "Server host")
 	flag.IntVar(&config.MaxConns, "max-conns", config.MaxConns, "Maximum concurrent connections")
 	flag.IntVar(&config.ReadTimeout, "read-timeout", config.ReadTimeout, "Read timeout in seconds")
 	flag.IntVar(&config.WriteTimeout, "write-timeout", config.WriteTimeout, "Write timeout in seconds")
 	flag.StringVar(&config.LogLevel, "log-level", config.LogLevel, "Log level (debug, info, warn, error)")
+	flag.StringVar(&config.NotifyKeyspaceEvents, "notify-keyspace-events", config.NotifyKeyspaceEvents,
+		"Keyspace notification flags (K, E and event classes g$lshzxetdA)")
+	flag.IntVar(&config.ScriptTimeout, "script-timeout", config.ScriptTimeout, "Maximum script run time in milliseconds")
+	flag.StringVar(&config.ReplicaOf, "replicaof", config.ReplicaOf, "Primary to replicate from (host:port)")
+	flag.StringVar(&config.ClusterAnnounce, "cluster-announce", config.ClusterAnnounce,
+		"Address announced to cluster peers (host:port); enables cluster mode")
+	flag.StringVar(&peers, "cluster-peers", "", "Comma-separated cluster servers to join through")
+	flag.IntVar(&config.ClusterVirtualNodes, "cluster-virtual-nodes", config.ClusterVirtualNodes,
+		"Virtual nodes of the cluster ring, as set on clients")
+	flag.StringVar(&config.ClusterHashFunction, "cluster-hash", config.ClusterHashFunction,
+		"Hash function of the cluster ring, as set on clients (sha256, xxhash, fnv, murmur3)")
+	flag.StringVar(&config.ClusterPlacement, "cluster-placement", config.ClusterPlacement,
+		"Placement algorithm of the cluster ring, as set on clients (consistent, rendezvous, maglev)")
 	flag.Parse()
 
+	if peers != "" {
+		config.ClusterPeers = splitList(peers)
+	}
+
 	if port := os.Getenv("CACHEMIR_PORT"); port != "" {
 		if p, err := strconv.Atoi(port); err == nil {
 			config.Port = p
@@ -182,21 +295,79 @@ func LoadServerConfig() *ServerConfig {
 		}
 	}
 
+	if events := os.Getenv("CACHEMIR_NOTIFY_KEYSPACE_EVENTS"); events != "" {
+		config.NotifyKeyspaceEvents = events
+	}
+
+	if timeout := os.Getenv("CACHEMIR_SCRIPT_TIMEOUT"); timeout != "" {
+		if t, err := strconv.Atoi(timeout); err == nil {
+			config.ScriptTimeout = t
+		}
+	}
+
+	if primary := os.Getenv("CACHEMIR_REPLICAOF"); primary != "" {
+		config.ReplicaOf = primary
+	}
+
+	if announce := os.Getenv("CACHEMIR_CLUSTER_ANNOUNCE"); announce != "" {
+		config.ClusterAnnounce = announce
+	}
+
+	if peers := os.Getenv("CACHEMIR_CLUSTER_PEERS"); peers != "" {
+		config.ClusterPeers = splitList(peers)
+	}
+
+	if virtualNodes := os.Getenv("CACHEMIR_CLUSTER_VIRTUAL_NODES"); virtualNodes != "" {
+		if vn, err := strconv.Atoi(virtualNodes); err == nil {
+			config.ClusterVirtualNodes = vn
+		}
+	}
+
+	if hashFunction := os.Getenv("CACHEMIR_CLUSTER_HASH"); hashFunction != "" {
+		config.ClusterHashFunction = hashFunction
+	}
+
+	if placement := os.Getenv("CACHEMIR_CLUSTER_PLACEMENT"); placement != "" {
+		config.ClusterPlacement = placement
+	}
+
 	return config
 }
 
+// ClusterRing returns the configuration of the cluster ring.
+func (c *ServerConfig) ClusterRing() hash.RingConfig {
+	return hash.RingConfig{
+		Placement:    c.ClusterPlacement,
+		Hash:         c.ClusterHashFunction,
+		VirtualNodes: c.ClusterVirtualNodes,
+	}
+}
+
 // LoadClientConfig creates a ClientConfig by loading values from environment
 // variables, with sensible defaults.
 //
 // Environment variables:
 //
-//	CACHEMIR_NODES: Comma-separated list of server addresses
+//	CACHEMIR_NODES: Comma-separated list of server addresses, optionally weighted as host:port=weight
 //	CACHEMIR_MAX_CONNS_PER_NODE: Maximum connections per server
 //	CACHEMIR_CONN_TIMEOUT: Connection timeout in seconds
 //	CACHEMIR_READ_TIMEOUT: Read timeout in seconds
 //	CACHEMIR_WRITE_TIMEOUT: Write timeout in seconds
 //	CACHEMIR_RETRY_ATTEMPTS: Number of retry attempts
 //	CACHEMIR_VIRTUAL_NODES: Virtual nodes for consistent hashing
+//	CACHEMIR_SENTINELS: Comma-separated list of sentinel addresses
+//	CACHEMIR_REPLICATION_FACTOR: Nodes holding each key
+//	CACHEMIR_WRITE_QUORUM: Replicas that must acknowledge a write
+//	CACHEMIR_READ_QUORUM: Replicas that must answer a read
+//	CACHEMIR_FAILURE_THRESHOLD: Consecutive failures before a node is marked down
+//	CACHEMIR_HEALTH_CHECK_INTERVAL: Seconds between probes of a down node
+//	CACHEMIR_HASH_FUNCTION: Hash function placing keys (sha256, xxhash, fnv, murmur3)
+//	CACHEMIR_PLACEMENT: Placement algorithm (consistent, jump, rendezvous, maglev, bounded)
+//	CACHEMIR_LOAD_FACTOR: Maximum node load relative to the average, for bounded placement
+//	CACHEMIR_DISCOVERY: Node discovery source (srv:name, file:path or static:nodes); clears the default node
+//	CACHEMIR_DISCOVERY_INTERVAL: Seconds between discovery lookups
+//	CACHEMIR_NEAR_CACHE_SIZE: Values kept in the client-side near cache (0 disables it)
+//	CACHEMIR_NEAR_CACHE_TTL: Milliseconds a near-cached value is served at most
 //
 // Example:
 //
@@ -224,13 +395,28 @@ func LoadClientConfig() *ClientConfig {
 		WriteTimeout:    DefaultWriteTimeoutSecs,
 		RetryAttempts:   DefaultRetryAttempts,
 		VirtualNodes:    DefaultVirtualNodes,
+
+		ReplicationFactor: 1,
+
+		FailureThreshold:    DefaultFailureThreshold,
+		HealthCheckInterval: DefaultHealthCheckSecs,
+
+		HashFunction: hash.HashSHA256,
+		Placement:    hash.PlacementConsistent,
+		LoadFactor:   hash.DefaultLoadFactor,
+
+		DiscoveryInterval: DefaultDiscoveryIntervalSecs,
+
+		NearCacheTTL: DefaultNearCacheTTLMs,
+	}
+
+	if source := os.Getenv("CACHEMIR_DISCOVERY"); source != "" {
+		config.Discovery = source
+		config.Nodes = nil
 	}
 
 	if nodes := os.Getenv("CACHEMIR_NODES"); nodes != "" {
-		config.Nodes = strings.Split(nodes, ",")
-		for i, node := range config.Nodes {
-			config.Nodes[i] = strings.TrimSpace(node)
-		}
+		config.Nodes = splitList(nodes)
 	}
 
 	if maxConns := os.Getenv("CACHEMIR_MAX_CONNS_PER_NODE"); maxConns != "" {
@@ -269,9 +455,225 @@ func LoadClientConfig() *ClientConfig {
 		}
 	}
 
+	if sentinels := os.Getenv("CACHEMIR_SENTINELS"); sentinels != "" {
+		config.Sentinels = splitList(sentinels)
+	}
+
+	if factor := os.Getenv("CACHEMIR_REPLICATION_FACTOR"); factor != "" {
+		if n, err := strconv.Atoi(factor); err == nil {
+			config.ReplicationFactor = n
+		}
+	}
+
+	if quorum := os.Getenv("CACHEMIR_WRITE_QUORUM"); quorum != "" {
+		if w, err := strconv.Atoi(quorum); err == nil {
+			config.WriteQuorum = w
+		}
+	}
+
+	if quorum := os.Getenv("CACHEMIR_READ_QUORUM"); quorum != "" {
+		if r, err := strconv.Atoi(quorum); err == nil {
+			config.ReadQuorum = r
+		}
+	}
+
+	if threshold := os.Getenv("CACHEMIR_FAILURE_THRESHOLD"); threshold != "" {
+		if ft, err := strconv.Atoi(threshold); err == nil {
+			config.FailureThreshold = ft
+		}
+	}
+
+	if interval := os.Getenv("CACHEMIR_HEALTH_CHECK_INTERVAL"); interval != "" {
+		if hi, err := strconv.Atoi(interval); err == nil {
+			config.HealthCheckInterval = hi
+		}
+	}
+
+	if hashFunction := os.Getenv("CACHEMIR_HASH_FUNCTION"); hashFunction != "" {
+		config.HashFunction = hashFunction
+	}
+
+	if placement := os.Getenv("CACHEMIR_PLACEMENT"); placement != "" {
+		config.Placement = placement
+	}
+
+	if loadFactor := os.Getenv("CACHEMIR_LOAD_FACTOR"); loadFactor != "" {
+		if lf, err := strconv.ParseFloat(loadFactor, 64); err == nil {
+			config.LoadFactor = lf
+		}
+	}
+
+	if interval := os.Getenv("CACHEMIR_DISCOVERY_INTERVAL"); interval != "" {
+		if di, err := strconv.Atoi(interval); err == nil {
+			config.DiscoveryInterval = di
+		}
+	}
+
+	if size := os.Getenv("CACHEMIR_NEAR_CACHE_SIZE"); size != "" {
+		if ns, err := strconv.Atoi(size); err == nil {
+			config.NearCacheSize = ns
+		}
+	}
+
+	if ttl := os.Getenv("CACHEMIR_NEAR_CACHE_TTL"); ttl != "" {
+		if nt, err := strconv.Atoi(ttl); err == nil {
+			config.NearCacheTTL = nt
+		}
+	}
+
+	return config
+}
+
+// Ring returns the configuration of the client's hash ring.
+func (c *ClientConfig) Ring() hash.RingConfig {
+	return hash.RingConfig{
+		Placement:    c.Placement,
+		Hash:         c.HashFunction,
+		VirtualNodes: c.VirtualNodes,
+		LoadFactor:   c.LoadFactor,
+	}
+}
+
+// Quorums returns the write and read quorums, resolving 0 to a majority of
+// the replication factor. Validate rejects quorums that don't add up to more
+// than the replication factor, with which a read could miss the latest
+// write entirely.
+func (c *ClientConfig) Quorums() (write, read int) {
+	majority := max(c.ReplicationFactor, 1)/2 + 1
+	write, read = c.WriteQuorum, c.ReadQuorum
+	if write == 0 {
+		write = majority
+	}
+	if read == 0 {
+		read = majority
+	}
+	return write, read
+}
+
+// LoadSentinelConfig creates a SentinelConfig by loading values from
+// command-line flags and environment variables, with sensible defaults.
+//
+// Command-line flags:
+//
+//	-port: Sentinel port (default: 26379)
+//	-host: Sentinel host (default: "0.0.0.0")
+//	-primaries: Comma-separated monitored primaries, "name=host:port" or "host:port"
+//	-sentinels: Comma-separated addresses of the other sentinels
+//	-quorum: Sentinels that must agree a primary is down (default: majority)
+//	-down-after: Milliseconds without a PING reply before a primary is down (default: 5000)
+//	-failover-timeout: Milliseconds before a failed failover is retried (default: 30000)
+//
+// Environment variables:
+//
+//	CACHEMIR_PORT, CACHEMIR_HOST: Listening address
+//	CACHEMIR_PRIMARIES: Comma-separated monitored primaries
+//	CACHEMIR_SENTINELS: Comma-separated addresses of the other sentinels
+//	CACHEMIR_QUORUM: Sentinels that must agree a primary is down
+//
+// Returns:
+//   - SentinelConfig with values loaded from flags, environment variables and defaults
+func LoadSentinelConfig() *SentinelConfig {
+	config := &SentinelConfig{
+		Host:            "0.0.0.0",
+		Port:            DefaultSentinelPort,
+		DownAfter:       DefaultDownAfterMs,
+		FailoverTimeout: DefaultFailoverTimeoutMs,
+	}
+
+	var primaries, sentinels string
+	flag.IntVar(&config.Port, "port", config.Port, "Sentinel port")
+	flag.StringVar(&config.Host, "host", config.Host, "Sentinel host")
+	flag.StringVar(&primaries, "primaries", "", "Comma-separated monitored primaries (name=host:port or host:port)")
+	flag.StringVar(&sentinels, "sentinels", "", "Comma-separated addresses of the other sentinels")
+	flag.IntVar(&config.Quorum, "quorum", config.Quorum, "Sentinels that must agree a primary is down (0: majority)")
+	flag.IntVar(&config.DownAfter, "down-after", config.DownAfter,
+		"Milliseconds without a PING reply before a primary is down")
+	flag.IntVar(&config.FailoverTimeout, "failover-timeout", config.FailoverTimeout,
+		"Milliseconds before a failed failover is retried")
+	flag.Parse()
+
+	if primaries != "" {
+		config.Primaries = splitList(primaries)
+	}
+	if sentinels != "" {
+		config.Sentinels = splitList(sentinels)
+	}
+
+	if port := os.Getenv("CACHEMIR_PORT"); port != "" {
+		if p, err := strconv.Atoi(port); err == nil {
+			config.Port = p
+		}
+	}
+
+	if host := os.Getenv("CACHEMIR_HOST"); host != "" {
+		config.Host = host
+	}
+
+	if primaries := os.Getenv("CACHEMIR_PRIMARIES"); primaries != "" {
+		config.Primaries = splitList(primaries)
+	}
+
+	if sentinels := os.Getenv("CACHEMIR_SENTINELS"); sentinels != "" {
+		config.Sentinels = splitList(sentinels)
+	}
+
+	if quorum := os.Getenv("CACHEMIR_QUORUM"); quorum != "" {
+		if q, err := strconv.Atoi(quorum); err == nil {
+			config.Quorum = q
+		}
+	}
+
 	return config
 }
 
+// splitList splits a comma-separated list, trimming spaces around items.
+func splitList(list string) []string {
+	items := strings.Split(list, ",")
+	for i, item := range items {
+		items[i] = strings.TrimSpace(item)
+	}
+	return items
+}
+
+// ParseNode parses a node of a ClientConfig, given as "host:port" or
+// "host:port=weight". Nodes own a share of the keys proportional to their
+// weight, which defaults to 1.
+func ParseNode(spec string) (addr string, weight int, err error) {
+	addr, weightStr, weighted := strings.Cut(spec, "=")
+	if addr == "" {
+		return "", 0, fmt.Errorf("empty node address")
+	}
+	if !strings.Contains(addr, ":") {
+		return "", 0, fmt.Errorf("invalid node address format: %s", addr)
+	}
+	if !weighted {
+		return addr, 1, nil
+	}
+	weight, err = strconv.Atoi(weightStr)
+	if err != nil || weight < 1 {
+		return "", 0, fmt.Errorf("invalid node weight: %s", spec)
+	}
+	return addr, weight, nil
+}
+
+// ParsePrimary parses a monitored primary of a SentinelConfig, given as
+// "name=host:port" or "host:port". A primary without a name is named by its
+// address.
+func ParsePrimary(spec string) (name, addr string, err error) {
+	name, addr, found := strings.Cut(spec, "=")
+	if !found {
+		addr = spec
+		name = spec
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("empty primary name: %s", spec)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", "", fmt.Errorf("invalid primary address %q: %w", addr, err)
+	}
+	return name, addr, nil
+}
+
 // Address returns the full address string for the server to bind to.
 // It combines the host and port into a format suitable for net.Listen().
 //
@@ -295,6 +697,10 @@ func (c *ServerConfig) Address() string {
 //   - MaxConns must be positive
 //   - ReadTimeout must be positive
 //   - WriteTimeout must be positive
+//   - ScriptTimeout must be positive
+//   - ReplicaOf must be empty or a host:port address
+//   - ClusterAnnounce and ClusterPeers must be host:port addresses, with positive ClusterVirtualNodes
+//   - ClusterHashFunction must be known, and ClusterPlacement consistent, rendezvous or maglev
 //   - LogLevel must be one of: debug, info, warn, error
 //
 // Example:
@@ -324,6 +730,37 @@ func (c *ServerConfig) Validate() error {
 		return fmt.Errorf("write timeout must be positive: %d", c.WriteTimeout)
 	}
 
+	if c.ScriptTimeout < 1 {
+		return fmt.Errorf("script timeout must be positive: %d", c.ScriptTimeout)
+	}
+
+	if c.ReplicaOf != "" {
+		if _, _, err := net.SplitHostPort(c.ReplicaOf); err != nil {
+			return fmt.Errorf("invalid primary address %q: %w", c.ReplicaOf, err)
+		}
+	}
+
+	if c.ClusterAnnounce != "" {
+		for _, addr := range append([]string{c.ClusterAnnounce}, c.ClusterPeers...) {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return fmt.Errorf("invalid cluster address %q: %w", addr, err)
+			}
+		}
+		if c.ClusterVirtualNodes < 1 {
+			return fmt.Errorf("cluster virtual nodes must be positive: %d", c.ClusterVirtualNodes)
+		}
+		// Jump depends on the order nodes are added in, and bounded
+		// placement on client loads, which servers can't agree on.
+		switch c.ClusterPlacement {
+		case "", hash.PlacementConsistent, hash.PlacementRendezvous, hash.PlacementMaglev:
+		default:
+			return fmt.Errorf("unsupported cluster placement: %s", c.ClusterPlacement)
+		}
+		if _, err := hash.NewHasher(c.ClusterHashFunction); err != nil {
+			return err
+		}
+	}
+
 	validLogLevels := map[string]bool{
 		"debug": true,
 		"info":  true,
@@ -343,12 +780,20 @@ func (c *ServerConfig) Validate() error {
 // that required fields are properly configured.
 //
 // Validation rules:
-//   - At least one node must be specified
-//   - All node addresses must be non-empty and contain a colon
+//   - At least one node, sentinel or discovery source must be specified
+//   - All node and sentinel addresses must be non-empty and contain a colon
+//   - Node weights, given as "host:port=weight", must be positive integers
 //   - MaxConnsPerNode must be positive
 //   - All timeout values must be positive
 //   - RetryAttempts must be non-negative
 //   - VirtualNodes must be positive
+//   - ReplicationFactor must be non-negative
+//   - WriteQuorum and ReadQuorum must be between 0 (a majority) and ReplicationFactor,
+//     and add up to more than ReplicationFactor
+//   - FailureThreshold must be non-negative, and HealthCheckInterval positive if it isn't 0
+//   - HashFunction and Placement must be known, and LoadFactor above 1 for bounded placement
+//   - Discovery must be a known source, and DiscoveryInterval positive if it is set
+//   - NearCacheSize must be non-negative, and NearCacheTTL positive if it isn't 0
 //
 // Example:
 //
@@ -365,11 +810,17 @@ func (c *ServerConfig) Validate() error {
 //   - nil if configuration is valid
 //   - Error describing the first validation failure found
 func (c *ClientConfig) Validate() error {
-	if len(c.Nodes) == 0 {
+	if len(c.Nodes) == 0 && len(c.Sentinels) == 0 && c.Discovery == "" {
 		return fmt.Errorf("at least one node must be specified")
 	}
 
 	for _, node := range c.Nodes {
+		if _, _, err := ParseNode(node); err != nil {
+			return err
+		}
+	}
+
+	for _, node := range c.Sentinels {
 		if node == "" {
 			return fmt.Errorf("empty node address")
 		}
@@ -402,5 +853,113 @@ func (c *ClientConfig) Validate() error {
 		return fmt.Errorf("virtual nodes must be positive: %d", c.VirtualNodes)
 	}
 
+	if c.ReplicationFactor < 0 {
+		return fmt.Errorf("replication factor must be non-negative: %d", c.ReplicationFactor)
+	}
+
+	replicas := max(c.ReplicationFactor, 1)
+	if c.WriteQuorum < 0 || c.WriteQuorum > replicas {
+		return fmt.Errorf("write quorum must be between 0 and %d: %d", replicas, c.WriteQuorum)
+	}
+
+	if c.ReadQuorum < 0 || c.ReadQuorum > replicas {
+		return fmt.Errorf("read quorum must be between 0 and %d: %d", replicas, c.ReadQuorum)
+	}
+
+	if write, read := c.Quorums(); write+read <= replicas {
+		return fmt.Errorf("write and read quorums must add up to more than %d replicas: %d + %d", replicas, write, read)
+	}
+
+	if c.FailureThreshold < 0 {
+		return fmt.Errorf("failure threshold must be non-negative: %d", c.FailureThreshold)
+	}
+
+	if c.FailureThreshold > 0 && c.HealthCheckInterval < 1 {
+		return fmt.Errorf("health check interval must be positive: %d", c.HealthCheckInterval)
+	}
+
+	if _, err := hash.NewRing(c.Ring()); err != nil {
+		return err
+	}
+
+	if c.Discovery != "" {
+		if _, err := discovery.Parse(c.Discovery); err != nil {
+			return err
+		}
+		if c.DiscoveryInterval < 1 {
+			return fmt.Errorf("discovery interval must be positive: %d", c.DiscoveryInterval)
+		}
+	}
+
+	if c.NearCacheSize < 0 {
+		return fmt.Errorf("near cache size must be non-negative: %d", c.NearCacheSize)
+	}
+
+	if c.NearCacheSize > 0 && c.NearCacheTTL < 1 {
+		return fmt.Errorf("near cache TTL must be positive: %d", c.NearCacheTTL)
+	}
+
+	return nil
+}
+
+// Address returns the address the sentinel listens on, in "host:port"
+// format.
+func (c *SentinelConfig) Address() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
+
+// Validate checks if the SentinelConfig contains valid values.
+//
+// Validation rules:
+//   - Port must be between 1 and 65535
+//   - At least one primary must be specified, each with a unique name and a
+//     host:port address
+//   - Sentinel addresses must be host:port addresses
+//   - Quorum must be between 0 (a majority) and the number of sentinels
+//     including this one
+//   - DownAfter and FailoverTimeout must be positive
+//
+// Returns:
+//   - nil if configuration is valid
+//   - Error describing the first validation failure found
+func (c *SentinelConfig) Validate() error {
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("invalid port: %d", c.Port)
+	}
+
+	if len(c.Primaries) == 0 {
+		return fmt.Errorf("at least one primary must be specified")
+	}
+
+	names := make(map[string]bool)
+	for _, spec := range c.Primaries {
+		name, _, err := ParsePrimary(spec)
+		if err != nil {
+			return err
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate primary name: %s", name)
+		}
+		names[name] = true
+	}
+
+	for _, sentinel := range c.Sentinels {
+		if _, _, err := net.SplitHostPort(sentinel); err != nil {
+			return fmt.Errorf("invalid sentinel address %q: %w", sentinel, err)
+		}
+	}
+
+	if c.Quorum < 0 || c.Quorum > len(c.Sentinels)+1 {
+		return fmt.Errorf("quorum must be between 0 and %d: %d", len(c.Sentinels)+1, c.Quorum)
+	}
+
+	if c.DownAfter < 1 {
+		return fmt.Errorf("down-after must be positive: %d", c.DownAfter)
+	}
+
+	if c.FailoverTimeout < 1 {
+		return fmt.Errorf("failover timeout must be positive: %d", c.FailoverTimeout)
+	}
+
 	return nil
 }
@@ -0,0 +1,80 @@
+// Package discovery provides sources of the nodes of a CacheMir cluster, so
+// that clients follow nodes as they come and go instead of using a fixed
+// list.
+//
+// A Discovery returns the current nodes, as "host:port" or
+// "host:port=weight". Clients poll it and add or remove nodes to match:
+//
+//	d, err := discovery.Parse("srv:_cachemir._tcp.cache.example.com")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	err = client.Discover(d, 10*time.Second)
+//
+// Or through the client configuration:
+//
+//	CACHEMIR_DISCOVERY=file:/etc/cachemir/nodes.yaml
+//
+// Three sources are provided: DNS SRV records (SRV), a file reloaded when
+// it changes (File) and a fixed list (Static). Other sources, such as a
+// service registry, only need to implement Discovery.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Discovery is a source of the nodes of a cluster.
+type Discovery interface {
+	// Nodes returns the current nodes, as "host:port" or "host:port=weight".
+	// An error means the nodes are unknown, not that there are none, so
+	// callers keep the nodes they have.
+	Nodes(ctx context.Context) ([]string, error)
+}
+
+// Parse creates the Discovery described by spec:
+//
+//   - "srv:name" looks up the SRV records of name, e.g. "srv:_cachemir._tcp.example.com"
+//   - "file:path" reads a JSON or YAML list of nodes, e.g. "file:/etc/cachemir/nodes.json"
+//   - "static:nodes" returns a comma-separated list of nodes, e.g. "static:cache1:8080,cache2:8080=2"
+func Parse(spec string) (Discovery, error) {
+	scheme, value, ok := strings.Cut(spec, ":")
+	if !ok || value == "" {
+		return nil, fmt.Errorf("invalid discovery source: %q", spec)
+	}
+
+	switch scheme {
+	case "srv":
+		return NewSRV(value), nil
+	case "file":
+		return NewFile(value), nil
+	case "static":
+		return NewStatic(strings.Split(value, ",")...), nil
+	default:
+		return nil, fmt.Errorf("unknown discovery source: %s", scheme)
+	}
+}
+
+// Static is a fixed list of nodes.
+type Static struct {
+	nodes []string
+}
+
+// NewStatic creates a Static source of nodes. Blank entries are ignored.
+func NewStatic(nodes ...string) *Static {
+	s := &Static{}
+	for _, node := range nodes {
+		if node = strings.TrimSpace(node); node != "" {
+			s.nodes = append(s.nodes, node)
+		}
+	}
+	return s
+}
+
+// Nodes returns the nodes of the list.
+func (s *Static) Nodes(_ context.Context) ([]string, error) {
+	return slices.Clone(s.nodes), nil
+}
@@ -0,0 +1,243 @@
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec string
+		want interface{}
+	}{
+		{"srv:_cachemir._tcp.example.com", &SRV{}},
+		{"file:/etc/cachemir/nodes.yaml", &File{}},
+		{"static:cache1:8080,cache2:8080=2", &Static{}},
+	}
+	for _, tt := range tests {
+		d, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		switch tt.want.(type) {
+		case *SRV:
+			if srv, ok := d.(*SRV); !ok || srv.Name != "_cachemir._tcp.example.com" {
+				t.Errorf("Parse(%q) = %#v", tt.spec, d)
+			}
+		case *File:
+			if file, ok := d.(*File); !ok || file.path != "/etc/cachemir/nodes.yaml" {
+				t.Errorf("Parse(%q) = %#v", tt.spec, d)
+			}
+		case *Static:
+			if _, ok := d.(*Static); !ok {
+				t.Errorf("Parse(%q) = %#v", tt.spec, d)
+			}
+		}
+	}
+
+	for _, spec := range []string{"", "srv", "srv:", "consul:cache", "cache1:8080"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestStatic(t *testing.T) {
+	d, err := Parse("static: cache1:8080, ,cache2:8080=2")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	nodes, err := d.Nodes(context.Background())
+	if err != nil || !slices.Equal(nodes, []string{"cache1:8080", "cache2:8080=2"}) {
+		t.Errorf("Expected [cache1:8080 cache2:8080=2], got %v, %v", nodes, err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	want := []string{"cache1:8080", "cache2:8080=2"}
+	formats := map[string]string{
+		"json list":   `["cache1:8080", "cache2:8080=2"]`,
+		"json object": `{"nodes": ["cache1:8080", "cache2:8080=2"]}`,
+		"yaml list":   "# Cache nodes\n- cache1:8080\n- 'cache2:8080=2'\n",
+		"yaml object": "---\nversion: 2\nnodes:\n  - cache1:8080 # Small\n  - \"cache2:8080=2\"\nother:\n  - cache3:8080\n",
+		"yaml flow":   "nodes: [cache1:8080, \"cache2:8080=2\"]\n",
+	}
+	for name, content := range formats {
+		path := filepath.Join(t.TempDir(), "nodes")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		nodes, err := NewFile(path).Nodes(context.Background())
+		if err != nil || !slices.Equal(nodes, want) {
+			t.Errorf("%s: expected %v, got %v, %v", name, want, nodes, err)
+		}
+	}
+
+	for _, content := range []string{"", "nodes: cache1:8080", "[1, 2]", "just text"} {
+		path := filepath.Join(t.TempDir(), "nodes")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if nodes, err := NewFile(path).Nodes(context.Background()); err == nil {
+			t.Errorf("Expected an error for %q, got %v", content, nodes)
+		}
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.json")
+	write := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := NewFile(path)
+	start := time.Now().Add(-time.Hour)
+	write(`["cache1:8080"]`, start)
+	if n, err := f.Nodes(context.Background()); err != nil || !slices.Equal(n, []string{"cache1:8080"}) {
+		t.Fatalf("Expected [cache1:8080], got %v, %v", n, err)
+	}
+
+	write(`["cache1:8080", "cache2:8080"]`, start.Add(time.Second))
+	if n, err := f.Nodes(context.Background()); err != nil || len(n) != 2 {
+		t.Errorf("Expected the file to be reloaded, got %v, %v", n, err)
+	}
+
+	write(`["cache1:8080",`, start.Add(2*time.Second))
+	if _, err := f.Nodes(context.Background()); err == nil {
+		t.Error("Expected an error for a truncated file")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Nodes(context.Background()); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestSRV(t *testing.T) {
+	stub := startDNSStub(t, []srvRecord{
+		{priority: 10, weight: 10, port: 8080, target: "cache1.example.com."},
+		{priority: 10, weight: 20, port: 8081, target: "cache2.example.com."},
+		{priority: 10, weight: 10, port: 8080, target: "cache3.example.com."},
+		{priority: 20, weight: 10, port: 8080, target: "backup.example.com."},
+	})
+
+	srv := NewSRV("_cachemir._tcp.example.com")
+	srv.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", stub)
+		},
+	}
+
+	nodes, err := srv.Nodes(context.Background())
+	if err != nil {
+		t.Fatalf("Nodes failed: %v", err)
+	}
+	slices.Sort(nodes)
+	want := []string{"cache1.example.com:8080", "cache2.example.com:8081=2", "cache3.example.com:8080"}
+	if !slices.Equal(nodes, want) {
+		t.Errorf("Expected %v, got %v", want, nodes)
+	}
+}
+
+func TestGCD(t *testing.T) {
+	tests := []struct{ a, b, want int }{{0, 10, 10}, {10, 20, 10}, {12, 18, 6}, {7, 1, 1}}
+	for _, tt := range tests {
+		if got := gcd(tt.a, tt.b); got != tt.want {
+			t.Errorf("gcd(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// srvRecord is an SRV record served by the DNS stub.
+type srvRecord struct {
+	priority, weight, port uint16
+	target                 string
+}
+
+// startDNSStub starts a UDP DNS server that answers every query with
+// records, and returns its address.
+func startDNSStub(t *testing.T, records []srvRecord) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if reply := dnsReply(buf[:n], records); reply != nil {
+				conn.WriteTo(reply, addr) //nolint:errcheck,gosec // Best effort
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// dnsReply builds the reply to a query: its header and question, followed
+// by an answer for each record.
+func dnsReply(query []byte, records []srvRecord) []byte {
+	const headerSize = 12
+	if len(query) < headerSize {
+		return nil
+	}
+	end := headerSize
+	for end < len(query) && query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5 // Root label, type and class
+	if end > len(query) {
+		return nil
+	}
+
+	reply := append([]byte(nil), query[:end]...)
+	binary.BigEndian.PutUint16(reply[2:], 0x8180)               // Response, recursion desired and available
+	binary.BigEndian.PutUint16(reply[4:], 1)                    // Questions
+	binary.BigEndian.PutUint16(reply[6:], uint16(len(records))) //nolint:gosec // Few records
+	binary.BigEndian.PutUint32(reply[8:], 0)                    // Authority and additional records
+	for _, record := range records {
+		reply = append(reply, 0xc0, headerSize)          // Name: pointer to the question
+		reply = binary.BigEndian.AppendUint16(reply, 33) // Type SRV
+		reply = binary.BigEndian.AppendUint16(reply, 1)  // Class IN
+		reply = binary.BigEndian.AppendUint32(reply, 60) // TTL
+		target := encodeDNSName(record.target)
+		reply = binary.BigEndian.AppendUint16(reply, uint16(6+len(target))) //nolint:gosec // Short names
+		reply = binary.BigEndian.AppendUint16(reply, record.priority)
+		reply = binary.BigEndian.AppendUint16(reply, record.weight)
+		reply = binary.BigEndian.AppendUint16(reply, record.port)
+		reply = append(reply, target...)
+	}
+	return reply
+}
+
+// encodeDNSName encodes a name as DNS labels.
+func encodeDNSName(name string) []byte {
+	var encoded []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		encoded = append(encoded, byte(len(label)))
+		encoded = append(encoded, label...)
+	}
+	return append(encoded, 0)
+}
@@ -0,0 +1,148 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File discovers nodes from a file, which is read again whenever its size
+// or modification time changes. The file holds a JSON or YAML list of
+// nodes, either on its own or under a "nodes" key:
+//
+//	{"nodes": ["cache1:8080", "cache2:8080=2"]}
+//
+//	nodes:
+//	  - cache1:8080
+//	  - cache2:8080=2 # Twice the keys of cache1
+//
+// Only this subset of YAML is supported: comments, block and flow lists,
+// and quoted items. Files should be replaced atomically, by renaming a new
+// file over the old one, so that a half-written file is never read; a file
+// that doesn't parse or lists no nodes is an error, and keeps the nodes
+// read before.
+type File struct {
+	path string
+
+	mu      sync.Mutex
+	size    int64     // Size of the file when last read
+	modTime time.Time // Modification time of the file when last read
+	nodes   []string  // Nodes read last
+}
+
+// NewFile creates a File source reading path.
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Nodes returns the nodes of the file, reading it if it changed.
+func (f *File) Nodes(_ context.Context) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if f.nodes != nil && info.Size() == f.size && info.ModTime().Equal(f.modTime) {
+		return slices.Clone(f.nodes), nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := parseNodeList(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.path, err)
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("%s: no nodes", f.path)
+	}
+
+	f.size, f.modTime, f.nodes = info.Size(), info.ModTime(), nodes
+	return slices.Clone(nodes), nil
+}
+
+// parseNodeList parses a JSON list of nodes, a JSON object with a "nodes"
+// list, or their YAML equivalents.
+func parseNodeList(data []byte) ([]string, error) {
+	trimmed := strings.TrimSpace(string(data))
+	switch {
+	case strings.HasPrefix(trimmed, "["):
+		var nodes []string
+		err := json.Unmarshal(data, &nodes)
+		return nodes, err
+	case strings.HasPrefix(trimmed, "{"):
+		var list struct {
+			Nodes []string `json:"nodes"`
+		}
+		err := json.Unmarshal(data, &list)
+		return list.Nodes, err
+	default:
+		return parseYAMLNodeList(trimmed)
+	}
+}
+
+// parseYAMLNodeList parses a YAML list of nodes, at the top level or under
+// a "nodes" key. Items under other keys are ignored.
+func parseYAMLNodeList(text string) ([]string, error) {
+	var nodes []string
+	inNodes := true // Whether list items belong to the node list
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(stripYAMLComment(line))
+		if line == "" || line == "---" {
+			continue
+		}
+
+		if item, ok := strings.CutPrefix(line, "-"); ok {
+			if inNodes {
+				nodes = append(nodes, unquoteYAML(strings.TrimSpace(item)))
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected a list item or a key", i+1)
+		}
+		inNodes = strings.TrimSpace(key) == "nodes"
+		value = strings.TrimSpace(value)
+		if !inNodes || value == "" {
+			continue
+		}
+		if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+			return nil, fmt.Errorf("line %d: nodes must be a list", i+1)
+		}
+		for _, item := range strings.Split(value[1:len(value)-1], ",") {
+			if item = unquoteYAML(strings.TrimSpace(item)); item != "" {
+				nodes = append(nodes, item)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// stripYAMLComment removes a comment, which starts with "#" at the start of
+// the line or after a space.
+func stripYAMLComment(line string) string {
+	for i := range len(line) {
+		if line[i] == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquoteYAML removes the single or double quotes around s, if any.
+func unquoteYAML(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
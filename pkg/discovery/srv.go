@@ -0,0 +1,74 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// SRV discovers nodes from the DNS SRV records of a name, such as the
+// records a Kubernetes headless service or Consul publish. Only the records
+// of the lowest priority are used, as the others are backups. Their weights
+// become node weights, divided by their greatest common divisor so that
+// records weighted 10, 10 and 20 give weights 1, 1 and 2; weight 0 counts
+// as 1.
+type SRV struct {
+	// Name is the full name to look up, e.g. "_cachemir._tcp.example.com".
+	Name string
+	// Resolver looks up the records; nil for net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+// NewSRV creates an SRV source looking up name with the default resolver.
+func NewSRV(name string) *SRV {
+	return &SRV{Name: name}
+}
+
+// Nodes looks up the SRV records and returns their targets.
+func (s *SRV) Nodes(ctx context.Context) ([]string, error) {
+	resolver := s.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	_, records, err := resolver.LookupSRV(ctx, "", "", s.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no SRV records for %s", s.Name)
+	}
+
+	priority := records[0].Priority
+	for _, record := range records {
+		priority = min(priority, record.Priority)
+	}
+	divisor := 0
+	for _, record := range records {
+		if record.Priority == priority {
+			divisor = gcd(divisor, max(int(record.Weight), 1))
+		}
+	}
+
+	var nodes []string
+	for _, record := range records {
+		if record.Priority != priority {
+			continue
+		}
+		node := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+		if weight := max(int(record.Weight), 1) / divisor; weight > 1 {
+			node += "=" + strconv.Itoa(weight)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// gcd returns the greatest common divisor of a and b.
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -48,6 +48,7 @@
 //   - Minimal key redistribution on topology changes
 //   - Thread-safe ring operations
 //   - Configurable virtual node count
+//   - Pluggable hash functions and jump, rendezvous, Maglev and bounded-load placements
 //
 // Configuration (pkg/config):
 //   - Server and client configuration management
@@ -0,0 +1,142 @@
+package hash
+
+import (
+	"math"
+	"sync"
+)
+
+// BoundedLoad implements consistent hashing with bounded loads (Mirrokni,
+// Thorup and Zadimoghaddam, 2018): keys are placed on a ConsistentHash
+// ring, but a node whose load would exceed loadFactor times the average is
+// skipped, and the key goes to the next node clockwise instead. This keeps
+// hot keys from overloading their node.
+//
+// The load of a node is the number of uses acquired and not yet released
+// (see LoadTracker), so a key may be served by another node while its
+// owner is busy. That suits read-through caching, where such a request
+// misses and is filled from the source of truth, rather than keys that
+// are only ever written once.
+type BoundedLoad struct {
+	ring       *ConsistentHash
+	loadFactor float64
+
+	mu    sync.Mutex
+	loads map[string]int // Acquired uses per node
+	total int            // Sum of loads
+}
+
+// NewBoundedLoad creates an empty BoundedLoad ring with the given virtual
+// nodes per node and hasher. loadFactor is the maximum load of a node
+// relative to the average, and must be above 1.
+func NewBoundedLoad(virtualNodes int, hasher Hasher, loadFactor float64) *BoundedLoad {
+	return &BoundedLoad{
+		ring:       NewWithHasher(virtualNodes, hasher),
+		loadFactor: loadFactor,
+		loads:      make(map[string]int),
+	}
+}
+
+// AddNode adds node to the ring.
+func (b *BoundedLoad) AddNode(node string) {
+	b.ring.AddNode(node)
+}
+
+// AddNodeWithWeight adds node to the ring with the given weight, which also
+// scales its load bound.
+func (b *BoundedLoad) AddNodeWithWeight(node string, weight int) {
+	b.ring.AddNodeWithWeight(node, weight)
+}
+
+// GetWeight returns the weight of node.
+func (b *BoundedLoad) GetWeight(node string) int {
+	return b.ring.GetWeight(node)
+}
+
+// RemoveNode removes node from the ring. Its outstanding uses still count
+// until they are released.
+func (b *BoundedLoad) RemoveNode(node string) {
+	b.ring.RemoveNode(node)
+}
+
+// GetNode returns the first node clockwise from key whose load is below
+// its bound.
+func (b *BoundedLoad) GetNode(key string) string {
+	ring := b.ring
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	if len(ring.sortedHashes) == 0 {
+		return ""
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	idx := ring.search(ring.hashKey(HashTag(key)))
+	for i := 0; i < len(ring.sortedHashes); i++ {
+		node := ring.ring[ring.sortedHashes[(idx+i)%len(ring.sortedHashes)]]
+		if b.loads[node] < b.bound(ring.nodes[node], ring.totalWeight) {
+			return node
+		}
+	}
+	return ring.ring[ring.sortedHashes[idx]]
+}
+
+// GetNodesForKey returns the nodes of key on the ring, regardless of their
+// loads, so that replicas stay in place.
+func (b *BoundedLoad) GetNodesForKey(key string, n int) []string {
+	return b.ring.GetNodesForKey(key, n)
+}
+
+// GetNodes returns all nodes.
+func (b *BoundedLoad) GetNodes() []string {
+	return b.ring.GetNodes()
+}
+
+// Acquire counts a use of node.
+func (b *BoundedLoad) Acquire(node string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.loads[node]++
+	b.total++
+}
+
+// Release ends a use of node counted by Acquire.
+func (b *BoundedLoad) Release(node string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.loads[node] > 0 {
+		b.loads[node]--
+		b.total--
+	}
+	if b.loads[node] == 0 {
+		delete(b.loads, node)
+	}
+}
+
+// Stats returns the statistics of the ring, plus the total "load" and the
+// current "load_bound" of a node of weight 1.
+func (b *BoundedLoad) Stats() map[string]interface{} {
+	stats := b.ring.Stats()
+
+	b.ring.mu.RLock()
+	totalWeight := b.ring.totalWeight
+	b.ring.mu.RUnlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats["load"] = b.total
+	stats["load_bound"] = b.bound(1, max(totalWeight, 1))
+	return stats
+}
+
+// bound returns the maximum load of a node of the given weight, counting
+// the use about to be acquired: its weighted share of the total load,
+// times the load factor. Callers must hold b.mu.
+func (b *BoundedLoad) bound(weight, totalWeight int) int {
+	share := float64(weight) / float64(totalWeight)
+	return int(math.Ceil(b.loadFactor * float64(b.total+1) * share))
+}
@@ -3,6 +3,9 @@
 // Consistent hashing is a technique used to distribute keys across multiple nodes
 // in a way that minimizes redistribution when nodes are added or removed from the cluster.
 // This implementation uses virtual nodes to achieve better key distribution.
+// Other placement algorithms (jump, rendezvous, Maglev and bounded-load
+// consistent hashing) implement the same Ring interface, and every ring can
+// use any Hasher (SHA-256, xxHash, FNV-1a or MurmurHash3).
 //
 // Example usage:
 //
@@ -19,10 +22,13 @@
 //   - Keys are distributed roughly evenly across nodes
 //   - Adding/removing nodes only affects a small portion of keys
 //   - The same key always maps to the same node (until topology changes)
+//
+// Rings of other kinds are created with NewRing:
+//
+//	ring, err := hash.NewRing(hash.RingConfig{Placement: "rendezvous", Hash: "xxhash"})
 package hash
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"sort"
 	"sync"
@@ -37,15 +43,19 @@ const DefaultVirtualNodes = 150
 // It provides thread-safe operations for adding/removing nodes and
 // mapping keys to nodes in a distributed system.
 //
-// The hash ring uses SHA-256 for hashing and maintains virtual nodes
-// to ensure better key distribution. When nodes are added or removed,
-// only a fraction of keys need to be redistributed.
+// The hash ring places keys and virtual nodes at 64-bit positions computed
+// by its Hasher (SHA-256 by default) and maintains virtual nodes to ensure
+// better key distribution. When nodes are added or removed, only a
+// fraction of keys need to be redistributed. Nodes get virtual nodes in
+// proportion to their weight, so that larger servers own more keys.
 type ConsistentHash struct {
-	ring         map[uint32]string // Hash -> node mapping
-	nodes        map[string]bool   // Set of active nodes
-	sortedHashes []uint32          // Sorted hash values for binary search
+	ring         map[uint64]string // Hash -> node mapping
+	nodes        map[string]int    // Active nodes -> weight
+	sortedHashes []uint64          // Sorted hash values for binary search
 	mu           sync.RWMutex      // Protects all fields
+	totalWeight  int               // Sum of the weights of the nodes
 	virtualNodes int               // Number of virtual nodes per physical node
+	hasher       Hasher            // Hash function for keys and virtual nodes
 }
 
 // New creates a new ConsistentHash with the specified number of virtual nodes.
@@ -59,13 +69,25 @@ type ConsistentHash struct {
 //
 //	ch := hash.New(100) // 100 virtual nodes per physical node
 func New(virtualNodes int) *ConsistentHash {
+	return NewWithHasher(virtualNodes, SHA256)
+}
+
+// NewWithHasher creates a new ConsistentHash that places keys and virtual
+// nodes with hasher. Every client and server sharing the ring must use the
+// same hasher.
+//
+// Example:
+//
+//	ch := hash.NewWithHasher(150, hash.XXHash)
+func NewWithHasher(virtualNodes int, hasher Hasher) *ConsistentHash {
 	if virtualNodes <= 0 {
 		virtualNodes = DefaultVirtualNodes
 	}
 	return &ConsistentHash{
-		ring:         make(map[uint32]string),
-		nodes:        make(map[string]bool),
+		ring:         make(map[uint64]string),
+		nodes:        make(map[string]int),
 		virtualNodes: virtualNodes,
+		hasher:       hasher,
 	}
 }
 
@@ -87,20 +109,76 @@ func (c *ConsistentHash) AddNode(node string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.nodes[node] {
+	if _, exists := c.nodes[node]; exists {
+		return
+	}
+	c.setWeight(node, 1)
+}
+
+// AddNodeWithWeight adds a physical node with virtualNodes times weight
+// virtual nodes, so that it owns a share of the keys proportional to its
+// weight. Weights below 1 count as 1. If the node already exists, its
+// weight is changed: raising it only moves keys to the node, and lowering
+// it only moves keys away from it.
+//
+// Example:
+//
+//	ch.AddNodeWithWeight("small:8080", 1) // 8GB
+//	ch.AddNodeWithWeight("large:8080", 8) // 64GB, gets 8 times the keys
+//
+// Parameters:
+//   - node: The node identifier (typically "host:port")
+//   - weight: The relative capacity of the node
+func (c *ConsistentHash) AddNodeWithWeight(node string, weight int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setWeight(node, max(weight, 1))
+}
+
+// GetWeight returns the weight of node, or 0 if it isn't in the ring.
+func (c *ConsistentHash) GetWeight(node string) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.nodes[node]
+}
+
+// setWeight adds or removes virtual nodes of node so that it has weight
+// times virtualNodes of them, removing the node if weight is 0. Virtual
+// node i of a node is always at the same position, so only the added or
+// removed virtual nodes change owner. Callers must hold c.mu.
+func (c *ConsistentHash) setWeight(node string, weight int) {
+	current := c.nodes[node]
+	c.totalWeight += weight - current
+	if weight == 0 {
+		delete(c.nodes, node)
+	} else {
+		c.nodes[node] = weight
+	}
+
+	if weight > current {
+		for i := current * c.virtualNodes; i < weight*c.virtualNodes; i++ {
+			hash := c.hashKey(fmt.Sprintf("%s:%d", node, i))
+			c.ring[hash] = node
+			c.sortedHashes = append(c.sortedHashes, hash)
+		}
+		sort.Slice(c.sortedHashes, func(i, j int) bool {
+			return c.sortedHashes[i] < c.sortedHashes[j]
+		})
 		return
 	}
 
-	c.nodes[node] = true
-	for i := 0; i < c.virtualNodes; i++ {
-		virtualKey := fmt.Sprintf("%s:%d", node, i)
-		hash := c.hashKey(virtualKey)
-		c.ring[hash] = node
-		c.sortedHashes = append(c.sortedHashes, hash)
+	for i := weight * c.virtualNodes; i < current*c.virtualNodes; i++ {
+		delete(c.ring, c.hashKey(fmt.Sprintf("%s:%d", node, i)))
 	}
-	sort.Slice(c.sortedHashes, func(i, j int) bool {
-		return c.sortedHashes[i] < c.sortedHashes[j]
-	})
+	var newSortedHashes []uint64
+	for _, hash := range c.sortedHashes {
+		if _, exists := c.ring[hash]; exists {
+			newSortedHashes = append(newSortedHashes, hash)
+		}
+	}
+	c.sortedHashes = newSortedHashes
 }
 
 // RemoveNode removes a physical node from the consistent hash ring.
@@ -120,24 +198,10 @@ func (c *ConsistentHash) RemoveNode(node string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if !c.nodes[node] {
+	if _, exists := c.nodes[node]; !exists {
 		return
 	}
-
-	delete(c.nodes, node)
-	for i := 0; i < c.virtualNodes; i++ {
-		virtualKey := fmt.Sprintf("%s:%d", node, i)
-		hash := c.hashKey(virtualKey)
-		delete(c.ring, hash)
-	}
-
-	var newSortedHashes []uint32
-	for _, hash := range c.sortedHashes {
-		if _, exists := c.ring[hash]; exists {
-			newSortedHashes = append(newSortedHashes, hash)
-		}
-	}
-	c.sortedHashes = newSortedHashes
+	c.setWeight(node, 0)
 }
 
 // GetNode returns the node responsible for the given key.
@@ -145,7 +209,9 @@ func (c *ConsistentHash) RemoveNode(node string) {
 // Returns an empty string if no nodes are available.
 //
 // The same key will always return the same node unless the ring topology
-// changes (nodes added/removed).
+// changes (nodes added/removed). Keys with a hash tag are placed by their
+// tag only (see HashTag), so "user:{42}:profile" and "user:{42}:cart" map
+// to the same node.
 //
 // Example:
 //
@@ -168,11 +234,51 @@ func (c *ConsistentHash) GetNode(key string) string {
 		return ""
 	}
 
-	hash := c.hashKey(key)
+	hash := c.hashKey(HashTag(key))
 	idx := c.search(hash)
 	return c.ring[c.sortedHashes[idx]]
 }
 
+// GetNodesForKey returns up to n distinct physical nodes for the given key:
+// the node returned by GetNode, followed by the next distinct nodes found
+// walking clockwise around the ring. It is used to place replicas of a
+// key, so that losing one node doesn't lose the key. Fewer than n nodes are
+// returned if the ring has fewer. It isn't named GetNodes, which already
+// returns every node of the ring.
+//
+// Example:
+//
+//	replicas := ch.GetNodesForKey("user:123", 3)
+//	// replicas[0] == ch.GetNode("user:123")
+//
+// Parameters:
+//   - key: The key to hash and locate
+//   - n: The number of nodes wanted
+//
+// Returns:
+//   - Distinct node identifiers in preference order, or nil if no nodes
+func (c *ConsistentHash) GetNodesForKey(key string, n int) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.ring) == 0 || n <= 0 {
+		return nil
+	}
+	n = min(n, len(c.nodes))
+
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	idx := c.search(c.hashKey(HashTag(key)))
+	for i := 0; i < len(c.sortedHashes) && len(nodes) < n; i++ {
+		node := c.ring[c.sortedHashes[(idx+i)%len(c.sortedHashes)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
 // GetNodes returns a slice of all active nodes in the hash ring.
 // The order is not guaranteed.
 //
@@ -197,7 +303,7 @@ func (c *ConsistentHash) GetNodes() []string {
 // search performs binary search to find the first hash >= the given hash.
 // If no such hash exists, it wraps around to the first hash (index 0).
 // This implements the circular nature of the hash ring.
-func (c *ConsistentHash) search(hash uint32) int {
+func (c *ConsistentHash) search(hash uint64) int {
 	idx := sort.Search(len(c.sortedHashes), func(i int) bool {
 		return c.sortedHashes[i] >= hash
 	})
@@ -207,12 +313,9 @@ func (c *ConsistentHash) search(hash uint32) int {
 	return idx
 }
 
-// hashKey computes a 32-bit hash of the given key using SHA-256.
-// Only the first 4 bytes of the SHA-256 hash are used to create
-// a 32-bit hash value for ring positioning.
-func (c *ConsistentHash) hashKey(key string) uint32 {
-	h := sha256.Sum256([]byte(key))
-	return uint32(h[0])<<24 | uint32(h[1])<<16 | uint32(h[2])<<8 | uint32(h[3])
+// hashKey computes the ring position of the given key.
+func (c *ConsistentHash) hashKey(key string) uint64 {
+	return c.hasher.Sum64(key)
 }
 
 // Stats returns statistics about the current state of the hash ring.
@@ -223,19 +326,51 @@ func (c *ConsistentHash) hashKey(key string) uint32 {
 //	stats := ch.Stats()
 //	fmt.Printf("Nodes: %d, Virtual nodes: %d\n",
 //		stats["nodes"], stats["virtual_nodes"])
+//	for node, pct := range stats["ownership"].(map[string]float64) {
+//		fmt.Printf("%s owns %.1f%% of the keys\n", node, pct)
+//	}
 //
 // Returns:
 //   - Map containing statistics:
 //   - "nodes": number of physical nodes
 //   - "virtual_nodes": total number of virtual nodes
 //   - "ring_size": size of the sorted hash array
+//   - "weights": map[string]int of node weights
+//   - "ownership": map[string]float64 of the percentage of the ring owned by each node
 func (c *ConsistentHash) Stats() map[string]interface{} {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
+	weights := make(map[string]int, len(c.nodes))
+	for node, weight := range c.nodes {
+		weights[node] = weight
+	}
+
 	return map[string]interface{}{
 		"nodes":         len(c.nodes),
 		"virtual_nodes": len(c.ring),
 		"ring_size":     len(c.sortedHashes),
+		"weights":       weights,
+		"ownership":     c.ownership(),
+	}
+}
+
+// ownership returns the percentage of the ring owned by each node: the
+// arcs that end at its virtual nodes, where the keys they own hash to.
+// Callers must hold c.mu.
+func (c *ConsistentHash) ownership() map[string]float64 {
+	ownership := make(map[string]float64, len(c.nodes))
+	if len(c.sortedHashes) == 1 {
+		ownership[c.ring[c.sortedHashes[0]]] = 100
+		return ownership
+	}
+
+	const ringSize = float64(1<<63) * 2
+	for i, hash := range c.sortedHashes {
+		// The first arc wraps around from the last position; uint64
+		// subtraction wraps the same way.
+		prev := c.sortedHashes[(i+len(c.sortedHashes)-1)%len(c.sortedHashes)]
+		ownership[c.ring[hash]] += float64(hash-prev) / ringSize * 100
 	}
+	return ownership
 }
@@ -2,6 +2,7 @@ package hash
 
 import (
 	"fmt"
+	"slices"
 	"testing"
 )
 
@@ -66,3 +67,348 @@ func TestConsistentHashDistribution(t *testing.T) {
 		}
 	}
 }
+
+func TestConsistentHashGetNodesForKey(t *testing.T) {
+	ch := New(150)
+
+	if nodes := ch.GetNodesForKey("key", 2); nodes != nil {
+		t.Errorf("Expected no nodes on an empty ring, got %v", nodes)
+	}
+
+	for _, node := range []string{"node1:8080", "node2:8080", "node3:8080", "node4:8080"} {
+		ch.AddNode(node)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		nodes := ch.GetNodesForKey(key, 3)
+		if len(nodes) != 3 {
+			t.Fatalf("Expected 3 nodes for %s, got %v", key, nodes)
+		}
+		if nodes[0] != ch.GetNode(key) {
+			t.Errorf("First node for %s is %s, GetNode returned %s", key, nodes[0], ch.GetNode(key))
+		}
+		if nodes[0] == nodes[1] || nodes[0] == nodes[2] || nodes[1] == nodes[2] {
+			t.Errorf("Nodes for %s are not distinct: %v", key, nodes)
+		}
+	}
+
+	if nodes := ch.GetNodesForKey("key", 10); len(nodes) != 4 {
+		t.Errorf("Expected all 4 nodes when asking for 10, got %v", nodes)
+	}
+
+	// Removing a node other than the first keeps the key's owner.
+	nodes := ch.GetNodesForKey("key", 2)
+	ch.RemoveNode(nodes[1])
+	if after := ch.GetNodesForKey("key", 2); after[0] != nodes[0] || after[1] == nodes[1] {
+		t.Errorf("Expected %s to stay first and %s to be replaced, got %v", nodes[0], nodes[1], after)
+	}
+}
+
+func TestHashers(t *testing.T) {
+	tests := []struct {
+		hasher Hasher
+		input  string
+		want   uint64
+	}{
+		{XXHash, "", 0xef46db3751d8e999},
+		{XXHash, "abc", 0x44bc2cf5ad770999},
+		{XXHash, "The quick brown fox jumps over the lazy dog", 0x0b242d361fda71bc},
+		{Murmur3, "", 0},
+		{Murmur3, "hello", 0xcbd8a7b341bd9b02},
+		{Murmur3, "The quick brown fox jumps over the lazy dog", 0xe34bbc7bbc071b6c},
+		{FNV, "", fmix64(0xcbf29ce484222325)},
+		{FNV, "a", fmix64(0xaf63dc4c8601ec8c)},
+		{SHA256, "abc", 0xba7816bf8f01cfea},
+	}
+
+	for _, tt := range tests {
+		if got := tt.hasher.Sum64(tt.input); got != tt.want {
+			t.Errorf("%T.Sum64(%q) = %#x, want %#x", tt.hasher, tt.input, got, tt.want)
+		}
+	}
+
+	if _, err := NewHasher("md5"); err == nil {
+		t.Error("Expected an error for an unknown hash function")
+	}
+}
+
+// placements are the ring configurations exercised by the tests and
+// benchmarks below.
+var placements = []RingConfig{
+	{Placement: PlacementConsistent, Hash: HashSHA256},
+	{Placement: PlacementConsistent, Hash: HashXXHash},
+	{Placement: PlacementJump, Hash: HashXXHash},
+	{Placement: PlacementRendezvous, Hash: HashXXHash},
+	{Placement: PlacementMaglev, Hash: HashMurmur3},
+	{Placement: PlacementBounded, Hash: HashFNV},
+}
+
+func newTestRing(t testing.TB, cfg RingConfig, nodes int) Ring {
+	ring, err := NewRing(cfg)
+	if err != nil {
+		t.Fatalf("NewRing(%+v): %v", cfg, err)
+	}
+	for i := 0; i < nodes; i++ {
+		ring.AddNode(fmt.Sprintf("node%d:8080", i+1))
+	}
+	return ring
+}
+
+func TestRings(t *testing.T) {
+	for _, cfg := range placements {
+		t.Run(cfg.Placement+"/"+cfg.Hash, func(t *testing.T) {
+			ring := newTestRing(t, cfg, 4)
+
+			distribution := make(map[string]int)
+			owners := make(map[string]string)
+			for i := 0; i < 4000; i++ {
+				key := fmt.Sprintf("key_%d", i)
+				node := ring.GetNode(key)
+				if node != ring.GetNode(key) {
+					t.Fatalf("GetNode should be consistent for %s", key)
+				}
+				distribution[node]++
+				owners[key] = node
+
+				nodes := ring.GetNodesForKey(key, 3)
+				if len(nodes) != 3 || nodes[0] != node {
+					t.Fatalf("Expected 3 nodes starting with %s for %s, got %v", node, key, nodes)
+				}
+				if nodes[0] == nodes[1] || nodes[0] == nodes[2] || nodes[1] == nodes[2] {
+					t.Fatalf("Nodes for %s are not distinct: %v", key, nodes)
+				}
+			}
+			for node, count := range distribution {
+				if count < 700 || count > 1300 {
+					t.Errorf("Poor distribution for node %s: %d keys", node, count)
+				}
+			}
+
+			// Jump only moves the keys of the node removed if it was added last.
+			removed := "node4:8080"
+			ring.RemoveNode(removed)
+			if len(ring.GetNodes()) != 3 {
+				t.Fatalf("Expected 3 nodes after removal, got %v", ring.GetNodes())
+			}
+			moved := 0
+			for key, owner := range owners {
+				node := ring.GetNode(key)
+				if node == removed {
+					t.Fatalf("Removed node returned for %s", key)
+				}
+				if owner != removed && node != owner {
+					moved++
+				}
+			}
+			if moved > len(owners)/100 {
+				t.Errorf("%d keys of the remaining nodes moved", moved)
+			}
+		})
+	}
+}
+
+func TestWeightedNodes(t *testing.T) {
+	for _, cfg := range placements {
+		t.Run(cfg.Placement+"/"+cfg.Hash, func(t *testing.T) {
+			ring := newTestRing(t, cfg, 0)
+			// Jump only keeps the other nodes' keys in place when the
+			// buckets removed are the last ones.
+			ring.AddNode("small1:8080")
+			ring.AddNode("small2:8080")
+			ring.AddNodeWithWeight("large:8080", 3)
+			if w := ring.GetWeight("large:8080"); w != 3 {
+				t.Fatalf("Expected weight 3, got %d", w)
+			}
+
+			owners := make(map[string]string)
+			distribution := make(map[string]int)
+			for i := 0; i < 5000; i++ {
+				key := fmt.Sprintf("key_%d", i)
+				owners[key] = ring.GetNode(key)
+				distribution[owners[key]]++
+			}
+			if large := distribution["large:8080"]; large < 2600 || large > 3400 {
+				t.Errorf("Expected about 3000 keys on the large node, got %v", distribution)
+			}
+
+			// Lowering a weight only moves keys away from the node.
+			ring.AddNodeWithWeight("large:8080", 1)
+			for key, owner := range owners {
+				if node := ring.GetNode(key); node != owner && owner != "large:8080" {
+					t.Fatalf("%s moved from %s to %s", key, owner, node)
+				}
+			}
+		})
+	}
+}
+
+func TestConsistentHashOwnership(t *testing.T) {
+	ch := New(150)
+	ch.AddNode("node1:8080")
+	if ownership := ch.Stats()["ownership"].(map[string]float64); ownership["node1:8080"] != 100 {
+		t.Errorf("Expected a single node to own the whole ring, got %v", ownership)
+	}
+
+	ch.AddNodeWithWeight("node2:8080", 3)
+	stats := ch.Stats()
+	ownership := stats["ownership"].(map[string]float64)
+	if total := ownership["node1:8080"] + ownership["node2:8080"]; total < 99.99 || total > 100.01 {
+		t.Errorf("Expected ownership to add up to 100%%, got %v", ownership)
+	}
+	if ownership["node2:8080"] < 65 || ownership["node2:8080"] > 85 {
+		t.Errorf("Expected node2 to own about 75%% of the ring, got %v", ownership)
+	}
+	if stats["virtual_nodes"] != 600 || stats["weights"].(map[string]int)["node2:8080"] != 3 {
+		t.Errorf("Unexpected stats: %v", stats)
+	}
+
+	ch.RemoveNode("node2:8080")
+	if stats := ch.Stats(); stats["virtual_nodes"] != 150 {
+		t.Errorf("Expected 150 virtual nodes after removal, got %v", stats["virtual_nodes"])
+	}
+}
+
+func TestHashTag(t *testing.T) {
+	tests := map[string]string{
+		"user:{42}:profile": "42",
+		"{user42}":          "user42",
+		"user:42":           "user:42",
+		"user:{}:42":        "user:{}:42",
+		"user:{42":          "user:{42",
+		"user:}42{":         "user:}42{",
+		"{a}{b}":            "a",
+		"{{a}}":             "{a",
+	}
+	for key, want := range tests {
+		if got := HashTag(key); got != want {
+			t.Errorf("HashTag(%q) = %q, want %q", key, got, want)
+		}
+	}
+
+	for _, cfg := range placements {
+		ring := newTestRing(t, cfg, 10)
+		for i := 0; i < 100; i++ {
+			tag := fmt.Sprintf("{user%d}", i)
+			if ring.GetNode("profile:"+tag) != ring.GetNode("cart:"+tag) {
+				t.Errorf("%s/%s: keys tagged %s map to different nodes", cfg.Placement, cfg.Hash, tag)
+			}
+			if nodes := ring.GetNodesForKey(tag+":a", 3); !slices.Equal(nodes, ring.GetNodesForKey(tag+":b", 3)) {
+				t.Errorf("%s/%s: replicas of keys tagged %s differ", cfg.Placement, cfg.Hash, tag)
+			}
+		}
+	}
+}
+
+func TestBoundedLoad(t *testing.T) {
+	ring := NewBoundedLoad(150, XXHash, 1.25)
+	for _, node := range []string{"node1:8080", "node2:8080", "node3:8080", "node4:8080"} {
+		ring.AddNode(node)
+	}
+
+	owner := ring.GetNode("hot")
+	for i := 0; i < 10; i++ {
+		node := ring.GetNode("hot")
+		ring.Acquire(node)
+	}
+	if ring.GetNode("hot") == owner {
+		t.Errorf("Expected the hot key to spill over from %s", owner)
+	}
+	if load := ring.Stats()["load"]; load != 10 {
+		t.Errorf("Expected a load of 10, got %v", load)
+	}
+
+	for _, node := range ring.GetNodes() {
+		for i := 0; i < 10; i++ {
+			ring.Release(node)
+		}
+	}
+	if ring.GetNode("hot") != owner {
+		t.Errorf("Expected the hot key back on %s once released", owner)
+	}
+}
+
+func TestNewRingErrors(t *testing.T) {
+	for _, cfg := range []RingConfig{
+		{Placement: "random"},
+		{Hash: "crc32"},
+		{Placement: PlacementBounded, LoadFactor: 0.5},
+	} {
+		if _, err := NewRing(cfg); err == nil {
+			t.Errorf("Expected an error for %+v", cfg)
+		}
+	}
+}
+
+func BenchmarkHashers(b *testing.B) {
+	for _, name := range []string{HashSHA256, HashXXHash, HashFNV, HashMurmur3} {
+		hasher, _ := NewHasher(name)
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				hasher.Sum64("user:1234567890:profile")
+			}
+		})
+	}
+}
+
+func BenchmarkGetNode(b *testing.B) {
+	for _, cfg := range placements {
+		ring := newTestRing(b, cfg, 10)
+		b.Run(cfg.Placement+"/"+cfg.Hash, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ring.GetNode("user:1234567890:profile")
+			}
+		})
+	}
+}
+
+// BenchmarkDistribution reports how evenly 100000 keys spread over 10
+// nodes, as the share of the most loaded node relative to a perfect split.
+func BenchmarkDistribution(b *testing.B) {
+	const keys, nodes = 100000, 10
+	for _, cfg := range placements {
+		b.Run(cfg.Placement+"/"+cfg.Hash, func(b *testing.B) {
+			var peak float64
+			for i := 0; i < b.N; i++ {
+				ring := newTestRing(b, cfg, nodes)
+				distribution := make(map[string]int)
+				for k := 0; k < keys; k++ {
+					distribution[ring.GetNode(fmt.Sprintf("key_%d", k))]++
+				}
+				counts := make([]int, 0, nodes)
+				for _, count := range distribution {
+					counts = append(counts, count)
+				}
+				peak = float64(slices.Max(counts)) / (keys / nodes)
+			}
+			b.ReportMetric(peak, "max/avg")
+		})
+	}
+}
+
+// BenchmarkRemap reports the share of 100000 keys that change node when an
+// eleventh node joins 10, where 1/11 (0.091) is the minimum.
+func BenchmarkRemap(b *testing.B) {
+	const keys, nodes = 100000, 10
+	for _, cfg := range placements {
+		b.Run(cfg.Placement+"/"+cfg.Hash, func(b *testing.B) {
+			var remapped float64
+			for i := 0; i < b.N; i++ {
+				ring := newTestRing(b, cfg, nodes)
+				before := make([]string, keys)
+				for k := range before {
+					before[k] = ring.GetNode(fmt.Sprintf("key_%d", k))
+				}
+				ring.AddNode(fmt.Sprintf("node%d:8080", nodes+1))
+				moved := 0
+				for k := range before {
+					if ring.GetNode(fmt.Sprintf("key_%d", k)) != before[k] {
+						moved++
+					}
+				}
+				remapped = float64(moved) / keys
+			}
+			b.ReportMetric(remapped, "remapped")
+		})
+	}
+}
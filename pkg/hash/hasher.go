@@ -0,0 +1,100 @@
+package hash
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// Hasher computes the 64-bit hashes used to place keys and nodes.
+// Implementations must be deterministic across processes and platforms,
+// since every client and server has to agree on where keys live.
+type Hasher interface {
+	Sum64(key string) uint64
+}
+
+// Hash function names accepted by NewHasher.
+const (
+	HashSHA256  = "sha256"
+	HashXXHash  = "xxhash"
+	HashFNV     = "fnv"
+	HashMurmur3 = "murmur3"
+)
+
+// Available hashers. SHA256 is the default, for compatibility with rings
+// built by earlier versions; the others are several times faster.
+var (
+	SHA256  Hasher = sha256Hasher{}  // First 8 bytes of SHA-256
+	XXHash  Hasher = xxHasher{}      // XXH64 with seed 0
+	FNV     Hasher = fnvHasher{}     // 64-bit FNV-1a, finalized
+	Murmur3 Hasher = murmur3Hasher{} // First half of MurmurHash3 x64_128 with seed 0
+)
+
+// NewHasher returns the hasher with the given name: "sha256" (or "" for
+// the default), "xxhash", "fnv" or "murmur3".
+func NewHasher(name string) (Hasher, error) {
+	switch name {
+	case "", HashSHA256:
+		return SHA256, nil
+	case HashXXHash:
+		return XXHash, nil
+	case HashFNV:
+		return FNV, nil
+	case HashMurmur3:
+		return Murmur3, nil
+	default:
+		return nil, fmt.Errorf("unknown hash function: %s", name)
+	}
+}
+
+type sha256Hasher struct{}
+
+// Sum64 returns the first 8 bytes of the SHA-256 of key, big-endian, so
+// that positions order as the 32-bit positions of earlier versions did.
+func (sha256Hasher) Sum64(key string) uint64 {
+	h := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(h[:8])
+}
+
+// FNV-1a parameters.
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+type fnvHasher struct{}
+
+// Sum64 returns the 64-bit FNV-1a hash of key, as hash/fnv.New64a computes
+// it, passed through fmix64. The high bits of FNV-1a barely change between
+// keys that differ in their last bytes, such as "node1:8080:0" and
+// "node1:8080:1", which would otherwise bunch them up on the ring.
+func (fnvHasher) Sum64(key string) uint64 {
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= fnvPrime64
+	}
+	return fmix64(h)
+}
+
+// readUint64 returns the little-endian uint64 at s[i:].
+func readUint64(s string, i int) uint64 {
+	return uint64(s[i]) | uint64(s[i+1])<<8 | uint64(s[i+2])<<16 | uint64(s[i+3])<<24 |
+		uint64(s[i+4])<<32 | uint64(s[i+5])<<40 | uint64(s[i+6])<<48 | uint64(s[i+7])<<56
+}
+
+// readUint32 returns the little-endian uint32 at s[i:].
+func readUint32(s string, i int) uint64 {
+	return uint64(s[i]) | uint64(s[i+1])<<8 | uint64(s[i+2])<<16 | uint64(s[i+3])<<24
+}
+
+// fmix64 is the MurmurHash3 finalizer, which makes every bit of the result
+// depend on every bit of k.
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
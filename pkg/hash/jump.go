@@ -0,0 +1,160 @@
+package hash
+
+import (
+	"slices"
+	"sync"
+)
+
+// jumpMultiplier is the linear congruential generator multiplier of jump
+// consistent hashing.
+const jumpMultiplier = 2862933555777941757
+
+// jumpScale is 2^31, the range of the random numbers drawn by each jump.
+const jumpScale = float64(1 << 31)
+
+// Jump implements jump consistent hashing (Lamping and Veach, 2014): keys
+// are mapped to one of n numbered buckets with no memory and near perfect
+// balance, and adding a bucket moves only the keys it takes over. A node
+// gets one bucket per unit of weight.
+//
+// Buckets are numbered in the order nodes were added, so every client must
+// add the same nodes in the same order. Removing the node added last moves
+// only its keys; removing another node renumbers the buckets added after
+// it, which moves most of their keys.
+type Jump struct {
+	mu      sync.RWMutex
+	buckets []string // Node of each bucket, in bucket order
+	hasher  Hasher
+}
+
+// NewJump creates an empty Jump ring that hashes keys with hasher.
+func NewJump(hasher Hasher) *Jump {
+	return &Jump{hasher: hasher}
+}
+
+// AddNode adds node as the last bucket.
+func (j *Jump) AddNode(node string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !slices.Contains(j.buckets, node) {
+		j.buckets = append(j.buckets, node)
+	}
+}
+
+// AddNodeWithWeight gives node weight buckets. Buckets are added at the
+// end, and removed from the end of the node's buckets, so that raising the
+// weight of the node added last only moves keys to it.
+func (j *Jump) AddNodeWithWeight(node string, weight int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	weight = max(weight, 1)
+	current := j.weight(node)
+	for ; current < weight; current++ {
+		j.buckets = append(j.buckets, node)
+	}
+	for i := len(j.buckets) - 1; i >= 0 && current > weight; i-- {
+		if j.buckets[i] == node {
+			j.buckets = slices.Delete(j.buckets, i, i+1)
+			current--
+		}
+	}
+}
+
+// GetWeight returns the number of buckets of node.
+func (j *Jump) GetWeight(node string) int {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return j.weight(node)
+}
+
+// weight counts the buckets of node. Callers must hold j.mu.
+func (j *Jump) weight(node string) int {
+	weight := 0
+	for _, bucket := range j.buckets {
+		if bucket == node {
+			weight++
+		}
+	}
+	return weight
+}
+
+// RemoveNode removes the buckets of node, renumbering the buckets after
+// them.
+func (j *Jump) RemoveNode(node string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.buckets = slices.DeleteFunc(j.buckets, func(bucket string) bool { return bucket == node })
+}
+
+// GetNode returns the node of the bucket key jumps to.
+func (j *Jump) GetNode(key string) string {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[jumpBucket(j.hasher.Sum64(HashTag(key)), len(j.buckets))]
+}
+
+// GetNodesForKey returns the node of key's bucket followed by the distinct
+// nodes of the next buckets.
+func (j *Jump) GetNodesForKey(key string, n int) []string {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if len(j.buckets) == 0 || n <= 0 {
+		return nil
+	}
+
+	var nodes []string
+	bucket := jumpBucket(j.hasher.Sum64(HashTag(key)), len(j.buckets))
+	for i := 0; i < len(j.buckets) && len(nodes) < n; i++ {
+		if node := j.buckets[(bucket+i)%len(j.buckets)]; !slices.Contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// GetNodes returns the nodes in the order of their first bucket.
+func (j *Jump) GetNodes() []string {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	var nodes []string
+	for _, node := range j.buckets {
+		if !slices.Contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Stats returns the number of "nodes" and "buckets".
+func (j *Jump) Stats() map[string]interface{} {
+	nodes := len(j.GetNodes())
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return map[string]interface{}{
+		"nodes":   nodes,
+		"buckets": len(j.buckets),
+	}
+}
+
+// jumpBucket returns the bucket in [0, buckets) of a key hash.
+func jumpBucket(key uint64, buckets int) int {
+	b, next := -1, 0
+	for next < buckets {
+		b = next
+		key = key*jumpMultiplier + 1
+		next = int(float64(b+1) * (jumpScale / float64((key>>33)+1)))
+	}
+	return b
+}
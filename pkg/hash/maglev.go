@@ -0,0 +1,182 @@
+package hash
+
+import (
+	"slices"
+	"sync"
+)
+
+// maglevTableSize is the size of the Maglev lookup table. It must be prime,
+// and well above the number of nodes for the nodes to get even shares:
+// with 65537 entries, shares differ by less than 1% up to about 650 nodes.
+const maglevTableSize = 65537
+
+// Maglev implements Maglev hashing (Eisenbud et al., 2016): every node
+// fills entries of a lookup table in an order given by its own hash, taking
+// turns, so that each node ends up with an almost equal share of the table.
+// Keys are looked up in the table in constant time. Adding or removing a
+// node moves slightly more than its share of the keys. Weighted nodes take
+// as many entries per turn as their weight.
+//
+// The table is rebuilt on every change, which takes a few milliseconds.
+type Maglev struct {
+	mu      sync.RWMutex
+	nodes   []string // Sorted, so that every process builds the same table
+	weights map[string]int
+	table   []int32 // Entry -> index in nodes
+	hasher  Hasher
+}
+
+// NewMaglev creates an empty Maglev ring that hashes keys and nodes with
+// hasher.
+func NewMaglev(hasher Hasher) *Maglev {
+	return &Maglev{weights: make(map[string]int), hasher: hasher}
+}
+
+// AddNode adds node with weight 1 and rebuilds the table.
+func (m *Maglev) AddNode(node string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.weights[node]; !exists {
+		m.setWeight(node, 1)
+	}
+}
+
+// AddNodeWithWeight adds node with the given weight, or changes its weight,
+// and rebuilds the table.
+func (m *Maglev) AddNodeWithWeight(node string, weight int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.setWeight(node, max(weight, 1))
+}
+
+// GetWeight returns the weight of node.
+func (m *Maglev) GetWeight(node string) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.weights[node]
+}
+
+// setWeight adds node or changes its weight and rebuilds the table.
+// Callers must hold m.mu.
+func (m *Maglev) setWeight(node string, weight int) {
+	if m.weights[node] == weight {
+		return
+	}
+	if i, found := slices.BinarySearch(m.nodes, node); !found {
+		m.nodes = slices.Insert(m.nodes, i, node)
+	}
+	m.weights[node] = weight
+	m.populate()
+}
+
+// RemoveNode removes node and rebuilds the table.
+func (m *Maglev) RemoveNode(node string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, found := slices.BinarySearch(m.nodes, node)
+	if !found {
+		return
+	}
+	m.nodes = slices.Delete(m.nodes, i, i+1)
+	delete(m.weights, node)
+	m.populate()
+}
+
+// GetNode returns the node of key's table entry.
+func (m *Maglev) GetNode(key string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.nodes) == 0 {
+		return ""
+	}
+	return m.nodes[m.table[m.hasher.Sum64(HashTag(key))%maglevTableSize]]
+}
+
+// GetNodesForKey returns the node of key's table entry, followed by the
+// next distinct nodes found in the following entries.
+func (m *Maglev) GetNodesForKey(key string, n int) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.nodes) == 0 || n <= 0 {
+		return nil
+	}
+	n = min(n, len(m.nodes))
+
+	nodes := make([]string, 0, n)
+	seen := make(map[int32]bool, n)
+	entry := m.hasher.Sum64(HashTag(key)) % maglevTableSize
+	for i := uint64(0); i < maglevTableSize && len(nodes) < n; i++ {
+		idx := m.table[(entry+i)%maglevTableSize]
+		if !seen[idx] {
+			seen[idx] = true
+			nodes = append(nodes, m.nodes[idx])
+		}
+	}
+	return nodes
+}
+
+// GetNodes returns the nodes, sorted.
+func (m *Maglev) GetNodes() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return slices.Clone(m.nodes)
+}
+
+// Stats returns the number of "nodes" and the "table_size".
+func (m *Maglev) Stats() map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return map[string]interface{}{
+		"nodes":      len(m.nodes),
+		"table_size": maglevTableSize,
+	}
+}
+
+// populate rebuilds the lookup table. Each node walks the table from its
+// offset by its skip, both derived from its hash, and takes the first free
+// entry, once per unit of weight, on its turn. Callers must hold m.mu.
+func (m *Maglev) populate() {
+	if len(m.nodes) == 0 {
+		m.table = nil
+		return
+	}
+
+	offsets := make([]uint64, len(m.nodes))
+	skips := make([]uint64, len(m.nodes))
+	next := make([]uint64, len(m.nodes))
+	for i, node := range m.nodes {
+		h := m.hasher.Sum64(node)
+		offsets[i] = h % maglevTableSize
+		skips[i] = fmix64(h)%(maglevTableSize-1) + 1
+	}
+
+	table := make([]int32, maglevTableSize)
+	for i := range table {
+		table[i] = -1
+	}
+	for filled := 0; ; {
+		for i, node := range m.nodes {
+			for range m.weights[node] {
+				entry := (offsets[i] + next[i]*skips[i]) % maglevTableSize
+				for table[entry] >= 0 {
+					next[i]++
+					entry = (offsets[i] + next[i]*skips[i]) % maglevTableSize
+				}
+				table[entry] = int32(i) //nolint:gosec // Far fewer nodes than table entries
+				next[i]++
+				if filled++; filled == maglevTableSize {
+					m.table = table
+					return
+				}
+			}
+		}
+	}
+}
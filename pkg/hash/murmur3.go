@@ -0,0 +1,71 @@
+package hash
+
+import "math/bits"
+
+// MurmurHash3 x64_128 constants.
+const (
+	murmurC1 uint64 = 0x87c37b91114253d5
+	murmurC2 uint64 = 0x4cf5ad432745937f
+	murmurN1 uint64 = 0x52dce729
+	murmurN2 uint64 = 0x38495ab5
+)
+
+// murmurBlock is the number of bytes consumed per round.
+const murmurBlock = 16
+
+type murmur3Hasher struct{}
+
+// Sum64 returns the first 64 bits of the MurmurHash3 x64_128 hash of key
+// with seed 0.
+func (murmur3Hasher) Sum64(key string) uint64 {
+	n := len(key)
+	var h1, h2 uint64
+
+	i := 0
+	for ; i+murmurBlock <= n; i += murmurBlock {
+		h1 ^= murmurK1(readUint64(key, i))
+		h1 = bits.RotateLeft64(h1, 27)
+		h1 += h2
+		h1 = h1*5 + murmurN1
+
+		h2 ^= murmurK2(readUint64(key, i+8))
+		h2 = bits.RotateLeft64(h2, 31)
+		h2 += h1
+		h2 = h2*5 + murmurN2
+	}
+
+	tail := key[i:]
+	var k1, k2 uint64
+	for j := len(tail) - 1; j >= 8; j-- {
+		k2 ^= uint64(tail[j]) << ((j - 8) * 8)
+	}
+	if len(tail) > 8 {
+		h2 ^= murmurK2(k2)
+	}
+	for j := min(len(tail), 8) - 1; j >= 0; j-- {
+		k1 ^= uint64(tail[j]) << (j * 8)
+	}
+	if len(tail) > 0 {
+		h1 ^= murmurK1(k1)
+	}
+
+	h1 ^= uint64(n)
+	h2 ^= uint64(n)
+	h1 += h2
+	h2 += h1
+	h1 = fmix64(h1)
+	h2 = fmix64(h2)
+	return h1 + h2
+}
+
+func murmurK1(k uint64) uint64 {
+	k *= murmurC1
+	k = bits.RotateLeft64(k, 31)
+	return k * murmurC2
+}
+
+func murmurK2(k uint64) uint64 {
+	k *= murmurC2
+	k = bits.RotateLeft64(k, 33)
+	return k * murmurC1
+}
@@ -0,0 +1,151 @@
+package hash
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"sync"
+)
+
+// Rendezvous implements rendezvous, or highest random weight, hashing
+// (Thaler and Ravishankar, 1998): each node scores every key, and the key
+// goes to the node with the highest score. Removing a node moves only its
+// keys, adding one moves only the keys it scores highest on, and the
+// following scores give the replicas of a key. Lookups score every node,
+// so they take time proportional to the number of nodes.
+//
+// Weighted nodes use the logarithmic method of Schindelhauer and Schomaker
+// (2005), which gives each node a share of the keys proportional to its
+// weight while keeping those properties.
+type Rendezvous struct {
+	mu     sync.RWMutex
+	nodes  map[string]rendezvousNode
+	hasher Hasher
+}
+
+// rendezvousNode is a node of a Rendezvous ring.
+type rendezvousNode struct {
+	hash   uint64
+	weight int
+}
+
+// NewRendezvous creates an empty Rendezvous ring that hashes keys and nodes
+// with hasher.
+func NewRendezvous(hasher Hasher) *Rendezvous {
+	return &Rendezvous{nodes: make(map[string]rendezvousNode), hasher: hasher}
+}
+
+// AddNode adds node with weight 1.
+func (r *Rendezvous) AddNode(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.nodes[node]; !exists {
+		r.nodes[node] = rendezvousNode{hash: r.hasher.Sum64(node), weight: 1}
+	}
+}
+
+// AddNodeWithWeight adds node with the given weight, or changes its weight.
+func (r *Rendezvous) AddNodeWithWeight(node string, weight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nodes[node] = rendezvousNode{hash: r.hasher.Sum64(node), weight: max(weight, 1)}
+}
+
+// GetWeight returns the weight of node.
+func (r *Rendezvous) GetWeight(node string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.nodes[node].weight
+}
+
+// RemoveNode removes node.
+func (r *Rendezvous) RemoveNode(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.nodes, node)
+}
+
+// GetNode returns the node with the highest score for key. Ties, which
+// are as unlikely as hash collisions, go to the smallest node name.
+func (r *Rendezvous) GetNode(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keyHash := r.hasher.Sum64(HashTag(key))
+	var best string
+	var bestScore float64
+	for node, rn := range r.nodes {
+		score := rendezvousScore(keyHash, rn)
+		if best == "" || score > bestScore || score == bestScore && node < best {
+			best, bestScore = node, score
+		}
+	}
+	return best
+}
+
+// GetNodesForKey returns the n nodes with the highest scores for key, best
+// first.
+func (r *Rendezvous) GetNodesForKey(key string, n int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.nodes) == 0 || n <= 0 {
+		return nil
+	}
+
+	type scored struct {
+		node  string
+		score float64
+	}
+	keyHash := r.hasher.Sum64(HashTag(key))
+	scores := make([]scored, 0, len(r.nodes))
+	for node, rn := range r.nodes {
+		scores = append(scores, scored{node, rendezvousScore(keyHash, rn)})
+	}
+	slices.SortFunc(scores, func(a, b scored) int {
+		if a.score != b.score {
+			return cmp.Compare(b.score, a.score)
+		}
+		return cmp.Compare(a.node, b.node)
+	})
+
+	nodes := make([]string, min(n, len(scores)))
+	for i := range nodes {
+		nodes[i] = scores[i].node
+	}
+	return nodes
+}
+
+// GetNodes returns all nodes.
+func (r *Rendezvous) GetNodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// Stats returns the number of "nodes".
+func (r *Rendezvous) Stats() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return map[string]interface{}{
+		"nodes": len(r.nodes),
+	}
+}
+
+// rendezvousScore returns the score of node n for a key: weight / -ln(u),
+// where u in (0, 1) mixes the hashes of the key and the node. Mixing the
+// two hashes avoids hashing the key once per node.
+func rendezvousScore(keyHash uint64, n rendezvousNode) float64 {
+	u := (float64(fmix64(keyHash^n.hash)>>11) + 0.5) / (1 << 53)
+	return float64(n.weight) / -math.Log(u)
+}
@@ -0,0 +1,119 @@
+package hash
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Ring maps keys to nodes. ConsistentHash is the default implementation;
+// Jump, Rendezvous, Maglev and BoundedLoad trade memory, speed and the
+// share of keys that move on topology changes differently. All of them
+// are safe for concurrent use.
+type Ring interface {
+	// AddNode adds a node with weight 1. Adding a node that exists is a no-op.
+	AddNode(node string)
+	// AddNodeWithWeight adds a node that owns a share of the keys
+	// proportional to weight, or changes the weight of an existing node.
+	AddNodeWithWeight(node string, weight int)
+	// GetWeight returns the weight of a node, or 0 if it isn't in the ring.
+	GetWeight(node string) int
+	// RemoveNode removes a node. Removing a node that doesn't exist is a no-op.
+	RemoveNode(node string)
+	// GetNode returns the node responsible for key, or "" if there are no
+	// nodes. Only the hash tag of key is hashed, see HashTag.
+	GetNode(key string) string
+	// GetNodesForKey returns up to n distinct nodes for key in preference
+	// order, to place replicas on, or nil if there are no nodes. It isn't
+	// named GetNodes, which already returns every node of the ring.
+	GetNodesForKey(key string, n int) []string
+	// GetNodes returns all nodes, in no particular order.
+	GetNodes() []string
+	// Stats returns statistics about the ring, including the number of "nodes".
+	Stats() map[string]interface{}
+}
+
+// HashTag returns the part of key that places it: the hash tag, which is
+// the text between the first "{" and the next "}", if there is one and it
+// isn't empty, or the whole key otherwise. Keys with the same hash tag,
+// such as "user:{42}:profile" and "user:{42}:cart", always map to the same
+// node, so that multi-key commands and transactions can use them together.
+//
+// Example:
+//
+//	hash.HashTag("user:{42}:cart") // "42"
+//	hash.HashTag("user:42:cart")   // "user:42:cart"
+//	hash.HashTag("{}user:42")      // "{}user:42"
+func HashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
+
+// LoadTracker is implemented by rings whose placement depends on the load
+// of the nodes, such as BoundedLoad. Callers acquire the node GetNode
+// returned for as long as they use it, and release it afterwards.
+type LoadTracker interface {
+	Acquire(node string)
+	Release(node string)
+}
+
+// Placement algorithm names accepted by NewRing.
+const (
+	PlacementConsistent = "consistent"
+	PlacementJump       = "jump"
+	PlacementRendezvous = "rendezvous"
+	PlacementMaglev     = "maglev"
+	PlacementBounded    = "bounded"
+)
+
+// DefaultLoadFactor is the default maximum load of a BoundedLoad node,
+// relative to the average load.
+const DefaultLoadFactor = 1.25
+
+// RingConfig selects the placement algorithm and hash function of a ring.
+type RingConfig struct {
+	Placement    string  // "consistent" (or "" for the default), "jump", "rendezvous", "maglev" or "bounded"
+	Hash         string  // Hash function name, see NewHasher
+	VirtualNodes int     // Virtual nodes per node, for "consistent" and "bounded"
+	LoadFactor   float64 // Maximum load relative to the average, for "bounded" (default: 1.25)
+}
+
+// NewRing creates an empty ring as configured.
+//
+// Example:
+//
+//	ring, err := hash.NewRing(hash.RingConfig{Placement: "maglev", Hash: "xxhash"})
+func NewRing(cfg RingConfig) (Ring, error) {
+	hasher, err := NewHasher(cfg.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Placement {
+	case "", PlacementConsistent:
+		return NewWithHasher(cfg.VirtualNodes, hasher), nil
+	case PlacementJump:
+		return NewJump(hasher), nil
+	case PlacementRendezvous:
+		return NewRendezvous(hasher), nil
+	case PlacementMaglev:
+		return NewMaglev(hasher), nil
+	case PlacementBounded:
+		loadFactor := cfg.LoadFactor
+		if loadFactor == 0 {
+			loadFactor = DefaultLoadFactor
+		}
+		if loadFactor <= 1 {
+			return nil, fmt.Errorf("load factor must be above 1: %g", loadFactor)
+		}
+		return NewBoundedLoad(cfg.VirtualNodes, hasher, loadFactor), nil
+	default:
+		return nil, fmt.Errorf("unknown placement: %s", cfg.Placement)
+	}
+}
@@ -0,0 +1,79 @@
+package hash
+
+import "math/bits"
+
+// XXH64 primes.
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// xxStripe is the number of bytes consumed per round by the four lanes.
+const xxStripe = 32
+
+type xxHasher struct{}
+
+// Sum64 returns the XXH64 hash of key with seed 0.
+func (xxHasher) Sum64(key string) uint64 {
+	n := len(key)
+	i := 0
+
+	var h uint64
+	if n >= xxStripe {
+		v1 := xxPrime1
+		v1 += xxPrime2
+		v2 := xxPrime2
+		v3 := uint64(0)
+		v4 := xxPrime1
+		v4 = -v4
+		for ; i+xxStripe <= n; i += xxStripe {
+			v1 = xxRound(v1, readUint64(key, i))
+			v2 = xxRound(v2, readUint64(key, i+8))
+			v3 = xxRound(v3, readUint64(key, i+16))
+			v4 = xxRound(v4, readUint64(key, i+24))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+	h += uint64(n)
+
+	for ; i+8 <= n; i += 8 {
+		h ^= xxRound(0, readUint64(key, i))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if i+4 <= n {
+		h ^= readUint32(key, i) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		i += 4
+	}
+	for ; i < n; i++ {
+		h ^= uint64(key[i]) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
@@ -0,0 +1,501 @@
+// Package lock provides distributed mutexes on top of a CacheMir cluster.
+//
+// A Mutex stores a random token under its key with a TTL, so a lock whose
+// holder crashed is released when the TTL runs out. Only the holder of the
+// token can release or extend the lock: both are done atomically by scripts
+// that compare the stored token first, so a holder whose lock expired and
+// was taken over can't delete its successor's lock.
+//
+// Basic Usage:
+//
+//	c := client.New([]string{"server1:8080", "server2:8080"})
+//	m := lock.New(c, "lock:invoice:42", &lock.Options{TTL: 10 * time.Second, AutoRenew: true})
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//	defer cancel()
+//	if err := m.Lock(ctx); err != nil {
+//		log.Fatal(err)
+//	}
+//	defer m.Unlock()
+//
+//	select {
+//	case <-m.Lost():
+//		// The lock could not be renewed; stop working on the invoice
+//	default:
+//	}
+//
+// With Quorum set, the lock is taken on every node of the ring and is held
+// only if a majority of them granted it before the TTL ran out, following
+// the Redlock algorithm. This keeps the lock safe when a minority of nodes
+// fail or restart.
+//
+// The TTL bounds how long a lock can outlive its holder; it is not a fencing
+// mechanism. A holder paused for longer than the TTL may still believe it
+// holds the lock, so protected resources should check Token where possible.
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cachemir/cachemir/pkg/client"
+)
+
+// Default option values.
+const (
+	DefaultTTL        = 10 * time.Second
+	DefaultMinBackoff = 10 * time.Millisecond
+	DefaultMaxBackoff = 500 * time.Millisecond
+
+	tokenBytes = 16
+
+	// Redlock subtracts the clock drift between nodes from a lock's validity:
+	// 1% of the TTL plus a fixed margin.
+	driftFactor = 100
+	driftMargin = 2 * time.Millisecond
+)
+
+var (
+	// ErrNotAcquired is returned by TryLock when the lock is held by someone
+	// else.
+	ErrNotAcquired = errors.New("lock not acquired")
+
+	// ErrNotHeld is returned when releasing or extending a lock that is not
+	// held, either because it was never acquired or because it expired.
+	ErrNotHeld = errors.New("lock not held")
+)
+
+var (
+	// acquireScript stores the token only if the key doesn't exist.
+	acquireScript = client.NewScript(
+		`return redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2], 'IFVER', 0) and 1 or 0`)
+
+	// releaseScript deletes the key only if it still holds the token.
+	releaseScript = client.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+
+	// extendScript resets the TTL only if the key still holds the token.
+	extendScript = client.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+return 0`)
+)
+
+// Options configures a Mutex. The zero value uses the defaults.
+type Options struct {
+	// TTL is how long the lock is held without renewal (default: 10s). It is
+	// rounded down to whole milliseconds.
+	TTL time.Duration
+
+	// MinBackoff and MaxBackoff bound the randomized exponential backoff
+	// between attempts in Lock (defaults: 10ms and 500ms).
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// AutoRenew extends the lock every TTL/3 while it is held. If renewal
+	// fails until the lock expires, the channel returned by Lost is closed.
+	AutoRenew bool
+
+	// Quorum takes the lock on every node of the ring and requires a
+	// majority, instead of using only the node that owns the key.
+	Quorum bool
+}
+
+// Mutex is a distributed lock on one key. It is safe for concurrent use,
+// but like sync.Mutex it is not reentrant: a Mutex holds at most one lock
+// at a time. Use one Mutex per holder; each acquisition gets a new token.
+type Mutex struct {
+	client *client.Client
+	key    string
+	opts   Options
+
+	mu      sync.Mutex
+	token   string        // Token of the held lock; empty when not held
+	nodes   []string      // Nodes holding the token (quorum mode)
+	lost    chan struct{} // Closed when auto-renewal gives up
+	stop    chan struct{} // Closed to stop auto-renewal
+	renewer sync.WaitGroup
+}
+
+// New creates a Mutex for key. opts may be nil for the defaults.
+//
+// Example:
+//
+//	m := lock.New(c, "lock:reports", nil)
+//	if err := m.TryLock(); errors.Is(err, lock.ErrNotAcquired) {
+//		return // Someone else is generating the reports
+//	}
+//	defer m.Unlock()
+func New(c *client.Client, key string, opts *Options) *Mutex {
+	m := &Mutex{client: c, key: key}
+	if opts != nil {
+		m.opts = *opts
+	}
+	if m.opts.TTL < time.Millisecond {
+		m.opts.TTL = DefaultTTL
+	}
+	if m.opts.MinBackoff <= 0 {
+		m.opts.MinBackoff = DefaultMinBackoff
+	}
+	if m.opts.MaxBackoff < m.opts.MinBackoff {
+		m.opts.MaxBackoff = max(DefaultMaxBackoff, m.opts.MinBackoff)
+	}
+	return m
+}
+
+// Key returns the key the mutex locks.
+func (m *Mutex) Key() string {
+	return m.key
+}
+
+// Token returns the token of the held lock, or an empty string if the lock
+// is not held. Protected resources can store it to reject stale holders.
+func (m *Mutex) Token() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.token
+}
+
+// Lost returns a channel that is closed when an auto-renewed lock could not
+// be renewed before it expired. It returns nil when the lock is not held.
+func (m *Mutex) Lost() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lost
+}
+
+// TryLock makes one attempt to acquire the lock.
+//
+// Returns:
+//   - ErrNotAcquired if the lock is held by someone else
+//   - Error if the mutex already holds a lock or the cluster failed
+func (m *Mutex) TryLock() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token != "" {
+		return fmt.Errorf("lock %q is already held by this mutex", m.key)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	if m.opts.Quorum {
+		err = m.acquireQuorum(token)
+	} else {
+		err = m.acquireSingle(token)
+	}
+	if err != nil {
+		return err
+	}
+
+	m.token = token
+	m.lost = make(chan struct{})
+	if m.opts.AutoRenew {
+		m.stop = make(chan struct{})
+		m.renewer.Add(1)
+		go m.renew(token, m.stop, m.lost)
+	}
+	return nil
+}
+
+// Lock acquires the lock, retrying with randomized exponential backoff
+// while it is held by someone else, until it succeeds or ctx is done.
+//
+// Returns:
+//   - ctx.Err() if ctx was done before the lock was acquired
+//   - Error if the mutex already holds a lock or the cluster failed
+func (m *Mutex) Lock(ctx context.Context) error {
+	if m.Token() != "" {
+		return fmt.Errorf("lock %q is already held by this mutex", m.key)
+	}
+
+	backoff := m.opts.MinBackoff
+	for {
+		// In quorum mode, nodes that fail while others refuse the lock count
+		// as contention; only a cluster that can't be reached is an error.
+		err := m.TryLock()
+		if !errors.Is(err, ErrNotAcquired) {
+			return err
+		}
+
+		timer := time.NewTimer(jitter(backoff))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, m.opts.MaxBackoff)
+	}
+}
+
+// Unlock releases the lock if this mutex still holds it and stops its
+// renewal.
+//
+// Returns:
+//   - ErrNotHeld if the lock was not held or had expired
+//   - Error if the cluster failed; the lock then expires after its TTL
+func (m *Mutex) Unlock() error {
+	m.mu.Lock()
+	token, nodes := m.token, m.nodes
+	m.token, m.nodes = "", nil
+	m.stopRenewal()
+	m.mu.Unlock()
+	m.renewer.Wait()
+
+	if token == "" {
+		return ErrNotHeld
+	}
+
+	if !m.opts.Quorum {
+		released, err := m.runBool(releaseScript, "", token)
+		if err != nil {
+			return err
+		}
+		if !released {
+			return ErrNotHeld
+		}
+		return nil
+	}
+
+	released := 0
+	var lastErr error
+	for _, node := range nodes {
+		ok, err := m.runBool(releaseScript, node, token)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if ok {
+			released++
+		}
+	}
+	if released >= quorum(len(nodes)) {
+		return nil
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	return ErrNotHeld
+}
+
+// Extend resets the TTL of the held lock. Auto-renewal calls it
+// periodically; call it directly for manual renewal.
+//
+// Returns:
+//   - ErrNotHeld if the lock was not held or had expired
+//   - Error if the cluster failed
+func (m *Mutex) Extend() error {
+	m.mu.Lock()
+	token := m.token
+	m.mu.Unlock()
+
+	if token == "" {
+		return ErrNotHeld
+	}
+	return m.extend(token)
+}
+
+// extend resets the TTL of the lock holding token.
+func (m *Mutex) extend(token string) error {
+	if !m.opts.Quorum {
+		extended, err := m.runBool(extendScript, "", token, m.ttlArg())
+		if err != nil {
+			return err
+		}
+		if !extended {
+			return ErrNotHeld
+		}
+		return nil
+	}
+
+	m.mu.Lock()
+	nodes := m.nodes
+	m.mu.Unlock()
+
+	start := time.Now()
+	extended := 0
+	var lastErr error
+	for _, node := range nodes {
+		ok, err := m.runBool(extendScript, node, token, m.ttlArg())
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if ok {
+			extended++
+		}
+	}
+	if extended >= quorum(len(nodes)) && m.validity(start) > 0 {
+		return nil
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	return ErrNotHeld
+}
+
+// acquireSingle takes the lock on the node that owns the key.
+func (m *Mutex) acquireSingle(token string) error {
+	acquired, err := m.runBool(acquireScript, "", token, m.ttlArg())
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return ErrNotAcquired
+	}
+	return nil
+}
+
+// acquireQuorum takes the lock on every node of the ring and keeps it if a
+// majority granted it with time to spare. Otherwise the partial locks are
+// released right away.
+func (m *Mutex) acquireQuorum(token string) error {
+	nodes := m.client.Nodes()
+	if len(nodes) == 0 {
+		return fmt.Errorf("no available nodes")
+	}
+
+	start := time.Now()
+	var granted []string
+	var lastErr error
+	failed := 0
+	for _, node := range nodes {
+		ok, err := m.runBool(acquireScript, node, token, m.ttlArg())
+		if err != nil {
+			lastErr = err
+			failed++
+			continue
+		}
+		if ok {
+			granted = append(granted, node)
+		}
+	}
+
+	if len(granted) >= quorum(len(nodes)) && m.validity(start) > 0 {
+		m.nodes = granted
+		return nil
+	}
+
+	for _, node := range nodes {
+		if _, err := m.runBool(releaseScript, node, token); err != nil {
+			lastErr = err
+		}
+	}
+	// The lock is contended if any node refused it; errors alone mean the
+	// cluster is unreachable.
+	if failed == len(nodes) {
+		return lastErr
+	}
+	return ErrNotAcquired
+}
+
+// renew extends the lock every TTL/3 until stop is closed. If extending
+// fails until the lock would have expired, it closes lost and gives up.
+func (m *Mutex) renew(token string, stop, lost chan struct{}) {
+	defer m.renewer.Done()
+
+	ticker := time.NewTicker(m.opts.TTL / 3)
+	defer ticker.Stop()
+
+	expires := time.Now().Add(m.opts.TTL)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		err := m.extend(token)
+		if err == nil {
+			expires = start.Add(m.opts.TTL)
+			continue
+		}
+		if errors.Is(err, ErrNotHeld) || time.Now().After(expires) {
+			close(lost)
+			return
+		}
+		// A transient error; retry on the next tick while the lock is valid.
+	}
+}
+
+// stopRenewal stops the renewal goroutine, if any. Callers must hold m.mu.
+func (m *Mutex) stopRenewal() {
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+	m.lost = nil
+}
+
+// runBool runs a lock script that returns 1 or 0, on node or on the owner
+// of the key if node is empty.
+func (m *Mutex) runBool(script *client.Script, node string, args ...string) (bool, error) {
+	result, err := script.RunOnNode(m.client, node, []string{m.key}, args...)
+	if err != nil {
+		return false, err
+	}
+	n, ok := result.(int64)
+	if !ok {
+		return false, fmt.Errorf("unexpected script result %v", result)
+	}
+	return n == 1, nil
+}
+
+// ttlArg returns the TTL in milliseconds as a script argument.
+func (m *Mutex) ttlArg() string {
+	return strconv.FormatInt(m.opts.TTL.Milliseconds(), 10)
+}
+
+// validity returns how much of a lock taken at start is left after
+// accounting for clock drift between nodes.
+func (m *Mutex) validity(start time.Time) time.Duration {
+	drift := m.opts.TTL/driftFactor + driftMargin
+	return m.opts.TTL - time.Since(start) - drift
+}
+
+// quorum returns the majority of n nodes.
+func quorum(n int) int {
+	return n/2 + 1
+}
+
+// newToken returns a random lock token.
+func newToken() (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// jitter returns a random duration between d/2 and d, so that contending
+// clients don't retry in lockstep.
+func jitter(d time.Duration) time.Duration {
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(half))
+	if err != nil {
+		return d
+	}
+	return time.Duration(half + n.Int64())
+}
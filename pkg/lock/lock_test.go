@@ -0,0 +1,365 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cachemir/cachemir/internal/server"
+	"github.com/cachemir/cachemir/pkg/client"
+	"github.com/cachemir/cachemir/pkg/config"
+)
+
+// freeAddr returns a local address that is free at the time of the call,
+// and its port.
+func freeAddr(t *testing.T) (string, int) {
+	t.Helper()
+
+	lc := net.ListenConfig{}
+	l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer l.Close()                      //nolint:errcheck // Only reserved the port
+	port := l.Addr().(*net.TCPAddr).Port //nolint:errcheck // TCP listeners have TCP addresses
+	return fmt.Sprintf("127.0.0.1:%d", port), port
+}
+
+// startServer starts a server on a free local port and returns its address.
+// The server is stopped when the test ends.
+func startServer(t *testing.T) string {
+	t.Helper()
+
+	addr, port := freeAddr(t)
+	s := server.New(port)
+	go func() {
+		if err := s.Start(); err != nil {
+			t.Errorf("Server failed: %v", err)
+		}
+	}()
+	t.Cleanup(func() { s.Stop() }) //nolint:errcheck,gosec // Nothing to do on failure
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close() //nolint:errcheck,gosec // Only probing
+			return addr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Server at %s did not start: %v", addr, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startProxy forwards connections from a free local address to target,
+// returning the address and a function that closes the proxy and every
+// connection through it, as if target became unreachable.
+func startProxy(t *testing.T, target string) (string, func()) {
+	t.Helper()
+
+	lc := net.ListenConfig{}
+	l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	var mu sync.Mutex
+	var conns []net.Conn
+	track := func(c net.Conn) {
+		mu.Lock()
+		conns = append(conns, c)
+		mu.Unlock()
+	}
+	go func() {
+		for {
+			in, err := l.Accept()
+			if err != nil {
+				return
+			}
+			out, err := net.Dial("tcp", target)
+			if err != nil {
+				in.Close() //nolint:errcheck,gosec // Refusing the connection
+				continue
+			}
+			track(in)
+			track(out)
+			go io.Copy(in, out) //nolint:errcheck // Ends when either side closes
+			go io.Copy(out, in) //nolint:errcheck // Ends when either side closes
+		}
+	}()
+
+	cut := func() {
+		l.Close() //nolint:errcheck,gosec // Nothing to do on failure
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range conns {
+			c.Close() //nolint:errcheck,gosec // Nothing to do on failure
+		}
+	}
+	t.Cleanup(cut)
+	return l.Addr().String(), cut
+}
+
+// newClient creates a client for nodes with short timeouts. The client is
+// closed when the test ends.
+func newClient(t *testing.T, nodes ...string) *client.Client {
+	t.Helper()
+
+	cfg := config.LoadClientConfig()
+	cfg.Nodes = nodes
+	cfg.ConnTimeout = 1
+	cfg.ReadTimeout = 2
+	cfg.WriteTimeout = 2
+	cfg.RetryAttempts = 1
+	c := client.NewWithConfig(cfg)
+	t.Cleanup(func() { c.Close() }) //nolint:errcheck,gosec // Nothing to do on failure
+	return c
+}
+
+// waitClosed fails the test if ch is not closed within timeout.
+func waitClosed(t *testing.T, ch <-chan struct{}, timeout time.Duration, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(timeout):
+		t.Fatalf("Timed out waiting for %s", what)
+	}
+}
+
+func TestTryLockIsExclusive(t *testing.T) {
+	addr := startServer(t)
+	c := newClient(t, addr)
+
+	const contenders = 10
+	var wg sync.WaitGroup
+	mutexes := make([]*Mutex, contenders)
+	errs := make([]error, contenders)
+	for i := range mutexes {
+		mutexes[i] = New(c, "lock:exclusive", nil)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = mutexes[i].TryLock()
+		}(i)
+	}
+	wg.Wait()
+
+	var holder *Mutex
+	for i, err := range errs {
+		switch {
+		case err == nil && holder != nil:
+			t.Fatal("Expected a single holder, got several")
+		case err == nil:
+			holder = mutexes[i]
+		case !errors.Is(err, ErrNotAcquired):
+			t.Errorf("Expected ErrNotAcquired, got %v", err)
+		}
+	}
+	if holder == nil {
+		t.Fatal("Expected one contender to acquire the lock")
+	}
+	if stored, err := c.Get("lock:exclusive"); err != nil || stored != holder.Token() {
+		t.Errorf("Expected the holder's token %q to be stored, got %q (%v)", holder.Token(), stored, err)
+	}
+
+	if err := holder.TryLock(); err == nil {
+		t.Error("Expected a mutex not to lock twice")
+	}
+	first := holder.Token()
+	if err := holder.Unlock(); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if err := holder.TryLock(); err != nil {
+		t.Fatalf("Expected to lock again after Unlock, got %v", err)
+	}
+	if holder.Token() == first {
+		t.Error("Expected each acquisition to get a new token")
+	}
+	if err := holder.Unlock(); err != nil {
+		t.Errorf("Unlock failed: %v", err)
+	}
+	if err := holder.Unlock(); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("Expected ErrNotHeld when unlocking twice, got %v", err)
+	}
+}
+
+func TestUnlockAfterExpiryKeepsNewOwner(t *testing.T) {
+	addr := startServer(t)
+	c := newClient(t, addr)
+
+	stale := New(c, "lock:expiry", &Options{TTL: 100 * time.Millisecond})
+	if err := stale.TryLock(); err != nil {
+		t.Fatalf("TryLock failed: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	owner := New(c, "lock:expiry", &Options{TTL: 10 * time.Second})
+	if err := owner.TryLock(); err != nil {
+		t.Fatalf("Expected to take over the expired lock, got %v", err)
+	}
+
+	if err := stale.Extend(); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("Expected ErrNotHeld when extending an expired lock, got %v", err)
+	}
+	if err := stale.Unlock(); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("Expected ErrNotHeld when unlocking an expired lock, got %v", err)
+	}
+	if stored, err := c.Get("lock:expiry"); err != nil || stored != owner.Token() {
+		t.Errorf("Expected the new owner's lock to be kept, got %q (%v)", stored, err)
+	}
+	if err := owner.Unlock(); err != nil {
+		t.Errorf("Unlock failed: %v", err)
+	}
+}
+
+func TestLockHonorsContext(t *testing.T) {
+	addr := startServer(t)
+	c := newClient(t, addr)
+
+	holder := New(c, "lock:ctx", nil)
+	if err := holder.TryLock(); err != nil {
+		t.Fatalf("TryLock failed: %v", err)
+	}
+
+	waiter := New(c, "lock:ctx", &Options{MinBackoff: 5 * time.Millisecond, MaxBackoff: 20 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := waiter.Lock(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Lock returned %v after its deadline", elapsed)
+	}
+
+	// Lock succeeds once the holder releases the lock.
+	time.AfterFunc(50*time.Millisecond, func() { holder.Unlock() }) //nolint:errcheck,gosec // Checked by Lock
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := waiter.Lock(ctx); err != nil {
+		t.Errorf("Expected to acquire the released lock, got %v", err)
+	}
+}
+
+func TestAutoRenewKeepsLock(t *testing.T) {
+	addr := startServer(t)
+	c := newClient(t, addr)
+
+	m := New(c, "lock:renew", &Options{TTL: 150 * time.Millisecond, AutoRenew: true})
+	if err := m.TryLock(); err != nil {
+		t.Fatalf("TryLock failed: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	if err := New(c, "lock:renew", nil).TryLock(); !errors.Is(err, ErrNotAcquired) {
+		t.Errorf("Expected the renewed lock to still be held, got %v", err)
+	}
+	select {
+	case <-m.Lost():
+		t.Error("Expected the lock not to be lost")
+	default:
+	}
+	if err := m.Unlock(); err != nil {
+		t.Errorf("Unlock failed: %v", err)
+	}
+	if m.Lost() != nil {
+		t.Error("Expected Lost to return nil once unlocked")
+	}
+}
+
+func TestLostClosesWhenRenewalFails(t *testing.T) {
+	addr := startServer(t)
+	c := newClient(t, addr)
+
+	// The lock is deleted behind the holder's back.
+	m := New(c, "lock:lost", &Options{TTL: 150 * time.Millisecond, AutoRenew: true})
+	if err := m.TryLock(); err != nil {
+		t.Fatalf("TryLock failed: %v", err)
+	}
+	if _, err := c.Del("lock:lost"); err != nil {
+		t.Fatalf("Del failed: %v", err)
+	}
+	waitClosed(t, m.Lost(), time.Second, "the lock to be lost")
+	if err := m.Unlock(); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("Expected ErrNotHeld, got %v", err)
+	}
+
+	// The node holding the lock becomes unreachable.
+	proxy, cut := startProxy(t, addr)
+	c = newClient(t, proxy)
+	m = New(c, "lock:unreachable", &Options{TTL: 150 * time.Millisecond, AutoRenew: true})
+	if err := m.TryLock(); err != nil {
+		t.Fatalf("TryLock failed: %v", err)
+	}
+	cut()
+	waitClosed(t, m.Lost(), 3*time.Second, "the lock to be lost after the node stopped")
+}
+
+func TestQuorumToleratesMinorityFailure(t *testing.T) {
+	addr1 := startServer(t)
+	addr2 := startServer(t)
+	down, _ := freeAddr(t) // Nothing listens there
+	c := newClient(t, addr1, addr2, down)
+
+	m := New(c, "lock:quorum", &Options{Quorum: true})
+	if err := m.TryLock(); err != nil {
+		t.Fatalf("Expected a majority of nodes to grant the lock, got %v", err)
+	}
+	for _, addr := range []string{addr1, addr2} {
+		other := newClient(t, addr)
+		if stored, err := other.Get("lock:quorum"); err != nil || stored != m.Token() {
+			t.Errorf("Expected the token on %s, got %q (%v)", addr, stored, err)
+		}
+	}
+
+	if err := New(c, "lock:quorum", &Options{Quorum: true}).TryLock(); !errors.Is(err, ErrNotAcquired) {
+		t.Errorf("Expected the quorum lock to be exclusive, got %v", err)
+	}
+	if err := m.Extend(); err != nil {
+		t.Errorf("Expected the majority to extend the lock, got %v", err)
+	}
+	if err := m.Unlock(); err != nil {
+		t.Errorf("Unlock failed: %v", err)
+	}
+}
+
+func TestQuorumFailsWithoutMajority(t *testing.T) {
+	addr := startServer(t)
+	down1, _ := freeAddr(t)
+	down2, _ := freeAddr(t)
+	c := newClient(t, addr, down1, down2)
+
+	m := New(c, "lock:minority", &Options{Quorum: true})
+	if err := m.TryLock(); err == nil {
+		t.Fatal("Expected the lock to fail with a single node of three")
+	}
+
+	// The partial lock was released.
+	if _, err := newClient(t, addr).Get("lock:minority"); err == nil {
+		t.Error("Expected the partial lock to be released")
+	}
+}
+
+func TestLockReturnsClusterErrors(t *testing.T) {
+	down, _ := freeAddr(t) // Nothing listens there
+	c := newClient(t, down)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := New(c, "lock:down", nil).Lock(ctx)
+	if err == nil || errors.Is(err, ErrNotAcquired) || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the cluster error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Lock retried a cluster error for %v", elapsed)
+	}
+}
@@ -34,6 +34,21 @@
 //   - Hash operations: HGET, HSET, HDEL, HGETALL, HEXISTS
 //   - List operations: LPUSH, RPUSH, LPOP, RPOP, LLEN
 //   - Set operations: SADD, SREM, SMEMBERS, SISMEMBER
+//   - Bitmap operations: SETBIT, GETBIT, BITCOUNT, BITPOS, BITOP
+//   - HyperLogLog operations: PFADD, PFCOUNT, PFMERGE
+//   - Stream operations: XADD, XLEN, XRANGE, XREVRANGE, XTRIM, XREAD, XGROUP,
+//     XREADGROUP, XACK, XPENDING, XCLAIM
+//   - Geo operations: GEOADD, GEOPOS, GEODIST, GEOSEARCH
+//   - JSON operations: JSON.SET, JSON.GET, JSON.DEL, JSON.NUMINCRBY, JSON.ARRAPPEND
+//   - Pub/Sub: SUBSCRIBE, UNSUBSCRIBE, PSUBSCRIBE, PUNSUBSCRIBE, PUBLISH,
+//     SSUBSCRIBE, SUNSUBSCRIBE, SPUBLISH
+//   - Transactions: MULTI, EXEC, DISCARD, WATCH, UNWATCH
+//   - Scripting: EVAL, EVALSHA, SCRIPT LOAD, SCRIPT EXISTS, SCRIPT FLUSH
+//   - Versioned values: GETV, SET IFVER
+//   - Rate limiting: GCRA, TOKENBUCKET
+//   - Serialization: DUMP, RESTORE
+//   - Replication: REPLICAOF, ROLE (SYNC and REPLCONF are used between nodes)
+//   - Sentinels: SENTINEL (served by cachemir-sentinel, not cache servers)
 //   - Utility: PING
 package protocol
 
@@ -66,34 +81,105 @@ type CommandType uint8
 // Command type constants define all supported cache operations.
 // These match Redis command semantics for compatibility.
 const (
-	CmdGet       CommandType = iota // GET key - retrieve string value
-	CmdSet                          // SET key value [ttl] - store string value
-	CmdDel                          // DEL key - delete key
-	CmdExists                       // EXISTS key - check if key exists
-	CmdIncr                         // INCR key - increment integer value
-	CmdDecr                         // DECR key - decrement integer value
-	CmdIncrBy                       // INCRBY key delta - increment by delta
-	CmdDecrBy                       // DECRBY key delta - decrement by delta
-	CmdExpire                       // EXPIRE key ttl - set key expiration
-	CmdTTL                          // TTL key - get time to live
-	CmdPersist                      // PERSIST key - remove expiration
-	CmdHGet                         // HGET key field - get hash field
-	CmdHSet                         // HSET key field value - set hash field
-	CmdHDel                         // HDEL key field - delete hash field
-	CmdHGetAll                      // HGETALL key - get all hash fields
-	CmdHExists                      // HEXISTS key field - check hash field exists
-	CmdLPush                        // LPUSH key value... - push to list head
-	CmdRPush                        // RPUSH key value... - push to list tail
-	CmdLPop                         // LPOP key - pop from list head
-	CmdRPop                         // RPOP key - pop from list tail
-	CmdLLen                         // LLEN key - get list length
-	CmdSAdd                         // SADD key member... - add to set
-	CmdSRem                         // SREM key member... - remove from set
-	CmdSMembers                     // SMEMBERS key - get all set members
-	CmdSIsMember                    // SISMEMBER key member - check set membership
-	CmdPing                         // PING - connectivity test
+	CmdGet           CommandType = iota // GET key [WITHPTTL] - retrieve string value (and its TTL)
+	CmdSet                              // SET key value [ttl] [IFVER version [VERSION new]] - store string value
+	CmdDel                              // DEL key - delete key
+	CmdExists                           // EXISTS key - check if key exists
+	CmdIncr                             // INCR key - increment integer value
+	CmdDecr                             // DECR key - decrement integer value
+	CmdIncrBy                           // INCRBY key delta - increment by delta
+	CmdDecrBy                           // DECRBY key delta - decrement by delta
+	CmdExpire                           // EXPIRE key ttl - set key expiration
+	CmdTTL                              // TTL key - get time to live
+	CmdPersist                          // PERSIST key - remove expiration
+	CmdHGet                             // HGET key field - get hash field
+	CmdHSet                             // HSET key field value - set hash field
+	CmdHDel                             // HDEL key field - delete hash field
+	CmdHGetAll                          // HGETALL key - get all hash fields
+	CmdHExists                          // HEXISTS key field - check hash field exists
+	CmdLPush                            // LPUSH key value... - push to list head
+	CmdRPush                            // RPUSH key value... - push to list tail
+	CmdLPop                             // LPOP key - pop from list head
+	CmdRPop                             // RPOP key - pop from list tail
+	CmdLLen                             // LLEN key - get list length
+	CmdSAdd                             // SADD key member... - add to set
+	CmdSRem                             // SREM key member... - remove from set
+	CmdSMembers                         // SMEMBERS key - get all set members
+	CmdSIsMember                        // SISMEMBER key member - check set membership
+	CmdPing                             // PING - connectivity test
+	CmdSetBit                           // SETBIT key offset value - set or clear a bit
+	CmdGetBit                           // GETBIT key offset - get a bit
+	CmdBitCount                         // BITCOUNT key [start end] - count set bits
+	CmdBitPos                           // BITPOS key bit [start [end]] - find first bit
+	CmdBitOp                            // BITOP destkey op srckey... - bitwise operation
+	CmdPFAdd                            // PFADD key element... - add to HyperLogLog
+	CmdPFCount                          // PFCOUNT key [key...] - estimate cardinality
+	CmdPFMerge                          // PFMERGE destkey srckey... - merge HyperLogLogs
+	CmdPFExport                         // PFEXPORT key - serialized HyperLogLog (cross-node merges)
+	CmdPFImport                         // PFIMPORT key data - merge serialized HyperLogLog
+	CmdXAdd                             // XADD key [MAXLEN|MINID [=|~] n] id field value... - append entry
+	CmdXLen                             // XLEN key - number of stream entries
+	CmdXRange                           // XRANGE key start end [COUNT n] - entries in ID order
+	CmdXRevRange                        // XREVRANGE key end start [COUNT n] - entries in reverse order
+	CmdXTrim                            // XTRIM key MAXLEN|MINID [=|~] threshold - trim stream
+	CmdXRead                            // XREAD [COUNT n] [BLOCK ms] STREAMS key... id... (Key routes only)
+	CmdXGroup                           // XGROUP CREATE|DESTROY key group [id [MKSTREAM]] - manage groups
+	CmdXReadGroup                       // XREADGROUP GROUP g c [COUNT n] [BLOCK ms] [NOACK] STREAMS key... id...
+	CmdXAck                             // XACK key group id... - acknowledge entries
+	CmdXPending                         // XPENDING key group [start end count [consumer]] - pending entries
+	CmdXClaim                           // XCLAIM key group consumer min-idle-ms id... [JUSTID] - claim entries
+	CmdGeoAdd                           // GEOADD key [NX|XX] [CH] lon lat member... - add locations
+	CmdGeoPos                           // GEOPOS key member... - coordinates of members
+	CmdGeoDist                          // GEODIST key member1 member2 [m|km|mi|ft] - distance between members
+	CmdGeoSearch                        // GEOSEARCH key FROMMEMBER|FROMLONLAT ... BYRADIUS|BYBOX ... [options] - search area
+	CmdJSONSet                          // JSON.SET key path value [NX|XX] - set JSON value at path
+	CmdJSONGet                          // JSON.GET key [path...] - get JSON values
+	CmdJSONDel                          // JSON.DEL key [path] - delete JSON values
+	CmdJSONNumIncrBy                    // JSON.NUMINCRBY key path delta - increment numbers
+	CmdJSONArrAppend                    // JSON.ARRAPPEND key path value... - append to arrays
+	CmdSubscribe                        // SUBSCRIBE channel... - enter subscriber mode
+	CmdUnsubscribe                      // UNSUBSCRIBE [channel...] - leave channels (all if none given)
+	CmdPSubscribe                       // PSUBSCRIBE pattern... - subscribe to glob patterns
+	CmdPUnsubscribe                     // PUNSUBSCRIBE [pattern...] - leave patterns (all if none given)
+	CmdPublish                          // PUBLISH channel message - deliver message (Key is the channel)
+	CmdSSubscribe                       // SSUBSCRIBE channel... - subscribe to sharded channels
+	CmdSUnsubscribe                     // SUNSUBSCRIBE [channel...] - leave sharded channels (all if none given)
+	CmdSPublish                         // SPUBLISH channel message - deliver to a sharded channel (Key is the channel)
+	CmdMulti                            // MULTI - start queuing a transaction
+	CmdExec                             // EXEC - run the queued transaction atomically
+	CmdDiscard                          // DISCARD - drop the queued transaction
+	CmdWatch                            // WATCH key... - abort the next EXEC if keys change (Args are the keys)
+	CmdUnwatch                          // UNWATCH - forget watched keys
+	CmdEval                             // EVAL script numkeys key... arg... - run a Lua script (Key routes only)
+	CmdEvalSha                          // EVALSHA sha1 numkeys key... arg... - run a cached script by digest
+	CmdScript                           // SCRIPT LOAD script | EXISTS sha1... | FLUSH - manage the script cache
+	CmdGetV                             // GETV key [WITHPTTL] - get string value and its version (and TTL)
+	CmdGCRA                             // GCRA key burst count period_ms [quantity] - GCRA rate limiter
+	CmdTokenBucket                      // TOKENBUCKET key capacity refill period_ms [tokens] - token bucket
+	CmdDump                             // DUMP key - serialize a value for RESTORE
+	CmdRestore                          // RESTORE key ttl_ms payload [REPLACE] - create a key from DUMP output
+	CmdReplicaOf                        // REPLICAOF host port | NO ONE - replicate a primary or become one
+	CmdSync                             // SYNC port - start a replication stream (sent by replicas)
+	CmdReplConf                         // REPLCONF SNAPSHOT n | OFFSET n | ACK n - replication stream control
+	CmdRole                             // ROLE - replication role, offset and lag
+	CmdSentinel                         // SENTINEL PRIMARIES | IS-DOWN name addr epoch candidate - sentinel queries
+	CmdKeys                             // KEYS pattern - list the keys matching a glob pattern
+	CmdMigrate                          // MIGRATE host port timeout_ms [COPY] [REPLACE] [KEYS key...] - move keys to a node
+	CmdCluster                          // CLUSTER NODES | MEET host port | FORGET host port | GOSSIP... - cluster membership
+	CmdScan                             // SCAN cursor [MATCH pattern] [COUNT count] - iterate over the keys
+	CmdHotKeys                          // HOTKEYS [count] - most accessed keys
+	CmdClient                           // CLIENT TRACKING ON | OFF - track the keys read by the connection
 )
 
+// SentinelChannel is the channel on which sentinels publish primary
+// changes, as "name address epoch" messages.
+const SentinelChannel = "__sentinel__:switch"
+
+// InvalidateChannel is the channel on which servers publish the keys read
+// by tracking connections (see CmdClient) when they change, one key per
+// message.
+const InvalidateChannel = "__cachemir__:invalidate"
+
 // ResponseType represents the type of response from the server.
 // Different response types carry different data formats.
 type ResponseType uint8
@@ -106,8 +192,14 @@ const (
 	RespInt                        // Integer data response
 	RespArray                      // Array of strings response
 	RespNil                        // Null/empty response
+	RespNested                     // Nested array of strings, integers, nils and arrays
+	RespPush                       // Out-of-band push message (same encoding as RespNested)
+	RespMulti                      // Complete responses, one per command of a transaction ([]*Response)
 )
 
+// maxNestingDepth bounds the depth of RespNested responses accepted by the decoder.
+const maxNestingDepth = 32
+
 // Command represents a client request to the cache server.
 // It encapsulates the operation type, target key, arguments, and optional TTL.
 //
@@ -288,6 +380,16 @@ func deserializeTTL(data []byte, offset int) (time.Duration, error) {
 //   - RespError/RespString: type + varint length + data bytes
 //   - RespInt: type + varint-encoded signed integer
 //   - RespArray: type + varint count + (varint length + bytes) for each item
+//   - RespNested, RespPush: type + varint count + (type byte + encoded value) for each item
+//
+// RespNested data is a []interface{} whose elements are string, int64, nil,
+// []string or []interface{} values, allowing structured replies such as
+// stream entries to be sent in a single response. RespPush uses the same
+// encoding for messages the server sends without a matching request, such as
+// Pub/Sub deliveries: ["message", channel, payload],
+// ["pmessage", pattern, channel, payload], ["smessage", channel, payload],
+// or subscription confirmations [kind, name, count] where kind is
+// "subscribe", "psubscribe", "ssubscribe" or one of their "un" forms.
 //
 // Example:
 //
@@ -330,11 +432,63 @@ func (r *Response) Serialize() ([]byte, error) {
 		}
 	case RespNil:
 		return buf, nil
+	case RespNested, RespPush:
+		arr, _ := r.Data.([]interface{})
+		return appendNested(buf, arr)
+	case RespMulti:
+		responses, _ := r.Data.([]*Response)
+		buf = binary.AppendUvarint(buf, uint64(len(responses)))
+		for _, item := range responses {
+			if item.Type == RespMulti {
+				return nil, fmt.Errorf("multi responses cannot be nested")
+			}
+			itemBytes, err := item.Serialize()
+			if err != nil {
+				return nil, err
+			}
+			buf = binary.AppendUvarint(buf, uint64(len(itemBytes)))
+			buf = append(buf, itemBytes...)
+		}
 	}
 
 	return buf, nil
 }
 
+// appendNested encodes the elements of a RespNested array.
+func appendNested(buf []byte, arr []interface{}) ([]byte, error) {
+	buf = binary.AppendUvarint(buf, uint64(len(arr)))
+	for _, item := range arr {
+		var err error
+		switch v := item.(type) {
+		case nil:
+			buf = append(buf, byte(RespNil))
+		case string:
+			buf = append(buf, byte(RespString))
+			buf = binary.AppendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		case int64:
+			buf = append(buf, byte(RespInt))
+			buf = binary.AppendVarint(buf, v)
+		case []string:
+			nested := make([]interface{}, len(v))
+			for i, str := range v {
+				nested[i] = str
+			}
+			buf = append(buf, byte(RespNested))
+			buf, err = appendNested(buf, nested)
+		case []interface{}:
+			buf = append(buf, byte(RespNested))
+			buf, err = appendNested(buf, v)
+		default:
+			return nil, fmt.Errorf("unsupported nested value type %T", item)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
 // DeserializeResponse reconstructs a Response from its binary representation.
 // This is the inverse operation of Response.Serialize().
 //
@@ -374,11 +528,53 @@ func DeserializeResponse(data []byte) (*Response, error) {
 		return deserializeIntResponse(resp, data, offset)
 	case RespArray:
 		return deserializeArrayResponse(resp, data, offset)
+	case RespNested, RespPush:
+		arr, _, err := deserializeNested(data, offset, 0)
+		if err != nil {
+			return nil, err
+		}
+		resp.Data = arr
+		return resp, nil
+	case RespMulti:
+		return deserializeMultiResponse(resp, data, offset)
 	}
 
 	return resp, nil
 }
 
+func deserializeMultiResponse(resp *Response, data []byte, offset int) (*Response, error) {
+	count, n := binary.Uvarint(data[offset:])
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid multi response count")
+	}
+	if count > uint64(len(data)) {
+		return nil, fmt.Errorf("multi response count too large")
+	}
+	offset += n
+
+	responses := make([]*Response, 0, count)
+	for i := uint64(0); i < count; i++ {
+		length, size := binary.Uvarint(data[offset:])
+		if size <= 0 || length == 0 || length > uint64(len(data)-offset-size) {
+			return nil, fmt.Errorf("invalid multi response item")
+		}
+		offset += size
+
+		itemData := data[offset : offset+int(length)]
+		if ResponseType(itemData[0]) == RespMulti {
+			return nil, fmt.Errorf("multi responses cannot be nested")
+		}
+		item, err := DeserializeResponse(itemData)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, item)
+		offset += int(length)
+	}
+	resp.Data = responses
+	return resp, nil
+}
+
 func deserializeErrorResponse(resp *Response, data []byte, offset int) (*Response, error) {
 	errorStr, _, err := deserializeString(data, offset, "error")
 	if err != nil {
@@ -415,6 +611,59 @@ func deserializeArrayResponse(resp *Response, data []byte, offset int) (*Respons
 	return resp, nil
 }
 
+func deserializeNested(data []byte, offset, depth int) (arr []interface{}, newOffset int, err error) {
+	if depth >= maxNestingDepth {
+		return nil, 0, fmt.Errorf("nested response too deep")
+	}
+
+	count, n := binary.Uvarint(data[offset:])
+	if n <= 0 {
+		return nil, 0, fmt.Errorf("invalid nested array count")
+	}
+	if count > uint64(len(data)) {
+		return nil, 0, fmt.Errorf("nested array count too large")
+	}
+	offset += n
+
+	arr = make([]interface{}, 0, count)
+	for i := uint64(0); i < count; i++ {
+		if offset >= len(data) {
+			return nil, 0, fmt.Errorf("nested array truncated")
+		}
+		itemType := ResponseType(data[offset])
+		offset++
+
+		switch itemType {
+		case RespNil:
+			arr = append(arr, nil)
+		case RespString:
+			var str string
+			str, offset, err = deserializeString(data, offset, "nested string")
+			if err != nil {
+				return nil, 0, err
+			}
+			arr = append(arr, str)
+		case RespInt:
+			num, size := binary.Varint(data[offset:])
+			if size <= 0 {
+				return nil, 0, fmt.Errorf("invalid integer")
+			}
+			offset += size
+			arr = append(arr, num)
+		case RespNested:
+			var nested []interface{}
+			nested, offset, err = deserializeNested(data, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			arr = append(arr, nested)
+		default:
+			return nil, 0, fmt.Errorf("invalid nested value type: %d", itemType)
+		}
+	}
+	return arr, offset, nil
+}
+
 // ParseTextCommand parses a Redis-style text command into a Command struct.
 // This is useful for debugging, testing, or implementing a text-based interface.
 // Supports basic commands like GET, SET, DEL, EXISTS, INCR, DECR, PING.
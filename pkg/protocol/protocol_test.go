@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// roundTrip serializes resp and deserializes the result.
+func roundTrip(t *testing.T, resp *Response) *Response {
+	t.Helper()
+
+	data, err := resp.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	got, err := DeserializeResponse(data)
+	if err != nil {
+		t.Fatalf("DeserializeResponse failed: %v", err)
+	}
+	return got
+}
+
+func TestNestedResponseRoundTrip(t *testing.T) {
+	entry := []interface{}{"1-0", []interface{}{"type", "signup"}}
+	tests := []struct {
+		name string
+		data []interface{}
+		want []interface{}
+	}{
+		{"empty", []interface{}{}, []interface{}{}},
+		{"scalars", []interface{}{"a", int64(-7), nil, ""}, []interface{}{"a", int64(-7), nil, ""}},
+		{"nested", []interface{}{"s", []interface{}{entry, nil}}, []interface{}{"s", []interface{}{entry, nil}}},
+		{"string slices", []interface{}{[]string{"x", "y"}}, []interface{}{[]interface{}{"x", "y"}}},
+	}
+	for _, tt := range tests {
+		for _, typ := range []ResponseType{RespNested, RespPush} {
+			got := roundTrip(t, &Response{Type: typ, Data: tt.data})
+			if got.Type != typ || !reflect.DeepEqual(got.Data, tt.want) {
+				t.Errorf("%s (type %d): expected %v, got %+v", tt.name, typ, tt.want, got)
+			}
+		}
+	}
+}
+
+func TestMultiResponseRoundTrip(t *testing.T) {
+	responses := []*Response{
+		{Type: RespOK},
+		{Type: RespError, Error: "WRONGTYPE"},
+		{Type: RespString, Data: "value"},
+		{Type: RespInt, Data: int64(42)},
+		{Type: RespArray, Data: []string{"a", "b"}},
+		{Type: RespNil},
+		{Type: RespNested, Data: []interface{}{"k", []interface{}{int64(1), nil}}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteResponse(&buf, &Response{Type: RespMulti, Data: responses}); err != nil {
+		t.Fatalf("WriteResponse failed: %v", err)
+	}
+	got, err := ReadResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if got.Type != RespMulti || !reflect.DeepEqual(got.Data, responses) {
+		t.Errorf("Expected %+v, got %+v", responses, got.Data)
+	}
+
+	empty := roundTrip(t, &Response{Type: RespMulti, Data: []*Response{}})
+	if !reflect.DeepEqual(empty.Data, []*Response{}) {
+		t.Errorf("Expected no responses, got %+v", empty.Data)
+	}
+}
+
+func TestInvalidNestedResponses(t *testing.T) {
+	nested := &Response{Type: RespMulti, Data: []*Response{{Type: RespMulti, Data: []*Response{}}}}
+	if _, err := nested.Serialize(); err == nil {
+		t.Error("Expected nested multi responses to be rejected")
+	}
+	unsupported := &Response{Type: RespNested, Data: []interface{}{3.5}}
+	if _, err := unsupported.Serialize(); err == nil {
+		t.Error("Expected an unsupported nested value to be rejected")
+	}
+
+	deep := []interface{}{}
+	for i := 0; i < maxNestingDepth; i++ {
+		deep = []interface{}{deep}
+	}
+	data, err := (&Response{Type: RespNested, Data: deep}).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	if _, err := DeserializeResponse(data); err == nil || !strings.Contains(err.Error(), "too deep") {
+		t.Errorf("Expected a too deep response to be rejected, got %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"push item", []byte{byte(RespNested), 1, byte(RespPush), 0}},
+		{"error item", []byte{byte(RespNested), 1, byte(RespError), 0}},
+		{"truncated", []byte{byte(RespPush), 2, byte(RespNil)}},
+		{"nested multi", []byte{byte(RespMulti), 1, 2, byte(RespMulti), 0}},
+	}
+	for _, tt := range tests {
+		if _, err := DeserializeResponse(tt.data); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
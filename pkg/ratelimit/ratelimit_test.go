@@ -0,0 +1,206 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cachemir/cachemir/internal/server"
+	"github.com/cachemir/cachemir/pkg/client"
+	"github.com/cachemir/cachemir/pkg/config"
+)
+
+// tolerance bounds the time elapsed between two requests of a test. Limits
+// use periods of an hour, so a request denied by one stays denied for much
+// longer than that.
+const tolerance = time.Second
+
+// newClient starts a server on a free local port and returns a client for
+// it. Both are stopped when the test ends.
+func newClient(t *testing.T) *client.Client {
+	t.Helper()
+
+	lc := net.ListenConfig{}
+	l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port //nolint:errcheck // TCP listeners have TCP addresses
+	if err := l.Close(); err != nil {
+		t.Fatalf("Failed to free port: %v", err)
+	}
+
+	s := server.New(port)
+	go func() {
+		if err := s.Start(); err != nil {
+			t.Errorf("Server failed: %v", err)
+		}
+	}()
+	t.Cleanup(func() { s.Stop() }) //nolint:errcheck,gosec // Nothing to do on failure
+
+	cfg := config.LoadClientConfig()
+	cfg.Nodes = []string{fmt.Sprintf("127.0.0.1:%d", port)}
+	cfg.ConnTimeout = 1
+	cfg.ReadTimeout = 2
+	cfg.WriteTimeout = 2
+	c := client.NewWithConfig(cfg)
+	t.Cleanup(func() { c.Close() }) //nolint:errcheck,gosec // Nothing to do on failure
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Ping() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return c
+}
+
+// expectResult fails the test unless got matches want, with durations
+// within tolerance of want's.
+func expectResult(t *testing.T, what string, got, want Result) {
+	t.Helper()
+
+	near := func(d, target time.Duration) bool {
+		return d <= target && d > target-tolerance
+	}
+	if got.Allowed != want.Allowed || got.Limit != want.Limit || got.Remaining != want.Remaining ||
+		!near(got.RetryAfter, want.RetryAfter) && got.RetryAfter != want.RetryAfter ||
+		!near(got.ResetAfter, want.ResetAfter) && got.ResetAfter != want.ResetAfter {
+		t.Errorf("%s: expected %+v, got %+v", what, want, got)
+	}
+}
+
+func TestGCRAWindowBoundary(t *testing.T) {
+	limiter := NewGCRA(newClient(t), PerHour(3)) // One request every 20 minutes, bursts of 3
+	const interval = 20 * time.Minute
+
+	result, err := limiter.Peek("alice")
+	if err != nil {
+		t.Fatalf("Peek failed: %v", err)
+	}
+	expectResult(t, "peek", result, Result{Allowed: true, Limit: 3, Remaining: 3})
+
+	// The burst is allowed, each request pushing the reset back.
+	for i := int64(1); i <= 3; i++ {
+		result, err := limiter.Allow("alice")
+		if err != nil {
+			t.Fatalf("Allow failed: %v", err)
+		}
+		want := Result{Allowed: true, Limit: 3, Remaining: 3 - i, ResetAfter: time.Duration(i) * interval}
+		expectResult(t, fmt.Sprintf("request %d", i), result, want)
+	}
+
+	// The next one is denied until an interval has passed, and isn't counted.
+	for i := 0; i < 2; i++ {
+		result, err = limiter.Allow("alice")
+		if err != nil {
+			t.Fatalf("Allow failed: %v", err)
+		}
+		expectResult(t, "request past the burst", result,
+			Result{Limit: 3, RetryAfter: interval, ResetAfter: 3 * interval})
+	}
+
+	// Other keys have their own limit.
+	if result, err := limiter.Allow("bob"); err != nil || !result.Allowed || result.Remaining != 2 {
+		t.Errorf("Expected another key to be allowed, got %+v (%v)", result, err)
+	}
+
+	// A request larger than the burst is never allowed.
+	result, err = limiter.AllowN("carol", 4)
+	if err != nil {
+		t.Fatalf("AllowN failed: %v", err)
+	}
+	if result.Allowed || result.RetryAfter != -1 {
+		t.Errorf("Expected a request above the burst to never be allowed, got %+v", result)
+	}
+}
+
+func TestTokenBucketWindowBoundary(t *testing.T) {
+	limiter := NewTokenBucket(newClient(t), Limit{Burst: 5, Count: 1, Period: time.Hour})
+
+	result, err := limiter.AllowN("upload", 3)
+	if err != nil {
+		t.Fatalf("AllowN failed: %v", err)
+	}
+	expectResult(t, "first request", result, Result{Allowed: true, Limit: 5, Remaining: 2, ResetAfter: 3 * time.Hour})
+
+	// Three more tokens are one short; the bucket is left as it was.
+	result, err = limiter.AllowN("upload", 3)
+	if err != nil {
+		t.Fatalf("AllowN failed: %v", err)
+	}
+	expectResult(t, "request past the tokens", result,
+		Result{Limit: 5, Remaining: 2, RetryAfter: time.Hour, ResetAfter: 3 * time.Hour})
+
+	// The last two tokens are still there.
+	result, err = limiter.AllowN("upload", 2)
+	if err != nil {
+		t.Fatalf("AllowN failed: %v", err)
+	}
+	expectResult(t, "last tokens", result, Result{Allowed: true, Limit: 5, ResetAfter: 5 * time.Hour})
+
+	if result, err := limiter.AllowN("upload", 6); err != nil || result.RetryAfter != -1 {
+		t.Errorf("Expected a request above the capacity to never be allowed, got %+v (%v)", result, err)
+	}
+}
+
+func TestLimitReopensAfterRetryAfter(t *testing.T) {
+	for _, algorithm := range []Algorithm{GCRA, TokenBucket} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			limiter := New(newClient(t), algorithm, Limit{Burst: 1, Count: 1, Period: 100 * time.Millisecond})
+
+			if result, err := limiter.Allow("key"); err != nil || !result.Allowed {
+				t.Fatalf("Expected the first request to be allowed, got %+v (%v)", result, err)
+			}
+			result, err := limiter.Allow("key")
+			if err != nil || result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > 100*time.Millisecond {
+				t.Fatalf("Expected a denial for up to 100ms, got %+v (%v)", result, err)
+			}
+
+			// Waiting exactly RetryAfter is enough.
+			time.Sleep(result.RetryAfter)
+			if result, err := limiter.Allow("key"); err != nil || !result.Allowed {
+				t.Errorf("Expected a request after RetryAfter to be allowed, got %+v (%v)", result, err)
+			}
+		})
+	}
+}
+
+func TestResetPrefixAndWait(t *testing.T) {
+	c := newClient(t)
+	limiter := NewGCRA(c, PerHour(1))
+
+	if result, err := limiter.Allow("key"); err != nil || !result.Allowed {
+		t.Fatalf("Expected the first request to be allowed, got %+v (%v)", result, err)
+	}
+	if _, err := c.Get(DefaultPrefix + "key"); err != nil {
+		t.Errorf("Expected the state under %q, got %v", DefaultPrefix+"key", err)
+	}
+
+	// Limiters with another prefix don't share the state.
+	if result, err := limiter.WithPrefix("other:").Allow("key"); err != nil || !result.Allowed {
+		t.Errorf("Expected a limiter with another prefix to be allowed, got %+v (%v)", result, err)
+	}
+
+	// Wait gives up with the context.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Wait to end with its context, got %v", err)
+	}
+	if err := limiter.WaitN(context.Background(), "key", 2); err == nil {
+		t.Error("Expected WaitN above the burst to fail")
+	}
+
+	// Reset allows a full burst again.
+	if err := limiter.Reset("key"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if err := limiter.Wait(context.Background(), "key"); err != nil {
+		t.Errorf("Expected Wait to succeed after Reset, got %v", err)
+	}
+}